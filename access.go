@@ -0,0 +1,77 @@
+package sftp
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/pkg/sftp/apis"
+)
+
+// accessExtension asks the server whether it may access a path, like access(2).
+const accessExtension = "access@github.com/pkg/sftp"
+
+// AccessMode selects what Client.Access checks, like the mode of access(2).
+type AccessMode uint32
+
+// The access modes, which can be combined. AccessExists only checks that
+// the path exists.
+const (
+	AccessExists  AccessMode = 0
+	AccessExecute AccessMode = 1
+	AccessWrite   AccessMode = 2
+	AccessRead    AccessMode = 4
+)
+
+// Access checks whether the server may access path with mode, so that
+// applications can find out whether they can write a file, say, before
+// starting a large upload. It returns nil if so, an error matching
+// fs.ErrPermission if not, and one matching fs.ErrNotExist if there is no
+// such file.
+//
+// If the server supports the access@github.com/pkg/sftp extension, as the
+// Server of this package does when the file system is the host's, the server
+// checks the access itself. Otherwise Access stats path and checks its
+// permission bits, which is merely a hint: not knowing who the server acts
+// as, it reports a permission as granted if the owner, group or others have
+// it. Access does not check for read-only file systems and the like either.
+func (c *Client) Access(path string, mode AccessMode) (err error) {
+	defer pathError(&err, "access", path)
+
+	if _, ok := c.HasExtension(accessExtension); ok {
+		id := c.nextID()
+		err := c.sendStatusPacket(id, &sshFxpAccessPacket{
+			ID:   id,
+			Path: path,
+			Mode: uint32(mode),
+		})
+		if status, ok := err.(*StatusError); !ok || status.FxCode() != ErrSSHFxOpUnsupported {
+			return err
+		}
+	}
+
+	fi, err := c.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	// the permission bits of owner, group and others, folded into one
+	perm := fi.Mode().Perm()
+	granted := AccessMode(perm>>6|perm>>3|perm) & (AccessRead | AccessWrite | AccessExecute)
+	if mode&^granted != 0 {
+		return &fs.PathError{Op: "access", Path: path, Err: fs.ErrPermission}
+	}
+	return nil
+}
+
+func (p *sshFxpExtendedPacketAccess) respond(svr *Server) responsePacket {
+	accesser, ok := svr.fs.(apis.Accesser)
+	if !ok {
+		return statusFromError(p.ID, ErrSSHFxOpUnsupported)
+	}
+
+	err := accesser.Access(toLocalPath(p.Path), p.Mode)
+	if errors.Is(err, apis.ErrAccessUnsupported) {
+		err = ErrSSHFxOpUnsupported
+	}
+	return statusFromError(p.ID, err)
+}
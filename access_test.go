@@ -0,0 +1,46 @@
+package sftp
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientAccess(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	_, ok := client.HasExtension(accessExtension)
+	require.True(t, ok, "server doesn't list access extension")
+
+	dir := t.TempDir()
+	name := path.Join(dir, "file")
+	require.NoError(t, os.WriteFile(name, nil, 0o644))
+
+	assert.NoError(t, client.Access(name, AccessExists))
+	assert.NoError(t, client.Access(name, AccessRead|AccessWrite))
+	assert.True(t, errors.Is(client.Access(name, AccessExecute), fs.ErrPermission))
+	assert.NoError(t, client.Access(dir, AccessWrite|AccessExecute))
+	assert.True(t, errors.Is(client.Access(path.Join(dir, "missing"), AccessExists), fs.ErrNotExist))
+}
+
+func TestClientAccessFallback(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+
+	// the handlers cannot check the access, so the client checks the permissions
+	f, err := p.cli.Create("/file")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// the in-memory files are all 0644
+	assert.NoError(t, p.cli.Access("/file", AccessRead|AccessWrite))
+	assert.True(t, errors.Is(p.cli.Access("/file", AccessRead|AccessExecute), fs.ErrPermission))
+	assert.Error(t, p.cli.Access("/missing", AccessExists))
+}
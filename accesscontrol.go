@@ -0,0 +1,159 @@
+package sftp
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// Operation is what a request asks a Server to do with a file, named like
+// the Method of the Request a RequestServer passes to its handlers.
+type Operation string
+
+// The operations checked by WithAccessControl.
+const (
+	OpGet         Operation = "Get"  // open for reading
+	OpPut         Operation = "Put"  // open for writing
+	OpOpen        Operation = "Open" // open for reading and writing
+	OpList        Operation = "List"
+	OpStat        Operation = "Stat"
+	OpLstat       Operation = "Lstat"
+	OpReadlink    Operation = "Readlink"
+	OpSetstat     Operation = "Setstat"
+	OpRemove      Operation = "Remove"
+	OpRmdir       Operation = "Rmdir"
+	OpMkdir       Operation = "Mkdir"
+	OpRename      Operation = "Rename"
+	OpPosixRename Operation = "PosixRename"
+	OpSymlink     Operation = "Symlink"
+	OpLink        Operation = "Link"
+	OpStatVFS     Operation = "StatVFS"
+	OpDirStats    Operation = "DirStats"
+	OpDiskUsage   Operation = "DiskUsage"
+)
+
+// WithAccessControl has the Server ask allow before every operation reading
+// or changing a file, so that it can enforce the permissions of the user,
+// like read-only directories or no removals, beyond those of the file
+// system. It returns nil to go on, or the error to answer the request with;
+// fs.ErrPermission is sent as SSH_FX_PERMISSION_DENIED.
+//
+// The path is the one of the file system, below the root directory if set
+// with WithRootDirectory. Flags are the SSH_FXF_* open flags of OpGet, OpPut
+// and OpOpen, and the SSH_FILEXFER_ATTR_* flags of the attributes OpSetstat
+// changes, 0 otherwise. Renames and links are checked for both paths, the
+// target of a symbolic link is not checked.
+//
+// Reads and writes of an open handle are allowed by the check of the open,
+// as are Fstat and the copy-data and allocate extensions. Fsetstat is
+// checked as OpSetstat with the path the handle was opened with, the
+// check-file extension as OpGet, the access and getacl extensions as
+// OpStat, the setacl extension as OpSetstat with the SSH_FILEXFER_ATTR_ACL
+// flag and the space-available extension as OpStatVFS. The glob extension is checked as
+// the OpStat, OpLstat and OpList of every lookup it makes, which fail
+// quietly like the errors of Glob. Realpath and expand-path requests are
+// not checked.
+//
+// The function is called concurrently.
+func WithAccessControl(allow func(op Operation, path string, flags uint32) error) ServerOption {
+	return func(s *Server) error {
+		s.accessControl = allow
+		return nil
+	}
+}
+
+// checkAccess asks the path policy and the access control of the Server
+// about p, and returns the response to deny it with, if so.
+func (svr *Server) checkAccess(p requestPacket) responsePacket {
+	if err := svr.allowed(p); err != nil {
+		status := statusFromError(p.id(), err)
+		if status.Code == sshFxFailure && errors.Is(err, fs.ErrPermission) {
+			status.Code = sshFxPermissionDenied
+		}
+		return status
+	}
+	return nil
+}
+
+// allowed returns the error of the access control for the operations of p.
+func (svr *Server) allowed(p requestPacket) error {
+	switch p := p.(type) {
+	case *sshFxpExtendedPacket:
+		if p.SpecificPacket == nil {
+			return nil
+		}
+		return svr.allowed(p.SpecificPacket)
+
+	case *sshFxpOpenPacket:
+		return svr.access(Operation(openMethod(p.Pflags)), p.Path, p.Pflags)
+	case *sshFxpOpendirPacket:
+		return svr.access(OpList, p.Path, 0)
+	case *sshFxpStatPacket:
+		return svr.access(OpStat, p.Path, 0)
+	case *sshFxpLstatPacket:
+		return svr.access(OpLstat, p.Path, 0)
+	case *sshFxpReadlinkPacket:
+		return svr.access(OpReadlink, p.Path, 0)
+	case *sshFxpSetstatPacket:
+		return svr.access(OpSetstat, p.Path, p.Flags)
+	case *sshFxpFsetstatPacket:
+		f, ok := svr.getHandle(p.Handle)
+		if !ok {
+			// answered with EBADF anyway
+			return nil
+		}
+		return svr.access(OpSetstat, f.Name(), p.Flags)
+	case *sshFxpRemovePacket:
+		return svr.access(OpRemove, p.Filename, 0)
+	case *sshFxpRmdirPacket:
+		return svr.access(OpRmdir, p.Path, 0)
+	case *sshFxpMkdirPacket:
+		return svr.access(OpMkdir, p.Path, 0)
+	case *sshFxpSymlinkPacket:
+		return svr.access(OpSymlink, p.Linkpath, 0)
+	case *sshFxpRenamePacket:
+		return svr.accessBoth(OpRename, p.Oldpath, p.Newpath)
+	case *sshFxpExtendedPacketPosixRename:
+		return svr.accessBoth(OpPosixRename, p.Oldpath, p.Newpath)
+	case *sshFxpExtendedPacketHardlink:
+		return svr.accessBoth(OpLink, p.Oldpath, p.Newpath)
+	case *sshFxpExtendedPacketStatVFS:
+		return svr.access(OpStatVFS, p.Path, 0)
+	case *sshFxpExtendedPacketSpaceAvailable:
+		return svr.access(OpStatVFS, p.Path, 0)
+	case *sshFxpExtendedPacketCheckFile:
+		if p.Handle != "" {
+			return nil
+		}
+		return svr.access(OpGet, p.Path, sshFxfRead)
+	case *sshFxpExtendedPacketDirStats:
+		return svr.access(OpDirStats, p.Path, 0)
+	case *sshFxpExtendedPacketDiskUsage:
+		return svr.access(OpDiskUsage, p.Path, 0)
+	case *sshFxpExtendedPacketAccess:
+		return svr.access(OpStat, p.Path, 0)
+	case *sshFxpExtendedPacketGetACL:
+		return svr.access(OpStat, p.Path, 0)
+	case *sshFxpExtendedPacketSetACL:
+		return svr.access(OpSetstat, p.Path, sshFileXferAttrACL)
+	}
+	return nil
+}
+
+// access checks op on path with the path policy and the access control.
+func (svr *Server) access(op Operation, path string, flags uint32) error {
+	if err := svr.checkPathPolicy(op, path); err != nil {
+		return err
+	}
+	if svr.accessControl != nil {
+		return svr.accessControl(op, path, flags)
+	}
+	return nil
+}
+
+// accessBoth checks op for the paths from and to.
+func (svr *Server) accessBoth(op Operation, from, to string) error {
+	if err := svr.access(op, from, 0); err != nil {
+		return err
+	}
+	return svr.access(op, to, 0)
+}
@@ -0,0 +1,69 @@
+package sftp
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerAccessControl(t *testing.T) {
+	dir := t.TempDir()
+	ro := filepath.Join(dir, "ro")
+	require.NoError(t, os.Mkdir(ro, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(ro, "file"), []byte("hello"), 0o644))
+
+	var mu sync.Mutex
+	checked := make(map[Operation][]string)
+	allow := func(op Operation, path string, flags uint32) error {
+		mu.Lock()
+		checked[op] = append(checked[op], path)
+		mu.Unlock()
+
+		switch op {
+		case OpGet, OpList, OpStat, OpLstat:
+			return nil
+		}
+		if op == OpRemove || strings.HasPrefix(path, ro) {
+			return fs.ErrPermission
+		}
+		return nil
+	}
+
+	client, server := clientServerPair(t, WithAccessControl(allow))
+	defer client.Close()
+	defer server.Close()
+
+	f, err := client.Open(filepath.Join(ro, "file"))
+	require.NoError(t, err, "reading is allowed")
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	assert.ErrorIs(t, f.Chmod(0o600), fs.ErrPermission, "fsetstat is checked with the path")
+	require.NoError(t, f.Close())
+
+	_, err = client.Create(filepath.Join(ro, "new"))
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	assert.ErrorIs(t, client.Mkdir(filepath.Join(ro, "dir")), fs.ErrPermission)
+
+	rw := filepath.Join(dir, "rw")
+	f, err = client.Create(rw)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.ErrorIs(t, client.Rename(rw, filepath.Join(ro, "moved")), fs.ErrPermission, "the target is checked")
+	assert.ErrorIs(t, client.Remove(rw), fs.ErrPermission)
+	_, err = os.Stat(rw)
+	assert.NoError(t, err, "the file is not removed")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{filepath.Join(ro, "file")}, checked[OpGet])
+	assert.Equal(t, []string{rw, filepath.Join(ro, "moved")}, checked[OpRename])
+	assert.Equal(t, []string{filepath.Join(ro, "file")}, checked[OpSetstat])
+}
@@ -0,0 +1,166 @@
+package sftp
+
+import (
+	"github.com/pkg/sftp/apis"
+)
+
+// getACLExtension and setACLExtension get and set the access control list
+// of a path, which protocol version 3 has no attribute for.
+const (
+	getACLExtension = "getacl@github.com/pkg/sftp"
+	setACLExtension = "setacl@github.com/pkg/sftp"
+)
+
+// GetACL returns the access control list of path, the NFSv4 style list of
+// SFTP protocol version 4 and later, which Windows servers and those on file
+// systems like ZFS or NFSv4 keep.
+//
+// GetACL uses the getacl@github.com/pkg/sftp extension, which the Server of
+// this package supports when its file system implements apis.ACLer. Servers
+// without it are asked for the acl attribute of path instead, if the
+// negotiated protocol version is 4 or later, see WithProtocolVersion.
+// It returns an error matching ErrOpUnsupported if the server has no
+// access control lists.
+func (c *Client) GetACL(path string) (_ []ACE, err error) {
+	defer pathError(&err, "getacl", path)
+
+	if _, ok := c.HasExtension(getACLExtension); !ok {
+		if c.version >= 4 {
+			return c.getACLAttr(path)
+		}
+		return nil, ErrSSHFxOpUnsupported
+	}
+
+	id := c.nextID()
+	typ, data, err := c.sendPacket(nil, &sshFxpGetACLPacket{
+		ID:   id,
+		Path: path,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case sshFxpExtendedReply:
+		sid, data, err := unmarshalUint32Safe(data)
+		if err != nil {
+			return nil, err
+		}
+		if sid != id {
+			return nil, &unexpectedIDErr{id, sid}
+		}
+		acl, _, err := unmarshalACLSafe(data)
+		return acl, err
+
+	case sshFxpStatus:
+		return nil, normaliseError(unmarshalStatus(id, data))
+
+	default:
+		return nil, unimplementedPacketErr(typ)
+	}
+}
+
+// getACLAttr stats path for the acl attribute only.
+func (c *Client) getACLAttr(path string) ([]ACE, error) {
+	id := c.nextID()
+	typ, data, err := c.sendPacket(nil, trailerPacket{&sshFxpStatPacket{
+		ID:   id,
+		Path: path,
+	}, marshalUint32(nil, sshFileXferAttrACL)})
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case sshFxpAttrs:
+		sid, data := unmarshalUint32(data)
+		if sid != id {
+			return nil, &unexpectedIDErr{id, sid}
+		}
+		// servers leave out the attributes they do not have
+		if flags, _, _ := unmarshalUint32Safe(data); flags&sshFileXferAttrACL == 0 {
+			return nil, ErrSSHFxOpUnsupported
+		}
+		attr, _ := c.unmarshalAttrs(data)
+		return attr.ACL, nil
+
+	case sshFxpStatus:
+		return nil, normaliseError(unmarshalStatus(id, data))
+
+	default:
+		return nil, unimplementedPacketErr(typ)
+	}
+}
+
+// SetACL replaces the access control list of path with acl, see GetACL.
+// With protocol version 6 the list controls access rather than only
+// auditing it.
+func (c *Client) SetACL(path string, acl []ACE) (err error) {
+	defer pathError(&err, "setacl", path)
+
+	if _, ok := c.HasExtension(setACLExtension); !ok {
+		if c.version >= 4 {
+			attr := marshalACL(nil, c.version, sfxACLControlIncluded|sfxACLControlPresent, acl)
+			return c.setstat(path, sshFileXferAttrACL, string(attr))
+		}
+		return ErrSSHFxOpUnsupported
+	}
+
+	id := c.nextID()
+	return c.sendStatusPacket(id, &sshFxpSetACLPacket{
+		ID:   id,
+		Path: path,
+		ACL:  acl,
+	})
+}
+
+// unmarshalACLSafe decodes an access control list of version 4,
+// failing on a short one rather than truncating it.
+func unmarshalACLSafe(b []byte) ([]ACE, []byte, error) {
+	count, b, err := unmarshalUint32Safe(b)
+	if err != nil {
+		return nil, b, err
+	}
+
+	var acl []ACE
+	for i := uint32(0); i < count; i++ {
+		var ace ACE
+		if ace.Type, b, err = unmarshalUint32Safe(b); err != nil {
+			return nil, b, err
+		} else if ace.Flag, b, err = unmarshalUint32Safe(b); err != nil {
+			return nil, b, err
+		} else if ace.Mask, b, err = unmarshalUint32Safe(b); err != nil {
+			return nil, b, err
+		} else if ace.Who, b, err = unmarshalStringSafe(b); err != nil {
+			return nil, b, err
+		}
+		acl = append(acl, ace)
+	}
+	return acl, b, nil
+}
+
+// sshFxpGetACLReply is the SSH_FXP_EXTENDED_REPLY sent for getacl requests.
+type sshFxpGetACLReply struct {
+	ID  uint32
+	ACL []ACE
+}
+
+func (p *sshFxpGetACLReply) id() uint32 { return p.ID }
+
+func (p *sshFxpGetACLReply) MarshalBinary() ([]byte, error) {
+	b := []byte{0, 0, 0, 0, sshFxpExtendedReply}
+	b = marshalUint32(b, p.ID)
+	return marshalACL(b, 4, 0, p.ACL), nil
+}
+
+func (p *sshFxpExtendedPacketGetACL) respond(svr *Server) responsePacket {
+	acl, err := apis.GetACL(svr.fs, toLocalPath(p.Path))
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+	return &sshFxpGetACLReply{ID: p.ID, ACL: acl}
+}
+
+func (p *sshFxpExtendedPacketSetACL) respond(svr *Server) responsePacket {
+	return statusFromError(p.ID, apis.SetACL(svr.fs, toLocalPath(p.Path), p.ACL))
+}
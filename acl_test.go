@@ -0,0 +1,141 @@
+package sftp
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// aclFs keeps the access control lists of the files in memory.
+type aclFs struct {
+	apis.FullFs
+	mu   sync.Mutex
+	acls map[string][]ACE
+}
+
+func (fsys *aclFs) GetACL(name string) ([]ACE, error) {
+	if _, err := fsys.Stat(name); err != nil {
+		return nil, err
+	}
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	return fsys.acls[name], nil
+}
+
+func (fsys *aclFs) SetACL(name string, acl []ACE) error {
+	if _, err := fsys.Stat(name); err != nil {
+		return err
+	}
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	fsys.acls[name] = acl
+	return nil
+}
+
+// fsClient serves fsys to a client.
+func fsClient(t *testing.T, fsys apis.Fs) *Client {
+	client, server := clientServerPairFS(t, fsys, nil)
+	t.Cleanup(func() { client.Close() })
+	t.Cleanup(func() { server.Close() })
+	return client
+}
+
+func TestClientACL(t *testing.T) {
+	client := fsClient(t, &aclFs{FullFs: apis.NewAVFS(), acls: map[string][]ACE{}})
+
+	_, ok := client.HasExtension(getACLExtension)
+	require.True(t, ok, "server doesn't list getacl extension")
+
+	name := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(name, nil, 0o644))
+
+	acl, err := client.GetACL(name)
+	require.NoError(t, err)
+	assert.Empty(t, acl)
+
+	want := []ACE{
+		{Type: 0, Mask: ace4ReadData | ace4WriteData, Who: "OWNER@"},
+		{Type: 1, Flag: 0x40, Mask: ace4WriteData, Who: "EVERYONE@"},
+	}
+	require.NoError(t, client.SetACL(name, want))
+	acl, err = client.GetACL(name)
+	require.NoError(t, err)
+	assert.Equal(t, want, acl)
+
+	missing := filepath.Join(filepath.Dir(name), "missing")
+	_, err = client.GetACL(missing)
+	assert.True(t, errors.Is(err, ErrNotExist), "got %v", err)
+	var pathErr *fs.PathError
+	require.True(t, errors.As(client.SetACL(missing, want), &pathErr))
+	assert.Equal(t, "setacl", pathErr.Op)
+}
+
+func TestClientACLUnsupported(t *testing.T) {
+	client := fsClient(t, apis.NewAVFS())
+
+	name := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(name, nil, 0o644))
+
+	_, err := client.GetACL(name)
+	assert.True(t, errors.Is(err, ErrOpUnsupported), "got %v", err)
+	assert.True(t, errors.Is(client.SetACL(name, nil), ErrOpUnsupported))
+
+	p := clientRequestServerPair(t)
+	defer p.Close()
+	_, err = p.cli.GetACL("/file")
+	assert.True(t, errors.Is(err, ErrOpUnsupported), "got %v", err)
+}
+
+func TestClientACLV6(t *testing.T) {
+	acl := []ACE{{Type: 1, Flag: 2, Mask: 3, Who: "someone"}}
+	client, requests := fakeServer(t, 6, func(typ byte, id uint32) rawPacket {
+		if typ == sshFxpStat {
+			b := marshalUint32([]byte{sshFxpAttrs}, id)
+			b = marshalUint32(b, sshFileXferAttrACL)
+			b = append(b, sshFileXferTypeRegular)
+			return marshalString(b, string(marshalACL(nil, 6, sfxACLControlPresent, acl)))
+		}
+		return statusOK(id)
+	})
+	defer client.Close()
+
+	got, err := client.GetACL("/file")
+	require.NoError(t, err)
+	assert.Equal(t, acl, got)
+	req := <-requests
+	assert.Equal(t, byte(sshFxpStat), req.typ)
+	_, data := unmarshalUint32(req.data)
+	_, data = unmarshalString(data)
+	assert.Equal(t, marshalUint32(nil, sshFileXferAttrACL), data)
+
+	require.NoError(t, client.SetACL("/file", acl))
+	req = <-requests
+	assert.Equal(t, byte(sshFxpSetstat), req.typ)
+	_, data = unmarshalUint32(req.data)
+	_, data = unmarshalString(data)
+	want := marshalUint32(nil, sshFileXferAttrACL)
+	want = append(want, sshFileXferTypeUnknown)
+	want = marshalString(want, string(marshalACL(nil, 6, sfxACLControlIncluded|sfxACLControlPresent, acl)))
+	assert.Equal(t, want, data)
+}
+
+func TestClientACLV4Missing(t *testing.T) {
+	client, _ := fakeServer(t, 4, func(typ byte, id uint32) rawPacket {
+		// a server without access control lists leaves the attribute out
+		b := marshalUint32([]byte{sshFxpAttrs}, id)
+		b = marshalUint32(b, 0)
+		return append(b, sshFileXferTypeRegular)
+	})
+	defer client.Close()
+
+	_, err := client.GetACL("/file")
+	assert.True(t, errors.Is(err, ErrOpUnsupported), "got %v", err)
+}
@@ -37,9 +37,9 @@ func (a *allocator) GetPage(requestOrderID uint32) []byte {
 		a.available = a.available[:truncLength] // truncate the slice
 	}
 
-	// no preallocated slice found, just allocate a new one
+	// no preallocated slice found, take one from the pages shared with other sessions
 	if result == nil {
-		result = make([]byte, maxMsgLength)
+		result = getPage()
 	}
 
 	// put result in used pages
@@ -59,12 +59,25 @@ func (a *allocator) ReleasePages(requestOrderID uint32) {
 	delete(a.used, requestOrderID)
 }
 
-// Free removes all the used and available pages.
+// ForgetPages drops the pages in use for the given requestID without reusing them,
+// they may still be in use after the request was answered
+func (a *allocator) ForgetPages(requestOrderID uint32) {
+	a.Lock()
+	defer a.Unlock()
+
+	delete(a.used, requestOrderID)
+}
+
+// Free removes all the used and available pages, the available ones go back
+// to the pagePool for other sessions to use.
 // Call this method when the allocator is not needed anymore
 func (a *allocator) Free() {
 	a.Lock()
 	defer a.Unlock()
 
+	for _, page := range a.available {
+		putPage(page)
+	}
 	a.available = nil
 	a.used = make(map[uint32][][]byte)
 }
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package apis
+
+func access(name string, mode uint32) error {
+	return ErrAccessUnsupported
+}
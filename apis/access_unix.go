@@ -0,0 +1,12 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package apis
+
+import (
+	"syscall"
+)
+
+func access(name string, mode uint32) error {
+	return syscall.Access(name, mode)
+}
@@ -0,0 +1,193 @@
+// Package aferofs adapts an afero.Fs to the apis.Fs sftp.NewServer serves.
+//
+//	server, err := sftp.NewServer(channel, aferofs.New(afero.NewMemMapFs()),
+//		sftp.WithRootDirectory("/"))
+//
+// Symbolic links are supported as far as the afero.Fs supports them, hard
+// links are not.
+package aferofs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/pkg/sftp"
+	"github.com/pkg/sftp/apis"
+)
+
+// FS is an apis.Fs serving an afero.Fs, see New.
+type FS struct {
+	fs afero.Fs
+}
+
+// New returns an apis.Fs serving fsys.
+func New(fsys afero.Fs) *FS {
+	return &FS{fs: fsys}
+}
+
+func (api *FS) Chtimes(name string, atime, mtime time.Time) error {
+	return api.fs.Chtimes(name, atime, mtime)
+}
+
+func (api *FS) Chmod(name string, mode os.FileMode) error {
+	return api.fs.Chmod(name, mode)
+}
+
+func (api *FS) Chown(name string, uid, gid int) error {
+	return api.fs.Chown(name, uid, gid)
+}
+
+func (api *FS) Mkdir(name string, perm os.FileMode) error {
+	return api.fs.Mkdir(name, perm)
+}
+
+// Lstat is Stat, unless the afero.Fs implements afero.Lstater.
+func (api *FS) Lstat(name string) (fs.FileInfo, error) {
+	if lstater, ok := api.fs.(afero.Lstater); ok {
+		fi, _, err := lstater.LstatIfPossible(name)
+		return fi, err
+	}
+	return api.fs.Stat(name)
+}
+
+func (api *FS) OpenFile(name string, flag int, perm os.FileMode) (apis.File, error) {
+	return api.wrapFile(api.fs.OpenFile(name, flag, perm))
+}
+
+func (api *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := api.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := readDir(f, -1)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, err
+}
+
+// Readlink fails, unless the afero.Fs implements afero.LinkReader.
+func (api *FS) Readlink(name string) (string, error) {
+	if reader, ok := api.fs.(afero.LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: afero.ErrNoReadlink}
+}
+
+func (api *FS) Remove(name string) error {
+	return api.fs.Remove(name)
+}
+
+func (api *FS) Rename(oldpath, newpath string) error {
+	return api.fs.Rename(oldpath, newpath)
+}
+
+func (api *FS) Stat(name string) (fs.FileInfo, error) {
+	return api.fs.Stat(name)
+}
+
+// Symlink fails, unless the afero.Fs implements afero.Linker.
+func (api *FS) Symlink(oldname, newname string) error {
+	if linker, ok := api.fs.(afero.Linker); ok {
+		return linker.SymlinkIfPossible(oldname, newname)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: afero.ErrNoSymlink}
+}
+
+func (api *FS) Truncate(name string, size int64) error {
+	f, err := api.fs.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (api *FS) Open(name string) (apis.File, error) {
+	return api.wrapFile(api.fs.Open(name))
+}
+
+func (api *FS) RemoveAll(path string) error {
+	return api.fs.RemoveAll(path)
+}
+
+func (api *FS) Create(name string) (apis.File, error) {
+	return api.wrapFile(api.fs.Create(name))
+}
+
+// TempDir returns os.TempDir(), as afero.TempDir does.
+func (api *FS) TempDir() string {
+	return os.TempDir()
+}
+
+// Link fails, afero has no hard links.
+func (api *FS) Link(oldname string, newname string) error {
+	return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: sftp.ErrSSHFxOpUnsupported}
+}
+
+// CanReadLinks reports whether the afero.Fs implements afero.LinkReader.
+func (api *FS) CanReadLinks() bool {
+	_, ok := api.fs.(afero.LinkReader)
+	return ok
+}
+
+// CanWriteLinks reports whether the afero.Fs implements afero.Linker.
+func (api *FS) CanWriteLinks() bool {
+	_, ok := api.fs.(afero.Linker)
+	return ok
+}
+
+// file is an open afero.File.
+type file struct {
+	afero.File
+	fs afero.Fs
+}
+
+func (api *FS) wrapFile(f afero.File, err error) (apis.File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return file{File: f, fs: api.fs}, nil
+}
+
+func (f file) Chmod(mode fs.FileMode) error {
+	return f.fs.Chmod(f.Name(), mode)
+}
+
+func (f file) Chown(uid, gid int) error {
+	return f.fs.Chown(f.Name(), uid, gid)
+}
+
+func (f file) ReadDir(n int) ([]fs.DirEntry, error) {
+	return readDir(f.File, n)
+}
+
+// readDir reads the entries of the directory f, like os.File.ReadDir.
+func readDir(f afero.File, n int) ([]fs.DirEntry, error) {
+	infos, err := f.Readdir(n)
+	entries := make([]fs.DirEntry, len(infos))
+	for i, fi := range infos {
+		entries[i] = dirEntry{fi}
+	}
+	if n <= 0 && err == io.EOF {
+		err = nil
+	}
+	return entries, err
+}
+
+// dirEntry is a fs.DirEntry of a resolved fs.FileInfo.
+type dirEntry struct {
+	fs.FileInfo
+}
+
+func (e dirEntry) Type() fs.FileMode { return e.Mode().Type() }
+
+func (e dirEntry) Info() (fs.FileInfo, error) { return e.FileInfo, nil }
@@ -0,0 +1,82 @@
+package aferofs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pkg/sftp"
+	"github.com/pkg/sftp/apis"
+)
+
+var (
+	_ apis.FullFs     = (*FS)(nil)
+	_ apis.LinkReader = (*FS)(nil)
+	_ apis.LinkWriter = (*FS)(nil)
+)
+
+func TestFS(t *testing.T) {
+	mem := afero.NewMemMapFs()
+
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw}, New(mem), sftp.WithRootDirectory("/"))
+	require.NoError(t, err)
+	go func() {
+		server.Serve()
+		server.Close()
+	}()
+	client, err := sftp.NewClientPipe(cr, cw)
+	require.NoError(t, err)
+	defer client.Close()
+
+	require.NoError(t, client.Mkdir("/dir"))
+	f, err := client.Create("/dir/file")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("J"), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	b, err := afero.ReadFile(mem, "/dir/file")
+	require.NoError(t, err)
+	assert.Equal(t, "Jello", string(b))
+
+	f, err = client.Open("/dir/file")
+	require.NoError(t, err)
+	b, err = ioutil.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "Jello", string(b))
+	require.NoError(t, f.Close())
+
+	require.NoError(t, client.Chmod("/dir/file", 0o600))
+	fi, err := mem.Stat("/dir/file")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+
+	require.NoError(t, client.Rename("/dir/file", "/dir/renamed"))
+	fis, err := client.ReadDir("/dir")
+	require.NoError(t, err)
+	if assert.Len(t, fis, 1) {
+		assert.Equal(t, "renamed", fis[0].Name())
+		assert.Equal(t, int64(5), fis[0].Size())
+	}
+
+	require.NoError(t, client.Truncate("/dir/renamed", 2))
+	b, err = afero.ReadFile(mem, "/dir/renamed")
+	require.NoError(t, err)
+	assert.Equal(t, "Je", string(b))
+
+	assert.Error(t, client.Link("/dir/renamed", "/link"), "no hard links")
+	require.NoError(t, client.Remove("/dir/renamed"))
+	require.NoError(t, client.RemoveDirectory("/dir"))
+}
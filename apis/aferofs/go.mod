@@ -0,0 +1,11 @@
+module github.com/pkg/sftp/apis/aferofs
+
+go 1.15
+
+require (
+	github.com/pkg/sftp v1.10.1
+	github.com/spf13/afero v1.6.0
+	github.com/stretchr/testify v1.7.1
+)
+
+replace github.com/pkg/sftp => ../..
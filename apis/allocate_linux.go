@@ -0,0 +1,35 @@
+package apis
+
+import (
+	"os"
+	"syscall"
+)
+
+func (f *osFile) Allocate(offset, length int64) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var allocErr error
+	if err := conn.Control(func(fd uintptr) {
+		for {
+			// mode 0 grows the file to the end of the range
+			allocErr = syscall.Fallocate(int(fd), 0, offset, length)
+			if allocErr != syscall.EINTR {
+				return
+			}
+		}
+	}); err != nil {
+		return err
+	}
+
+	switch allocErr {
+	case nil:
+		return nil
+	case syscall.EOPNOTSUPP, syscall.ENOSYS:
+		// like tmpfs before Linux 3.5, or some FUSE file systems
+		return unsupported("fallocate", f.Name())
+	}
+	return &os.PathError{Op: "fallocate", Path: f.Name(), Err: allocErr}
+}
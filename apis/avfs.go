@@ -40,7 +40,7 @@ func (api *AVFS) Lstat(name string) (fs.FileInfo, error) {
 }
 
 func (api *AVFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
-	return api.fs.OpenFile(name, flag, perm)
+	return wrapFile(api.fs.OpenFile(name, flag, perm))
 }
 
 func (api *AVFS) ReadDir(name string) ([]fs.DirEntry, error) {
@@ -72,7 +72,7 @@ func (api *AVFS) Truncate(name string, size int64) error {
 }
 
 func (api *AVFS) Open(name string) (File, error) {
-	return api.fs.Open(name)
+	return wrapFile(api.fs.Open(name))
 }
 
 func (api *AVFS) RemoveAll(path string) error {
@@ -80,7 +80,7 @@ func (api *AVFS) RemoveAll(path string) error {
 }
 
 func (api *AVFS) Create(name string) (File, error) {
-	return api.fs.Create(name)
+	return wrapFile(api.fs.Create(name))
 }
 
 func (api *AVFS) Getwd() (string, error) {
@@ -94,3 +94,43 @@ func (api *AVFS) TempDir() string {
 func (api *AVFS) Link(oldname string, newname string) error {
 	return api.fs.Link(oldname, newname)
 }
+
+// Access checks the access to name with access(2) when the VFS is backed by
+// the host file system, emulated file systems cannot tell.
+func (api *AVFS) Access(name string, mode uint32) error {
+	if f, ok := api.fs.(interface{ HasFeature(avfs.Features) bool }); ok && f.HasFeature(avfs.FeatRealFS) {
+		return access(name, mode)
+	}
+	return ErrAccessUnsupported
+}
+
+// StatVFS reports the statistics of the host file system when the VFS is
+// backed by it. Emulated file systems (e.g. memfs) have no fixed capacity,
+// for those a large, empty file system is reported, so clients checking
+// for free space before uploading keep working.
+func (api *AVFS) StatVFS(name string) (*StatVFS, error) {
+	if f, ok := api.fs.(interface{ HasFeature(avfs.Features) bool }); ok && f.HasFeature(avfs.FeatRealFS) {
+		return statVFS(name)
+	}
+
+	if _, err := api.fs.Stat(name); err != nil {
+		return nil, err
+	}
+
+	const (
+		blockSize = 4096
+		blocks    = 1 << 30 // 4 TiB of blocks
+		inodes    = 1 << 30
+	)
+	return &StatVFS{
+		Bsize:   blockSize,
+		Frsize:  blockSize,
+		Blocks:  blocks,
+		Bfree:   blocks,
+		Bavail:  blocks,
+		Files:   inodes,
+		Ffree:   inodes,
+		Favail:  inodes,
+		Namemax: 255,
+	}, nil
+}
@@ -0,0 +1,275 @@
+// Package billyfs adapts a go-billy file system to the apis.Fs sftp.NewServer
+// serves.
+//
+//	server, err := sftp.NewServer(channel, billyfs.New(memfs.New()),
+//		sftp.WithRootDirectory("/"))
+//
+// Changing modes, owners and times needs a billy.Change, and hard links are
+// not supported. A billy.File cannot write at an offset, so WriteAt seeks
+// and writes, one call at a time.
+package billyfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+
+	"github.com/pkg/sftp"
+	"github.com/pkg/sftp/apis"
+)
+
+// FS is an apis.Fs serving a billy.Filesystem, see New.
+type FS struct {
+	fs billy.Filesystem
+}
+
+// New returns an apis.Fs serving fsys.
+func New(fsys billy.Filesystem) *FS {
+	return &FS{fs: fsys}
+}
+
+// change returns the billy.Change of the file system, failing with op on
+// name if it has none.
+func (api *FS) change(op, name string) (billy.Change, error) {
+	if change, ok := api.fs.(billy.Change); ok {
+		return change, nil
+	}
+	return nil, &fs.PathError{Op: op, Path: name, Err: sftp.ErrSSHFxOpUnsupported}
+}
+
+func (api *FS) Chtimes(name string, atime, mtime time.Time) error {
+	change, err := api.change("chtimes", name)
+	if err != nil {
+		return err
+	}
+	return change.Chtimes(name, atime, mtime)
+}
+
+func (api *FS) Chmod(name string, mode os.FileMode) error {
+	change, err := api.change("chmod", name)
+	if err != nil {
+		return err
+	}
+	return change.Chmod(name, mode)
+}
+
+func (api *FS) Chown(name string, uid, gid int) error {
+	change, err := api.change("chown", name)
+	if err != nil {
+		return err
+	}
+	return change.Chown(name, uid, gid)
+}
+
+// Mkdir creates the directory name in an existing one, with MkdirAll. The
+// root always exists, even where the billy.Filesystem cannot stat it yet.
+func (api *FS) Mkdir(name string, perm os.FileMode) error {
+	if _, err := api.fs.Lstat(name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if dir := path.Dir(name); dir != "/" && dir != "." {
+		parent, err := api.fs.Stat(dir)
+		switch {
+		case err != nil:
+			return err
+		case !parent.IsDir():
+			return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+	}
+	return api.fs.MkdirAll(name, perm)
+}
+
+func (api *FS) Lstat(name string) (fs.FileInfo, error) {
+	return api.fs.Lstat(name)
+}
+
+// OpenFile opens a file, or a directory for reading, which not all billy
+// file systems can open.
+func (api *FS) OpenFile(name string, flag int, perm os.FileMode) (apis.File, error) {
+	f, err := api.fs.OpenFile(name, flag, perm)
+	if err != nil && flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		if fi, err2 := api.fs.Stat(name); err2 == nil && fi.IsDir() {
+			f, err = dir(name), nil
+		}
+	}
+	return api.wrapFile(name, f, err)
+}
+
+func (api *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	infos, err := api.fs.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	entries := make([]fs.DirEntry, len(infos))
+	for i, fi := range infos {
+		entries[i] = dirEntry{fi}
+	}
+	return entries, nil
+}
+
+func (api *FS) Readlink(name string) (string, error) {
+	return api.fs.Readlink(name)
+}
+
+func (api *FS) Remove(name string) error {
+	return api.fs.Remove(name)
+}
+
+func (api *FS) Rename(oldpath, newpath string) error {
+	return api.fs.Rename(oldpath, newpath)
+}
+
+func (api *FS) Stat(name string) (fs.FileInfo, error) {
+	return api.fs.Stat(name)
+}
+
+func (api *FS) Symlink(oldname, newname string) error {
+	return api.fs.Symlink(oldname, newname)
+}
+
+func (api *FS) Truncate(name string, size int64) error {
+	f, err := api.fs.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (api *FS) Open(name string) (apis.File, error) {
+	return api.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (api *FS) RemoveAll(path string) error {
+	return util.RemoveAll(api.fs, path)
+}
+
+func (api *FS) Create(name string) (apis.File, error) {
+	f, err := api.fs.Create(name)
+	return api.wrapFile(name, f, err)
+}
+
+// TempDir returns os.TempDir(), which TempFile uses too.
+func (api *FS) TempDir() string {
+	return os.TempDir()
+}
+
+// Link fails, billy has no hard links.
+func (api *FS) Link(oldname string, newname string) error {
+	return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: sftp.ErrSSHFxOpUnsupported}
+}
+
+// file is an open billy.File.
+type file struct {
+	billy.File
+	api  *FS
+	name string
+
+	mu      sync.Mutex // serializes WriteAt, which seeks and writes, and ReadDir
+	entries []fs.DirEntry
+	listed  bool
+	next    int // the entry ReadDir continues at
+}
+
+func (api *FS) wrapFile(name string, f billy.File, err error) (apis.File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &file{File: f, api: api, name: name}, nil
+}
+
+func (f *file) Chmod(mode fs.FileMode) error {
+	return f.api.Chmod(f.name, mode)
+}
+
+func (f *file) Chown(uid, gid int) error {
+	return f.api.Chown(f.name, uid, gid)
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.api.fs.Stat(f.name)
+}
+
+func (f *file) Sync() error {
+	if s, ok := f.File.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+func (f *file) WriteAt(b []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return f.Write(b)
+}
+
+func (f *file) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// ReadDir lists the directory from the file system, as billy.Files cannot.
+// The entries are listed once, by the first call.
+func (f *file) ReadDir(n int) ([]fs.DirEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.listed {
+		entries, err := f.api.ReadDir(f.name)
+		if err != nil {
+			return nil, err
+		}
+		f.entries, f.listed = entries, true
+	}
+
+	rest := f.entries[f.next:]
+	if n > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n <= 0 || n > len(rest) {
+		n = len(rest)
+	}
+	f.next += n
+	return rest[:n], nil
+}
+
+// dir is an open directory, which can only be listed.
+type dir string
+
+func (d dir) Name() string                      { return string(d) }
+func (d dir) Read([]byte) (int, error)          { return 0, d.isDir("read") }
+func (d dir) ReadAt([]byte, int64) (int, error) { return 0, d.isDir("read") }
+func (d dir) Write([]byte) (int, error)         { return 0, d.isDir("write") }
+func (d dir) Seek(int64, int) (int64, error)    { return 0, d.isDir("seek") }
+func (d dir) Truncate(int64) error              { return d.isDir("truncate") }
+func (d dir) Lock() error                       { return nil }
+func (d dir) Unlock() error                     { return nil }
+func (d dir) Close() error                      { return nil }
+
+func (d dir) isDir(op string) error {
+	return &fs.PathError{Op: op, Path: string(d), Err: syscall.EISDIR}
+}
+
+// dirEntry is a fs.DirEntry of a resolved fs.FileInfo.
+type dirEntry struct {
+	fs.FileInfo
+}
+
+func (e dirEntry) Type() fs.FileMode { return e.Mode().Type() }
+
+func (e dirEntry) Info() (fs.FileInfo, error) { return e.FileInfo, nil }
@@ -0,0 +1,76 @@
+package billyfs
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pkg/sftp"
+	"github.com/pkg/sftp/apis"
+)
+
+var _ apis.FullFs = (*FS)(nil)
+
+func TestFS(t *testing.T) {
+	mem := memfs.New()
+
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw}, New(mem), sftp.WithRootDirectory("/"))
+	require.NoError(t, err)
+	go func() {
+		server.Serve()
+		server.Close()
+	}()
+	client, err := sftp.NewClientPipe(cr, cw)
+	require.NoError(t, err)
+	defer client.Close()
+
+	require.NoError(t, client.Mkdir("/dir"))
+	assert.Error(t, client.Mkdir("/dir"), "exists")
+	assert.Error(t, client.Mkdir("/missing/dir"), "no parent")
+
+	f, err := client.Create("/dir/file")
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("world"), 7)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("hello, "), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	b, err := util.ReadFile(mem, "/dir/file")
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(b))
+
+	f, err = client.Open("/dir/file")
+	require.NoError(t, err)
+	b, err = ioutil.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(b))
+	require.NoError(t, f.Close())
+
+	require.NoError(t, client.Symlink("/dir/file", "/link"))
+	target, err := client.ReadLink("/link")
+	require.NoError(t, err)
+	assert.Equal(t, "/dir/file", target)
+
+	fis, err := client.ReadDir("/")
+	require.NoError(t, err)
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	assert.Equal(t, []string{"dir", "link"}, names)
+
+	require.NoError(t, client.Rename("/dir/file", "/dir/renamed"))
+	_, err = mem.Stat("/dir/renamed")
+	assert.NoError(t, err)
+}
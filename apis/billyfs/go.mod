@@ -0,0 +1,11 @@
+module github.com/pkg/sftp/apis/billyfs
+
+go 1.15
+
+require (
+	github.com/go-git/go-billy/v5 v5.4.1
+	github.com/pkg/sftp v0.0.0-00010101000000-000000000000
+	github.com/stretchr/testify v1.7.1
+)
+
+replace github.com/pkg/sftp => ../..
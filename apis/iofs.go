@@ -0,0 +1,115 @@
+package apis
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// IOFS is a read-only Fs serving a fs.FS, see NewIOFS.
+type IOFS struct {
+	fsys fs.FS
+}
+
+// NewIOFS returns a read-only Fs serving fsys. The paths are taken relative
+// to the root of fsys, so that "/a/b" and "a/b" both name "a/b" in there;
+// serve it with sftp.WithRootDirectory("/") to have clients start at its
+// root. It implements none of the optional interfaces, OpenFile fails with
+// syscall.EPERM for writing, and there are no symbolic links.
+func NewIOFS(fsys fs.FS) *IOFS {
+	return &IOFS{fsys: fsys}
+}
+
+// ioName returns the name of the path name in a fs.FS.
+func ioName(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func readOnly(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: syscall.EPERM}
+}
+
+// OpenFile opens name for reading, and fails for any other flag.
+func (api *IOFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, readOnly("open", name)
+	}
+	return api.Open(name)
+}
+
+func (api *IOFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(api.fsys, ioName(name))
+}
+
+func (api *IOFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(api.fsys, ioName(name))
+}
+
+func (api *IOFS) Open(name string) (File, error) {
+	f, err := api.fsys.Open(ioName(name))
+	if err != nil {
+		return nil, err
+	}
+	return &ioFile{File: f, name: name}, nil
+}
+
+// ioFile is an open file of a IOFS.
+type ioFile struct {
+	fs.File
+	name string
+
+	mu sync.Mutex // serializes ReadAt emulated with Seek and Read
+}
+
+func (f *ioFile) Name() string { return f.name }
+
+func (f *ioFile) Chmod(mode fs.FileMode) error    { return readOnly("chmod", f.name) }
+func (f *ioFile) Truncate(size int64) error       { return readOnly("truncate", f.name) }
+func (f *ioFile) Write(b []byte) (int, error)     { return 0, readOnly("write", f.name) }
+func (f *ioFile) WriteString(string) (int, error) { return 0, readOnly("write", f.name) }
+
+func (f *ioFile) WriteAt(b []byte, off int64) (int, error) {
+	return 0, readOnly("write", f.name)
+}
+
+func (f *ioFile) Seek(offset int64, whence int) (int64, error) {
+	if s, ok := f.File.(io.Seeker); ok {
+		return s.Seek(offset, whence)
+	}
+	return 0, &fs.PathError{Op: "seek", Path: f.name, Err: syscall.ESPIPE}
+}
+
+// ReadAt reads with the ReadAt of the fs.File, or else seeks and reads.
+func (f *ioFile) ReadAt(b []byte, off int64) (int, error) {
+	if r, ok := f.File.(io.ReaderAt); ok {
+		return r.ReadAt(b, off)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(f.File, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *ioFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d, ok := f.File.(fs.ReadDirFile); ok {
+		return d.ReadDir(n)
+	}
+	return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+}
@@ -0,0 +1,61 @@
+package apis_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pkg/sftp"
+	"github.com/pkg/sftp/apis"
+)
+
+func TestIOFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"hello":     {Data: []byte("hello, world"), Mode: 0o644},
+		"dir/inner": {Data: []byte("inner")},
+	}
+
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw}, apis.NewIOFS(fsys), sftp.WithRootDirectory("/"))
+	require.NoError(t, err)
+	go func() {
+		server.Serve()
+		server.Close()
+	}()
+	client, err := sftp.NewClientPipe(cr, cw)
+	require.NoError(t, err)
+	defer client.Close()
+
+	f, err := client.Open("/hello")
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(b))
+	err = f.Sync()
+	assert.True(t, errors.Is(err, sftp.ErrOpUnsupported), "got %v", err)
+	require.NoError(t, f.Close())
+
+	fis, err := client.ReadDir("/")
+	require.NoError(t, err)
+	if assert.Len(t, fis, 2) {
+		assert.Equal(t, "dir", fis[0].Name())
+		assert.True(t, fis[0].IsDir())
+		assert.Equal(t, "hello", fis[1].Name())
+		assert.Equal(t, int64(12), fis[1].Size())
+	}
+
+	_, err = client.Create("/new")
+	assert.True(t, os.IsPermission(err), "%v", err)
+	assert.Error(t, client.Remove("/hello"))
+	assert.Error(t, client.Mkdir("/newdir"))
+}
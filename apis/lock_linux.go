@@ -0,0 +1,51 @@
+package apis
+
+import (
+	"io"
+	"syscall"
+)
+
+// fOFDSetlk is F_OFD_SETLK, which package syscall does not define. Unlike
+// F_SETLK, it places locks owned by the open file rather than the process.
+const fOFDSetlk = 37
+
+func (f *osFile) LockFile(offset, length int64, exclusive bool) error {
+	typ := int16(syscall.F_RDLCK)
+	if exclusive {
+		typ = syscall.F_WRLCK
+	}
+	return f.setlk(typ, offset, length)
+}
+
+func (f *osFile) UnlockFile(offset, length int64) error {
+	return f.setlk(syscall.F_UNLCK, offset, length)
+}
+
+func (f *osFile) setlk(typ int16, offset, length int64) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	lk := syscall.Flock_t{
+		Type:   typ,
+		Whence: io.SeekStart,
+		Start:  offset,
+		Len:    length,
+	}
+	var lockErr error
+	if err := conn.Control(func(fd uintptr) {
+		lockErr = syscall.FcntlFlock(fd, fOFDSetlk, &lk)
+	}); err != nil {
+		return err
+	}
+
+	switch lockErr {
+	case syscall.EAGAIN, syscall.EACCES:
+		return ErrLockConflict
+	case syscall.EBADF, syscall.EINVAL, syscall.ENOLCK, syscall.ENOSYS:
+		// not opened for the kind of lock, or no OFD locks on this kernel
+		return ErrLockUnsupported
+	}
+	return lockErr
+}
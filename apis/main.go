@@ -0,0 +1,293 @@
+// Package apis defines the file system interface Fs, which sftp.NewServer
+// serves, with its optional extensions, and implements it over the host
+// file system with NewOS and NewAVFS, and over a read-only fs.FS with
+// NewIOFS. The subpackages aferofs and billyfs adapt the file systems of
+// afero and go-billy, in modules of their own so that only their users
+// depend on those.
+//
+// # Implementing Fs
+//
+// An Fs needs the methods of Fs and a File those of File, which are those of
+// package os and *os.File by the same names. Anything else an Fs can do, it
+// declares with the optional interfaces of FullFs, like Mkdirer or
+// Symlinker, and the server answers the requests needing a missing one with
+// SSH_FX_OP_UNSUPPORTED: the functions Mkdir, Symlink and so on call them,
+// failing with ErrUnsupported. Methods which fail for a particular path
+// rather return an error wrapping syscall.EPERM or similar. Beyond that, the
+// server looks for these optional interfaces:
+//
+//   - StatVFSer answers statvfs@openssh.com, which fails without it.
+//   - LinkReader and LinkWriter declare that Readlink, or Symlink and Link,
+//     are not supported at all, which the server then answers as
+//     unsupported without calling them.
+//   - Accesser answers the access extension, which fails without it.
+//   - XattrLister lists extended attributes, and Xattrer gets and sets
+//     them for the extended attributes of stat and setstat requests.
+//   - ACLer gets and sets access control lists for the ACL extensions,
+//     which fail without it.
+//   - FileSyncer, on a File, answers fsync@openssh.com, which fails
+//     without it.
+//   - FileChowner, on a File, changes the owner of an open file, which is
+//     changed by name with the Chowner of the Fs without it.
+//   - FileLocker, on a File, places byte range locks which other processes
+//     see, while the server only locks against its own sessions without it.
+//   - FileAllocator, on a File, reserves disk space for the allocate
+//     extension, which fails without it.
+//
+// An *os.File, or a File wrapping one as reported by OSFile, is read with
+// sendfile(2) where the server is configured to, and a File implementing
+// FileDescriptor with mmap(2).
+package apis
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// File is an open file of a Fs, an *os.File for the host file system.
+type File interface {
+	Chmod(mode fs.FileMode) error
+	Close() error
+	Name() string
+	Read(b []byte) (n int, err error)
+	ReadAt(b []byte, off int64) (n int, err error)
+	Seek(offset int64, whence int) (ret int64, err error)
+	Stat() (fs.FileInfo, error)
+	Truncate(size int64) error
+	Write(b []byte) (n int, err error)
+	WriteAt(b []byte, off int64) (n int, err error)
+	WriteString(s string) (n int, err error)
+	ReadDir(n int) ([]fs.DirEntry, error)
+}
+
+// Fs is a file system, with the methods of package os by the same names.
+// It is the core every file system implements, the server looks for the
+// optional interfaces below for anything more, see FullFs.
+type Fs interface {
+	Open(name string) (File, error)
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.DirEntry, error)
+}
+
+// FullFs is an Fs with all the optional interfaces of the host file system,
+// as OS and AVFS are.
+type FullFs interface {
+	Fs
+	Lstater
+	Readlinker
+	Mkdirer
+	Remover
+	Renamer
+	Symlinker
+	Linker
+	Chmodder
+	Chowner
+	Chtimeser
+	Truncater
+	RemoveAller
+	TempDirer
+}
+
+// Lstater is an optional interface of a Fs with symbolic links, to stat
+// them rather than their targets. Lstat falls back to Stat without it.
+type Lstater interface {
+	Lstat(name string) (os.FileInfo, error)
+}
+
+// Readlinker is an optional interface of a Fs with symbolic links, to read
+// their targets.
+type Readlinker interface {
+	Readlink(name string) (string, error)
+}
+
+// Mkdirer is an optional interface of a Fs to create directories.
+type Mkdirer interface {
+	Mkdir(name string, perm os.FileMode) error
+}
+
+// Remover is an optional interface of a Fs to remove files and empty
+// directories.
+type Remover interface {
+	Remove(name string) error
+}
+
+// Renamer is an optional interface of a Fs to rename files.
+type Renamer interface {
+	Rename(oldpath, newpath string) error
+}
+
+// Symlinker is an optional interface of a Fs to create symbolic links.
+type Symlinker interface {
+	Symlink(oldname, newname string) error
+}
+
+// Linker is an optional interface of a Fs to create hard links.
+type Linker interface {
+	Link(oldname, newname string) error
+}
+
+// Chmodder is an optional interface of a Fs to change modes.
+type Chmodder interface {
+	Chmod(name string, mode os.FileMode) error
+}
+
+// Chowner is an optional interface of a Fs to change owners.
+type Chowner interface {
+	Chown(name string, uid, gid int) error
+}
+
+// Chtimeser is an optional interface of a Fs to change access and
+// modification times.
+type Chtimeser interface {
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// Truncater is an optional interface of a Fs to change the size of files.
+type Truncater interface {
+	Truncate(name string, size int64) error
+}
+
+// RemoveAller is an optional interface of a Fs to remove directories with
+// their contents.
+type RemoveAller interface {
+	RemoveAll(path string) error
+}
+
+// TempDirer is an optional interface of a Fs to name the directory for
+// temporary files. TempDir falls back to os.TempDir without it.
+type TempDirer interface {
+	TempDir() string
+}
+
+// StatVFS holds the file system statistics reported by statvfs(3).
+type StatVFS struct {
+	Bsize   uint64 // file system block size
+	Frsize  uint64 // fundamental fs block size
+	Blocks  uint64 // number of blocks (unit f_frsize)
+	Bfree   uint64 // free blocks in file system
+	Bavail  uint64 // free blocks for non-root
+	Files   uint64 // total file inodes
+	Ffree   uint64 // free file inodes
+	Favail  uint64 // free file inodes for to non-root
+	Fsid    uint64 // file system id
+	Flag    uint64 // bit mask of f_flag values
+	Namemax uint64 // maximum filename length
+}
+
+// StatVFSer is an optional interface a Fs can implement to answer
+// statvfs@openssh.com requests for the given path.
+type StatVFSer interface {
+	StatVFS(name string) (*StatVFS, error)
+}
+
+// LinkReader is an optional interface a Fs can implement to declare whether
+// it supports reading symbolic links with Readlink.
+type LinkReader interface {
+	CanReadLinks() bool
+}
+
+// LinkWriter is an optional interface a Fs can implement to declare whether
+// it supports creating links with Symlink and Link, which object stores
+// for example often cannot.
+type LinkWriter interface {
+	CanWriteLinks() bool
+}
+
+// Accesser is an optional interface a Fs can implement to check whether the
+// server may access the given path, like access(2). The mode combines 4 to
+// read, 2 to write and 1 to execute, 0 only checks that the path exists.
+// It returns ErrAccessUnsupported if it cannot tell.
+type Accesser interface {
+	Access(name string, mode uint32) error
+}
+
+// ErrAccessUnsupported is returned by Accesser implementations,
+// which cannot check the access to a path.
+var ErrAccessUnsupported = errors.New("access checks are not supported")
+
+// FileSyncer is an optional interface a File can implement to commit its
+// contents to stable storage, like fsync(2).
+type FileSyncer interface {
+	Sync() error
+}
+
+// FileChowner is an optional interface a File can implement to change its
+// owner, rather than having it changed by name.
+type FileChowner interface {
+	Chown(uid, gid int) error
+}
+
+// FileDescriptor is an optional interface a File backed by an open file of
+// the operating system can implement to return its file descriptor.
+type FileDescriptor interface {
+	Fd() uintptr
+}
+
+// FileLocker is an optional interface a File can implement to place advisory
+// byte range locks, which other processes honour as well, like fcntl(2) does.
+// The locks belong to the File rather than the process, so that Files
+// opened by the same process conflict too. A length of 0 locks up to the end
+// of the file, wherever that will be.
+//
+// LockFile fails with ErrLockConflict if another File holds a conflicting
+// lock, and with ErrLockUnsupported if the File cannot be locked after all,
+// like for exclusive locks of files opened read-only.
+type FileLocker interface {
+	LockFile(offset, length int64, exclusive bool) error
+	UnlockFile(offset, length int64) error
+}
+
+// Errors of FileLocker implementations.
+var (
+	ErrLockConflict    = errors.New("byte range is locked")
+	ErrLockUnsupported = errors.New("byte range locks are not supported")
+)
+
+// FileAllocator is an optional interface a File can implement to reserve the
+// disk space of a byte range, like fallocate(2), so that writing it later
+// cannot fail for lack of space. The file grows to the end of the range if
+// it is shorter, and is never shrunk. It returns an error wrapping
+// ErrUnsupported if the file system cannot reserve space.
+type FileAllocator interface {
+	Allocate(offset, length int64) error
+}
+
+// ACE is an entry of an access control list, like those of NFSv4 and of SFTP
+// protocol version 4 and later: Type allows, denies, audits or alarms the
+// access Mask grants to Who, such as "OWNER@" or a user name, with the
+// inheritance and other flags of Flag.
+type ACE struct {
+	Type uint32
+	Flag uint32
+	Mask uint32
+	Who  string
+}
+
+// ACLer is an optional interface a Fs can implement to get and set the
+// access control list of the given path. SetACL replaces the whole list.
+// Both return an error wrapping ErrUnsupported if the file system has no
+// access control lists.
+type ACLer interface {
+	GetACL(name string) ([]ACE, error)
+	SetACL(name string, acl []ACE) error
+}
+
+// XattrLister is an optional interface a Fs can implement to list
+// the extended attributes of the given path.
+type XattrLister interface {
+	Listxattr(name string) ([]string, error)
+}
+
+// Xattrer is an optional interface a Fs can implement to get and set the
+// extended attributes of the given path as well as listing them. The
+// attribute names are those of the clients, which the OS file system keeps
+// in the user namespace, as user.<attr>. It returns an error wrapping
+// ErrUnsupported if the file system has no extended attributes.
+type Xattrer interface {
+	XattrLister
+	Getxattr(name, attr string) ([]byte, error)
+	Setxattr(name, attr string, data []byte) error
+}
@@ -0,0 +1,15 @@
+package apis_test
+
+import (
+	"github.com/pkg/sftp"
+	"github.com/pkg/sftp/apis"
+)
+
+var (
+	_ apis.FullFs    = (*apis.OS)(nil)
+	_ apis.FullFs    = (*apis.AVFS)(nil)
+	_ apis.Fs        = (*apis.IOFS)(nil)
+	_ sftp.ServerFS  = (*apis.OS)(nil)
+	_ apis.StatVFSer = (*apis.OS)(nil)
+	_ apis.Accesser  = (*apis.OS)(nil)
+)
@@ -0,0 +1,180 @@
+package apis
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ErrUnsupported is the error of the functions below for an Fs without the
+// optional interface they need.
+var ErrUnsupported = errors.New("operation not supported by the file system")
+
+func unsupported(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: ErrUnsupported}
+}
+
+func linkUnsupported(op, oldname, newname string) error {
+	return &os.LinkError{Op: op, Old: oldname, New: newname, Err: ErrUnsupported}
+}
+
+// Lstat calls the Lstat of fsys, or else its Stat.
+func Lstat(fsys Fs, name string) (fs.FileInfo, error) {
+	if l, ok := fsys.(Lstater); ok {
+		return l.Lstat(name)
+	}
+	return fsys.Stat(name)
+}
+
+// Readlink calls the Readlink of fsys.
+func Readlink(fsys Fs, name string) (string, error) {
+	if r, ok := fsys.(Readlinker); ok {
+		return r.Readlink(name)
+	}
+	return "", unsupported("readlink", name)
+}
+
+// Mkdir calls the Mkdir of fsys.
+func Mkdir(fsys Fs, name string, perm os.FileMode) error {
+	if m, ok := fsys.(Mkdirer); ok {
+		return m.Mkdir(name, perm)
+	}
+	return unsupported("mkdir", name)
+}
+
+// Remove calls the Remove of fsys.
+func Remove(fsys Fs, name string) error {
+	if r, ok := fsys.(Remover); ok {
+		return r.Remove(name)
+	}
+	return unsupported("remove", name)
+}
+
+// Rename calls the Rename of fsys.
+func Rename(fsys Fs, oldpath, newpath string) error {
+	if r, ok := fsys.(Renamer); ok {
+		return r.Rename(oldpath, newpath)
+	}
+	return linkUnsupported("rename", oldpath, newpath)
+}
+
+// Symlink calls the Symlink of fsys.
+func Symlink(fsys Fs, oldname, newname string) error {
+	if s, ok := fsys.(Symlinker); ok {
+		return s.Symlink(oldname, newname)
+	}
+	return linkUnsupported("symlink", oldname, newname)
+}
+
+// Link calls the Link of fsys.
+func Link(fsys Fs, oldname, newname string) error {
+	if l, ok := fsys.(Linker); ok {
+		return l.Link(oldname, newname)
+	}
+	return linkUnsupported("link", oldname, newname)
+}
+
+// Chmod calls the Chmod of fsys.
+func Chmod(fsys Fs, name string, mode os.FileMode) error {
+	if c, ok := fsys.(Chmodder); ok {
+		return c.Chmod(name, mode)
+	}
+	return unsupported("chmod", name)
+}
+
+// Chown calls the Chown of fsys.
+func Chown(fsys Fs, name string, uid, gid int) error {
+	if c, ok := fsys.(Chowner); ok {
+		return c.Chown(name, uid, gid)
+	}
+	return unsupported("chown", name)
+}
+
+// Chtimes calls the Chtimes of fsys.
+func Chtimes(fsys Fs, name string, atime, mtime time.Time) error {
+	if c, ok := fsys.(Chtimeser); ok {
+		return c.Chtimes(name, atime, mtime)
+	}
+	return unsupported("chtimes", name)
+}
+
+// Truncate calls the Truncate of fsys.
+func Truncate(fsys Fs, name string, size int64) error {
+	if t, ok := fsys.(Truncater); ok {
+		return t.Truncate(name, size)
+	}
+	return unsupported("truncate", name)
+}
+
+// RemoveAll calls the RemoveAll of fsys.
+func RemoveAll(fsys Fs, path string) error {
+	if r, ok := fsys.(RemoveAller); ok {
+		return r.RemoveAll(path)
+	}
+	return unsupported("removeall", path)
+}
+
+// TempDir calls the TempDir of fsys, or else returns os.TempDir().
+func TempDir(fsys Fs) string {
+	if t, ok := fsys.(TempDirer); ok {
+		return t.TempDir()
+	}
+	return os.TempDir()
+}
+
+// Sync calls the Sync of f.
+func Sync(f File) error {
+	if s, ok := f.(FileSyncer); ok {
+		return s.Sync()
+	}
+	return unsupported("fsync", f.Name())
+}
+
+// FileChown calls the Chown of f, or else the Chown of fsys for its name.
+func FileChown(fsys Fs, f File, uid, gid int) error {
+	if c, ok := f.(FileChowner); ok {
+		return c.Chown(uid, gid)
+	}
+	return Chown(fsys, f.Name(), uid, gid)
+}
+
+// Allocate calls the Allocate of f.
+func Allocate(f File, offset, length int64) error {
+	if a, ok := f.(FileAllocator); ok {
+		return a.Allocate(offset, length)
+	}
+	return unsupported("fallocate", f.Name())
+}
+
+// GetACL calls the GetACL of fsys.
+func GetACL(fsys Fs, name string) ([]ACE, error) {
+	if a, ok := fsys.(ACLer); ok {
+		return a.GetACL(name)
+	}
+	return nil, unsupported("getacl", name)
+}
+
+// SetACL calls the SetACL of fsys.
+func SetACL(fsys Fs, name string, acl []ACE) error {
+	if a, ok := fsys.(ACLer); ok {
+		return a.SetACL(name, acl)
+	}
+	return unsupported("setacl", name)
+}
+
+// Getxattr calls the Getxattr of fsys.
+func Getxattr(fsys Fs, name, attr string) ([]byte, error) {
+	if x, ok := fsys.(Xattrer); ok {
+		return x.Getxattr(name, attr)
+	}
+	return nil, unsupported("getxattr", name)
+}
+
+// Setxattr calls the Setxattr of fsys.
+func Setxattr(fsys Fs, name, attr string, data []byte) error {
+	if x, ok := fsys.(Xattrer); ok {
+		return x.Setxattr(name, attr, data)
+	}
+	return unsupported("setxattr", name)
+}
@@ -8,6 +8,33 @@ import (
 type OS struct {
 }
 
+// osFile is an *os.File, which implements FileLocker where the OS can.
+type osFile struct {
+	*os.File
+}
+
+// wrapFile wraps the *os.File f, if it is one.
+func wrapFile(f File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := f.(*os.File); ok {
+		return &osFile{f}, nil
+	}
+	return f, nil
+}
+
+// OSFile returns the *os.File of f, if it is or wraps one.
+func OSFile(f File) (*os.File, bool) {
+	switch f := f.(type) {
+	case *os.File:
+		return f, true
+	case *osFile:
+		return f.File, true
+	}
+	return nil, false
+}
+
 func NewOS() *OS {
 	return &OS{}
 }
@@ -33,7 +60,7 @@ func (*OS) Lstat(name string) (os.FileInfo, error) {
 }
 
 func (*OS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
-	return os.OpenFile(name, flag, perm)
+	return wrapFile(os.OpenFile(name, flag, perm))
 }
 
 func (*OS) ReadDir(name string) ([]os.DirEntry, error) {
@@ -65,7 +92,7 @@ func (*OS) Truncate(name string, size int64) error {
 }
 
 func (*OS) Open(name string) (File, error) {
-	return os.Open(name)
+	return wrapFile(os.Open(name))
 }
 
 func (*OS) RemoveAll(name string) error {
@@ -73,7 +100,7 @@ func (*OS) RemoveAll(name string) error {
 }
 
 func (*OS) Create(name string) (File, error) {
-	return os.Create(name)
+	return wrapFile(os.Create(name))
 }
 
 func (*OS) Getwd() (string, error) {
@@ -87,3 +114,27 @@ func (*OS) TempDir() string {
 func (*OS) Link(oldname string, newname string) error {
 	return os.Link(oldname, newname)
 }
+
+func (*OS) StatVFS(name string) (*StatVFS, error) {
+	return statVFS(name)
+}
+
+func (*OS) Access(name string, mode uint32) error {
+	return access(name, mode)
+}
+
+// Listxattr lists the extended attributes of the user namespace,
+// without the "user." prefix.
+func (*OS) Listxattr(name string) ([]string, error) {
+	return listxattr(name)
+}
+
+// Getxattr gets the extended attribute user.<attr>.
+func (*OS) Getxattr(name, attr string) ([]byte, error) {
+	return getxattr(name, attr)
+}
+
+// Setxattr sets the extended attribute user.<attr>.
+func (*OS) Setxattr(name, attr string, data []byte) error {
+	return setxattr(name, attr, data)
+}
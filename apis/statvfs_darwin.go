@@ -1,10 +1,18 @@
-package sftp
+//go:build darwin
+// +build darwin
+
+package apis
 
 import (
 	"syscall"
 )
 
-func statvfsFromStatfst(stat *syscall.Statfs_t) (*StatVFS, error) {
+func statVFS(name string) (*StatVFS, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(name, &stat); err != nil {
+		return nil, err
+	}
+
 	return &StatVFS{
 		Bsize:   uint64(stat.Bsize),
 		Frsize:  uint64(stat.Bsize), // fragment size is a linux thing; use block size here
@@ -13,9 +21,9 @@ func statvfsFromStatfst(stat *syscall.Statfs_t) (*StatVFS, error) {
 		Bavail:  stat.Bavail,
 		Files:   stat.Files,
 		Ffree:   stat.Ffree,
-		Favail:  stat.Ffree,                                                      // not sure how to calculate Favail
-		Fsid:    uint64(uint64(stat.Fsid.Val[1])<<32 | uint64(stat.Fsid.Val[0])), // endianness?
-		Flag:    uint64(stat.Flags),                                              // assuming POSIX?
-		Namemax: 1024,                                                            // man 2 statfs shows: #define MAXPATHLEN      1024
+		Favail:  stat.Ffree,                                              // not sure how to calculate Favail
+		Fsid:    uint64(stat.Fsid.Val[1])<<32 | uint64(stat.Fsid.Val[0]), // endianness?
+		Flag:    uint64(stat.Flags),                                      // assuming POSIX?
+		Namemax: 1024,                                                    // man 2 statfs shows: #define MAXPATHLEN      1024
 	}, nil
 }
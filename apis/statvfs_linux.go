@@ -1,12 +1,18 @@
+//go:build linux
 // +build linux
 
-package sftp
+package apis
 
 import (
 	"syscall"
 )
 
-func statvfsFromStatfst(stat *syscall.Statfs_t) (*StatVFS, error) {
+func statVFS(name string) (*StatVFS, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(name, &stat); err != nil {
+		return nil, err
+	}
+
 	return &StatVFS{
 		Bsize:   uint64(stat.Bsize),
 		Frsize:  uint64(stat.Frsize),
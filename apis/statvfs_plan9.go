@@ -0,0 +1,9 @@
+package apis
+
+import (
+	"syscall"
+)
+
+func statVFS(name string) (*StatVFS, error) {
+	return nil, syscall.EPLAN9
+}
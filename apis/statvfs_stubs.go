@@ -0,0 +1,12 @@
+//go:build !darwin && !linux && !plan9
+// +build !darwin,!linux,!plan9
+
+package apis
+
+import (
+	"syscall"
+)
+
+func statVFS(name string) (*StatVFS, error) {
+	return nil, syscall.ENOTSUP
+}
@@ -0,0 +1,69 @@
+package apis
+
+import (
+	"bytes"
+	"io/fs"
+	"strings"
+	"syscall"
+)
+
+// xattrPrefix is the namespace the extended attributes of clients live in.
+const xattrPrefix = "user."
+
+func listxattr(name string) ([]string, error) {
+	var buf []byte
+	for {
+		size, err := syscall.Listxattr(name, buf)
+		if err == syscall.ERANGE || err == nil && len(buf) == 0 && size > 0 {
+			// grown since asked for the size, or asking for it now
+			if size, err = syscall.Listxattr(name, nil); err == nil {
+				buf = make([]byte, size)
+				continue
+			}
+		}
+		if err != nil {
+			return nil, xattrError("listxattr", name, err)
+		}
+
+		var attrs []string
+		for _, attr := range bytes.Split(buf[:size], []byte{0}) {
+			if a := string(attr); strings.HasPrefix(a, xattrPrefix) {
+				attrs = append(attrs, strings.TrimPrefix(a, xattrPrefix))
+			}
+		}
+		return attrs, nil
+	}
+}
+
+func getxattr(name, attr string) ([]byte, error) {
+	var buf []byte
+	for {
+		size, err := syscall.Getxattr(name, xattrPrefix+attr, buf)
+		if err == syscall.ERANGE || err == nil && len(buf) == 0 && size > 0 {
+			if size, err = syscall.Getxattr(name, xattrPrefix+attr, nil); err == nil {
+				buf = make([]byte, size)
+				continue
+			}
+		}
+		if err != nil {
+			return nil, xattrError("getxattr", name, err)
+		}
+		return buf[:size], nil
+	}
+}
+
+func setxattr(name, attr string, data []byte) error {
+	if err := syscall.Setxattr(name, xattrPrefix+attr, data, 0); err != nil {
+		return xattrError("setxattr", name, err)
+	}
+	return nil
+}
+
+// xattrError wraps the error of op on name, ErrUnsupported if
+// the file system has no extended attributes.
+func xattrError(op, name string, err error) error {
+	if err == syscall.ENOTSUP {
+		return unsupported(op, name)
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
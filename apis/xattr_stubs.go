@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package apis
+
+func listxattr(name string) ([]string, error) {
+	return nil, unsupported("listxattr", name)
+}
+
+func getxattr(name, attr string) ([]byte, error) {
+	return nil, unsupported("getxattr", name)
+}
+
+func setxattr(name, attr string, data []byte) error {
+	return unsupported("setxattr", name)
+}
@@ -0,0 +1,105 @@
+package sftp
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// The values of Client.appendMode.
+const (
+	appendUnknown int32 = iota // not probed yet
+	appendHonored              // the server appends writes to files opened with SSH_FXF_APPEND
+	appendIgnored              // it writes them at their offsets
+)
+
+// OpenAppend opens the named file for writing at its end, creating it if
+// it does not exist, like os.OpenFile with os.O_APPEND does.
+//
+// OpenFile passes os.O_APPEND on as SSH_FXF_APPEND, which some servers
+// honor by appending every write, and others, like the Server of this
+// package, ignore by writing at the offsets the client sends, which start at
+// 0. OpenAppend finds out which kind of server it talks to, once per Client,
+// by appending to a temporary file next to the named one. Where the server
+// honors the flag, writes are appended by the server, one at a time, even
+// with UseConcurrentWrites, so that they stay in order, and concurrent
+// writers elsewhere do not overwrite each other. Elsewhere the File starts
+// at the end of the file as reported by Stat and writes one write after the
+// other from there, which writers elsewhere can interleave with.
+func (c *Client) OpenAppend(path string) (*File, error) {
+	if c.honorsAppend(path) {
+		f, err := c.open(path, flags(os.O_WRONLY|os.O_APPEND|os.O_CREATE))
+		if err != nil {
+			return nil, err
+		}
+		f.append = true
+		return f, nil
+	}
+
+	f, err := c.open(path, flags(os.O_WRONLY|os.O_CREATE))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// honorsAppend reports whether the server honors SSH_FXF_APPEND, probing it
+// next to name the first time. If the probe fails, it is taken as not, to
+// be probed again the next time.
+func (c *Client) honorsAppend(name string) bool {
+	switch atomic.LoadInt32(&c.appendMode) {
+	case appendHonored:
+		return true
+	case appendIgnored:
+		return false
+	}
+
+	honored, err := c.probeAppend(name)
+	if err != nil {
+		return false
+	}
+	mode := appendIgnored
+	if honored {
+		mode = appendHonored
+	}
+	atomic.StoreInt32(&c.appendMode, mode)
+	return honored
+}
+
+// probeAppend writes a byte to a temporary file next to name, and another
+// one at offset 0 with SSH_FXF_APPEND, which the file ends up with both
+// bytes for if the server honors it.
+func (c *Client) probeAppend(name string) (bool, error) {
+	f, err := c.createTemp(name)
+	if err != nil {
+		return false, err
+	}
+	tmp := f.Name()
+	defer c.Remove(tmp)
+
+	_, err = f.Write([]byte{'a'})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, err
+	}
+
+	f, err = c.open(tmp, flags(os.O_WRONLY|os.O_APPEND))
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err := f.WriteAt([]byte{'b'}, 0); err != nil {
+		return false, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	return fi.Size() == 2, nil
+}
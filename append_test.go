@@ -0,0 +1,48 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientOpenAppend(t *testing.T) {
+	skipIfWindows(t)
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "log")
+	require.NoError(t, os.WriteFile(name, []byte("hello"), 0o644))
+
+	f, err := client.OpenAppend(name)
+	require.NoError(t, err)
+	_, err = f.Write([]byte(" world"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, appendIgnored, client.appendMode, "the Server writes at the offsets")
+	assert.Equal(t, []string{"log"}, dirNames(t, dir), "the probe is removed")
+
+	f, err = client.OpenAppend(name)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("!"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	b, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(b))
+
+	f, err = client.OpenAppend(filepath.Join(dir, "new"))
+	require.NoError(t, err)
+	_, err = f.Write([]byte("created"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	b, err = os.ReadFile(filepath.Join(dir, "new"))
+	require.NoError(t, err)
+	assert.Equal(t, "created", string(b))
+}
@@ -35,7 +35,9 @@ func (fi *fileInfo) Size() int64 { return int64(fi.stat.Size) }
 func (fi *fileInfo) Mode() fs.FileMode { return toFileMode(fi.stat.Mode) }
 
 // ModTime returns the last modification time of the file.
-func (fi *fileInfo) ModTime() time.Time { return time.Unix(int64(fi.stat.Mtime), 0) }
+func (fi *fileInfo) ModTime() time.Time {
+	return time.Unix(int64(fi.stat.Mtime), int64(fi.stat.MtimeNsec))
+}
 
 // IsDir returns true if the file is a directory.
 func (fi *fileInfo) IsDir() bool { return fi.Mode().IsDir() }
@@ -54,6 +56,21 @@ type FileStat struct {
 	UID      uint32
 	GID      uint32
 	Extended []StatExtended
+
+	// Only reported by servers speaking protocol version 4 or later,
+	// see WithProtocolVersion.
+	Owner    string
+	Group    string
+	ACLFlags uint32
+	ACL      []ACE
+
+	// The nanoseconds of the times and the creation time, only reported by
+	// servers speaking protocol version 4 or later, or supporting the
+	// times@github.com/pkg/sftp extension. CreateTime is 0 if unknown.
+	AtimeNsec      uint32
+	MtimeNsec      uint32
+	CreateTime     uint32
+	CreateTimeNsec uint32
 }
 
 // StatExtended contains additional, extended information for a FileStat.
@@ -81,6 +98,25 @@ func fileStatFromInfo(fi fs.FileInfo) (uint32, *FileStat) {
 		Mode:  fromFileMode(fi.Mode()),
 		Mtime: uint32(mtime),
 		Atime: uint32(atime),
+
+		MtimeNsec: uint32(fi.ModTime().Nanosecond()),
+	}
+	fileStat.AtimeNsec = fileStat.MtimeNsec
+	if btime, ok := birthTime(fi); ok {
+		fileStat.CreateTime = uint32(btime.Unix())
+		fileStat.CreateTimeNsec = uint32(btime.Nanosecond())
+	}
+
+	// handlers not backed by an os file system can report the owner and
+	// times through a FileStat, as the fs.FileInfo of a Client does
+	if sys, ok := fi.Sys().(*FileStat); ok {
+		flags |= sshFileXferAttrUIDGID
+		fileStat.UID = sys.UID
+		fileStat.GID = sys.GID
+		fileStat.Atime = sys.Atime
+		fileStat.AtimeNsec = sys.AtimeNsec
+		fileStat.CreateTime = sys.CreateTime
+		fileStat.CreateTimeNsec = sys.CreateTimeNsec
 	}
 
 	// os specific file stat decoding
@@ -7,3 +7,7 @@ import "io/fs"
 func fileStatFromInfoOs(fi fs.FileInfo, flags *uint32, fileStat *FileStat) {
 	// todo
 }
+
+func allocatedSize(fi fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
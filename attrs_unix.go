@@ -14,3 +14,11 @@ func fileStatFromInfoOs(fi fs.FileInfo, flags *uint32, fileStat *FileStat) {
 		fileStat.GID = statt.Gid
 	}
 }
+
+// allocatedSize returns the bytes allocated on disk for the file of fi.
+func allocatedSize(fi fs.FileInfo) (uint64, bool) {
+	if statt, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(statt.Blocks) * 512, true
+	}
+	return 0, false
+}
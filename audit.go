@@ -0,0 +1,294 @@
+package sftp
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// AuditRecord describes an operation of a client, once it completed.
+//
+// Opening a file or directory, reading, writing or listing it, and closing
+// it again is one operation, recorded when the handle is closed, or when the
+// session ends with the handle left open. Every other request with a path
+// is an operation by itself.
+type AuditRecord struct {
+	Time     time.Time     // when the operation started
+	Duration time.Duration // until it completed
+
+	User    string // of the session, see AuditSession
+	Session string
+
+	// Operation is the method the operation would have as a Request, like
+	// "Get", "Put", "List", "Rename" or "Mkdir", or "Realpath". Opens for
+	// reading and writing are "Open".
+	Operation string
+
+	Path   string // as sent by the client
+	Target string // of renames and links
+
+	// Flags are the SSH_FXF_* flags a file was opened with.
+	Flags uint32
+
+	// Status is the name of the status the operation completed with,
+	// like "SSH_FX_OK" or "SSH_FX_PERMISSION_DENIED", and Err its error.
+	// A read or write failing fails the whole operation on the handle.
+	Status string
+	Err    error
+
+	BytesRead    int64 // file contents sent to the client
+	BytesWritten int64 // file contents received from the client
+}
+
+// AuditSession identifies the session in AuditRecords, usually by the name
+// the user authenticated with and the id of the SSH session.
+type AuditSession struct {
+	User string
+	ID   string
+}
+
+// AuditSink receives an AuditRecord for every operation a Server or
+// RequestServer completes, see WithAuditSink and WithRSAuditSink.
+// Audit is called concurrently, and holds up the session until it returns.
+//
+// For one JSON object per line, see NewJSONAuditSink.
+type AuditSink interface {
+	Audit(AuditRecord)
+}
+
+// AuditFunc is a function which is an AuditSink.
+type AuditFunc func(AuditRecord)
+
+// Audit calls f(rec).
+func (f AuditFunc) Audit(rec AuditRecord) {
+	f(rec)
+}
+
+// WithAuditSink has the Server pass a record of every completed operation
+// of session to sink.
+func WithAuditSink(sink AuditSink, session AuditSession) ServerOption {
+	return func(s *Server) error {
+		s.audit = newAuditLog(sink, session)
+		return nil
+	}
+}
+
+// WithRSAuditSink has the RequestServer pass a record of every completed
+// operation of session to sink.
+func WithRSAuditSink(sink AuditSink, session AuditSession) RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.audit = newAuditLog(sink, session)
+	}
+}
+
+// NewJSONAuditSink returns an AuditSink, which writes every record to w as
+// a JSON object on a line of its own, like
+//
+//	{"time":"2006-01-02T15:04:05Z","duration":0.25,"user":"alice","session":"1",
+//	"operation":"Put","path":"/upload/report.csv","flags":26,"status":"SSH_FX_OK",
+//	"bytes_written":52310}
+//
+// with the fields of AuditRecord, and "error" for the message of Err.
+// Records failing to write are dropped.
+func NewJSONAuditSink(w io.Writer) AuditSink {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+
+	return AuditFunc(func(rec AuditRecord) {
+		line := jsonAuditRecord{
+			Time:         rec.Time,
+			Duration:     rec.Duration.Seconds(),
+			User:         rec.User,
+			Session:      rec.Session,
+			Operation:    rec.Operation,
+			Path:         rec.Path,
+			Target:       rec.Target,
+			Flags:        rec.Flags,
+			Status:       rec.Status,
+			BytesRead:    rec.BytesRead,
+			BytesWritten: rec.BytesWritten,
+		}
+		if rec.Err != nil {
+			line.Error = rec.Err.Error()
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(line)
+	})
+}
+
+type jsonAuditRecord struct {
+	Time         time.Time `json:"time"`
+	Duration     float64   `json:"duration"` // seconds
+	User         string    `json:"user,omitempty"`
+	Session      string    `json:"session,omitempty"`
+	Operation    string    `json:"operation"`
+	Path         string    `json:"path,omitempty"`
+	Target       string    `json:"target,omitempty"`
+	Flags        uint32    `json:"flags,omitempty"`
+	Status       string    `json:"status"`
+	Error        string    `json:"error,omitempty"`
+	BytesRead    int64     `json:"bytes_read,omitempty"`
+	BytesWritten int64     `json:"bytes_written,omitempty"`
+}
+
+// auditLog turns the requests a server answered into AuditRecords.
+type auditLog struct {
+	sink    AuditSink
+	session AuditSession
+
+	mu      sync.Mutex
+	handles map[string]*AuditRecord // operations on the open handles
+}
+
+func newAuditLog(sink AuditSink, session AuditSession) *auditLog {
+	return &auditLog{
+		sink:    sink,
+		session: session,
+		handles: make(map[string]*AuditRecord),
+	}
+}
+
+// served audits the request r, answered with rpkt as described by ev.
+// A nil auditLog does nothing.
+func (a *auditLog) served(r loggedRequest, ev LogEvent, rpkt responsePacket) {
+	if a == nil {
+		return
+	}
+
+	switch p := r.pkt.(type) {
+	case *sshFxpOpenPacket, *sshFxpOpendirPacket:
+		rec := a.record(r, ev)
+		if h, ok := rpkt.(*sshFxpHandlePacket); ok {
+			a.mu.Lock()
+			a.handles[h.Handle] = &rec
+			a.mu.Unlock()
+			return
+		}
+		a.emit(rec)
+
+	case *sshFxpReadPacket, *sshFxpWritePacket, *sshFxpReaddirPacket:
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		rec, ok := a.handles[ev.Handle]
+		if !ok {
+			return
+		}
+		if ev.Err != nil {
+			if status, ok := ev.Err.(*StatusError); (!ok || status.Code != sshFxEOF) && rec.Err == nil {
+				rec.Err = ev.Err
+			}
+			return
+		}
+		switch p.(type) {
+		case *sshFxpReadPacket:
+			rec.BytesRead += ev.Bytes
+		case *sshFxpWritePacket:
+			rec.BytesWritten += ev.Bytes
+		}
+
+	case *sshFxpClosePacket:
+		a.mu.Lock()
+		rec, ok := a.handles[p.Handle]
+		delete(a.handles, p.Handle)
+		a.mu.Unlock()
+		if !ok {
+			return
+		}
+		if rec.Err == nil {
+			rec.Err = ev.Err
+		}
+		rec.Duration = time.Since(rec.Time)
+		a.emit(*rec)
+
+	case *sshFxpFsetstatPacket:
+		a.mu.Lock()
+		opened, ok := a.handles[p.Handle]
+		a.mu.Unlock()
+		if !ok {
+			return
+		}
+		rec := a.record(r, ev)
+		rec.Path = opened.Path
+		a.emit(rec)
+
+	case hasPath:
+		a.emit(a.record(r, ev))
+	}
+}
+
+// record returns the record of the operation r, described by ev.
+func (a *auditLog) record(r loggedRequest, ev LogEvent) AuditRecord {
+	rec := AuditRecord{
+		Time:      r.start,
+		Duration:  ev.Latency,
+		User:      a.session.User,
+		Session:   a.session.ID,
+		Operation: requestMethod(r.pkt),
+		Path:      ev.Path,
+		Target:    requestTarget(r.pkt),
+		Err:       ev.Err,
+	}
+	switch p := r.pkt.(type) {
+	case *sshFxpOpenPacket:
+		rec.Operation = openMethod(p.Pflags)
+		rec.Flags = p.Pflags
+	case *sshFxpOpendirPacket:
+		rec.Operation = "List"
+	case *sshFxpRealpathPacket:
+		rec.Operation = "Realpath"
+	case *sshFxpFsetstatPacket:
+		rec.Operation = "Setstat"
+	}
+	if rec.Operation == "" {
+		rec.Operation = ev.Packet
+		if ev.Extension != "" {
+			rec.Operation = ev.Extension
+		}
+	}
+	return rec
+}
+
+// emit passes rec to the sink.
+func (a *auditLog) emit(rec AuditRecord) {
+	rec.Status = statusName(rec.Err)
+	a.sink.Audit(rec)
+}
+
+// abandon records the operations on the handles left open at the end of a
+// session, as failed with io.ErrUnexpectedEOF. A nil auditLog does nothing.
+func (a *auditLog) abandon() {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	handles := a.handles
+	a.handles = make(map[string]*AuditRecord)
+	a.mu.Unlock()
+
+	for _, rec := range handles {
+		if rec.Err == nil {
+			rec.Err = io.ErrUnexpectedEOF
+		}
+		rec.Duration = time.Since(rec.Time)
+		a.emit(*rec)
+	}
+}
+
+// requestTarget returns the target path of renames and links.
+func requestTarget(pkt requestPacket) string {
+	switch p := pkt.(type) {
+	case *sshFxpRenamePacket:
+		return p.Newpath
+	case *sshFxpSymlinkPacket:
+		return p.Linkpath
+	case *sshFxpExtendedPacketHardlink:
+		return p.Newpath
+	case *sshFxpExtendedPacketPosixRename:
+		return p.Newpath
+	}
+	return ""
+}
@@ -0,0 +1,152 @@
+package sftp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// auditTrail is an AuditSink keeping the records.
+type auditTrail struct {
+	mu      sync.Mutex
+	records []AuditRecord
+}
+
+func (a *auditTrail) Audit(rec AuditRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records = append(a.records, rec)
+}
+
+// find returns the records of operation on path.
+func (a *auditTrail) find(operation, path string) []AuditRecord {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var records []AuditRecord
+	for _, rec := range a.records {
+		if rec.Operation == operation && rec.Path == path {
+			records = append(records, rec)
+		}
+	}
+	return records
+}
+
+func TestAuditSink(t *testing.T) {
+	trail := new(auditTrail)
+	session := AuditSession{User: "alice", ID: "42"}
+
+	client, server := clientServerPair(t, WithAuditSink(trail, session))
+	defer server.Close()
+
+	dir := t.TempDir()
+	foo, bar := filepath.Join(dir, "foo"), filepath.Join(dir, "bar")
+
+	f, err := client.OpenFile(foo, os.O_WRONLY|os.O_CREATE)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	f, err = client.Open(foo)
+	require.NoError(t, err)
+	_, err = io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.NoError(t, client.Rename(foo, bar))
+	_, err = client.Stat(foo)
+	require.Error(t, err)
+
+	_, err = client.Open(bar) // left open
+	require.NoError(t, err)
+
+	client.Close()
+	waitServed(server)
+
+	if records := trail.find("Put", foo); assert.Len(t, records, 1) {
+		rec := records[0]
+		assert.Equal(t, "alice", rec.User)
+		assert.Equal(t, "42", rec.Session)
+		assert.Equal(t, uint32(sshFxfWrite|sshFxfCreat), rec.Flags)
+		assert.Equal(t, "SSH_FX_OK", rec.Status)
+		assert.Equal(t, int64(5), rec.BytesWritten)
+		assert.False(t, rec.Time.IsZero())
+	}
+	if records := trail.find("Get", foo); assert.Len(t, records, 1) {
+		assert.Equal(t, "SSH_FX_OK", records[0].Status, "the EOF of reads is no error")
+		assert.Equal(t, int64(5), records[0].BytesRead)
+	}
+	if records := trail.find("Rename", foo); assert.Len(t, records, 1) {
+		assert.Equal(t, bar, records[0].Target)
+	}
+	if records := trail.find("Stat", foo); assert.Len(t, records, 1) {
+		assert.Equal(t, "SSH_FX_NO_SUCH_FILE", records[0].Status)
+		assert.Error(t, records[0].Err)
+	}
+	if records := trail.find("Get", bar); assert.Len(t, records, 1) {
+		assert.True(t, errors.Is(records[0].Err, io.ErrUnexpectedEOF), "the handle was left open")
+	}
+	assert.Empty(t, trail.find("SSH_FXP_INIT", ""))
+}
+
+func TestRequestServerAuditSink(t *testing.T) {
+	trail := new(auditTrail)
+	p := clientRequestServerPair(t, WithRSAuditSink(trail, AuditSession{User: "bob"}))
+	defer p.Close()
+
+	f, err := p.cli.Create("/foo")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, p.cli.Mkdir("/dir"))
+
+	if records := trail.find("Open", "/foo"); assert.Len(t, records, 1) {
+		assert.Equal(t, "bob", records[0].User)
+		assert.Equal(t, int64(5), records[0].BytesWritten)
+		assert.Equal(t, "SSH_FX_OK", records[0].Status)
+	}
+	assert.Len(t, trail.find("Mkdir", "/dir"), 1)
+}
+
+func TestJSONAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewJSONAuditSink(&buf)
+	sink.Audit(AuditRecord{
+		Time:         time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+		Duration:     250 * time.Millisecond,
+		User:         "alice",
+		Operation:    "Put",
+		Path:         "/upload/report.csv",
+		Status:       "SSH_FX_FAILURE",
+		Err:          errors.New("disk full"),
+		BytesWritten: 10,
+	})
+	sink.Audit(AuditRecord{Operation: "Mkdir", Status: "SSH_FX_OK"})
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+
+	var rec map[string]interface{}
+	require.NoError(t, json.Unmarshal(lines[0], &rec))
+	assert.Equal(t, map[string]interface{}{
+		"time":          "2006-01-02T15:04:05Z",
+		"duration":      0.25,
+		"user":          "alice",
+		"operation":     "Put",
+		"path":          "/upload/report.csv",
+		"status":        "SSH_FX_FAILURE",
+		"error":         "disk full",
+		"bytes_written": 10.0,
+	}, rec)
+}
@@ -0,0 +1,205 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp/apis"
+)
+
+// errBackendTimeout fails the requests whose backend calls ran over the timeout.
+var errBackendTimeout = errors.New("backend call timed out")
+
+// errPoisonedHandle fails the requests on a handle poisoned by a backend call
+// which timed out.
+var errPoisonedHandle = errors.New("handle is unusable after a backend call timed out")
+
+// BackendTimeoutStats reports the backend calls of a Server which ran over
+// the timeout set with WithBackendTimeout.
+type BackendTimeoutStats struct {
+	TimedOut uint64 // requests whose backend calls ran over the timeout
+	Stuck    int    // of those, the ones whose backend calls have not returned yet
+	Poisoned int    // handles poisoned by them, which are not closed yet
+}
+
+// WithBackendTimeout limits how long the Server waits for the calls to the
+// backend Fs and its files serving a request. Each request is served on a
+// goroutine of its own then, so that a hung backend, like an unresponsive NFS
+// or FUSE mount, cannot hold up the workers serving the other requests.
+//
+// A request running over the timeout fails with SSH_FX_FAILURE, while its
+// backend calls go on in the background. If it was on a handle, the handle
+// is poisoned: further requests on it fail the same way, until the client
+// closes it, which closes the file in the background. Mutations completing
+// after the timeout are not replicated, see WithReplication.
+// Server.BackendTimeoutStats reports how many calls are stuck.
+func WithBackendTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) error {
+		if timeout <= 0 {
+			return fmt.Errorf("sftp: non-positive backend timeout %v", timeout)
+		}
+		s.timeouts = &backendTimeouts{
+			timeout:  timeout,
+			poisoned: make(map[string]apis.File),
+		}
+		return nil
+	}
+}
+
+type backendTimeouts struct {
+	timeout time.Duration
+
+	timedOut uint64 // accessed atomically
+	stuck    int64  // accessed atomically
+
+	mu       sync.Mutex
+	poisoned map[string]apis.File // by handle
+}
+
+// BackendTimeoutStats returns the backend calls which ran over the timeout,
+// the zero value if WithBackendTimeout was not used.
+func (svr *Server) BackendTimeoutStats() BackendTimeoutStats {
+	t := svr.timeouts
+	if t == nil {
+		return BackendTimeoutStats{}
+	}
+
+	t.mu.Lock()
+	poisoned := len(t.poisoned)
+	t.mu.Unlock()
+
+	return BackendTimeoutStats{
+		TimedOut: atomic.LoadUint64(&t.timedOut),
+		Stuck:    int(atomic.LoadInt64(&t.stuck)),
+		Poisoned: poisoned,
+	}
+}
+
+// requestHandle returns the handle p is on, if any.
+func requestHandle(p requestPacket) (string, bool) {
+	if e, ok := p.(*sshFxpExtendedPacket); ok {
+		p = e.SpecificPacket
+	}
+	if p, ok := p.(hasHandle); ok {
+		return p.getHandle(), true
+	}
+	return "", false
+}
+
+type timedResponse struct {
+	rpkt responsePacket
+	err  error
+}
+
+// respondWithTimeout is respondPacket on a goroutine of its own, which fails
+// p once the timeout has passed.
+func (svr *Server) respondWithTimeout(p orderedRequest) (responsePacket, error) {
+	t := svr.timeouts
+
+	handle, onHandle := requestHandle(p.requestPacket)
+	if onHandle && t.isPoisoned(handle) {
+		if _, ok := p.requestPacket.(*sshFxpClosePacket); ok {
+			t.release(handle)
+			return statusFromError(p.id(), nil), nil
+		}
+		return statusFromError(p.id(), errPoisonedHandle), nil
+	}
+
+	done := make(chan timedResponse, 1)
+	go func() {
+		rpkt, err := respondPacket(svr, p)
+		done <- timedResponse{rpkt, err}
+	}()
+
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.rpkt, r.err
+	case <-timer.C:
+	}
+
+	atomic.AddUint64(&t.timedOut, 1)
+	atomic.AddInt64(&t.stuck, 1)
+	if svr.pktMgr.alloc != nil {
+		// the backend calls may still use the pages of the request
+		svr.pktMgr.alloc.ForgetPages(p.orderID())
+	}
+	if onHandle {
+		t.poison(svr, handle)
+	}
+
+	go func() {
+		r := <-done
+		atomic.AddInt64(&t.stuck, -1)
+		svr.discardLate(p, r.rpkt)
+	}()
+
+	return statusFromError(p.id(), errBackendTimeout), nil
+}
+
+// discardLate cleans up after the response to p, which timed out.
+func (svr *Server) discardLate(p orderedRequest, rpkt responsePacket) {
+	if svr.cache != nil {
+		// drop what the cache learned while the mutation was pending
+		svr.invalidateCache(p.requestPacket, nil)
+	}
+	if h, ok := rpkt.(*sshFxpHandlePacket); ok {
+		// the client never learns about the handle
+		svr.closeHandle(h.Handle)
+	}
+}
+
+func (t *backendTimeouts) isPoisoned(handle string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.poisoned[handle]
+	return ok
+}
+
+// poison takes handle from the open files of svr, so that its requests fail.
+func (t *backendTimeouts) poison(svr *Server, handle string) {
+	svr.openFilesLock.Lock()
+	f, ok := svr.openFiles[handle]
+	delete(svr.openFiles, handle)
+	svr.openFilesLock.Unlock()
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.poisoned[handle] = f
+}
+
+// release forgets the poisoned handle, closing its file in the background.
+func (t *backendTimeouts) release(handle string) {
+	t.mu.Lock()
+	f, ok := t.poisoned[handle]
+	delete(t.poisoned, handle)
+	t.mu.Unlock()
+
+	if ok {
+		go closePoisoned(f)
+	}
+}
+
+// closePoisoned closes the files of all poisoned handles in the background.
+func (t *backendTimeouts) closePoisoned() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for handle, f := range t.poisoned {
+		delete(t.poisoned, handle)
+		go closePoisoned(f)
+	}
+}
+
+func closePoisoned(f apis.File) {
+	serverLocks.unlock(f.Name(), f, 0, 0)
+	f.Close()
+}
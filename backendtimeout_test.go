@@ -0,0 +1,85 @@
+package sftp
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hangingFs hangs on the stats of and the reads from the file name,
+// until hang is closed.
+type hangingFs struct {
+	apis.FullFs
+	name string
+	hang chan struct{}
+}
+
+func (h *hangingFs) Stat(name string) (fs.FileInfo, error) {
+	if name == h.name {
+		<-h.hang
+	}
+	return h.FullFs.Stat(name)
+}
+
+func (h *hangingFs) OpenFile(name string, flag int, perm os.FileMode) (apis.File, error) {
+	f, err := h.FullFs.OpenFile(name, flag, perm)
+	if err != nil || name != h.name {
+		return f, err
+	}
+	return hangingFile{f, h.hang}, nil
+}
+
+type hangingFile struct {
+	apis.File
+	hang chan struct{}
+}
+
+func (f hangingFile) ReadAt(b []byte, off int64) (int, error) {
+	<-f.hang
+	return f.File.ReadAt(b, off)
+}
+
+func TestServerBackendTimeout(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "hung")
+	require.NoError(t, os.WriteFile(name, []byte("hello"), 0o644))
+
+	backend := &hangingFs{FullFs: apis.NewAVFS(), name: name, hang: make(chan struct{})}
+	client, server := clientServerPairFS(t, backend, []ServerOption{WithBackendTimeout(50 * time.Millisecond)})
+	defer client.Close()
+	defer server.Close()
+
+	_, err := client.Stat(name)
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, ErrSSHFxFailure), "got %v", err)
+	}
+	_, err = client.Stat(dir)
+	assert.NoError(t, err, "the hung call does not hold up others")
+
+	f, err := client.Open(name)
+	require.NoError(t, err)
+	_, err = f.ReadAt(make([]byte, 5), 0)
+	assert.Error(t, err)
+	_, err = f.Stat()
+	assert.Error(t, err, "the handle is poisoned")
+	assert.Equal(t, BackendTimeoutStats{TimedOut: 2, Stuck: 2, Poisoned: 1}, server.BackendTimeoutStats())
+
+	assert.NoError(t, f.Close())
+	assert.Equal(t, 0, server.BackendTimeoutStats().Poisoned)
+
+	close(backend.hang)
+	assert.Eventually(t, func() bool {
+		return server.BackendTimeoutStats().Stuck == 0
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = NewServer(nil, backend, WithBackendTimeout(0))
+	assert.Error(t, err)
+}
@@ -0,0 +1,18 @@
+//go:build darwin || freebsd || netbsd
+// +build darwin freebsd netbsd
+
+package sftp
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// birthTime returns the creation time of the file of fi.
+func birthTime(fi fs.FileInfo) (time.Time, bool) {
+	if statt, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(statt.Birthtimespec.Unix()), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,15 @@
+//go:build !darwin && !freebsd && !netbsd
+// +build !darwin,!freebsd,!netbsd
+
+package sftp
+
+import (
+	"io/fs"
+	"time"
+)
+
+// birthTime returns the creation time of the file of fi, which
+// package syscall cannot tell on this platform.
+func birthTime(fi fs.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
@@ -0,0 +1,130 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/sftp/apis"
+)
+
+// Capabilities reports which optional functionality the apis.Fs of a Server
+// supports, so missing functionality can be noticed before clients need it.
+type Capabilities struct {
+	Symlinks  bool
+	Hardlinks bool
+	Chown     bool
+	StatVFS   bool
+	Xattrs    bool
+
+	// Errors holds why a capability is missing, keyed by its lower case
+	// name, e.g. "symlinks".
+	Errors map[string]error
+}
+
+// String returns the capabilities as "name=true" or "name=false",
+// in the order of the struct fields.
+func (c Capabilities) String() string {
+	entries := []string{
+		fmt.Sprintf("symlinks=%t", c.Symlinks),
+		fmt.Sprintf("hardlinks=%t", c.Hardlinks),
+		fmt.Sprintf("chown=%t", c.Chown),
+		fmt.Sprintf("statvfs=%t", c.StatVFS),
+		fmt.Sprintf("xattrs=%t", c.Xattrs),
+	}
+
+	names := make([]string, 0, len(c.Errors))
+	for name := range c.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entries = append(entries, fmt.Sprintf("%s: %v", name, c.Errors[name]))
+	}
+	return strings.Join(entries, " ")
+}
+
+var errNoOwner = errors.New("file owner not reported")
+
+var selfCheckCount uint64
+
+// Capabilities probes the apis.Fs of the Server. Optional interfaces are
+// checked for and called, everything else is tried out in a scratch
+// directory below the TempDir of the Fs, which is removed afterwards.
+//
+// It is meant to be called once at startup, e.g. to log the result.
+func (svr *Server) Capabilities() Capabilities {
+	c := Capabilities{Errors: make(map[string]error)}
+	check := func(name string, supported *bool, err error) {
+		if err != nil {
+			c.Errors[name] = err
+			return
+		}
+		*supported = true
+	}
+
+	dir := path.Join(apis.TempDir(svr.fs), fmt.Sprintf("sftp-selfcheck-%d-%d",
+		os.Getpid(), atomic.AddUint64(&selfCheckCount, 1)))
+	file := path.Join(dir, "file")
+
+	err := apis.Mkdir(svr.fs, dir, 0700)
+	if err == nil {
+		defer apis.RemoveAll(svr.fs, dir)
+
+		var f apis.File
+		if f, err = svr.fs.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666); err == nil {
+			err = f.Close()
+		}
+	}
+	if err != nil {
+		for _, name := range []string{"symlinks", "hardlinks", "chown", "xattrs"} {
+			c.Errors[name] = err
+		}
+	} else {
+		// a Fs declaring it cannot create links is taken by its word
+		symlinkErr, hardlinkErr := error(ErrSSHFxOpUnsupported), error(ErrSSHFxOpUnsupported)
+		if svr.canWriteLinks() {
+			symlinkErr = apis.Symlink(svr.fs, "file", path.Join(dir, "symlink"))
+			hardlinkErr = apis.Link(svr.fs, file, path.Join(dir, "hardlink"))
+		}
+		check("symlinks", &c.Symlinks, symlinkErr)
+		check("hardlinks", &c.Hardlinks, hardlinkErr)
+		check("chown", &c.Chown, svr.probeChown(file))
+		check("xattrs", &c.Xattrs, svr.probeXattrs(file))
+	}
+
+	if statVFSer, ok := svr.fs.(apis.StatVFSer); ok {
+		_, err := statVFSer.StatVFS(apis.TempDir(svr.fs))
+		check("statvfs", &c.StatVFS, err)
+	} else {
+		c.Errors["statvfs"] = ErrSSHFxOpUnsupported
+	}
+
+	return c
+}
+
+// probeChown changes the owner of name to the owner it already has.
+func (svr *Server) probeChown(name string) error {
+	fi, err := apis.Lstat(svr.fs, name)
+	if err != nil {
+		return err
+	}
+	flags, stat := fileStatFromInfo(fi)
+	if flags&sshFileXferAttrUIDGID == 0 {
+		return errNoOwner
+	}
+	return apis.Chown(svr.fs, name, int(stat.UID), int(stat.GID))
+}
+
+func (svr *Server) probeXattrs(name string) error {
+	lister, ok := svr.fs.(apis.XattrLister)
+	if !ok {
+		return ErrSSHFxOpUnsupported
+	}
+	_, err := lister.Listxattr(name)
+	return err
+}
@@ -0,0 +1,134 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// limitedFs fails symlinks and supports listing extended attributes.
+type limitedFs struct {
+	apis.FullFs
+}
+
+func (limitedFs) Symlink(oldname, newname string) error {
+	return errors.New("no symlinks here")
+}
+
+func (limitedFs) Listxattr(name string) ([]string, error) {
+	return nil, nil
+}
+
+// chownFs changes owners, which the AVFS only does with privileges.
+type chownFs struct {
+	*apis.AVFS
+}
+
+func (chownFs) Chown(name string, uid, gid int) error {
+	return nil
+}
+
+func testCapabilities(t *testing.T, fs apis.Fs) Capabilities {
+	server, err := NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{}, fs)
+	require.NoError(t, err)
+
+	caps := server.Capabilities()
+
+	// the scratch directory is gone
+	matches, err := filepath.Glob(filepath.Join(apis.TempDir(fs), "sftp-selfcheck-*"))
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+
+	return caps
+}
+
+func TestServerCapabilities(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("StatVFS is implemented on linux and darwin")
+	}
+
+	caps := testCapabilities(t, chownFs{apis.NewAVFS()})
+	assert.True(t, caps.Symlinks)
+	assert.True(t, caps.Hardlinks)
+	assert.True(t, caps.Chown)
+	assert.True(t, caps.StatVFS)
+	assert.False(t, caps.Xattrs)
+	assert.Equal(t, map[string]error{"xattrs": ErrSSHFxOpUnsupported}, caps.Errors)
+	assert.Equal(t, "symlinks=true hardlinks=true chown=true statvfs=true xattrs=false xattrs: "+
+		ErrSSHFxOpUnsupported.Error(), caps.String())
+}
+
+func TestServerCapabilitiesMissing(t *testing.T) {
+	caps := testCapabilities(t, limitedFs{statVFSlessFs{apis.NewAVFS()}})
+	assert.False(t, caps.Symlinks)
+	assert.Error(t, caps.Errors["symlinks"])
+	assert.False(t, caps.StatVFS)
+	assert.Equal(t, ErrSSHFxOpUnsupported, caps.Errors["statvfs"])
+	assert.True(t, caps.Xattrs)
+}
+
+// tempDirFs has a different TempDir.
+type tempDirFs struct {
+	apis.FullFs
+	dir string
+}
+
+func (fs tempDirFs) TempDir() string {
+	return fs.dir
+}
+
+func TestServerCapabilitiesNoTempDir(t *testing.T) {
+	caps := testCapabilities(t, tempDirFs{apis.NewAVFS(), filepath.Join(t.TempDir(), "missing")})
+	assert.False(t, caps.Symlinks)
+	assert.False(t, caps.Hardlinks)
+	assert.False(t, caps.Chown)
+	assert.True(t, os.IsNotExist(caps.Errors["hardlinks"]))
+}
+
+// linklessFs declares that it can neither read nor create links.
+type linklessFs struct {
+	apis.FullFs
+}
+
+func (linklessFs) CanReadLinks() bool  { return false }
+func (linklessFs) CanWriteLinks() bool { return false }
+
+func TestServerCapabilitiesNoLinks(t *testing.T) {
+	caps := testCapabilities(t, linklessFs{chownFs{apis.NewAVFS()}})
+	assert.False(t, caps.Symlinks)
+	assert.False(t, caps.Hardlinks)
+	assert.Equal(t, ErrSSHFxOpUnsupported, caps.Errors["symlinks"])
+	assert.Equal(t, ErrSSHFxOpUnsupported, caps.Errors["hardlinks"])
+	assert.True(t, caps.Chown)
+}
+
+func TestServerLinksUnsupported(t *testing.T) {
+	client, server := clientServerPairFS(t, linklessFs{chownFs{apis.NewAVFS()}}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target")
+	require.NoError(t, os.WriteFile(target, nil, 0644))
+	require.NoError(t, os.Symlink(target, filepath.Join(dir, "existing")))
+
+	assertUnsupported := func(err error) {
+		t.Helper()
+		assert.True(t, errors.Is(err, ErrSSHFxOpUnsupported), "got %v", err)
+	}
+	assertUnsupported(client.Symlink(target, filepath.Join(dir, "symlink")))
+	assertUnsupported(client.Link(target, filepath.Join(dir, "hardlink")))
+	_, err := client.ReadLink(filepath.Join(dir, "existing"))
+	assertUnsupported(err)
+}
@@ -0,0 +1,292 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"strings"
+	"syscall"
+)
+
+// minCheckFileBlockSize is the smallest block size a server has to accept
+// for check-file requests, see draft-ietf-secsh-filexfer-extensions-00.
+const minCheckFileBlockSize = 256
+
+// checkFileHashes maps the check-file algorithm names to their
+// implementations, in the order the server prefers them.
+var checkFileHashes = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"sha256", sha256.New},
+	{"sha512", sha512.New},
+	{"sha384", sha512.New384},
+	{"sha224", sha256.New224},
+	{"sha1", sha1.New},
+	{"md5", md5.New},
+}
+
+// CheckFileResult is the reply to a check-file request.
+// Hashes holds one digest per block, or a single digest of the whole range
+// if no block size was requested.
+type CheckFileResult struct {
+	Algorithm string
+	Hashes    [][]byte
+}
+
+// selectCheckFileHash picks the first algorithm of the comma separated list
+// sent by the client that the server supports.
+func selectCheckFileHash(algorithms string) (string, func() hash.Hash, bool) {
+	for _, algo := range strings.Split(algorithms, ",") {
+		algo = strings.TrimSpace(algo)
+		for _, h := range checkFileHashes {
+			if h.name == algo {
+				return h.name, h.new, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+// selectCheckFileHashSize returns the digest size of the named algorithm.
+func selectCheckFileHashSize(algorithm string) (int, bool) {
+	_, newHash, ok := selectCheckFileHash(algorithm)
+	if !ok {
+		return 0, false
+	}
+	return newHash().Size(), true
+}
+
+// checkFile hashes the requested range of r and returns the reply packet.
+func (p *sshFxpExtendedPacketCheckFile) checkFile(r io.ReaderAt) responsePacket {
+	name, newHash, ok := selectCheckFileHash(p.Algorithms)
+	if !ok {
+		return statusFromError(p.ID, ErrSSHFxOpUnsupported)
+	}
+
+	if p.BlockSize != 0 && p.BlockSize < minCheckFileBlockSize {
+		return statusFromError(p.ID, syscall.EINVAL)
+	}
+
+	if p.Offset > uint64(math.MaxInt64) || p.Length > uint64(math.MaxInt64) {
+		return statusFromError(p.ID, syscall.EINVAL)
+	}
+
+	length := int64(p.Length)
+	if length == 0 || length > math.MaxInt64-int64(p.Offset) {
+		// a length of zero means everything up to the end of the file
+		length = math.MaxInt64 - int64(p.Offset)
+	}
+	section := io.NewSectionReader(r, int64(p.Offset), length)
+
+	h := newHash()
+	var hashes []byte
+	if p.BlockSize == 0 {
+		if _, err := io.Copy(h, section); err != nil {
+			return statusFromError(p.ID, err)
+		}
+		hashes = h.Sum(nil)
+	} else {
+		for {
+			h.Reset()
+			n, err := io.CopyN(h, section, int64(p.BlockSize))
+			if err != nil && err != io.EOF {
+				return statusFromError(p.ID, err)
+			}
+			if n == 0 {
+				break
+			}
+			hashes = h.Sum(hashes)
+
+			// the whole reply has to fit into a single packet
+			if len(hashes) > maxMsgLength-checkFileReplyOverhead-len(name) {
+				return statusFromError(p.ID, errCheckFileTooLarge)
+			}
+			if err == io.EOF {
+				break
+			}
+		}
+	}
+
+	return &sshFxpCheckFileReply{
+		ID:        p.ID,
+		Algorithm: name,
+		Hashes:    hashes,
+	}
+}
+
+var errCheckFileTooLarge = errors.New("check-file reply would exceed the maximum packet size")
+
+// checkFileReplyOverhead is the size of an sshFxpCheckFileReply without the
+// algorithm name and the hashes.
+const checkFileReplyOverhead = 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+	4 + len("check-file") +
+	4
+
+func (p *sshFxpExtendedPacketCheckFile) respond(svr *Server) responsePacket {
+	if p.Handle != "" {
+		f, ok := svr.getHandle(p.Handle)
+		if !ok {
+			return statusFromError(p.ID, EBADF)
+		}
+		return p.checkFile(f)
+	}
+
+	f, err := svr.fs.Open(toLocalPath(p.Path))
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+	defer f.Close()
+
+	return p.checkFile(f)
+}
+
+// checkFile answers check-file requests from the Handlers of the RequestServer.
+// Handles have to be opened for reading, names are opened through FileGet.
+func (rs *RequestServer) checkFile(pkt *sshFxpExtendedPacketCheckFile) responsePacket {
+	if pkt.Handle != "" {
+		request, ok := rs.getRequest(pkt.Handle)
+		if !ok {
+			return statusFromError(pkt.ID, EBADF)
+		}
+		r := request.state.getReaderAt()
+		if r == nil {
+			if rw := request.state.getWriterAtReaderAt(); rw != nil {
+				r = rw
+			}
+		}
+		if r == nil {
+			return statusFromError(pkt.ID, EBADF)
+		}
+		return pkt.checkFile(r)
+	}
+
+	request := NewRequest("Get", pkt.Path)
+	request.Flags = sshFxfRead
+	r, err := rs.Handlers.FileGet.Fileread(request)
+	if err != nil {
+		return statusFromError(pkt.ID, err)
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	return pkt.checkFile(r)
+}
+
+// checkFileWindow is the number of blocks verify hashes per check-file
+// request, which keeps the replies well below the maximum packet size.
+const checkFileWindow = 1024
+
+// readSeekerAt is a source of uploads that can be verified.
+type readSeekerAt interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// readFromVerified is ReadFrom, checking the written data against src.
+func (f *File) readFromVerified(src readSeekerAt) (int64, error) {
+	srcOffset, err := src.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	offset := f.offset
+
+	n, err := f.readFrom(src)
+	if err != nil {
+		return n, err
+	}
+	return n, f.repair(src, srcOffset, offset, n)
+}
+
+// repair compares length bytes of the file from offset with src from
+// srcOffset, and writes the blocks that differ again.
+func (f *File) repair(src io.ReaderAt, srcOffset, offset, length int64) error {
+	blockSize := int64(f.c.verifyBlockSize)
+	b := make([]byte, blockSize)
+
+	for repairs := 0; ; repairs++ {
+		bad, err := f.verify(src, srcOffset, offset, length)
+		if err != nil || len(bad) == 0 {
+			return err
+		}
+		if repairs == f.c.verifyRepairs {
+			return fmt.Errorf("sftp: %d blocks of %s still differ after %d repairs", len(bad), f.path, repairs)
+		}
+
+		for _, start := range bad {
+			n := length - start
+			if n > blockSize {
+				n = blockSize
+			}
+			if _, err := src.ReadAt(b[:n], srcOffset+start); err != nil && err != io.EOF {
+				return err
+			}
+			if _, err := f.WriteAt(b[:n], offset+start); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// verify returns where the blocks start, relative to offset, that differ
+// between length bytes of the file from offset and src from srcOffset.
+func (f *File) verify(src io.ReaderAt, srcOffset, offset, length int64) ([]int64, error) {
+	algorithms := make([]string, len(checkFileHashes))
+	for i, h := range checkFileHashes {
+		algorithms[i] = h.name
+	}
+
+	blockSize := int64(f.c.verifyBlockSize)
+	b := make([]byte, blockSize)
+
+	var bad []int64
+	for window := int64(0); window < length; window += blockSize * checkFileWindow {
+		size := length - window
+		if size > blockSize*checkFileWindow {
+			size = blockSize * checkFileWindow
+		}
+
+		// by name, as the file may be open for writing only
+		res, err := f.c.CheckFile(f.path, algorithms, offset+window, size, uint32(blockSize))
+		if err != nil {
+			return nil, err
+		}
+		_, newHash, ok := selectCheckFileHash(res.Algorithm)
+		if !ok {
+			return nil, fmt.Errorf("sftp: unknown check-file algorithm %q", res.Algorithm)
+		}
+		h := newHash()
+
+		for i := 0; int64(i)*blockSize < size; i++ {
+			start := window + int64(i)*blockSize
+			n := length - start
+			if n > blockSize {
+				n = blockSize
+			}
+			m, err := src.ReadAt(b[:n], srcOffset+start)
+			if int64(m) < n {
+				if err == nil || err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return nil, err
+			}
+
+			h.Reset()
+			h.Write(b[:n])
+			if i >= len(res.Hashes) || !bytes.Equal(h.Sum(nil), res.Hashes[i]) {
+				bad = append(bad, start)
+			}
+		}
+	}
+
+	return bad, nil
+}
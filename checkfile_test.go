@@ -0,0 +1,179 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectCheckFileHash(t *testing.T) {
+	name, _, ok := selectCheckFileHash("foo, md5,sha256")
+	assert.True(t, ok)
+	assert.Equal(t, "md5", name)
+
+	_, _, ok = selectCheckFileHash("foo,bar")
+	assert.False(t, ok)
+}
+
+func TestServerCheckFile(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	name := filepath.Join(t.TempDir(), "checkfile")
+	require.NoError(t, os.WriteFile(name, content, 0644))
+
+	_, ok := client.HasExtension("check-file")
+	assert.True(t, ok)
+
+	sum := sha256.Sum256(content)
+	res, err := client.CheckFile(name, []string{"sha256"}, 0, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "sha256", res.Algorithm)
+	assert.Equal(t, [][]byte{sum[:]}, res.Hashes)
+
+	md := md5.Sum(content[10:30])
+	res, err = client.CheckFile(name, []string{"unknown", "md5"}, 10, 20, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "md5", res.Algorithm)
+	assert.Equal(t, [][]byte{md[:]}, res.Hashes)
+
+	f, err := client.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	res, err = f.CheckFile([]string{"sha256"}, 0, 0, 256)
+	require.NoError(t, err)
+	if assert.Len(t, res.Hashes, 4) {
+		for i, h := range res.Hashes {
+			end := (i + 1) * 256
+			if end > len(content) {
+				end = len(content)
+			}
+			sum := sha256.Sum256(content[i*256 : end])
+			assert.Equal(t, sum[:], h)
+		}
+	}
+
+	_, err = f.CheckFile([]string{"sha256"}, 0, 0, 16)
+	assert.Error(t, err)
+
+	_, err = client.CheckFile(name, []string{"crc32"}, 0, 0, 0)
+	assert.Error(t, err)
+
+	_, err = client.CheckFile(filepath.Join(t.TempDir(), "missing"), []string{"sha256"}, 0, 0, 0)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRequestCheckFile(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+
+	content := "hello world"
+	_, err := putTestFile(p.cli, "/foo", content)
+	require.NoError(t, err)
+
+	sum := sha256.Sum256([]byte(content))
+	res, err := p.cli.CheckFile("/foo", []string{"sha256"}, 0, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{sum[:]}, res.Hashes)
+
+	f, err := p.cli.Open("/foo")
+	require.NoError(t, err)
+	defer f.Close()
+
+	sum = sha256.Sum256([]byte(content[6:]))
+	res, err = f.CheckFile([]string{"sha256"}, 6, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{sum[:]}, res.Hashes)
+
+	_, err = p.cli.CheckFile("/bar", []string{"sha256"}, 0, 0, 0)
+	assert.Error(t, err)
+}
+
+// corruptingFs flips a bit of the writes containing marker,
+// up to times often, like a lossy link would.
+type corruptingFs struct {
+	apis.FullFs
+	marker []byte
+
+	mu    sync.Mutex
+	times int
+}
+
+func (c *corruptingFs) OpenFile(name string, flag int, perm os.FileMode) (apis.File, error) {
+	f, err := c.FullFs.OpenFile(name, flag, perm)
+	if err != nil {
+		return f, err
+	}
+	return corruptingFile{f, c}, nil
+}
+
+type corruptingFile struct {
+	apis.File
+	fs *corruptingFs
+}
+
+func (f corruptingFile) WriteAt(b []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	if i := bytes.Index(b, f.fs.marker); i >= 0 && f.fs.times > 0 {
+		f.fs.times--
+		b = append([]byte(nil), b...)
+		b[i] ^= 1
+	}
+	f.fs.mu.Unlock()
+	return f.File.WriteAt(b, off)
+}
+
+func TestVerifiedUploads(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 300)
+	copy(content[1000:], "marker")
+
+	for _, tt := range []struct {
+		corruptions int
+		repairs     int
+		ok          bool
+	}{
+		{0, 0, true},
+		{1, 1, true},
+		{2, 1, false},
+	} {
+		backend := &corruptingFs{FullFs: apis.NewAVFS(), marker: []byte("marker"), times: tt.corruptions}
+		client, server := clientServerPairFS(t, backend, nil, UseVerifiedUploads(256, tt.repairs))
+
+		name := filepath.Join(t.TempDir(), "uploaded")
+		f, err := client.OpenFile(name, os.O_WRONLY|os.O_CREATE)
+		require.NoError(t, err)
+		n, err := f.ReadFrom(bytes.NewReader(content))
+		assert.Equal(t, int64(len(content)), n)
+		require.NoError(t, f.Close())
+
+		b, err2 := os.ReadFile(name)
+		require.NoError(t, err2)
+		if tt.ok {
+			assert.NoError(t, err, "%d corruptions, %d repairs", tt.corruptions, tt.repairs)
+			assert.Equal(t, content, b)
+		} else {
+			assert.Error(t, err, "%d corruptions, %d repairs", tt.corruptions, tt.repairs)
+			assert.NotEqual(t, content, b)
+		}
+
+		server.Close()
+		client.Close()
+	}
+
+	_, w := io.Pipe()
+	_, err := NewClientPipe(nil, w, UseVerifiedUploads(16, 1))
+	assert.Error(t, err)
+}
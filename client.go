@@ -2,12 +2,15 @@ package sftp
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	iofs "io/fs"
 	"math"
 	"path"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -39,7 +42,8 @@ type ClientOption func(*Client) error
 // If you get the error "failed to send packet header: EOF" when copying a
 // large file, try lowering this number.
 //
-// The default packet size is 32768 bytes.
+// The default packet size is 32768 bytes, or the largest size the server
+// reports through the limits@openssh.com extension.
 func MaxPacketChecked(size int) ClientOption {
 	return func(c *Client) error {
 		if size < 1 {
@@ -61,7 +65,8 @@ func MaxPacketChecked(size int) ClientOption {
 // If you get the error "failed to send packet header: EOF" when copying a
 // large file, try lowering this number.
 //
-// The default packet size is 32768 bytes.
+// The default packet size is 32768 bytes, or the largest size the server
+// reports through the limits@openssh.com extension.
 func MaxPacketUnchecked(size int) ClientOption {
 	return func(c *Client) error {
 		if size < 1 {
@@ -79,14 +84,18 @@ func MaxPacketUnchecked(size int) ClientOption {
 // If you get the error "failed to send packet header: EOF" when copying a
 // large file, try lowering this number.
 //
-// The default packet size is 32768 bytes.
+// The default packet size is 32768 bytes, or the largest size the server
+// reports through the limits@openssh.com extension.
 func MaxPacket(size int) ClientOption {
 	return MaxPacketChecked(size)
 }
 
 // MaxConcurrentRequestsPerFile sets the maximum concurrent requests allowed for a single file.
 //
-// The default maximum concurrent requests is 64.
+// The default maximum concurrent requests is 64, scaled down if the server
+// allows larger packets than 32768 bytes, see MaxPacket. Links with a long
+// round trip time may need more to keep busy, to spare a constrained server
+// cap the requests of all Files with MaxInflightRequests as well.
 func MaxConcurrentRequestsPerFile(n int) ClientOption {
 	return func(c *Client) error {
 		if n < 1 {
@@ -97,6 +106,27 @@ func MaxConcurrentRequestsPerFile(n int) ClientOption {
 	}
 }
 
+// MaxInflightRequests caps the requests the Client has outstanding at once,
+// over all Files and calls together, so that constrained servers are not
+// overwhelmed. Further requests wait until a response frees a slot.
+//
+// The slots are handed out fairly: the reads and writes of the Files take
+// turns, so that one large transfer cannot hold up those of other Files, and
+// one in eight slots is kept for the other requests, like Stat or ReadDir,
+// so that they do not wait behind the transfers at all.
+//
+// By default the number of outstanding requests is only bounded per File,
+// see MaxConcurrentRequestsPerFile.
+func MaxInflightRequests(n int) ClientOption {
+	return func(c *Client) error {
+		if n < 1 {
+			return errors.New("n must be greater or equal to 1")
+		}
+		c.slots = newRequestSlots(n)
+		return nil
+	}
+}
+
 // UseConcurrentWrites allows the Client to perform concurrent Writes.
 //
 // Using concurrency while doing writes, requires special consideration.
@@ -112,6 +142,28 @@ func UseConcurrentWrites(value bool) ClientOption {
 	}
 }
 
+// UseVerifiedUploads makes File.ReadFrom check the data it wrote with the
+// check-file extension, block by block of blockSize bytes, and write the
+// blocks that differ from the source again, up to maxRepairs times.
+// This repairs uploads over links that corrupt data along the way.
+//
+// Only sources that are an io.ReaderAt and io.Seeker can be verified,
+// the others are uploaded as without this option. The server has to support
+// check-file, blockSize has to be at least 256 bytes.
+func UseVerifiedUploads(blockSize uint32, maxRepairs int) ClientOption {
+	return func(c *Client) error {
+		if blockSize < minCheckFileBlockSize {
+			return fmt.Errorf("sftp: check-file block size %d is less than %d", blockSize, minCheckFileBlockSize)
+		}
+		if maxRepairs < 0 {
+			return fmt.Errorf("sftp: negative number of repairs %d", maxRepairs)
+		}
+		c.verifyBlockSize = blockSize
+		c.verifyRepairs = maxRepairs
+		return nil
+	}
+}
+
 // UseConcurrentReads allows the Client to perform concurrent Reads.
 //
 // Concurrent reads are generally safe to use and not using them will degrade
@@ -154,6 +206,38 @@ func UseFstat(value bool) ClientOption {
 	}
 }
 
+// UseStreamingWriteTo has File.WriteTo read the file until EOF with up to
+// window read requests in flight, without asking for its size first.
+// A window of 0 or less uses the maximum of concurrent requests.
+//
+// By default WriteTo stats the file and issues its reads for the reported
+// size at fixed offsets, which truncates or corrupts the downloads of files
+// that grow while read, or of /proc-like files which report no size or the
+// wrong one and answer with short reads. When streaming, each read follows
+// the data actually received, and a short read has the requests ahead of
+// it issued again. At most window packets are buffered at any time.
+//
+// UseConcurrentReads(false) takes precedence over this option.
+func UseStreamingWriteTo(window int) ClientOption {
+	return func(c *Client) error {
+		c.useStreamingWriteTo = true
+		c.streamWindow = window
+		return nil
+	}
+}
+
+// UseCompression compresses file contents on the wire with the first of the
+// given algorithms that the server offers as well. This requires a Server or
+// RequestServer of this package configured with the same algorithms,
+// otherwise the option has no effect. By default zstd is used, calling
+// UseCompression without any algorithms turns compression off. See Compressor.
+func UseCompression(compressors ...Compressor) ClientOption {
+	return func(c *Client) error {
+		c.compression.offered = compressors
+		return nil
+	}
+}
+
 // Client represents an SFTP session on a *ssh.ClientConn SSH connection.
 // Multiple Clients can be active on a single SSH connection, and a Client
 // may be called concurrently from multiple Goroutines.
@@ -164,6 +248,9 @@ type Client struct {
 
 	ext map[string]string // Extensions (name -> data).
 
+	maxVersion uint32 // highest protocol version offered.
+	version    uint32 // negotiated protocol version.
+
 	maxPacket             int // max packet size read or written.
 	maxConcurrentRequests int
 	nextid                uint32
@@ -173,6 +260,21 @@ type Client struct {
 	useConcurrentWrites    bool
 	useFstat               bool
 	disableConcurrentReads bool
+
+	useStreamingWriteTo bool
+	streamWindow        int // reads File.WriteTo streams with, 0 or less for the maximum
+
+	readAheadMax int // bytes File.Read may request ahead, 0 to not read ahead
+
+	verifyBlockSize uint32 // of uploads checked with check-file, 0 to not check
+	verifyRepairs   int
+
+	keepaliveInterval  time.Duration // 0 to not probe the server
+	keepaliveMaxMissed int
+
+	statVFSCache *statVFSCache // nil to always ask the server
+
+	appendMode int32 // whether the server honors SSH_FXF_APPEND, see OpenAppend, accessed atomically
 }
 
 // NewClient creates a new SFTP client on conn, using zero or more option
@@ -200,12 +302,15 @@ func NewClient(conn *ssh.Client, opts ...ClientOption) (*Client, error) {
 // NewClientPipe creates a new SFTP client given a Reader and a WriteCloser.
 // This can be used for connecting to an SFTP server over TCP/TLS or by using
 // the system's ssh client program (e.g. via exec.Command).
+// NewClientTransport also reconnects over such pipes.
 func NewClientPipe(rd io.Reader, wr io.WriteCloser, opts ...ClientOption) (*Client, error) {
 	sftp := &Client{
 		clientConn: clientConn{
 			conn: conn{
 				Reader:      rd,
 				WriteCloser: wr,
+				compression: compression{offered: defaultCompressors()},
+				pooled:      true,
 			},
 			inflight: make(map[uint32]chan<- result),
 			closed:   make(chan struct{}),
@@ -213,8 +318,7 @@ func NewClientPipe(rd io.Reader, wr io.WriteCloser, opts ...ClientOption) (*Clie
 
 		ext: make(map[string]string),
 
-		maxPacket:             1 << 15,
-		maxConcurrentRequests: 64,
+		maxVersion: sftpProtocolVersion,
 	}
 
 	for _, opt := range opts {
@@ -236,9 +340,72 @@ func NewClientPipe(rd io.Reader, wr io.WriteCloser, opts ...ClientOption) (*Clie
 	sftp.clientConn.wg.Add(1)
 	go sftp.loop()
 
+	err := sftp.negotiateCompression(func(p idmarshaler) (byte, []byte, error) {
+		return sftp.sendPacket(nil, p)
+	})
+	if err != nil {
+		sftp.Close()
+		return nil, err
+	}
+
+	if err := sftp.applyLimits(); err != nil {
+		sftp.Close()
+		return nil, err
+	}
+
+	if sftp.trace != nil {
+		sftp.trace.begin("sftp.Client")
+		sftp.propagateTrace()
+	}
+
+	if sftp.keepaliveInterval > 0 {
+		sftp.clientConn.wg.Add(1)
+		go sftp.keepalive()
+	}
+
+	if sftp.reconnect != nil && sftp.reconnect.signal != nil {
+		sftp.clientConn.wg.Add(1)
+		go sftp.reconnect.watch()
+	}
+
 	return sftp, nil
 }
 
+// negotiateCompression enables compression, if both ends support a common
+// algorithm, sending the request with roundTrip. This has to happen before
+// any other request is in flight.
+func (c *Client) negotiateCompression(roundTrip func(idmarshaler) (byte, []byte, error)) error {
+	algos, ok := c.HasExtension(compressionExtension)
+	if !ok {
+		return nil
+	}
+	comp := c.compression.choose(algos)
+	if comp == nil {
+		return nil
+	}
+
+	id := c.nextID()
+	typ, data, err := roundTrip(&sshFxpCompressionPacket{
+		ID:        id,
+		Algorithm: comp.Name(),
+	})
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case sshFxpStatus:
+		if err := normaliseError(unmarshalStatus(id, data)); err != nil {
+			// the server changed its mind, carry on without compression
+			return nil
+		}
+	default:
+		return unimplementedPacketErr(typ)
+	}
+
+	c.compression.set(comp)
+	return nil
+}
+
 // Create creates the named file mode 0666 (before umask), truncating it if it
 // already exists. If successful, methods on the returned File can be used for
 // I/O; the associated file descriptor has mode O_RDWR. If you need more
@@ -251,11 +418,55 @@ func (c *Client) Create(path string) (*File, error) {
 	return c.open(path, flags(syscall.O_RDWR|syscall.O_CREAT|syscall.O_TRUNC))
 }
 
+// ReadFile reads the named file and returns its contents, like os.ReadFile.
+// The buffer is allocated from the size the file has when it is opened, and
+// filled with the concurrent reads of File.WriteTo.
+func (c *Client) ReadFile(name string) (_ []byte, err error) {
+	defer pathError(&err, "open", name)
+
+	f, err := c.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+		if size := fi.Size(); size > 0 && size < math.MaxInt32 {
+			buf.Grow(int(size) + bytes.MinRead)
+		}
+	}
+	if _, err := f.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteFile writes data to the named file, creating it if necessary, like
+// os.WriteFile. If the file does not exist, the server creates it with the
+// mode perm, usually before its umask; otherwise it is truncated first,
+// keeping its mode. The data is written with the concurrent writes of
+// File.ReadFrom.
+func (c *Client) WriteFile(name string, data []byte, perm iofs.FileMode) (err error) {
+	defer pathError(&err, "open", name)
+
+	f, err := c.openAttrs(name, flags(syscall.O_WRONLY|syscall.O_CREAT|syscall.O_TRUNC),
+		sshFileXferAttrPermissions, marshalUint32(nil, toChmodPerm(perm)))
+	if err != nil {
+		return err
+	}
+	if _, err := f.ReadFrom(bytes.NewReader(data)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 const sftpProtocolVersion = 3 // http://tools.ietf.org/html/draft-ietf-secsh-filexfer-02
 
 func (c *Client) sendInit() error {
 	return c.clientConn.conn.sendPacket(&sshFxInitPacket{
-		Version: sftpProtocolVersion, // http://tools.ietf.org/html/draft-ietf-secsh-filexfer-02
+		Version: c.maxVersion,
 	})
 }
 
@@ -277,9 +488,11 @@ func (c *Client) recvVersion() error {
 	if err != nil {
 		return err
 	}
-	if version != sftpProtocolVersion {
-		return &unexpectedVersionErr{sftpProtocolVersion, version}
+	// the server may answer with an older version than offered, but not older than 3
+	if version < sftpProtocolVersion || version > c.maxVersion {
+		return &unexpectedVersionErr{c.maxVersion, version}
 	}
+	c.version = version
 
 	for len(data) > 0 {
 		var ext extensionPair
@@ -302,67 +515,135 @@ func (c *Client) HasExtension(name string) (string, bool) {
 	return data, ok
 }
 
-// Walk returns a new Walker rooted at root.
+// Walk returns a new Walker rooted at root. WalkDir walks with the
+// semantics of fs.WalkDir, and can follow symbolic links.
 func (c *Client) Walk(root string) *fs.Walker {
 	return fs.WalkFS(root, c)
 }
 
 // ReadDir reads the directory named by dirname and returns a list of
 // directory entries.
-func (c *Client) ReadDir(p string) ([]iofs.FileInfo, error) {
+func (c *Client) ReadDir(p string) (_ []iofs.FileInfo, err error) {
+	defer c.startOp("Client.ReadDir", p, "").done(&err)
+	defer pathError(&err, "readdir", p)
+
+	var attrs []iofs.FileInfo
+	err = c.readDir(p, func(page []iofs.FileInfo) error {
+		attrs = append(attrs, page...)
+		return nil
+	})
+	return attrs, err
+}
+
+// readDir lists the directory p, passing the entries of each SSH_FXP_NAME
+// response to page as they arrive. It stops at the first error of page.
+func (c *Client) readDir(p string, page func([]iofs.FileInfo) error) error {
 	handle, err := c.opendir(p)
+	if err != nil {
+		return err
+	}
+	defer c.close(handle)
+	for {
+		attrs, err := c.readDirPage(context.Background(), handle)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := page(attrs); err != nil {
+			return err
+		}
+	}
+}
+
+// readDirPage reads the next SSH_FXP_NAME response of the open directory
+// handle, failing with io.EOF at the end.
+func (c *Client) readDirPage(ctx context.Context, handle string) ([]iofs.FileInfo, error) {
+	count, data, err := c.readDirResponse(ctx, handle)
 	if err != nil {
 		return nil, err
 	}
-	defer c.close(handle) // this has to defer earlier than the lock below
 	var attrs []iofs.FileInfo
-	var done = false
-	for !done {
-		id := c.nextID()
-		typ, data, err1 := c.sendPacket(nil, &sshFxpReaddirPacket{
-			ID:     id,
-			Handle: handle,
-		})
-		if err1 != nil {
-			err = err1
-			done = true
-			break
+	for i := uint32(0); i < count; i++ {
+		var filename string
+		filename, data = unmarshalString(data)
+		if c.version < 4 {
+			_, data = unmarshalString(data) // discard longname
 		}
-		switch typ {
-		case sshFxpName:
-			sid, data := unmarshalUint32(data)
-			if sid != id {
-				return nil, &unexpectedIDErr{id, sid}
-			}
-			count, data := unmarshalUint32(data)
-			for i := uint32(0); i < count; i++ {
-				var filename string
-				filename, data = unmarshalString(data)
-				_, data = unmarshalString(data) // discard longname
-				var attr *FileStat
-				attr, data = unmarshalAttrs(data)
-				if filename == "." || filename == ".." {
-					continue
-				}
-				attrs = append(attrs, fileInfoFromStat(attr, path.Base(filename)))
-			}
-		case sshFxpStatus:
-			// TODO(dfc) scope warning!
-			err = normaliseError(unmarshalStatus(id, data))
-			done = true
-		default:
-			return nil, unimplementedPacketErr(typ)
+		var attr *FileStat
+		attr, data = c.unmarshalAttrs(data)
+		if filename == "." || filename == ".." {
+			continue
 		}
+		attrs = append(attrs, fileInfoFromStat(attr, path.Base(filename)))
 	}
-	if err == io.EOF {
-		err = nil
+	return attrs, nil
+}
+
+// readDirNamesPage is readDirPage for the names only, which skips the
+// attributes rather than decoding them where it can.
+func (c *Client) readDirNamesPage(ctx context.Context, handle string) ([]string, error) {
+	count, data, err := c.readDirResponse(ctx, handle)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for i := uint32(0); i < count; i++ {
+		var filename string
+		filename, data = unmarshalString(data)
+		if c.version < 4 {
+			data = skipAttrs(skipString(data)) // and the longname
+		} else {
+			_, data = c.unmarshalAttrs(data)
+		}
+		if filename == "." || filename == ".." {
+			continue
+		}
+		names = append(names, path.Base(filename))
+	}
+	return names, nil
+}
+
+// readDirResponse sends SSH_FXP_READDIR for the open directory handle, and
+// returns the count and the entries of the SSH_FXP_NAME response, failing
+// with io.EOF at the end. It gives up waiting once ctx is done.
+func (c *Client) readDirResponse(ctx context.Context, handle string) (uint32, []byte, error) {
+	id := c.nextID()
+	typ, data, err := c.sendPacketContext(ctx, nil, &sshFxpReaddirPacket{
+		ID:     id,
+		Handle: handle,
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	switch typ {
+	case sshFxpName:
+		sid, data := unmarshalUint32(data)
+		if sid != id {
+			return 0, nil, &unexpectedIDErr{id, sid}
+		}
+		count, data := unmarshalUint32(data)
+		return count, data, nil
+	case sshFxpStatus:
+		err := normaliseError(unmarshalStatus(id, data))
+		if err == nil {
+			err = io.EOF
+		}
+		return 0, nil, err
+	default:
+		return 0, nil, unimplementedPacketErr(typ)
 	}
-	return attrs, err
 }
 
 func (c *Client) opendir(path string) (string, error) {
+	return c.opendirContext(context.Background(), path)
+}
+
+// opendirContext is opendir, but gives up waiting once ctx is done.
+func (c *Client) opendirContext(ctx context.Context, path string) (string, error) {
 	id := c.nextID()
-	typ, data, err := c.sendPacket(nil, &sshFxpOpendirPacket{
+	typ, data, err := c.sendPacketContext(ctx, nil, &sshFxpOpendirPacket{
 		ID:   id,
 		Path: path,
 	})
@@ -386,7 +667,10 @@ func (c *Client) opendir(path string) (string, error) {
 
 // Stat returns a FileInfo structure describing the file specified by path 'p'.
 // If 'p' is a symbolic link, the returned FileInfo structure describes the referent file.
-func (c *Client) Stat(p string) (iofs.FileInfo, error) {
+func (c *Client) Stat(p string) (_ iofs.FileInfo, err error) {
+	defer c.startOp("Client.Stat", p, "").done(&err)
+	defer pathError(&err, "stat", p)
+
 	fs, err := c.stat(p)
 	if err != nil {
 		return nil, err
@@ -396,32 +680,47 @@ func (c *Client) Stat(p string) (iofs.FileInfo, error) {
 
 // Lstat returns a FileInfo structure describing the file specified by path 'p'.
 // If 'p' is a symbolic link, the returned FileInfo structure describes the symbolic link.
-func (c *Client) Lstat(p string) (iofs.FileInfo, error) {
-	id := c.nextID()
-	typ, data, err := c.sendPacket(nil, &sshFxpLstatPacket{
-		ID:   id,
-		Path: p,
-	})
+func (c *Client) Lstat(p string) (_ iofs.FileInfo, err error) {
+	defer c.startOp("Client.Lstat", p, "").done(&err)
+	defer pathError(&err, "lstat", p)
+
+	fs, err := c.lstat(p)
 	if err != nil {
 		return nil, err
 	}
-	switch typ {
-	case sshFxpAttrs:
-		sid, data := unmarshalUint32(data)
-		if sid != id {
-			return nil, &unexpectedIDErr{id, sid}
-		}
-		attr, _ := unmarshalAttrs(data)
-		return fileInfoFromStat(attr, path.Base(p)), nil
-	case sshFxpStatus:
-		return nil, normaliseError(unmarshalStatus(id, data))
-	default:
-		return nil, unimplementedPacketErr(typ)
+	return fileInfoFromStat(fs, path.Base(p)), nil
+}
+
+// StatNoCache is like Stat, but always asks the server, bypassing the caches
+// of WithStatCache and WithNegativeStatCache, which it refreshes.
+func (c *Client) StatNoCache(p string) (_ iofs.FileInfo, err error) {
+	defer c.startOp("Client.Stat", p, "").done(&err)
+	defer pathError(&err, "stat", p)
+
+	fs, err := c.uncachedStat(p, false)
+	if err != nil {
+		return nil, err
 	}
+	return fileInfoFromStat(fs, path.Base(p)), nil
+}
+
+// LstatNoCache is like Lstat, but always asks the server, bypassing the
+// caches of WithStatCache and WithNegativeStatCache, which it refreshes.
+func (c *Client) LstatNoCache(p string) (_ iofs.FileInfo, err error) {
+	defer c.startOp("Client.Lstat", p, "").done(&err)
+	defer pathError(&err, "lstat", p)
+
+	fs, err := c.uncachedStat(p, true)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfoFromStat(fs, path.Base(p)), nil
 }
 
 // ReadLink reads the target of a symbolic link.
-func (c *Client) ReadLink(p string) (string, error) {
+func (c *Client) ReadLink(p string) (_ string, err error) {
+	defer pathError(&err, "readlink", p)
+
 	id := c.nextID()
 	typ, data, err := c.sendPacket(nil, &sshFxpReadlinkPacket{
 		ID:   id,
@@ -441,7 +740,7 @@ func (c *Client) ReadLink(p string) (string, error) {
 			return "", unexpectedCount(1, count)
 		}
 		filename, _ := unmarshalString(data) // ignore dummy attributes
-		return filename, nil
+		return c.paths.returnedLink(filename), nil
 	case sshFxpStatus:
 		return "", normaliseError(unmarshalStatus(id, data))
 	default:
@@ -450,7 +749,9 @@ func (c *Client) ReadLink(p string) (string, error) {
 }
 
 // Link creates a hard link at 'newname', pointing at the same inode as 'oldname'
-func (c *Client) Link(oldname, newname string) error {
+func (c *Client) Link(oldname, newname string) (err error) {
+	defer linkError(&err, "link", oldname, newname)
+
 	id := c.nextID()
 	typ, data, err := c.sendPacket(nil, &sshFxpHardlinkPacket{
 		ID:      id,
@@ -469,13 +770,24 @@ func (c *Client) Link(oldname, newname string) error {
 }
 
 // Symlink creates a symbolic link at 'newname', pointing at target 'oldname'
-func (c *Client) Symlink(oldname, newname string) error {
+func (c *Client) Symlink(oldname, newname string) (err error) {
+	defer linkError(&err, "symlink", oldname, newname)
+
 	id := c.nextID()
-	typ, data, err := c.sendPacket(nil, &sshFxpSymlinkPacket{
+	var pkt idmarshaler = &sshFxpSymlinkPacket{
 		ID:         id,
 		Linkpath:   newname,
 		Targetpath: oldname,
-	})
+	}
+	if c.version >= 6 {
+		pkt = &sshFxpLinkPacket{
+			ID:           id,
+			NewLinkPath:  newname,
+			ExistingPath: oldname,
+			Symlink:      true,
+		}
+	}
+	typ, data, err := c.sendPacket(nil, pkt)
 	if err != nil {
 		return err
 	}
@@ -488,6 +800,7 @@ func (c *Client) Symlink(oldname, newname string) error {
 }
 
 func (c *Client) setfstat(handle string, flags uint32, attrs interface{}) error {
+	flags, attrs = c.setstatAttrs(flags, attrs)
 	id := c.nextID()
 	typ, data, err := c.sendPacket(nil, &sshFxpFsetstatPacket{
 		ID:     id,
@@ -508,6 +821,7 @@ func (c *Client) setfstat(handle string, flags uint32, attrs interface{}) error
 
 // setstat is a convience wrapper to allow for changing of various parts of the file descriptor.
 func (c *Client) setstat(path string, flags uint32, attrs interface{}) error {
+	flags, attrs = c.setstatAttrs(flags, attrs)
 	id := c.nextID()
 	typ, data, err := c.sendPacket(nil, &sshFxpSetstatPacket{
 		ID:    id,
@@ -527,22 +841,28 @@ func (c *Client) setstat(path string, flags uint32, attrs interface{}) error {
 }
 
 // Chtimes changes the access and modification times of the named file.
-func (c *Client) Chtimes(path string, atime time.Time, mtime time.Time) error {
-	type times struct {
-		Atime uint32
-		Mtime uint32
-	}
-	attrs := times{uint32(atime.Unix()), uint32(mtime.Unix())}
-	return c.setstat(path, sshFileXferAttrACmodTime, attrs)
+//
+// The times keep their nanoseconds with protocol version 4 or later, see
+// WithProtocolVersion, and with servers supporting the
+// times@github.com/pkg/sftp extension, as the Server of this package does.
+// Otherwise they are truncated to whole seconds.
+func (c *Client) Chtimes(path string, atime time.Time, mtime time.Time) (err error) {
+	defer pathError(&err, "chtimes", path)
+
+	return c.setstat(path, sshFileXferAttrACmodTime, acModTimes{atime, mtime})
+}
+
+// uidGID are the attributes set by Chown.
+type uidGID struct {
+	UID uint32
+	GID uint32
 }
 
 // Chown changes the user and group owners of the named file.
-func (c *Client) Chown(path string, uid, gid int) error {
-	type owner struct {
-		UID uint32
-		GID uint32
-	}
-	attrs := owner{uint32(uid), uint32(gid)}
+func (c *Client) Chown(path string, uid, gid int) (err error) {
+	defer pathError(&err, "chown", path)
+
+	attrs := uidGID{uint32(uid), uint32(gid)}
 	return c.setstat(path, sshFileXferAttrUIDGID, attrs)
 }
 
@@ -551,7 +871,9 @@ func (c *Client) Chown(path string, uid, gid int) error {
 // Chmod does not apply a umask, because even retrieving the umask is not
 // possible in a portable way without causing a race condition. Callers
 // should mask off umask bits, if desired.
-func (c *Client) Chmod(path string, mode iofs.FileMode) error {
+func (c *Client) Chmod(path string, mode iofs.FileMode) (err error) {
+	defer pathError(&err, "chmod", path)
+
 	return c.setstat(path, sshFileXferAttrPermissions, toChmodPerm(mode))
 }
 
@@ -559,7 +881,12 @@ func (c *Client) Chmod(path string, mode iofs.FileMode) error {
 // that if the size is less than its current size it will be truncated to fit,
 // the SFTP protocol does not specify what behavior the server should do when setting
 // size greater than the current size.
-func (c *Client) Truncate(path string, size int64) error {
+func (c *Client) Truncate(path string, size int64) (err error) {
+	defer pathError(&err, "truncate", path)
+
+	if size < 0 {
+		return iofs.ErrInvalid
+	}
 	return c.setstat(path, sshFileXferAttrSize, uint64(size))
 }
 
@@ -573,17 +900,29 @@ func (c *Client) Open(path string) (*File, error) {
 // OpenFile is the generalized open call; most users will use Open or
 // Create instead. It opens the named file with specified flag (O_RDONLY
 // etc.). If successful, methods on the returned File can be used for I/O.
+//
+// Of the flags of package os, O_RDONLY, O_WRONLY, O_RDWR, O_APPEND,
+// O_CREATE, O_TRUNC and O_EXCL are passed on, the others are ignored.
+// O_TRUNC without O_CREATE truncates the file only if it exists. See
+// OpenFileFlags for the flags of later protocol versions, and
+// CreateExclusive for how to tell an existing file apart from other failures
+// of O_EXCL.
 func (c *Client) OpenFile(path string, f int) (*File, error) {
 	return c.open(path, flags(f))
 }
 
 func (c *Client) open(path string, pflags uint32) (*File, error) {
+	return c.openAttrs(path, pflags, 0, nil)
+}
+
+// openAttrs is open, passing the attributes attrs flagged by attrFlags
+// for the server to apply to a file it creates.
+func (c *Client) openAttrs(path string, pflags, attrFlags uint32, attrs []byte) (_ *File, err error) {
+	defer c.startOp("Client.Open", path, "").done(&err)
+	defer pathError(&err, "open", path)
+
 	id := c.nextID()
-	typ, data, err := c.sendPacket(nil, &sshFxpOpenPacket{
-		ID:     id,
-		Path:   path,
-		Pflags: pflags,
-	})
+	typ, data, err := c.sendPacket(nil, c.openPacket(id, path, pflags, attrFlags, attrs))
 	if err != nil {
 		return nil, err
 	}
@@ -594,6 +933,12 @@ func (c *Client) open(path string, pflags uint32) (*File, error) {
 			return nil, &unexpectedIDErr{id, sid}
 		}
 		handle, _ := unmarshalString(data)
+		if c.reconnect != nil {
+			handle = c.reconnect.track(path, pflags, handle)
+		}
+		if c.attrCache != nil {
+			c.attrCache.opened(handle, path)
+		}
 		return &File{c: c, path: path, handle: handle}, nil
 	case sshFxpStatus:
 		return nil, normaliseError(unmarshalStatus(id, data))
@@ -623,11 +968,52 @@ func (c *Client) close(handle string) error {
 }
 
 func (c *Client) stat(path string) (*FileStat, error) {
+	return c.cachedStat(path, false)
+}
+
+func (c *Client) lstat(path string) (*FileStat, error) {
+	return c.cachedStat(path, true)
+}
+
+// cachedStat answers stat, or lstat, from the cache of WithStatCache and
+// WithNegativeStatCache if it can, and asks the server otherwise.
+func (c *Client) cachedStat(path string, lstat bool) (*FileStat, error) {
+	if c.attrCache == nil {
+		return c.statRemote(path, lstat)
+	}
+	if e, ok := c.attrCache.lookup(path, lstat); ok {
+		if e.err != nil {
+			return nil, e.err
+		}
+		return &e.stat, nil
+	}
+	return c.uncachedStat(path, lstat)
+}
+
+// uncachedStat asks the server for stat, or lstat, refreshing the cache.
+func (c *Client) uncachedStat(path string, lstat bool) (*FileStat, error) {
+	if c.attrCache == nil {
+		return c.statRemote(path, lstat)
+	}
+	gen := c.attrCache.generation()
+	fs, err := c.statRemote(path, lstat)
+	c.attrCache.store(gen, path, lstat, fs, err)
+	return fs, err
+}
+
+func (c *Client) statRemote(path string, lstat bool) (*FileStat, error) {
 	id := c.nextID()
-	typ, data, err := c.sendPacket(nil, &sshFxpStatPacket{
+	var p idmarshaler = &sshFxpStatPacket{
 		ID:   id,
 		Path: path,
-	})
+	}
+	if lstat {
+		p = &sshFxpLstatPacket{
+			ID:   id,
+			Path: path,
+		}
+	}
+	typ, data, err := c.sendPacket(nil, c.statPacket(p))
 	if err != nil {
 		return nil, err
 	}
@@ -637,7 +1023,7 @@ func (c *Client) stat(path string) (*FileStat, error) {
 		if sid != id {
 			return nil, &unexpectedIDErr{id, sid}
 		}
-		attr, _ := unmarshalAttrs(data)
+		attr, _ := c.unmarshalAttrs(data)
 		return attr, nil
 	case sshFxpStatus:
 		return nil, normaliseError(unmarshalStatus(id, data))
@@ -648,10 +1034,10 @@ func (c *Client) stat(path string) (*FileStat, error) {
 
 func (c *Client) fstat(handle string) (*FileStat, error) {
 	id := c.nextID()
-	typ, data, err := c.sendPacket(nil, &sshFxpFstatPacket{
+	typ, data, err := c.sendPacket(nil, c.statPacket(&sshFxpFstatPacket{
 		ID:     id,
 		Handle: handle,
-	})
+	}))
 	if err != nil {
 		return nil, err
 	}
@@ -661,7 +1047,7 @@ func (c *Client) fstat(handle string) (*FileStat, error) {
 		if sid != id {
 			return nil, &unexpectedIDErr{id, sid}
 		}
-		attr, _ := unmarshalAttrs(data)
+		attr, _ := c.unmarshalAttrs(data)
 		return attr, nil
 	case sshFxpStatus:
 		return nil, normaliseError(unmarshalStatus(id, data))
@@ -674,7 +1060,17 @@ func (c *Client) fstat(handle string) (*FileStat, error) {
 //
 // It implements the statvfs@openssh.com SSH_FXP_EXTENDED feature
 // from http://www.opensource.apple.com/source/OpenSSH/OpenSSH-175/openssh/PROTOCOL?txt.
-func (c *Client) StatVFS(path string) (*StatVFS, error) {
+// With WithStatVFSCache, it answers from cache while it can.
+func (c *Client) StatVFS(path string) (_ *StatVFS, err error) {
+	defer pathError(&err, "statvfs", path)
+
+	if c.statVFSCache != nil {
+		return c.statVFSCache.get(path, c.statVFS)
+	}
+	return c.statVFS(path)
+}
+
+func (c *Client) statVFS(path string) (*StatVFS, error) {
 	// send the StatVFS packet to the server
 	id := c.nextID()
 	typ, data, err := c.sendPacket(nil, &sshFxpStatvfsPacket{
@@ -705,6 +1101,115 @@ func (c *Client) StatVFS(path string) (*StatVFS, error) {
 	}
 }
 
+// CheckFile asks the server to compute a digest of the file at path using
+// the first of the given algorithms (e.g. "sha256", "md5") it supports.
+// Only length bytes starting at offset are hashed, a length of 0 hashes up to
+// the end of the file. If blockSize is not 0, a digest is returned for each
+// block of blockSize bytes, otherwise a single digest for the whole range.
+//
+// CheckFile requires the server to support the check-file extension.
+func (c *Client) CheckFile(path string, algorithms []string, offset, length int64, blockSize uint32) (_ *CheckFileResult, err error) {
+	defer pathError(&err, "checkfile", path)
+
+	return c.checkFile(&sshFxpCheckFilePacket{
+		Path:       path,
+		Algorithms: strings.Join(algorithms, ","),
+		Offset:     uint64(offset),
+		Length:     uint64(length),
+		BlockSize:  blockSize,
+	})
+}
+
+func (c *Client) checkFile(p *sshFxpCheckFilePacket) (*CheckFileResult, error) {
+	if p.Offset > math.MaxInt64 || p.Length > math.MaxInt64 {
+		return nil, iofs.ErrInvalid
+	}
+
+	id := c.nextID()
+	p.ID = id
+	typ, data, err := c.sendPacket(nil, p)
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case sshFxpExtendedReply:
+		sid, data, err := unmarshalUint32Safe(data)
+		if err != nil {
+			return nil, err
+		}
+		if sid != id {
+			return nil, &unexpectedIDErr{id, sid}
+		}
+
+		// the reply repeats the extension name
+		if _, data, err = unmarshalStringSafe(data); err != nil {
+			return nil, err
+		}
+
+		algo, data, err := unmarshalStringSafe(data)
+		if err != nil {
+			return nil, err
+		}
+
+		h, ok := selectCheckFileHashSize(algo)
+		if !ok {
+			return nil, fmt.Errorf("sftp: unknown check-file algorithm %q", algo)
+		}
+		if len(data)%h != 0 {
+			return nil, errors.New("sftp: malformed check-file reply")
+		}
+
+		result := &CheckFileResult{Algorithm: algo}
+		for ; len(data) > 0; data = data[h:] {
+			result.Hashes = append(result.Hashes, data[:h:h])
+		}
+		return result, nil
+
+	case sshFxpStatus:
+		return nil, normaliseError(unmarshalStatus(id, data))
+
+	default:
+		return nil, unimplementedPacketErr(typ)
+	}
+}
+
+// DirStats returns the number of entries and the total size of the regular
+// files below the directory at path in a single round trip.
+//
+// DirStats requires the server to support the dir-stats@github.com/pkg/sftp
+// extension, as the Server and RequestServer of this package do.
+func (c *Client) DirStats(path string) (_ *DirStats, err error) {
+	defer pathError(&err, "dirstats", path)
+
+	id := c.nextID()
+	typ, data, err := c.sendPacket(nil, &sshFxpDirStatsPacket{
+		ID:   id,
+		Path: path,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case sshFxpExtendedReply:
+		sid, data, err := unmarshalUint32Safe(data)
+		if err != nil {
+			return nil, err
+		}
+		if sid != id {
+			return nil, &unexpectedIDErr{id, sid}
+		}
+		return unmarshalDirStats(data)
+
+	case sshFxpStatus:
+		return nil, normaliseError(unmarshalStatus(id, data))
+
+	default:
+		return nil, unimplementedPacketErr(typ)
+	}
+}
+
 // Join joins any number of path elements into a single path, adding a
 // separating slash if necessary. The result is Cleaned; in particular, all
 // empty strings are ignored.
@@ -713,14 +1218,17 @@ func (c *Client) Join(elem ...string) string { return path.Join(elem...) }
 // Remove removes the specified file or directory. An error will be returned if no
 // file or directory with the specified path exists, or if the specified directory
 // is not empty.
-func (c *Client) Remove(path string) error {
-	err := c.removeFile(path)
+func (c *Client) Remove(path string) (err error) {
+	defer c.startOp("Client.Remove", path, "").done(&err)
+	defer pathError(&err, "remove", path)
+
+	err = c.removeFile(path)
 	// some servers, *cough* osx *cough*, return EPERM, not ENODIR.
 	// serv-u returns ssh_FX_FILE_IS_A_DIRECTORY
 	if err, ok := err.(*StatusError); ok {
 		switch err.Code {
 		case sshFxFailure, sshFxFileIsADirectory:
-			return c.RemoveDirectory(path)
+			return c.removeDirectory(path)
 		}
 	}
 	return err
@@ -744,7 +1252,14 @@ func (c *Client) removeFile(path string) error {
 }
 
 // RemoveDirectory removes a directory path.
-func (c *Client) RemoveDirectory(path string) error {
+func (c *Client) RemoveDirectory(path string) (err error) {
+	defer c.startOp("Client.RemoveDirectory", path, "").done(&err)
+	defer pathError(&err, "rmdir", path)
+
+	return c.removeDirectory(path)
+}
+
+func (c *Client) removeDirectory(path string) error {
 	id := c.nextID()
 	typ, data, err := c.sendPacket(nil, &sshFxpRmdirPacket{
 		ID:   id,
@@ -762,13 +1277,12 @@ func (c *Client) RemoveDirectory(path string) error {
 }
 
 // Rename renames a file.
-func (c *Client) Rename(oldname, newname string) error {
+func (c *Client) Rename(oldname, newname string) (err error) {
+	defer c.startOp("Client.Rename", oldname, "").done(&err)
+	defer linkError(&err, "rename", oldname, newname)
+
 	id := c.nextID()
-	typ, data, err := c.sendPacket(nil, &sshFxpRenamePacket{
-		ID:      id,
-		Oldpath: oldname,
-		Newpath: newname,
-	})
+	typ, data, err := c.sendPacket(nil, c.renamePacket(id, oldname, newname, 0))
 	if err != nil {
 		return err
 	}
@@ -782,13 +1296,22 @@ func (c *Client) Rename(oldname, newname string) error {
 
 // PosixRename renames a file using the posix-rename@openssh.com extension
 // which will replace newname if it already exists.
-func (c *Client) PosixRename(oldname, newname string) error {
+// Servers speaking protocol version 5 or later without the extension
+// are asked for an overwriting, atomic rename instead.
+func (c *Client) PosixRename(oldname, newname string) (err error) {
+	defer c.startOp("Client.PosixRename", oldname, "").done(&err)
+	defer linkError(&err, "rename", oldname, newname)
+
 	id := c.nextID()
-	typ, data, err := c.sendPacket(nil, &sshFxpPosixRenamePacket{
+	var pkt idmarshaler = &sshFxpPosixRenamePacket{
 		ID:      id,
 		Oldpath: oldname,
 		Newpath: newname,
-	})
+	}
+	if _, ok := c.HasExtension("posix-rename@openssh.com"); !ok && c.version >= 5 {
+		pkt = c.renamePacket(id, oldname, newname, sshFxfRenameOverwrite|sshFxfRenameAtomic)
+	}
+	typ, data, err := c.sendPacket(nil, pkt)
 	if err != nil {
 		return err
 	}
@@ -800,16 +1323,75 @@ func (c *Client) PosixRename(oldname, newname string) error {
 	}
 }
 
+// RenameOverwrite renames oldname to newname, replacing newname if it exists
+// and is not a directory. It is PosixRename where the server supports it.
+// Elsewhere newname is moved aside to a backup name first, which is moved
+// back if the rename fails, and removed once it succeeded.
+func (c *Client) RenameOverwrite(oldname, newname string) (err error) {
+	defer linkError(&err, "rename", oldname, newname)
+
+	if _, ok := c.HasExtension("posix-rename@openssh.com"); ok || c.version >= 5 {
+		return c.PosixRename(oldname, newname)
+	}
+
+	defer c.startOp("Client.RenameOverwrite", oldname, "").done(&err)
+
+	err = c.Rename(oldname, newname)
+	if err == nil {
+		return nil
+	}
+	fi, statErr := c.Lstat(newname)
+	if statErr != nil || fi.IsDir() {
+		// nothing to replace
+		return err
+	}
+
+	backup := fmt.Sprintf("%s.%d.sftp-rename", newname, c.nextID())
+	if err := c.Rename(newname, backup); err != nil {
+		return err
+	}
+	if err := c.Rename(oldname, newname); err != nil {
+		if restoreErr := c.Rename(backup, newname); restoreErr != nil {
+			return fmt.Errorf("%w, and restoring %s from %s failed: %v", err, newname, backup, restoreErr)
+		}
+		return err
+	}
+	if err := c.removeFile(backup); err != nil {
+		return fmt.Errorf("renamed, but removing the replaced %s at %s failed: %w", newname, backup, err)
+	}
+	return nil
+}
+
 // RealPath can be used to have the server canonicalize any given path name to an absolute path.
 //
 // This is useful for converting path names containing ".." components,
 // or relative pathnames without a leading slash into absolute paths.
-func (c *Client) RealPath(path string) (string, error) {
+func (c *Client) RealPath(path string) (_ string, err error) {
+	defer pathError(&err, "realpath", path)
+
+	id := c.nextID()
+	return c.resolvePath(id, &sshFxpRealpathPacket{
+		ID:   id,
+		Path: path,
+	})
+}
+
+// ExpandPath is like RealPath, but also expands a leading "~" or "~user"
+// to the home directory of the user on the server.
+// The server has to support the expand-path@openssh.com extension.
+func (c *Client) ExpandPath(path string) (_ string, err error) {
+	defer pathError(&err, "expandpath", path)
+
 	id := c.nextID()
-	typ, data, err := c.sendPacket(nil, &sshFxpRealpathPacket{
+	return c.resolvePath(id, &sshFxpExpandPathPacket{
 		ID:   id,
 		Path: path,
 	})
+}
+
+// resolvePath sends pkt, which is answered with a single name.
+func (c *Client) resolvePath(id uint32, pkt idmarshaler) (string, error) {
+	typ, data, err := c.sendPacket(nil, pkt)
 	if err != nil {
 		return "", err
 	}
@@ -824,7 +1406,7 @@ func (c *Client) RealPath(path string) (string, error) {
 			return "", unexpectedCount(1, count)
 		}
 		filename, _ := unmarshalString(data) // ignore attributes
-		return filename, nil
+		return c.paths.returned(filename), nil
 	case sshFxpStatus:
 		return "", normaliseError(unmarshalStatus(id, data))
 	default:
@@ -841,12 +1423,12 @@ func (c *Client) Getwd() (string, error) {
 // Mkdir creates the specified directory. An error will be returned if a file or
 // directory with the specified path already exists, or if the directory's
 // parent folder does not exist (the method cannot create complete paths).
-func (c *Client) Mkdir(path string) error {
+func (c *Client) Mkdir(path string) (err error) {
+	defer c.startOp("Client.Mkdir", path, "").done(&err)
+	defer pathError(&err, "mkdir", path)
+
 	id := c.nextID()
-	typ, data, err := c.sendPacket(nil, &sshFxpMkdirPacket{
-		ID:   id,
-		Path: path,
-	})
+	typ, data, err := c.sendPacket(nil, c.mkdirPacket(id, path))
 	if err != nil {
 		return err
 	}
@@ -862,7 +1444,26 @@ func (c *Client) Mkdir(path string) error {
 // and returns nil, or else returns an error.
 // If path is already a directory, MkdirAll does nothing and returns nil.
 // If path contains a regular file, an error is returned
-func (c *Client) MkdirAll(path string) error {
+//
+// Directories created concurrently by someone else, e.g. by parallel uploads
+// into the same prefix, are taken as they are.
+func (c *Client) MkdirAll(path string) (err error) {
+	defer pathError(&err, "mkdir", path)
+
+	return c.mkdirAll(path, nil)
+}
+
+// MkdirAllMode is MkdirAll, but sets the permission bits of the directories
+// it creates to perm, regardless of the umask of the server. Directories that
+// exist already, or were created by someone else meanwhile, are left alone.
+func (c *Client) MkdirAllMode(path string, perm iofs.FileMode) (err error) {
+	defer pathError(&err, "mkdir", path)
+
+	return c.mkdirAll(path, &perm)
+}
+
+// mkdirAll implements MkdirAll, applying perm to the created directories if set.
+func (c *Client) mkdirAll(path string, perm *iofs.FileMode) error {
 	// Most of this code mimics https://golang.org/src/os/path.go?s=514:561#L13
 	// Fast path: if we can tell whether path is a directory or file, stop with success or error.
 	dir, err := c.Stat(path)
@@ -886,7 +1487,7 @@ func (c *Client) MkdirAll(path string) error {
 
 	if j > 1 {
 		// Create parent
-		err = c.MkdirAll(path[0 : j-1])
+		err = c.mkdirAll(path[0:j-1], perm)
 		if err != nil {
 			return err
 		}
@@ -895,14 +1496,19 @@ func (c *Client) MkdirAll(path string) error {
 	// Parent now exists; invoke Mkdir and use its result.
 	err = c.Mkdir(path)
 	if err != nil {
-		// Handle arguments like "foo/." by
-		// double-checking that directory doesn't exist.
-		dir, err1 := c.Lstat(path)
+		// Handle arguments like "foo/." and directories created meanwhile
+		// by double-checking that directory doesn't exist. Stat rather than
+		// Lstat, to accept symbolic links to directories like the fast path.
+		dir, err1 := c.Stat(path)
 		if err1 == nil && dir.IsDir() {
 			return nil
 		}
 		return err
 	}
+
+	if perm != nil {
+		return c.Chmod(path, *perm)
+	}
 	return nil
 }
 
@@ -913,13 +1519,71 @@ type File struct {
 	handle string
 
 	mu     sync.Mutex
-	offset int64 // current offset within remote file
+	offset int64      // current offset within remote file
+	ra     *readAhead // of Read, if the Client reads ahead
+	append bool       // the server appends the writes, which go one at a time
+
+	readDeadline  fileDeadline
+	writeDeadline fileDeadline
+
+	closed int32 // set once Close is called, accessed atomically
+}
+
+// SetReadDeadline sets the deadline for the read requests of the File, like
+// net.Conn does: any read request still outstanding once t has passed, even
+// if it was sent before the deadline was set, fails with an error wrapping
+// os.ErrDeadlineExceeded. Extending the deadline before it passes keeps the
+// outstanding requests waiting. Requests given up on are left to complete on
+// the server, their responses are discarded when they arrive.
+// A zero value for t means reads do not time out.
+func (f *File) SetReadDeadline(t time.Time) error {
+	f.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for the write requests of the File,
+// in the same way as SetReadDeadline does for reads.
+// Even if a write times out, the data may still have been written.
+// A zero value for t means writes do not time out.
+func (f *File) SetWriteDeadline(t time.Time) error {
+	f.writeDeadline.set(t)
+	return nil
+}
+
+// sendPacketDeadline sends request p of the File and waits for its
+// response, giving up once the deadline d has passed.
+func (f *File) sendPacketDeadline(ch chan result, p idmarshaler, d *fileDeadline) (byte, []byte, error) {
+	if cap(ch) < 1 {
+		ch = make(chan result, 1)
+	}
+
+	f.c.dispatchRequestDeadline(ch, p, d.deadline())
+	s := f.c.awaitCancel(ch, p.id(), d.wait())
+	return s.typ, s.data, s.err
 }
 
 // Close closes the File, rendering it unusable for I/O. It returns an
-// error, if any.
-func (f *File) Close() error {
-	return f.c.close(f.handle)
+// error, if any, ErrHandleClosed if it was closed already.
+func (f *File) Close() (err error) {
+	defer f.c.startOp("File.Close", f.path, f.handle).done(&err)
+	defer pathError(&err, "close", f.path)
+	if !atomic.CompareAndSwapInt32(&f.closed, 0, 1) {
+		return ErrHandleClosed
+	}
+
+	err = f.c.close(f.handle)
+	if f.c.reconnect != nil {
+		f.c.reconnect.untrack(f.handle)
+	}
+	return err
+}
+
+// checkClosed returns ErrHandleClosed once the File was closed.
+func (f *File) checkClosed() error {
+	if atomic.LoadInt32(&f.closed) != 0 {
+		return ErrHandleClosed
+	}
+	return nil
 }
 
 // Name returns the name of the file as presented to Open or Create.
@@ -935,11 +1599,20 @@ func (f *File) Name() string {
 // To maximise throughput for transferring the entire file (especially
 // over high latency links) it is recommended to use WriteTo rather
 // than calling Read multiple times. io.Copy will do this
-// automatically.
-func (f *File) Read(b []byte) (int, error) {
+// automatically. Where that is not an option, see UseReadAhead.
+func (f *File) Read(b []byte) (_ int, err error) {
+	defer pathError(&err, "read", f.path)
+	if err = f.checkClosed(); err != nil {
+		return 0, err
+	}
+
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	if f.c.readAheadMax > 0 {
+		return f.readStream(b)
+	}
+
 	n, err := f.ReadAt(b, f.offset)
 	f.offset += int64(n)
 	return n, err
@@ -949,13 +1622,20 @@ func (f *File) Read(b []byte) (int, error) {
 // It will continue progressively reading into the buffer until it fills the whole buffer, or an error occurs.
 func (f *File) readChunkAt(ch chan result, b []byte, off int64) (n int, err error) {
 	for err == nil && n < len(b) {
+		// never ask for more than fits into a single packet,
+		// this also keeps the length from overflowing the uint32 on the wire.
+		l := len(b) - n
+		if l > f.c.maxPacket {
+			l = f.c.maxPacket
+		}
+
 		id := f.c.nextID()
-		typ, data, err := f.c.sendPacket(ch, &sshFxpReadPacket{
+		typ, data, err := f.sendPacketDeadline(ch, &sshFxpReadPacket{
 			ID:     id,
 			Handle: f.handle,
 			Offset: uint64(off) + uint64(n),
-			Len:    uint32(len(b) - n),
-		})
+			Len:    uint32(l),
+		}, &f.readDeadline)
 		if err != nil {
 			return n, err
 		}
@@ -965,13 +1645,18 @@ func (f *File) readChunkAt(ch chan result, b []byte, off int64) (n int, err erro
 			return n, normaliseError(unmarshalStatus(id, data))
 
 		case sshFxpData:
+			page := data
 			sid, data := unmarshalUint32(data)
 			if id != sid {
 				return n, &unexpectedIDErr{id, sid}
 			}
 
 			l, data := unmarshalUint32(data)
+			if int64(l) > int64(len(data)) {
+				return n, errShortPacket
+			}
 			n += copy(b[n:], data[:l])
+			putPage(page)
 
 		default:
 			return n, unimplementedPacketErr(typ)
@@ -1007,7 +1692,18 @@ func (f *File) readAtSequential(b []byte, off int64) (read int, err error) {
 // ReadAt reads up to len(b) byte from the File at a given offset `off`. It returns
 // the number of bytes read and an error, if any. ReadAt follows io.ReaderAt semantics,
 // so the file offset is not altered during the read.
-func (f *File) ReadAt(b []byte, off int64) (int, error) {
+func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
+	op := f.c.startOp("File.ReadAt", f.path, f.handle)
+	defer func() { op.end(int64(n), err) }()
+	defer pathError(&err, "read", f.path)
+	if err = f.checkClosed(); err != nil {
+		return 0, err
+	}
+
+	if off < 0 {
+		return 0, iofs.ErrInvalid
+	}
+
 	if len(b) <= f.c.maxPacket {
 		// This should be able to be serviced with 1/2 requests.
 		// So, just do it directly.
@@ -1091,7 +1787,7 @@ func (f *File) ReadAt(b []byte, off int64) (int, error) {
 			for packet := range workCh {
 				var n int
 
-				s := <-packet.res
+				s := f.c.awaitCancel(packet.res, packet.id, f.readDeadline.wait())
 				resPool.Put(packet.res)
 
 				err := s.err
@@ -1105,9 +1801,12 @@ func (f *File) ReadAt(b []byte, off int64) (int, error) {
 						if packet.id != sid {
 							err = &unexpectedIDErr{packet.id, sid}
 
+						} else if l, data := unmarshalUint32(data); int64(l) > int64(len(data)) {
+							err = errShortPacket
+
 						} else {
-							l, data := unmarshalUint32(data)
 							n = copy(packet.b, data[:l])
+							putPage(s.data)
 
 							// For normal disk files, it is guaranteed that this will read
 							// the specified number of bytes, or up to end of file.
@@ -1193,6 +1892,90 @@ func (f *File) writeToSequential(w io.Writer) (written int64, err error) {
 	}
 }
 
+// writeToStream writes the file from the current offset to w until EOF,
+// keeping up to window read requests in flight for the offsets after the
+// data received so far.
+func (f *File) writeToStream(w io.Writer, window int) (written int64, err error) {
+	type streamRead struct {
+		id  uint32
+		res chan result
+		off int64
+	}
+	var queue []streamRead
+
+	chunkSize := f.c.maxPacket
+	issue := f.offset
+	for {
+		for len(queue) < window {
+			req := streamRead{
+				id:  f.c.nextID(),
+				res: make(chan result, 1),
+				off: issue,
+			}
+			f.c.dispatchRequest(req.res, &sshFxpReadPacket{
+				ID:     req.id,
+				Handle: f.handle,
+				Offset: uint64(req.off),
+				Len:    uint32(chunkSize),
+			})
+			queue = append(queue, req)
+			issue += int64(chunkSize)
+		}
+
+		req := queue[0]
+		queue = queue[1:]
+
+		s := f.c.awaitCancel(req.res, req.id, f.readDeadline.wait())
+		if s.err != nil {
+			return written, s.err
+		}
+
+		switch s.typ {
+		case sshFxpStatus:
+			err := normaliseError(unmarshalStatus(req.id, s.data))
+			if err == io.EOF {
+				return written, nil
+			}
+			return written, err
+
+		case sshFxpData:
+			sid, data := unmarshalUint32(s.data)
+			if req.id != sid {
+				return written, &unexpectedIDErr{req.id, sid}
+			}
+
+			l, data := unmarshalUint32(data)
+			if int64(l) > int64(len(data)) {
+				return written, errShortPacket
+			}
+			if int64(l) > int64(chunkSize) {
+				return written, errLongPacket
+			}
+			if l == 0 {
+				return written, nil
+			}
+
+			m, err := w.Write(data[:l])
+			written += int64(m)
+			f.offset += int64(m)
+			putPage(s.data)
+			if err != nil {
+				return written, err
+			}
+
+			if int(l) < chunkSize {
+				// The requests in flight do not continue where this one
+				// ended, their responses are dropped into their channels.
+				queue = queue[:0]
+				issue = req.off + int64(l)
+			}
+
+		default:
+			return written, unimplementedPacketErr(s.typ)
+		}
+	}
+}
+
 // WriteTo writes the file to the given Writer.
 // The return value is the number of bytes written.
 // Any error encountered during the write is also returned.
@@ -1201,12 +1984,26 @@ func (f *File) writeToSequential(w io.Writer) (written int64, err error) {
 // to maximise throughput for transferring the entire file,
 // especially over high latency links.
 func (f *File) WriteTo(w io.Writer) (written int64, err error) {
+	op := f.c.startOp("File.WriteTo", f.path, f.handle)
+	defer func() { op.end(written, err) }()
+	defer pathError(&err, "read", f.path)
+	if err = f.checkClosed(); err != nil {
+		return 0, err
+	}
+
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
 	if f.c.disableConcurrentReads {
 		return f.writeToSequential(w)
 	}
+	if f.c.useStreamingWriteTo {
+		window := f.c.streamWindow
+		if window <= 0 {
+			window = f.c.maxConcurrentRequests
+		}
+		return f.writeToStream(w, window)
+	}
 
 	// For concurrency, we want to guess how many concurrent workers we should use.
 	var fileStat *FileStat
@@ -1314,7 +2111,7 @@ func (f *File) WriteTo(w io.Writer) (written int64, err error) {
 				var b []byte
 				var n int
 
-				s := <-readWork.res
+				s := f.c.awaitCancel(readWork.res, readWork.id, f.readDeadline.wait())
 				resPool.Put(readWork.res)
 
 				err := s.err
@@ -1328,11 +2125,17 @@ func (f *File) WriteTo(w io.Writer) (written int64, err error) {
 						if readWork.id != sid {
 							err = &unexpectedIDErr{readWork.id, sid}
 
+						} else if l, data := unmarshalUint32(data); int64(l) > int64(len(data)) {
+							err = errShortPacket
+
+						} else if int64(l) > int64(chunkSize) {
+							err = errLongPacket
+
 						} else {
-							l, data := unmarshalUint32(data)
 							b = pool.Get()[:l]
 							n = copy(b, data[:l])
 							b = b[:n]
+							putPage(s.data)
 						}
 
 					default:
@@ -1395,7 +2198,12 @@ func (f *File) WriteTo(w io.Writer) (written int64, err error) {
 
 // Stat returns the FileInfo structure describing file. If there is an
 // error.
-func (f *File) Stat() (iofs.FileInfo, error) {
+func (f *File) Stat() (_ iofs.FileInfo, err error) {
+	defer pathError(&err, "stat", f.path)
+	if err = f.checkClosed(); err != nil {
+		return nil, err
+	}
+
 	fs, err := f.c.fstat(f.handle)
 	if err != nil {
 		return nil, err
@@ -1411,23 +2219,30 @@ func (f *File) Stat() (iofs.FileInfo, error) {
 // over high latency links) it is recommended to use ReadFrom rather
 // than calling Write multiple times. io.Copy will do this
 // automatically.
-func (f *File) Write(b []byte) (int, error) {
+func (f *File) Write(b []byte) (_ int, err error) {
+	defer pathError(&err, "write", f.path)
+	if err = f.checkClosed(); err != nil {
+		return 0, err
+	}
+
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	f.dropReadAhead()
+
 	n, err := f.WriteAt(b, f.offset)
 	f.offset += int64(n)
 	return n, err
 }
 
 func (f *File) writeChunkAt(ch chan result, b []byte, off int64) (int, error) {
-	typ, data, err := f.c.sendPacket(ch, &sshFxpWritePacket{
+	typ, data, err := f.sendPacketDeadline(ch, &sshFxpWritePacket{
 		ID:     f.c.nextID(),
 		Handle: f.handle,
 		Offset: uint64(off),
 		Length: uint32(len(b)),
 		Data:   b,
-	})
+	}, &f.writeDeadline)
 	if err != nil {
 		return 0, err
 	}
@@ -1520,7 +2335,7 @@ func (f *File) writeAtConcurrent(b []byte, off int64) (int, error) {
 			defer wg.Done()
 
 			for work := range workCh {
-				s := <-work.res
+				s := f.c.awaitCancel(work.res, work.id, f.writeDeadline.wait())
 				pool.Put(work.res)
 
 				err := s.err
@@ -1573,12 +2388,23 @@ func (f *File) writeAtConcurrent(b []byte, off int64) (int, error) {
 // the number of bytes written and an error, if any. WriteAt follows io.WriterAt semantics,
 // so the file offset is not altered during the write.
 func (f *File) WriteAt(b []byte, off int64) (written int, err error) {
+	op := f.c.startOp("File.WriteAt", f.path, f.handle)
+	defer func() { op.end(int64(written), err) }()
+	defer pathError(&err, "write", f.path)
+	if err = f.checkClosed(); err != nil {
+		return 0, err
+	}
+
+	if off < 0 {
+		return 0, iofs.ErrInvalid
+	}
+
 	if len(b) <= f.c.maxPacket {
 		// We can do this in one write.
 		return f.writeChunkAt(nil, b, off)
 	}
 
-	if f.c.useConcurrentWrites {
+	if f.c.useConcurrentWrites && !f.append {
 		return f.writeAtConcurrent(b, off)
 	}
 
@@ -1612,6 +2438,11 @@ func (f *File) WriteAt(b []byte, off int64) (written int, err error) {
 //
 // Otherwise, the given concurrency will be capped by the Client's max concurrency.
 func (f *File) ReadFromWithConcurrency(r io.Reader, concurrency int) (read int64, err error) {
+	defer pathError(&err, "write", f.path)
+	if err = f.checkClosed(); err != nil {
+		return 0, err
+	}
+
 	// Split the write into multiple maxPacket sized concurrent writes.
 	// This allows writes with a suitably large reader
 	// to transfer data at a much faster rate due to overlapping round trip times.
@@ -1688,7 +2519,7 @@ func (f *File) ReadFromWithConcurrency(r io.Reader, concurrency int) (read int64
 			defer wg.Done()
 
 			for work := range workCh {
-				s := <-work.res
+				s := f.c.awaitCancel(work.res, work.id, f.writeDeadline.wait())
 				pool.Put(work.res)
 
 				err := s.err
@@ -1757,11 +2588,31 @@ func (f *File) ReadFromWithConcurrency(r io.Reader, concurrency int) (read int64
 // This method is preferred over calling Write multiple times
 // to maximise throughput for transferring the entire file,
 // especially over high-latency links.
-func (f *File) ReadFrom(r io.Reader) (int64, error) {
+//
+// With UseVerifiedUploads, the data written is checked against r afterwards,
+// if r is an io.ReaderAt and io.Seeker, like *os.File or *bytes.Reader.
+func (f *File) ReadFrom(r io.Reader) (read int64, err error) {
+	op := f.c.startOp("File.ReadFrom", f.path, f.handle)
+	defer func() { op.end(read, err) }()
+	defer pathError(&err, "write", f.path)
+	if err = f.checkClosed(); err != nil {
+		return 0, err
+	}
+
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	if f.c.useConcurrentWrites {
+	f.dropReadAhead()
+
+	if src, ok := r.(readSeekerAt); ok && f.c.verifyBlockSize > 0 {
+		return f.readFromVerified(src)
+	}
+	return f.readFrom(r)
+}
+
+// readFrom implements ReadFrom, with f.mu held.
+func (f *File) readFrom(r io.Reader) (int64, error) {
+	if f.c.useConcurrentWrites && !f.append {
 		var remain int64
 		switch r := r.(type) {
 		case interface{ Len() int }:
@@ -1836,7 +2687,12 @@ func (f *File) ReadFrom(r io.Reader) (int64, error) {
 // Seek implements io.Seeker by setting the client offset for the next Read or
 // Write. It returns the next offset read. Seeking before or after the end of
 // the file is undefined. Seeking relative to the end calls Stat.
-func (f *File) Seek(offset int64, whence int) (int64, error) {
+func (f *File) Seek(offset int64, whence int) (_ int64, err error) {
+	defer pathError(&err, "seek", f.path)
+	if err = f.checkClosed(); err != nil {
+		return 0, err
+	}
+
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
@@ -1863,21 +2719,36 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 }
 
 // Chown changes the uid/gid of the current file.
-func (f *File) Chown(uid, gid int) error {
+func (f *File) Chown(uid, gid int) (err error) {
+	defer pathError(&err, "chown", f.path)
+	if err = f.checkClosed(); err != nil {
+		return err
+	}
+
 	return f.c.Chown(f.path, uid, gid)
 }
 
 // Chmod changes the permissions of the current file.
 //
 // See Client.Chmod for details.
-func (f *File) Chmod(mode iofs.FileMode) error {
+func (f *File) Chmod(mode iofs.FileMode) (err error) {
+	defer pathError(&err, "chmod", f.path)
+	if err = f.checkClosed(); err != nil {
+		return err
+	}
+
 	return f.c.setfstat(f.handle, sshFileXferAttrPermissions, toChmodPerm(mode))
 }
 
 // Sync requests a flush of the contents of a File to stable storage.
 //
 // Sync requires the server to support the fsync@openssh.com extension.
-func (f *File) Sync() error {
+func (f *File) Sync() (err error) {
+	defer pathError(&err, "sync", f.path)
+	if err = f.checkClosed(); err != nil {
+		return err
+	}
+
 	id := f.c.nextID()
 	typ, data, err := f.c.sendPacket(nil, &sshFxpFsyncPacket{
 		ID:     id,
@@ -1894,12 +2765,39 @@ func (f *File) Sync() error {
 	}
 }
 
+// CheckFile asks the server to compute a digest of the open file,
+// see Client.CheckFile for the meaning of the arguments.
+//
+// CheckFile requires the server to support the check-file extension.
+func (f *File) CheckFile(algorithms []string, offset, length int64, blockSize uint32) (_ *CheckFileResult, err error) {
+	defer pathError(&err, "checkfile", f.path)
+	if err = f.checkClosed(); err != nil {
+		return nil, err
+	}
+
+	return f.c.checkFile(&sshFxpCheckFilePacket{
+		Handle:     f.handle,
+		Algorithms: strings.Join(algorithms, ","),
+		Offset:     uint64(offset),
+		Length:     uint64(length),
+		BlockSize:  blockSize,
+	})
+}
+
 // Truncate sets the size of the current file. Although it may be safely assumed
 // that if the size is less than its current size it will be truncated to fit,
 // the SFTP protocol does not specify what behavior the server should do when setting
 // size greater than the current size.
 // We send a SSH_FXP_FSETSTAT here since we have a file handle
-func (f *File) Truncate(size int64) error {
+func (f *File) Truncate(size int64) (err error) {
+	defer pathError(&err, "truncate", f.path)
+	if err = f.checkClosed(); err != nil {
+		return err
+	}
+
+	if size < 0 {
+		return iofs.ErrInvalid
+	}
 	return f.c.setfstat(f.handle, sshFileXferAttrSize, uint64(size))
 }
 
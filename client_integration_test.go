@@ -20,13 +20,14 @@ import (
 	"regexp"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"testing"
 	"testing/quick"
 	"time"
 
-	"github.com/pkg/sftp/internal/apis"
+	"github.com/pkg/sftp/apis"
 
 	"github.com/kr/fs"
 	"github.com/stretchr/testify/assert"
@@ -733,6 +734,82 @@ func TestClientPosixRename(t *testing.T) {
 	}
 }
 
+// noReplaceFs fails renames onto existing paths, like SSH_FXP_RENAME of
+// SFTP version 3 does elsewhere.
+type noReplaceFs struct {
+	apis.FullFs
+}
+
+func (f noReplaceFs) Rename(oldpath, newpath string) error {
+	if _, err := f.Lstat(newpath); err == nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EEXIST}
+	}
+	return f.FullFs.Rename(oldpath, newpath)
+}
+
+func TestClientRenameOverwrite(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+	server.SetAPI(noReplaceFs{apis.NewOS()})
+	delete(client.ext, "posix-rename@openssh.com")
+
+	dir := t.TempDir()
+	oldName, newName := path.Join(dir, "old"), path.Join(dir, "new")
+	require.NoError(t, os.WriteFile(oldName, []byte("old"), 0o644))
+	require.NoError(t, os.WriteFile(newName, []byte("new"), 0o644))
+
+	require.Error(t, client.Rename(oldName, newName))
+	require.NoError(t, client.RenameOverwrite(oldName, newName))
+	b, err := os.ReadFile(newName)
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(b))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the backup is removed")
+
+	// a failing rename restores the replaced file
+	assert.Error(t, client.RenameOverwrite(oldName, newName))
+	b, err = os.ReadFile(newName)
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(b))
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, os.Mkdir(path.Join(dir, "sub"), 0o755))
+	assert.Error(t, client.RenameOverwrite(newName, path.Join(dir, "sub")), "directories are not replaced")
+}
+
+func TestClientReadWriteFile(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	name := path.Join(t.TempDir(), "file")
+	content := bytes.Repeat([]byte("0123456789abcdef"), 10000)
+	require.NoError(t, client.WriteFile(name, content, 0o600))
+	fi, err := os.Stat(name)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+
+	b, err := client.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, content, b)
+
+	// an existing file is truncated and keeps its mode
+	require.NoError(t, client.WriteFile(name, []byte("short"), 0o644))
+	b, err = client.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, "short", string(b))
+	fi, err = os.Stat(name)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+
+	_, err = client.ReadFile(name + ".missing")
+	assert.True(t, os.IsNotExist(err), "%v", err)
+}
+
 func TestClientGetwd(t *testing.T) {
 	sftp, cmd := testClient(t, READONLY, NODELAY)
 	fsApi := apis.NewAVFS()
@@ -1677,17 +1754,7 @@ func TestClientSyncGo(t *testing.T) {
 		t.Skipf("skipping without -testserver")
 	}
 	err := testClientSync(t)
-
-	// Since Server does not support the fsync extension, we can only
-	// check that we get the right error.
-	require.Error(t, err)
-
-	switch err := err.(type) {
-	case *StatusError:
-		assert.Equal(t, ErrSSHFxOpUnsupported, err.FxCode())
-	default:
-		t.Error(err)
-	}
+	assert.NoError(t, err)
 }
 
 func TestClientSyncSFTP(t *testing.T) {
@@ -2037,6 +2104,72 @@ func TestGlob(t *testing.T) {
 	}
 }
 
+func TestMatchOptions(t *testing.T) {
+	both := []GlobOption{GlobDoublestar(), GlobBraces()}
+	for _, tt := range []struct {
+		pattern, name string
+		match         bool
+		err           error
+	}{
+		{"a/**/*.go", "a/x.go", true, nil},
+		{"a/**/*.go", "a/b/c/x.go", true, nil},
+		{"a/**/*.go", "b/x.go", false, nil},
+		{"**", "a/b", true, nil},
+		{"a/**", "a", true, nil},
+		{"a/**/**/b", "a/b", true, nil},
+		{"a**", "ab/c", false, nil},
+		{"*.{go,mod}", "go.mod", true, nil},
+		{"*.{go,mod}", "go.sum", false, nil},
+		{"{a,b/{c,d}}/x", "b/d/x", true, nil},
+		{"{a,[{,]}", "{", true, nil},
+		{`\{a,b}`, "{a,b}", true, nil},
+		{"{a,b", "a", false, ErrBadPattern},
+		{"a}", "a}", true, nil},
+		{"**/[", "a", false, ErrBadPattern},
+	} {
+		ok, err := Match(tt.pattern, tt.name, both...)
+		assert.Equal(t, tt.match, ok, "Match(%#q, %#q)", tt.pattern, tt.name)
+		assert.Equal(t, tt.err, err, "Match(%#q, %#q)", tt.pattern, tt.name)
+	}
+
+	ok, err := Match("a/**/*.go", "a/b/c/x.go")
+	assert.False(t, ok, "** is * without GlobDoublestar")
+	assert.NoError(t, err)
+	ok, err = Match("{a,b}", "a")
+	assert.False(t, ok, "braces are literal without GlobBraces")
+	assert.NoError(t, err)
+}
+
+func TestGlobOptions(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(root, "a/b/c"), 0o755))
+	for _, file := range []string{"x.go", "go.mod", "a/y.go", "a/b/c/z.go", "a/b/c/z.txt"} {
+		require.NoError(t, os.WriteFile(path.Join(root, file), nil, 0o644))
+	}
+
+	glob := func(pattern string) []string {
+		matches, err := client.Glob(path.Join(root, pattern), GlobDoublestar(), GlobBraces())
+		require.NoError(t, err)
+		for i, m := range matches {
+			matches[i] = strings.TrimPrefix(m, root+"/")
+		}
+		sort.Strings(matches)
+		return matches
+	}
+	assert.Equal(t, []string{"a/b/c/z.go", "a/y.go", "x.go"}, glob("**/*.go"))
+	assert.Equal(t, []string{"a/b/c/z.go", "a/b/c/z.txt"}, glob("a/**/z.*"))
+	assert.Equal(t, []string{"a/b/c/z.go", "a/b/c/z.txt"}, glob("*/b/**/z.{go,txt}"))
+	assert.Equal(t, []string{"go.mod", "x.go"}, glob("{x.go,go.mod,missing}"))
+	assert.Empty(t, glob("missing/**"))
+
+	_, err := client.Glob("{a", GlobBraces())
+	assert.Equal(t, ErrBadPattern, err)
+}
+
 func TestGlobError(t *testing.T) {
 	sftp, cmd := testClient(t, READONLY, NODELAY)
 	defer cmd.Wait()
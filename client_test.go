@@ -5,10 +5,16 @@ import (
 	"errors"
 	"io"
 	"os"
+	"path"
+	"strconv"
+	"sync"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/kr/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // assert that *Client implements fs.FileSystem
@@ -199,3 +205,194 @@ func TestClientNoSid(t *testing.T) {
 		t.Fatal("expected ErrSSHFxConnectionLost, got", err)
 	}
 }
+
+func TestClientMkdirAllConcurrent(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	sub := path.Join(t.TempDir(), "a", "b", "c")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- client.MkdirAll(path.Join(sub, strconv.Itoa(i)))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+}
+
+func TestClientMkdirAllMode(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(path.Join(dir, "existing"), 0755))
+	require.NoError(t, os.Symlink(path.Join(dir, "existing"), path.Join(dir, "link")))
+
+	sub := path.Join(dir, "link", "a", "b")
+	require.NoError(t, client.MkdirAllMode(sub, 0700))
+
+	for _, name := range []string{sub, path.Dir(sub)} {
+		fi, err := os.Stat(name)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), fi.Mode().Perm(), name)
+	}
+	fi, err := os.Stat(path.Join(dir, "existing"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), fi.Mode().Perm(), "existing directories are left alone")
+
+	require.NoError(t, client.MkdirAll(path.Join(dir, "link")+"/"))
+}
+
+func TestClientMaxInflightRequests(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	svr := &conn{Reader: sr, WriteCloser: sw}
+
+	ids := make(chan uint32, 3)
+	go func() {
+		defer close(ids)
+		defer svr.Close()
+
+		if _, _, err := svr.recvPacket(0); err != nil {
+			return
+		}
+		if err := svr.sendPacket(rawPacket(marshalUint32([]byte{sshFxpVersion}, 3))); err != nil {
+			return
+		}
+		for {
+			_, data, err := svr.recvPacket(0)
+			if err != nil {
+				return
+			}
+			id, _ := unmarshalUint32(data)
+			ids <- id
+		}
+	}()
+
+	client, err := NewClientPipe(cr, cw, MaxInflightRequests(2))
+	require.NoError(t, err)
+	defer client.Close()
+
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			errs <- client.Mkdir("/dir" + strconv.Itoa(i))
+		}(i)
+	}
+
+	first := <-ids
+	<-ids
+	select {
+	case <-ids:
+		t.Fatal("more requests than allowed in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+	assert.Equal(t, os.ErrDeadlineExceeded, client.ping(time.Now().Add(10*time.Millisecond)),
+		"waiting for a slot respects the deadline")
+
+	require.NoError(t, svr.sendPacket(statusOK(first)))
+	require.NoError(t, <-errs)
+	third := <-ids
+	require.NoError(t, svr.sendPacket(statusOK(third)))
+	require.NoError(t, <-errs)
+}
+
+// shortReadHandler serves a file like those in /proc, answering reads with
+// at most max bytes and misreporting its size.
+type shortReadHandler struct {
+	content []byte
+	size    int64
+	max     int
+}
+
+func (h shortReadHandler) ReadAt(b []byte, off int64) (int, error) {
+	if off >= int64(len(h.content)) {
+		return 0, io.EOF
+	}
+	if len(b) > h.max {
+		b = b[:h.max]
+	}
+	return copy(b, h.content[off:]), nil
+}
+
+func (h shortReadHandler) Fileread(*Request) (io.ReaderAt, error) {
+	return h, nil
+}
+
+func (h shortReadHandler) Filelist(r *Request) (ListerAt, error) {
+	return listerat{&memFile{name: path.Base(r.Filepath), content: make([]byte, h.size)}}, nil
+}
+
+func TestClientStreamingWriteTo(t *testing.T) {
+	content := make([]byte, 100*1024+123)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	h := shortReadHandler{content: content, size: 1 << 20, max: 1000}
+
+	for _, window := range []int{0, 1, 5} {
+		client, server := clientRequestServerPipe(t, Handlers{FileGet: h, FileList: h}, nil, MaxPacket(4096), UseStreamingWriteTo(window))
+
+		f, err := client.Open("/proc/file")
+		require.NoError(t, err)
+		_, err = f.Seek(10, io.SeekStart)
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		n, err := f.WriteTo(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(content)-10), n)
+		assert.True(t, bytes.Equal(content[10:], buf.Bytes()), "window %d", window)
+		assert.Equal(t, int64(len(content)), f.offset)
+
+		server.Close()
+		client.Close()
+	}
+}
+
+func TestClientPathErrors(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	name := path.Join(dir, "missing")
+
+	_, err := client.Stat(name)
+	var pathErr *os.PathError
+	require.True(t, errors.As(err, &pathErr), "got %v", err)
+	assert.Equal(t, "stat", pathErr.Op)
+	assert.Equal(t, name, pathErr.Path)
+	assert.True(t, errors.Is(err, ErrNotExist))
+	assert.True(t, os.IsNotExist(err))
+
+	err = client.Rename(name, name+".new")
+	var linkErr *os.LinkError
+	require.True(t, errors.As(err, &linkErr), "got %v", err)
+	assert.Equal(t, "rename", linkErr.Op)
+	assert.Equal(t, name+".new", linkErr.New)
+
+	f, err := client.Create(path.Join(dir, "file"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// a closed File fails without asking the server
+	_, err = f.Write([]byte("hello"))
+	assert.True(t, errors.Is(err, ErrHandleClosed), "got %v", err)
+	require.True(t, errors.As(err, &pathErr))
+	assert.Equal(t, "write", pathErr.Op)
+	_, err = f.Stat()
+	assert.True(t, errors.Is(err, ErrHandleClosed), "got %v", err)
+	assert.True(t, errors.Is(f.Close(), ErrHandleClosed))
+}
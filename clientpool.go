@@ -0,0 +1,304 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrPoolClosed is returned by ClientPool.Get once the pool is closed.
+var ErrPoolClosed = errors.New("sftp: client pool closed")
+
+// A ClientPoolOption is a function which applies configuration to a ClientPool.
+type ClientPoolOption func(*ClientPool) error
+
+// WithPoolHealthCheck makes Get check that a session which was idle for at
+// least idle still answers, within timeout, before handing it out.
+// Sessions that do not answer are closed and replaced.
+func WithPoolHealthCheck(idle, timeout time.Duration) ClientPoolOption {
+	return func(p *ClientPool) error {
+		if idle < 0 || timeout <= 0 {
+			return fmt.Errorf("sftp: invalid pool health check after %v within %v", idle, timeout)
+		}
+		p.checkIdle = idle
+		p.checkTimeout = timeout
+		return nil
+	}
+}
+
+// WithPoolStriping spreads Upload and Download of files of at least minSize
+// bytes over up to stripes sessions, which speeds up transfers over links
+// with a high bandwidth-delay product. Only sessions that are free right away
+// are used for the additional stripes.
+func WithPoolStriping(stripes int, minSize int64) ClientPoolOption {
+	return func(p *ClientPool) error {
+		if stripes < 1 {
+			return fmt.Errorf("sftp: invalid number of stripes %d", stripes)
+		}
+		p.stripes = stripes
+		p.stripeMinSize = minSize
+		return nil
+	}
+}
+
+// ClientPool hands out up to a fixed number of Client sessions,
+// opening them when needed and reusing them once they are put back.
+type ClientPool struct {
+	newClient func() (*Client, error)
+
+	checkIdle    time.Duration
+	checkTimeout time.Duration // 0 to not check idle sessions
+
+	stripes       int
+	stripeMinSize int64
+
+	slots chan struct{}   // one for every open session
+	idle  chan idleClient // sessions put back
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// idleClient is a session put back into the pool at since.
+type idleClient struct {
+	c     *Client
+	since time.Time
+}
+
+// NewClientPool creates a pool of up to size sessions, which are opened by
+// newClient. See SSHSessions for opening them on ssh connections.
+func NewClientPool(size int, newClient func() (*Client, error), opts ...ClientPoolOption) (*ClientPool, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("sftp: invalid client pool size %d", size)
+	}
+
+	p := &ClientPool{
+		newClient: newClient,
+		stripes:   1,
+		slots:     make(chan struct{}, size),
+		idle:      make(chan idleClient, size),
+		closed:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// SSHSessions returns a function for NewClientPool, which opens the sessions
+// on conns in turn, with opts.
+func SSHSessions(conns []*ssh.Client, opts ...ClientOption) func() (*Client, error) {
+	var next uint32
+	return func() (*Client, error) {
+		if len(conns) == 0 {
+			return nil, errors.New("sftp: no ssh connections for the client pool")
+		}
+		conn := conns[(atomic.AddUint32(&next, 1)-1)%uint32(len(conns))]
+		return NewClient(conn, opts...)
+	}
+}
+
+// Get returns a session of the pool, which has to be given back with Put.
+// It waits for one to be put back, if all sessions are in use.
+func (p *ClientPool) Get() (*Client, error) {
+	return p.get(true)
+}
+
+// tryGet is Get, but returns false instead of waiting.
+func (p *ClientPool) tryGet() (*Client, bool) {
+	c, err := p.get(false)
+	return c, err == nil
+}
+
+// errPoolBusy is returned by get, if all sessions are in use and it is not to wait.
+var errPoolBusy = errors.New("sftp: all sessions of the client pool are in use")
+
+// get returns an idle session, or opens a new one if there is room.
+// Otherwise it waits for a session to be put back, if wait is set.
+func (p *ClientPool) get(wait bool) (*Client, error) {
+	for {
+		select {
+		case <-p.closed:
+			return nil, ErrPoolClosed
+		default:
+		}
+
+		// reuse idle sessions before opening new ones
+		select {
+		case ic := <-p.idle:
+			if c, ok := p.reuse(ic); ok {
+				return c, nil
+			}
+			continue
+		default:
+		}
+
+		select {
+		case p.slots <- struct{}{}:
+			return p.open()
+		default:
+		}
+
+		if !wait {
+			return nil, errPoolBusy
+		}
+
+		select {
+		case <-p.closed:
+			return nil, ErrPoolClosed
+		case ic := <-p.idle:
+			if c, ok := p.reuse(ic); ok {
+				return c, nil
+			}
+		case p.slots <- struct{}{}:
+			return p.open()
+		}
+	}
+}
+
+// open opens a new session in the slot taken.
+func (p *ClientPool) open() (*Client, error) {
+	c, err := p.newClient()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return c, nil
+}
+
+// reuse returns the idle session, if it is healthy, or discards it.
+func (p *ClientPool) reuse(ic idleClient) (*Client, bool) {
+	if p.healthy(ic) {
+		return ic.c, true
+	}
+	p.discard(ic.c)
+	return nil, false
+}
+
+// Put gives a session from Get back to the pool.
+// Sessions which lost their connection are closed and replaced.
+func (p *ClientPool) Put(c *Client) {
+	select {
+	case <-p.closed:
+		p.discard(c)
+		return
+	case <-c.closed:
+		p.discard(c)
+		return
+	default:
+	}
+
+	p.idle <- idleClient{c: c, since: time.Now()}
+
+	// Close may have drained the idle sessions already
+	select {
+	case <-p.closed:
+		p.drain()
+	default:
+	}
+}
+
+// Close closes all idle sessions of the pool,
+// sessions in use are closed once they are put back.
+func (p *ClientPool) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+	p.drain()
+	return nil
+}
+
+// drain closes all idle sessions.
+func (p *ClientPool) drain() {
+	for {
+		select {
+		case ic := <-p.idle:
+			p.discard(ic.c)
+		default:
+			return
+		}
+	}
+}
+
+// discard closes c and frees its slot.
+func (p *ClientPool) discard(c *Client) {
+	c.Close()
+	<-p.slots
+}
+
+// healthy reports whether the idle session can be handed out.
+func (p *ClientPool) healthy(ic idleClient) bool {
+	select {
+	case <-ic.c.closed:
+		return false
+	default:
+	}
+
+	if p.checkTimeout == 0 || time.Since(ic.since) < p.checkIdle {
+		return true
+	}
+	return ic.c.ping(time.Now().Add(p.checkTimeout)) == nil
+}
+
+// sessions returns c and as many further sessions, free right away,
+// as size is worth striping over.
+func (p *ClientPool) sessions(c *Client, size int64) []*Client {
+	sessions := []*Client{c}
+	if size < p.stripeMinSize {
+		return sessions
+	}
+	for len(sessions) < p.stripes {
+		c, ok := p.tryGet()
+		if !ok {
+			break
+		}
+		sessions = append(sessions, c)
+	}
+	return sessions
+}
+
+// Download copies the remote file at path to w, returning the bytes copied.
+func (p *ClientPool) Download(path string, w io.WriterAt) (int64, error) {
+	c, err := p.Get()
+	if err != nil {
+		return 0, err
+	}
+	fi, err := c.Stat(path)
+	if err != nil {
+		p.Put(c)
+		return 0, err
+	}
+
+	sessions := p.sessions(c, fi.Size())
+	defer p.putAll(sessions)
+
+	return downloadConcurrent(sessions, path, fi.Size(), w)
+}
+
+// Upload copies size bytes of r to the remote file at path,
+// which is created or truncated first. It returns the bytes copied.
+func (p *ClientPool) Upload(path string, r io.ReaderAt, size int64) (int64, error) {
+	c, err := p.Get()
+	if err != nil {
+		return 0, err
+	}
+
+	sessions := p.sessions(c, size)
+	defer p.putAll(sessions)
+
+	return UploadConcurrent(sessions, path, r, size)
+}
+
+// putAll puts all sessions back.
+func (p *ClientPool) putAll(sessions []*Client) {
+	for _, c := range sessions {
+		p.Put(c)
+	}
+}
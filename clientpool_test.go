@@ -0,0 +1,145 @@
+package sftp
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// poolServers opens sessions to new Servers for a ClientPool.
+type poolServers struct {
+	t       *testing.T
+	mu      sync.Mutex
+	servers []*Server
+}
+
+func (s *poolServers) newClient() (*Client, error) {
+	client, server := clientServerPair(s.t)
+
+	s.mu.Lock()
+	s.servers = append(s.servers, server)
+	s.mu.Unlock()
+	return client, nil
+}
+
+func (s *poolServers) opened() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.servers)
+}
+
+func TestClientPoolReuse(t *testing.T) {
+	s := &poolServers{t: t}
+	pool, err := NewClientPool(2, s.newClient)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	c1, err := pool.Get()
+	require.NoError(t, err)
+	pool.Put(c1)
+	c, err := pool.Get()
+	require.NoError(t, err)
+	assert.Same(t, c1, c)
+	assert.Equal(t, 1, s.opened())
+
+	c2, err := pool.Get()
+	require.NoError(t, err)
+	assert.NotSame(t, c1, c2)
+
+	got := make(chan *Client)
+	go func() {
+		c, _ := pool.Get()
+		got <- c
+	}()
+	select {
+	case <-got:
+		t.Fatal("Get did not wait for a free session")
+	case <-time.After(10 * time.Millisecond):
+	}
+	pool.Put(c2)
+	assert.Same(t, c2, <-got)
+	assert.Equal(t, 2, s.opened())
+
+	// sessions that lost their connection are replaced
+	s.servers[0].Close()
+	c1.Wait()
+	pool.Put(c1)
+	c, err = pool.Get()
+	require.NoError(t, err)
+	assert.NotSame(t, c1, c)
+	assert.Equal(t, 3, s.opened())
+	pool.Put(c)
+	pool.Put(c2)
+
+	require.NoError(t, pool.Close())
+	_, err = pool.Get()
+	assert.Equal(t, ErrPoolClosed, err)
+}
+
+func TestClientPoolHealthCheck(t *testing.T) {
+	var silent bool
+	pool, err := NewClientPool(1, func() (*Client, error) {
+		if !silent {
+			silent = true
+			return silentClient(t), nil
+		}
+		return (&poolServers{t: t}).newClient()
+	}, WithPoolHealthCheck(0, 10*time.Millisecond))
+	require.NoError(t, err)
+	defer pool.Close()
+
+	c1, err := pool.Get()
+	require.NoError(t, err)
+	pool.Put(c1)
+
+	c2, err := pool.Get()
+	require.NoError(t, err)
+	assert.NotSame(t, c1, c2, "the silent session is replaced")
+	_, err = c2.Getwd()
+	assert.NoError(t, err)
+	pool.Put(c2)
+}
+
+func TestClientPoolStriping(t *testing.T) {
+	s := &poolServers{t: t}
+	pool, err := NewClientPool(4, s.newClient, WithPoolStriping(3, 1024))
+	require.NoError(t, err)
+	defer pool.Close()
+
+	content := make([]byte, 3*stripeBufferSize+12345)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "striped")
+	n, err := pool.Upload(name, bytes.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), n)
+	assert.Equal(t, 3, s.opened())
+
+	b, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, b))
+
+	f, err := os.Create(filepath.Join(dir, "downloaded"))
+	require.NoError(t, err)
+	defer f.Close()
+	n, err = pool.Download(name, f)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), n)
+	b, err = os.ReadFile(f.Name())
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, b))
+
+	// small files are not striped
+	n, err = pool.Upload(name, bytes.NewReader(content[:10]), 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), n)
+	assert.Equal(t, 3, s.opened())
+}
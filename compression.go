@@ -0,0 +1,347 @@
+package sftp
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressionExtension is the private extension used to negotiate payload
+// compression between a Client and a Server or RequestServer of this package.
+// The server lists the algorithms it offers as comma separated extension data,
+// the client picks one of them with an extended request.
+const compressionExtension = "compression@github.com/pkg/sftp"
+
+// Compressor compresses the payload of SSH_FXP_WRITE and SSH_FXP_DATA packets,
+// once both ends agreed on it. All other packets are never compressed.
+//
+// This package provides zstd, which is offered by default, and deflate.
+// Other algorithms can be plugged in by implementing this interface on both
+// ends.
+//
+// A Compressor is used concurrently.
+type Compressor interface {
+	// Name identifies the algorithm during negotiation, e.g. "zstd".
+	Name() string
+
+	// Compress appends the compressed form of src to dst.
+	Compress(dst, src []byte) ([]byte, error)
+
+	// Decompress appends the decompressed form of src to dst.
+	// It must fail instead of producing more than limit bytes.
+	Decompress(dst, src []byte, limit int) ([]byte, error)
+}
+
+var errCompressedPayload = errors.New("sftp: invalid compressed payload")
+
+// Every compressed payload starts with one of these, so incompressible data
+// can be sent as is.
+const (
+	payloadRaw        = 0
+	payloadCompressed = 1
+)
+
+var (
+	defaultZstdOnce sync.Once
+	defaultZstd     Compressor
+)
+
+// defaultCompressors returns the algorithms offered unless UseCompression,
+// WithCompression or WithRSCompression says otherwise.
+// All connections share the same zstd Compressor.
+func defaultCompressors() []Compressor {
+	defaultZstdOnce.Do(func() {
+		defaultZstd, _ = NewZstdCompressor(int(zstd.SpeedDefault))
+	})
+	if defaultZstd == nil {
+		return nil
+	}
+	return []Compressor{defaultZstd}
+}
+
+// compression holds the negotiation state of a connection.
+type compression struct {
+	offered []Compressor // in order of preference
+	active  atomic.Value // activeCompressor, set once negotiated
+}
+
+// activeCompressor wraps the Compressor in use, as an atomic.Value
+// always has to hold the same type.
+type activeCompressor struct {
+	Compressor
+}
+
+func (c *compression) get() Compressor {
+	active, _ := c.active.Load().(activeCompressor)
+	return active.Compressor
+}
+
+// set activates comp, a nil comp turns compression off.
+func (c *compression) set(comp Compressor) {
+	c.active.Store(activeCompressor{comp})
+}
+
+// enable activates the offered Compressor with the given name.
+func (c *compression) enable(name string) error {
+	for _, comp := range c.offered {
+		if comp.Name() == name {
+			c.set(comp)
+			return nil
+		}
+	}
+	return ErrSSHFxOpUnsupported
+}
+
+// extensions returns exts with the compression extension appended,
+// if any algorithm is offered.
+func (c *compression) extensions(exts []sshExtensionPair) []sshExtensionPair {
+	if len(c.offered) == 0 {
+		return exts
+	}
+
+	names := make([]string, len(c.offered))
+	for i, comp := range c.offered {
+		names[i] = comp.Name()
+	}
+
+	return append(exts[:len(exts):len(exts)], sshExtensionPair{
+		Name: compressionExtension,
+		Data: strings.Join(names, ","),
+	})
+}
+
+// choose returns the first offered Compressor the peer supports,
+// the peer algorithms are the extension data it advertised.
+func (c *compression) choose(peer string) Compressor {
+	for _, comp := range c.offered {
+		for _, name := range strings.Split(peer, ",") {
+			if comp.Name() == name {
+				return comp
+			}
+		}
+	}
+	return nil
+}
+
+// encode returns m with a compressed payload, if m is a write or data packet
+// and compression was negotiated.
+func (c *compression) encode(m encoding.BinaryMarshaler) encoding.BinaryMarshaler {
+	comp := c.get()
+	if comp == nil {
+		return m
+	}
+
+	if r, ok := m.(orderedResponse); ok {
+		// the packet manager is done ordering by the time we are sending
+		m = r.responsePacket
+	}
+
+	switch p := m.(type) {
+	case *sshFxpWritePacket:
+		data := encodePayload(comp, p.Data)
+		return &sshFxpWritePacket{
+			ID:     p.ID,
+			Length: uint32(len(data)),
+			Offset: p.Offset,
+			Handle: p.Handle,
+			Data:   data,
+		}
+	case *sshFxpDataPacket:
+		data := encodePayload(comp, p.Data)
+		return &sshFxpDataPacket{
+			ID:     p.ID,
+			Length: uint32(len(data)),
+			Data:   data,
+		}
+	}
+	return m
+}
+
+// decode reverses encode on a received packet.
+func (c *compression) decode(typ uint8, b []byte) ([]byte, error) {
+	comp := c.get()
+	if comp == nil || (typ != sshFxpWrite && typ != sshFxpData) {
+		return b, nil
+	}
+
+	// everything up to the payload is copied over unchanged
+	_, rest, err := unmarshalUint32Safe(b) // id
+	if err != nil {
+		return nil, err
+	}
+	if typ == sshFxpWrite {
+		if _, rest, err = unmarshalStringSafe(rest); err != nil { // handle
+			return nil, err
+		}
+		if _, rest, err = unmarshalUint64Safe(rest); err != nil { // offset
+			return nil, err
+		}
+	}
+	prefix := b[:len(b)-len(rest)]
+
+	payload, _, err := unmarshalStringSafe(rest)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) == 0 {
+		return nil, errCompressedPayload
+	}
+
+	out := make([]byte, len(prefix)+4, len(prefix)+4+len(payload))
+	copy(out, prefix)
+
+	switch payload[0] {
+	case payloadRaw:
+		out = append(out, payload[1:]...)
+	case payloadCompressed:
+		if out, err = comp.Decompress(out, []byte(payload[1:]), maxMsgLength); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errCompressedPayload
+	}
+
+	// fill in the length of the decompressed payload
+	n := len(out) - len(prefix) - 4
+	marshalUint32(out[:len(prefix)], uint32(n))
+
+	return out, nil
+}
+
+// encodePayload compresses data, falling back to sending it as is,
+// if it does not get any smaller.
+func encodePayload(comp Compressor, data []byte) []byte {
+	out, err := comp.Compress([]byte{payloadCompressed}, data)
+	if err != nil || len(out) > len(data) {
+		out = append(make([]byte, 0, 1+len(data)), payloadRaw)
+		out = append(out, data...)
+	}
+	return out
+}
+
+func (p *sshFxpExtendedPacketCompression) respond(svr *Server) responsePacket {
+	return statusFromError(p.ID, svr.compression.enable(p.Algorithm))
+}
+
+type deflateCompressor struct {
+	level   int
+	writers sync.Pool
+}
+
+// NewDeflateCompressor returns a Compressor using deflate at the given
+// compress/flate level.
+func NewDeflateCompressor(level int) (Compressor, error) {
+	if _, err := flate.NewWriter(ioutil.Discard, level); err != nil {
+		return nil, err
+	}
+	return &deflateCompressor{level: level}, nil
+}
+
+func (*deflateCompressor) Name() string { return "deflate" }
+
+func (d *deflateCompressor) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+
+	w, _ := d.writers.Get().(*flate.Writer)
+	if w == nil {
+		var err error
+		if w, err = flate.NewWriter(buf, d.level); err != nil {
+			return nil, err
+		}
+	} else {
+		w.Reset(buf)
+	}
+	defer func() {
+		w.Reset(ioutil.Discard) // do not hold on to buf while pooled
+		d.writers.Put(w)
+	}()
+
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (*deflateCompressor) Decompress(dst, src []byte, limit int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+
+	buf := bytes.NewBuffer(dst)
+	start := buf.Len()
+	if _, err := io.Copy(buf, io.LimitReader(r, int64(limit)+1)); err != nil {
+		return nil, err
+	}
+	if buf.Len()-start > limit {
+		return nil, errCompressedPayload
+	}
+	return buf.Bytes(), nil
+}
+
+type zstdCompressor struct {
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+var (
+	zstdDecoderOnce sync.Once
+	zstdDecoder     *zstd.Decoder
+	zstdDecoderErr  error
+)
+
+// sharedZstdDecoder returns the decoder shared by all zstd Compressors,
+// its settings do not depend on the compression level.
+func sharedZstdDecoder() (*zstd.Decoder, error) {
+	zstdDecoderOnce.Do(func() {
+		zstdDecoder, zstdDecoderErr = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxMsgLength))
+	})
+	return zstdDecoder, zstdDecoderErr
+}
+
+// NewZstdCompressor returns a Compressor using zstd at the given
+// github.com/klauspost/compress/zstd level, e.g. zstd.SpeedDefault.
+func NewZstdCompressor(level int) (Compressor, error) {
+	lvl := zstd.EncoderLevel(level)
+	if lvl < zstd.SpeedFastest || lvl > zstd.SpeedBestCompression {
+		return nil, fmt.Errorf("sftp: invalid zstd level %d", level)
+	}
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(lvl), zstd.WithEncoderCRC(false))
+	if err != nil {
+		return nil, err
+	}
+	dec, err := sharedZstdDecoder()
+	if err != nil {
+		return nil, err
+	}
+	return &zstdCompressor{enc: enc, dec: dec}, nil
+}
+
+func (*zstdCompressor) Name() string { return "zstd" }
+
+func (z *zstdCompressor) Compress(dst, src []byte) ([]byte, error) {
+	return z.enc.EncodeAll(src, dst), nil
+}
+
+func (z *zstdCompressor) Decompress(dst, src []byte, limit int) ([]byte, error) {
+	start := len(dst)
+	out, err := z.dec.DecodeAll(src, dst)
+	if err != nil {
+		return nil, err
+	}
+	if len(out)-start > limit {
+		return nil, errCompressedPayload
+	}
+	return out, nil
+}
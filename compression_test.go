@@ -0,0 +1,176 @@
+package sftp
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testDeflate(t *testing.T) Compressor {
+	comp, err := NewDeflateCompressor(flate.BestSpeed)
+	require.NoError(t, err)
+	return comp
+}
+
+func TestNewDeflateCompressorInvalidLevel(t *testing.T) {
+	_, err := NewDeflateCompressor(42)
+	assert.Error(t, err)
+}
+
+func TestCompressionEncodeDecode(t *testing.T) {
+	compressible := bytes.Repeat([]byte("sftp"), 4096)
+	random := make([]byte, 4096)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+
+	for _, data := range [][]byte{compressible, random, {}} {
+		c := &compression{offered: []Compressor{testDeflate(t)}}
+
+		pkts := []encoding.BinaryMarshaler{
+			&sshFxpWritePacket{ID: 1, Handle: "h", Offset: 1 << 33, Length: uint32(len(data)), Data: data},
+			&sshFxpDataPacket{ID: 2, Length: uint32(len(data)), Data: data},
+		}
+
+		for _, pkt := range pkts {
+			// nothing changes until compression is negotiated
+			assert.Equal(t, pkt, c.encode(pkt))
+		}
+
+		require.NoError(t, c.enable("deflate"))
+
+		for _, pkt := range pkts {
+			want, err := pkt.MarshalBinary()
+			require.NoError(t, err)
+
+			got, err := c.encode(pkt).MarshalBinary()
+			require.NoError(t, err)
+			if len(data) == len(compressible) {
+				assert.Less(t, len(got), len(want))
+			}
+
+			// strip the length and type, like recvPacket does
+			b, err := c.decode(got[4], got[5:])
+			require.NoError(t, err)
+			assert.Equal(t, want[5:], b)
+		}
+
+		assert.Equal(t, ErrSSHFxOpUnsupported, c.enable("zstd"))
+	}
+}
+
+func TestNewZstdCompressorInvalidLevel(t *testing.T) {
+	_, err := NewZstdCompressor(42)
+	assert.Error(t, err)
+}
+
+func TestZstdCompressor(t *testing.T) {
+	comp := defaultCompressors()[0]
+	assert.Equal(t, "zstd", comp.Name())
+
+	data := bytes.Repeat([]byte("sftp"), 4096)
+	b, err := comp.Compress([]byte{payloadCompressed}, data)
+	require.NoError(t, err)
+	assert.Less(t, len(b), len(data))
+
+	got, err := comp.Decompress([]byte("prefix"), b[1:], len(data))
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte("prefix"), data...), got)
+
+	_, err = comp.Decompress(nil, b[1:], len(data)-1)
+	assert.Equal(t, errCompressedPayload, err)
+
+	_, err = comp.Decompress(nil, []byte("not zstd"), len(data))
+	assert.Error(t, err)
+}
+
+func TestCompressionDecodeInvalid(t *testing.T) {
+	c := &compression{offered: []Compressor{testDeflate(t)}}
+	require.NoError(t, c.enable("deflate"))
+
+	for _, payload := range []string{"", "\x02abc", "\x01not deflate"} {
+		b := marshalString(marshalUint32(nil, 1), payload)
+		_, err := c.decode(sshFxpData, b)
+		assert.Error(t, err, "%q", payload)
+	}
+}
+
+// testCompressedTransfer writes and reads back a compressible file.
+func testCompressedTransfer(t *testing.T, client *Client, name string) {
+	content := bytes.Repeat([]byte("compress me "), 10000)
+
+	f, err := client.Create(name)
+	require.NoError(t, err)
+	_, err = f.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	f, err = client.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	got, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+}
+
+func TestServerCompression(t *testing.T) {
+	client, server := clientServerPairWith(t, []ServerOption{WithCompression(testDeflate(t))}, UseCompression(testDeflate(t)))
+	defer client.Close()
+	defer server.Close()
+
+	algos, ok := client.HasExtension(compressionExtension)
+	assert.True(t, ok)
+	assert.Equal(t, "deflate", algos)
+	require.NotNil(t, client.compression.get())
+	require.NotNil(t, server.compression.get())
+
+	name := filepath.Join(t.TempDir(), "compressed")
+	testCompressedTransfer(t, client, name)
+
+	// the file on disk is not compressed
+	b, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Len(t, b, len("compress me ")*10000)
+}
+
+func TestRequestServerCompression(t *testing.T) {
+	client, server := clientRequestServerPipe(t, InMemHandler(), []RequestServerOption{WithRSCompression(testDeflate(t))}, UseCompression(testDeflate(t)))
+	defer client.Close()
+	defer server.Close()
+
+	require.NotNil(t, client.compression.get())
+	testCompressedTransfer(t, client, "/compressed")
+}
+
+func TestCompressionDefault(t *testing.T) {
+	client, server := clientRequestServerPipe(t, InMemHandler(), nil)
+	defer client.Close()
+	defer server.Close()
+
+	algos, ok := client.HasExtension(compressionExtension)
+	assert.True(t, ok)
+	assert.Equal(t, "zstd", algos)
+	require.NotNil(t, client.compression.get())
+	assert.Equal(t, "zstd", client.compression.get().Name())
+	testCompressedTransfer(t, client, "/compressed")
+}
+
+func TestCompressionNotOffered(t *testing.T) {
+	client, server := clientServerPairWith(t, []ServerOption{WithCompression()}, UseCompression(testDeflate(t)))
+	defer client.Close()
+	defer server.Close()
+
+	_, ok := client.HasExtension(compressionExtension)
+	assert.False(t, ok)
+	assert.Nil(t, client.compression.get())
+
+	testCompressedTransfer(t, client, filepath.Join(t.TempDir(), "plain"))
+}
@@ -1,10 +1,14 @@
 package sftp
 
 import (
+	"context"
 	"encoding"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"sync"
+	"time"
 )
 
 // conn implements a bidirectional channel on which client and server
@@ -13,21 +17,47 @@ type conn struct {
 	io.Reader
 	io.WriteCloser
 	// this is the same allocator used in packet manager
-	alloc      *allocator
+	alloc       *allocator
+	compression compression
+	// pooled reads data packets into pages of the pagePool, see recvPacketPooled
+	pooled bool
+	// throttle paces the payload of reads and writes, if a bandwidth limit is set
+	throttle   *throttle
 	sync.Mutex // used to serialise writes to sendPacket
 }
 
 // the orderID is used in server mode if the allocator is enabled.
 // For the client mode just pass 0
 func (c *conn) recvPacket(orderID uint32) (uint8, []byte, error) {
-	return recvPacket(c, c.alloc, orderID)
+	typ, data, err := recvPacketPooled(c, c.alloc, orderID, c.pooled)
+	if err != nil {
+		return typ, data, err
+	}
+	c.throttle.recv(typ, data)
+
+	decoded, err := c.compression.decode(typ, data)
+	if c.pooled && (err != nil || cap(decoded) != cap(data)) {
+		// decompressed into a new slice, the page is not needed anymore
+		putPage(data)
+	}
+	return typ, decoded, err
 }
 
 func (c *conn) sendPacket(m encoding.BinaryMarshaler) error {
+	// compress outside of the lock, it can take a while
+	m = c.compression.encode(m)
+	c.throttle.send(m)
+
 	c.Lock()
 	defer c.Unlock()
 
-	return sendPacket(c, m)
+	err := sendPacket(c, m)
+	var cut *cutShortError
+	if errors.As(err, &cut) {
+		// nothing can be sent after the packet anymore
+		c.WriteCloser.Close()
+	}
+	return err
 }
 
 func (c *conn) Close() error {
@@ -40,8 +70,29 @@ type clientConn struct {
 	conn
 	wg sync.WaitGroup
 
-	sync.Mutex                          // protects inflight
+	sync.Mutex                          // protects inflight, pending and logged
 	inflight   map[uint32]chan<- result // outstanding requests
+	pending    map[uint32]idmarshaler   // outstanding requests kept for reconnect
+
+	// logger and metrics receive the events of logged, outstanding
+	// requests, if set by UseLogger and UseMetrics.
+	logger  Logger
+	metrics MetricsCollector
+	logged  map[uint32]loggedRequest
+
+	paths *pathRewrite  // rewrites the paths of requests, see WithPathRewrite
+	trace *sessionTrace // traces the operations, see UseTracer
+
+	attrCache *statCache // caches Stat and Lstat, see WithStatCache
+
+	// slots hands out the slots of the outstanding requests,
+	// if their number is capped by MaxInflightRequests.
+	slots *requestSlots
+
+	// reconnect re-establishes the session when the connection is lost,
+	// if set by WithAutoReconnect. It holds gate while it does so.
+	reconnect *reconnector
+	gate      sync.RWMutex
 
 	closed chan struct{}
 	err    error
@@ -55,17 +106,37 @@ func (c *clientConn) Wait() error {
 	return c.err
 }
 
+// isClosed reports whether the conn has shut down.
+func (c *clientConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
 // Close closes the SFTP session.
 func (c *clientConn) Close() error {
 	defer c.wg.Wait()
+	defer c.trace.end(nil)
+	if c.reconnect != nil {
+		c.reconnect.stop()
+	}
 	return c.conn.Close()
 }
 
 func (c *clientConn) loop() {
 	defer c.wg.Done()
-	err := c.recv()
-	if err != nil {
-		c.broadcastErr(err)
+	for {
+		err := c.recv()
+		if err != nil && c.reconnect != nil && c.reconnect.resume(err) {
+			continue
+		}
+		if err != nil {
+			c.broadcastErr(err)
+		}
+		return
 	}
 }
 
@@ -84,6 +155,14 @@ func (c *clientConn) recv() error {
 			return err
 		}
 
+		if c.logged != nil {
+			c.logResponse(sid, typ, data)
+		}
+
+		if c.attrCache != nil {
+			c.attrCache.answered(sid)
+		}
+
 		ch, ok := c.getChannel(sid)
 		if !ok {
 			// This is an unexpected occurrence. Send the error
@@ -96,7 +175,9 @@ func (c *clientConn) recv() error {
 	}
 }
 
-func (c *clientConn) putChannel(ch chan<- result, sid uint32) bool {
+func (c *clientConn) putChannel(ch chan<- result, p idmarshaler) bool {
+	sid := p.id()
+
 	c.Lock()
 	defer c.Unlock()
 
@@ -109,6 +190,12 @@ func (c *clientConn) putChannel(ch chan<- result, sid uint32) bool {
 	}
 
 	c.inflight[sid] = ch
+	if c.pending != nil {
+		c.pending[sid] = p
+	}
+	if c.logged != nil {
+		c.logged[sid] = newLoggedRequest(p)
+	}
 	return true
 }
 
@@ -118,10 +205,31 @@ func (c *clientConn) getChannel(sid uint32) (chan<- result, bool) {
 
 	ch, ok := c.inflight[sid]
 	delete(c.inflight, sid)
+	delete(c.pending, sid)
+	if ok {
+		c.release(sid)
+	}
 
 	return ch, ok
 }
 
+// acquire takes a slot for request p, waiting until one is free, the conn is
+// closed, or deadline has passed. A zero deadline waits forever.
+func (c *clientConn) acquire(p idmarshaler, deadline time.Time) error {
+	if c.slots == nil {
+		return nil
+	}
+	return c.slots.acquire(p, c.closed, deadline)
+}
+
+// release frees the slot of request sid, which is no longer outstanding.
+func (c *clientConn) release(sid uint32) {
+	if c.slots == nil {
+		return
+	}
+	c.slots.release(sid)
+}
+
 // result captures the result of receiving the a packet from the server
 type result struct {
 	typ  byte
@@ -135,26 +243,129 @@ type idmarshaler interface {
 }
 
 func (c *clientConn) sendPacket(ch chan result, p idmarshaler) (byte, []byte, error) {
+	return c.sendPacketDeadline(ch, p, time.Time{})
+}
+
+// sendPacketDeadline is sendPacket, but gives up waiting for the response
+// once deadline has passed. A zero deadline waits forever.
+func (c *clientConn) sendPacketDeadline(ch chan result, p idmarshaler, deadline time.Time) (byte, []byte, error) {
 	if cap(ch) < 1 {
 		ch = make(chan result, 1)
 	}
 
-	c.dispatchRequest(ch, p)
-	s := <-ch
+	c.dispatchRequestDeadline(ch, p, deadline)
+	s := c.awaitResult(ch, p.id(), deadline)
 	return s.typ, s.data, s.err
 }
 
+// awaitResult waits for the response to request sid on ch.
+// If deadline passes first, the request is abandoned with os.ErrDeadlineExceeded:
+// its response is dropped when it arrives, so ch may be reused right away.
+func (c *clientConn) awaitResult(ch chan result, sid uint32, deadline time.Time) result {
+	if deadline.IsZero() {
+		return <-ch
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case s := <-ch:
+		return s
+	case <-timer.C:
+	}
+
+	return c.abandon(ch, sid, os.ErrDeadlineExceeded)
+}
+
+// awaitCancel is awaitResult, but gives up once cancel is closed.
+func (c *clientConn) awaitCancel(ch chan result, sid uint32, cancel <-chan struct{}) result {
+	select {
+	case s := <-ch:
+		return s
+	case <-cancel:
+	}
+
+	return c.abandon(ch, sid, os.ErrDeadlineExceeded)
+}
+
+// sendPacketContext is sendPacket, but gives up waiting for a free slot and
+// the response once ctx is done.
+func (c *clientConn) sendPacketContext(ctx context.Context, ch chan result, p idmarshaler) (byte, []byte, error) {
+	if cap(ch) < 1 {
+		ch = make(chan result, 1)
+	}
+
+	deadline, _ := ctx.Deadline()
+	c.dispatchRequestDeadline(ch, p, deadline)
+	var s result
+	select {
+	case s = <-ch:
+	case <-ctx.Done():
+		s = c.abandon(ch, p.id(), ctx.Err())
+	}
+	return s.typ, s.data, s.err
+}
+
+// abandon gives up waiting for the response to request sid on ch with err:
+// the response is dropped when it arrives, so ch may be reused right away.
+// If the response is already on its way into ch, it is returned instead.
+func (c *clientConn) abandon(ch chan result, sid uint32, err error) result {
+	c.Lock()
+	if inflight, ok := c.inflight[sid]; ok && inflight == (chan<- result)(ch) {
+		// Replace the chan in inflight, like broadcastErr,
+		// so the late response cannot end up in ch.
+		c.inflight[sid] = make(chan<- result, 1)
+		delete(c.pending, sid)
+		c.Unlock()
+		return result{err: err}
+	}
+	c.Unlock()
+
+	// The response is already on its way into ch.
+	return <-ch
+}
+
 // dispatchRequest should ideally only be called by race-detection tests outside of this file,
 // where you have to ensure two packets are in flight sequentially after each other.
 func (c *clientConn) dispatchRequest(ch chan<- result, p idmarshaler) {
+	c.dispatchRequestDeadline(ch, p, time.Time{})
+}
+
+// dispatchRequestDeadline is dispatchRequest, but gives up waiting
+// for a free slot, see MaxInflightRequests, once deadline has passed.
+func (c *clientConn) dispatchRequestDeadline(ch chan<- result, p idmarshaler, deadline time.Time) {
 	sid := p.id()
 
-	if !c.putChannel(ch, sid) {
+	if err := c.acquire(p, deadline); err != nil {
+		ch <- result{err: err}
+		return
+	}
+
+	if c.reconnect != nil {
+		c.gate.RLock()
+		defer c.gate.RUnlock()
+	}
+
+	if c.attrCache != nil {
+		c.attrCache.sending(p)
+	}
+	p = c.paths.packet(p)
+	if !c.putChannel(ch, p) {
 		// already closed.
+		c.release(sid)
 		return
 	}
 
-	if err := c.conn.sendPacket(p); err != nil {
+	if err := c.conn.sendPacket(c.reconnect.translate(p)); err != nil {
+		var merr *marshalError
+		if c.reconnect != nil && !errors.As(err, &merr) {
+			// Leave the request to be replayed or failed,
+			// once the loop notices the lost connection.
+			c.conn.Close()
+			return
+		}
+
 		if ch, ok := c.getChannel(sid); ok {
 			ch <- result{err: err}
 		}
@@ -166,6 +377,13 @@ func (c *clientConn) broadcastErr(err error) {
 	c.Lock()
 	defer c.Unlock()
 
+	select {
+	case <-c.closed:
+		// already shut down, by the keepalive for example
+		return
+	default:
+	}
+
 	bcastRes := result{err: ErrSSHFxConnectionLost}
 	for sid, ch := range c.inflight {
 		ch <- bcastRes
@@ -174,6 +392,8 @@ func (c *clientConn) broadcastErr(err error) {
 		// we have hijacked this chan,
 		// and this guarantees always-only-once sending.
 		c.inflight[sid] = make(chan<- result, 1)
+		delete(c.pending, sid)
+		delete(c.logged, sid)
 	}
 
 	c.err = err
@@ -182,6 +402,25 @@ func (c *clientConn) broadcastErr(err error) {
 
 type serverConn struct {
 	conn
+	status   statusText     // of the status responses
+	stats    *sessionStats  // counts the traffic, see Server.Stats
+	protocol serverProtocol // the version negotiated with the client
+}
+
+func (s *serverConn) recvPacket(orderID uint32) (uint8, []byte, error) {
+	typ, data, err := s.conn.recvPacket(orderID)
+	if err == nil && s.stats != nil {
+		s.stats.received(typ, data)
+	}
+	return typ, data, err
+}
+
+func (s *serverConn) sendPacket(m encoding.BinaryMarshaler) error {
+	m = s.status.apply(m)
+	if s.stats != nil {
+		s.stats.sent(m)
+	}
+	return s.conn.sendPacket(s.protocol.encode(m))
 }
 
 func (s *serverConn) sendError(id uint32, err error) error {
@@ -0,0 +1,208 @@
+package sftp
+
+import (
+	"io"
+	iofs "io/fs"
+	"math"
+	"syscall"
+)
+
+// copyDataExtension copies data between two open handles on the server,
+// see https://github.com/openssh/openssh-portable/blob/master/PROTOCOL
+const copyDataExtension = "copy-data"
+
+// offsetWriter writes sequentially to w from off onwards.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (o *offsetWriter) Write(b []byte) (int, error) {
+	n, err := o.w.WriteAt(b, o.off)
+	o.off += int64(n)
+	return n, err
+}
+
+// copyData copies the requested range from r to w, until EOF
+// if no length was requested.
+func (p *sshFxpExtendedPacketCopyData) copyData(r io.ReaderAt, w io.WriterAt) error {
+	readOffset, err := toInt64(p.ReadFromOffset)
+	if err != nil {
+		return err
+	}
+	writeOffset, err := toInt64(p.WriteToOffset)
+	if err != nil {
+		return err
+	}
+	length, err := toInt64(p.ReadDataLength)
+	if err != nil {
+		return err
+	}
+	if length == 0 || length > math.MaxInt64-readOffset {
+		length = math.MaxInt64 - readOffset
+	}
+
+	if p.ReadFromHandle == p.WriteToHandle &&
+		writeOffset-readOffset < length && readOffset-writeOffset < length {
+		// the copy would read back what it wrote
+		return syscall.EINVAL
+	}
+
+	_, err = io.Copy(&offsetWriter{w: w, off: writeOffset}, io.NewSectionReader(r, readOffset, length))
+	return err
+}
+
+func (p *sshFxpExtendedPacketCopyData) respond(svr *Server) responsePacket {
+	r, ok := svr.getHandle(p.ReadFromHandle)
+	if !ok {
+		return statusFromError(p.ID, EBADF)
+	}
+	w, ok := svr.getHandle(p.WriteToHandle)
+	if !ok {
+		return statusFromError(p.ID, EBADF)
+	}
+
+	return statusFromError(p.ID, p.copyData(r, w))
+}
+
+// copyData copies between the readerAt and writerAt of two open requests.
+func (rs *RequestServer) copyData(pkt *sshFxpExtendedPacketCopyData) responsePacket {
+	src, ok := rs.getRequest(pkt.ReadFromHandle)
+	if !ok {
+		return statusFromError(pkt.ID, EBADF)
+	}
+	dst, ok := rs.getRequest(pkt.WriteToHandle)
+	if !ok {
+		return statusFromError(pkt.ID, EBADF)
+	}
+
+	var r io.ReaderAt
+	if rd, _, rw := src.getAllReaderWriters(); rd != nil {
+		r = rd
+	} else if rw != nil {
+		r = rw
+	}
+	var w io.WriterAt
+	if _, wr, rw := dst.getAllReaderWriters(); wr != nil {
+		w = wr
+	} else if rw != nil {
+		w = rw
+	}
+	if r == nil || w == nil {
+		return statusFromError(pkt.ID, EBADF)
+	}
+
+	return statusFromError(pkt.ID, pkt.copyData(r, w))
+}
+
+// CopyData asks the server to copy length bytes from src, starting at
+// srcOffset, to dst at dstOffset, without the data passing through the client.
+// A length of 0 copies everything up to the end of src.
+// src has to be open for reading and dst for writing, they may be the same
+// File if the ranges do not overlap.
+// The server has to support the copy-data extension.
+func (c *Client) CopyData(src *File, srcOffset int64, dst *File, dstOffset int64, length int64) error {
+	if srcOffset < 0 || dstOffset < 0 || length < 0 {
+		return iofs.ErrInvalid
+	}
+
+	id := c.nextID()
+	typ, data, err := c.sendPacket(nil, &sshFxpCopyDataPacket{
+		ID:             id,
+		ReadFromHandle: src.handle,
+		ReadFromOffset: uint64(srcOffset),
+		ReadDataLength: uint64(length),
+		WriteToHandle:  dst.handle,
+		WriteToOffset:  uint64(dstOffset),
+	})
+	if err != nil {
+		return err
+	}
+
+	switch typ {
+	case sshFxpStatus:
+		return normaliseError(unmarshalStatus(id, data))
+	default:
+		return unimplementedPacketErr(typ)
+	}
+}
+
+// A CopyFileOption configures Client.CopyFile.
+type CopyFileOption = TransferOption
+
+// CopyPreserve makes CopyFile give the copy the permissions and times of
+// the original, like WithPreserve(PreserveMode|PreserveTimes).
+func CopyPreserve() CopyFileOption {
+	return WithPreserve(PreserveMode | PreserveTimes)
+}
+
+// CopyThroughClient makes CopyFile pass the data through the client even
+// where the server supports the copy-data extension.
+func CopyThroughClient() CopyFileOption {
+	return func(o *transferOptions) {
+		o.throughClient = true
+	}
+}
+
+// CopyFile copies the regular file src to dst, which is created or
+// truncated. Where the server supports the copy-data extension, it copies
+// the data itself. Otherwise the data passes through the client, read and
+// written concurrently, up to the size src had when CopyFile opened it.
+//
+// WithPreserve gives the copy the attributes src had when CopyFile opened it.
+func (c *Client) CopyFile(src, dst string, opts ...CopyFileOption) (err error) {
+	defer c.startOp("Client.CopyFile", src, "").done(&err)
+	defer linkError(&err, "copy", src, dst)
+
+	o := newTransferOptions(opts)
+
+	r, err := c.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	fi, err := r.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return &iofs.PathError{Op: "copy", Path: src, Err: syscall.EINVAL}
+	}
+
+	w, err := c.OpenFile(dst, syscall.O_WRONLY|syscall.O_CREAT|syscall.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.HasExtension(copyDataExtension); ok && !o.throughClient {
+		err = c.CopyData(r, 0, w, 0, 0)
+	} else {
+		err = copyThroughClient(r, w, fi.Size())
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && o.preserve != 0 {
+		err = c.preserveRemote(dst, fi, o.preserve)
+	}
+	return err
+}
+
+// copyThroughClient copies size bytes from r to w, with the concurrent reads
+// of WriteTo feeding the concurrent writes of ReadFrom.
+func copyThroughClient(r, w *File, size int64) error {
+	pr, pw := io.Pipe()
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := r.WriteTo(pw)
+		pw.CloseWithError(err)
+		readErr <- err
+	}()
+
+	_, err := w.ReadFrom(&io.LimitedReader{R: pr, N: size})
+	// stop the reads if src grew, or the writes failed
+	pr.Close()
+	if err := <-readErr; err != nil && err != io.ErrClosedPipe {
+		return err
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package sftp
+
+import (
+	"bytes"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCopyData(t *testing.T, client *Client, dir string) {
+	src, err := client.Create(path.Join(dir, "src"))
+	require.NoError(t, err)
+	defer src.Close()
+	_, err = src.Write([]byte("0123456789"))
+	require.NoError(t, err)
+
+	dst, err := client.Create(path.Join(dir, "dst"))
+	require.NoError(t, err)
+	defer dst.Close()
+
+	require.NoError(t, client.CopyData(src, 2, dst, 0, 4))
+	require.NoError(t, client.CopyData(src, 8, dst, 4, 0)) // up to EOF
+	require.NoError(t, client.CopyData(src, 0, dst, 6, 100))
+
+	// within the same file, without overlapping
+	require.NoError(t, client.CopyData(src, 0, src, 10, 5))
+	assert.Error(t, client.CopyData(src, 0, src, 5, 10))
+	assert.Error(t, client.CopyData(src, 0, src, 20, 0))
+
+	assert.Equal(t, iofs.ErrInvalid, client.CopyData(src, -1, dst, 0, 0))
+
+	got := make([]byte, 32)
+	n, err := dst.ReadAt(got, 0)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "2345890123456789", string(got[:n]))
+
+	n, err = src.ReadAt(got, 0)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "012345678901234", string(got[:n]))
+}
+
+func TestServerCopyData(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	_, ok := client.HasExtension(copyDataExtension)
+	assert.True(t, ok)
+
+	testCopyData(t, client, t.TempDir())
+}
+
+func TestServerCopyDataBadHandle(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	name := path.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(name, []byte("data"), 0644))
+	f, err := client.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	assert.Error(t, client.CopyData(f, 0, &File{c: client, handle: "bogus"}, 0, 0))
+	assert.Error(t, client.CopyData(&File{c: client, handle: "bogus"}, 0, f, 0, 0))
+}
+
+func TestRequestCopyData(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+
+	testCopyData(t, p.cli, "/")
+	checkRequestServerAllocator(t, p)
+}
+
+func TestClientCopyFile(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	src := path.Join(dir, "src")
+	content := bytes.Repeat([]byte("0123456789abcdef"), 20000)
+	require.NoError(t, os.WriteFile(src, content, 0o640))
+	mtime := time.Unix(1500000000, 0)
+	require.NoError(t, os.Chtimes(src, mtime, mtime))
+
+	for name, opts := range map[string][]CopyFileOption{
+		"copy-data":      {CopyPreserve()},
+		"through-client": {CopyPreserve(), CopyThroughClient()},
+	} {
+		dst := path.Join(dir, name)
+		require.NoError(t, client.CopyFile(src, dst, opts...), name)
+		b, err := os.ReadFile(dst)
+		require.NoError(t, err)
+		assert.Equal(t, content, b, name)
+		fi, err := os.Stat(dst)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o640), fi.Mode().Perm(), name)
+		assert.True(t, fi.ModTime().Equal(mtime), name)
+	}
+
+	// without CopyPreserve, the copy is a new file
+	dst := path.Join(dir, "plain")
+	require.NoError(t, client.CopyFile(src, dst, CopyThroughClient()))
+	fi, err := os.Stat(dst)
+	require.NoError(t, err)
+	assert.False(t, fi.ModTime().Equal(mtime))
+
+	assert.Error(t, client.CopyFile(dir, path.Join(dir, "dir")), "not a regular file")
+	assert.True(t, os.IsNotExist(client.CopyFile(path.Join(dir, "missing"), dst)))
+}
@@ -0,0 +1,68 @@
+package sftp
+
+import (
+	"sync"
+	"time"
+)
+
+// fileDeadline is the read or write deadline of a File. Like those of
+// net.Conn, it applies to the requests outstanding already, when it is set,
+// as well as to those sent later. Its zero value is no deadline.
+type fileDeadline struct {
+	mu     sync.Mutex
+	t      time.Time
+	timer  *time.Timer
+	cancel chan struct{} // closed once the deadline has passed
+}
+
+// set sets the deadline to t, a zero t meaning no deadline.
+func (d *fileDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		// the timer fired, wait for it to close cancel
+		<-d.cancel
+	}
+	d.timer = nil
+	d.t = t
+
+	if d.cancel == nil || isClosedChan(d.cancel) {
+		d.cancel = make(chan struct{})
+	}
+	if t.IsZero() {
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+	close(d.cancel)
+}
+
+// deadline returns the deadline, the zero time if there is none.
+func (d *fileDeadline) deadline() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.t
+}
+
+// wait returns a channel closed once the deadline has passed.
+func (d *fileDeadline) wait() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancel == nil {
+		d.cancel = make(chan struct{})
+	}
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,139 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stallingHandler serves reads and writes that stall until released.
+type stallingHandler struct {
+	release chan struct{}
+}
+
+func (h stallingHandler) ReadAt(b []byte, off int64) (int, error) {
+	<-h.release
+	return copy(b, "hello"), nil
+}
+
+func (h stallingHandler) WriteAt(b []byte, off int64) (int, error) {
+	<-h.release
+	return len(b), nil
+}
+
+func (h stallingHandler) Fileread(*Request) (io.ReaderAt, error) {
+	return h, nil
+}
+
+func (h stallingHandler) Filewrite(*Request) (io.WriterAt, error) {
+	return h, nil
+}
+
+func (h stallingHandler) OpenFile(*Request) (WriterAtReaderAt, error) {
+	return h, nil
+}
+
+func TestFileDeadlines(t *testing.T) {
+
+	h := stallingHandler{release: make(chan struct{})}
+	mem := InMemHandler()
+	client, server := clientRequestServerPipe(t, Handlers{FileGet: h, FilePut: h, FileCmd: mem.FileCmd, FileList: mem.FileList}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	f, err := client.OpenFile("/stalling", os.O_RDWR|os.O_CREATE)
+	require.NoError(t, err)
+
+	require.NoError(t, f.SetReadDeadline(time.Now().Add(20*time.Millisecond)))
+	_, err = f.ReadAt(make([]byte, 5), 0)
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded), "got %v", err)
+
+	require.NoError(t, f.SetWriteDeadline(time.Now().Add(20*time.Millisecond)))
+	_, err = f.WriteAt([]byte("hello"), 0)
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded), "got %v", err)
+
+	// the abandoned requests complete, the connection stays usable
+	close(h.release)
+
+	require.NoError(t, f.SetReadDeadline(time.Time{}))
+	b := make([]byte, 5)
+	n, err := f.ReadAt(b, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b[:n]))
+
+	require.NoError(t, f.SetWriteDeadline(time.Time{}))
+	_, err = f.WriteAt([]byte("hello"), 0)
+	require.NoError(t, err)
+
+	_, err = client.Getwd()
+	require.NoError(t, err)
+}
+
+func TestFileDeadlinePending(t *testing.T) {
+
+	h := stallingHandler{release: make(chan struct{})}
+	mem := InMemHandler()
+	client, server := clientRequestServerPipe(t, Handlers{FileGet: h, FilePut: h, FileCmd: mem.FileCmd, FileList: mem.FileList}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	f, err := client.OpenFile("/stalling", os.O_RDWR|os.O_CREATE)
+	require.NoError(t, err)
+
+	// setting the deadline fails the outstanding requests, like with net.Conn
+	errs := make(chan error, 1)
+	go func() {
+		_, err := f.ReadAt(make([]byte, 5), 0)
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, f.SetReadDeadline(time.Now()))
+	err = <-errs
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded), "got %v", err)
+
+	// extending it keeps them waiting
+	require.NoError(t, f.SetWriteDeadline(time.Now().Add(20*time.Millisecond)))
+	go func() {
+		_, err := f.WriteAt([]byte("hello"), 0)
+		errs <- err
+	}()
+	require.NoError(t, f.SetWriteDeadline(time.Time{}))
+	select {
+	case err := <-errs:
+		t.Fatalf("write returned before its response: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(h.release)
+	require.NoError(t, <-errs)
+
+	require.NoError(t, f.SetReadDeadline(time.Time{}))
+	b := make([]byte, 5)
+	n, err := f.ReadAt(b, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b[:n]))
+}
+
+func TestFileDeadlineReset(t *testing.T) {
+	var d fileDeadline
+	select {
+	case <-d.wait():
+		t.Fatal("no deadline has passed")
+	default:
+	}
+
+	d.set(time.Now())
+	<-d.wait()
+	assert.False(t, d.deadline().IsZero())
+
+	d.set(time.Now().Add(time.Millisecond))
+	<-d.wait()
+
+	d.set(time.Now().Add(time.Hour))
+	d.set(time.Time{})
+	assert.False(t, isClosedChan(d.wait()))
+}
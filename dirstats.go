@@ -0,0 +1,197 @@
+package sftp
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"syscall"
+)
+
+// dirStatsExtension asks the server to summarise a directory tree,
+// so clients do not need to walk it with one request per directory.
+const dirStatsExtension = "dir-stats@github.com/pkg/sftp"
+
+// DirStats summarises the entries below a directory, similar to du --inodes.
+// Symbolic links are counted but not followed.
+type DirStats struct {
+	Dirs     uint64 // number of subdirectories
+	Files    uint64 // number of regular files
+	Symlinks uint64 // number of symbolic links
+	Others   uint64 // number of sockets, devices, named pipes, ...
+	Size     uint64 // total size of the regular files in bytes
+}
+
+func (s *DirStats) add(fi fs.FileInfo) {
+	switch mode := fi.Mode(); {
+	case mode.IsDir():
+		s.Dirs++
+	case mode.IsRegular():
+		s.Files++
+		s.Size += uint64(fi.Size())
+	case mode&fs.ModeSymlink != 0:
+		s.Symlinks++
+	default:
+		s.Others++
+	}
+}
+
+// walkDirStats sums up the tree below root, which has to be a directory.
+// readDir must not follow symbolic links.
+func walkDirStats(root fs.FileInfo, name string, readDir func(string) ([]fs.FileInfo, error)) (*DirStats, error) {
+	if !root.IsDir() {
+		return nil, &fs.PathError{Op: "dirstats", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	stats := new(DirStats)
+	dirs := []string{name}
+	for len(dirs) > 0 {
+		dir := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+
+		infos, err := readDir(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, fi := range infos {
+			stats.add(fi)
+			if fi.IsDir() {
+				dirs = append(dirs, path.Join(dir, fi.Name()))
+			}
+		}
+	}
+	return stats, nil
+}
+
+type sshFxpDirStatsReply struct {
+	ID uint32
+	DirStats
+}
+
+func (p *sshFxpDirStatsReply) id() uint32 { return p.ID }
+
+func (p *sshFxpDirStatsReply) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		5*8 // 5*uint64
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtendedReply)
+	b = marshalUint32(b, p.ID)
+	b = marshalUint64(b, p.Dirs)
+	b = marshalUint64(b, p.Files)
+	b = marshalUint64(b, p.Symlinks)
+	b = marshalUint64(b, p.Others)
+	b = marshalUint64(b, p.Size)
+
+	return b, nil
+}
+
+func unmarshalDirStats(b []byte) (*DirStats, error) {
+	var s DirStats
+	var err error
+	for _, v := range []*uint64{&s.Dirs, &s.Files, &s.Symlinks, &s.Others, &s.Size} {
+		if *v, b, err = unmarshalUint64Safe(b); err != nil {
+			return nil, err
+		}
+	}
+	return &s, nil
+}
+
+func (p *sshFxpExtendedPacketDirStats) respond(svr *Server) responsePacket {
+	name := toLocalPath(p.Path)
+
+	root, err := svr.fs.Stat(name)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	stats, err := walkDirStats(root, name, func(dir string) ([]fs.FileInfo, error) {
+		entries, err := svr.fs.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		infos := make([]fs.FileInfo, 0, len(entries))
+		for _, entry := range entries {
+			fi, err := entry.Info()
+			if err != nil {
+				// removed while walking
+				continue
+			}
+			infos = append(infos, fi)
+		}
+		return infos, nil
+	})
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	return &sshFxpDirStatsReply{ID: p.ID, DirStats: *stats}
+}
+
+// dirStats answers dir-stats requests from the DirStatsFileLister handler,
+// or by listing every directory through Filelist if it is not implemented.
+func (rs *RequestServer) dirStats(pkt *sshFxpExtendedPacketDirStats) responsePacket {
+	request := NewRequest("DirStats", pkt.Path)
+
+	if lister, ok := rs.Handlers.FileList.(DirStatsFileLister); ok {
+		stats, err := lister.DirStats(request)
+		if err != nil {
+			return statusFromError(pkt.ID, err)
+		}
+		return &sshFxpDirStatsReply{ID: pkt.ID, DirStats: *stats}
+	}
+
+	infos, err := listAll(rs.Handlers.FileList, NewRequest("Stat", pkt.Path))
+	if err != nil {
+		return statusFromError(pkt.ID, err)
+	}
+	if len(infos) == 0 {
+		return statusFromError(pkt.ID, fs.ErrNotExist)
+	}
+
+	stats, err := walkDirStats(infos[0], request.Filepath, func(dir string) ([]fs.FileInfo, error) {
+		return listAll(rs.Handlers.FileList, NewRequest("List", dir))
+	})
+	if err != nil {
+		return statusFromError(pkt.ID, err)
+	}
+
+	return &sshFxpDirStatsReply{ID: pkt.ID, DirStats: *stats}
+}
+
+// listAll returns every entry of the ListerAt returned for r.
+func listAll(h FileLister, r *Request) ([]fs.FileInfo, error) {
+	lister, err := h.Filelist(r)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := lister.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	pager, paged := lister.(PagedListerAt)
+	var cursor string
+
+	var infos []fs.FileInfo
+	buf := make([]fs.FileInfo, MaxFilelist)
+	for {
+		var n int
+		if paged {
+			n, cursor, err = pager.ListPage(buf, cursor)
+		} else {
+			n, err = lister.ListAt(buf, int64(len(infos)))
+		}
+		infos = append(infos, buf[:n]...)
+		if err == io.EOF {
+			return infos, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			// a ListerAt not returning io.EOF at the end
+			return infos, nil
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerDirStats(t *testing.T) {
+	skipIfWindows(t)
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub", "subsub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), []byte("hello"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b"), []byte("foo"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "subsub", "c"), nil, 0644))
+	require.NoError(t, os.Symlink("sub", filepath.Join(dir, "link")))
+
+	stats, err := client.DirStats(dir)
+	require.NoError(t, err)
+	assert.Equal(t, &DirStats{
+		Dirs:     2,
+		Files:    3,
+		Symlinks: 1,
+		Size:     8,
+	}, stats)
+
+	_, err = client.DirStats(filepath.Join(dir, "a"))
+	assert.Error(t, err)
+
+	_, err = client.DirStats(filepath.Join(dir, "missing"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRequestDirStats(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+
+	require.NoError(t, p.cli.Mkdir("/dir"))
+	require.NoError(t, p.cli.Mkdir("/dir/sub"))
+	_, err := putTestFile(p.cli, "/dir/a", "hello")
+	require.NoError(t, err)
+	_, err = putTestFile(p.cli, "/dir/sub/b", "foo")
+	require.NoError(t, err)
+	_, err = putTestFile(p.cli, "/other", "not counted")
+	require.NoError(t, err)
+
+	stats, err := p.cli.DirStats("/dir")
+	require.NoError(t, err)
+	assert.Equal(t, &DirStats{
+		Dirs:  1,
+		Files: 2,
+		Size:  8,
+	}, stats)
+
+	_, err = p.cli.DirStats("/other")
+	assert.Error(t, err)
+
+	_, err = p.cli.DirStats("/missing")
+	assert.Error(t, err)
+	checkRequestServerAllocator(t, p)
+}
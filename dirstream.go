@@ -0,0 +1,105 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	iofs "io/fs"
+)
+
+// DirStream lists a directory page by page, as the server sends it, see
+// Client.ReadDirStream.
+type DirStream struct {
+	c      *Client
+	handle string
+	page   []iofs.FileInfo // not returned yet
+	info   iofs.FileInfo
+	err    error
+	closed bool
+}
+
+// ReadDirStream opens the directory p for a DirStream, which reads the
+// entries of each SSH_FXP_READDIR response as Next gets to them, rather
+// than all of them at once like ReadDir. The DirStream holds a handle on
+// the server until it is closed, or Next returns false.
+//
+//	d, err := client.ReadDirStream(dir)
+//	if err != nil {
+//		return err
+//	}
+//	defer d.Close()
+//	for d.Next() {
+//		fmt.Println(d.Info().Name())
+//	}
+//	return d.Err()
+func (c *Client) ReadDirStream(p string) (_ *DirStream, err error) {
+	defer c.startOp("Client.ReadDirStream", p, "").done(&err)
+	defer pathError(&err, "readdir", p)
+
+	handle, err := c.opendir(p)
+	if err != nil {
+		return nil, err
+	}
+	return &DirStream{c: c, handle: handle}, nil
+}
+
+// Next advances to the next entry, which Info returns, reading the next page
+// of the listing if needed. It returns false at the end of the listing or
+// on an error, which Err returns, and closes the DirStream then.
+func (d *DirStream) Next() bool {
+	for len(d.page) == 0 {
+		if d.err != nil || d.closed {
+			d.info = nil
+			return false
+		}
+		d.page, d.err = d.c.readDirPage(context.Background(), d.handle)
+		if d.err != nil {
+			d.Close()
+		}
+	}
+	d.info, d.page = d.page[0], d.page[1:]
+	return true
+}
+
+// Info returns the entry Next advanced to.
+func (d *DirStream) Info() iofs.FileInfo {
+	return d.info
+}
+
+// Err returns the error which ended the listing, or nil at its end.
+func (d *DirStream) Err() error {
+	if d.err == io.EOF {
+		return nil
+	}
+	return d.err
+}
+
+// Close closes the handle of the directory, ending the listing. Closing a
+// closed DirStream does nothing.
+func (d *DirStream) Close() error {
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	d.page = nil
+	return d.c.close(d.handle)
+}
+
+// ReadDirN reads up to n entries of the directory p, in the order the
+// server lists them, and stops listing once it has them. If n <= 0, it
+// reads all of them, like ReadDir.
+func (c *Client) ReadDirN(p string, n int) (_ []iofs.FileInfo, err error) {
+	defer c.startOp("Client.ReadDirN", p, "").done(&err)
+	defer pathError(&err, "readdir", p)
+
+	d, err := c.ReadDirStream(p)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	var infos []iofs.FileInfo
+	for (n <= 0 || len(infos) < n) && d.Next() {
+		infos = append(infos, d.Info())
+	}
+	return infos, d.Err()
+}
@@ -0,0 +1,58 @@
+package sftp
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientReadDirStream(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	var want []string
+	for i := 0; i < 250; i++ {
+		name := "file" + strconv.Itoa(i)
+		require.NoError(t, os.WriteFile(path.Join(dir, name), nil, 0o644))
+		want = append(want, name)
+	}
+	sort.Strings(want)
+
+	d, err := client.ReadDirStream(dir)
+	require.NoError(t, err)
+	var got []string
+	for d.Next() {
+		got = append(got, d.Info().Name())
+	}
+	require.NoError(t, d.Err())
+	assert.Nil(t, d.Info())
+	assert.False(t, d.Next(), "stays at the end")
+	require.NoError(t, d.Close())
+	sort.Strings(got)
+	assert.Equal(t, want, got)
+
+	// closing early
+	d, err = client.ReadDirStream(dir)
+	require.NoError(t, err)
+	require.True(t, d.Next())
+	require.NoError(t, d.Close())
+	assert.False(t, d.Next())
+	assert.NoError(t, d.Err())
+
+	infos, err := client.ReadDirN(dir, 10)
+	require.NoError(t, err)
+	assert.Len(t, infos, 10)
+	infos, err = client.ReadDirN(dir, 0)
+	require.NoError(t, err)
+	assert.Len(t, infos, 250)
+
+	_, err = client.ReadDirStream(path.Join(dir, "missing"))
+	assert.True(t, os.IsNotExist(err), "%v", err)
+}
@@ -0,0 +1,349 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sync"
+
+	"github.com/pkg/sftp/apis"
+)
+
+// diskUsageExtension asks the server to sum up the disk usage of a tree,
+// so clients do not need to walk it with one request per directory.
+const diskUsageExtension = "disk-usage@github.com/pkg/sftp"
+
+// duConcurrency is how many directories the client lists at once
+// when it walks a tree for DiskUsage and Du itself.
+const duConcurrency = 8
+
+// defaultDiskUsageLimit is the number of entries a Server looks at to answer
+// a disk-usage request, unless WithDiskUsageLimit says otherwise.
+const defaultDiskUsageLimit = 1 << 20
+
+// errDiskUsageCanceled stops the walk of a Server which is shutting down.
+var errDiskUsageCanceled = errors.New("sftp: disk usage canceled")
+
+// DiskUsage sums up a file or a directory tree, similar to du.
+// Symbolic links are counted but not followed.
+type DiskUsage struct {
+	Dirs      uint64 // number of directories, including the root if it is one
+	Files     uint64 // number of other entries, like regular files and symbolic links
+	Size      uint64 // apparent size of the regular files in bytes
+	Allocated uint64 // bytes allocated on disk, or the apparent size if the server cannot tell
+	Complete  bool   // false if the server stopped at its limit before seeing every entry
+}
+
+func (u *DiskUsage) add(fi fs.FileInfo) {
+	if fi.IsDir() {
+		u.Dirs++
+	} else {
+		u.Files++
+	}
+	if fi.Mode().IsRegular() {
+		u.Size += uint64(fi.Size())
+	}
+	if n, ok := allocatedSize(fi); ok {
+		u.Allocated += n
+	} else if fi.Mode().IsRegular() {
+		u.Allocated += uint64(fi.Size())
+	}
+}
+
+// walkDiskUsage sums up root and the tree below it, looking at no more than
+// limit entries. readDir must not follow symbolic links. The walk stops with
+// errDiskUsageCanceled once done is closed.
+func walkDiskUsage(root fs.FileInfo, name string, limit int, done <-chan struct{}, readDir func(string) ([]fs.FileInfo, error)) (*DiskUsage, error) {
+	usage := &DiskUsage{Complete: true}
+	usage.add(root)
+	if !root.IsDir() {
+		return usage, nil
+	}
+
+	seen := 1
+	dirs := []string{name}
+	for len(dirs) > 0 {
+		select {
+		case <-done:
+			return nil, errDiskUsageCanceled
+		default:
+		}
+
+		dir := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+
+		infos, err := readDir(dir)
+		if err != nil {
+			if dir != name && errors.Is(err, fs.ErrNotExist) {
+				// removed while walking
+				continue
+			}
+			return nil, err
+		}
+
+		for _, fi := range infos {
+			if seen >= limit {
+				usage.Complete = false
+				return usage, nil
+			}
+			seen++
+
+			usage.add(fi)
+			if fi.IsDir() {
+				dirs = append(dirs, path.Join(dir, fi.Name()))
+			}
+		}
+	}
+	return usage, nil
+}
+
+// WithDiskUsageLimit has the Server look at no more than n entries to answer
+// a disk-usage request, instead of 1<<20, so that a request on a huge tree
+// cannot keep the server busy for long. Beyond that, clients get the sums
+// so far, marked as incomplete.
+func WithDiskUsageLimit(n int) ServerOption {
+	return func(s *Server) error {
+		if n < 1 {
+			return fmt.Errorf("sftp: disk usage limit %d is not positive", n)
+		}
+		s.duLimit = n
+		return nil
+	}
+}
+
+// DiskUsage returns the number of entries, the apparent size and the space
+// allocated on disk of the file or directory tree at path.
+//
+// If the server supports the disk-usage@github.com/pkg/sftp extension, as
+// the Server of this package does, it sums up the tree in a single round
+// trip, which is much faster than walking a large tree from the client.
+// Otherwise DiskUsage walks the tree itself, listing several directories at
+// once, and reports the apparent size as the allocated one.
+func (c *Client) DiskUsage(path string) (_ *DiskUsage, err error) {
+	defer pathError(&err, "diskusage", path)
+
+	if _, ok := c.HasExtension(diskUsageExtension); ok {
+		usage, err := c.diskUsage(path)
+		if status, ok := err.(*StatusError); !ok || status.FxCode() != ErrSSHFxOpUnsupported {
+			return usage, err
+		}
+	}
+
+	root, err := c.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.walkDiskUsage(root, path, duConcurrency)
+}
+
+// Du returns the number of entries of the file or directory tree at path,
+// including path, and the apparent size of the regular files among them,
+// like du -s --apparent-size but counting the entries too. Symbolic links
+// are counted but not followed.
+//
+// Like DiskUsage, Du has the server sum up the tree where it supports the
+// disk-usage@github.com/pkg/sftp extension. It walks the tree itself
+// otherwise, or if the tree was too large for the server to sum up.
+func (c *Client) Du(path string) (entries, bytes int64, err error) {
+	defer pathError(&err, "du", path)
+
+	if _, ok := c.HasExtension(diskUsageExtension); ok {
+		usage, err := c.diskUsage(path)
+		if err == nil && usage.Complete {
+			return int64(usage.Dirs + usage.Files), int64(usage.Size), nil
+		}
+		if status, ok := err.(*StatusError); err != nil && (!ok || status.FxCode() != ErrSSHFxOpUnsupported) {
+			return 0, 0, err
+		}
+	}
+
+	root, err := c.Lstat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	usage, err := c.walkDiskUsage(root, path, duConcurrency)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int64(usage.Dirs + usage.Files), int64(usage.Size), nil
+}
+
+// walkDiskUsage sums up root and the tree below it like the walkDiskUsage of
+// a Server, with n workers listing a directory each at the same time.
+func (c *Client) walkDiskUsage(root fs.FileInfo, name string, n int) (*DiskUsage, error) {
+	usage := &DiskUsage{Complete: true}
+	usage.add(root)
+	if !root.IsDir() {
+		return usage, nil
+	}
+
+	var (
+		mu       sync.Mutex
+		more     = sync.NewCond(&mu) // signalled when dirs or busy change
+		dirs     = []string{name}
+		busy     int // workers listing a directory
+		firstErr error
+	)
+	work := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for {
+			for len(dirs) == 0 && busy > 0 && firstErr == nil {
+				more.Wait()
+			}
+			if len(dirs) == 0 || firstErr != nil {
+				// done, let the others find out too
+				more.Broadcast()
+				return
+			}
+
+			dir := dirs[len(dirs)-1]
+			dirs = dirs[:len(dirs)-1]
+			busy++
+			mu.Unlock()
+			infos, err := c.ReadDir(dir)
+			mu.Lock()
+			busy--
+
+			switch {
+			case err == nil:
+				for _, fi := range infos {
+					usage.add(fi)
+					if fi.IsDir() {
+						dirs = append(dirs, path.Join(dir, fi.Name()))
+					}
+				}
+			case dir != name && errors.Is(err, fs.ErrNotExist):
+				// removed while walking
+			case firstErr == nil:
+				firstErr = err
+			}
+			more.Broadcast()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work()
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return usage, nil
+}
+
+func (c *Client) diskUsage(path string) (*DiskUsage, error) {
+	id := c.nextID()
+	typ, data, err := c.sendPacket(nil, &sshFxpDiskUsagePacket{
+		ID:   id,
+		Path: path,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case sshFxpExtendedReply:
+		sid, data, err := unmarshalUint32Safe(data)
+		if err != nil {
+			return nil, err
+		}
+		if sid != id {
+			return nil, &unexpectedIDErr{id, sid}
+		}
+		return unmarshalDiskUsage(data)
+
+	case sshFxpStatus:
+		return nil, normaliseError(unmarshalStatus(id, data))
+
+	default:
+		return nil, unimplementedPacketErr(typ)
+	}
+}
+
+type sshFxpDiskUsageReply struct {
+	ID uint32
+	DiskUsage
+}
+
+func (p *sshFxpDiskUsageReply) id() uint32 { return p.ID }
+
+func (p *sshFxpDiskUsageReply) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4*8 + // 4*uint64
+		1 // byte(complete)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtendedReply)
+	b = marshalUint32(b, p.ID)
+	b = marshalUint64(b, p.Dirs)
+	b = marshalUint64(b, p.Files)
+	b = marshalUint64(b, p.Size)
+	b = marshalUint64(b, p.Allocated)
+	if p.Complete {
+		b = append(b, 1)
+	} else {
+		b = append(b, 0)
+	}
+
+	return b, nil
+}
+
+func unmarshalDiskUsage(b []byte) (*DiskUsage, error) {
+	var u DiskUsage
+	var err error
+	for _, v := range []*uint64{&u.Dirs, &u.Files, &u.Size, &u.Allocated} {
+		if *v, b, err = unmarshalUint64Safe(b); err != nil {
+			return nil, err
+		}
+	}
+	if len(b) < 1 {
+		return nil, errShortPacket
+	}
+	u.Complete = b[0] != 0
+	return &u, nil
+}
+
+func (p *sshFxpExtendedPacketDiskUsage) respond(svr *Server) responsePacket {
+	name := toLocalPath(p.Path)
+
+	root, err := apis.Lstat(svr.fs, name)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	limit := svr.duLimit
+	if limit == 0 {
+		limit = defaultDiskUsageLimit
+	}
+
+	usage, err := walkDiskUsage(root, name, limit, svr.done, func(dir string) ([]fs.FileInfo, error) {
+		entries, err := svr.fs.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		infos := make([]fs.FileInfo, 0, len(entries))
+		for _, entry := range entries {
+			fi, err := entry.Info()
+			if err != nil {
+				// removed while walking
+				continue
+			}
+			infos = append(infos, fi)
+		}
+		return infos, nil
+	})
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	return &sshFxpDiskUsageReply{ID: p.ID, DiskUsage: *usage}
+}
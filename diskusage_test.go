@@ -0,0 +1,148 @@
+package sftp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerDiskUsage(t *testing.T) {
+	skipIfWindows(t)
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub", "subsub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), make([]byte, 5000), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b"), []byte("foo"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "subsub", "c"), nil, 0644))
+	require.NoError(t, os.Symlink("sub", filepath.Join(dir, "link")))
+
+	usage, err := client.DiskUsage(dir)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), usage.Dirs)
+	assert.Equal(t, uint64(4), usage.Files)
+	assert.Equal(t, uint64(5003), usage.Size)
+	assert.NotZero(t, usage.Allocated)
+	assert.True(t, usage.Complete)
+
+	usage, err = client.DiskUsage(filepath.Join(dir, "a"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), usage.Files)
+	assert.Equal(t, uint64(5000), usage.Size)
+
+	_, err = client.DiskUsage(filepath.Join(dir, "missing"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestServerDiskUsageLimit(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644))
+	}
+
+	client, server := clientServerPair(t, WithDiskUsageLimit(3))
+	defer client.Close()
+	defer server.Close()
+
+	usage, err := client.DiskUsage(dir)
+	require.NoError(t, err)
+	assert.False(t, usage.Complete)
+	assert.Equal(t, uint64(3), usage.Dirs+usage.Files)
+
+	_, err = NewServer(nil, apis.NewAVFS(), WithDiskUsageLimit(0))
+	assert.Error(t, err)
+}
+
+func TestDiskUsageCanceled(t *testing.T) {
+	root, err := os.Lstat(t.TempDir())
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	close(done)
+	_, err = walkDiskUsage(root, "/", 10, done, nil)
+	assert.Equal(t, errDiskUsageCanceled, err)
+}
+
+func TestRequestDiskUsage(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+
+	require.NoError(t, p.cli.Mkdir("/dir"))
+	require.NoError(t, p.cli.Mkdir("/dir/sub"))
+	_, err := putTestFile(p.cli, "/dir/a", "hello")
+	require.NoError(t, err)
+	_, err = putTestFile(p.cli, "/dir/sub/b", "foo")
+	require.NoError(t, err)
+
+	// the client walks the tree itself
+	usage, err := p.cli.DiskUsage("/dir")
+	require.NoError(t, err)
+	assert.Equal(t, &DiskUsage{
+		Dirs:      2,
+		Files:     2,
+		Size:      8,
+		Allocated: 8,
+		Complete:  true,
+	}, usage)
+
+	_, err = p.cli.DiskUsage("/missing")
+	assert.Error(t, err)
+	checkRequestServerAllocator(t, p)
+}
+
+func TestClientDu(t *testing.T) {
+	skipIfWindows(t)
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub", "subsub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), make([]byte, 5000), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b"), []byte("foo"), 0644))
+	require.NoError(t, os.Symlink("sub", filepath.Join(dir, "link")))
+
+	entries, bytes, err := client.Du(dir)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), entries)
+	assert.Equal(t, int64(5003), bytes)
+
+	// the same, walking the tree on the client
+	root, err := client.Lstat(dir)
+	require.NoError(t, err)
+	usage, err := client.walkDiskUsage(root, dir, 3)
+	require.NoError(t, err)
+	assert.Equal(t, &DiskUsage{Dirs: 3, Files: 3, Size: 5003, Allocated: 5003, Complete: true}, usage)
+
+	_, _, err = client.Du(filepath.Join(dir, "missing"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRequestDu(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+
+	require.NoError(t, p.cli.Mkdir("/dir"))
+	for i := 0; i < 20; i++ {
+		sub := fmt.Sprintf("/dir/%d", i)
+		require.NoError(t, p.cli.Mkdir(sub))
+		_, err := putTestFile(p.cli, sub+"/file", "hello")
+		require.NoError(t, err)
+	}
+
+	entries, bytes, err := p.cli.Du("/dir")
+	require.NoError(t, err)
+	assert.Equal(t, int64(41), entries)
+	assert.Equal(t, int64(100), bytes)
+
+	_, _, err = p.cli.Du("/missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,180 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openSSHEOF is what the sftp-server of OpenSSH answers to the probes of
+// eofProbe, which the servers of this package have to answer alike.
+var openSSHEOF = map[string]string{
+	"read empty file":   "EOF",
+	"read at the end":   "EOF",
+	"read past the end": "EOF",
+	"read across end":   "data 2",
+	"read after EOF":    "EOF",
+	"readdir empty dir": "names 0, EOF",
+	"readdir dir":       "names 2, EOF",
+	"readdir after EOF": "EOF",
+}
+
+// eofProbe sets up files in dir, and returns what the server answers to
+// requests at the end of them. Listings leave out "." and "..", which only
+// some servers list.
+func eofProbe(t *testing.T, c *Client, dir string) map[string]string {
+	put := func(name, data string) {
+		f, err := c.Create(path.Join(dir, name))
+		require.NoError(t, err)
+		_, err = f.Write([]byte(data))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	put("empty", "")
+	put("hello", "hello")
+	require.NoError(t, c.Mkdir(path.Join(dir, "emptydir")))
+	require.NoError(t, c.Mkdir(path.Join(dir, "dir")))
+	put("dir/a", "a")
+	put("dir/b", "b")
+
+	status := func(id uint32, typ byte, data []byte, err error) string {
+		require.NoError(t, err)
+		switch typ {
+		case sshFxpStatus:
+			err := unmarshalStatus(id, data)
+			if status, ok := err.(*StatusError); ok && status.Code == sshFxEOF {
+				return "EOF"
+			}
+			return err.Error()
+		case sshFxpData:
+			n, _ := unmarshalUint32(data[4:])
+			return fmt.Sprintf("data %d", n)
+		}
+		return fmt.Sprintf("unexpected %v", fxp(typ))
+	}
+
+	read := func(name string, offset uint64) string {
+		f, err := c.Open(path.Join(dir, name))
+		require.NoError(t, err)
+		defer f.Close()
+		id := c.nextID()
+		typ, data, err := c.sendPacket(nil, &sshFxpReadPacket{ID: id, Handle: f.handle, Offset: offset, Len: 4})
+		return status(id, typ, data, err)
+	}
+
+	readdir := func(handle string) string {
+		var names int
+		for i := 0; i < 10; i++ {
+			id := c.nextID()
+			typ, data, err := c.sendPacket(nil, &sshFxpReaddirPacket{ID: id, Handle: handle})
+			if typ != sshFxpName {
+				return fmt.Sprintf("names %d, %s", names, status(id, typ, data, err))
+			}
+			count, data := unmarshalUint32(data[4:])
+			for j := uint32(0); j < count; j++ {
+				var name string
+				name, data = unmarshalString(data)
+				_, data = unmarshalString(data) // longname
+				_, data = unmarshalAttrs(data)
+				if name != "." && name != ".." {
+					names++
+				}
+			}
+		}
+		return fmt.Sprintf("names %d, no EOF", names)
+	}
+
+	answers := map[string]string{
+		"read empty file":   read("empty", 0),
+		"read at the end":   read("hello", 5),
+		"read past the end": read("hello", 9),
+		"read across end":   read("hello", 3),
+	}
+
+	f, err := c.Open(path.Join(dir, "hello"))
+	require.NoError(t, err)
+	_, err = f.ReadAt(make([]byte, 5), 0)
+	require.NoError(t, err)
+	id := c.nextID()
+	typ, data, err := c.sendPacket(nil, &sshFxpReadPacket{ID: id, Handle: f.handle, Offset: 5, Len: 4})
+	answers["read after EOF"] = status(id, typ, data, err)
+	require.NoError(t, f.Close())
+
+	handle, err := c.opendir(path.Join(dir, "emptydir"))
+	require.NoError(t, err)
+	answers["readdir empty dir"] = readdir(handle)
+	require.NoError(t, c.close(handle))
+
+	handle, err = c.opendir(path.Join(dir, "dir"))
+	require.NoError(t, err)
+	answers["readdir dir"] = readdir(handle)
+	id = c.nextID()
+	typ, data, err = c.sendPacket(nil, &sshFxpReaddirPacket{ID: id, Handle: handle})
+	answers["readdir after EOF"] = status(id, typ, data, err)
+	require.NoError(t, c.close(handle))
+
+	return answers
+}
+
+func TestEOFConformance(t *testing.T) {
+	t.Run("Server", func(t *testing.T) {
+		client, server := clientServerPair(t)
+		defer client.Close()
+		defer server.Close()
+		assert.Equal(t, openSSHEOF, eofProbe(t, client, t.TempDir()))
+	})
+
+	t.Run("RequestServer", func(t *testing.T) {
+		p := clientRequestServerPair(t)
+		defer p.Close()
+		assert.Equal(t, openSSHEOF, eofProbe(t, p.cli, "/"))
+	})
+
+	t.Run("OpenSSH", func(t *testing.T) {
+		client, cmd := testClient(t, READWRITE, NODELAY)
+		defer cmd.Wait()
+		defer client.Close()
+		assert.Equal(t, openSSHEOF, eofProbe(t, client, t.TempDir()))
+	})
+}
+
+// emptyReaderAt reads nothing, without reporting io.EOF.
+type emptyReaderAt struct{}
+
+func (emptyReaderAt) ReadAt(p []byte, off int64) (int, error) { return 0, nil }
+
+// emptyListerAt lists nothing, without reporting io.EOF.
+type emptyListerAt struct{}
+
+func (emptyListerAt) ListAt(ls []fs.FileInfo, offset int64) (int, error) { return 0, nil }
+
+func TestRequestEmptyIsEOF(t *testing.T) {
+	handlers := newTestHandlers()
+
+	request := testRequest("Get")
+	request.state.readerAt = emptyReaderAt{}
+	rpkt := request.call(handlers, &sshFxpReadPacket{ID: 1, Handle: "a", Len: 4}, nil, 0)
+	if assert.IsType(t, &sshFxpStatusPacket{}, rpkt) {
+		assert.EqualValues(t, sshFxEOF, rpkt.(*sshFxpStatusPacket).Code)
+	}
+
+	request = testRequest("List")
+	request.state.listerAt = emptyListerAt{}
+	rpkt = request.call(handlers, &sshFxpReaddirPacket{ID: 2, Handle: "a"}, nil, 0)
+	if assert.IsType(t, &sshFxpStatusPacket{}, rpkt) {
+		assert.EqualValues(t, sshFxEOF, rpkt.(*sshFxpStatusPacket).Code)
+	}
+}
+
+func TestStatusFromWrappedEOF(t *testing.T) {
+	err := fmt.Errorf("read %s: %w", "foo", io.EOF)
+	assert.EqualValues(t, sshFxEOF, statusFromError(1, err).Code)
+	assert.EqualValues(t, sshFxFailure, statusFromError(1, io.ErrUnexpectedEOF).Code)
+	assert.EqualValues(t, sshFxFailure, statusFromError(1, errors.New("EOF")).Code)
+}
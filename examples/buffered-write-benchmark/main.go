@@ -11,7 +11,7 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/pkg/sftp/internal/apis"
+	"github.com/pkg/sftp/apis"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
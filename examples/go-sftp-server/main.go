@@ -12,7 +12,7 @@ import (
 	"net"
 	"os"
 
-	"github.com/pkg/sftp/internal/apis"
+	"github.com/pkg/sftp/apis"
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
@@ -0,0 +1,69 @@
+package sftp
+
+import (
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// expandPathExtension is like realpath, but expands "~" and "~user" first.
+const expandPathExtension = "expand-path@openssh.com"
+
+// HomeDirResolver returns the home directory of the named user,
+// or of the user the server runs as if username is empty.
+type HomeDirResolver func(username string) (string, error)
+
+// WithHomeDirResolver sets how a Server expands "~" and "~user" for
+// expand-path@openssh.com requests. By default the home directories
+// are looked up with os/user.
+func WithHomeDirResolver(resolve HomeDirResolver) ServerOption {
+	return func(s *Server) error {
+		s.homeDir = resolve
+		return nil
+	}
+}
+
+func osHomeDir(username string) (string, error) {
+	var u *user.User
+	var err error
+	if username == "" {
+		u, err = user.Current()
+	} else {
+		u, err = user.Lookup(username)
+	}
+	if err != nil {
+		return "", err
+	}
+	return u.HomeDir, nil
+}
+
+// expandHome replaces a leading "~" or "~user" of p with the home directory.
+func expandHome(p string, resolve HomeDirResolver) (string, error) {
+	if !strings.HasPrefix(p, "~") {
+		return p, nil
+	}
+
+	username, rest := p[1:], ""
+	if i := strings.IndexByte(username, '/'); i >= 0 {
+		username, rest = username[:i], username[i:]
+	}
+
+	home, err := resolve(username)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(home) + rest, nil
+}
+
+func (p *sshFxpExtendedPacketExpandPath) respond(svr *Server) responsePacket {
+	resolve := svr.homeDir
+	if resolve == nil {
+		resolve = osHomeDir
+	}
+
+	expanded, err := expandHome(p.Path, resolve)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+	return realpathResponse(p.ID, expanded)
+}
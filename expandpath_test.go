@@ -0,0 +1,89 @@
+package sftp
+
+import (
+	"errors"
+	"os/user"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errNoSuchUser = errors.New("no such user")
+
+func testHomeDirs(username string) (string, error) {
+	switch username {
+	case "":
+		return "/home/me", nil
+	case "alice":
+		return "/srv/alice/", nil
+	}
+	return "", errNoSuchUser
+}
+
+func TestExpandHome(t *testing.T) {
+	for _, tt := range []struct {
+		path, want string
+	}{
+		{"/abs/path", "/abs/path"},
+		{"rel/~path", "rel/~path"},
+		{"~", "/home/me"},
+		{"~/", "/home/me/"},
+		{"~/docs/a", "/home/me/docs/a"},
+		{"~alice", "/srv/alice/"},
+		{"~alice/docs", "/srv/alice//docs"},
+	} {
+		got, err := expandHome(tt.path, testHomeDirs)
+		require.NoError(t, err, tt.path)
+		assert.Equal(t, tt.want, got, tt.path)
+	}
+
+	_, err := expandHome("~bob/docs", testHomeDirs)
+	assert.Equal(t, errNoSuchUser, err)
+}
+
+func TestServerExpandPath(t *testing.T) {
+	skipIfWindows(t)
+	client, server := clientServerPair(t, WithHomeDirResolver(testHomeDirs))
+	defer client.Close()
+	defer server.Close()
+
+	_, ok := client.HasExtension(expandPathExtension)
+	assert.True(t, ok)
+
+	got, err := client.ExpandPath("~alice/docs/../pics")
+	require.NoError(t, err)
+	assert.Equal(t, "/srv/alice/pics", got)
+
+	got, err = client.ExpandPath("/no/tilde/")
+	require.NoError(t, err)
+	assert.Equal(t, "/no/tilde", got)
+
+	_, err = client.ExpandPath("~bob")
+	assert.Error(t, err)
+}
+
+func TestServerExpandPathOSUser(t *testing.T) {
+	skipIfWindows(t)
+	u, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	got, err := client.ExpandPath("~")
+	require.NoError(t, err)
+	assert.Equal(t, cleanPath(u.HomeDir), got)
+}
+
+func TestRequestExpandPathUnsupported(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+
+	_, err := p.cli.ExpandPath("~")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrSSHFxOpUnsupported), "got %v", err)
+}
@@ -0,0 +1,91 @@
+package sftp
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exportTestTree(t *testing.T) string {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(path.Join(dir, "sub"), 0755))
+	require.NoError(t, os.WriteFile(path.Join(dir, "sub", "file"), []byte("hello"), 0640))
+	require.NoError(t, os.Symlink("sub/file", path.Join(dir, "link")))
+	return dir
+}
+
+func TestClientExportListingJSONL(t *testing.T) {
+	skipIfWindows(t)
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := exportTestTree(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, client.ExportListing(dir, ListingJSONL, &buf))
+
+	recs := make(map[string]listingRecord)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec listingRecord
+		require.NoError(t, json.Unmarshal([]byte(line), &rec))
+		recs[rec.Path] = rec
+	}
+	require.Len(t, recs, 4)
+
+	file := recs[path.Join(dir, "sub", "file")]
+	assert.Equal(t, int64(5), file.Size)
+	assert.Equal(t, "-rw-r-----", file.Mode)
+	assert.Equal(t, uint32(os.Getuid()), file.UID)
+
+	assert.Equal(t, "sub/file", recs[path.Join(dir, "link")].Link)
+	assert.True(t, strings.HasPrefix(recs[dir].Mode, "d"))
+}
+
+func TestClientExportListingCSV(t *testing.T) {
+	skipIfWindows(t)
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := exportTestTree(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, client.ExportListing(dir, ListingCSV, &buf))
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 5)
+	assert.Equal(t, listingHeader, rows[0])
+
+	var paths []string
+	for _, row := range rows[1:] {
+		paths = append(paths, row[0])
+	}
+	assert.ElementsMatch(t, []string{
+		dir,
+		path.Join(dir, "link"),
+		path.Join(dir, "sub"),
+		path.Join(dir, "sub", "file"),
+	}, paths)
+}
+
+func TestClientExportListingErrors(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	var buf bytes.Buffer
+	assert.Error(t, client.ExportListing(t.TempDir(), ListingFormat(42), &buf))
+	assert.Zero(t, buf.Len())
+
+	err := client.ExportListing(path.Join(t.TempDir(), "missing"), ListingCSV, &buf)
+	assert.True(t, os.IsNotExist(err))
+}
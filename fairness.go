@@ -0,0 +1,206 @@
+package sftp
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// requestSlots caps the requests outstanding at once, see MaxInflightRequests,
+// handing out the slots fairly: the reads and writes of Files, which come in
+// pipelined bursts, take turns file by file, and cannot take the last slots,
+// which are kept for the other requests, like Stat or ReadDir. Those never
+// wait behind the transfers then, only behind each other.
+type requestSlots struct {
+	limit    int // of the requests outstanding
+	reserved int // of the slots only the other requests take
+
+	mu       sync.Mutex
+	inflight int
+	data     map[uint32]bool // the outstanding requests which read or write a File
+	ndata    int             // of those
+
+	waiting []*slotWaiter            // the other requests, first come first served
+	files   map[string][]*slotWaiter // the reads and writes, by handle
+	turns   []string                 // the handles waiting, whose turn is next first
+}
+
+// slotWaiter is a request waiting for a slot.
+type slotWaiter struct {
+	sid      uint32
+	handle   string
+	transfer bool          // reads or writes a File
+	ready    chan struct{} // closed once the slot is taken for it
+	granted  bool
+}
+
+func newRequestSlots(limit int) *requestSlots {
+	// one in eight slots, but keep one for the transfers
+	reserved := limit / 8
+	if reserved == 0 && limit > 1 {
+		reserved = 1
+	}
+	return &requestSlots{
+		limit:    limit,
+		reserved: reserved,
+		data:     make(map[uint32]bool),
+		files:    make(map[string][]*slotWaiter),
+	}
+}
+
+// transferHandle returns the handle of the File request p reads or writes.
+func transferHandle(p idmarshaler) (string, bool) {
+	switch p := p.(type) {
+	case trailerPacket:
+		return transferHandle(p.idmarshaler)
+	case *sshFxpReadPacket:
+		return p.Handle, true
+	case *sshFxpWritePacket:
+		return p.Handle, true
+	case *sshFxpCopyDataPacket:
+		return p.WriteToHandle, true
+	}
+	return "", false
+}
+
+// acquire takes a slot for request p, waiting until one is free and it is
+// the turn of p, closed is closed, or deadline has passed. A zero deadline
+// waits forever.
+func (s *requestSlots) acquire(p idmarshaler, closed <-chan struct{}, deadline time.Time) error {
+	handle, transfer := transferHandle(p)
+	w := &slotWaiter{sid: p.id(), handle: handle, transfer: transfer, ready: make(chan struct{})}
+
+	s.mu.Lock()
+	if transfer {
+		if len(s.turns) == 0 && s.freeForTransfers() {
+			s.take(w)
+			s.mu.Unlock()
+			return nil
+		}
+		if len(s.files[handle]) == 0 {
+			s.turns = append(s.turns, handle)
+		}
+		s.files[handle] = append(s.files[handle], w)
+	} else {
+		if len(s.waiting) == 0 && s.inflight < s.limit {
+			s.take(w)
+			s.mu.Unlock()
+			return nil
+		}
+		s.waiting = append(s.waiting, w)
+	}
+	s.mu.Unlock()
+
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var err error
+	select {
+	case <-w.ready:
+		return nil
+	case <-closed:
+		err = ErrSSHFxConnectionLost
+	case <-expired:
+		err = os.ErrDeadlineExceeded
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.granted {
+		// given the slot meanwhile, hand it on
+		s.free(w.sid)
+	} else {
+		s.dequeue(w)
+	}
+	return err
+}
+
+// release frees the slot of request sid, which is no longer outstanding.
+func (s *requestSlots) release(sid uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.free(sid)
+}
+
+func (s *requestSlots) freeForTransfers() bool {
+	return s.inflight < s.limit && s.ndata < s.limit-s.reserved
+}
+
+// take gives a slot to w. The caller holds s.mu.
+func (s *requestSlots) take(w *slotWaiter) {
+	s.inflight++
+	if w.transfer {
+		s.ndata++
+		s.data[w.sid] = true
+	}
+	w.granted = true
+}
+
+// free frees the slot of request sid and hands out the slots free then,
+// first to the other requests, then to the reads and writes of the Files
+// in turn. The caller holds s.mu.
+func (s *requestSlots) free(sid uint32) {
+	s.inflight--
+	if s.data[sid] {
+		delete(s.data, sid)
+		s.ndata--
+	}
+
+	for len(s.waiting) > 0 && s.inflight < s.limit {
+		w := s.waiting[0]
+		s.waiting = s.waiting[1:]
+		s.take(w)
+		close(w.ready)
+	}
+	for len(s.turns) > 0 && s.freeForTransfers() {
+		handle := s.turns[0]
+		queue := s.files[handle]
+		w := queue[0]
+		s.turns = s.turns[1:]
+		if len(queue) > 1 {
+			s.files[handle] = queue[1:]
+			s.turns = append(s.turns, handle)
+		} else {
+			delete(s.files, handle)
+		}
+		s.take(w)
+		close(w.ready)
+	}
+}
+
+// dequeue removes w, which gave up waiting, from its queue.
+// The caller holds s.mu.
+func (s *requestSlots) dequeue(w *slotWaiter) {
+	if w.transfer {
+		queue := s.files[w.handle]
+		for i, v := range queue {
+			if v == w {
+				queue = append(queue[:i:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) > 0 {
+			s.files[w.handle] = queue
+			return
+		}
+		delete(s.files, w.handle)
+		for i, handle := range s.turns {
+			if handle == w.handle {
+				s.turns = append(s.turns[:i:i], s.turns[i+1:]...)
+				break
+			}
+		}
+		return
+	}
+
+	for i, v := range s.waiting {
+		if v == w {
+			s.waiting = append(s.waiting[:i:i], s.waiting[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package sftp
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// acquireAsync acquires a slot for p in the background,
+// sending the error once it has one.
+func acquireAsync(s *requestSlots, p idmarshaler, closed chan struct{}) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- s.acquire(p, closed, time.Time{}) }()
+	return done
+}
+
+func waitQueued(t *testing.T, s *requestSlots, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		queued := len(s.waiting)
+		for _, queue := range s.files {
+			queued += len(queue)
+		}
+		return queued == n
+	}, time.Second, time.Millisecond)
+}
+
+func assertWaiting(t *testing.T, done <-chan error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		t.Fatalf("got a slot out of turn: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestRequestSlotsReserved(t *testing.T) {
+	closed := make(chan struct{})
+	s := newRequestSlots(8)
+	require.Equal(t, 1, s.reserved)
+
+	for i := uint32(0); i < 7; i++ {
+		require.NoError(t, s.acquire(&sshFxpWritePacket{ID: i, Handle: "a"}, closed, time.Time{}))
+	}
+	write := acquireAsync(s, &sshFxpWritePacket{ID: 7, Handle: "a"}, closed)
+	waitQueued(t, s, 1)
+
+	// the transfers leave a slot for the others
+	require.NoError(t, s.acquire(&sshFxpStatPacket{ID: 8}, closed, time.Time{}))
+	assertWaiting(t, write)
+
+	s.release(8)
+	assertWaiting(t, write)
+	s.release(0)
+	require.NoError(t, <-write)
+
+	assert.Equal(t, os.ErrDeadlineExceeded, s.acquire(&sshFxpReadPacket{ID: 9, Handle: "b"}, closed, time.Now().Add(time.Millisecond)))
+	waitQueued(t, s, 0)
+	assert.Empty(t, s.turns)
+	close(closed)
+	assert.Equal(t, ErrSSHFxConnectionLost, s.acquire(&sshFxpReadPacket{ID: 9, Handle: "b"}, closed, time.Time{}))
+}
+
+func TestRequestSlotsTurns(t *testing.T) {
+	closed := make(chan struct{})
+	s := newRequestSlots(3) // two slots for transfers
+
+	require.NoError(t, s.acquire(&sshFxpWritePacket{ID: 1, Handle: "a"}, closed, time.Time{}))
+	require.NoError(t, s.acquire(&sshFxpWritePacket{ID: 2, Handle: "a"}, closed, time.Time{}))
+	a3 := acquireAsync(s, &sshFxpWritePacket{ID: 3, Handle: "a"}, closed)
+	waitQueued(t, s, 1)
+	a4 := acquireAsync(s, &sshFxpWritePacket{ID: 4, Handle: "a"}, closed)
+	waitQueued(t, s, 2)
+	b1 := acquireAsync(s, &sshFxpReadPacket{ID: 5, Handle: "b"}, closed)
+	waitQueued(t, s, 3)
+
+	// the other requests go first, not taking the slots of the transfers
+	stat := acquireAsync(s, &sshFxpStatPacket{ID: 6}, closed)
+	require.NoError(t, <-stat)
+	stat = acquireAsync(s, &sshFxpStatPacket{ID: 7}, closed)
+	waitQueued(t, s, 4)
+	s.release(1)
+	require.NoError(t, <-stat)
+	assertWaiting(t, a3)
+
+	// then the files take turns
+	s.release(6)
+	require.NoError(t, <-a3)
+	s.release(2)
+	require.NoError(t, <-b1)
+	assertWaiting(t, a4)
+	s.release(3)
+	require.NoError(t, <-a4)
+}
@@ -0,0 +1,113 @@
+package sftp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FeatureUsage is a snapshot of the protocol features used during a session.
+// Packets is keyed by packet type name (e.g. "SSH_FXP_OPEN") and Extensions
+// by the extended request name (e.g. "posix-rename@openssh.com"); the values
+// are the number of times each was requested by the client.
+//
+// Unknown extended requests are counted as well, so operators can find out
+// which extensions their clients try to use even if they are not supported.
+//
+// Once Serve returns, a Server or RequestServer reports the FeatureUsage of the
+// session to its Logger and MetricsCollector, if they implement
+// FeatureUsageLogger or FeatureUsageCollector.
+type FeatureUsage struct {
+	Packets    map[string]uint64
+	Extensions map[string]uint64
+}
+
+// String returns the used features sorted by name, one "name=count" per
+// entry, packets first.
+func (u FeatureUsage) String() string {
+	var entries []string
+	for _, m := range []map[string]uint64{u.Packets, u.Extensions} {
+		names := make([]string, 0, len(m))
+		for name := range m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			entries = append(entries, fmt.Sprintf("%s=%d", name, m[name]))
+		}
+	}
+	return strings.Join(entries, " ")
+}
+
+// FeatureUsageLogger is a Logger which also receives the features every
+// session used, see FeatureUsage. A Server or RequestServer calls
+// LogFeatureUsage once its Serve returns.
+type FeatureUsageLogger interface {
+	Logger
+	LogFeatureUsage(FeatureUsage)
+}
+
+// FeatureUsageCollector is a MetricsCollector which also counts the features
+// sessions used, see FeatureUsage. A Server or RequestServer calls
+// AddFeatureUsage once its Serve returns.
+type FeatureUsageCollector interface {
+	MetricsCollector
+	AddFeatureUsage(FeatureUsage)
+}
+
+// reportFeatureUsage passes the session-end report u to logger and metrics,
+// if they take it; either may be nil.
+func reportFeatureUsage(logger Logger, metrics MetricsCollector, u FeatureUsage) {
+	if l, ok := logger.(FeatureUsageLogger); ok {
+		l.LogFeatureUsage(u)
+	}
+	if m, ok := metrics.(FeatureUsageCollector); ok {
+		m.AddFeatureUsage(u)
+	}
+}
+
+// featureTracker records which packet types and extensions a session used.
+// It is safe to use concurrently.
+type featureTracker struct {
+	mu         sync.Mutex
+	packets    map[fxp]uint64
+	extensions map[string]uint64
+}
+
+func newFeatureTracker() *featureTracker {
+	return &featureTracker{
+		packets:    make(map[fxp]uint64),
+		extensions: make(map[string]uint64),
+	}
+}
+
+// record counts an incoming packet, pkt may be nil or partially unmarshaled.
+func (t *featureTracker) record(pktType fxp, pkt requestPacket) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.packets[pktType]++
+
+	if p, ok := pkt.(*sshFxpExtendedPacket); ok && p.ExtendedRequest != "" {
+		t.extensions[p.ExtendedRequest]++
+	}
+}
+
+func (t *featureTracker) snapshot() FeatureUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u := FeatureUsage{
+		Packets:    make(map[string]uint64, len(t.packets)),
+		Extensions: make(map[string]uint64, len(t.extensions)),
+	}
+	for typ, n := range t.packets {
+		u.Packets[typ.String()] += n
+	}
+	for name, n := range t.extensions {
+		u.Extensions[name] = n
+	}
+	return u
+}
@@ -0,0 +1,134 @@
+package sftp
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeatureTracker(t *testing.T) {
+	tracker := newFeatureTracker()
+
+	tracker.record(sshFxpOpen, &sshFxpOpenPacket{})
+	tracker.record(sshFxpOpen, &sshFxpOpenPacket{})
+	tracker.record(sshFxpExtended, &sshFxpExtendedPacket{ExtendedRequest: "posix-rename@openssh.com"})
+	tracker.record(sshFxpExtended, &sshFxpExtendedPacket{ExtendedRequest: "unknown@example.com"})
+	tracker.record(sshFxpStat, nil)
+
+	usage := tracker.snapshot()
+	assert.Equal(t, map[string]uint64{
+		"SSH_FXP_OPEN":     2,
+		"SSH_FXP_EXTENDED": 2,
+		"SSH_FXP_STAT":     1,
+	}, usage.Packets)
+	assert.Equal(t, map[string]uint64{
+		"posix-rename@openssh.com": 1,
+		"unknown@example.com":      1,
+	}, usage.Extensions)
+	assert.Equal(t, "SSH_FXP_EXTENDED=2 SSH_FXP_OPEN=2 SSH_FXP_STAT=1 posix-rename@openssh.com=1 unknown@example.com=1", usage.String())
+
+	// snapshots must not alias the tracker state
+	usage.Packets["SSH_FXP_OPEN"] = 42
+	assert.Equal(t, uint64(2), tracker.snapshot().Packets["SSH_FXP_OPEN"])
+}
+
+func TestServerFeatureUsage(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	_, err := client.Stat("/doesnotexist")
+	assert.Error(t, err)
+	_, err = client.StatVFS("/")
+	_ = err // statvfs may be unsupported on this platform, it is counted regardless
+
+	usage := server.FeatureUsage()
+	assert.Equal(t, uint64(1), usage.Packets["SSH_FXP_INIT"])
+	assert.Equal(t, uint64(1), usage.Packets["SSH_FXP_STAT"])
+	assert.Equal(t, uint64(1), usage.Extensions["statvfs@openssh.com"])
+}
+
+func TestRequestServerFeatureUsage(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+
+	err := p.cli.Mkdir("/foo")
+	assert.NoError(t, err)
+	err = p.cli.PosixRename("/foo", "/bar")
+	assert.NoError(t, err)
+
+	usage := p.svr.FeatureUsage()
+	assert.Equal(t, uint64(1), usage.Packets["SSH_FXP_MKDIR"])
+	assert.Equal(t, uint64(1), usage.Extensions["posix-rename@openssh.com"])
+}
+
+// featureReporter is a FeatureUsageLogger and FeatureUsageCollector
+// keeping the session-end reports.
+type featureReporter struct {
+	mu      sync.Mutex
+	logged  []FeatureUsage
+	metered []FeatureUsage
+}
+
+func (r *featureReporter) Log(LogEvent)                                 {}
+func (r *featureReporter) ObserveRequest(string, string, time.Duration) {}
+func (r *featureReporter) AddBytes(int64, int64)                        {}
+func (r *featureReporter) AddOpenHandles(int)                           {}
+
+func (r *featureReporter) LogFeatureUsage(u FeatureUsage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logged = append(r.logged, u)
+}
+
+func (r *featureReporter) AddFeatureUsage(u FeatureUsage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metered = append(r.metered, u)
+}
+
+func (r *featureReporter) reports() (logged, metered []FeatureUsage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.logged, r.metered
+}
+
+func TestServerFeatureUsageReport(t *testing.T) {
+	r := new(featureReporter)
+	client, server := clientServerPair(t, WithLogger(r), WithMetrics(r))
+
+	_, err := client.Stat("/doesnotexist")
+	assert.Error(t, err)
+
+	server.Close()
+	client.Close()
+	<-server.done
+
+	require.Eventually(t, func() bool {
+		logged, metered := r.reports()
+		return len(logged) == 1 && len(metered) == 1
+	}, 10*time.Second, time.Millisecond)
+
+	logged, metered := r.reports()
+	assert.Equal(t, uint64(1), logged[0].Packets["SSH_FXP_STAT"])
+	assert.Equal(t, logged, metered)
+}
+
+func TestRequestServerFeatureUsageReport(t *testing.T) {
+	r := new(featureReporter)
+	p := clientRequestServerPair(t, WithRSLogger(r), WithRSMetrics(r))
+
+	err := p.cli.Mkdir("/foo")
+	assert.NoError(t, err)
+
+	p.Close()
+	<-p.svrResult
+
+	logged, metered := r.reports()
+	require.Len(t, logged, 1)
+	assert.Equal(t, uint64(1), logged[0].Packets["SSH_FXP_MKDIR"])
+	assert.Equal(t, logged, metered)
+}
@@ -0,0 +1,198 @@
+package sftp
+
+import (
+	"io/fs"
+)
+
+// globExtension asks the server to match a pattern, so clients do not need
+// to list every directory it runs through with requests of their own.
+const globExtension = "glob@github.com/pkg/sftp"
+
+// The flags of a glob request, the GlobOptions of the pattern.
+const (
+	globFlagDoublestar = 1 << iota
+	globFlagBraces
+)
+
+// globReplyOverhead is the size of a glob reply without the matches.
+const globReplyOverhead = 4 + 1 + 4 + 4 // uint32(length) + byte(type) + uint32(id) + uint32(count)
+
+func (o *globOptions) flags() uint32 {
+	var flags uint32
+	if o.doublestar {
+		flags |= globFlagDoublestar
+	}
+	if o.braces {
+		flags |= globFlagBraces
+	}
+	return flags
+}
+
+func globOptionsFromFlags(flags uint32) *globOptions {
+	return &globOptions{
+		doublestar: flags&globFlagDoublestar != 0,
+		braces:     flags&globFlagBraces != 0,
+	}
+}
+
+// globRemote has the server match pattern. Bad patterns, and patterns
+// matching too much for a single reply, fail with a *StatusError.
+func (c *Client) globRemote(pattern string, o *globOptions) ([]string, error) {
+	id := c.nextID()
+	typ, data, err := c.sendPacket(nil, &sshFxpGlobPacket{
+		ID:      id,
+		Pattern: pattern,
+		Flags:   o.flags(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case sshFxpExtendedReply:
+		sid, data, err := unmarshalUint32Safe(data)
+		if err != nil {
+			return nil, err
+		}
+		if sid != id {
+			return nil, &unexpectedIDErr{id, sid}
+		}
+		return unmarshalGlobMatches(data)
+
+	case sshFxpStatus:
+		err := normaliseError(unmarshalStatus(id, data))
+		if err == nil {
+			// a status is no answer to a glob request
+			err = &StatusError{Code: sshFxFailure}
+		}
+		return nil, err
+
+	default:
+		return nil, unimplementedPacketErr(typ)
+	}
+}
+
+func unmarshalGlobMatches(b []byte) ([]string, error) {
+	count, b, err := unmarshalUint32Safe(b)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, nil
+	}
+	if int64(count) > int64(len(b)/4) {
+		return nil, errShortPacket
+	}
+	matches := make([]string, count)
+	for i := range matches {
+		if matches[i], b, err = unmarshalStringSafe(b); err != nil {
+			return nil, err
+		}
+	}
+	return matches, nil
+}
+
+type sshFxpGlobReply struct {
+	ID      uint32
+	Matches []string
+}
+
+func (p *sshFxpGlobReply) id() uint32 { return p.ID }
+
+func (p *sshFxpGlobReply) MarshalBinary() ([]byte, error) {
+	l := globReplyOverhead
+	for _, m := range p.Matches {
+		l += 4 + len(m)
+	}
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtendedReply)
+	b = marshalUint32(b, p.ID)
+	b = marshalUint32(b, uint32(len(p.Matches)))
+	for _, m := range p.Matches {
+		b = marshalString(b, m)
+	}
+
+	return b, nil
+}
+
+func (p *sshFxpExtendedPacketGlob) respond(svr *Server) responsePacket {
+	matches, err := glob(serverGlobFS{svr}, p.Pattern, globOptionsFromFlags(p.Flags))
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	size := globReplyOverhead
+	for _, m := range matches {
+		if size += 4 + len(m); size > maxMsgLength {
+			// the client globs itself
+			return statusFromError(p.ID, ErrSSHFxOpUnsupported)
+		}
+	}
+
+	return &sshFxpGlobReply{ID: p.ID, Matches: matches}
+}
+
+// serverGlobFS looks up the files a glob request matches in the file system
+// of a Server, with the paths the client sees. The lookups are confined to
+// the root directory and checked like the Stat, Lstat and ReadDir requests
+// of the client would be.
+type serverGlobFS struct {
+	svr *Server
+}
+
+// local returns the path of the file system for the path name of the
+// client, if op is allowed on it.
+func (g serverGlobFS) local(op Operation, name string, follow bool) (string, error) {
+	svr := g.svr
+	if svr.root != nil {
+		var err error
+		if name, err = svr.root.resolve(name, follow); err != nil {
+			return "", err
+		}
+	}
+	if svr.accessControl != nil || svr.pathPolicy != nil {
+		if err := svr.access(op, name, 0); err != nil {
+			return "", err
+		}
+	}
+	return toLocalPath(name), nil
+}
+
+func (g serverGlobFS) Stat(name string) (fs.FileInfo, error) {
+	local, err := g.local(OpStat, name, true)
+	if err != nil {
+		return nil, err
+	}
+	return g.svr.stat(local)
+}
+
+func (g serverGlobFS) Lstat(name string) (fs.FileInfo, error) {
+	local, err := g.local(OpLstat, name, false)
+	if err != nil {
+		return nil, err
+	}
+	return g.svr.lstat(local)
+}
+
+func (g serverGlobFS) ReadDir(name string) ([]fs.FileInfo, error) {
+	local, err := g.local(OpList, name, true)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := g.svr.fs.ReadDir(local)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		fi, err := entry.Info()
+		if err != nil {
+			// removed meanwhile
+			continue
+		}
+		infos = append(infos, fi)
+	}
+	return infos, nil
+}
@@ -0,0 +1,103 @@
+package sftp
+
+import (
+	"os"
+	"path"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerGlob(t *testing.T) {
+	skipIfWindows(t)
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	_, ok := client.HasExtension(globExtension)
+	require.True(t, ok)
+
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(root, "a/b"), 0o755))
+	for _, file := range []string{"x.go", "y.txt", "a/z.go", "a/b/w.go"} {
+		require.NoError(t, os.WriteFile(path.Join(root, file), nil, 0o644))
+	}
+	require.NoError(t, os.Symlink("a", path.Join(root, "link")))
+
+	for _, pattern := range []string{"*.go", "*/*.go", "link/*", "**/*.go", "{x,y}.*", "missing/*", "x.go"} {
+		pattern = path.Join(root, pattern)
+		o := &globOptions{doublestar: true, braces: true}
+
+		remote, err := client.globRemote(pattern, o)
+		require.NoError(t, err, pattern)
+		local, err := glob(client, pattern, o)
+		require.NoError(t, err, pattern)
+		sort.Strings(remote)
+		sort.Strings(local)
+		assert.Equal(t, local, remote, pattern)
+	}
+
+	matches, err := client.Glob(path.Join(root, "*/*.go"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{path.Join(root, "a/z.go"), path.Join(root, "link/z.go")}, matches)
+
+	// bad patterns are reported by the client
+	_, err = client.globRemote("[", &globOptions{})
+	assert.IsType(t, &StatusError{}, err)
+	_, err = client.Glob("[")
+	assert.Equal(t, ErrBadPattern, err)
+}
+
+func TestServerGlobRootDirectory(t *testing.T) {
+	skipIfWindows(t)
+	skipIfPlan9(t)
+
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(path.Join(root, "pub"), 0o755))
+	require.NoError(t, os.Mkdir(path.Join(root, "secret"), 0o755))
+	require.NoError(t, os.WriteFile(path.Join(root, "pub", "a"), nil, 0o644))
+	require.NoError(t, os.WriteFile(path.Join(root, "secret", "b"), nil, 0o644))
+	require.NoError(t, os.Symlink("/pub", path.Join(root, "link")))
+
+	client, server := clientServerPair(t, WithRootDirectory(root), WithPathPolicy([]PathRule{
+		{Pattern: "/secret", Verbs: 0},
+	}))
+	defer client.Close()
+	defer server.Close()
+
+	matches, err := client.Glob("/*/*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/link/a", "/pub/a"}, matches)
+
+	matches, err = client.Glob("/../*")
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+}
+
+func TestRequestGlob(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+
+	require.NoError(t, p.cli.Mkdir("/dir"))
+	_, err := putTestFile(p.cli, "/dir/a", "hello")
+	require.NoError(t, err)
+
+	// the client lists the directories itself
+	matches, err := p.cli.Glob("/*/a")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/dir/a"}, matches)
+	checkRequestServerAllocator(t, p)
+}
+
+func TestGlobReply(t *testing.T) {
+	b, err := (&sshFxpGlobReply{ID: 1, Matches: []string{"/a", "/b/c"}}).MarshalBinary()
+	require.NoError(t, err)
+	matches, err := unmarshalGlobMatches(b[9:])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/a", "/b/c"}, matches)
+
+	_, err = unmarshalGlobMatches([]byte{0, 0, 0, 2, 0, 0, 0, 0})
+	assert.Equal(t, errShortPacket, err)
+}
@@ -3,9 +3,9 @@ module github.com/pkg/sftp
 go 1.15
 
 require (
-	github.com/avfs/avfs v0.25.1 // indirect
+	github.com/avfs/avfs v0.25.1
+	github.com/klauspost/compress v1.15.9
 	github.com/kr/fs v0.1.0
-	github.com/stretchr/testify v1.7.0
+	github.com/stretchr/testify v1.7.1
 	golang.org/x/crypto v0.0.0-20211215153901-e495a2d5b3d3
-	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e // indirect
 )
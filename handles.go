@@ -0,0 +1,211 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// errTooManyHandles is the error for opens beyond the limit of open handles.
+// Clients see it as SSH_FX_FAILURE with its message.
+var errTooManyHandles = errors.New("sftp: too many open handles")
+
+// errIdleHandle is the transfer error of the handles a RequestServer closes
+// for having been idle too long.
+var errIdleHandle = errors.New("sftp: handle closed after being idle")
+
+// WithMaxHandles limits the handles a client may have open on the Server at
+// once, files and directories alike. Opens beyond the limit fail with
+// SSH_FX_FAILURE, until the client closes some of its handles. This keeps
+// clients which leak handles from exhausting the file descriptors of the
+// process.
+func WithMaxHandles(max int) ServerOption {
+	return func(s *Server) error {
+		if max <= 0 {
+			return fmt.Errorf("sftp: non-positive handle limit %d", max)
+		}
+		s.handles = s.handles.withMax(max)
+		return nil
+	}
+}
+
+// WithIdleHandleTimeout has the Server close the handles no request used for
+// timeout, so that clients which forget to close them do not keep the files
+// open. Later requests on such a handle fail with EBADF, like those on any
+// handle that is closed.
+//
+// A request in progress on a handle counts as use when it starts, so a
+// single read or write taking longer than timeout may find its handle
+// closed afterwards.
+func WithIdleHandleTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) error {
+		if timeout <= 0 {
+			return fmt.Errorf("sftp: non-positive idle handle timeout %v", timeout)
+		}
+		s.handles = s.handles.withIdle(timeout)
+		return nil
+	}
+}
+
+// WithRSMaxHandles limits the handles a client may have open on the
+// RequestServer at once, see WithMaxHandles. Non-positive limits are ignored.
+func WithRSMaxHandles(max int) RequestServerOption {
+	return func(rs *RequestServer) {
+		if max > 0 {
+			rs.handles = rs.handles.withMax(max)
+		}
+	}
+}
+
+// WithRSIdleHandleTimeout has the RequestServer close the handles no request
+// used for timeout, see WithIdleHandleTimeout. Their readers and writers get
+// a transfer error before they are closed, if they are TransferErrors.
+// Non-positive timeouts are ignored.
+func WithRSIdleHandleTimeout(timeout time.Duration) RequestServerOption {
+	return func(rs *RequestServer) {
+		if timeout > 0 {
+			rs.handles = rs.handles.withIdle(timeout)
+		}
+	}
+}
+
+// handleTracker enforces the limit on the open handles of a session, and
+// finds the ones which have been idle for too long. A nil handleTracker
+// enforces nothing.
+type handleTracker struct {
+	max  int           // 0 for no limit
+	idle time.Duration // 0 to keep idle handles open
+
+	mu   sync.Mutex
+	used map[string]time.Time // by handle, if idle is set
+}
+
+func (t *handleTracker) withMax(max int) *handleTracker {
+	if t == nil {
+		t = &handleTracker{used: make(map[string]time.Time)}
+	}
+	t.max = max
+	return t
+}
+
+func (t *handleTracker) withIdle(idle time.Duration) *handleTracker {
+	if t == nil {
+		t = &handleTracker{used: make(map[string]time.Time)}
+	}
+	t.idle = idle
+	return t
+}
+
+// full reports whether no handle may be opened while open are.
+func (t *handleTracker) full(open int) bool {
+	return t != nil && t.max > 0 && open >= t.max
+}
+
+// touch records a use of handle, or its open.
+func (t *handleTracker) touch(handle string) {
+	if t == nil || t.idle == 0 {
+		return
+	}
+	t.mu.Lock()
+	t.used[handle] = time.Now()
+	t.mu.Unlock()
+}
+
+// forget drops handle, once it is closed.
+func (t *handleTracker) forget(handle string) {
+	if t == nil || t.idle == 0 {
+		return
+	}
+	t.mu.Lock()
+	delete(t.used, handle)
+	t.mu.Unlock()
+}
+
+// reap calls close with the handles idle for too long, until done is closed.
+func (t *handleTracker) reap(done <-chan struct{}, close func(handle string)) {
+	if t == nil || t.idle == 0 {
+		return
+	}
+
+	interval := t.idle / 2
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			for _, handle := range t.idleSince(now.Add(-t.idle)) {
+				close(handle)
+			}
+		}
+	}
+}
+
+// idleSince returns the handles not used since then, and forgets them.
+func (t *handleTracker) idleSince(then time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var idle []string
+	for handle, used := range t.used {
+		if used.Before(then) {
+			idle = append(idle, handle)
+			delete(t.used, handle)
+		}
+	}
+	return idle
+}
+
+// handlesFull reports whether the client has all the handles it may have open.
+func (svr *Server) handlesFull() bool {
+	if svr.handles == nil {
+		return false
+	}
+	svr.openFilesLock.RLock()
+	defer svr.openFilesLock.RUnlock()
+	return svr.handles.full(len(svr.openFiles))
+}
+
+// handlesFull reports whether the client has all the handles it may have open.
+func (rs *RequestServer) handlesFull() bool {
+	if rs.handles == nil {
+		return false
+	}
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.handles.full(len(rs.openRequests))
+}
+
+// reapHandle closes handle, which has been idle for too long.
+func (svr *Server) reapHandle(handle string) {
+	if svr.closeHandle(handle) == nil {
+		fmt.Fprintf(svr.debugStream, "sftp server closed idle handle %q\n", handle)
+	}
+}
+
+// reapRequest closes the request of handle, which has been idle for too long.
+func (rs *RequestServer) reapRequest(handle string) {
+	rs.mu.Lock()
+	r, ok := rs.openRequests[handle]
+	delete(rs.openRequests, handle)
+	rs.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if rs.metrics != nil {
+		rs.metrics.AddOpenHandles(-1)
+	}
+	r.transferError(errIdleHandle)
+	rs.releaseLocks(r)
+	if err := r.close(); err != nil && err != io.EOF {
+		debug("closing idle handle %q: %v", handle, err)
+	}
+}
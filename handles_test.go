@@ -0,0 +1,89 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerMaxHandles(t *testing.T) {
+	client, server := clientServerPair(t, WithMaxHandles(2))
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "f")
+	require.NoError(t, os.WriteFile(name, []byte("hello"), 0o644))
+
+	f1, err := client.Open(name)
+	require.NoError(t, err)
+	f2, err := client.Open(name)
+	require.NoError(t, err)
+
+	_, err = client.Open(name)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), errTooManyHandles.Error())
+	}
+	_, err = client.ReadDir(dir)
+	assert.Error(t, err, "directories count alike")
+
+	require.NoError(t, f1.Close())
+	f3, err := client.Open(name)
+	require.NoError(t, err)
+	require.NoError(t, f2.Close())
+	require.NoError(t, f3.Close())
+}
+
+func TestServerIdleHandleTimeout(t *testing.T) {
+	client, server := clientServerPair(t, WithIdleHandleTimeout(50*time.Millisecond))
+	defer client.Close()
+	defer server.Close()
+
+	name := filepath.Join(t.TempDir(), "f")
+	require.NoError(t, os.WriteFile(name, []byte("hello"), 0o644))
+
+	busy, err := client.Open(name)
+	require.NoError(t, err)
+	idle, err := client.Open(name)
+	require.NoError(t, err)
+
+	b := make([]byte, 1)
+	for i := 0; i < 10; i++ {
+		_, err = busy.ReadAt(b, 0)
+		require.NoError(t, err, "in use")
+		time.Sleep(20 * time.Millisecond)
+	}
+	_, err = idle.ReadAt(b, 0)
+	assert.Error(t, err, "reaped")
+	assert.Error(t, idle.Close())
+	assert.NoError(t, busy.Close())
+
+	_, err = NewServer(nil, apis.NewAVFS(), WithIdleHandleTimeout(0))
+	assert.Error(t, err)
+}
+
+func TestRequestServerHandleLimits(t *testing.T) {
+	p := clientRequestServerPair(t, WithRSMaxHandles(1), WithRSIdleHandleTimeout(50*time.Millisecond))
+	defer p.Close()
+
+	f, err := p.cli.Create("/foo")
+	require.NoError(t, err)
+	_, err = p.cli.Create("/bar")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), errTooManyHandles.Error())
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	_, err = f.Write([]byte("hello"))
+	assert.Error(t, err, "reaped")
+
+	f, err = p.cli.Create("/bar")
+	require.NoError(t, err, "the reaped handle is no longer counted")
+	require.NoError(t, f.Close())
+}
@@ -0,0 +1,77 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrKeepaliveTimeout is returned by Client.Wait, once the Client shut down
+// because the server stopped answering keepalive probes, see WithKeepalive.
+var ErrKeepaliveTimeout = errors.New("sftp: server stopped answering keepalive probes")
+
+// WithKeepalive probes the server every interval with a cheap request,
+// the realpath of ".". Once maxMissed probes in a row went unanswered for an
+// interval each, the Client shuts down: requests in flight fail with
+// ErrSSHFxConnectionLost and Wait returns ErrKeepaliveTimeout.
+// This keeps pooled clients from holding on to dead sessions.
+//
+// The Client shuts down even with WithAutoReconnect,
+// as a connection that stopped responding may still look alive.
+func WithKeepalive(interval time.Duration, maxMissed int) ClientOption {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return fmt.Errorf("sftp: keepalive interval %v is not positive", interval)
+		}
+		if maxMissed < 1 {
+			return fmt.Errorf("sftp: keepalive has to allow at least one missed probe, not %d", maxMissed)
+		}
+		c.keepaliveInterval = interval
+		c.keepaliveMaxMissed = maxMissed
+		return nil
+	}
+}
+
+// keepalive probes the server until the connection shuts down.
+func (c *Client) keepalive() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.keepaliveInterval)
+	defer ticker.Stop()
+
+	var missed int
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+		}
+
+		err := c.ping(time.Now().Add(c.keepaliveInterval))
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			missed = 0
+			continue
+		}
+
+		missed++
+		if missed >= c.keepaliveMaxMissed {
+			// Release everyone waiting first,
+			// closing may block on a write that hangs.
+			c.broadcastErr(ErrKeepaliveTimeout)
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+// ping sends a cheap request, the realpath of ".", and waits for the response
+// until deadline. Any response will do, even an error status.
+func (c *Client) ping(deadline time.Time) error {
+	id := c.nextID()
+	_, _, err := c.sendPacketDeadline(nil, &sshFxpRealpathPacket{
+		ID:   id,
+		Path: ".",
+	}, deadline)
+	return err
+}
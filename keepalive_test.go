@@ -0,0 +1,61 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// silentClient connects to a server, which never answers after the init.
+func silentClient(t *testing.T, opts ...ClientOption) *Client {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	go func() {
+		svr := &conn{Reader: sr, WriteCloser: sw}
+		defer svr.Close()
+		if _, _, err := svr.recvPacket(0); err != nil {
+			return
+		}
+		if err := svr.sendPacket(rawPacket(marshalUint32([]byte{sshFxpVersion}, 3))); err != nil {
+			return
+		}
+		// read all requests, but never answer
+		for {
+			if _, _, err := svr.recvPacket(0); err != nil {
+				return
+			}
+		}
+	}()
+
+	client, err := NewClientPipe(cr, cw, opts...)
+	require.NoError(t, err)
+	return client
+}
+
+func TestKeepaliveDeadServer(t *testing.T) {
+	client := silentClient(t, WithKeepalive(10*time.Millisecond, 2))
+	defer client.Close()
+
+	_, err := client.Stat("/stuck")
+	assert.True(t, errors.Is(err, ErrSSHFxConnectionLost), "got %v", err)
+	assert.Equal(t, ErrKeepaliveTimeout, client.Wait())
+}
+
+func TestKeepaliveLiveServer(t *testing.T) {
+	client, server := clientServerPairWith(t, nil, WithKeepalive(20*time.Millisecond, 5))
+	defer client.Close()
+	defer server.Close()
+
+	time.Sleep(100 * time.Millisecond)
+	_, err := client.Stat(os.TempDir())
+	assert.NoError(t, err)
+
+	_, w := io.Pipe()
+	_, err = NewClientPipe(nil, w, WithKeepalive(0, 1))
+	assert.Error(t, err)
+}
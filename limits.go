@@ -0,0 +1,138 @@
+package sftp
+
+// limitsExtension reports the sizes a server accepts, see
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL
+const limitsExtension = "limits@openssh.com"
+
+const (
+	defaultMaxPacket             = 1 << 15
+	defaultMaxConcurrentRequests = 64
+
+	// limitsOverhead is left for the header of READ, WRITE and DATA packets,
+	// like OpenSSH does when it derives the read and write limits.
+	limitsOverhead = 1024
+)
+
+// limits as reported by limits@openssh.com, zero means unknown or unlimited.
+type limits struct {
+	MaxPacketLength uint64
+	MaxReadLength   uint64
+	MaxWriteLength  uint64
+	MaxOpenHandles  uint64
+}
+
+// serverLimits returns the limits of Server and RequestServer.
+func serverLimits() limits {
+	return limits{
+		MaxPacketLength: maxMsgLength,
+		MaxReadLength:   uint64(maxTxPacket),
+		MaxWriteLength:  maxMsgLength - limitsOverhead,
+	}
+}
+
+// maxPacket returns the largest payload both ends can read and write
+// in a single request, or 0 if the server did not tell.
+func (l limits) maxPacket() int {
+	if l.MaxPacketLength == 0 && l.MaxReadLength == 0 && l.MaxWriteLength == 0 {
+		return 0
+	}
+
+	size := uint64(maxMsgLength - limitsOverhead) // the most we can receive
+	for _, n := range []uint64{l.MaxReadLength, l.MaxWriteLength} {
+		if n != 0 && n < size {
+			size = n
+		}
+	}
+	if l.MaxPacketLength > limitsOverhead && l.MaxPacketLength-limitsOverhead < size {
+		size = l.MaxPacketLength - limitsOverhead
+	}
+	return int(size)
+}
+
+type sshFxpLimitsReply struct {
+	ID uint32
+	limits
+}
+
+func (p *sshFxpLimitsReply) id() uint32 { return p.ID }
+
+func (p *sshFxpLimitsReply) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4*8 // 4*uint64
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtendedReply)
+	b = marshalUint32(b, p.ID)
+	b = marshalUint64(b, p.MaxPacketLength)
+	b = marshalUint64(b, p.MaxReadLength)
+	b = marshalUint64(b, p.MaxWriteLength)
+	b = marshalUint64(b, p.MaxOpenHandles)
+
+	return b, nil
+}
+
+func unmarshalLimits(b []byte) (limits, error) {
+	var l limits
+	var err error
+	for _, v := range []*uint64{&l.MaxPacketLength, &l.MaxReadLength, &l.MaxWriteLength, &l.MaxOpenHandles} {
+		if *v, b, err = unmarshalUint64Safe(b); err != nil {
+			return limits{}, err
+		}
+	}
+	return l, nil
+}
+
+func (p *sshFxpExtendedPacketLimits) respond(svr *Server) responsePacket {
+	return &sshFxpLimitsReply{ID: p.ID, limits: serverLimits()}
+}
+
+// applyLimits sizes maxPacket from the limits of the server, unless it was
+// set with an option, and scales maxConcurrentRequests along with it,
+// unless that was set too, to keep the same amount of data in flight.
+func (c *Client) applyLimits() error {
+	autoPacket, autoConcurrency := c.maxPacket == 0, c.maxConcurrentRequests == 0
+	if autoPacket {
+		c.maxPacket = defaultMaxPacket
+	}
+	if autoConcurrency {
+		c.maxConcurrentRequests = defaultMaxConcurrentRequests
+	}
+
+	if _, ok := c.HasExtension(limitsExtension); !ok || !autoPacket {
+		return nil
+	}
+
+	id := c.nextID()
+	typ, data, err := c.sendPacket(nil, &sshFxpLimitsPacket{ID: id})
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case sshFxpExtendedReply:
+		sid, data := unmarshalUint32(data)
+		if sid != id {
+			return &unexpectedIDErr{id, sid}
+		}
+		l, err := unmarshalLimits(data)
+		if err != nil {
+			return err
+		}
+		size := l.maxPacket()
+		if size <= 0 {
+			return nil
+		}
+		c.maxPacket = size
+		if autoConcurrency {
+			c.maxConcurrentRequests = defaultMaxConcurrentRequests * defaultMaxPacket / size
+			if c.maxConcurrentRequests < 1 {
+				c.maxConcurrentRequests = 1
+			}
+		}
+		return nil
+	case sshFxpStatus:
+		// the server changed its mind, stick to the defaults
+		return nil
+	default:
+		return unimplementedPacketErr(typ)
+	}
+}
@@ -0,0 +1,92 @@
+package sftp
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitsMaxPacket(t *testing.T) {
+	for _, tt := range []struct {
+		limits limits
+		want   int
+	}{
+		{limits{}, 0},
+		{serverLimits(), int(maxTxPacket)},
+		{limits{MaxPacketLength: 256 * 1024, MaxReadLength: 255 * 1024, MaxWriteLength: 255 * 1024}, 255 * 1024},
+		{limits{MaxPacketLength: 64 * 1024}, 63 * 1024},
+		{limits{MaxReadLength: 1 << 20, MaxWriteLength: 1 << 20}, maxMsgLength - limitsOverhead},
+		{limits{MaxReadLength: 1 << 12, MaxOpenHandles: 100}, 1 << 12},
+	} {
+		assert.Equal(t, tt.want, tt.limits.maxPacket(), "%+v", tt.limits)
+	}
+}
+
+func TestLimitsMarshalUnmarshal(t *testing.T) {
+	want := limits{1, 2, 3, 4}
+	b, err := (&sshFxpLimitsReply{ID: 42, limits: want}).MarshalBinary()
+	require.NoError(t, err)
+
+	// strip the length, type and id
+	got, err := unmarshalLimits(b[9:])
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	_, err = unmarshalLimits(b[9:20])
+	assert.Error(t, err)
+}
+
+func TestClientLimits(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	_, ok := client.HasExtension(limitsExtension)
+	assert.True(t, ok)
+	assert.Equal(t, defaultMaxPacket, client.maxPacket)
+	assert.Equal(t, defaultMaxConcurrentRequests, client.maxConcurrentRequests)
+}
+
+func TestClientLimitsLargerPackets(t *testing.T) {
+	defer func(n uint32) { maxTxPacket = n }(maxTxPacket)
+	maxTxPacket = 1 << 17
+
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	assert.Equal(t, 1<<17, client.maxPacket)
+	assert.Equal(t, 16, client.maxConcurrentRequests)
+
+	content := bytes.Repeat([]byte("large packets "), 50000)
+	name := path.Join(t.TempDir(), "large")
+
+	f, err := client.Create(name)
+	require.NoError(t, err)
+	_, err = f.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	f, err = client.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+	got, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+}
+
+func TestClientLimitsOptionsWin(t *testing.T) {
+	defer func(n uint32) { maxTxPacket = n }(maxTxPacket)
+	maxTxPacket = 1 << 17
+
+	client, server := clientServerPairWith(t, nil, MaxPacketUnchecked(1<<16), MaxConcurrentRequestsPerFile(3))
+	defer client.Close()
+	defer server.Close()
+
+	assert.Equal(t, 1<<16, client.maxPacket)
+	assert.Equal(t, 3, client.maxConcurrentRequests)
+}
@@ -0,0 +1,347 @@
+package sftp
+
+import (
+	"errors"
+	"io/fs"
+	"math"
+	"sync"
+
+	"github.com/pkg/sftp/apis"
+)
+
+// blockExtension and unblockExtension carry SSH_FXP_BLOCK and SSH_FXP_UNBLOCK
+// of protocol version 6 for servers speaking older versions.
+const (
+	blockExtension   = "block@github.com/pkg/sftp"
+	unblockExtension = "unblock@github.com/pkg/sftp"
+)
+
+// byte range locking of protocol version 6
+const (
+	sshFxpBlock   = 22
+	sshFxpUnblock = 23
+
+	sshFxfBlockRead     = 0x00000040
+	sshFxfBlockWrite    = 0x00000080
+	sshFxfBlockDelete   = 0x00000100
+	sshFxfBlockAdvisory = 0x00000200
+)
+
+// asExtension sends a request of protocol version 6 as the extension name,
+// which carries the same fields after its name.
+type asExtension struct {
+	idmarshaler
+	name string
+}
+
+func (p asExtension) MarshalBinary() ([]byte, error) {
+	b, err := p.idmarshaler.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	// skip uint32(length) + byte(type) + uint32(id)
+	fields := b[4+1+4:]
+
+	ext := make([]byte, 4, 4+1+4+4+len(p.name)+len(fields))
+	ext = append(ext, sshFxpExtended)
+	ext = marshalUint32(ext, p.id())
+	ext = marshalString(ext, p.name)
+
+	return append(ext, fields...), nil
+}
+
+// rangeLock is a byte range locked by owner, up to but excluding end.
+type rangeLock struct {
+	owner      interface{}
+	start, end int64
+	exclusive  bool
+}
+
+// rangeLocks is a table of advisory byte range locks by file name.
+// The zero value is ready to use.
+type rangeLocks struct {
+	mu    sync.Mutex
+	locks map[string][]rangeLock
+}
+
+// lockEnd returns where a lock of length bytes from offset ends,
+// a length of 0 locks up to the end of the file, wherever that will be.
+func lockEnd(offset, length int64) int64 {
+	if length == 0 || length > math.MaxInt64-offset {
+		return math.MaxInt64
+	}
+	return offset + length
+}
+
+// lock locks the range for owner, if no other owner holds a conflicting lock.
+// Exclusive locks conflict with every lock, shared locks with exclusive ones only.
+func (t *rangeLocks) lock(name string, owner interface{}, offset, length int64, exclusive bool) error {
+	if offset < 0 || length < 0 {
+		return fs.ErrInvalid
+	}
+	l := rangeLock{
+		owner:     owner,
+		start:     offset,
+		end:       lockEnd(offset, length),
+		exclusive: exclusive,
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, held := range t.locks[name] {
+		if held.owner != owner && held.start < l.end && l.start < held.end && (held.exclusive || exclusive) {
+			return ErrSSHFxByteRangeLockConflict
+		}
+	}
+
+	if t.locks == nil {
+		t.locks = make(map[string][]rangeLock)
+	}
+	t.locks[name] = append(t.locks[name], l)
+	return nil
+}
+
+// unlock releases the lock owner holds on exactly this range.
+// An offset and length of 0 release all locks of owner on name.
+func (t *rangeLocks) unlock(name string, owner interface{}, offset, length int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	all := offset == 0 && length == 0
+	end := lockEnd(offset, length)
+
+	var found bool
+	locks := t.locks[name][:0]
+	for _, held := range t.locks[name] {
+		if held.owner == owner && (all || !found && held.start == offset && held.end == end) {
+			found = true
+			continue
+		}
+		locks = append(locks, held)
+	}
+
+	if len(locks) == 0 {
+		delete(t.locks, name)
+	} else {
+		t.locks[name] = locks
+	}
+
+	if !found && !all {
+		return ErrSSHFxNoMatchingByteRangeLock
+	}
+	return nil
+}
+
+// serverLocks are shared by all Servers of the process,
+// so that sessions can coordinate through them.
+var serverLocks rangeLocks
+
+func (p *sshFxpExtendedPacketBlock) respond(svr *Server) responsePacket {
+	f, ok := svr.getHandle(p.Handle)
+	if !ok {
+		return statusFromError(p.ID, EBADF)
+	}
+	offset, err := toInt64(p.Offset)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+	length, err := toInt64(p.Length)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	exclusive := p.LockMask&sshFxfBlockRead != 0
+	if err := serverLocks.lock(f.Name(), f, offset, length, exclusive); err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	// place the lock in the OS too, for other processes to see it
+	if l, ok := f.(apis.FileLocker); ok {
+		err := l.LockFile(offset, length, exclusive)
+		switch {
+		case errors.Is(err, apis.ErrLockUnsupported):
+			// other sessions of this process see the lock at least
+		case err != nil:
+			serverLocks.unlock(f.Name(), f, offset, length)
+			if errors.Is(err, apis.ErrLockConflict) {
+				err = ErrSSHFxByteRangeLockConflict
+			}
+			return statusFromError(p.ID, err)
+		}
+	}
+	return statusFromError(p.ID, nil)
+}
+
+func (p *sshFxpExtendedPacketUnblock) respond(svr *Server) responsePacket {
+	f, ok := svr.getHandle(p.Handle)
+	if !ok {
+		return statusFromError(p.ID, EBADF)
+	}
+	offset, err := toInt64(p.Offset)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+	length, err := toInt64(p.Length)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	if err := serverLocks.unlock(f.Name(), f, offset, length); err != nil {
+		return statusFromError(p.ID, err)
+	}
+
+	// the OS merges overlapping locks of a file, so this may release more of
+	// the file than the lock did if the client placed overlapping ones
+	if l, ok := f.(apis.FileLocker); ok {
+		if err := l.UnlockFile(offset, length); err != nil && !errors.Is(err, apis.ErrLockUnsupported) {
+			return statusFromError(p.ID, err)
+		}
+	}
+	return statusFromError(p.ID, nil)
+}
+
+// locker returns the Locker of the handlers, if any.
+func (rs *RequestServer) locker() (Locker, bool) {
+	if l, ok := rs.Handlers.FilePut.(Locker); ok {
+		return l, true
+	}
+	l, ok := rs.Handlers.FileGet.(Locker)
+	return l, ok
+}
+
+// lock answers block requests through the Locker of the handlers.
+func (rs *RequestServer) lock(pkt *sshFxpExtendedPacketBlock) responsePacket {
+	l, ok := rs.locker()
+	if !ok {
+		return statusFromError(pkt.ID, ErrSSHFxOpUnsupported)
+	}
+	r, ok := rs.getRequest(pkt.Handle)
+	if !ok {
+		return statusFromError(pkt.ID, EBADF)
+	}
+	offset, err := toInt64(pkt.Offset)
+	if err != nil {
+		return statusFromError(pkt.ID, err)
+	}
+	length, err := toInt64(pkt.Length)
+	if err != nil {
+		return statusFromError(pkt.ID, err)
+	}
+
+	exclusive := pkt.LockMask&sshFxfBlockRead != 0
+	return statusFromError(pkt.ID, l.Lock(r, offset, length, exclusive))
+}
+
+// unlock answers unblock requests through the Locker of the handlers.
+func (rs *RequestServer) unlock(pkt *sshFxpExtendedPacketUnblock) responsePacket {
+	l, ok := rs.locker()
+	if !ok {
+		return statusFromError(pkt.ID, ErrSSHFxOpUnsupported)
+	}
+	r, ok := rs.getRequest(pkt.Handle)
+	if !ok {
+		return statusFromError(pkt.ID, EBADF)
+	}
+	offset, err := toInt64(pkt.Offset)
+	if err != nil {
+		return statusFromError(pkt.ID, err)
+	}
+	length, err := toInt64(pkt.Length)
+	if err != nil {
+		return statusFromError(pkt.ID, err)
+	}
+
+	return statusFromError(pkt.ID, l.Unlock(r, offset, length))
+}
+
+// releaseLocks releases all locks of the file request r, which is being closed.
+func (rs *RequestServer) releaseLocks(r *Request) {
+	if r.Method == "List" {
+		return
+	}
+	if l, ok := rs.locker(); ok {
+		_ = l.Unlock(r, 0, 0)
+	}
+}
+
+// Lock places an advisory lock on length bytes of the file from offset.
+// An exclusive lock conflicts with every other lock on the range, a shared
+// lock only with exclusive ones. A length of 0 locks up to the end of the
+// file, including data appended later. Locks are released by Unlock or when
+// the File is closed.
+//
+// Lock fails with a StatusError of code ErrSSHFxByteRangeLockConflict if the
+// range is locked by someone else. The server has to speak protocol version 6,
+// see WithProtocolVersion, or support the block@github.com/pkg/sftp extension.
+// The Server of this package places the locks in the OS as well, where the
+// file system supports it, so that other processes see them.
+func (f *File) Lock(offset, length int64, exclusive bool) (err error) {
+	defer pathError(&err, "lock", f.path)
+	if err = f.checkClosed(); err != nil {
+		return err
+	}
+
+	if offset < 0 || length < 0 {
+		return fs.ErrInvalid
+	}
+
+	mask := uint32(sshFxfBlockWrite | sshFxfBlockDelete | sshFxfBlockAdvisory)
+	if exclusive {
+		mask |= sshFxfBlockRead
+	}
+
+	id := f.c.nextID()
+	var pkt idmarshaler = &sshFxpBlockPacket{
+		ID:       id,
+		Handle:   f.handle,
+		Offset:   uint64(offset),
+		Length:   uint64(length),
+		LockMask: mask,
+	}
+	if f.c.version < 6 {
+		pkt = asExtension{pkt, blockExtension}
+	}
+	return f.c.sendStatusPacket(id, pkt)
+}
+
+// Unlock releases the lock Lock placed on exactly this range.
+// It fails with a StatusError of code ErrSSHFxNoMatchingByteRangeLock,
+// if there is no such lock.
+func (f *File) Unlock(offset, length int64) (err error) {
+	defer pathError(&err, "unlock", f.path)
+	if err = f.checkClosed(); err != nil {
+		return err
+	}
+
+	if offset < 0 || length < 0 {
+		return fs.ErrInvalid
+	}
+
+	id := f.c.nextID()
+	var pkt idmarshaler = &sshFxpUnblockPacket{
+		ID:     id,
+		Handle: f.handle,
+		Offset: uint64(offset),
+		Length: uint64(length),
+	}
+	if f.c.version < 6 {
+		pkt = asExtension{pkt, unblockExtension}
+	}
+	return f.c.sendStatusPacket(id, pkt)
+}
+
+// sendStatusPacket sends a request answered with a status only.
+func (c *Client) sendStatusPacket(id uint32, pkt idmarshaler) error {
+	typ, data, err := c.sendPacket(nil, pkt)
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case sshFxpStatus:
+		return normaliseError(unmarshalStatus(id, data))
+	default:
+		return unimplementedPacketErr(typ)
+	}
+}
@@ -0,0 +1,139 @@
+package sftp
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeLocks(t *testing.T) {
+	var locks rangeLocks
+	a, b := new(int), new(int)
+
+	require.NoError(t, locks.lock("f", a, 0, 10, false))
+	assert.NoError(t, locks.lock("f", b, 5, 10, false), "shared locks do not conflict")
+	assert.Equal(t, ErrSSHFxByteRangeLockConflict, locks.lock("f", b, 9, 1, true))
+	assert.NoError(t, locks.lock("f", b, 10, 0, true), "adjacent ranges do not conflict")
+	assert.NoError(t, locks.lock("f", a, 0, 5, true), "own locks do not conflict")
+	assert.NoError(t, locks.lock("g", b, 0, 0, true), "other files do not conflict")
+	assert.Equal(t, ErrSSHFxByteRangeLockConflict, locks.lock("f", a, 1<<40, 1, false))
+
+	assert.Equal(t, ErrSSHFxNoMatchingByteRangeLock, locks.unlock("f", a, 0, 9))
+	assert.Equal(t, ErrSSHFxNoMatchingByteRangeLock, locks.unlock("f", a, 10, 0))
+	assert.NoError(t, locks.unlock("f", b, 10, 0))
+	assert.NoError(t, locks.lock("f", a, 1<<40, 1, false))
+
+	assert.NoError(t, locks.unlock("f", a, 0, 0))
+	assert.NoError(t, locks.lock("f", b, 0, 5, true), "all locks of a are gone")
+	assert.NoError(t, locks.unlock("f", b, 0, 0))
+	assert.NoError(t, locks.unlock("g", b, 0, 0))
+	assert.Empty(t, locks.locks)
+}
+
+// testFileLock locks name through two handles.
+func testFileLock(t *testing.T, client *Client, name string) {
+	f1, err := client.OpenFile(name, os.O_RDWR|os.O_CREATE)
+	require.NoError(t, err)
+	defer f1.Close()
+	f2, err := client.OpenFile(name, os.O_RDWR)
+	require.NoError(t, err)
+
+	require.NoError(t, f1.Lock(0, 10, true))
+	err = f2.Lock(5, 10, false)
+	assert.True(t, errors.Is(err, ErrSSHFxByteRangeLockConflict), "got %v", err)
+	assert.NoError(t, f2.Lock(10, 5, true))
+
+	require.NoError(t, f1.Unlock(0, 10))
+	assert.NoError(t, f2.Lock(5, 5, false))
+	err = f1.Unlock(0, 10)
+	assert.True(t, errors.Is(err, ErrSSHFxNoMatchingByteRangeLock), "got %v", err)
+
+	// closing releases the locks
+	require.NoError(t, f2.Close())
+	assert.NoError(t, f1.Lock(0, 0, true))
+}
+
+func TestServerLock(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	_, ok := client.HasExtension(blockExtension)
+	require.True(t, ok, "server doesn't list block extension")
+
+	testFileLock(t, client, path.Join(t.TempDir(), "locked"))
+}
+
+func TestServerLockOS(t *testing.T) {
+	name := path.Join(t.TempDir(), "locked")
+	other, err := apis.NewOS().OpenFile(name, os.O_RDWR|os.O_CREATE, 0o644)
+	require.NoError(t, err)
+	defer other.Close()
+	l, ok := other.(apis.FileLocker)
+	if !ok {
+		t.Skip("no byte range locks in the OS")
+	}
+
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	f, err := client.OpenFile(name, os.O_RDWR)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// another process, as far as the OS is concerned
+	require.NoError(t, f.Lock(0, 10, true))
+	assert.Equal(t, apis.ErrLockConflict, l.LockFile(5, 1, false))
+	assert.NoError(t, l.LockFile(10, 10, true))
+
+	err = f.Lock(15, 1, false)
+	assert.True(t, errors.Is(err, ErrSSHFxByteRangeLockConflict), "got %v", err)
+
+	require.NoError(t, f.Unlock(0, 10))
+	assert.NoError(t, l.LockFile(5, 1, false))
+	assert.NoError(t, l.UnlockFile(0, 0))
+}
+
+func TestRequestLock(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+
+	testFileLock(t, p.cli, "/locked")
+}
+
+func TestClientLockV6(t *testing.T) {
+	client, requests := fakeServer(t, 6, func(typ byte, id uint32) rawPacket {
+		if typ == sshFxpOpen {
+			return marshalString(marshalUint32([]byte{sshFxpHandle}, id), "h")
+		}
+		return statusOK(id)
+	})
+	defer client.Close()
+
+	f, err := client.Open("/file")
+	require.NoError(t, err)
+	<-requests
+
+	require.NoError(t, f.Lock(1, 2, false))
+	req := <-requests
+	assert.Equal(t, byte(sshFxpBlock), req.typ)
+	_, data := unmarshalUint32(req.data)
+	handle, data := unmarshalString(data)
+	assert.Equal(t, "h", handle)
+	want := marshalUint64(marshalUint64(nil, 1), 2)
+	assert.Equal(t, marshalUint32(want, sshFxfBlockWrite|sshFxfBlockDelete|sshFxfBlockAdvisory), data)
+
+	require.NoError(t, f.Unlock(1, 2))
+	req = <-requests
+	assert.Equal(t, byte(sshFxpUnblock), req.typ)
+	_, data = unmarshalUint32(req.data)
+	_, data = unmarshalString(data)
+	assert.Equal(t, want, data)
+}
@@ -0,0 +1,232 @@
+package sftp
+
+import (
+	"time"
+)
+
+// LogEvent describes a request, once it was answered.
+type LogEvent struct {
+	// Packet is the type of the request, like "SSH_FXP_OPEN".
+	Packet string
+
+	// Extension is the name of an extended request, if the server knows it.
+	Extension string
+
+	ID     uint32 // request id
+	Path   string // path of the request, if it has one
+	Handle string // handle of the request, if it has one
+
+	// Latency is the time the server took to serve the request, or the time
+	// the client waited for the response.
+	Latency time.Duration
+
+	// Bytes is the number of bytes read or written, for reads and writes.
+	Bytes int64
+
+	// Err is the error the request failed with, a *StatusError if the
+	// server answered with one, including the SSH_FX_EOF of reads.
+	Err error
+}
+
+// Logger receives a LogEvent for every request a Client, Server or
+// RequestServer handles, see UseLogger, WithLogger and WithRSLogger.
+// Log is called concurrently, and holds up the session until it returns.
+//
+// For log/slog, see SlogLogger.
+type Logger interface {
+	Log(LogEvent)
+}
+
+// LoggerFunc is a function which is a Logger.
+type LoggerFunc func(LogEvent)
+
+// Log calls f(ev).
+func (f LoggerFunc) Log(ev LogEvent) {
+	f(ev)
+}
+
+// UseLogger has the Client pass an event to logger for every response.
+func UseLogger(logger Logger) ClientOption {
+	return func(c *Client) error {
+		c.logger = logger
+		if c.logged == nil {
+			c.logged = make(map[uint32]loggedRequest)
+		}
+		return nil
+	}
+}
+
+// WithLogger has the Server pass an event to logger for every request served.
+func WithLogger(logger Logger) ServerOption {
+	return func(s *Server) error {
+		s.logger = logger
+		return nil
+	}
+}
+
+// WithRSLogger has the RequestServer pass an event to logger for every
+// request served.
+func WithRSLogger(logger Logger) RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.logger = logger
+	}
+}
+
+// observing reports whether the Server logs, measures, traces or audits
+// the requests it serves.
+func (svr *Server) observing() bool {
+	return svr.logger != nil || svr.metrics != nil || svr.trace != nil || svr.audit != nil
+}
+
+// observing reports whether the RequestServer logs, measures, traces or
+// audits the requests it serves.
+func (rs *RequestServer) observing() bool {
+	return rs.logger != nil || rs.metrics != nil || rs.trace != nil || rs.audit != nil
+}
+
+// loggedRequest is a request which is logged once answered.
+type loggedRequest struct {
+	ev    LogEvent
+	start time.Time
+	span  Span          // of a server tracing its requests
+	pkt   requestPacket // of a server auditing its requests
+}
+
+// newLoggedRequest starts the event for the request pkt.
+func newLoggedRequest(pkt interface{}) loggedRequest {
+	ev := LogEvent{
+		Packet: requestType(pkt).String(),
+	}
+	if p, ok := pkt.(interface{ id() uint32 }); ok {
+		ev.ID = p.id()
+	}
+	switch p := pkt.(type) {
+	case *sshFxpExtendedPacket:
+		ev.Extension = p.ExtendedRequest
+		if p.SpecificPacket != nil {
+			pkt = p.SpecificPacket
+		}
+	case *sshFxpWritePacket:
+		ev.Bytes = int64(len(p.Data))
+	}
+	if p, ok := pkt.(interface{ getPath() string }); ok {
+		ev.Path = p.getPath()
+	}
+	if p, ok := pkt.(interface{ getHandle() string }); ok {
+		ev.Handle = p.getHandle()
+	}
+	r := loggedRequest{ev: ev, start: time.Now()}
+	if p, ok := pkt.(requestPacket); ok {
+		r.pkt = p
+	}
+	return r
+}
+
+// served completes the event with the response rpkt of a server.
+func (r loggedRequest) served(rpkt responsePacket) LogEvent {
+	ev := r.ev
+	ev.Latency = time.Since(r.start)
+	switch p := rpkt.(type) {
+	case *sshFxpDataPacket:
+		ev.Bytes = int64(len(p.Data))
+	case *sshFxpFileDataPacket:
+		ev.Bytes = int64(p.Length)
+	case *sshFxpStatusPacket:
+		if p.Code != sshFxOk {
+			err := p.StatusError
+			ev.Err = &err
+		}
+	}
+	return ev
+}
+
+// answered completes the event with the response of type typ and body data,
+// which a client received.
+func (r loggedRequest) answered(typ uint8, data []byte) LogEvent {
+	ev := r.ev
+	ev.Latency = time.Since(r.start)
+	if len(data) < 8 {
+		// malformed, the client fails the request
+		return ev
+	}
+	switch typ {
+	case sshFxpData:
+		// the length of the data follows uint32(id)
+		n, _ := unmarshalUint32(data[4:])
+		ev.Bytes = int64(n)
+	case sshFxpStatus:
+		err := unmarshalStatus(ev.ID, data)
+		if status, ok := err.(*StatusError); !ok || status.Code != sshFxOk {
+			ev.Err = err
+		}
+	}
+	return ev
+}
+
+// requestType returns the packet type of the request pkt.
+func requestType(pkt interface{}) fxp {
+	switch pkt.(type) {
+	case *sshFxInitPacket:
+		return sshFxpInit
+	case *sshFxpOpenPacket:
+		return sshFxpOpen
+	case *sshFxpClosePacket:
+		return sshFxpClose
+	case *sshFxpReadPacket:
+		return sshFxpRead
+	case *sshFxpWritePacket:
+		return sshFxpWrite
+	case *sshFxpLstatPacket:
+		return sshFxpLstat
+	case *sshFxpFstatPacket:
+		return sshFxpFstat
+	case *sshFxpSetstatPacket:
+		return sshFxpSetstat
+	case *sshFxpFsetstatPacket:
+		return sshFxpFsetstat
+	case *sshFxpOpendirPacket:
+		return sshFxpOpendir
+	case *sshFxpReaddirPacket:
+		return sshFxpReaddir
+	case *sshFxpRemovePacket:
+		return sshFxpRemove
+	case *sshFxpMkdirPacket:
+		return sshFxpMkdir
+	case *sshFxpRmdirPacket:
+		return sshFxpRmdir
+	case *sshFxpRealpathPacket:
+		return sshFxpRealpath
+	case *sshFxpStatPacket:
+		return sshFxpStat
+	case *sshFxpRenamePacket:
+		return sshFxpRename
+	case *sshFxpReadlinkPacket:
+		return sshFxpReadlink
+	case *sshFxpSymlinkPacket:
+		return sshFxpSymlink
+	}
+	return sshFxpExtended
+}
+
+// logResponse logs and measures the request sid, answered with a response of
+// type typ and body data.
+func (c *clientConn) logResponse(sid uint32, typ uint8, data []byte) {
+	c.Lock()
+	r, ok := c.logged[sid]
+	delete(c.logged, sid)
+	c.Unlock()
+	if !ok {
+		return
+	}
+
+	ev := r.answered(typ, data)
+	observe(c.logger, c.metrics, nil, ev)
+	if c.metrics != nil {
+		switch {
+		case typ == sshFxpHandle:
+			c.metrics.AddOpenHandles(1)
+		case ev.Packet == fxp(sshFxpClose).String() && ev.Err == nil:
+			c.metrics.AddOpenHandles(-1)
+		}
+	}
+}
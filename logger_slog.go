@@ -0,0 +1,62 @@
+//go:build go1.21
+// +build go1.21
+
+package sftp
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogLogger returns a Logger writing every event to l as a record
+// "sftp request" at level Debug, or Info if the request failed with
+// something else than the end of a file or directory.
+// The Logger is a FeatureUsageLogger, writing the features a session used
+// as a record "sftp session features" at level Info.
+// It requires Go 1.21 or later.
+func SlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Log(ev LogEvent) {
+	level := slog.LevelDebug
+	if status, ok := ev.Err.(*StatusError); ev.Err != nil && (!ok || status.Code != sshFxEOF) {
+		level = slog.LevelInfo
+	}
+
+	ctx := context.Background()
+	if !s.l.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("packet", ev.Packet),
+		slog.Any("id", ev.ID),
+		slog.Duration("latency", ev.Latency),
+	}
+	if ev.Extension != "" {
+		attrs = append(attrs, slog.String("extension", ev.Extension))
+	}
+	if ev.Path != "" {
+		attrs = append(attrs, slog.String("path", ev.Path))
+	}
+	if ev.Handle != "" {
+		attrs = append(attrs, slog.String("handle", ev.Handle))
+	}
+	if ev.Bytes != 0 {
+		attrs = append(attrs, slog.Int64("bytes", ev.Bytes))
+	}
+	if ev.Err != nil {
+		attrs = append(attrs, slog.Any("error", ev.Err))
+	}
+	s.l.LogAttrs(ctx, level, "sftp request", attrs...)
+}
+
+func (s slogLogger) LogFeatureUsage(u FeatureUsage) {
+	s.l.LogAttrs(context.Background(), slog.LevelInfo, "sftp session features",
+		slog.String("features", u.String()))
+}
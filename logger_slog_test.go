@@ -0,0 +1,44 @@
+//go:build go1.21
+// +build go1.21
+
+package sftp
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := SlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	l.Log(LogEvent{Packet: "SSH_FXP_READ", ID: 1, Err: &StatusError{Code: sshFxEOF}})
+	assert.Empty(t, buf.String(), "the end of a file is logged at level Debug")
+
+	l.Log(LogEvent{Packet: "SSH_FXP_OPEN", ID: 2, Path: "/foo", Latency: time.Millisecond, Err: errors.New("boom")})
+	line := buf.String()
+	for _, want := range []string{"level=INFO", `msg="sftp request"`, "packet=SSH_FXP_OPEN", "id=2", "path=/foo", "latency=1ms", "error=boom"} {
+		assert.Contains(t, line, want)
+	}
+}
+
+func TestSlogLoggerFeatureUsage(t *testing.T) {
+	var buf bytes.Buffer
+	l := SlogLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	fl, ok := l.(FeatureUsageLogger)
+	assert.True(t, ok)
+	fl.LogFeatureUsage(FeatureUsage{
+		Packets:    map[string]uint64{"SSH_FXP_OPEN": 2},
+		Extensions: map[string]uint64{"statvfs@openssh.com": 1},
+	})
+	line := buf.String()
+	for _, want := range []string{"level=INFO", `msg="sftp session features"`, `features="SSH_FXP_OPEN=2 statvfs@openssh.com=1"`} {
+		assert.Contains(t, line, want)
+	}
+}
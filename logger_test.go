@@ -0,0 +1,98 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// eventLog collects the events of a Logger.
+type eventLog struct {
+	mu     sync.Mutex
+	events []LogEvent
+}
+
+func (l *eventLog) Log(ev LogEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, ev)
+}
+
+// find returns the events of packet type typ.
+func (l *eventLog) find(typ string) []LogEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var found []LogEvent
+	for _, ev := range l.events {
+		if ev.Packet == typ {
+			found = append(found, ev)
+		}
+	}
+	return found
+}
+
+func TestLogger(t *testing.T) {
+	serverLog, clientLog := new(eventLog), new(eventLog)
+
+	client, server := clientServerPairWith(t, []ServerOption{WithLogger(serverLog)}, UseLogger(clientLog))
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "logged")
+	require.NoError(t, os.WriteFile(name, []byte("hello"), 0o644))
+
+	f, err := client.Open(name)
+	require.NoError(t, err)
+	_, err = f.ReadAt(make([]byte, 5), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = client.Stat(filepath.Join(dir, "missing"))
+	require.Error(t, err)
+
+	for _, l := range []*eventLog{serverLog, clientLog} {
+		opens := l.find("SSH_FXP_OPEN")
+		if assert.Len(t, opens, 1) {
+			assert.Equal(t, name, opens[0].Path)
+			assert.NoError(t, opens[0].Err)
+		}
+
+		reads := l.find("SSH_FXP_READ")
+		if assert.Len(t, reads, 1) {
+			assert.NotEmpty(t, reads[0].Handle)
+			assert.Equal(t, int64(5), reads[0].Bytes)
+			assert.NotZero(t, reads[0].Latency)
+		}
+
+		stats := l.find("SSH_FXP_STAT")
+		if assert.Len(t, stats, 1) && assert.IsType(t, &StatusError{}, stats[0].Err) {
+			assert.Equal(t, ErrSSHFxNoSuchFile, stats[0].Err.(*StatusError).FxCode())
+		}
+	}
+}
+
+func TestRequestServerLogger(t *testing.T) {
+	l := new(eventLog)
+	p := clientRequestServerPair(t, WithRSLogger(l), WithRSStrictPaths())
+	defer p.Close()
+
+	_, err := putTestFile(p.cli, "/foo", "hello")
+	require.NoError(t, err)
+	_, err = p.cli.Stat("/../foo")
+	require.Error(t, err)
+
+	writes := l.find("SSH_FXP_WRITE")
+	if assert.Len(t, writes, 1) {
+		assert.Equal(t, int64(5), writes[0].Bytes)
+	}
+	stats := l.find("SSH_FXP_STAT")
+	if assert.Len(t, stats, 1) {
+		assert.Equal(t, "/../foo", stats[0].Path)
+		assert.Error(t, stats[0].Err, "rejected requests are logged too")
+	}
+}
@@ -6,7 +6,7 @@ import (
 	"testing"
 	"time"
 
-	"github.com/pkg/sftp/internal/apis"
+	"github.com/pkg/sftp/apis"
 )
 
 const (
@@ -1,6 +1,7 @@
 package sftp
 
 import (
+	iofs "io/fs"
 	"path"
 	"strings"
 )
@@ -8,13 +9,63 @@ import (
 // ErrBadPattern indicates a globbing pattern was malformed.
 var ErrBadPattern = path.ErrBadPattern
 
+// globOptions holds the options of Match and Glob.
+type globOptions struct {
+	doublestar bool
+	braces     bool
+}
+
+// A GlobOption extends the patterns of Match and Glob.
+type GlobOption func(*globOptions)
+
+// GlobDoublestar makes a ** path element match zero or more path elements,
+// so that a/**/*.go matches a/x.go and a/b/c/x.go. Glob finds the matches
+// below a ** by walking the directory tree there.
+func GlobDoublestar() GlobOption {
+	return func(o *globOptions) {
+		o.doublestar = true
+	}
+}
+
+// GlobBraces makes {a,b} match either a or b. The alternatives may contain
+// patterns and alternations themselves, and a \{ is a literal brace.
+func GlobBraces() GlobOption {
+	return func(o *globOptions) {
+		o.braces = true
+	}
+}
+
 // Match reports whether name matches the shell pattern.
 //
-// This is an alias for path.Match from the standard library,
-// offered so that callers need not import the path package.
+// Without options, this is an alias for path.Match from the standard
+// library, offered so that callers need not import the path package.
 // For details, see https://golang.org/pkg/path/#Match.
-func Match(pattern, name string) (matched bool, err error) {
-	return path.Match(pattern, name)
+func Match(pattern, name string, opts ...GlobOption) (matched bool, err error) {
+	if len(opts) == 0 {
+		return path.Match(pattern, name)
+	}
+	var o globOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	patterns := []string{pattern}
+	if o.braces {
+		if patterns, err = expandBraces(pattern); err != nil {
+			return false, err
+		}
+	}
+	for _, pattern := range patterns {
+		if o.doublestar && hasDoublestar(pattern) {
+			matched, err = matchElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+		} else {
+			matched, err = path.Match(pattern, name)
+		}
+		if matched || err != nil {
+			return matched, err
+		}
+	}
+	return false, nil
 }
 
 // detect if byte(char) is path separator
@@ -34,15 +85,76 @@ func Split(p string) (dir, file string) {
 
 // Glob returns the names of all files matching pattern or nil
 // if there is no matching file. The syntax of patterns is the same
-// as in Match. The pattern may describe hierarchical names such as
-// /usr/*/bin/ed.
+// as in Match, with the same options. The pattern may describe
+// hierarchical names such as /usr/*/bin/ed.
+//
+// If the server supports the glob@github.com/pkg/sftp extension, as the
+// Server of this package does, it matches the pattern in a single round
+// trip. Otherwise, or with WithPathRewrite, Glob lists the directories
+// itself.
 //
 // Glob ignores file system errors such as I/O errors reading directories.
 // The only possible returned error is ErrBadPattern, when pattern
 // is malformed.
-func (c *Client) Glob(pattern string) (matches []string, err error) {
+func (c *Client) Glob(pattern string, opts ...GlobOption) (matches []string, err error) {
+	var o globOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// patterns cannot be rewritten like paths
+	if _, ok := c.HasExtension(globExtension); ok && c.paths == nil {
+		matches, err := c.globRemote(pattern, &o)
+		if _, ok := err.(*StatusError); !ok {
+			return matches, err
+		}
+		// bad patterns, or too many matches for a reply
+	}
+	return glob(c, pattern, &o)
+}
+
+// globFS looks up the files Glob matches: those of a Client, or those of a
+// Server answering a glob request.
+type globFS interface {
+	Stat(name string) (iofs.FileInfo, error)
+	Lstat(name string) (iofs.FileInfo, error)
+	ReadDir(name string) ([]iofs.FileInfo, error)
+}
+
+// glob is Glob looking up the files in fsys.
+func glob(fsys globFS, pattern string, o *globOptions) (matches []string, err error) {
+	if !o.braces {
+		return globPattern(fsys, pattern, o)
+	}
+
+	patterns, err := expandBraces(pattern)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		m, err := globPattern(fsys, pattern, o)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range m {
+			if !seen[name] {
+				seen[name] = true
+				matches = append(matches, name)
+			}
+		}
+	}
+	return matches, nil
+}
+
+// globPattern is glob for a pattern without alternations.
+func globPattern(fsys globFS, pattern string, o *globOptions) (matches []string, err error) {
+	if o.doublestar && hasDoublestar(pattern) {
+		return globDoublestar(fsys, pattern, o)
+	}
+
 	if !hasMeta(pattern) {
-		file, err := c.Lstat(pattern)
+		file, err := fsys.Lstat(pattern)
 		if err != nil {
 			return nil, nil
 		}
@@ -55,7 +167,7 @@ func (c *Client) Glob(pattern string) (matches []string, err error) {
 	dir = cleanGlobPath(dir)
 
 	if !hasMeta(dir) {
-		return c.glob(dir, file, nil)
+		return globDir(fsys, dir, file, nil)
 	}
 
 	// Prevent infinite recursion. See issue 15879.
@@ -64,12 +176,12 @@ func (c *Client) Glob(pattern string) (matches []string, err error) {
 	}
 
 	var m []string
-	m, err = c.Glob(dir)
+	m, err = globPattern(fsys, dir, o)
 	if err != nil {
 		return
 	}
 	for _, d := range m {
-		matches, err = c.glob(d, file, matches)
+		matches, err = globDir(fsys, d, file, matches)
 		if err != nil {
 			return
 		}
@@ -89,20 +201,20 @@ func cleanGlobPath(path string) string {
 	}
 }
 
-// glob searches for files matching pattern in the directory dir
+// globDir searches for files matching pattern in the directory dir
 // and appends them to matches. If the directory cannot be
 // opened, it returns the existing matches. New matches are
 // added in lexicographical order.
-func (c *Client) glob(dir, pattern string, matches []string) (m []string, e error) {
+func globDir(fsys globFS, dir, pattern string, matches []string) (m []string, e error) {
 	m = matches
-	fi, err := c.Stat(dir)
+	fi, err := fsys.Stat(dir)
 	if err != nil {
 		return
 	}
 	if !fi.IsDir() {
 		return
 	}
-	names, err := c.ReadDir(dir)
+	names, err := fsys.ReadDir(dir)
 	if err != nil {
 		return
 	}
@@ -135,3 +247,171 @@ func Join(elem ...string) string {
 func hasMeta(path string) bool {
 	return strings.ContainsAny(path, "\\*?[")
 }
+
+// hasDoublestar reports whether pattern has a ** path element.
+func hasDoublestar(pattern string) bool {
+	for _, elem := range strings.Split(pattern, "/") {
+		if elem == "**" {
+			return true
+		}
+	}
+	return false
+}
+
+// globDoublestar is globPattern for a pattern with a ** path element. It
+// walks the trees of the directories matching what comes before the first
+// one, and matches the names below them against the rest.
+func globDoublestar(fsys globFS, pattern string, o *globOptions) (matches []string, err error) {
+	elems := strings.Split(pattern, "/")
+	i := 0
+	for elems[i] != "**" {
+		i++
+	}
+	rest := elems[i:]
+	for _, elem := range rest {
+		if _, err := path.Match(elem, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	base := strings.Join(elems[:i], "/")
+	switch {
+	case base == "" && strings.HasPrefix(pattern, "/"):
+		base = "/"
+	case base == "":
+		base = "."
+	}
+	bases := []string{base}
+	if hasMeta(base) {
+		if bases, err = globPattern(fsys, base, o); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, base := range bases {
+		info, err := fsys.Stat(base)
+		if err != nil {
+			continue
+		}
+		globWalk(fsys, base, info, func(name string) {
+			var relElems []string
+			if rel := relativeTo(base, name); rel != "" {
+				relElems = strings.Split(rel, "/")
+			}
+			if matched, _ := matchElems(rest, relElems); matched {
+				matches = append(matches, name)
+			}
+		})
+	}
+	return matches, nil
+}
+
+// globWalk calls fn for name and, if it is a directory, everything below it,
+// without following symbolic links. Directories which cannot be listed are
+// skipped.
+func globWalk(fsys globFS, name string, info iofs.FileInfo, fn func(name string)) {
+	fn(name)
+	if !info.IsDir() {
+		return
+	}
+	infos, err := fsys.ReadDir(name)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		globWalk(fsys, path.Join(name, info.Name()), info, fn)
+	}
+}
+
+// relativeTo returns name relative to the directory base it was walked from.
+func relativeTo(base, name string) string {
+	switch {
+	case name == base:
+		return ""
+	case base == "/":
+		return name[1:]
+	case base == ".":
+		return name
+	}
+	return name[len(base)+1:]
+}
+
+// matchElems reports whether the path elements of name match the elements
+// of pattern, where ** matches zero or more elements.
+func matchElems(pattern, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for len(pattern) > 1 && pattern[1] == "**" {
+				pattern = pattern[1:]
+			}
+			for i := 0; i <= len(name); i++ {
+				if matched, err := matchElems(pattern[1:], name[i:]); matched || err != nil {
+					return matched, err
+				}
+			}
+			return false, nil
+		}
+		if len(name) == 0 {
+			return false, nil
+		}
+		if matched, err := path.Match(pattern[0], name[0]); !matched || err != nil {
+			return false, err
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0, nil
+}
+
+// expandBraces returns the patterns of the {a,b} alternations of pattern.
+func expandBraces(pattern string) ([]string, error) {
+	start, depth := -1, 0
+	var commas []int
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '[':
+			// braces and commas in a character class are literal
+			for i++; i < len(pattern) && pattern[i] != ']'; i++ {
+				if pattern[i] == '\\' {
+					i++
+				}
+			}
+		case '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		case '}':
+			if depth == 0 {
+				// a literal brace, as in shells
+				continue
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+
+			prefix, suffix := pattern[:start], pattern[i+1:]
+			var patterns []string
+			from := start + 1
+			for _, to := range append(commas, i) {
+				expanded, err := expandBraces(prefix + pattern[from:to] + suffix)
+				if err != nil {
+					return nil, err
+				}
+				patterns = append(patterns, expanded...)
+				from = to + 1
+			}
+			return patterns, nil
+		}
+	}
+	if depth != 0 {
+		return nil, ErrBadPattern
+	}
+	return []string{pattern}, nil
+}
@@ -0,0 +1,664 @@
+// Package memfs is an in-memory file system for the sftp.RequestServer, for
+// tests and for ephemeral scratch servers.
+//
+//	server := sftp.NewRequestServer(channel, memfs.New().Handlers())
+//
+// It supports directories, symbolic and hard links, permissions, owners,
+// access and modification times, and reads and writes at any offset, with
+// the gaps writes leave reading as zeros. It is safe for concurrent use, by
+// any number of sessions.
+//
+// Permissions are kept and reported, but not enforced.
+package memfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// maxFollows is how many symbolic links are followed resolving a path.
+const maxFollows = 40
+
+var (
+	errNotEmpty = errors.New("directory not empty")
+	errLoop     = errors.New("too many levels of symbolic links")
+)
+
+// FS is an in-memory file system, see New.
+type FS struct {
+	mu   sync.Mutex // guards all the nodes and their contents
+	root *node
+}
+
+// New returns an empty FS, holding only the root directory.
+func New() *FS {
+	now := time.Now()
+	return &FS{
+		root: &node{
+			mode:       fs.ModeDir | 0o755,
+			modTime:    now,
+			accessTime: now,
+			children:   make(map[string]*node),
+		},
+	}
+}
+
+// Handlers returns the sftp.Handlers serving fsys.
+func (fsys *FS) Handlers() sftp.Handlers {
+	return sftp.Handlers{
+		FileGet:  fsys,
+		FilePut:  fsys,
+		FileCmd:  fsys,
+		FileList: fsys,
+	}
+}
+
+// node is a file, directory or symbolic link. Hard links share a node.
+type node struct {
+	mode       fs.FileMode
+	modTime    time.Time
+	accessTime time.Time
+	uid, gid   uint32
+
+	data     []byte           // of files
+	target   string           // of symbolic links
+	children map[string]*node // of directories
+}
+
+func (n *node) isDir() bool     { return n.mode.IsDir() }
+func (n *node) isSymlink() bool { return n.mode&fs.ModeSymlink != 0 }
+
+// resize truncates or extends the data of n to size, extending it by zeros.
+func (n *node) resize(size int64) {
+	old := int64(len(n.data))
+	switch {
+	case size <= old:
+		n.data = n.data[:size]
+	case size <= int64(cap(n.data)):
+		n.data = n.data[:size]
+		for i := old; i < size; i++ {
+			n.data[i] = 0
+		}
+	default:
+		c := 2 * int64(cap(n.data))
+		if c < size {
+			c = size
+		}
+		data := make([]byte, size, c)
+		copy(data, n.data)
+		n.data = data
+	}
+}
+
+// info returns the fs.FileInfo of n under name.
+func (n *node) info(name string) fs.FileInfo {
+	fi := &fileInfo{
+		name:    name,
+		mode:    n.mode,
+		modTime: n.modTime,
+		sys: &sftp.FileStat{
+			UID:   n.uid,
+			GID:   n.gid,
+			Atime: uint32(n.accessTime.Unix()),
+			Mtime: uint32(n.modTime.Unix()),
+		},
+	}
+	switch {
+	case n.isSymlink():
+		fi.size = int64(len(n.target))
+	case !n.isDir():
+		fi.size = int64(len(n.data))
+	}
+	fi.sys.Size = uint64(fi.size)
+	return fi
+}
+
+// fileInfo is a snapshot of a node, as it was when it was taken.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	sys     *sftp.FileStat
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return fi.sys }
+
+// lookup resolves name to the directory it is in, its base name in there and
+// its node, nil if there is none. Symbolic links are followed in the
+// directories of name, and at its end if follow is set. The root directory
+// is in itself.
+func (fsys *FS) lookup(name string, follow bool) (dir *node, base string, n *node, err error) {
+	hops := 0
+	return fsys.walk(name, follow, &hops)
+}
+
+func (fsys *FS) walk(name string, follow bool, hops *int) (*node, string, *node, error) {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return fsys.root, "/", fsys.root, nil
+	}
+
+	dirName, base := path.Split(name)
+	_, _, dir, err := fsys.walk(dirName, true, hops)
+	switch {
+	case err != nil:
+		return nil, "", nil, err
+	case dir == nil:
+		return nil, "", nil, syscall.ENOENT
+	case !dir.isDir():
+		return nil, "", nil, syscall.ENOTDIR
+	}
+
+	n := dir.children[base]
+	if n == nil || !follow || !n.isSymlink() {
+		return dir, base, n, nil
+	}
+	if *hops++; *hops > maxFollows {
+		return nil, "", nil, errLoop
+	}
+	target := n.target
+	if !path.IsAbs(target) {
+		target = path.Join(dirName, target)
+	}
+	return fsys.walk(target, true, hops)
+}
+
+// find returns the node of name, failing if there is none.
+func (fsys *FS) find(name string, follow bool) (*node, error) {
+	_, _, n, err := fsys.lookup(name, follow)
+	if err == nil && n == nil {
+		err = syscall.ENOENT
+	}
+	return n, err
+}
+
+// add adds n to dir as base, failing if there is something already.
+func (fsys *FS) add(dir *node, base string, n *node) error {
+	if dir.children[base] != nil {
+		return syscall.EEXIST
+	}
+	now := time.Now()
+	if n.modTime.IsZero() {
+		n.modTime = now
+		n.accessTime = now
+	}
+	dir.children[base] = n
+	dir.modTime = now
+	return nil
+}
+
+// remove removes base from dir.
+func (fsys *FS) remove(dir *node, base string) {
+	delete(dir.children, base)
+	dir.modTime = time.Now()
+}
+
+func pathError(op, name string, err error) error {
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// Fileread opens a file for reading.
+func (fsys *FS) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	return fsys.OpenFile(r)
+}
+
+// Filewrite opens a file for writing.
+func (fsys *FS) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return fsys.OpenFile(r)
+}
+
+// OpenFile opens a file for reading and writing, implementing
+// sftp.OpenFileWriter. It creates, truncates and appends to the file as the
+// flags of r ask, following symbolic links, even dangling ones.
+func (fsys *FS) OpenFile(r *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	flags := r.Pflags()
+	dir, base, n, err := fsys.lookup(r.Filepath, true)
+	switch {
+	case err != nil:
+		return nil, pathError("open", r.Filepath, err)
+
+	case n == nil:
+		if !flags.Creat {
+			return nil, pathError("open", r.Filepath, syscall.ENOENT)
+		}
+		n = &node{mode: 0o644}
+		if err := fsys.add(dir, base, n); err != nil {
+			return nil, pathError("open", r.Filepath, err)
+		}
+
+	case flags.Creat && flags.Excl:
+		return nil, pathError("open", r.Filepath, syscall.EEXIST)
+
+	case n.isDir():
+		return nil, pathError("open", r.Filepath, syscall.EISDIR)
+
+	case flags.Trunc:
+		n.resize(0)
+		n.modTime = time.Now()
+	}
+
+	return &file{fsys: fsys, n: n, append: flags.Append}, nil
+}
+
+// file is an open file.
+type file struct {
+	fsys   *FS
+	n      *node
+	append bool
+}
+
+func (f *file) ReadAt(b []byte, off int64) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if off < 0 {
+		return 0, syscall.EINVAL
+	}
+	f.n.accessTime = time.Now()
+	if off >= int64(len(f.n.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.n.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *file) WriteAt(b []byte, off int64) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if off < 0 {
+		return 0, syscall.EINVAL
+	}
+	if f.append {
+		off = int64(len(f.n.data))
+	}
+	if end := off + int64(len(b)); end > int64(len(f.n.data)) {
+		f.n.resize(end)
+	}
+	f.n.modTime = time.Now()
+	return copy(f.n.data[off:], b), nil
+}
+
+// Filecmd serves the commands, see sftp.FileCmder.
+func (fsys *FS) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		return fsys.setstat(r)
+	case "Rename":
+		return fsys.rename(r.Filepath, r.Target, false)
+	case "Rmdir":
+		return fsys.rmdir(r.Filepath)
+	case "Remove":
+		return fsys.removeFile(r.Filepath)
+	case "Mkdir":
+		return fsys.mkdir(r)
+	case "Symlink":
+		// r.Filepath is what the link points to, r.Target the link.
+		return fsys.symlink(r.Filepath, r.Target)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// setstat sets the attributes of a Setstat request which also has extended
+// attributes, which are not supported, or it would not have reached
+// Filecmd.
+func (fsys *FS) setstat(r *sftp.Request) error {
+	attrs, err := r.FileAttributes()
+	if err != nil {
+		return err
+	}
+	if size, ok := attrs.Size(); ok {
+		if err := fsys.Truncate(r, size); err != nil {
+			return err
+		}
+	}
+	if mode, ok := attrs.Mode(); ok {
+		if err := fsys.Chmod(r, mode); err != nil {
+			return err
+		}
+	}
+	if atime, mtime, ok := attrs.Times(); ok {
+		if err := fsys.Chtimes(r, atime, mtime); err != nil {
+			return err
+		}
+	}
+	if uid, gid, ok := attrs.Owner(); ok {
+		return fsys.Chown(r, uid, gid)
+	}
+	return nil
+}
+
+// PosixRename renames like Rename, but replaces an existing target,
+// implementing sftp.PosixRenameFileCmder.
+func (fsys *FS) PosixRename(r *sftp.Request) error {
+	return fsys.rename(r.Filepath, r.Target, true)
+}
+
+func (fsys *FS) rename(oldpath, newpath string, replace bool) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	odir, obase, n, err := fsys.lookup(oldpath, false)
+	if err == nil && n == nil {
+		err = syscall.ENOENT
+	}
+	if err != nil {
+		return pathError("rename", oldpath, err)
+	}
+	if n == fsys.root {
+		return pathError("rename", oldpath, syscall.EINVAL)
+	}
+
+	ndir, nbase, old, err := fsys.lookup(newpath, false)
+	if err != nil {
+		return pathError("rename", newpath, err)
+	}
+	if old == n {
+		return nil
+	}
+	if n.isDir() && contains(n, ndir) {
+		return pathError("rename", newpath, syscall.EINVAL)
+	}
+
+	if old != nil {
+		switch {
+		case !replace:
+			// SFTP-v2: it is an error if there already exists a file with the name specified by newpath.
+			return pathError("rename", newpath, syscall.EEXIST)
+		case n.isDir() && !old.isDir():
+			return pathError("rename", newpath, syscall.ENOTDIR)
+		case !n.isDir() && old.isDir():
+			return pathError("rename", newpath, syscall.EISDIR)
+		case old.isDir() && len(old.children) > 0:
+			return pathError("rename", newpath, errNotEmpty)
+		}
+		fsys.remove(ndir, nbase)
+	}
+
+	fsys.remove(odir, obase)
+	return fsys.add(ndir, nbase, n)
+}
+
+// contains reports whether dir is sub, or has it in its tree.
+func contains(dir, sub *node) bool {
+	if dir == sub {
+		return true
+	}
+	for _, child := range dir.children {
+		if child.isDir() && contains(child, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func (fsys *FS) rmdir(name string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	dir, base, n, err := fsys.lookup(name, false)
+	switch {
+	case err != nil:
+		return pathError("rmdir", name, err)
+	case n == nil:
+		return pathError("rmdir", name, syscall.ENOENT)
+	case n == fsys.root:
+		return pathError("rmdir", name, syscall.EINVAL)
+	case !n.isDir():
+		return pathError("rmdir", name, syscall.ENOTDIR)
+	case len(n.children) > 0:
+		return pathError("rmdir", name, errNotEmpty)
+	}
+	fsys.remove(dir, base)
+	return nil
+}
+
+func (fsys *FS) removeFile(name string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	dir, base, n, err := fsys.lookup(name, false)
+	switch {
+	case err != nil:
+		return pathError("remove", name, err)
+	case n == nil:
+		return pathError("remove", name, syscall.ENOENT)
+	case n.isDir():
+		// SFTP-v2: SSH_FXP_REMOVE may not remove directories.
+		return pathError("remove", name, syscall.EISDIR)
+	}
+	fsys.remove(dir, base)
+	return nil
+}
+
+func (fsys *FS) mkdir(r *sftp.Request) error {
+	mode := fs.FileMode(0o755)
+	if attrs, err := r.FileAttributes(); err == nil {
+		if perm, ok := attrs.Mode(); ok {
+			mode = perm
+		}
+	}
+
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	dir, base, _, err := fsys.lookup(r.Filepath, false)
+	if err == nil {
+		err = fsys.add(dir, base, &node{
+			mode:     fs.ModeDir | mode,
+			children: make(map[string]*node),
+		})
+	}
+	if err != nil {
+		return pathError("mkdir", r.Filepath, err)
+	}
+	return nil
+}
+
+func (fsys *FS) symlink(target, linkpath string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	dir, base, _, err := fsys.lookup(linkpath, false)
+	if err == nil {
+		err = fsys.add(dir, base, &node{
+			mode:   fs.ModeSymlink | 0o777,
+			target: target,
+		})
+	}
+	if err != nil {
+		return pathError("symlink", linkpath, err)
+	}
+	return nil
+}
+
+// Link creates r.Target as a hard link of the file r.Filepath, implementing
+// sftp.Linker.
+func (fsys *FS) Link(r *sftp.Request) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	n, err := fsys.find(r.Filepath, false)
+	if err != nil {
+		return pathError("link", r.Filepath, err)
+	}
+	if n.isDir() {
+		return pathError("link", r.Filepath, syscall.EPERM)
+	}
+
+	dir, base, _, err := fsys.lookup(r.Target, false)
+	if err == nil {
+		err = fsys.add(dir, base, n)
+	}
+	if err != nil {
+		return pathError("link", r.Target, err)
+	}
+	return nil
+}
+
+// Truncate implements sftp.Truncater.
+func (fsys *FS) Truncate(r *sftp.Request, size int64) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	n, err := fsys.find(r.Filepath, true)
+	switch {
+	case err != nil:
+		return pathError("truncate", r.Filepath, err)
+	case n.isDir():
+		return pathError("truncate", r.Filepath, syscall.EISDIR)
+	case size < 0:
+		return pathError("truncate", r.Filepath, syscall.EINVAL)
+	}
+	n.resize(size)
+	n.modTime = time.Now()
+	return nil
+}
+
+// Chmod implements sftp.Chmodder.
+func (fsys *FS) Chmod(r *sftp.Request, mode fs.FileMode) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	n, err := fsys.find(r.Filepath, true)
+	if err != nil {
+		return pathError("chmod", r.Filepath, err)
+	}
+	n.mode = n.mode&fs.ModeType | mode&^fs.ModeType
+	return nil
+}
+
+// Chtimes implements sftp.Chtimeser.
+func (fsys *FS) Chtimes(r *sftp.Request, atime, mtime time.Time) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	n, err := fsys.find(r.Filepath, true)
+	if err != nil {
+		return pathError("chtimes", r.Filepath, err)
+	}
+	n.accessTime = atime
+	n.modTime = mtime
+	return nil
+}
+
+// Chown implements sftp.Chowner.
+func (fsys *FS) Chown(r *sftp.Request, uid, gid int) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	n, err := fsys.find(r.Filepath, true)
+	if err != nil {
+		return pathError("chown", r.Filepath, err)
+	}
+	n.uid = uint32(uid)
+	n.gid = uint32(gid)
+	return nil
+}
+
+// listerAt lists a snapshot of fs.FileInfos.
+type listerAt []fs.FileInfo
+
+func (l listerAt) ListAt(ls []fs.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Filelist serves List, Stat and Readlink, see sftp.FileLister.
+func (fsys *FS) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		return fsys.list(r.Filepath)
+	case "Stat":
+		return fsys.stat(r.Filepath, true)
+	case "Readlink":
+		target, err := fsys.Readlink(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{&fileInfo{name: target}}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// Lstat implements sftp.LstatFileLister.
+func (fsys *FS) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	return fsys.stat(r.Filepath, false)
+}
+
+func (fsys *FS) stat(name string, follow bool) (sftp.ListerAt, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	_, base, n, err := fsys.lookup(name, follow)
+	if err == nil && n == nil {
+		err = syscall.ENOENT
+	}
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return listerAt{n.info(base)}, nil
+}
+
+func (fsys *FS) list(name string) (sftp.ListerAt, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	dir, err := fsys.find(name, true)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	if !dir.isDir() {
+		return nil, pathError("readdir", name, syscall.ENOTDIR)
+	}
+
+	infos := make(listerAt, 0, len(dir.children))
+	for base, n := range dir.children {
+		infos = append(infos, n.info(base))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	dir.accessTime = time.Now()
+	return infos, nil
+}
+
+// Readlink returns the target of the symbolic link name, implementing
+// sftp.ReadlinkFileLister.
+func (fsys *FS) Readlink(name string) (string, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	n, err := fsys.find(name, false)
+	if err != nil {
+		return "", pathError("readlink", name, err)
+	}
+	if !n.isSymlink() {
+		return "", pathError("readlink", name, syscall.EINVAL)
+	}
+	return n.target, nil
+}
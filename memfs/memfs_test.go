@@ -0,0 +1,213 @@
+package memfs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pkg/sftp"
+)
+
+// serve serves fsys to a client over pipes.
+func serve(t *testing.T, fsys *FS) *sftp.Client {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server := sftp.NewRequestServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw}, fsys.Handlers())
+	go server.Serve()
+	client, err := sftp.NewClientPipe(cr, cw)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return client
+}
+
+func readFile(t *testing.T, client *sftp.Client, name string) string {
+	f, err := client.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestFiles(t *testing.T) {
+	client := serve(t, New())
+
+	f, err := client.Create("/sparse")
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("end"), 5)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("ab"), 1)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, "\x00ab\x00\x00end", readFile(t, client, "/sparse"))
+
+	require.NoError(t, client.Truncate("/sparse", 3))
+	require.NoError(t, client.Truncate("/sparse", 5))
+	assert.Equal(t, "\x00ab\x00\x00", readFile(t, client, "/sparse"), "extended by zeros")
+
+	f, err = client.OpenFile("/sparse", os.O_WRONLY|os.O_APPEND)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("!"), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, "\x00ab\x00\x00!", readFile(t, client, "/sparse"))
+
+	_, err = client.OpenFile("/sparse", os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	assert.Error(t, err)
+	_, err = client.Open("/missing")
+	assert.True(t, os.IsNotExist(err), "%v", err)
+}
+
+func TestAttributes(t *testing.T) {
+	client := serve(t, New())
+
+	f, err := client.Create("/foo")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	atime, mtime := time.Unix(1000, 0), time.Unix(2000, 0)
+	require.NoError(t, client.Chmod("/foo", 0o600))
+	require.NoError(t, client.Chtimes("/foo", atime, mtime))
+	require.NoError(t, client.Chown("/foo", 7, 8))
+
+	fi, err := client.Stat("/foo")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode())
+	assert.Equal(t, mtime, fi.ModTime())
+	stat := fi.Sys().(*sftp.FileStat)
+	assert.Equal(t, uint32(1000), stat.Atime)
+	assert.Equal(t, uint32(7), stat.UID)
+	assert.Equal(t, uint32(8), stat.GID)
+
+	require.NoError(t, client.Mkdir("/dir"))
+	fi, err = client.Stat("/dir")
+	require.NoError(t, err)
+	assert.True(t, fi.IsDir())
+	assert.Equal(t, os.ModeDir|0o755, fi.Mode())
+}
+
+func TestLinks(t *testing.T) {
+	client := serve(t, New())
+
+	require.NoError(t, client.Mkdir("/dir"))
+	f, err := client.Create("/dir/file")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.NoError(t, client.Symlink("/dir", "/link"))
+	assert.Equal(t, "hello", readFile(t, client, "/link/file"), "through a symbolic link")
+	target, err := client.ReadLink("/link")
+	require.NoError(t, err)
+	assert.Equal(t, "/dir", target)
+	fi, err := client.Lstat("/link")
+	require.NoError(t, err)
+	assert.True(t, fi.Mode()&os.ModeSymlink != 0)
+
+	require.NoError(t, client.Link("/dir/file", "/hard"))
+	f, err = client.OpenFile("/hard", os.O_WRONLY)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("J"), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, "Jello", readFile(t, client, "/dir/file"), "hard links share the content")
+
+	require.NoError(t, client.Remove("/dir/file"))
+	assert.Equal(t, "Jello", readFile(t, client, "/hard"))
+
+	require.NoError(t, client.Symlink("/loop", "/loop"))
+	_, err = client.Stat("/loop")
+	assert.Error(t, err)
+}
+
+func TestRenames(t *testing.T) {
+	client := serve(t, New())
+
+	require.NoError(t, client.MkdirAll("/a/b"))
+	f, err := client.Create("/a/b/file")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	f, err = client.Create("/other")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	assert.Error(t, client.Rename("/a", "/a/b/c"), "into itself")
+	assert.Error(t, client.Rename("/a/b/file", "/other"), "SFTP renames do not replace")
+	require.NoError(t, client.PosixRename("/a/b/file", "/other"))
+	require.NoError(t, client.Rename("/a", "/c"))
+
+	names := func(dir string) []string {
+		fis, err := client.ReadDir(dir)
+		require.NoError(t, err)
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		return names
+	}
+	assert.Equal(t, []string{"c", "other"}, names("/"))
+	assert.Equal(t, []string{"b"}, names("/c"))
+	assert.Empty(t, names("/c/b"))
+
+	assert.Error(t, client.RemoveDirectory("/c"), "not empty")
+	require.NoError(t, client.RemoveDirectory("/c/b"))
+	require.NoError(t, client.RemoveDirectory("/c"))
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	fsys := New()
+	client := serve(t, fsys)
+
+	f, err := client.Create("/file")
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := f.WriteAt([]byte{byte('a' + i)}, int64(i))
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	require.NoError(t, f.Close())
+	assert.Equal(t, "abcdefgh", readFile(t, client, "/file"))
+}
+
+func TestOpenAppend(t *testing.T) {
+	client := serve(t, New())
+
+	// the writes of both go to the end, as memfs honors SSH_FXF_APPEND
+	f1, err := client.OpenAppend("/log")
+	require.NoError(t, err)
+	defer f1.Close()
+	f2, err := client.OpenAppend("/log")
+	require.NoError(t, err)
+	defer f2.Close()
+
+	for _, w := range []struct {
+		f    *sftp.File
+		data string
+	}{{f1, "a"}, {f2, "b"}, {f1, "c"}} {
+		_, err := w.f.Write([]byte(w.data))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "abc", readFile(t, client, "/log"))
+
+	infos, err := client.ReadDir("/")
+	require.NoError(t, err)
+	assert.Len(t, infos, 1, "the probe is removed")
+}
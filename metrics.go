@@ -0,0 +1,93 @@
+package sftp
+
+import (
+	"time"
+)
+
+// MetricsCollector receives the measurements of a Client, Server or
+// RequestServer, see UseMetrics, WithMetrics and WithRSMetrics, to feed a
+// monitoring system; the module github.com/pkg/sftp/prometheus has one for
+// Prometheus.
+//
+// The methods are called concurrently from the hot paths of the session,
+// and have to return quickly.
+type MetricsCollector interface {
+	// ObserveRequest records a request of packet type packet, like
+	// "SSH_FXP_READ", answered with status after latency. The status is the
+	// name of the status code, like "SSH_FX_NO_SUCH_FILE", and "SSH_FX_OK"
+	// for requests answered with something else than a status.
+	ObserveRequest(packet, status string, latency time.Duration)
+
+	// AddBytes adds the file contents read and written by requests.
+	AddBytes(read, written int64)
+
+	// AddOpenHandles adds delta to the number of open file and directory
+	// handles.
+	AddOpenHandles(delta int)
+}
+
+// UseMetrics has the Client pass the measurements of its requests
+// to metrics.
+func UseMetrics(metrics MetricsCollector) ClientOption {
+	return func(c *Client) error {
+		c.metrics = metrics
+		if c.logged == nil {
+			c.logged = make(map[uint32]loggedRequest)
+		}
+		return nil
+	}
+}
+
+// WithMetrics has the Server pass the measurements of the requests it serves
+// to metrics.
+func WithMetrics(metrics MetricsCollector) ServerOption {
+	return func(s *Server) error {
+		s.metrics = metrics
+		return nil
+	}
+}
+
+// WithRSMetrics has the RequestServer pass the measurements of the requests
+// it serves to metrics.
+func WithRSMetrics(metrics MetricsCollector) RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.metrics = metrics
+	}
+}
+
+// observe passes the event of an answered request to logger, metrics and
+// span, any of which may be nil.
+func observe(logger Logger, metrics MetricsCollector, span Span, ev LogEvent) {
+	if span != nil {
+		span.End(ev)
+	}
+	if logger != nil {
+		logger.Log(ev)
+	}
+	if metrics == nil {
+		return
+	}
+
+	metrics.ObserveRequest(ev.Packet, statusName(ev.Err), ev.Latency)
+	if ev.Bytes > 0 && ev.Err == nil {
+		if ev.Packet == fxp(sshFxpWrite).String() {
+			metrics.AddBytes(0, ev.Bytes)
+		} else {
+			metrics.AddBytes(ev.Bytes, 0)
+		}
+	}
+}
+
+// statusName returns the name of the status code a request failed with.
+func statusName(err error) string {
+	switch err := err.(type) {
+	case nil:
+		return fx(sshFxOk).String()
+	case *StatusError:
+		if err.Code > 0xff {
+			return "unknown"
+		}
+		return fx(err.Code).String()
+	}
+	return fx(sshFxFailure).String()
+}
@@ -0,0 +1,120 @@
+package sftp
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingMetrics is a MetricsCollector keeping totals.
+type countingMetrics struct {
+	mu       sync.Mutex
+	requests map[string]int // by "packet status"
+	read     int64
+	written  int64
+	handles  int
+}
+
+func (m *countingMetrics) ObserveRequest(packet, status string, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.requests == nil {
+		m.requests = make(map[string]int)
+	}
+	m.requests[packet+" "+status]++
+}
+
+func (m *countingMetrics) AddBytes(read, written int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.read += read
+	m.written += written
+}
+
+func (m *countingMetrics) AddOpenHandles(delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handles += delta
+}
+
+func (m *countingMetrics) snapshot() (requests map[string]int, read, written int64, handles int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	requests = make(map[string]int, len(m.requests))
+	for k, v := range m.requests {
+		requests[k] = v
+	}
+	return requests, m.read, m.written, m.handles
+}
+
+func TestRequestServerMetrics(t *testing.T) {
+	m := new(countingMetrics)
+	p := clientRequestServerPair(t, WithRSMetrics(m))
+	defer p.Close()
+
+	f, err := p.cli.Create("/foo")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	_, _, _, handles := m.snapshot()
+	assert.Equal(t, 1, handles)
+
+	require.NoError(t, f.Close())
+
+	f, err = p.cli.Open("/foo")
+	require.NoError(t, err)
+	_, err = f.ReadAt(make([]byte, 5), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = p.cli.Stat("/missing")
+	require.Error(t, err)
+
+	requests, read, written, handles := m.snapshot()
+	assert.Equal(t, 2, requests["SSH_FXP_OPEN SSH_FX_OK"])
+	assert.Equal(t, 1, requests["SSH_FXP_WRITE SSH_FX_OK"])
+	assert.Equal(t, 1, requests["SSH_FXP_READ SSH_FX_OK"])
+	assert.Equal(t, 2, requests["SSH_FXP_CLOSE SSH_FX_OK"])
+	assert.Equal(t, 1, requests["SSH_FXP_STAT SSH_FX_FAILURE"], "the in-memory handler fails missing files")
+	assert.Equal(t, int64(5), read)
+	assert.Equal(t, int64(5), written)
+	assert.Equal(t, 0, handles)
+}
+
+func TestMetrics(t *testing.T) {
+	m, cm := new(countingMetrics), new(countingMetrics)
+
+	client, server := clientServerPairWith(t, []ServerOption{WithMetrics(m)}, UseMetrics(cm))
+
+	dir := t.TempDir()
+	_, err := client.Create(filepath.Join(dir, "foo"))
+	require.NoError(t, err)
+	_, err = client.ReadDir(dir)
+	require.NoError(t, err)
+
+	for _, m := range []*countingMetrics{m, cm} {
+		requests, _, _, handles := m.snapshot()
+		assert.Equal(t, 1, requests["SSH_FXP_OPEN SSH_FX_OK"])
+		assert.Equal(t, 1, requests["SSH_FXP_OPENDIR SSH_FX_OK"])
+		assert.Equal(t, 1, requests["SSH_FXP_READDIR SSH_FX_EOF"])
+		assert.Equal(t, 1, handles, "the created file is left open")
+	}
+
+	client.Close()
+	waitServed(server)
+
+	_, _, _, handles := m.snapshot()
+	assert.Equal(t, 0, handles, "the server closes the handles left open")
+}
+
+func TestStatusName(t *testing.T) {
+	assert.Equal(t, "SSH_FX_OK", statusName(nil))
+	assert.Equal(t, "SSH_FX_EOF", statusName(&StatusError{Code: sshFxEOF}))
+	assert.Equal(t, "unknown", statusName(&StatusError{Code: 1000}))
+	assert.Equal(t, "SSH_FX_FAILURE", statusName(ErrInternalInconsistency))
+}
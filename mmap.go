@@ -0,0 +1,86 @@
+package sftp
+
+import (
+	"fmt"
+	runtimedebug "runtime/debug"
+	"syscall"
+
+	"github.com/pkg/sftp/apis"
+)
+
+// WithMmapReads serves files opened read-only, which are OS files of at
+// least minSize bytes or rather implement apis.FileDescriptor, from a read-only memory mapping instead of a
+// read(2) per READ request. This saves system calls and copies for large
+// files many clients download, like OS images.
+//
+// Files that cannot be mapped are read as usual. Reads beyond the mapping,
+// because the file grew, or from pages gone, because it shrunk, fall back to
+// reading the file as well.
+func WithMmapReads(minSize int64) ServerOption {
+	return func(s *Server) error {
+		if minSize < 1 {
+			return fmt.Errorf("sftp: mmap size threshold %d is not positive", minSize)
+		}
+		s.mmapMinSize = minSize
+		return nil
+	}
+}
+
+// mmapFile is a file whose reads are served from data, its contents mapped into memory.
+type mmapFile struct {
+	apis.File
+	data []byte
+}
+
+// maybeMmap maps f, if the Server maps files and f is large enough.
+func (svr *Server) maybeMmap(f apis.File, osFlags int) apis.File {
+	if svr.mmapMinSize == 0 || osFlags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return f
+	}
+	fd, ok := f.(apis.FileDescriptor)
+	if !ok {
+		return f
+	}
+
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() < svr.mmapMinSize || fi.Size() > int64(^uint(0)>>1) {
+		return f
+	}
+
+	data, err := mmap(fd.Fd(), int(fi.Size()))
+	if err != nil {
+		debug("mmap %s: %v", f.Name(), err)
+		return f
+	}
+	return &mmapFile{File: f, data: data}
+}
+
+func (f *mmapFile) ReadAt(b []byte, off int64) (n int, err error) {
+	if off < 0 || off >= int64(len(f.data)) {
+		return f.File.ReadAt(b, off)
+	}
+
+	// pages of a file that shrunk fault, rather than crashing read them from the file
+	defer runtimedebug.SetPanicOnFault(runtimedebug.SetPanicOnFault(true))
+	defer func() {
+		if recover() != nil {
+			n, err = f.File.ReadAt(b, off)
+		}
+	}()
+
+	n = copy(b, f.data[off:])
+	if n < len(b) {
+		var m int
+		m, err = f.File.ReadAt(b[n:], off+int64(n))
+		n += m
+	}
+	return n, err
+}
+
+func (f *mmapFile) Close() error {
+	err := munmap(f.data)
+	if err2 := f.File.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
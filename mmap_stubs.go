@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package sftp
+
+func mmap(fd uintptr, size int) ([]byte, error) {
+	return nil, ErrSSHFxOpUnsupported
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,74 @@
+package sftp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithMmapReadsInvalid(t *testing.T) {
+	_, err := NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{}, apis.NewOS(), WithMmapReads(0))
+	assert.Error(t, err)
+}
+
+func TestServerMmapReads(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("mmap is not implemented on " + runtime.GOOS)
+	}
+
+	client, server := clientServerPairFS(t, apis.NewOS(), []ServerOption{WithMmapReads(1024)})
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	small := filepath.Join(dir, "small")
+	require.NoError(t, os.WriteFile(small, []byte("tiny"), 0644))
+	large := filepath.Join(dir, "large")
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1<<12)
+	require.NoError(t, os.WriteFile(large, content, 0644))
+
+	f, err := client.Open(small)
+	require.NoError(t, err)
+	defer f.Close()
+	handle, _ := server.getHandle(f.handle)
+	_, mapped := handle.(*mmapFile)
+	assert.False(t, mapped, "files below the threshold are not mapped")
+
+	f, err = client.Open(large)
+	require.NoError(t, err)
+	defer f.Close()
+	handle, _ = server.getHandle(f.handle)
+	_, mapped = handle.(*mmapFile)
+	assert.True(t, mapped)
+
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, content, b)
+
+	// data appended after mapping is read from the file
+	w, err := os.OpenFile(large, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("grown"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	b = make([]byte, 10)
+	n, err := f.ReadAt(b, int64(len(content)-5))
+	require.NoError(t, err)
+	assert.Equal(t, "bcdefgrown", string(b[:n]))
+
+	// pages gone with a truncate fault and fall back to the file
+	require.NoError(t, os.Truncate(large, 0))
+	_, err = f.ReadAt(b, int64(len(content)/2))
+	assert.Equal(t, io.EOF, err)
+}
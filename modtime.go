@@ -0,0 +1,112 @@
+package sftp
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+// ModTimeComparer compares the modification times of local and remote files,
+// as tools syncing trees do to find changed files. It tolerates servers which
+// keep timestamps coarser than SFTP, which carries whole seconds, and servers
+// reporting them shifted by a time zone offset.
+//
+// The zero value compares at the resolution of SFTP. Client.CalibrateModTimes
+// measures the other fields for a server.
+type ModTimeComparer struct {
+	// Granularity is the resolution of the remote timestamps,
+	// like 2s on FAT file systems. Less than a second counts as a second.
+	Granularity time.Duration
+
+	// Offset is added to remote timestamps to get the true time,
+	// like -1h for a server reporting UTC+1 local time as UTC.
+	Offset time.Duration
+
+	// Tolerance is the additional difference up to which times compare equal,
+	// like for clocks not quite in sync.
+	Tolerance time.Duration
+}
+
+// Normalize returns the true time of the remote timestamp t.
+func (m ModTimeComparer) Normalize(t time.Time) time.Time {
+	return t.Add(m.Offset)
+}
+
+// Compare returns 0 if the local and remote times are the same, as far as
+// the remote timestamps can tell, -1 if local is older, and +1 if it is newer.
+func (m ModTimeComparer) Compare(local, remote time.Time) int {
+	granularity := m.Granularity
+	if granularity < time.Second {
+		granularity = time.Second
+	}
+
+	d := local.Sub(m.Normalize(remote))
+	switch {
+	case d >= granularity+m.Tolerance:
+		return +1
+	case d <= -(granularity + m.Tolerance):
+		return -1
+	}
+	return 0
+}
+
+// Equal reports whether the local and remote times are the same,
+// as far as the remote timestamps can tell.
+func (m ModTimeComparer) Equal(local, remote time.Time) bool {
+	return m.Compare(local, remote) == 0
+}
+
+// calibrationTime is an even second at a whole hour, which the server
+// keeps as is, unlike the odd one after it if it only keeps even seconds.
+var calibrationTime = time.Unix(999997200, 0)
+
+// CalibrateModTimes measures how the server keeps and reports modification
+// times, by creating, touching and removing a temporary file in dir, which
+// has to be writable. Sync tools call it once at the start of a session.
+//
+// The difference of the time the server reports for the new file to the local
+// clock is rounded to quarter hours, like time zone offsets are, to get the
+// Offset. Smaller differences, from clocks not quite in sync, are left to
+// the Tolerance.
+func (c *Client) CalibrateModTimes(dir string) (ModTimeComparer, error) {
+	var m ModTimeComparer
+
+	name := path.Join(dir, fmt.Sprintf(".sftp-modtime-%d", time.Now().UnixNano()))
+	before := time.Now()
+	f, err := c.Create(name)
+	if err != nil {
+		return m, err
+	}
+	defer c.Remove(name)
+	if err := f.Close(); err != nil {
+		return m, err
+	}
+	after := time.Now()
+
+	fi, err := c.Stat(name)
+	if err != nil {
+		return m, err
+	}
+	created := before.Add(after.Sub(before) / 2)
+	m.Offset = created.Sub(fi.ModTime()).Round(15 * time.Minute)
+
+	// a server keeping even seconds only does not report them a second apart
+	var remote [2]time.Time
+	for i := range remote {
+		t := calibrationTime.Add(time.Duration(i) * time.Second)
+		if err := c.Chtimes(name, t, t); err != nil {
+			return m, err
+		}
+		fi, err := c.Stat(name)
+		if err != nil {
+			return m, err
+		}
+		remote[i] = fi.ModTime()
+	}
+	m.Granularity = time.Second
+	if remote[1].Sub(remote[0]) != time.Second {
+		m.Granularity = 2 * time.Second
+	}
+
+	return m, nil
+}
@@ -0,0 +1,76 @@
+package sftp
+
+import (
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModTimeComparer(t *testing.T) {
+	local := time.Date(2020, 1, 1, 12, 0, 0, 700e6, time.UTC)
+
+	var m ModTimeComparer
+	assert.True(t, m.Equal(local, local.Truncate(time.Second)))
+	assert.Equal(t, +1, m.Compare(local, local.Add(-2*time.Second)))
+	assert.Equal(t, -1, m.Compare(local, local.Add(2*time.Second)))
+
+	m = ModTimeComparer{Granularity: 2 * time.Second, Offset: -time.Hour}
+	assert.True(t, m.Equal(local, local.Add(time.Hour-time.Second)))
+	assert.Equal(t, +1, m.Compare(local, local.Add(time.Hour-3*time.Second)))
+
+	m.Tolerance = 1500 * time.Millisecond
+	assert.True(t, m.Equal(local, local.Add(time.Hour-3*time.Second)))
+}
+
+// skewedFs keeps even seconds only, like FAT, and reports timestamps an hour
+// ahead, like a server mistaking its local time for UTC.
+type skewedFs struct {
+	apis.FullFs
+}
+
+type skewedInfo struct {
+	fs.FileInfo
+}
+
+func (fi skewedInfo) ModTime() time.Time { return fi.FileInfo.ModTime().Add(time.Hour) }
+
+func (s skewedFs) Stat(name string) (fs.FileInfo, error) {
+	fi, err := s.FullFs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return skewedInfo{fi}, nil
+}
+
+func (s skewedFs) Chtimes(name string, atime, mtime time.Time) error {
+	return s.FullFs.Chtimes(name, atime.Truncate(2*time.Second), mtime.Truncate(2*time.Second))
+}
+
+func TestClientCalibrateModTimes(t *testing.T) {
+	skipIfWindows(t)
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	m, err := client.CalibrateModTimes(dir)
+	require.NoError(t, err)
+	assert.Equal(t, ModTimeComparer{Granularity: time.Second}, m)
+
+	entries, err := client.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the temporary file is removed")
+
+	client, skewed := clientServerPairFS(t, skewedFs{apis.NewAVFS()}, nil)
+	defer client.Close()
+	defer skewed.Close()
+
+	m, err = client.CalibrateModTimes(dir)
+	require.NoError(t, err)
+	assert.Equal(t, ModTimeComparer{Granularity: 2 * time.Second, Offset: -time.Hour}, m)
+}
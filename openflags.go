@@ -0,0 +1,74 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"os"
+)
+
+// OpenFlag selects open behavior of later protocol versions which package os
+// has no flag for, see OpenFileFlags.
+type OpenFlag uint32
+
+// The flags of OpenFileFlags, which can be combined.
+const (
+	// OpenText opens the file in text mode, in which the server converts
+	// the line endings of the file to and from those of the protocol.
+	// It takes protocol version 4 or later.
+	OpenText OpenFlag = 1 << iota
+
+	// OpenNoFollow fails to open a symbolic link, like O_NOFOLLOW, rather
+	// than opening what it points to. It takes protocol version 6.
+	OpenNoFollow
+
+	// OpenDeleteOnClose removes the file once its last handle is closed,
+	// for scratch files like those of O_TMPFILE. It takes protocol
+	// version 6.
+	OpenDeleteOnClose
+)
+
+// OpenFileFlags is OpenFile with the flags extra of later protocol versions.
+// If the negotiated version lacks one of them, it fails with an error
+// wrapping ErrOpUnsupported without asking the server, see
+// WithProtocolVersion.
+func (c *Client) OpenFileFlags(path string, f int, extra OpenFlag) (*File, error) {
+	pflags := flags(f)
+	if extra&OpenText != 0 {
+		pflags |= sshFxfText
+	}
+	if extra&OpenNoFollow != 0 {
+		pflags |= pflagNoFollow
+	}
+	if extra&OpenDeleteOnClose != 0 {
+		pflags |= pflagDeleteOnClose
+	}
+
+	switch {
+	case extra&^(OpenText|OpenNoFollow|OpenDeleteOnClose) != 0:
+		return nil, &iofs.PathError{Op: "open", Path: path, Err: fmt.Errorf("sftp: unknown open flags %#x", uint32(extra))}
+	case c.version < 4 && extra != 0, c.version < 6 && extra&^OpenText != 0:
+		return nil, &iofs.PathError{Op: "open", Path: path, Err: ErrOpUnsupported}
+	}
+	return c.open(path, pflags)
+}
+
+// CreateExclusive creates the named file mode 0666 (before umask), like
+// Create, but fails with an error wrapping ErrExist if it exists already,
+// like OpenFile with O_EXCL does on os. Servers speaking protocol version 3
+// have no status for that, so a generic failure is told apart from an
+// existing file by stat'ing it.
+func (c *Client) CreateExclusive(path string) (*File, error) {
+	f, err := c.open(path, flags(os.O_RDWR|os.O_CREATE|os.O_EXCL))
+	if err == nil || errors.Is(err, ErrExist) {
+		return f, err
+	}
+
+	var status *StatusError
+	if errors.As(err, &status) && status.Code == sshFxFailure {
+		if _, statErr := c.Lstat(path); statErr == nil {
+			return nil, &iofs.PathError{Op: "open", Path: path, Err: ErrExist}
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,83 @@
+package sftp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientCreateExclusive(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	name := filepath.Join(t.TempDir(), "file")
+	f, err := client.CreateExclusive(name)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// version 3 has no status for it
+	_, err = client.CreateExclusive(name)
+	assert.True(t, errors.Is(err, os.ErrExist), "got %v", err)
+	_, err = client.CreateExclusive(filepath.Join(name, "missing", "file"))
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, os.ErrExist))
+}
+
+func TestClientCreateExclusiveV6(t *testing.T) {
+	client, requests := fakeServer(t, 6, func(typ byte, id uint32) rawPacket {
+		b := marshalUint32([]byte{sshFxpStatus}, id)
+		b = marshalUint32(b, sshFxFileAlreadyExists)
+		return marshalString(marshalString(b, "exists"), "")
+	})
+	defer client.Close()
+
+	_, err := client.CreateExclusive("/file")
+	assert.True(t, errors.Is(err, os.ErrExist), "got %v", err)
+	req := <-requests
+	_, data := unmarshalUint32(req.data)
+	_, data = unmarshalString(data)
+	_, data = unmarshalUint32(data)
+	flags, _ := unmarshalUint32(data)
+	assert.Equal(t, uint32(sshFxfCreateNew), flags)
+}
+
+func TestClientOpenFileFlags(t *testing.T) {
+	openFlags := func(t *testing.T, version uint32, extra OpenFlag) (uint32, error) {
+		client, requests := fakeServer(t, version, func(typ byte, id uint32) rawPacket {
+			return marshalString(marshalUint32([]byte{sshFxpHandle}, id), "h")
+		})
+		defer client.Close()
+
+		if _, err := client.OpenFileFlags("/file", os.O_RDONLY, extra); err != nil {
+			return 0, err
+		}
+		req := <-requests
+		_, data := unmarshalUint32(req.data)
+		_, data = unmarshalString(data)
+		if version >= 5 {
+			_, data = unmarshalUint32(data)
+		}
+		flags, _ := unmarshalUint32(data)
+		return flags, nil
+	}
+
+	flags, err := openFlags(t, 4, OpenText)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(sshFxfRead|sshFxfText), flags)
+
+	flags, err = openFlags(t, 6, OpenText|OpenNoFollow|OpenDeleteOnClose)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(sshFxfOpenExisting|sshFxfTextMode|sshFxfNoFollow|sshFxfDeleteOnClose), flags)
+
+	_, err = openFlags(t, 3, OpenText)
+	assert.True(t, errors.Is(err, ErrOpUnsupported), "got %v", err)
+	_, err = openFlags(t, 5, OpenDeleteOnClose)
+	assert.True(t, errors.Is(err, ErrOpUnsupported), "got %v", err)
+	_, err = openFlags(t, 6, 1<<10)
+	assert.Error(t, err)
+}
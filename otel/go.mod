@@ -0,0 +1,12 @@
+module github.com/pkg/sftp/otel
+
+go 1.15
+
+require (
+	github.com/pkg/sftp v0.0.0-00010101000000-000000000000
+	go.opentelemetry.io/otel v1.7.0
+	go.opentelemetry.io/otel/sdk v1.7.0
+	go.opentelemetry.io/otel/trace v1.7.0
+)
+
+replace github.com/pkg/sftp => ..
@@ -0,0 +1,102 @@
+// Package otel provides an sftp.Tracer which records OpenTelemetry spans.
+//
+//	tracer := otel.NewTracer(provider.Tracer("myapp"))
+//	client, err := sftp.NewClient(conn, sftp.UseTracer(ctx, tracer))
+//
+// The Tracer propagates the trace of a client to the servers of package sftp
+// in the W3C trace context format, so that the spans of both ends of a
+// session make up one trace.
+package otel
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/pkg/sftp"
+)
+
+// Tracer is an sftp.Tracer and sftp.TracePropagator,
+// which starts its spans with an OpenTelemetry tracer.
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TraceContext
+}
+
+// NewTracer returns a Tracer, which starts its spans with tracer.
+func NewTracer(tracer trace.Tracer) *Tracer {
+	return &Tracer{tracer: tracer}
+}
+
+// Start starts a span named name, as a child of the span in ctx.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, sftp.Span) {
+	ctx, span := t.tracer.Start(ctx, name)
+	return ctx, otelSpan{span}
+}
+
+// traceparent is the header of the W3C trace context, which is all
+// a carrier holds.
+const traceparent = "traceparent"
+
+// Inject returns the traceparent header of the span in ctx.
+func (t *Tracer) Inject(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	t.propagator.Inject(ctx, carrier)
+	return carrier.Get(traceparent)
+}
+
+// Extract returns ctx carrying the remote span of the traceparent header
+// in carrier.
+func (t *Tracer) Extract(ctx context.Context, carrier string) context.Context {
+	return t.propagator.Extract(ctx, propagation.MapCarrier{traceparent: carrier})
+}
+
+// otelSpan is an sftp.Span wrapping an OpenTelemetry span.
+type otelSpan struct {
+	span trace.Span
+}
+
+// End sets the attributes of ev on the span, records its error,
+// and ends it. The io.EOF ending reads and listings is not an error.
+func (s otelSpan) End(ev sftp.LogEvent) {
+	var attrs []attribute.KeyValue
+	if ev.Packet != "" {
+		attrs = append(attrs,
+			attribute.String("sftp.packet", ev.Packet),
+			attribute.Int64("sftp.request_id", int64(ev.ID)),
+		)
+	}
+	if ev.Extension != "" {
+		attrs = append(attrs, attribute.String("sftp.extension", ev.Extension))
+	}
+	if ev.Path != "" {
+		attrs = append(attrs, attribute.String("sftp.path", ev.Path))
+	}
+	if ev.Handle != "" {
+		attrs = append(attrs, attribute.String("sftp.handle", ev.Handle))
+	}
+	if ev.Bytes != 0 {
+		attrs = append(attrs, attribute.Int64("sftp.bytes", ev.Bytes))
+	}
+	s.span.SetAttributes(attrs...)
+
+	if ev.Err != nil && !isEOF(ev.Err) {
+		s.span.RecordError(ev.Err)
+		s.span.SetStatus(codes.Error, ev.Err.Error())
+	}
+	s.span.End()
+}
+
+// isEOF reports whether err is io.EOF, or the SSH_FX_EOF status of a server.
+func isEOF(err error) bool {
+	var status *sftp.StatusError
+	if errors.As(err, &status) {
+		return status.FxCode() == sftp.ErrSSHFxEOF
+	}
+	return errors.Is(err, io.EOF)
+}
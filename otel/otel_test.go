@@ -0,0 +1,134 @@
+package otel
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/pkg/sftp"
+	"github.com/pkg/sftp/apis"
+)
+
+var (
+	_ sftp.Tracer          = (*Tracer)(nil)
+	_ sftp.TracePropagator = (*Tracer)(nil)
+)
+
+// ended returns the spans named name, which recorder has seen end.
+func ended(recorder *tracetest.SpanRecorder, name string) []sdktrace.ReadOnlySpan {
+	var spans []sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		if s.Name() == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+// attr returns the value of the attribute key of s.
+func attr(s sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer := NewTracer(provider.Tracer("test"))
+
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw}, apis.NewAVFS(), sftp.WithTracer(context.Background(), tracer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan struct{})
+	go func() {
+		server.Serve()
+		server.Close()
+		close(served)
+	}()
+	client, err := sftp.NewClientPipe(cr, cw, sftp.UseTracer(context.Background(), tracer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(t.TempDir(), "foo")
+	f, err := client.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Stat(name + ".missing"); err == nil {
+		t.Fatal("Stat of a missing file succeeded")
+	}
+
+	client.Close()
+	<-served
+
+	sessions := ended(recorder, "sftp.Client")
+	if len(sessions) != 1 {
+		t.Fatalf("got %d client sessions, want 1", len(sessions))
+	}
+	session := sessions[0].SpanContext()
+
+	opens := ended(recorder, "Client.Open")
+	if len(opens) != 1 {
+		t.Fatalf("got %d Client.Open spans, want 1", len(opens))
+	}
+	if opens[0].Parent().SpanID() != session.SpanID() {
+		t.Error("Client.Open is not a child of the client session")
+	}
+	if got := attr(opens[0], "sftp.path").AsString(); got != name {
+		t.Errorf("sftp.path = %q, want %q", got, name)
+	}
+
+	writes := ended(recorder, "SSH_FXP_WRITE")
+	if len(writes) != 1 {
+		t.Fatalf("got %d SSH_FXP_WRITE spans, want 1", len(writes))
+	}
+	if writes[0].SpanContext().TraceID() != session.TraceID() {
+		t.Error("the server did not join the trace of the client")
+	}
+	if got := attr(writes[0], "sftp.bytes").AsInt64(); got != 5 {
+		t.Errorf("sftp.bytes = %d, want 5", got)
+	}
+
+	stats := ended(recorder, "SSH_FXP_STAT")
+	if len(stats) != 1 {
+		t.Fatalf("got %d SSH_FXP_STAT spans, want 1", len(stats))
+	}
+	if got := stats[0].Status().Code; got != codes.Error {
+		t.Errorf("status of the failed SSH_FXP_STAT = %v, want Error", got)
+	}
+}
+
+func TestEOFIsNoError(t *testing.T) {
+	if !isEOF(io.EOF) {
+		t.Error("io.EOF is an error")
+	}
+	if !isEOF(&sftp.StatusError{Code: uint32(sftp.ErrSSHFxEOF)}) {
+		t.Error("SSH_FX_EOF is an error")
+	}
+	if isEOF(&sftp.StatusError{Code: uint32(sftp.ErrSSHFxFailure)}) {
+		t.Error("SSH_FX_FAILURE is no error")
+	}
+}
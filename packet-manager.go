@@ -4,12 +4,19 @@ import (
 	"encoding"
 	"sort"
 	"sync"
+	"sync/atomic"
 )
 
 // The goal of the packetManager is to keep the outgoing packets in the same
 // order as the incoming as is requires by section 7 of the RFC.
 
 type packetManager struct {
+	// unanswered counts the requests received and not answered yet,
+	// accessed atomically. It comes first to be aligned for that.
+	unanswered int64
+	// draining is set by Shutdown, accessed atomically
+	draining int32
+
 	requests    chan orderedPacket
 	responses   chan orderedPacket
 	fini        chan struct{}
@@ -20,6 +27,11 @@ type packetManager struct {
 	packetCount uint32
 	// it is not nil if the allocator is enabled
 	alloc *allocator
+	// workers serve reads and writes
+	workers int
+	// slots holds a value for every request received and not answered yet,
+	// if their number is limited by WithMaxWorkers.
+	slots chan struct{}
 }
 
 type packetSender interface {
@@ -35,6 +47,7 @@ func newPktMgr(sender packetSender) *packetManager {
 		outgoing:  make([]orderedPacket, 0, SftpServerWorkerCount),
 		sender:    sender,
 		working:   &sync.WaitGroup{},
+		workers:   SftpServerWorkerCount,
 	}
 	go s.controller()
 	return s
@@ -60,6 +73,7 @@ type orderedRequest struct {
 }
 
 func (s *packetManager) newOrderedRequest(p requestPacket) orderedRequest {
+	atomic.AddInt64(&s.unanswered, 1)
 	return orderedRequest{requestPacket: p, orderid: s.newOrderID()}
 }
 func (p orderedRequest) orderID() uint32       { return p.orderid }
@@ -102,6 +116,21 @@ func (s *packetManager) readyPacket(pkt orderedResponse) {
 	s.working.Done()
 }
 
+// acquire waits until another request may be received,
+// if the outstanding requests are limited.
+func (s *packetManager) acquire() {
+	if s.slots != nil {
+		s.slots <- struct{}{}
+	}
+}
+
+// release frees the slot of a request which was answered.
+func (s *packetManager) release() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
 // shut down packetManager controller
 func (s *packetManager) close() {
 	// pause until current packets are processed
@@ -115,8 +144,8 @@ func (s *packetManager) close() {
 func (s *packetManager) workerChan(runWorker func(chan orderedRequest),
 ) chan orderedRequest {
 	// multiple workers for faster read/writes
-	rwChan := make(chan orderedRequest, SftpServerWorkerCount)
-	for i := 0; i < SftpServerWorkerCount; i++ {
+	rwChan := make(chan orderedRequest, s.workers)
+	for i := 0; i < s.workers; i++ {
 		runWorker(rwChan)
 	}
 
@@ -124,7 +153,7 @@ func (s *packetManager) workerChan(runWorker func(chan orderedRequest),
 	cmdChan := make(chan orderedRequest)
 	runWorker(cmdChan)
 
-	pktChan := make(chan orderedRequest, SftpServerWorkerCount)
+	pktChan := make(chan orderedRequest, s.workers)
 	go func() {
 		for pkt := range pktChan {
 			switch pkt.requestPacket.(type) {
@@ -183,9 +212,16 @@ func (s *packetManager) maybeSendPackets() {
 		if in.orderID() == out.orderID() {
 			debug("Sending packet: %v", out.id())
 			s.sender.sendPacket(out.(encoding.BinaryMarshaler))
+			atomic.AddInt64(&s.unanswered, -1)
+			s.release()
 			if s.alloc != nil {
 				// mark for reuse the slices allocated for this request
 				s.alloc.ReleasePages(in.orderID())
+			} else if out, ok := out.(orderedResponse); ok {
+				if data, ok := out.responsePacket.(*sshFxpDataPacket); ok {
+					// the data was read into a page by getDataSlice
+					putPage(data.Data)
+				}
 			}
 			// pop off heads
 			copy(s.incoming, s.incoming[1:])            // shift left
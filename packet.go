@@ -8,7 +8,11 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"math"
 	"reflect"
+	"syscall"
+
+	"github.com/pkg/sftp/apis"
 )
 
 var (
@@ -54,7 +58,11 @@ func marshalFileInfo(b []byte, fi fs.FileInfo) []byte {
 	// 	   so that number of pairs equals extended_count
 
 	flags, fileStat := fileStatFromInfo(fi)
+	return marshalFileStat(b, flags, fileStat)
+}
 
+// marshalFileStat encodes the attributes of fileStat selected by flags.
+func marshalFileStat(b []byte, flags uint32, fileStat *FileStat) []byte {
 	b = marshalUint32(b, flags)
 	if flags&sshFileXferAttrSize != 0 {
 		b = marshalUint64(b, fileStat.Size)
@@ -70,6 +78,13 @@ func marshalFileInfo(b []byte, fi fs.FileInfo) []byte {
 		b = marshalUint32(b, fileStat.Atime)
 		b = marshalUint32(b, fileStat.Mtime)
 	}
+	if flags&sshFileXferAttrExtended != 0 {
+		b = marshalUint32(b, uint32(len(fileStat.Extended)))
+		for _, ext := range fileStat.Extended {
+			b = marshalString(b, ext.ExtType)
+			b = marshalString(b, ext.ExtData)
+		}
+	}
 
 	return b
 }
@@ -159,46 +174,131 @@ func unmarshalStringSafe(b []byte) (string, []byte, error) {
 	return string(b[:n]), b[n:], nil
 }
 
+// toInt64 converts an unsigned offset or size from the wire into the int64
+// used by io.ReaderAt, io.WriterAt and Truncate.
+// Values that do not fit are rejected instead of wrapping around to negative.
+func toInt64(v uint64) (int64, error) {
+	if v > math.MaxInt64 {
+		return 0, syscall.EINVAL
+	}
+	return int64(v), nil
+}
+
 func unmarshalAttrs(b []byte) (*FileStat, []byte) {
 	flags, b := unmarshalUint32(b)
 	return unmarshalFileStat(flags, b)
 }
 
+// skipString skips the string at the start of b, without copying it.
+func skipString(b []byte) []byte {
+	n, b, err := unmarshalUint32Safe(b)
+	if err != nil || int64(n) > int64(len(b)) {
+		return nil
+	}
+	return b[n:]
+}
+
+// skipAttrs skips the version 3 attributes at the start of b, like
+// unmarshalAttrs without decoding them.
+func skipAttrs(b []byte) []byte {
+	flags, b, err := unmarshalUint32Safe(b)
+	if err != nil {
+		return nil
+	}
+	n := 0
+	if flags&sshFileXferAttrSize != 0 {
+		n += 8
+	}
+	if flags&sshFileXferAttrUIDGID != 0 {
+		n += 8
+	}
+	if flags&sshFileXferAttrPermissions != 0 {
+		n += 4
+	}
+	if flags&sshFileXferAttrACmodTime != 0 {
+		n += 8
+	}
+	if len(b) < n {
+		return nil
+	}
+	b = b[n:]
+	if flags&sshFileXferAttrExtended != 0 {
+		count, rest, err := unmarshalUint32Safe(b)
+		if err != nil {
+			return nil
+		}
+		b = rest
+		for i := uint32(0); i < count && b != nil; i++ {
+			b = skipString(skipString(b))
+		}
+	}
+	return b
+}
+
 func unmarshalFileStat(flags uint32, b []byte) (*FileStat, []byte) {
+	fs, b, _ := unmarshalFileStatSafe(flags, b)
+	return fs, b
+}
+
+// unmarshalFileStatSafe is unmarshalFileStat, but also returns the first error,
+// if the attributes are cut short. Fields past the error are left zero.
+func unmarshalFileStatSafe(flags uint32, b []byte) (*FileStat, []byte, error) {
 	var fs FileStat
+	var firstErr, err error
+	keep := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
 	if flags&sshFileXferAttrSize == sshFileXferAttrSize {
-		fs.Size, b, _ = unmarshalUint64Safe(b)
+		fs.Size, b, err = unmarshalUint64Safe(b)
+		keep(err)
 	}
 	if flags&sshFileXferAttrUIDGID == sshFileXferAttrUIDGID {
-		fs.UID, b, _ = unmarshalUint32Safe(b)
+		fs.UID, b, err = unmarshalUint32Safe(b)
+		keep(err)
 	}
 	if flags&sshFileXferAttrUIDGID == sshFileXferAttrUIDGID {
-		fs.GID, b, _ = unmarshalUint32Safe(b)
+		fs.GID, b, err = unmarshalUint32Safe(b)
+		keep(err)
 	}
 	if flags&sshFileXferAttrPermissions == sshFileXferAttrPermissions {
-		fs.Mode, b, _ = unmarshalUint32Safe(b)
+		fs.Mode, b, err = unmarshalUint32Safe(b)
+		keep(err)
 	}
 	if flags&sshFileXferAttrACmodTime == sshFileXferAttrACmodTime {
-		fs.Atime, b, _ = unmarshalUint32Safe(b)
-		fs.Mtime, b, _ = unmarshalUint32Safe(b)
+		fs.Atime, b, err = unmarshalUint32Safe(b)
+		keep(err)
+		fs.Mtime, b, err = unmarshalUint32Safe(b)
+		keep(err)
 	}
 	if flags&sshFileXferAttrExtended == sshFileXferAttrExtended {
 		var count uint32
-		count, b, _ = unmarshalUint32Safe(b)
+		count, b, err = unmarshalUint32Safe(b)
+		keep(err)
+		if count > uint32(len(b))/8 {
+			// every extension takes at least two empty strings
+			keep(errShortPacket)
+			count = 0
+		}
 		ext := make([]StatExtended, count)
 		for i := uint32(0); i < count; i++ {
 			var typ string
 			var data string
-			typ, b, _ = unmarshalStringSafe(b)
-			data, b, _ = unmarshalStringSafe(b)
+			typ, b, err = unmarshalStringSafe(b)
+			keep(err)
+			data, b, err = unmarshalStringSafe(b)
+			keep(err)
 			ext[i] = StatExtended{
 				ExtType: typ,
 				ExtData: data,
 			}
 		}
 		fs.Extended = ext
+		unmarshalTimes(&fs)
 	}
-	return &fs, b
+	return &fs, b, firstErr
 }
 
 func unmarshalStatus(id uint32, data []byte) error {
@@ -230,13 +330,36 @@ func marshalPacket(m encoding.BinaryMarshaler) (header, payload []byte, err erro
 }
 
 // sendPacket marshals p according to RFC 4234.
+// marshalError is the error of a packet that failed to marshal,
+// which unlike a failed write leaves the connection intact.
+type marshalError struct {
+	err error
+}
+
+func (e *marshalError) Error() string {
+	return "binary marshaller failed: " + e.err.Error()
+}
+
+func (e *marshalError) Unwrap() error {
+	return e.err
+}
+
 func sendPacket(w io.Writer, m encoding.BinaryMarshaler) error {
+	if r, ok := m.(orderedResponse); ok {
+		// the packet manager is done ordering by the time we are sending
+		m = r.responsePacket
+	}
+
 	header, payload, err := marshalPacket(m)
 	if err != nil {
-		return fmt.Errorf("binary marshaller failed: %w", err)
+		return &marshalError{err}
 	}
 
 	length := len(header) + len(payload) - 4 // subtract the uint32(length) from the start
+	fp, fromFile := m.(filePayloader)
+	if fromFile {
+		length += fp.payloadLength()
+	}
 	if debugDumpTxPacketBytes {
 		debug("send packet: %s %d bytes %x%x", fxp(header[4]), length, header[5:], payload)
 	} else if debugDumpTxPacket {
@@ -255,10 +378,37 @@ func sendPacket(w io.Writer, m encoding.BinaryMarshaler) error {
 		}
 	}
 
+	if fromFile {
+		if err := fp.sendPayload(w); err != nil {
+			return &cutShortError{fmt.Errorf("failed to send packet payload: %w", err)}
+		}
+	}
+
 	return nil
 }
 
+// cutShortError is the error of a packet whose payload could not be sent
+// in full after its header, which leaves the peer unable to find the next one.
+type cutShortError struct {
+	err error
+}
+
+func (e *cutShortError) Error() string {
+	return e.err.Error()
+}
+
+func (e *cutShortError) Unwrap() error {
+	return e.err
+}
+
 func recvPacket(r io.Reader, alloc *allocator, orderID uint32) (uint8, []byte, error) {
+	return recvPacketPooled(r, alloc, orderID, false)
+}
+
+// recvPacketPooled is recvPacket, which if pooled reads the data packets into
+// pages of the pagePool, when there is no allocator. The receivers of the data
+// return the page with putPage, once they have copied the data out of it.
+func recvPacketPooled(r io.Reader, alloc *allocator, orderID uint32, pooled bool) (uint8, []byte, error) {
 	var b []byte
 	if alloc != nil {
 		b = alloc.GetPage(orderID)
@@ -277,6 +427,29 @@ func recvPacket(r io.Reader, alloc *allocator, orderID uint32) (uint8, []byte, e
 		debug("recv packet of 0 bytes too short")
 		return 0, nil, errShortPacket
 	}
+	if alloc == nil && pooled {
+		// read the type first, to know whether the rest goes into a page
+		if _, err := io.ReadFull(r, b[:1]); err != nil {
+			debug("recv packet %d bytes: err %v", length, err)
+			return 0, nil, err
+		}
+		typ := b[0]
+		if typ == sshFxpData {
+			b = getPage()[:length-1]
+		} else {
+			b = make([]byte, length-1)
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			debug("recv packet %d bytes: err %v", length, err)
+			return 0, nil, err
+		}
+		if debugDumpRxPacketBytes {
+			debug("recv packet: %s %d bytes %x", fxp(typ), length, b)
+		} else if debugDumpRxPacket {
+			debug("recv packet: %s %d bytes", fxp(typ), length)
+		}
+		return typ, b, nil
+	}
 	if alloc == nil {
 		b = make([]byte, length)
 	}
@@ -662,7 +835,8 @@ type sshFxpOpenPacket struct {
 	ID     uint32
 	Path   string
 	Pflags uint32
-	Flags  uint32 // ignored
+	Flags  uint32 // of the attributes
+	Attrs  []byte // following the flags
 }
 
 func (p *sshFxpOpenPacket) id() uint32 { return p.ID }
@@ -670,7 +844,7 @@ func (p *sshFxpOpenPacket) id() uint32 { return p.ID }
 func (p *sshFxpOpenPacket) MarshalBinary() ([]byte, error) {
 	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
 		4 + len(p.Path) +
-		4 + 4
+		4 + 4 + len(p.Attrs)
 
 	b := make([]byte, 4, l)
 	b = append(b, sshFxpOpen)
@@ -678,6 +852,7 @@ func (p *sshFxpOpenPacket) MarshalBinary() ([]byte, error) {
 	b = marshalString(b, p.Path)
 	b = marshalUint32(b, p.Pflags)
 	b = marshalUint32(b, p.Flags)
+	b = append(b, p.Attrs...)
 
 	return b, nil
 }
@@ -690,9 +865,10 @@ func (p *sshFxpOpenPacket) UnmarshalBinary(b []byte) error {
 		return err
 	} else if p.Pflags, b, err = unmarshalUint32Safe(b); err != nil {
 		return err
-	} else if p.Flags, _, err = unmarshalUint32Safe(b); err != nil {
+	} else if p.Flags, b, err = unmarshalUint32Safe(b); err != nil {
 		return err
 	}
+	p.Attrs = b
 	return nil
 }
 
@@ -750,8 +926,8 @@ func (p *sshFxpReadPacket) getDataSlice(alloc *allocator, orderID uint32) []byte
 		return alloc.GetPage(orderID)[:dataLen]
 	}
 
-	// allocate with extra space for the header
-	return make([]byte, dataLen, dataLen+dataHeaderLen)
+	// so does getPage, the packet manager returns the page once the data is sent
+	return getPage()[:dataLen]
 }
 
 type sshFxpRenamePacket struct {
@@ -864,8 +1040,9 @@ func (p *sshFxpWritePacket) UnmarshalBinary(b []byte) error {
 
 type sshFxpMkdirPacket struct {
 	ID    uint32
-	Flags uint32 // ignored
+	Flags uint32 // of the attributes
 	Path  string
+	Attrs []byte // following the flags
 }
 
 func (p *sshFxpMkdirPacket) id() uint32 { return p.ID }
@@ -873,13 +1050,14 @@ func (p *sshFxpMkdirPacket) id() uint32 { return p.ID }
 func (p *sshFxpMkdirPacket) MarshalBinary() ([]byte, error) {
 	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
 		4 + len(p.Path) +
-		4 // uint32
+		4 + len(p.Attrs) // uint32 + attributes
 
 	b := make([]byte, 4, l)
 	b = append(b, sshFxpMkdir)
 	b = marshalUint32(b, p.ID)
 	b = marshalString(b, p.Path)
 	b = marshalUint32(b, p.Flags)
+	b = append(b, p.Attrs...)
 
 	return b, nil
 }
@@ -890,9 +1068,10 @@ func (p *sshFxpMkdirPacket) UnmarshalBinary(b []byte) error {
 		return err
 	} else if p.Path, b, err = unmarshalStringSafe(b); err != nil {
 		return err
-	} else if p.Flags, _, err = unmarshalUint32Safe(b); err != nil {
+	} else if p.Flags, b, err = unmarshalUint32Safe(b); err != nil {
 		return err
 	}
+	p.Attrs = b
 	return nil
 }
 
@@ -1001,6 +1180,8 @@ func (p *sshFxpHandlePacket) MarshalBinary() ([]byte, error) {
 type sshFxpStatusPacket struct {
 	ID uint32
 	StatusError
+
+	err error // the status is made of, see WithErrorTranslator
 }
 
 func (p *sshFxpStatusPacket) MarshalBinary() ([]byte, error) {
@@ -1150,127 +1331,1009 @@ func (p *sshFxpFsyncPacket) MarshalBinary() ([]byte, error) {
 	return b, nil
 }
 
-type sshFxpExtendedPacket struct {
-	ID              uint32
-	ExtendedRequest string
-	SpecificPacket  interface {
-		serverRespondablePacket
-		readonly() bool
-	}
+type sshFxpCheckFilePacket struct {
+	ID         uint32
+	Handle     string // used with check-file-handle
+	Path       string // used with check-file-name
+	Algorithms string
+	Offset     uint64
+	Length     uint64
+	BlockSize  uint32
 }
 
-func (p *sshFxpExtendedPacket) id() uint32 { return p.ID }
-func (p *sshFxpExtendedPacket) readonly() bool {
-	if p.SpecificPacket == nil {
-		return true
+func (p *sshFxpCheckFilePacket) id() uint32 { return p.ID }
+
+func (p *sshFxpCheckFilePacket) MarshalBinary() ([]byte, error) {
+	ext, target := "check-file-name", p.Path
+	if p.Handle != "" {
+		ext, target = "check-file-handle", p.Handle
 	}
-	return p.SpecificPacket.readonly()
+
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(ext) +
+		4 + len(target) +
+		4 + len(p.Algorithms) +
+		8 + 8 + 4 // uint64 + uint64 + uint32
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, ext)
+	b = marshalString(b, target)
+	b = marshalString(b, p.Algorithms)
+	b = marshalUint64(b, p.Offset)
+	b = marshalUint64(b, p.Length)
+	b = marshalUint32(b, p.BlockSize)
+
+	return b, nil
 }
 
-func (p *sshFxpExtendedPacket) respond(svr *Server) responsePacket {
-	if p.SpecificPacket == nil {
-		return statusFromError(p.ID, nil)
-	}
-	return p.SpecificPacket.respond(svr)
+// sshFxpBlockPacket is SSH_FXP_BLOCK of protocol version 6,
+// see asExtension for older versions.
+type sshFxpBlockPacket struct {
+	ID       uint32
+	Handle   string
+	Offset   uint64
+	Length   uint64
+	LockMask uint32
 }
 
-func (p *sshFxpExtendedPacket) UnmarshalBinary(b []byte) error {
-	var err error
-	bOrig := b
-	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
-		return err
-	} else if p.ExtendedRequest, _, err = unmarshalStringSafe(b); err != nil {
-		return err
-	}
+func (p *sshFxpBlockPacket) id() uint32 { return p.ID }
 
-	// specific unmarshalling
-	switch p.ExtendedRequest {
-	case "statvfs@openssh.com":
-		p.SpecificPacket = &sshFxpExtendedPacketStatVFS{}
-	case "posix-rename@openssh.com":
-		p.SpecificPacket = &sshFxpExtendedPacketPosixRename{}
-	case "hardlink@openssh.com":
-		p.SpecificPacket = &sshFxpExtendedPacketHardlink{}
-	default:
-		return fmt.Errorf("packet type %v: %w", p.SpecificPacket, errUnknownExtendedPacket)
-	}
+func (p *sshFxpBlockPacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(p.Handle) +
+		8 + 8 + 4 // uint64 + uint64 + uint32
 
-	return p.SpecificPacket.UnmarshalBinary(bOrig)
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpBlock)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, p.Handle)
+	b = marshalUint64(b, p.Offset)
+	b = marshalUint64(b, p.Length)
+	b = marshalUint32(b, p.LockMask)
+
+	return b, nil
 }
 
-type sshFxpExtendedPacketStatVFS struct {
-	ID              uint32
-	ExtendedRequest string
-	Path            string
+// sshFxpUnblockPacket is SSH_FXP_UNBLOCK of protocol version 6,
+// see asExtension for older versions.
+type sshFxpUnblockPacket struct {
+	ID     uint32
+	Handle string
+	Offset uint64
+	Length uint64
 }
 
-func (p *sshFxpExtendedPacketStatVFS) id() uint32     { return p.ID }
-func (p *sshFxpExtendedPacketStatVFS) readonly() bool { return true }
-func (p *sshFxpExtendedPacketStatVFS) UnmarshalBinary(b []byte) error {
-	var err error
-	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
-		return err
-	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
-		return err
-	} else if p.Path, _, err = unmarshalStringSafe(b); err != nil {
-		return err
-	}
-	return nil
+func (p *sshFxpUnblockPacket) id() uint32 { return p.ID }
+
+func (p *sshFxpUnblockPacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(p.Handle) +
+		8 + 8 // uint64 + uint64
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpUnblock)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, p.Handle)
+	b = marshalUint64(b, p.Offset)
+	b = marshalUint64(b, p.Length)
+
+	return b, nil
 }
 
-type sshFxpExtendedPacketPosixRename struct {
-	ID              uint32
-	ExtendedRequest string
-	Oldpath         string
-	Newpath         string
+type sshFxpDirStatsPacket struct {
+	ID   uint32
+	Path string
 }
 
-func (p *sshFxpExtendedPacketPosixRename) id() uint32     { return p.ID }
-func (p *sshFxpExtendedPacketPosixRename) readonly() bool { return false }
-func (p *sshFxpExtendedPacketPosixRename) UnmarshalBinary(b []byte) error {
-	var err error
-	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
-		return err
-	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
-		return err
-	} else if p.Oldpath, b, err = unmarshalStringSafe(b); err != nil {
-		return err
-	} else if p.Newpath, _, err = unmarshalStringSafe(b); err != nil {
-		return err
-	}
-	return nil
+func (p *sshFxpDirStatsPacket) id() uint32 { return p.ID }
+
+func (p *sshFxpDirStatsPacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(dirStatsExtension) +
+		4 + len(p.Path)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, dirStatsExtension)
+	b = marshalString(b, p.Path)
+
+	return b, nil
 }
 
-func (p *sshFxpExtendedPacketPosixRename) respond(s *Server) responsePacket {
-	err := s.fs.Rename(p.Oldpath, p.Newpath)
-	return statusFromError(p.ID, err)
+type sshFxpDiskUsagePacket struct {
+	ID   uint32
+	Path string
 }
 
-type sshFxpExtendedPacketHardlink struct {
-	ID              uint32
-	ExtendedRequest string
-	Oldpath         string
-	Newpath         string
+func (p *sshFxpDiskUsagePacket) id() uint32 { return p.ID }
+
+func (p *sshFxpDiskUsagePacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(diskUsageExtension) +
+		4 + len(p.Path)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, diskUsageExtension)
+	b = marshalString(b, p.Path)
+
+	return b, nil
 }
 
-// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL
-func (p *sshFxpExtendedPacketHardlink) id() uint32     { return p.ID }
-func (p *sshFxpExtendedPacketHardlink) readonly() bool { return true }
-func (p *sshFxpExtendedPacketHardlink) UnmarshalBinary(b []byte) error {
-	var err error
-	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
-		return err
-	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
-		return err
-	} else if p.Oldpath, b, err = unmarshalStringSafe(b); err != nil {
-		return err
-	} else if p.Newpath, _, err = unmarshalStringSafe(b); err != nil {
-		return err
-	}
-	return nil
+type sshFxpGlobPacket struct {
+	ID      uint32
+	Pattern string
+	Flags   uint32
 }
 
-func (p *sshFxpExtendedPacketHardlink) respond(s *Server) responsePacket {
-	err := s.fs.Link(p.Oldpath, p.Newpath)
-	return statusFromError(p.ID, err)
+func (p *sshFxpGlobPacket) id() uint32 { return p.ID }
+
+func (p *sshFxpGlobPacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(globExtension) +
+		4 + len(p.Pattern) +
+		4
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, globExtension)
+	b = marshalString(b, p.Pattern)
+	b = marshalUint32(b, p.Flags)
+
+	return b, nil
+}
+
+type sshFxpSpaceAvailablePacket struct {
+	ID   uint32
+	Path string
+}
+
+func (p *sshFxpSpaceAvailablePacket) id() uint32 { return p.ID }
+
+func (p *sshFxpSpaceAvailablePacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(spaceAvailableExtension) +
+		4 + len(p.Path)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, spaceAvailableExtension)
+	b = marshalString(b, p.Path)
+
+	return b, nil
+}
+
+type sshFxpAllocatePacket struct {
+	ID     uint32
+	Handle string
+	Offset uint64
+	Length uint64
+}
+
+func (p *sshFxpAllocatePacket) id() uint32 { return p.ID }
+
+func (p *sshFxpAllocatePacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(allocateExtension) +
+		4 + len(p.Handle) +
+		8 + 8 // uint64(offset) + uint64(length)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, allocateExtension)
+	b = marshalString(b, p.Handle)
+	b = marshalUint64(b, p.Offset)
+	b = marshalUint64(b, p.Length)
+
+	return b, nil
+}
+
+type sshFxpAccessPacket struct {
+	ID   uint32
+	Path string
+	Mode uint32
+}
+
+func (p *sshFxpAccessPacket) id() uint32 { return p.ID }
+
+func (p *sshFxpAccessPacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(accessExtension) +
+		4 + len(p.Path) +
+		4 // uint32(mode)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, accessExtension)
+	b = marshalString(b, p.Path)
+	b = marshalUint32(b, p.Mode)
+
+	return b, nil
+}
+
+type sshFxpGetACLPacket struct {
+	ID   uint32
+	Path string
+}
+
+func (p *sshFxpGetACLPacket) id() uint32 { return p.ID }
+
+func (p *sshFxpGetACLPacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(getACLExtension) +
+		4 + len(p.Path)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, getACLExtension)
+	b = marshalString(b, p.Path)
+
+	return b, nil
+}
+
+type sshFxpSetACLPacket struct {
+	ID   uint32
+	Path string
+	ACL  []ACE
+}
+
+func (p *sshFxpSetACLPacket) id() uint32 { return p.ID }
+
+func (p *sshFxpSetACLPacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(setACLExtension) +
+		4 + len(p.Path) +
+		4 // uint32(count)
+	for _, ace := range p.ACL {
+		l += 3*4 + 4 + len(ace.Who)
+	}
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, setACLExtension)
+	b = marshalString(b, p.Path)
+	b = marshalACL(b, 4, 0, p.ACL)
+
+	return b, nil
+}
+
+type sshFxpExpandPathPacket struct {
+	ID   uint32
+	Path string
+}
+
+func (p *sshFxpExpandPathPacket) id() uint32 { return p.ID }
+
+func (p *sshFxpExpandPathPacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(expandPathExtension) +
+		4 + len(p.Path)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, expandPathExtension)
+	b = marshalString(b, p.Path)
+
+	return b, nil
+}
+
+type sshFxpUsersGroupsByIDPacket struct {
+	ID   uint32
+	UIDs []uint32
+	GIDs []uint32
+}
+
+func (p *sshFxpUsersGroupsByIDPacket) id() uint32 { return p.ID }
+
+func (p *sshFxpUsersGroupsByIDPacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(usersGroupsByIDExtension) +
+		4 + 4*len(p.UIDs) +
+		4 + 4*len(p.GIDs)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, usersGroupsByIDExtension)
+	for _, ids := range [][]uint32{p.UIDs, p.GIDs} {
+		b = marshalUint32(b, uint32(4*len(ids)))
+		for _, id := range ids {
+			b = marshalUint32(b, id)
+		}
+	}
+
+	return b, nil
+}
+
+type sshFxpCopyDataPacket struct {
+	ID             uint32
+	ReadFromHandle string
+	ReadFromOffset uint64
+	ReadDataLength uint64
+	WriteToHandle  string
+	WriteToOffset  uint64
+}
+
+func (p *sshFxpCopyDataPacket) id() uint32 { return p.ID }
+
+func (p *sshFxpCopyDataPacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(copyDataExtension) +
+		4 + len(p.ReadFromHandle) +
+		8 + 8 + // uint64 + uint64
+		4 + len(p.WriteToHandle) +
+		8 // uint64
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, copyDataExtension)
+	b = marshalString(b, p.ReadFromHandle)
+	b = marshalUint64(b, p.ReadFromOffset)
+	b = marshalUint64(b, p.ReadDataLength)
+	b = marshalString(b, p.WriteToHandle)
+	b = marshalUint64(b, p.WriteToOffset)
+
+	return b, nil
+}
+
+type sshFxpLimitsPacket struct {
+	ID uint32
+}
+
+func (p *sshFxpLimitsPacket) id() uint32 { return p.ID }
+
+func (p *sshFxpLimitsPacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(limitsExtension)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, limitsExtension)
+
+	return b, nil
+}
+
+type sshFxpCompressionPacket struct {
+	ID        uint32
+	Algorithm string
+}
+
+func (p *sshFxpCompressionPacket) id() uint32 { return p.ID }
+
+func (p *sshFxpCompressionPacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(compressionExtension) +
+		4 + len(p.Algorithm)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, compressionExtension)
+	b = marshalString(b, p.Algorithm)
+
+	return b, nil
+}
+
+// sshFxpCheckFileReply is the SSH_FXP_EXTENDED_REPLY sent for check-file requests.
+type sshFxpCheckFileReply struct {
+	ID        uint32
+	Algorithm string
+	Hashes    []byte // concatenated block hashes
+}
+
+func (p *sshFxpCheckFileReply) id() uint32 { return p.ID }
+
+func (p *sshFxpCheckFileReply) marshalPacket() ([]byte, []byte, error) {
+	const ext = "check-file"
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(ext) +
+		4 + len(p.Algorithm)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtendedReply)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, ext)
+	b = marshalString(b, p.Algorithm)
+
+	return b, p.Hashes, nil
+}
+
+func (p *sshFxpCheckFileReply) MarshalBinary() ([]byte, error) {
+	header, payload, err := p.marshalPacket()
+	return append(header, payload...), err
+}
+
+type sshFxpExtendedPacket struct {
+	ID              uint32
+	ExtendedRequest string
+	SpecificPacket  interface {
+		serverRespondablePacket
+		readonly() bool
+	}
+}
+
+func (p *sshFxpExtendedPacket) id() uint32 { return p.ID }
+func (p *sshFxpExtendedPacket) readonly() bool {
+	if p.SpecificPacket == nil {
+		return true
+	}
+	return p.SpecificPacket.readonly()
+}
+
+func (p *sshFxpExtendedPacket) respond(svr *Server) responsePacket {
+	if p.SpecificPacket == nil {
+		return statusFromError(p.ID, nil)
+	}
+	return p.SpecificPacket.respond(svr)
+}
+
+func (p *sshFxpExtendedPacket) UnmarshalBinary(b []byte) error {
+	var err error
+	bOrig := b
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+
+	// specific unmarshalling
+	switch p.ExtendedRequest {
+	case "statvfs@openssh.com":
+		p.SpecificPacket = &sshFxpExtendedPacketStatVFS{}
+	case "posix-rename@openssh.com":
+		p.SpecificPacket = &sshFxpExtendedPacketPosixRename{}
+	case "hardlink@openssh.com":
+		p.SpecificPacket = &sshFxpExtendedPacketHardlink{}
+	case "fsync@openssh.com":
+		p.SpecificPacket = &sshFxpExtendedPacketFsync{}
+	case "check-file-name", "check-file-handle":
+		p.SpecificPacket = &sshFxpExtendedPacketCheckFile{}
+	case compressionExtension:
+		p.SpecificPacket = &sshFxpExtendedPacketCompression{}
+	case dirStatsExtension:
+		p.SpecificPacket = &sshFxpExtendedPacketDirStats{}
+	case diskUsageExtension:
+		p.SpecificPacket = &sshFxpExtendedPacketDiskUsage{}
+	case globExtension:
+		p.SpecificPacket = &sshFxpExtendedPacketGlob{}
+	case spaceAvailableExtension:
+		p.SpecificPacket = &sshFxpExtendedPacketSpaceAvailable{}
+	case allocateExtension:
+		p.SpecificPacket = &sshFxpExtendedPacketAllocate{}
+	case accessExtension:
+		p.SpecificPacket = &sshFxpExtendedPacketAccess{}
+	case getACLExtension:
+		p.SpecificPacket = &sshFxpExtendedPacketGetACL{}
+	case setACLExtension:
+		p.SpecificPacket = &sshFxpExtendedPacketSetACL{}
+	case limitsExtension:
+		p.SpecificPacket = &sshFxpExtendedPacketLimits{}
+	case expandPathExtension:
+		p.SpecificPacket = &sshFxpExtendedPacketExpandPath{}
+	case usersGroupsByIDExtension:
+		p.SpecificPacket = &sshFxpExtendedPacketUsersGroupsByID{}
+	case copyDataExtension:
+		p.SpecificPacket = &sshFxpExtendedPacketCopyData{}
+	case blockExtension:
+		p.SpecificPacket = &sshFxpExtendedPacketBlock{}
+	case unblockExtension:
+		p.SpecificPacket = &sshFxpExtendedPacketUnblock{}
+	case traceContextExtension:
+		p.SpecificPacket = &sshFxpExtendedPacketTraceContext{}
+	default:
+		return fmt.Errorf("packet type %v: %w", p.SpecificPacket, errUnknownExtendedPacket)
+	}
+
+	return p.SpecificPacket.UnmarshalBinary(bOrig)
+}
+
+type sshFxpExtendedPacketStatVFS struct {
+	ID              uint32
+	ExtendedRequest string
+	Path            string
+}
+
+func (p *sshFxpExtendedPacketStatVFS) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketStatVFS) readonly() bool { return true }
+func (p *sshFxpExtendedPacketStatVFS) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Path, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+type sshFxpExtendedPacketPosixRename struct {
+	ID              uint32
+	ExtendedRequest string
+	Oldpath         string
+	Newpath         string
+}
+
+func (p *sshFxpExtendedPacketPosixRename) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketPosixRename) readonly() bool { return false }
+func (p *sshFxpExtendedPacketPosixRename) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Oldpath, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Newpath, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *sshFxpExtendedPacketPosixRename) respond(s *Server) responsePacket {
+	err := apis.Rename(s.fs, p.Oldpath, p.Newpath)
+	return statusFromError(p.ID, err)
+}
+
+type sshFxpExtendedPacketHardlink struct {
+	ID              uint32
+	ExtendedRequest string
+	Oldpath         string
+	Newpath         string
+}
+
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL
+func (p *sshFxpExtendedPacketHardlink) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketHardlink) readonly() bool { return false }
+func (p *sshFxpExtendedPacketHardlink) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Oldpath, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Newpath, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *sshFxpExtendedPacketHardlink) respond(s *Server) responsePacket {
+	if !s.canWriteLinks() {
+		return statusFromError(p.ID, ErrSSHFxOpUnsupported)
+	}
+	err := apis.Link(s.fs, p.Oldpath, p.Newpath)
+	return statusFromError(p.ID, err)
+}
+
+type sshFxpExtendedPacketFsync struct {
+	ID              uint32
+	ExtendedRequest string
+	Handle          string
+}
+
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL
+func (p *sshFxpExtendedPacketFsync) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketFsync) readonly() bool { return false }
+func (p *sshFxpExtendedPacketFsync) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Handle, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *sshFxpExtendedPacketFsync) respond(s *Server) responsePacket {
+	f, ok := s.getHandle(p.Handle)
+	if !ok {
+		return statusFromError(p.ID, EBADF)
+	}
+	return statusFromError(p.ID, apis.Sync(f))
+}
+
+// https://tools.ietf.org/html/draft-ietf-secsh-filexfer-extensions-00#section-3
+type sshFxpExtendedPacketCheckFile struct {
+	ID              uint32
+	ExtendedRequest string
+	Handle          string // set for check-file-handle
+	Path            string // set for check-file-name
+	Algorithms      string
+	Offset          uint64
+	Length          uint64
+	BlockSize       uint32
+}
+
+func (p *sshFxpExtendedPacketCheckFile) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketCheckFile) readonly() bool { return true }
+func (p *sshFxpExtendedPacketCheckFile) UnmarshalBinary(b []byte) error {
+	var err error
+	var target string
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if target, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Algorithms, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Offset, b, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	} else if p.Length, b, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	} else if p.BlockSize, _, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	}
+
+	if p.ExtendedRequest == "check-file-handle" {
+		p.Handle = target
+	} else {
+		p.Path = target
+	}
+	return nil
+}
+
+type sshFxpExtendedPacketCompression struct {
+	ID              uint32
+	ExtendedRequest string
+	Algorithm       string
+}
+
+func (p *sshFxpExtendedPacketCompression) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketCompression) readonly() bool { return true }
+func (p *sshFxpExtendedPacketCompression) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Algorithm, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+type sshFxpExtendedPacketDirStats struct {
+	ID              uint32
+	ExtendedRequest string
+	Path            string
+}
+
+func (p *sshFxpExtendedPacketDirStats) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketDirStats) readonly() bool { return true }
+func (p *sshFxpExtendedPacketDirStats) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Path, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+type sshFxpExtendedPacketDiskUsage struct {
+	ID              uint32
+	ExtendedRequest string
+	Path            string
+}
+
+func (p *sshFxpExtendedPacketDiskUsage) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketDiskUsage) readonly() bool { return true }
+func (p *sshFxpExtendedPacketDiskUsage) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Path, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+type sshFxpExtendedPacketGlob struct {
+	ID              uint32
+	ExtendedRequest string
+	Pattern         string
+	Flags           uint32
+}
+
+func (p *sshFxpExtendedPacketGlob) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketGlob) readonly() bool { return true }
+func (p *sshFxpExtendedPacketGlob) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Pattern, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Flags, _, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+type sshFxpExtendedPacketSpaceAvailable struct {
+	ID              uint32
+	ExtendedRequest string
+	Path            string
+}
+
+func (p *sshFxpExtendedPacketSpaceAvailable) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketSpaceAvailable) readonly() bool { return true }
+func (p *sshFxpExtendedPacketSpaceAvailable) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Path, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+type sshFxpExtendedPacketAllocate struct {
+	ID              uint32
+	ExtendedRequest string
+	Handle          string
+	Offset          uint64
+	Length          uint64
+}
+
+func (p *sshFxpExtendedPacketAllocate) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketAllocate) readonly() bool { return false }
+func (p *sshFxpExtendedPacketAllocate) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Handle, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Offset, b, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	} else if p.Length, _, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+type sshFxpExtendedPacketAccess struct {
+	ID              uint32
+	ExtendedRequest string
+	Path            string
+	Mode            uint32
+}
+
+func (p *sshFxpExtendedPacketAccess) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketAccess) readonly() bool { return true }
+func (p *sshFxpExtendedPacketAccess) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Path, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Mode, _, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+type sshFxpExtendedPacketGetACL struct {
+	ID              uint32
+	ExtendedRequest string
+	Path            string
+}
+
+func (p *sshFxpExtendedPacketGetACL) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketGetACL) readonly() bool { return true }
+func (p *sshFxpExtendedPacketGetACL) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Path, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+type sshFxpExtendedPacketSetACL struct {
+	ID              uint32
+	ExtendedRequest string
+	Path            string
+	ACL             []ACE
+}
+
+func (p *sshFxpExtendedPacketSetACL) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketSetACL) readonly() bool { return false }
+func (p *sshFxpExtendedPacketSetACL) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Path, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.ACL, _, err = unmarshalACLSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+type sshFxpExtendedPacketLimits struct {
+	ID              uint32
+	ExtendedRequest string
+}
+
+func (p *sshFxpExtendedPacketLimits) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketLimits) readonly() bool { return true }
+func (p *sshFxpExtendedPacketLimits) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+type sshFxpExtendedPacketExpandPath struct {
+	ID              uint32
+	ExtendedRequest string
+	Path            string
+}
+
+func (p *sshFxpExtendedPacketExpandPath) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketExpandPath) readonly() bool { return true }
+func (p *sshFxpExtendedPacketExpandPath) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Path, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+type sshFxpExtendedPacketUsersGroupsByID struct {
+	ID              uint32
+	ExtendedRequest string
+	UIDs            []uint32
+	GIDs            []uint32
+}
+
+func (p *sshFxpExtendedPacketUsersGroupsByID) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketUsersGroupsByID) readonly() bool { return true }
+func (p *sshFxpExtendedPacketUsersGroupsByID) UnmarshalBinary(b []byte) error {
+	var err error
+	var uids, gids string
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if uids, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if gids, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+
+	if p.UIDs, err = unmarshalIDList(uids); err != nil {
+		return err
+	}
+	p.GIDs, err = unmarshalIDList(gids)
+	return err
+}
+
+type sshFxpExtendedPacketCopyData struct {
+	ID              uint32
+	ExtendedRequest string
+	ReadFromHandle  string
+	ReadFromOffset  uint64
+	ReadDataLength  uint64
+	WriteToHandle   string
+	WriteToOffset   uint64
+}
+
+func (p *sshFxpExtendedPacketCopyData) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketCopyData) readonly() bool { return false }
+func (p *sshFxpExtendedPacketCopyData) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.ReadFromHandle, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.ReadFromOffset, b, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	} else if p.ReadDataLength, b, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	} else if p.WriteToHandle, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.WriteToOffset, _, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+type sshFxpExtendedPacketBlock struct {
+	ID              uint32
+	ExtendedRequest string
+	Handle          string
+	Offset          uint64
+	Length          uint64
+	LockMask        uint32
+}
+
+func (p *sshFxpExtendedPacketBlock) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketBlock) readonly() bool { return true }
+func (p *sshFxpExtendedPacketBlock) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Handle, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Offset, b, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	} else if p.Length, b, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	} else if p.LockMask, _, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+type sshFxpExtendedPacketUnblock struct {
+	ID              uint32
+	ExtendedRequest string
+	Handle          string
+	Offset          uint64
+	Length          uint64
+}
+
+func (p *sshFxpExtendedPacketUnblock) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketUnblock) readonly() bool { return true }
+func (p *sshFxpExtendedPacketUnblock) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Handle, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Offset, b, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	} else if p.Length, _, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	}
+	return nil
 }
@@ -387,6 +387,24 @@ func TestSendPacket(t *testing.T) {
 				0x0, 0x0, 0x0, 0x0,
 			},
 		},
+		{
+			packet: &sshFxpOpenPacket{
+				ID:     1,
+				Path:   "/foo",
+				Pflags: flags(syscall.O_WRONLY | syscall.O_CREAT),
+				Flags:  sshFileXferAttrPermissions,
+				Attrs:  marshalUint32(nil, 0o600),
+			},
+			want: []byte{
+				0x0, 0x0, 0x0, 0x19,
+				0x3,
+				0x0, 0x0, 0x0, 0x1,
+				0x0, 0x0, 0x0, 0x4, '/', 'f', 'o', 'o',
+				0x0, 0x0, 0x0, 0xa,
+				0x0, 0x0, 0x0, 0x4,
+				0x0, 0x0, 0x1, 0x80,
+			},
+		},
 		{
 			packet: &sshFxpWritePacket{
 				ID:     124,
@@ -0,0 +1,140 @@
+package sftp
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Verb is a class of operations, which a PathRule allows.
+type Verb uint8
+
+// The verbs of the operations, which can be combined.
+const (
+	// VerbRead reads files: OpGet and the check-file extension.
+	VerbRead Verb = 1 << iota
+
+	// VerbList lists directories and reads the attributes of files: OpList,
+	// OpStat, OpLstat, OpReadlink, OpStatVFS, OpDirStats and OpDiskUsage.
+	VerbList
+
+	// VerbWrite creates and changes files and directories: OpPut, OpSetstat,
+	// OpMkdir, OpSymlink and OpLink, and OpRename and OpPosixRename on both
+	// paths. OpOpen needs VerbRead too.
+	VerbWrite
+
+	// VerbDelete removes files and directories: OpRemove and OpRmdir.
+	VerbDelete
+
+	// VerbAll allows every operation.
+	VerbAll = VerbRead | VerbList | VerbWrite | VerbDelete
+)
+
+// PathRule allows the operations of Verbs on the paths matching Pattern.
+//
+// Pattern is an absolute path, which matches itself and everything below,
+// or a pattern of path.Match, which matches the paths it matches and
+// everything below them: "/home/*/public" matches "/home/alice/public/a".
+type PathRule struct {
+	Pattern string
+	Verbs   Verb
+}
+
+// WithPathPolicy restricts the operations of the Server by path, for upload
+// only drop boxes next to published read-only areas, say:
+//
+//	sftp.WithPathPolicy([]sftp.PathRule{
+//		{Pattern: "/srv/sftp/incoming", Verbs: sftp.VerbWrite},
+//		{Pattern: "/srv/sftp/pub", Verbs: sftp.VerbRead | sftp.VerbList},
+//		{Pattern: "/", Verbs: 0},
+//	})
+//
+// The first rule matching the path of an operation decides whether it is
+// allowed, operations on paths which no rule matches are. Denied operations
+// fail with SSH_FX_PERMISSION_DENIED. The patterns match the paths the client
+// sees, below the root directory if set with WithRootDirectory. Only with
+// one are symbolic links resolved before the rules are checked, otherwise
+// a link in a writable area can lead to any other.
+//
+// The rules are checked before the function of WithAccessControl, with the
+// operations described there.
+func WithPathPolicy(rules []PathRule) ServerOption {
+	return func(s *Server) error {
+		for _, rule := range rules {
+			if !path.IsAbs(rule.Pattern) {
+				return fmt.Errorf("sftp: path rule %q is not absolute", rule.Pattern)
+			}
+			if _, err := path.Match(rule.Pattern, "/"); err != nil {
+				return fmt.Errorf("sftp: path rule %q: %w", rule.Pattern, err)
+			}
+		}
+		s.pathPolicy = append([]PathRule(nil), rules...)
+		return nil
+	}
+}
+
+// operationVerbs are the verbs the operations need.
+var operationVerbs = map[Operation]Verb{
+	OpGet:         VerbRead,
+	OpPut:         VerbWrite,
+	OpOpen:        VerbRead | VerbWrite,
+	OpList:        VerbList,
+	OpStat:        VerbList,
+	OpLstat:       VerbList,
+	OpReadlink:    VerbList,
+	OpSetstat:     VerbWrite,
+	OpRemove:      VerbDelete,
+	OpRmdir:       VerbDelete,
+	OpMkdir:       VerbWrite,
+	OpRename:      VerbWrite,
+	OpPosixRename: VerbWrite,
+	OpSymlink:     VerbWrite,
+	OpLink:        VerbWrite,
+	OpStatVFS:     VerbList,
+	OpDirStats:    VerbList,
+	OpDiskUsage:   VerbList,
+}
+
+// checkPathPolicy returns fs.ErrPermission if the path policy of the Server
+// does not allow op on name, a path of its file system.
+func (svr *Server) checkPathPolicy(op Operation, name string) error {
+	if svr.pathPolicy == nil {
+		return nil
+	}
+
+	p := filepath.ToSlash(name)
+	if svr.root != nil {
+		rel, err := filepath.Rel(svr.root.path, name)
+		if err != nil || strings.HasPrefix(filepath.ToSlash(rel), "../") || rel == ".." {
+			return &fs.PathError{Op: string(op), Path: name, Err: fs.ErrPermission}
+		}
+		p = path.Join("/", filepath.ToSlash(rel))
+	}
+
+	for _, rule := range svr.pathPolicy {
+		if !matchPathRule(rule.Pattern, p) {
+			continue
+		}
+		if need := operationVerbs[op]; rule.Verbs&need != need {
+			return &fs.PathError{Op: string(op), Path: name, Err: fs.ErrPermission}
+		}
+		return nil
+	}
+	return nil
+}
+
+// matchPathRule reports whether pattern matches p or any of its parents.
+func matchPathRule(pattern, p string) bool {
+	p = path.Clean(p)
+	for {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+		if p == "/" || p == "." {
+			return false
+		}
+		p = path.Dir(p)
+	}
+}
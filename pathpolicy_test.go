@@ -0,0 +1,85 @@
+package sftp
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchPathRule(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/pub", "/pub", true},
+		{"/pub", "/pub/a/b", true},
+		{"/pub", "/public", false},
+		{"/", "/anything", true},
+		{"/home/*/public", "/home/alice/public/a", true},
+		{"/home/*/public", "/home/alice/private", false},
+		{"/*.txt", "/notes.txt", true},
+	} {
+		assert.Equal(t, tt.want, matchPathRule(tt.pattern, tt.path), "%s %s", tt.pattern, tt.path)
+	}
+}
+
+func TestServerPathPolicy(t *testing.T) {
+	skipIfWindows(t)
+	skipIfPlan9(t)
+
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "incoming"), 0o755))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "pub"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "pub", "readme"), []byte("hello"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "secret"), []byte("secret"), 0o644))
+
+	_, err := NewServer(nil, apis.NewAVFS(), WithPathPolicy([]PathRule{{Pattern: "pub"}}))
+	assert.Error(t, err, "relative pattern")
+
+	client, server := clientServerPair(t, WithRootDirectory(root), WithPathPolicy([]PathRule{
+		{Pattern: "/incoming", Verbs: VerbWrite},
+		{Pattern: "/pub", Verbs: VerbRead | VerbList},
+		{Pattern: "/", Verbs: VerbList},
+	}))
+	defer client.Close()
+	defer server.Close()
+
+	// the drop box takes uploads, and keeps them
+	f, err := client.OpenFile("/incoming/upload", os.O_WRONLY|os.O_CREATE)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = client.Open("/incoming/upload")
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	_, err = client.ReadDir("/incoming")
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	assert.ErrorIs(t, client.Remove("/incoming/upload"), fs.ErrPermission)
+
+	// the published area is read-only
+	f, err = client.Open("/pub/readme")
+	require.NoError(t, err)
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	require.NoError(t, f.Close())
+	_, err = client.ReadDir("/pub")
+	assert.NoError(t, err)
+	_, err = client.Create("/pub/new")
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	assert.ErrorIs(t, client.Rename("/incoming/upload", "/pub/upload"), fs.ErrPermission)
+
+	// everything else may be listed only
+	_, err = client.Stat("/secret")
+	assert.NoError(t, err)
+	_, err = client.Open("/secret")
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	assert.ErrorIs(t, client.Mkdir("/dir"), fs.ErrPermission)
+}
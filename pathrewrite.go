@@ -0,0 +1,206 @@
+package sftp
+
+import (
+	"path"
+	"strings"
+)
+
+// WithPathRewrite has the Client pass every path it sends to the server
+// through rewrite, and every path the server returns, from RealPath, Getwd,
+// ExpandPath and ReadLink, through inverse. Applications can so mount a
+// logical namespace onto the layout of a server, like "incoming/..." onto
+// "/srv/sftp/upload/...", without joining paths everywhere. See PathPrefix
+// for that case.
+//
+// The targets of symbolic links are rewritten only if they are absolute,
+// as relative ones are relative to the link. A nil inverse passes returned
+// paths unchanged.
+func WithPathRewrite(rewrite, inverse func(string) string) ClientOption {
+	return func(c *Client) error {
+		c.paths = &pathRewrite{to: rewrite, from: inverse}
+		return nil
+	}
+}
+
+// PathPrefix returns functions for WithPathRewrite, which map logical and
+// the paths below it to remote and the same paths below it, and back.
+// Other paths are left alone.
+//
+//	sftp.NewClient(conn, sftp.WithPathRewrite(sftp.PathPrefix("incoming", "/srv/sftp/upload")))
+func PathPrefix(logical, remote string) (rewrite, inverse func(string) string) {
+	return prefixMapper(path.Clean(logical), path.Clean(remote)),
+		prefixMapper(path.Clean(remote), path.Clean(logical))
+}
+
+// prefixMapper returns a function replacing the prefix from of paths with to.
+func prefixMapper(from, to string) func(string) string {
+	return func(p string) string {
+		clean := path.Clean(p)
+		switch {
+		case clean == from:
+			return to
+		case from == "/" && path.IsAbs(clean):
+			return path.Join(to, clean[1:])
+		case from == "." && !path.IsAbs(clean) && clean != ".." && !strings.HasPrefix(clean, "../"):
+			return path.Join(to, clean)
+		case strings.HasPrefix(clean, from+"/"):
+			return path.Join(to, clean[len(from)+1:])
+		}
+		return p
+	}
+}
+
+// pathRewrite maps the paths of requests, see WithPathRewrite.
+type pathRewrite struct {
+	to   func(string) string
+	from func(string) string
+}
+
+// returned maps the path p returned by the server.
+// A nil pathRewrite does not change anything.
+func (r *pathRewrite) returned(p string) string {
+	if r == nil || r.from == nil {
+		return p
+	}
+	return r.from(p)
+}
+
+// returnedLink maps the target p of a symbolic link returned by the server.
+func (r *pathRewrite) returnedLink(p string) string {
+	if !path.IsAbs(p) {
+		return p
+	}
+	return r.returned(p)
+}
+
+// link maps the target p of a symbolic link sent to the server.
+func (r *pathRewrite) link(p string) string {
+	if !path.IsAbs(p) {
+		return p
+	}
+	return r.to(p)
+}
+
+// packet returns p with its paths rewritten, leaving p itself alone,
+// as callers may send it again. A nil pathRewrite returns p.
+func (r *pathRewrite) packet(p idmarshaler) idmarshaler {
+	if r == nil || r.to == nil {
+		return p
+	}
+
+	switch p := p.(type) {
+	case trailerPacket:
+		return trailerPacket{r.packet(p.idmarshaler), p.trailer}
+	case asExtension:
+		return asExtension{r.packet(p.idmarshaler), p.name}
+	case *sshFxpOpenPacket:
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	case *sshFxpOpenV5Packet:
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	case *sshFxpOpendirPacket:
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	case *sshFxpLstatPacket:
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	case *sshFxpStatPacket:
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	case *sshFxpSetstatPacket:
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	case *sshFxpRemovePacket:
+		q := *p
+		q.Filename = r.to(p.Filename)
+		return &q
+	case *sshFxpMkdirPacket:
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	case *sshFxpRmdirPacket:
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	case *sshFxpRealpathPacket:
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	case *sshFxpReadlinkPacket:
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	case *sshFxpRenamePacket:
+		q := *p
+		q.Oldpath, q.Newpath = r.to(p.Oldpath), r.to(p.Newpath)
+		return &q
+	case *sshFxpPosixRenamePacket:
+		q := *p
+		q.Oldpath, q.Newpath = r.to(p.Oldpath), r.to(p.Newpath)
+		return &q
+	case *sshFxpHardlinkPacket:
+		q := *p
+		q.Oldpath, q.Newpath = r.to(p.Oldpath), r.to(p.Newpath)
+		return &q
+	case *sshFxpSymlinkPacket:
+		q := *p
+		q.Linkpath, q.Targetpath = r.to(p.Linkpath), r.link(p.Targetpath)
+		return &q
+	case *sshFxpLinkPacket:
+		q := *p
+		q.NewLinkPath = r.to(p.NewLinkPath)
+		if p.Symlink {
+			q.ExistingPath = r.link(p.ExistingPath)
+		} else {
+			q.ExistingPath = r.to(p.ExistingPath)
+		}
+		return &q
+	case *sshFxpStatvfsPacket:
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	case *sshFxpCheckFilePacket:
+		if p.Handle != "" {
+			return p
+		}
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	case *sshFxpDirStatsPacket:
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	case *sshFxpDiskUsagePacket:
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	case *sshFxpSpaceAvailablePacket:
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	case *sshFxpAccessPacket:
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	case *sshFxpGetACLPacket:
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	case *sshFxpSetACLPacket:
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	case *sshFxpExpandPathPacket:
+		q := *p
+		q.Path = r.to(p.Path)
+		return &q
+	}
+	return p
+}
@@ -0,0 +1,84 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathPrefix(t *testing.T) {
+	for _, tt := range []struct {
+		logical, remote string
+		in, out         string
+	}{
+		{"incoming", "/srv/in", "incoming", "/srv/in"},
+		{"incoming", "/srv/in", "incoming/a/b", "/srv/in/a/b"},
+		{"incoming", "/srv/in", "incoming/../x", "incoming/../x"},
+		{"incoming", "/srv/in", "incomingx/a", "incomingx/a"},
+		{"incoming", "/srv/in", "other", "other"},
+		{"/", "/home/user", "/a", "/home/user/a"},
+		{".", "/home/user", "a/b", "/home/user/a/b"},
+		{".", "/home/user", "../a", "../a"},
+		{"/in/", "/srv/in", "/in/a", "/srv/in/a"},
+	} {
+		rewrite, inverse := PathPrefix(tt.logical, tt.remote)
+		assert.Equal(t, tt.out, rewrite(tt.in), "rewrite %q mapping %q to %q", tt.in, tt.logical, tt.remote)
+		if tt.out != tt.in {
+			assert.Equal(t, filepath.ToSlash(filepath.Clean(tt.in)), inverse(tt.out), "inverse %q", tt.out)
+		}
+	}
+}
+
+func TestClientPathRewrite(t *testing.T) {
+	skipIfWindows(t)
+	dir := t.TempDir()
+
+	client, server := clientServerPairWith(t, nil, WithPathRewrite(PathPrefix("/incoming", dir)))
+	defer client.Close()
+	defer server.Close()
+
+	f, err := client.Create("/incoming/foo")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.NoError(t, client.Mkdir("/incoming/sub"))
+	require.NoError(t, client.Rename("/incoming/foo", "/incoming/sub/bar"))
+	b, err := os.ReadFile(filepath.Join(dir, "sub", "bar"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	fi, err := client.Stat("/incoming/sub/bar")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), fi.Size())
+
+	entries, err := client.ReadDir("/incoming/sub")
+	require.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "bar", entries[0].Name())
+	}
+
+	resolved, err := client.RealPath("/incoming/sub/../sub")
+	require.NoError(t, err)
+	assert.Equal(t, "/incoming/sub", resolved)
+
+	require.NoError(t, client.Symlink("/incoming/sub/bar", "/incoming/link"))
+	target, err := os.Readlink(filepath.Join(dir, "link"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "sub", "bar"), target)
+	target, err = client.ReadLink("/incoming/link")
+	require.NoError(t, err)
+	assert.Equal(t, "/incoming/sub/bar", target)
+
+	require.NoError(t, client.Symlink("sub/bar", "/incoming/rel"))
+	target, err = os.Readlink(filepath.Join(dir, "rel"))
+	require.NoError(t, err)
+	assert.Equal(t, "sub/bar", target, "relative targets are kept")
+
+	_, err = client.Stat("/elsewhere")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -1,5 +1,7 @@
 package sftp
 
+import "sync"
+
 // bufPool provides a pool of byte-slices to be reused in various parts of the package.
 // It is safe to use concurrently through a pointer.
 type bufPool struct {
@@ -77,3 +79,29 @@ func (p resChanPool) Put(ch chan result) {
 	default:
 	}
 }
+
+// pagePool holds pages of maxMsgLength bytes for the payloads of data packets,
+// the data responses read by clients and the data served by servers. It is
+// shared by all the clients and servers of the process, so that transfers do
+// not allocate a page per packet, and the pages of closed sessions are reused.
+var pagePool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, maxMsgLength)
+		return &b
+	},
+}
+
+// getPage returns a page of maxMsgLength bytes from the pagePool.
+func getPage() []byte {
+	return (*pagePool.Get().(*[]byte))[:maxMsgLength]
+}
+
+// putPage returns b to the pagePool, if it is a page. The page must not be
+// used anymore, including the slices of it.
+func putPage(b []byte) {
+	if cap(b) != maxMsgLength {
+		return
+	}
+	b = b[:maxMsgLength]
+	pagePool.Put(&b)
+}
@@ -0,0 +1,88 @@
+package sftp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPagePool(t *testing.T) {
+	page := getPage()
+	assert.Len(t, page, maxMsgLength)
+	putPage(page[:10])
+	putPage(make([]byte, 10)) // not a page, dropped
+
+	var buf bytes.Buffer
+	data := make([]byte, 1024)
+	require.NoError(t, sendPacket(&buf, &sshFxpDataPacket{ID: 1, Length: uint32(len(data)), Data: data}))
+	require.NoError(t, sendPacket(&buf, &sshFxpStatusPacket{ID: 2}))
+
+	typ, b, err := recvPacketPooled(&buf, nil, 0, true)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(sshFxpData), typ)
+	assert.Equal(t, maxMsgLength, cap(b), "data packets go into a page")
+	assert.Len(t, b, 4+4+len(data))
+
+	typ, b, err = recvPacketPooled(&buf, nil, 0, true)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(sshFxpStatus), typ)
+	assert.Less(t, cap(b), maxMsgLength)
+}
+
+func BenchmarkRecvDataPacket(b *testing.B) {
+	var packet bytes.Buffer
+	data := make([]byte, 32*1024)
+	require.NoError(b, sendPacket(&packet, &sshFxpDataPacket{ID: 1, Length: uint32(len(data)), Data: data}))
+
+	for _, pooled := range []bool{false, true} {
+		name := "alloc"
+		if pooled {
+			name = "pooled"
+		}
+		b.Run(name, func(b *testing.B) {
+			r := bytes.NewReader(packet.Bytes())
+			b.ReportAllocs()
+			b.SetBytes(int64(packet.Len()))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				r.Reset(packet.Bytes())
+				_, page, err := recvPacketPooled(r, nil, 0, pooled)
+				if err != nil {
+					b.Fatal(err)
+				}
+				putPage(page)
+			}
+		})
+	}
+}
+
+// BenchmarkClientReadAt reads a file through a Server in packets of 32 KiB,
+// the pages of the data are shared between the client and the server.
+func BenchmarkClientReadAt(b *testing.B) {
+	name := filepath.Join(b.TempDir(), "file")
+	require.NoError(b, os.WriteFile(name, make([]byte, 1<<20), 0o644))
+
+	client, server := clientServerPair(b)
+	defer client.Close()
+	defer server.Close()
+
+	f, err := client.Open(name)
+	require.NoError(b, err)
+	defer f.Close()
+
+	buf := make([]byte, 1<<20)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := f.ReadAt(buf, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
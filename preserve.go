@@ -0,0 +1,137 @@
+package sftp
+
+import (
+	iofs "io/fs"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Preserve selects the attributes WithPreserve carries over
+// from the source of a transfer to its destination.
+type Preserve uint8
+
+// The attributes to preserve, which can be combined.
+const (
+	PreserveMode  Preserve = 1 << iota // permissions, including setuid, setgid and sticky
+	PreserveTimes                      // access and modification times
+	PreserveOwner                      // user and group ids
+)
+
+// transferOptions holds the options of the transfer helpers.
+type transferOptions struct {
+	preserve      Preserve
+	throughClient bool
+}
+
+// A TransferOption configures DownloadConcurrent, UploadConcurrent
+// and Client.CopyFile.
+type TransferOption func(*transferOptions)
+
+func newTransferOptions(opts []TransferOption) transferOptions {
+	var o transferOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithPreserve makes a completed transfer give the destination the
+// attributes what of the source, like the -p flag of sftp(1) does. Remote
+// destinations get them with a single setstat request. The owner is only
+// carried over where the source reports numeric ids, and usually takes a
+// privileged server or local process.
+//
+// The times keep their nanoseconds where the protocol allows, see
+// Client.Chtimes.
+func WithPreserve(what Preserve) TransferOption {
+	return func(o *transferOptions) {
+		o.preserve |= what
+	}
+}
+
+// preserveRemote gives the remote file path the attributes what of fi,
+// with a single setstat request.
+func (c *Client) preserveRemote(path string, fi iofs.FileInfo, what Preserve) error {
+	flags, attrs := c.preservedAttrs(fi, what)
+	if flags == 0 {
+		return nil
+	}
+	return c.setstat(path, flags, attrs)
+}
+
+// preservedAttrs returns the attributes of fi selected by what, encoded for
+// a setstat request of the negotiated version, without the file type of
+// version 4 and later, which setstat adds.
+func (c *Client) preservedAttrs(fi iofs.FileInfo, what Preserve) (uint32, []byte) {
+	statFlags, stat := fileStatFromInfo(fi)
+	hasOwner := what&PreserveOwner != 0 && statFlags&sshFileXferAttrUIDGID != 0
+	atime := time.Unix(int64(stat.Atime), int64(stat.AtimeNsec))
+	mtime := fi.ModTime()
+
+	var flags uint32
+	var b []byte
+	if c.version >= 4 {
+		if hasOwner {
+			flags |= sshFileXferAttrOwnerGroup
+			b = marshalString(b, strconv.FormatUint(uint64(stat.UID), 10))
+			b = marshalString(b, strconv.FormatUint(uint64(stat.GID), 10))
+		}
+		if what&PreserveMode != 0 {
+			flags |= sshFileXferAttrPermissions
+			b = marshalUint32(b, toChmodPerm(fi.Mode()))
+		}
+		if what&PreserveTimes != 0 {
+			flags |= sshFileXferAttrAccessTime | sshFileXferAttrModifyTime | sshFileXferAttrSubsecondTimes
+			b = marshalUint32(marshalUint64(b, uint64(atime.Unix())), uint32(atime.Nanosecond()))
+			b = marshalUint32(marshalUint64(b, uint64(mtime.Unix())), uint32(mtime.Nanosecond()))
+		}
+		return flags, b
+	}
+
+	if hasOwner {
+		flags |= sshFileXferAttrUIDGID
+		b = marshalUint32(marshalUint32(b, stat.UID), stat.GID)
+	}
+	if what&PreserveMode != 0 {
+		flags |= sshFileXferAttrPermissions
+		b = marshalUint32(b, toChmodPerm(fi.Mode()))
+	}
+	if what&PreserveTimes != 0 {
+		flags |= sshFileXferAttrACmodTime
+		b = marshalUint32(marshalUint32(b, uint32(atime.Unix())), uint32(mtime.Unix()))
+
+		ext, ok := timesExtended(&FileStat{
+			AtimeNsec: uint32(atime.Nanosecond()),
+			MtimeNsec: uint32(mtime.Nanosecond()),
+		})
+		if _, supported := c.HasExtension(timesExtension); ok && supported {
+			flags |= sshFileXferAttrExtended
+			b = marshalUint32(b, 1)
+			b = marshalString(marshalString(b, ext.ExtType), ext.ExtData)
+		}
+	}
+	return flags, b
+}
+
+// preserveLocal gives the local file f the attributes what of fi,
+// changing the owner first, which may clear the setuid bits.
+func preserveLocal(f *os.File, fi iofs.FileInfo, what Preserve) error {
+	statFlags, stat := fileStatFromInfo(fi)
+	if what&PreserveOwner != 0 && statFlags&sshFileXferAttrUIDGID != 0 {
+		if err := f.Chown(int(stat.UID), int(stat.GID)); err != nil {
+			return err
+		}
+	}
+	if what&PreserveMode != 0 {
+		const mask = iofs.ModePerm | iofs.ModeSetuid | iofs.ModeSetgid | iofs.ModeSticky
+		if err := f.Chmod(fi.Mode() & mask); err != nil {
+			return err
+		}
+	}
+	if what&PreserveTimes != 0 {
+		atime := time.Unix(int64(stat.Atime), int64(stat.AtimeNsec))
+		return os.Chtimes(f.Name(), atime, fi.ModTime())
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+module github.com/pkg/sftp/prometheus
+
+go 1.15
+
+require (
+	github.com/pkg/sftp v0.0.0-00010101000000-000000000000
+	github.com/prometheus/client_golang v1.12.2
+)
+
+replace github.com/pkg/sftp => ..
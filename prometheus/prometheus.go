@@ -0,0 +1,124 @@
+// Package prometheus provides an sftp.MetricsCollector which keeps
+// Prometheus metrics.
+//
+//	c := prometheus.NewCollector("myapp")
+//	registry.MustRegister(c)
+//	server, err := sftp.NewServer(channel, sftp.WithMetrics(c))
+//
+// One Collector may serve any number of clients and servers, which then add
+// up in the same metrics.
+package prometheus
+
+import (
+	"time"
+
+	"github.com/pkg/sftp"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is an sftp.MetricsCollector and a prometheus.Collector.
+// It keeps the metrics, prefixed with the namespace and "sftp":
+//
+//	sftp_requests_total{packet,status}     requests by packet type and status
+//	sftp_request_duration_seconds{packet}  request latencies
+//	sftp_bytes_total{direction}            file contents "read" and "written"
+//	sftp_open_handles                      open file and directory handles
+//	sftp_feature_sessions_total{kind,name} sessions using a "packet" type or "extension"
+//
+// It is an sftp.FeatureUsageCollector, the feature sessions are counted once
+// a server session ends.
+type Collector struct {
+	requests *prom.CounterVec
+	latency  *prom.HistogramVec
+	bytes    *prom.CounterVec
+	handles  prom.Gauge
+	features *prom.CounterVec
+}
+
+// NewCollector returns a Collector with metrics in namespace,
+// which may be empty.
+func NewCollector(namespace string) *Collector {
+	return &Collector{
+		requests: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sftp",
+			Name:      "requests_total",
+			Help:      "SFTP requests by packet type and status.",
+		}, []string{"packet", "status"}),
+		latency: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "sftp",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of SFTP requests by packet type.",
+			Buckets:   prom.ExponentialBuckets(0.0001, 4, 10), // 100µs to 26s
+		}, []string{"packet"}),
+		bytes: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sftp",
+			Name:      "bytes_total",
+			Help:      "File contents read and written by SFTP requests.",
+		}, []string{"direction"}),
+		handles: prom.NewGauge(prom.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "sftp",
+			Name:      "open_handles",
+			Help:      "Open SFTP file and directory handles.",
+		}),
+		features: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sftp",
+			Name:      "feature_sessions_total",
+			Help:      "SFTP server sessions which used a packet type or extension.",
+		}, []string{"kind", "name"}),
+	}
+}
+
+// ObserveRequest implements sftp.MetricsCollector.
+func (c *Collector) ObserveRequest(packet, status string, latency time.Duration) {
+	c.requests.WithLabelValues(packet, status).Inc()
+	c.latency.WithLabelValues(packet).Observe(latency.Seconds())
+}
+
+// AddBytes implements sftp.MetricsCollector.
+func (c *Collector) AddBytes(read, written int64) {
+	if read > 0 {
+		c.bytes.WithLabelValues("read").Add(float64(read))
+	}
+	if written > 0 {
+		c.bytes.WithLabelValues("written").Add(float64(written))
+	}
+}
+
+// AddOpenHandles implements sftp.MetricsCollector.
+func (c *Collector) AddOpenHandles(delta int) {
+	c.handles.Add(float64(delta))
+}
+
+// AddFeatureUsage implements sftp.FeatureUsageCollector.
+func (c *Collector) AddFeatureUsage(u sftp.FeatureUsage) {
+	for name := range u.Packets {
+		c.features.WithLabelValues("packet", name).Inc()
+	}
+	for name := range u.Extensions {
+		c.features.WithLabelValues("extension", name).Inc()
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	c.requests.Describe(ch)
+	c.latency.Describe(ch)
+	c.bytes.Describe(ch)
+	c.handles.Describe(ch)
+	c.features.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	c.requests.Collect(ch)
+	c.latency.Collect(ch)
+	c.bytes.Collect(ch)
+	c.handles.Collect(ch)
+	c.features.Collect(ch)
+}
@@ -0,0 +1,79 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ sftp.FeatureUsageCollector = (*Collector)(nil)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector("test")
+	registry := prom.NewPedanticRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatal(err)
+	}
+
+	c.ObserveRequest("SSH_FXP_READ", "SSH_FX_OK", time.Millisecond)
+	c.ObserveRequest("SSH_FXP_READ", "SSH_FX_OK", time.Millisecond)
+	c.ObserveRequest("SSH_FXP_STAT", "SSH_FX_NO_SUCH_FILE", time.Millisecond)
+	c.AddBytes(10, 0)
+	c.AddBytes(5, 7)
+	c.AddOpenHandles(2)
+	c.AddOpenHandles(-1)
+
+	expected := `
+# HELP test_sftp_bytes_total File contents read and written by SFTP requests.
+# TYPE test_sftp_bytes_total counter
+test_sftp_bytes_total{direction="read"} 15
+test_sftp_bytes_total{direction="written"} 7
+# HELP test_sftp_open_handles Open SFTP file and directory handles.
+# TYPE test_sftp_open_handles gauge
+test_sftp_open_handles 1
+# HELP test_sftp_requests_total SFTP requests by packet type and status.
+# TYPE test_sftp_requests_total counter
+test_sftp_requests_total{packet="SSH_FXP_READ",status="SSH_FX_OK"} 2
+test_sftp_requests_total{packet="SSH_FXP_STAT",status="SSH_FX_NO_SUCH_FILE"} 1
+`
+	err := testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"test_sftp_bytes_total", "test_sftp_open_handles", "test_sftp_requests_total")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if n := testutil.CollectAndCount(c, "test_sftp_request_duration_seconds"); n != 2 {
+		t.Errorf("got %d latency histograms, want 2", n)
+	}
+}
+
+func TestCollectorFeatureUsage(t *testing.T) {
+	c := NewCollector("test")
+	registry := prom.NewPedanticRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		c.AddFeatureUsage(sftp.FeatureUsage{
+			Packets:    map[string]uint64{"SSH_FXP_OPEN": 3},
+			Extensions: map[string]uint64{"statvfs@openssh.com": uint64(i + 1)},
+		})
+	}
+
+	expected := `
+# HELP test_sftp_feature_sessions_total SFTP server sessions which used a packet type or extension.
+# TYPE test_sftp_feature_sessions_total counter
+test_sftp_feature_sessions_total{kind="extension",name="statvfs@openssh.com"} 2
+test_sftp_feature_sessions_total{kind="packet",name="SSH_FXP_OPEN"} 2
+`
+	err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "test_sftp_feature_sessions_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,509 @@
+package sftp
+
+// SFTP protocol versions 4 to 6 support for the Client
+// see https://tools.ietf.org/html/draft-ietf-secsh-filexfer-13
+
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+
+	"github.com/pkg/sftp/apis"
+)
+
+// maxProtocolVersion is the latest protocol version the Client can speak.
+const maxProtocolVersion = 6
+
+// sshFxpLink replaces SSH_FXP_SYMLINK from version 6 onwards.
+const sshFxpLink = 21
+
+// attribute flags from version 4 onwards,
+// SSH_FILEXFER_ATTR_UIDGID and SSH_FILEXFER_ATTR_ACMODTIME are gone.
+const (
+	sshFileXferAttrAccessTime       = 0x00000008
+	sshFileXferAttrCreateTime       = 0x00000010
+	sshFileXferAttrModifyTime       = 0x00000020
+	sshFileXferAttrACL              = 0x00000040
+	sshFileXferAttrOwnerGroup       = 0x00000080
+	sshFileXferAttrSubsecondTimes   = 0x00000100
+	sshFileXferAttrBits             = 0x00000200 // version 5
+	sshFileXferAttrAllocationSize   = 0x00000400 // version 6
+	sshFileXferAttrTextHint         = 0x00000800 // version 6
+	sshFileXferAttrMimeType         = 0x00001000 // version 6
+	sshFileXferAttrLinkCount        = 0x00002000 // version 6
+	sshFileXferAttrUntranslatedName = 0x00004000 // version 6
+	sshFileXferAttrCTime            = 0x00008000 // version 6
+
+	// the attributes requested by Stat, Lstat and Fstat
+	sshFileXferAttrStatV4 = sshFileXferAttrSize | sshFileXferAttrPermissions |
+		sshFileXferAttrAccessTime | sshFileXferAttrCreateTime | sshFileXferAttrModifyTime |
+		sshFileXferAttrACL | sshFileXferAttrOwnerGroup
+)
+
+// file types, which version 4 moved out of the permissions
+const (
+	sshFileXferTypeRegular     = 1
+	sshFileXferTypeDirectory   = 2
+	sshFileXferTypeSymlink     = 3
+	sshFileXferTypeSpecial     = 4
+	sshFileXferTypeUnknown     = 5
+	sshFileXferTypeSocket      = 6
+	sshFileXferTypeCharDevice  = 7
+	sshFileXferTypeBlockDevice = 8
+	sshFileXferTypeFIFO        = 9
+)
+
+// open flags and access mask from version 5 onwards
+const (
+	sshFxfCreateNew        = 0x00000000
+	sshFxfCreateTruncate   = 0x00000001
+	sshFxfOpenExisting     = 0x00000002
+	sshFxfOpenOrCreate     = 0x00000003
+	sshFxfTruncateExisting = 0x00000004
+	sshFxfAppendData       = 0x00000008
+	sshFxfAppendDataAtomic = 0x00000010
+	sshFxfTextMode         = 0x00000020
+	sshFxfNoFollow         = 0x00000400 // version 6
+	sshFxfDeleteOnClose    = 0x00000800 // version 6
+
+	ace4ReadData        = 0x00000001
+	ace4WriteData       = 0x00000002
+	ace4AppendData      = 0x00000004
+	ace4ReadAttributes  = 0x00000080
+	ace4WriteAttributes = 0x00000100
+
+	// the bits of the flags holding one of the dispositions above
+	sshFxfAccessDisposition = 0x00000007
+)
+
+// rename flags from version 5 onwards
+const (
+	sshFxfRenameOverwrite = 0x00000001
+	sshFxfRenameAtomic    = 0x00000002
+)
+
+// ACE is an entry of the access control list reported
+// by servers speaking protocol version 4 or later.
+type ACE = apis.ACE
+
+// acl flags of version 6, which SetACL sets to say the
+// list controls access rather than only auditing it
+const (
+	sfxACLControlIncluded = 0x00000001
+	sfxACLControlPresent  = 0x00000002
+)
+
+// WithProtocolVersion lets the client offer SFTP protocol versions up to
+// version, which has to be between 3 and 6. Servers answer with the version
+// they actually speak, which may be lower down to version 3, and the client
+// follows, see Client.ProtocolVersion.
+//
+// From version 4 onwards owners and groups are names and files report their
+// creation time and access control list, see FileStat. Chown then sends the
+// numeric ids as names, which not every server accepts.
+//
+// Server and RequestServer speak versions up to 6 as well, translating the
+// requests into those of version 3. They refuse the requests version 3 has
+// no equivalent for with SSH_FX_OP_UNSUPPORTED: access control lists and
+// attribute bits in attributes, and opening files in text mode, with byte
+// range locks, without following symlinks or deleting them on close.
+func WithProtocolVersion(version uint32) ClientOption {
+	return func(c *Client) error {
+		if version < sftpProtocolVersion || version > maxProtocolVersion {
+			return fmt.Errorf("sftp: protocol version %d is not between %d and %d", version, sftpProtocolVersion, maxProtocolVersion)
+		}
+		c.maxVersion = version
+		return nil
+	}
+}
+
+// ProtocolVersion returns the SFTP protocol version negotiated with the server.
+func (c *Client) ProtocolVersion() uint32 {
+	return c.version
+}
+
+// trailerPacket appends the fields later protocol versions added to a request.
+type trailerPacket struct {
+	idmarshaler
+	trailer []byte
+}
+
+func (p trailerPacket) MarshalBinary() ([]byte, error) {
+	b, err := p.idmarshaler.MarshalBinary()
+	return append(b, p.trailer...), err
+}
+
+// statPacket adds the requested attributes to a stat request from version 4 onwards.
+func (c *Client) statPacket(p idmarshaler) idmarshaler {
+	if c.version < 4 {
+		return p
+	}
+	return trailerPacket{p, marshalUint32(nil, sshFileXferAttrStatV4)}
+}
+
+// sshFxpOpenV5Packet is SSH_FXP_OPEN from version 5 onwards.
+type sshFxpOpenV5Packet struct {
+	ID            uint32
+	Path          string
+	DesiredAccess uint32
+	Flags         uint32
+	AttrFlags     uint32
+	Attrs         []byte // following the attribute flags, starting with the type
+}
+
+func (p *sshFxpOpenV5Packet) id() uint32 { return p.ID }
+
+func (p *sshFxpOpenV5Packet) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(p.Path) +
+		4 + 4 +
+		4 + len(p.Attrs)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpOpen)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, p.Path)
+	b = marshalUint32(b, p.DesiredAccess)
+	b = marshalUint32(b, p.Flags)
+	b = marshalUint32(b, p.AttrFlags)
+	b = append(b, p.Attrs...)
+
+	return b, nil
+}
+
+// open flags of version 4, and those of version 6 without a place in the
+// flags of earlier versions, which only toOpenV5 puts on the wire
+const (
+	sshFxfText = 0x00000040
+
+	pflagNoFollow      = 0x00000100
+	pflagDeleteOnClose = 0x00000200
+)
+
+// toOpenV5 converts SSH_FXF_* open flags of version 3 and 4 into
+// the desired access and flags of version 5.
+func toOpenV5(pflags uint32) (access, flags uint32) {
+	access = ace4ReadAttributes
+	if pflags&sshFxfRead != 0 {
+		access |= ace4ReadData
+	}
+	if pflags&sshFxfWrite != 0 {
+		access |= ace4WriteData | ace4WriteAttributes
+	}
+	if pflags&sshFxfAppend != 0 {
+		access |= ace4AppendData
+		flags |= sshFxfAppendData
+	}
+	if pflags&sshFxfText != 0 {
+		flags |= sshFxfTextMode
+	}
+	if pflags&pflagNoFollow != 0 {
+		flags |= sshFxfNoFollow
+	}
+	if pflags&pflagDeleteOnClose != 0 {
+		flags |= sshFxfDeleteOnClose
+	}
+
+	switch {
+	case pflags&sshFxfCreat != 0 && pflags&sshFxfExcl != 0:
+		flags |= sshFxfCreateNew
+	case pflags&sshFxfCreat != 0 && pflags&sshFxfTrunc != 0:
+		flags |= sshFxfCreateTruncate
+	case pflags&sshFxfCreat != 0:
+		flags |= sshFxfOpenOrCreate
+	case pflags&sshFxfTrunc != 0:
+		flags |= sshFxfTruncateExisting
+	default:
+		flags |= sshFxfOpenExisting
+	}
+	return access, flags
+}
+
+// openPacket returns the request opening path, along with the attributes
+// attrs flagged by attrFlags, which the server gives a file it creates.
+func (c *Client) openPacket(id uint32, path string, pflags, attrFlags uint32, attrs []byte) idmarshaler {
+	if c.version >= 4 {
+		attrs = append([]byte{sshFileXferTypeRegular}, attrs...)
+	}
+	if c.version >= 5 {
+		access, flags := toOpenV5(pflags)
+		return &sshFxpOpenV5Packet{
+			ID:            id,
+			Path:          path,
+			DesiredAccess: access,
+			Flags:         flags,
+			AttrFlags:     attrFlags,
+			Attrs:         attrs,
+		}
+	}
+	return &sshFxpOpenPacket{
+		ID:     id,
+		Path:   path,
+		Pflags: pflags,
+		Flags:  attrFlags,
+		Attrs:  attrs,
+	}
+}
+
+func (c *Client) mkdirPacket(id uint32, path string) idmarshaler {
+	p := &sshFxpMkdirPacket{
+		ID:   id,
+		Path: path,
+	}
+	if c.version < 4 {
+		return p
+	}
+	return trailerPacket{p, []byte{sshFileXferTypeDirectory}}
+}
+
+func (c *Client) renamePacket(id uint32, oldname, newname string, flags uint32) idmarshaler {
+	p := &sshFxpRenamePacket{
+		ID:      id,
+		Oldpath: oldname,
+		Newpath: newname,
+	}
+	if c.version < 5 {
+		return p
+	}
+	return trailerPacket{p, marshalUint32(nil, flags)}
+}
+
+// sshFxpLinkPacket is SSH_FXP_LINK from version 6 onwards.
+type sshFxpLinkPacket struct {
+	ID           uint32
+	NewLinkPath  string
+	ExistingPath string
+	Symlink      bool
+}
+
+func (p *sshFxpLinkPacket) id() uint32 { return p.ID }
+
+func (p *sshFxpLinkPacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(p.NewLinkPath) +
+		4 + len(p.ExistingPath) +
+		1 // bool
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpLink)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, p.NewLinkPath)
+	b = marshalString(b, p.ExistingPath)
+	if p.Symlink {
+		b = append(b, 1)
+	} else {
+		b = append(b, 0)
+	}
+
+	return b, nil
+}
+
+// attrsV4 puts the file type, which every attribute set
+// has from version 4 onwards, in front of attrs.
+type attrsV4 struct {
+	Type  uint8
+	Attrs interface{}
+}
+
+// setstatAttrs converts the version 3 attributes of a SETSTAT
+// or FSETSTAT into those of the negotiated version.
+func (c *Client) setstatAttrs(flags uint32, attrs interface{}) (uint32, interface{}) {
+	if c.version < 4 {
+		if a, ok := attrs.(acModTimes); ok {
+			return c.acModTimesV3(a)
+		}
+		return flags, attrs
+	}
+	switch a := attrs.(type) {
+	case acModTimes:
+		flags = sshFileXferAttrAccessTime | sshFileXferAttrModifyTime | sshFileXferAttrSubsecondTimes
+		attrs = struct {
+			Atime     uint64
+			AtimeNsec uint32
+			Mtime     uint64
+			MtimeNsec uint32
+		}{uint64(a.Atime.Unix()), uint32(a.Atime.Nanosecond()), uint64(a.Mtime.Unix()), uint32(a.Mtime.Nanosecond())}
+	case uidGID:
+		flags = sshFileXferAttrOwnerGroup
+		attrs = struct {
+			Owner string
+			Group string
+		}{strconv.FormatUint(uint64(a.UID), 10), strconv.FormatUint(uint64(a.GID), 10)}
+	}
+	return flags, attrsV4{sshFileXferTypeUnknown, attrs}
+}
+
+// unmarshalAttrs decodes attributes of the negotiated version.
+func (c *Client) unmarshalAttrs(b []byte) (*FileStat, []byte) {
+	if c.version < 4 {
+		return unmarshalAttrs(b)
+	}
+	return unmarshalAttrsV4(c.version, b)
+}
+
+// fileTypeModes maps version 4 file types to the type bits of the permissions.
+var fileTypeModes = map[uint8]fs.FileMode{
+	sshFileXferTypeRegular:     0,
+	sshFileXferTypeDirectory:   fs.ModeDir,
+	sshFileXferTypeSymlink:     fs.ModeSymlink,
+	sshFileXferTypeSocket:      fs.ModeSocket,
+	sshFileXferTypeCharDevice:  fs.ModeDevice | fs.ModeCharDevice,
+	sshFileXferTypeBlockDevice: fs.ModeDevice,
+	sshFileXferTypeFIFO:        fs.ModeNamedPipe,
+}
+
+func unmarshalAttrsV4(version uint32, b []byte) (*FileStat, []byte) {
+	// attributes of version 4 onwards:
+	// uint32   valid-attribute-flags
+	// byte     type                   always present
+	// uint64   size                   if flag SIZE
+	// uint64   allocation-size        if flag ALLOCATION_SIZE
+	// string   owner                  if flag OWNERGROUP
+	// string   group                  if flag OWNERGROUP
+	// uint32   permissions            if flag PERMISSIONS
+	// int64    atime                  if flag ACCESSTIME
+	// uint32   atime-nseconds         if flag SUBSECOND_TIMES
+	// int64    createtime             if flag CREATETIME
+	// uint32   createtime-nseconds    if flag SUBSECOND_TIMES
+	// int64    mtime                  if flag MODIFYTIME
+	// uint32   mtime-nseconds         if flag SUBSECOND_TIMES
+	// int64    ctime                  if flag CTIME
+	// uint32   ctime-nseconds         if flag SUBSECOND_TIMES
+	// string   acl                    if flag ACL
+	// uint32   attrib-bits            if flag BITS
+	// uint32   attrib-bits-valid      if flag BITS, version 6
+	// byte     text-hint              if flag TEXT_HINT
+	// string   mime-type              if flag MIME_TYPE
+	// uint32   link-count             if flag LINK_COUNT
+	// string   untranslated-name      if flag UNTRANSLATED_NAME
+	// uint32   extended-count         if flag EXTENDED
+	// extension-pair extensions
+
+	var fs FileStat
+	flags, b, _ := unmarshalUint32Safe(b)
+	if len(b) < 1 {
+		return &fs, nil
+	}
+	typ := b[0]
+	b = b[1:]
+
+	timeField := func(b []byte) (uint32, uint32, []byte) {
+		t, b, _ := unmarshalUint64Safe(b)
+		var nsec uint32
+		if flags&sshFileXferAttrSubsecondTimes != 0 {
+			nsec, b, _ = unmarshalUint32Safe(b)
+		}
+		return uint32(t), nsec, b
+	}
+
+	if flags&sshFileXferAttrSize != 0 {
+		fs.Size, b, _ = unmarshalUint64Safe(b)
+	}
+	if flags&sshFileXferAttrAllocationSize != 0 {
+		_, b, _ = unmarshalUint64Safe(b)
+	}
+	if flags&sshFileXferAttrOwnerGroup != 0 {
+		fs.Owner, b, _ = unmarshalStringSafe(b)
+		fs.Group, b, _ = unmarshalStringSafe(b)
+		// keep UID and GID meaningful for servers reporting numeric owners
+		if uid, err := strconv.ParseUint(fs.Owner, 10, 32); err == nil {
+			fs.UID = uint32(uid)
+		}
+		if gid, err := strconv.ParseUint(fs.Group, 10, 32); err == nil {
+			fs.GID = uint32(gid)
+		}
+	}
+	if flags&sshFileXferAttrPermissions != 0 {
+		fs.Mode, b, _ = unmarshalUint32Safe(b)
+	}
+	if mode, ok := fileTypeModes[typ]; ok && fs.Mode&S_IFMT == 0 {
+		fs.Mode |= fromFileMode(mode) & S_IFMT
+	}
+	if flags&sshFileXferAttrAccessTime != 0 {
+		fs.Atime, fs.AtimeNsec, b = timeField(b)
+	}
+	if flags&sshFileXferAttrCreateTime != 0 {
+		fs.CreateTime, fs.CreateTimeNsec, b = timeField(b)
+	}
+	if flags&sshFileXferAttrModifyTime != 0 {
+		fs.Mtime, fs.MtimeNsec, b = timeField(b)
+	}
+	if flags&sshFileXferAttrCTime != 0 {
+		_, _, b = timeField(b)
+	}
+	if flags&sshFileXferAttrACL != 0 {
+		var acl string
+		acl, b, _ = unmarshalStringSafe(b)
+		fs.ACLFlags, fs.ACL = unmarshalACL(version, []byte(acl))
+	}
+	if flags&sshFileXferAttrBits != 0 {
+		_, b, _ = unmarshalUint32Safe(b)
+		if version >= 6 {
+			_, b, _ = unmarshalUint32Safe(b)
+		}
+	}
+	if flags&sshFileXferAttrTextHint != 0 && len(b) > 0 {
+		b = b[1:]
+	}
+	if flags&sshFileXferAttrMimeType != 0 {
+		_, b, _ = unmarshalStringSafe(b)
+	}
+	if flags&sshFileXferAttrLinkCount != 0 {
+		_, b, _ = unmarshalUint32Safe(b)
+	}
+	if flags&sshFileXferAttrUntranslatedName != 0 {
+		_, b, _ = unmarshalStringSafe(b)
+	}
+	if flags&sshFileXferAttrExtended != 0 {
+		var count uint32
+		count, b, _ = unmarshalUint32Safe(b)
+		for i := uint32(0); i < count && len(b) > 0; i++ {
+			var ext StatExtended
+			ext.ExtType, b, _ = unmarshalStringSafe(b)
+			ext.ExtData, b, _ = unmarshalStringSafe(b)
+			fs.Extended = append(fs.Extended, ext)
+		}
+	}
+	return &fs, b
+}
+
+// marshalACL encodes the acl attribute, version 6 puts flags in front.
+func marshalACL(b []byte, version, flags uint32, acl []ACE) []byte {
+	if version >= 6 {
+		b = marshalUint32(b, flags)
+	}
+	b = marshalUint32(b, uint32(len(acl)))
+	for _, ace := range acl {
+		b = marshalUint32(b, ace.Type)
+		b = marshalUint32(b, ace.Flag)
+		b = marshalUint32(b, ace.Mask)
+		b = marshalString(b, ace.Who)
+	}
+	return b
+}
+
+// unmarshalACL decodes the acl attribute, version 6 put flags in front.
+func unmarshalACL(version uint32, b []byte) (uint32, []ACE) {
+	var flags uint32
+	if version >= 6 {
+		flags, b, _ = unmarshalUint32Safe(b)
+	}
+	count, b, _ := unmarshalUint32Safe(b)
+
+	var acl []ACE
+	for i := uint32(0); i < count && len(b) > 0; i++ {
+		var ace ACE
+		var err error
+		if ace.Type, b, err = unmarshalUint32Safe(b); err != nil {
+			break
+		} else if ace.Flag, b, err = unmarshalUint32Safe(b); err != nil {
+			break
+		} else if ace.Mask, b, err = unmarshalUint32Safe(b); err != nil {
+			break
+		} else if ace.Who, b, err = unmarshalStringSafe(b); err != nil {
+			break
+		}
+		acl = append(acl, ace)
+	}
+	return flags, acl
+}
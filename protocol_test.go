@@ -0,0 +1,272 @@
+package sftp
+
+import (
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rawPacket is a packet without its length.
+type rawPacket []byte
+
+func (p rawPacket) MarshalBinary() ([]byte, error) {
+	return append(make([]byte, 4), p...), nil
+}
+
+type recordedPacket struct {
+	typ  byte
+	data []byte
+}
+
+// fakeServer answers the init with version and records all other requests,
+// which are answered by reply.
+func fakeServer(t *testing.T, version uint32, reply func(typ byte, id uint32) rawPacket) (*Client, <-chan recordedPacket) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	svr := &conn{Reader: sr, WriteCloser: sw}
+
+	requests := make(chan recordedPacket, 16)
+	go func() {
+		defer close(requests)
+		defer svr.Close()
+
+		typ, _, err := svr.recvPacket(0)
+		if err != nil || typ != sshFxpInit {
+			return
+		}
+		if err := svr.sendPacket(rawPacket(marshalUint32([]byte{sshFxpVersion}, version))); err != nil {
+			return
+		}
+
+		for {
+			typ, data, err := svr.recvPacket(0)
+			if err != nil {
+				return
+			}
+			requests <- recordedPacket{typ, append([]byte(nil), data...)}
+			id, _ := unmarshalUint32(data)
+			if err := svr.sendPacket(reply(typ, id)); err != nil {
+				return
+			}
+		}
+	}()
+
+	client, err := NewClientPipe(cr, cw, WithProtocolVersion(6))
+	require.NoError(t, err)
+	return client, requests
+}
+
+func statusOK(id uint32) rawPacket {
+	b := marshalUint32([]byte{sshFxpStatus}, id)
+	b = marshalUint32(b, sshFxOk)
+	b = marshalString(b, "")
+	return marshalString(b, "")
+}
+
+func TestWithProtocolVersion(t *testing.T) {
+	_, w := io.Pipe()
+	_, err := NewClientPipe(nil, w, WithProtocolVersion(7))
+	assert.Error(t, err)
+	_, err = NewClientPipe(nil, w, WithProtocolVersion(2))
+	assert.Error(t, err)
+}
+
+func TestClientProtocolDowngrade(t *testing.T) {
+	client, requests := fakeServer(t, 3, func(typ byte, id uint32) rawPacket {
+		return statusOK(id)
+	})
+	defer client.Close()
+
+	assert.Equal(t, uint32(3), client.ProtocolVersion())
+	require.NoError(t, client.Mkdir("dir"))
+
+	// no type byte after the attributes of version 3
+	r := <-requests
+	id, _ := unmarshalUint32(r.data)
+	assert.Equal(t, byte(sshFxpMkdir), r.typ)
+	assert.Equal(t, marshalUint32(marshalString(marshalUint32(nil, id), "dir"), 0), r.data)
+}
+
+func TestClientProtocolTooNew(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	go func() {
+		svr := &conn{Reader: sr, WriteCloser: sw}
+		defer svr.Close()
+		if _, _, err := svr.recvPacket(0); err == nil {
+			svr.sendPacket(rawPacket(marshalUint32([]byte{sshFxpVersion}, 5)))
+		}
+	}()
+
+	_, err := NewClientPipe(cr, cw, WithProtocolVersion(4))
+	assert.Equal(t, &unexpectedVersionErr{4, 5}, err)
+}
+
+func TestClientProtocolV6(t *testing.T) {
+	acl := marshalUint32(nil, 0) // acl-flags
+	acl = marshalUint32(acl, 1)
+	acl = marshalUint32(acl, 0)
+	acl = marshalUint32(acl, 0)
+	acl = marshalUint32(acl, 1)
+	acl = marshalString(acl, "OWNER@")
+
+	client, requests := fakeServer(t, 6, func(typ byte, id uint32) rawPacket {
+		switch typ {
+		case sshFxpStat:
+			b := marshalUint32([]byte{sshFxpAttrs}, id)
+			b = marshalUint32(b, sshFileXferAttrSize|sshFileXferAttrAllocationSize|sshFileXferAttrOwnerGroup|
+				sshFileXferAttrPermissions|sshFileXferAttrModifyTime|sshFileXferAttrSubsecondTimes|sshFileXferAttrACL)
+			b = append(b, sshFileXferTypeDirectory)
+			b = marshalUint64(b, 42)
+			b = marshalUint64(b, 4096)
+			b = marshalString(b, "1000")
+			b = marshalString(b, "staff")
+			b = marshalUint32(b, 0755)
+			b = marshalUint64(b, 1234)
+			b = marshalUint32(b, 5678)
+			return marshalString(b, string(acl))
+		case sshFxpOpen:
+			return marshalString(marshalUint32([]byte{sshFxpHandle}, id), "h")
+		}
+		return statusOK(id)
+	})
+	defer client.Close()
+	assert.Equal(t, uint32(6), client.ProtocolVersion())
+
+	fi, err := client.Stat("/dir")
+	require.NoError(t, err)
+	assert.True(t, fi.IsDir())
+	assert.Equal(t, fs.ModeDir|0755, fi.Mode())
+	assert.Equal(t, int64(42), fi.Size())
+	assert.Equal(t, int64(1234), fi.ModTime().Unix())
+	stat := fi.Sys().(*FileStat)
+	assert.Equal(t, "1000", stat.Owner)
+	assert.Equal(t, uint32(1000), stat.UID)
+	assert.Equal(t, "staff", stat.Group)
+	assert.Equal(t, []ACE{{Mask: 1, Who: "OWNER@"}}, stat.ACL)
+
+	req := <-requests
+	assert.Equal(t, byte(sshFxpStat), req.typ)
+	_, data := unmarshalUint32(req.data)
+	path, data := unmarshalString(data)
+	assert.Equal(t, "/dir", path)
+	assert.Equal(t, marshalUint32(nil, sshFileXferAttrStatV4), data)
+
+	f, err := client.Create("/file")
+	require.NoError(t, err)
+	req = <-requests
+	assert.Equal(t, byte(sshFxpOpen), req.typ)
+	_, data = unmarshalUint32(req.data)
+	path, data = unmarshalString(data)
+	access, data := unmarshalUint32(data)
+	flags, data := unmarshalUint32(data)
+	assert.Equal(t, "/file", path)
+	assert.Equal(t, uint32(ace4ReadData|ace4WriteData|ace4ReadAttributes|ace4WriteAttributes), access)
+	assert.Equal(t, uint32(sshFxfCreateTruncate), flags)
+	assert.Equal(t, []byte{0, 0, 0, 0, sshFileXferTypeRegular}, data)
+
+	require.NoError(t, f.Chmod(0600))
+	req = <-requests
+	assert.Equal(t, byte(sshFxpFsetstat), req.typ)
+	_, data = unmarshalUint32(req.data)
+	_, data = unmarshalString(data)
+	assert.Equal(t, []byte{0, 0, 0, sshFileXferAttrPermissions, sshFileXferTypeUnknown, 0, 0, 1, 0x80}, data)
+
+	require.NoError(t, client.Symlink("/file", "/link"))
+	req = <-requests
+	assert.Equal(t, byte(sshFxpLink), req.typ)
+	_, data = unmarshalUint32(req.data)
+	link, data := unmarshalString(data)
+	target, data := unmarshalString(data)
+	assert.Equal(t, "/link", link)
+	assert.Equal(t, "/file", target)
+	assert.Equal(t, []byte{1}, data)
+
+	require.NoError(t, client.PosixRename("/file", "/moved"))
+	req = <-requests
+	assert.Equal(t, byte(sshFxpRename), req.typ)
+	_, data = unmarshalUint32(req.data)
+	_, data = unmarshalString(data)
+	_, data = unmarshalString(data)
+	assert.Equal(t, marshalUint32(nil, sshFxfRenameOverwrite|sshFxfRenameAtomic), data)
+}
+
+func TestClientWriteFileOpenAttrs(t *testing.T) {
+	for _, tt := range []struct {
+		version uint32
+		attrs   []byte // following the open flags
+	}{
+		{3, []byte{0, 0, 0, sshFileXferAttrPermissions, 0, 0, 1, 0xa0}},
+		{4, []byte{0, 0, 0, sshFileXferAttrPermissions, sshFileXferTypeRegular, 0, 0, 1, 0xa0}},
+		{6, []byte{0, 0, 0, sshFileXferAttrPermissions, sshFileXferTypeRegular, 0, 0, 1, 0xa0}},
+	} {
+		client, requests := fakeServer(t, tt.version, func(typ byte, id uint32) rawPacket {
+			if typ == sshFxpOpen {
+				return marshalString(marshalUint32([]byte{sshFxpHandle}, id), "h")
+			}
+			return statusOK(id)
+		})
+
+		require.NoError(t, client.WriteFile("/file", nil, 0o640))
+
+		// the mode is sent along with the open, without a stat first
+		req := <-requests
+		assert.Equal(t, byte(sshFxpOpen), req.typ, "version %d", tt.version)
+		_, data := unmarshalUint32(req.data)
+		_, data = unmarshalString(data)
+		_, data = unmarshalUint32(data)
+		if tt.version >= 5 {
+			_, data = unmarshalUint32(data) // desired access
+		}
+		assert.Equal(t, tt.attrs, data, "version %d", tt.version)
+
+		client.Close()
+	}
+}
+
+func TestToOpenV5(t *testing.T) {
+	for _, tt := range []struct {
+		pflags uint32
+		access uint32
+		flags  uint32
+	}{
+		{sshFxfRead, ace4ReadAttributes | ace4ReadData, sshFxfOpenExisting},
+		{sshFxfWrite | sshFxfCreat | sshFxfExcl, ace4ReadAttributes | ace4WriteData | ace4WriteAttributes, sshFxfCreateNew},
+		{sshFxfWrite | sshFxfCreat, ace4ReadAttributes | ace4WriteData | ace4WriteAttributes, sshFxfOpenOrCreate},
+		{sshFxfWrite | sshFxfTrunc, ace4ReadAttributes | ace4WriteData | ace4WriteAttributes, sshFxfTruncateExisting},
+		{sshFxfWrite | sshFxfAppend, ace4ReadAttributes | ace4WriteData | ace4WriteAttributes | ace4AppendData, sshFxfOpenExisting | sshFxfAppendData},
+		{sshFxfRead | sshFxfText, ace4ReadAttributes | ace4ReadData, sshFxfOpenExisting | sshFxfTextMode},
+		{sshFxfRead | pflagNoFollow | pflagDeleteOnClose, ace4ReadAttributes | ace4ReadData, sshFxfOpenExisting | sshFxfNoFollow | sshFxfDeleteOnClose},
+	} {
+		access, flags := toOpenV5(tt.pflags)
+		assert.Equal(t, tt.access, access, "pflags %#x", tt.pflags)
+		assert.Equal(t, tt.flags, flags, "pflags %#x", tt.pflags)
+	}
+}
+
+func TestUnmarshalAttrsV4(t *testing.T) {
+	// version 4 has no acl-flags and only some of the flags of version 6
+	b := marshalUint32(nil, sshFileXferAttrAccessTime|sshFileXferAttrCreateTime|sshFileXferAttrExtended)
+	b = append(b, sshFileXferTypeRegular)
+	b = marshalUint64(b, 10)
+	b = marshalUint64(b, 20)
+	b = marshalUint32(b, 1)
+	b = marshalString(b, "foo")
+	b = marshalString(b, "bar")
+	b = append(b, "rest"...)
+
+	stat, rest := unmarshalAttrsV4(4, b)
+	assert.Equal(t, uint32(10), stat.Atime)
+	assert.Equal(t, uint32(20), stat.CreateTime)
+	assert.Equal(t, []StatExtended{{"foo", "bar"}}, stat.Extended)
+	assert.True(t, toFileMode(stat.Mode).IsRegular())
+	assert.Equal(t, "rest", string(rest))
+
+	// truncated attributes do not panic
+	for i := range b {
+		unmarshalAttrsV4(6, b[:i])
+	}
+}
@@ -0,0 +1,108 @@
+package sftp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+)
+
+// maxTempAttempts bounds the temporary names PutAtomic tries, which only
+// collide if someone else picks the same random name.
+const maxTempAttempts = 10
+
+// putAtomicOptions holds the options of Client.PutAtomic.
+type putAtomicOptions struct {
+	perm   *iofs.FileMode
+	noSync bool
+}
+
+// A PutAtomicOption configures Client.PutAtomic.
+type PutAtomicOption func(*putAtomicOptions)
+
+// AtomicPerm gives the file PutAtomic writes the permissions perm, rather
+// than those the server creates files with.
+func AtomicPerm(perm iofs.FileMode) PutAtomicOption {
+	return func(o *putAtomicOptions) {
+		o.perm = &perm
+	}
+}
+
+// AtomicNoSync makes PutAtomic skip the fsync, trading the durability of
+// the file across a crash of the server for speed.
+func AtomicNoSync() PutAtomicOption {
+	return func(o *putAtomicOptions) {
+		o.noSync = true
+	}
+}
+
+// PutAtomic writes what r reads to the remote file path, so that whoever
+// opens path sees either the file before or all of the new one, never a
+// partial one. The data is written with File.ReadFrom to a temporary file
+// next to path, named after it with a random suffix, synced with
+// fsync@openssh.com where the server supports it, and renamed over path
+// with RenameOverwrite, which is atomic where the server supports
+// posix-rename@openssh.com or protocol version 5. The temporary file is
+// removed if anything fails.
+//
+// It returns the number of bytes read from r.
+func (c *Client) PutAtomic(path string, r io.Reader, opts ...PutAtomicOption) (n int64, err error) {
+	defer pathError(&err, "put", path)
+
+	var o putAtomicOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	f, err := c.createTemp(path)
+	if err != nil {
+		return 0, err
+	}
+	tmp := f.Name()
+	defer func() {
+		if err != nil {
+			// best effort, the error is what went wrong before
+			_ = c.Remove(tmp)
+		}
+	}()
+
+	n, err = f.ReadFrom(r)
+	if err == nil && o.perm != nil {
+		err = f.Chmod(*o.perm)
+	}
+	if _, ok := c.HasExtension("fsync@openssh.com"); err == nil && ok && !o.noSync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, err
+	}
+
+	return n, c.RenameOverwrite(tmp, path)
+}
+
+// createTemp exclusively creates a file with a random name next to name,
+// so that no one else writes to it.
+func (c *Client) createTemp(name string) (*File, error) {
+	dir, base := path.Split(name)
+	var err error
+	for i := 0; i < maxTempAttempts; i++ {
+		var suffix [8]byte
+		if _, err := rand.Read(suffix[:]); err != nil {
+			return nil, err
+		}
+		tmp := dir + "." + base + "." + hex.EncodeToString(suffix[:]) + ".tmp"
+
+		var f *File
+		f, err = c.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+		if !errors.Is(err, iofs.ErrExist) {
+			return f, err
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,93 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingReader reads its data, and then fails.
+type failingReader struct {
+	data string
+}
+
+func (r *failingReader) Read(b []byte) (int, error) {
+	if r.data == "" {
+		return 0, errors.New("source broke")
+	}
+	n := copy(b, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestClientPutAtomic(t *testing.T) {
+	skipIfWindows(t)
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "file")
+	n, err := client.PutAtomic(name, strings.NewReader("first"), AtomicPerm(0o640))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	b, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(b))
+	fi, err := os.Stat(name)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), fi.Mode().Perm())
+
+	_, err = client.PutAtomic(name, strings.NewReader("second"), AtomicNoSync())
+	require.NoError(t, err)
+	b, err = os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(b))
+	assert.Equal(t, []string{"file"}, dirNames(t, dir), "no temporary file left")
+
+	// a failed upload leaves the file as it was
+	_, err = client.PutAtomic(name, &failingReader{data: "partial"})
+	assert.Error(t, err)
+	b, err = os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(b))
+	assert.Equal(t, []string{"file"}, dirNames(t, dir), "the temporary file is removed")
+
+	_, err = client.PutAtomic(filepath.Join(dir, "missing", "file"), strings.NewReader(""))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestClientCreateTemp(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	name := filepath.Join(t.TempDir(), "file")
+	f1, err := client.createTemp(name)
+	require.NoError(t, err)
+	defer f1.Close()
+	f2, err := client.createTemp(name)
+	require.NoError(t, err)
+	defer f2.Close()
+
+	assert.NotEqual(t, f1.Name(), f2.Name())
+	assert.True(t, strings.HasPrefix(filepath.Base(f1.Name()), ".file."))
+	_, err = io.WriteString(f1, "x")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,363 @@
+package sftp
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/pkg/sftp/apis"
+)
+
+// ErrQuotaExceeded is the error for operations which would exceed the quota
+// of the user. Clients see it as SSH_FX_FAILURE with its message.
+var ErrQuotaExceeded = errors.New("sftp: quota exceeded")
+
+// QuotaUsage is how much a user stores, and may store.
+type QuotaUsage struct {
+	Bytes int64
+	Files int64 // files, directories and links
+
+	// MaxBytes and MaxFiles are the limits, 0 for none.
+	MaxBytes int64
+	MaxFiles int64
+}
+
+// QuotaManager keeps track of the bytes and files users store, and
+// enforces their quotas. See NewQuotaManager for one kept in memory.
+//
+// The methods of a QuotaManager are called concurrently.
+type QuotaManager interface {
+	// Charge adds bytes and files to the usage of user, or returns
+	// ErrQuotaExceeded without any change if that exceeded the quota.
+	// Negative amounts are given back, which never fails.
+	Charge(user string, bytes, files int64) error
+
+	// Usage returns the usage and limits of user.
+	Usage(user string) QuotaUsage
+}
+
+// NewQuotaManager returns a QuotaManager kept in memory, which allows every
+// user to store maxBytes in maxFiles, 0 for no limit. Users start out with
+// no usage, whatever is stored already.
+func NewQuotaManager(maxBytes, maxFiles int64) QuotaManager {
+	return &memQuota{
+		maxBytes: maxBytes,
+		maxFiles: maxFiles,
+		used:     make(map[string]*QuotaUsage),
+	}
+}
+
+// memQuota is the QuotaManager of NewQuotaManager.
+type memQuota struct {
+	maxBytes, maxFiles int64
+
+	mu   sync.Mutex
+	used map[string]*QuotaUsage
+}
+
+func (q *memQuota) Charge(user string, bytes, files int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	u, ok := q.used[user]
+	if !ok {
+		u = &QuotaUsage{MaxBytes: q.maxBytes, MaxFiles: q.maxFiles}
+		q.used[user] = u
+	}
+	if (bytes > 0 && u.MaxBytes > 0 && u.Bytes+bytes > u.MaxBytes) ||
+		(files > 0 && u.MaxFiles > 0 && u.Files+files > u.MaxFiles) {
+		return ErrQuotaExceeded
+	}
+	u.Bytes += bytes
+	u.Files += files
+	return nil
+}
+
+func (q *memQuota) Usage(user string) QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if u, ok := q.used[user]; ok {
+		return *u
+	}
+	return QuotaUsage{MaxBytes: q.maxBytes, MaxFiles: q.maxFiles}
+}
+
+// WithQuota has the Server charge the files user stores to the quota of m.
+// Writes growing a file, creates, truncates and allocations to larger
+// sizes, mkdir and links which would exceed it fail with ErrQuotaExceeded. Removals,
+// truncates and files replaced by renames give back what they free.
+// Pass a user per session for quotas per session.
+//
+// Only the growth of files is charged: rewriting a file in place is free.
+// Files and directories count alike. Hard links are charged as a file but
+// give back their size when removed, and changes made to the files outside
+// of the session are not tracked.
+//
+// Statvfs requests answer with the usage and limits of the quota, as far as
+// they are lower than those of the file system.
+func WithQuota(m QuotaManager, user string) ServerOption {
+	return func(s *Server) error {
+		s.quota = &quotaTracker{
+			m:       m,
+			user:    user,
+			written: make(map[string]int64),
+		}
+		return nil
+	}
+}
+
+// quotaTracker charges the operations of a Server session to a quota.
+type quotaTracker struct {
+	m    QuotaManager
+	user string
+
+	mu      sync.Mutex
+	written map[string]int64 // the size of the files open for writing, by handle
+}
+
+// quotaCharge is what an operation was charged before it was served,
+// and what it gives back once it has been.
+type quotaCharge struct {
+	bytes, files         int64 // charged
+	freeBytes, freeFiles int64 // given back
+
+	handle    string // whose size is set to size
+	size      int64
+	prevSize  int64 // of writes, to restore if they fail
+	setsSize  bool
+	opensFile bool
+}
+
+// before charges the operation p to the quota, unless that exceeds it.
+func (q *quotaTracker) before(svr *Server, p requestPacket) (quotaCharge, error) {
+	var c quotaCharge
+	switch p := p.(type) {
+	case *sshFxpExtendedPacket:
+		if p.SpecificPacket == nil {
+			return c, nil
+		}
+		return q.before(svr, p.SpecificPacket)
+
+	case *sshFxpOpenPacket:
+		if !p.hasPflags(sshFxfWrite) {
+			return c, nil
+		}
+		c.opensFile = true
+		fi, err := svr.fs.Stat(toLocalPath(p.Path))
+		switch {
+		case err == nil && p.hasPflags(sshFxfTrunc):
+			c.freeBytes = fi.Size()
+		case err == nil:
+			c.size = fi.Size()
+		case p.hasPflags(sshFxfCreat):
+			c.files = 1
+		}
+
+	case *sshFxpWritePacket:
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		written, ok := q.written[p.Handle]
+		end := int64(p.Offset) + int64(p.Length)
+		if !ok || end <= written || end < 0 {
+			return c, nil
+		}
+		c.bytes = end - written
+		if err := q.m.Charge(q.user, c.bytes, 0); err != nil {
+			return quotaCharge{}, err
+		}
+		q.written[p.Handle] = end
+		c.handle, c.size, c.prevSize = p.Handle, end, written
+		return c, nil
+
+	case *sshFxpSetstatPacket:
+		return q.truncate("", p.Flags, p.Attrs, func() (int64, error) {
+			fi, err := svr.fs.Stat(toLocalPath(p.Path))
+			if err != nil {
+				return 0, err
+			}
+			return fi.Size(), nil
+		})
+	case *sshFxpFsetstatPacket:
+		return q.truncate(p.Handle, p.Flags, p.Attrs, func() (int64, error) {
+			f, ok := svr.getHandle(p.Handle)
+			if !ok {
+				return 0, EBADF
+			}
+			fi, err := f.Stat()
+			if err != nil {
+				return 0, err
+			}
+			return fi.Size(), nil
+		})
+
+	case *sshFxpExtendedPacketAllocate:
+		f, ok := svr.getHandle(p.Handle)
+		if !ok {
+			return c, nil
+		}
+		fi, err := f.Stat()
+		end := int64(p.Offset + p.Length)
+		if err != nil || end <= fi.Size() || end < 0 {
+			return c, nil
+		}
+		c.handle, c.size, c.setsSize = p.Handle, end, true
+		c.bytes = end - fi.Size()
+
+	case *sshFxpMkdirPacket, *sshFxpSymlinkPacket, *sshFxpExtendedPacketHardlink:
+		c.files = 1
+
+	case *sshFxpRemovePacket:
+		if fi, err := apis.Lstat(svr.fs, toLocalPath(p.Filename)); err == nil {
+			c.freeFiles = 1
+			if fi.Mode().IsRegular() {
+				c.freeBytes = fi.Size()
+			}
+		}
+		return c, nil
+	case *sshFxpRmdirPacket:
+		c.freeFiles = 1
+		return c, nil
+	case *sshFxpRenamePacket:
+		return q.replaced(svr, c, p.Oldpath, p.Newpath), nil
+	case *sshFxpExtendedPacketPosixRename:
+		return q.replaced(svr, c, p.Oldpath, p.Newpath), nil
+
+	case *sshFxpClosePacket:
+		q.mu.Lock()
+		delete(q.written, p.Handle)
+		q.mu.Unlock()
+		return c, nil
+	}
+
+	if err := q.charge(c.bytes, c.files); err != nil {
+		return quotaCharge{}, err
+	}
+	return c, nil
+}
+
+// truncate charges a setstat with flags and attrs, if it sets the size of
+// the file of the given size, open as handle for fsetstat.
+func (q *quotaTracker) truncate(handle string, flags uint32, attrs interface{}, size func() (int64, error)) (quotaCharge, error) {
+	var c quotaCharge
+	b, ok := attrs.([]byte)
+	if flags&sshFileXferAttrSize == 0 || !ok {
+		return c, nil
+	}
+	newSize, _, err := unmarshalUint64Safe(b)
+	if err != nil || int64(newSize) < 0 {
+		return c, nil
+	}
+	oldSize, err := size()
+	if err != nil {
+		return c, nil
+	}
+
+	c.handle, c.size, c.setsSize = handle, int64(newSize), true
+	if delta := int64(newSize) - oldSize; delta > 0 {
+		c.bytes = delta
+	} else {
+		c.freeBytes = -delta
+	}
+	if err := q.charge(c.bytes, 0); err != nil {
+		return quotaCharge{}, err
+	}
+	return c, nil
+}
+
+// replaced gives back the file at newpath, which a rename from oldpath replaces.
+func (q *quotaTracker) replaced(svr *Server, c quotaCharge, oldpath, newpath string) quotaCharge {
+	if oldpath == newpath {
+		return c
+	}
+	if fi, err := apis.Lstat(svr.fs, toLocalPath(newpath)); err == nil && !fi.IsDir() {
+		c.freeFiles = 1
+		if fi.Mode().IsRegular() {
+			c.freeBytes = fi.Size()
+		}
+	}
+	return c
+}
+
+// charge charges bytes and files, if any.
+func (q *quotaTracker) charge(bytes, files int64) error {
+	if bytes == 0 && files == 0 {
+		return nil
+	}
+	return q.m.Charge(q.user, bytes, files)
+}
+
+// after settles the charge c of an operation, once it has been served
+// with rpkt: the charge is refunded if it failed, and what it freed given
+// back otherwise.
+func (q *quotaTracker) after(rpkt responsePacket, c quotaCharge) {
+	if status, ok := rpkt.(*sshFxpStatusPacket); ok && status.Code != sshFxOk {
+		q.charge(-c.bytes, -c.files)
+		if c.handle != "" && !c.setsSize {
+			q.mu.Lock()
+			if q.written[c.handle] == c.size {
+				q.written[c.handle] = c.prevSize
+			}
+			q.mu.Unlock()
+		}
+		return
+	}
+
+	q.charge(-c.freeBytes, -c.freeFiles)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if h, ok := rpkt.(*sshFxpHandlePacket); ok && c.opensFile {
+		q.written[h.Handle] = c.size
+	}
+	if _, ok := q.written[c.handle]; ok && c.setsSize {
+		q.written[c.handle] = c.size
+	}
+}
+
+// statVFS returns st with the usage and limits of the quota, where they are
+// lower than those of st.
+func (q *quotaTracker) statVFS(st *StatVFS) *StatVFS {
+	u := q.m.Usage(q.user)
+	if u.MaxBytes > 0 && st.Frsize > 0 {
+		var free uint64
+		if u.Bytes < u.MaxBytes {
+			free = uint64(u.MaxBytes-u.Bytes) / st.Frsize
+		}
+		st.Blocks = minUint64(st.Blocks, uint64(u.MaxBytes)/st.Frsize)
+		st.Bfree = minUint64(st.Bfree, free)
+		st.Bavail = minUint64(st.Bavail, free)
+	}
+	if u.MaxFiles > 0 {
+		var free uint64
+		if u.Files < u.MaxFiles {
+			free = uint64(u.MaxFiles - u.Files)
+		}
+		st.Files = minUint64(st.Files, uint64(u.MaxFiles))
+		st.Ffree = minUint64(st.Ffree, free)
+		st.Favail = minUint64(st.Favail, free)
+	}
+	return st
+}
+
+// quotaStatVFS is the statvfs reply of a quota on a file system which has
+// none, the quota limits it.
+func quotaStatVFS() *StatVFS {
+	const unlimited = ^uint64(0)
+	return &StatVFS{
+		Bsize:   4096,
+		Frsize:  4096,
+		Blocks:  unlimited,
+		Bfree:   unlimited,
+		Bavail:  unlimited,
+		Files:   unlimited,
+		Ffree:   unlimited,
+		Favail:  unlimited,
+		Namemax: 255,
+	}
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,69 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemQuota(t *testing.T) {
+	q := NewQuotaManager(100, 2)
+	require.NoError(t, q.Charge("alice", 60, 1))
+	assert.Equal(t, ErrQuotaExceeded, q.Charge("alice", 50, 0))
+	assert.Equal(t, ErrQuotaExceeded, q.Charge("alice", 0, 2))
+	require.NoError(t, q.Charge("bob", 100, 2), "quotas are per user")
+	require.NoError(t, q.Charge("alice", -60, -1))
+	assert.Equal(t, QuotaUsage{MaxBytes: 100, MaxFiles: 2}, q.Usage("alice"))
+	assert.Equal(t, QuotaUsage{Bytes: 100, Files: 2, MaxBytes: 100, MaxFiles: 2}, q.Usage("bob"))
+}
+
+func TestServerQuota(t *testing.T) {
+	q := NewQuotaManager(64*1024, 3)
+
+	client, server := clientServerPair(t, WithQuota(q, "alice"))
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+
+	f, err := client.OpenFile(a, os.O_RDWR|os.O_CREATE)
+	require.NoError(t, err)
+	_, err = f.Write(make([]byte, 40*1024))
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("rewritten"), 0)
+	require.NoError(t, err, "rewriting is free")
+	require.NoError(t, f.Close())
+	assert.Equal(t, QuotaUsage{Bytes: 40 * 1024, Files: 1, MaxBytes: 64 * 1024, MaxFiles: 3}, q.Usage("alice"))
+
+	f, err = client.OpenFile(b, os.O_WRONLY|os.O_CREATE)
+	require.NoError(t, err)
+	_, err = f.WriteAt(make([]byte, 30*1024), 0)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "quota exceeded")
+	}
+	_, err = f.WriteAt(make([]byte, 20*1024), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, int64(60*1024), q.Usage("alice").Bytes)
+
+	if st, err := client.StatVFS(dir); assert.NoError(t, err) {
+		assert.LessOrEqual(t, st.TotalSpace(), uint64(64*1024))
+		assert.LessOrEqual(t, st.FreeSpace(), uint64(4*1024))
+		assert.Equal(t, uint64(1), st.Favail)
+	}
+
+	require.NoError(t, client.Truncate(a, 10*1024))
+	require.NoError(t, client.Rename(b, a), "replaces a")
+	assert.Equal(t, QuotaUsage{Bytes: 20 * 1024, Files: 1, MaxBytes: 64 * 1024, MaxFiles: 3}, q.Usage("alice"))
+
+	require.NoError(t, client.Mkdir(filepath.Join(dir, "d")))
+	require.NoError(t, client.Mkdir(filepath.Join(dir, "e")))
+	assert.Error(t, client.Mkdir(filepath.Join(dir, "f")))
+	require.NoError(t, client.Remove(a))
+	require.NoError(t, client.RemoveDirectory(filepath.Join(dir, "d")))
+	assert.Equal(t, QuotaUsage{Files: 1, MaxBytes: 64 * 1024, MaxFiles: 3}, q.Usage("alice"))
+}
@@ -0,0 +1,271 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// UseReadAhead has File.Read request the data ahead of the reader, once it
+// reads a File sequentially, keeping up to maxBytes of requests in flight.
+// How far it reads ahead adapts to the round trip time of the requests and
+// to how fast the reader consumes the data, so that a fast reader does not
+// wait for each request in turn, while a slow one does not tie up the server.
+//
+// Data read ahead is dropped when the File is read elsewhere than where the
+// last Read ended, or is written through Write or ReadFrom. It is not updated
+// for writes through WriteAt or by others, which the reader may not see then.
+func UseReadAhead(maxBytes int) ClientOption {
+	return func(c *Client) error {
+		if maxBytes < 1 {
+			return errors.New("maxBytes must be greater or equal to 1")
+		}
+		c.readAheadMax = maxBytes
+		return nil
+	}
+}
+
+// readAhead keeps the read requests of a File read sequentially in flight.
+type readAhead struct {
+	next int64 // where the next Read continues sequentially
+
+	queue []readAheadRequest // in flight, by offset
+	issue int64              // offset of the next request to issue
+	buf   []byte             // data received for offset next
+	page  []byte             // holding buf, returned once buf is read
+	err   error              // ends the data in buf, once it is read
+
+	rtt      time.Duration // smoothed round trip time of the requests
+	perByte  float64       // smoothed seconds the reader takes per byte
+	returned time.Time     // when the last Read returned
+	lastRead int           // bytes the last Read returned
+}
+
+type readAheadRequest struct {
+	id   uint32
+	off  int64
+	len  int
+	res  chan result
+	sent time.Time
+}
+
+// window returns how many requests of chunk bytes to keep in flight,
+// so that the reader consumes the data of one while the others are on
+// their way, but no more than max.
+func (ra *readAhead) window(chunk, max int) int {
+	if ra.rtt == 0 {
+		return 1
+	}
+
+	w := max
+	if ra.perByte > 0 {
+		drain := time.Duration(ra.perByte * float64(chunk) * float64(time.Second))
+		if n := int(ra.rtt/drain) + 1; drain > 0 && n < w {
+			w = n
+		}
+	}
+	if w < 1 {
+		w = 1
+	}
+	return w
+}
+
+// sampleRTT adds a round trip time to the smoothed one, weighing it by 1/8.
+func (ra *readAhead) sampleRTT(rtt time.Duration) {
+	if ra.rtt == 0 {
+		ra.rtt = rtt
+		return
+	}
+	ra.rtt += (rtt - ra.rtt) / 8
+}
+
+// sampleDrain adds how long the reader took with the data of the last
+// Read, before it called Read again, to the smoothed rate, weighing it by 1/4.
+func (ra *readAhead) sampleDrain(now time.Time) {
+	if ra.returned.IsZero() || ra.lastRead == 0 {
+		return
+	}
+	perByte := now.Sub(ra.returned).Seconds() / float64(ra.lastRead)
+	if ra.perByte == 0 {
+		ra.perByte = perByte
+		return
+	}
+	ra.perByte += (perByte - ra.perByte) / 4
+}
+
+// reset drops the data read ahead, to carry on reading at off.
+// The responses to the requests still in flight are discarded.
+func (ra *readAhead) reset(off int64) {
+	ra.next = off
+	ra.queue = nil
+	ra.issue = off
+	ra.buf = nil
+	ra.err = nil
+	ra.release()
+}
+
+// release returns the page of the data read into buf, which has to be read by now.
+func (ra *readAhead) release() {
+	putPage(ra.page)
+	ra.page = nil
+}
+
+// dropReadAhead drops the data read ahead, as the File is written to.
+// It has to be called with f.mu held.
+func (f *File) dropReadAhead() {
+	f.ra = nil
+}
+
+// readStream implements Read with read-ahead once the File is read sequentially,
+// the first Read and any Read after a seek go through ReadAt.
+func (f *File) readStream(b []byte) (int, error) {
+	now := time.Now()
+
+	ra := f.ra
+	if ra == nil || ra.next != f.offset {
+		if ra == nil {
+			ra = &readAhead{}
+			f.ra = ra
+		}
+
+		n, err := f.ReadAt(b, f.offset)
+		f.offset += int64(n)
+		ra.reset(f.offset)
+		if err != nil {
+			// start over after errors and the end of the file
+			ra.next = -1
+		}
+		ra.returned, ra.lastRead = time.Now(), n
+		return n, err
+	}
+	ra.sampleDrain(now)
+
+	var n int
+	for n < len(b) {
+		if len(ra.buf) == 0 {
+			if ra.err != nil || n > 0 && !ra.ready() {
+				break
+			}
+			f.fillReadAhead()
+			continue
+		}
+		m := copy(b[n:], ra.buf)
+		ra.buf = ra.buf[m:]
+		n += m
+	}
+	f.offset += int64(n)
+	ra.next = f.offset
+	ra.returned, ra.lastRead = time.Now(), n
+
+	if n == 0 && ra.err != nil {
+		err := ra.err
+		ra.reset(f.offset)
+		ra.next = -1
+		return 0, err
+	}
+
+	f.issueReadAhead()
+	return n, nil
+}
+
+// ready reports whether the response to the next request has arrived.
+func (ra *readAhead) ready() bool {
+	return len(ra.queue) > 0 && len(ra.queue[0].res) > 0
+}
+
+// issueReadAhead sends requests until the window is in flight.
+func (f *File) issueReadAhead() {
+	ra := f.ra
+	if ra.err != nil {
+		return
+	}
+
+	chunk := f.c.maxPacket
+	max := f.c.readAheadMax / chunk
+	if max > f.c.maxConcurrentRequests {
+		max = f.c.maxConcurrentRequests
+	}
+	if max < 1 {
+		max = 1
+	}
+
+	for len(ra.queue) < ra.window(chunk, max) {
+		req := readAheadRequest{
+			id:   f.c.nextID(),
+			off:  ra.issue,
+			len:  chunk,
+			res:  make(chan result, 1),
+			sent: time.Now(),
+		}
+		f.c.dispatchRequest(req.res, &sshFxpReadPacket{
+			ID:     req.id,
+			Handle: f.handle,
+			Offset: uint64(req.off),
+			Len:    uint32(req.len),
+		})
+		ra.queue = append(ra.queue, req)
+		ra.issue += int64(chunk)
+	}
+}
+
+// fillReadAhead waits for the next response, and puts its data into the buffer.
+func (f *File) fillReadAhead() {
+	ra := f.ra
+	ra.release()
+	if len(ra.queue) == 0 {
+		f.issueReadAhead()
+	}
+
+	req := ra.queue[0]
+	ra.queue = ra.queue[1:]
+
+	waited := len(req.res) == 0
+	s := f.c.awaitCancel(req.res, req.id, f.readDeadline.wait())
+	if waited && s.err == nil {
+		ra.sampleRTT(time.Since(req.sent))
+	}
+
+	if s.err != nil {
+		ra.fail(s.err)
+		return
+	}
+
+	switch s.typ {
+	case sshFxpStatus:
+		ra.fail(normaliseError(unmarshalStatus(req.id, s.data)))
+
+	case sshFxpData:
+		sid, data := unmarshalUint32(s.data)
+		if req.id != sid {
+			ra.fail(&unexpectedIDErr{req.id, sid})
+			return
+		}
+
+		l, data := unmarshalUint32(data)
+		if int64(l) > int64(len(data)) {
+			ra.fail(errShortPacket)
+			return
+		}
+		if l == 0 {
+			ra.fail(io.EOF)
+			return
+		}
+
+		ra.buf, ra.page = data[:l], s.data
+		if int(l) < req.len {
+			// The requests in flight do not continue where this one ended,
+			// either it is the end of the file, or the server sends less.
+			ra.queue = nil
+			ra.issue = req.off + int64(l)
+		}
+
+	default:
+		ra.fail(unimplementedPacketErr(s.typ))
+	}
+}
+
+// fail ends the data read ahead with err.
+func (ra *readAhead) fail(err error) {
+	ra.queue = nil
+	ra.err = err
+}
@@ -0,0 +1,95 @@
+package sftp
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadAheadWindow(t *testing.T) {
+	var ra readAhead
+	assert.Equal(t, 1, ra.window(1000, 8), "nothing measured yet")
+
+	ra.sampleRTT(10 * time.Millisecond)
+	assert.Equal(t, 8, ra.window(1000, 8), "a reader which takes no time")
+
+	// the reader takes 1ms per 1000 bytes, the requests 10ms
+	ra.returned, ra.lastRead = time.Now().Add(-time.Millisecond), 1000
+	ra.sampleDrain(ra.returned.Add(time.Millisecond))
+	assert.Equal(t, 11, ra.window(1000, 16))
+	assert.Equal(t, 8, ra.window(1000, 8))
+
+	// a slow reader needs a single request ahead
+	ra.perByte = 1
+	assert.Equal(t, 1, ra.window(1000, 8))
+
+	ra.sampleRTT(2 * time.Millisecond)
+	assert.Equal(t, 9*time.Millisecond, ra.rtt)
+}
+
+func TestReadAhead(t *testing.T) {
+	client, server := clientServerPairWith(t, nil, MaxPacket(1024), UseReadAhead(16*1024))
+	defer client.Close()
+	defer server.Close()
+
+	content := make([]byte, 100*1024+123)
+	rnd := rand.New(rand.NewSource(1))
+	rnd.Read(content)
+	name := path.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(name, content, 0o644))
+
+	f, err := client.OpenFile(name, os.O_RDWR)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var got bytes.Buffer
+	var ahead bool
+	b := make([]byte, 3000)
+	for {
+		n, err := f.Read(b[:1+rnd.Intn(len(b))])
+		got.Write(b[:n])
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		ahead = ahead || len(f.ra.queue) > 1
+	}
+	assert.True(t, bytes.Equal(content, got.Bytes()))
+	assert.True(t, ahead, "never read ahead")
+
+	// seeking drops what was read ahead
+	_, err = f.Seek(1000, io.SeekStart)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = io.ReadFull(f, b[:500])
+		require.NoError(t, err)
+	}
+	assert.Equal(t, content[2000:2500], b[:500])
+	_, err = f.Seek(50*1024, io.SeekStart)
+	require.NoError(t, err)
+	_, err = io.ReadFull(f, b)
+	require.NoError(t, err)
+	assert.Equal(t, content[50*1024:50*1024+len(b)], b)
+
+	// so does writing
+	for i := 0; i < 3; i++ {
+		_, err = io.ReadFull(f, b[:500])
+		require.NoError(t, err)
+	}
+	off, err := f.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("written"))
+	require.NoError(t, err)
+	_, err = f.Seek(off, io.SeekStart)
+	require.NoError(t, err)
+	_, err = io.ReadFull(f, b[:7])
+	require.NoError(t, err)
+	assert.Equal(t, "written", string(b[:7]))
+}
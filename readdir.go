@@ -0,0 +1,59 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	iofs "io/fs"
+)
+
+// ReadDirContext is ReadDir, but gives up once ctx is done, with the error
+// of ctx, and closes the directory.
+func (c *Client) ReadDirContext(ctx context.Context, p string) (_ []iofs.FileInfo, err error) {
+	defer c.startOp("Client.ReadDirContext", p, "").done(&err)
+	defer pathError(&err, "readdir", p)
+
+	handle, err := c.opendirContext(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close(handle)
+
+	var infos []iofs.FileInfo
+	for {
+		page, err := c.readDirPage(ctx, handle)
+		if err == io.EOF {
+			return infos, nil
+		}
+		if err != nil {
+			return infos, err
+		}
+		infos = append(infos, page...)
+	}
+}
+
+// ReadDirNamesContext returns the names of the entries of the directory p,
+// in the order the server lists them, like ReadDirContext without the
+// attributes. It skips them rather than decoding them, which makes very
+// large listings faster where only the names matter.
+func (c *Client) ReadDirNamesContext(ctx context.Context, p string) (_ []string, err error) {
+	defer c.startOp("Client.ReadDirNamesContext", p, "").done(&err)
+	defer pathError(&err, "readdir", p)
+
+	handle, err := c.opendirContext(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close(handle)
+
+	var names []string
+	for {
+		page, err := c.readDirNamesPage(ctx, handle)
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return names, err
+		}
+		names = append(names, page...)
+	}
+}
@@ -0,0 +1,76 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientReadDirContext(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	var want []string
+	for i := 0; i < 150; i++ {
+		name := "file" + strconv.Itoa(i)
+		require.NoError(t, os.WriteFile(path.Join(dir, name), []byte(name), 0o644))
+		want = append(want, name)
+	}
+	sort.Strings(want)
+
+	ctx := context.Background()
+	infos, err := client.ReadDirContext(ctx, dir)
+	require.NoError(t, err)
+	var got []string
+	for _, info := range infos {
+		got = append(got, info.Name())
+		assert.Equal(t, int64(len(info.Name())), info.Size())
+	}
+	sort.Strings(got)
+	assert.Equal(t, want, got)
+
+	names, err := client.ReadDirNamesContext(ctx, dir)
+	require.NoError(t, err)
+	sort.Strings(names)
+	assert.Equal(t, want, names)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = client.ReadDirContext(canceled, dir)
+	assert.True(t, errors.Is(err, context.Canceled), "got %v", err)
+	_, err = client.ReadDirNamesContext(canceled, dir)
+	assert.True(t, errors.Is(err, context.Canceled), "got %v", err)
+
+	_, err = client.ReadDirNamesContext(ctx, path.Join(dir, "missing"))
+	assert.True(t, os.IsNotExist(err), "%v", err)
+}
+
+func TestSkipAttrs(t *testing.T) {
+	flags := uint32(sshFileXferAttrSize | sshFileXferAttrUIDGID | sshFileXferAttrPermissions |
+		sshFileXferAttrACmodTime | sshFileXferAttrExtended)
+	b := marshalUint32(nil, flags)
+	b = marshalUint64(b, 5)                       // size
+	b = marshalUint32(marshalUint32(b, 1), 2)     // uid, gid
+	b = marshalUint32(b, 0o644)                   // permissions
+	b = marshalUint32(marshalUint32(b, 3), 4)     // atime, mtime
+	b = marshalUint32(b, 1)                       // extended count
+	b = marshalString(marshalString(b, "a"), "b") // extension pair
+	full := len(b)
+	b = append(b, "rest"...)
+
+	assert.Equal(t, "rest", string(skipAttrs(b)))
+	attrs, rest := unmarshalAttrs(b)
+	assert.Equal(t, uint64(5), attrs.Size)
+	assert.Equal(t, "rest", string(rest), "skipAttrs agrees with unmarshalAttrs")
+	assert.Nil(t, skipAttrs(b[:10]), "cut short")
+	assert.Nil(t, skipAttrs(b[:full-1]), "cut short")
+}
@@ -0,0 +1,426 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DialFunc opens a new connection to the SFTP server for WithAutoReconnect,
+// returning the pipes of a fresh session of the sftp subsystem for example.
+type DialFunc func() (io.Reader, io.WriteCloser, error)
+
+// ReconnectPolicy controls how hard WithAutoReconnect tries to reconnect.
+type ReconnectPolicy struct {
+	// MaxAttempts is how often to dial after the connection was lost,
+	// before giving up. Values below 1 mean a single attempt.
+	MaxAttempts int
+
+	// Backoff is the delay before the second attempt, it doubles for
+	// every further attempt, up to MaxBackoff if that is set.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// WithAutoReconnect re-establishes the session through dial, whenever the
+// connection to the server is lost, instead of failing all requests with
+// ErrSSHFxConnectionLost.
+//
+// Once connected again, open Files are reopened at the same path, without
+// the flags to create, truncate or exclusively create, and carry on at their
+// offsets. Requests which were in flight are sent again, if that is safe:
+// reads, writes which do not append, stat, setstat, realpath, readlink, fsync
+// and close. Other requests in flight fail with ErrSSHFxConnectionLost, as
+// they may or may not have taken effect. Directory handles and byte range
+// locks are not restored.
+//
+// The server has to negotiate the same protocol version as before. If all
+// attempts of policy fail, the Client shuts down as without this option.
+func WithAutoReconnect(dial DialFunc, policy ReconnectPolicy) ClientOption {
+	return func(c *Client) error {
+		if dial == nil {
+			return errors.New("sftp: WithAutoReconnect needs a dial function")
+		}
+		c.reconnect = &reconnector{
+			c:       c,
+			dial:    dial,
+			policy:  policy,
+			stopped: make(chan struct{}),
+			files:   make(map[string]*resumableFile),
+		}
+		c.pending = make(map[uint32]idmarshaler)
+		return nil
+	}
+}
+
+// reconnector re-establishes the session of a Client.
+//
+// Files opened with it get a token as their handle, which is translated
+// to the handle of the current session whenever a request is sent.
+type reconnector struct {
+	c      *Client
+	dial   DialFunc
+	policy ReconnectPolicy
+
+	// set by NewClientTransport, if the Transport implements them
+	signal   <-chan struct{}
+	observer ReconnectObserver
+
+	stopOnce sync.Once
+	stopped  chan struct{}
+
+	mu        sync.Mutex // protects files and lastToken
+	files     map[string]*resumableFile
+	lastToken uint64
+}
+
+// resumableFile is an open File, which is reopened on every new session.
+type resumableFile struct {
+	path   string
+	pflags uint32
+	handle string // on the current session
+}
+
+// tokenPrefix starts every token, no server hands out such handles.
+const tokenPrefix = "\x00reconnect:"
+
+// track registers the File at path, opened with pflags as handle,
+// and returns the token standing in for handle.
+func (r *reconnector) track(path string, pflags uint32, handle string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastToken++
+	token := tokenPrefix + strconv.FormatUint(r.lastToken, 10)
+	r.files[token] = &resumableFile{
+		path:   path,
+		pflags: pflags,
+		handle: handle,
+	}
+	return token
+}
+
+// untrack forgets the File of token, which was closed.
+func (r *reconnector) untrack(token string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.files, token)
+}
+
+// file returns the File of token, if it is one.
+func (r *reconnector) file(token string) (resumableFile, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, ok := r.files[token]
+	if !ok {
+		return resumableFile{}, false
+	}
+	return *f, true
+}
+
+// handle returns the handle of the current session standing behind h.
+func (r *reconnector) handle(h string) string {
+	if f, ok := r.file(h); ok {
+		return f.handle
+	}
+	return h
+}
+
+// translate returns p with all tokens replaced by the handles of the current session.
+// It returns p itself, if there is no reconnector.
+func (r *reconnector) translate(p idmarshaler) idmarshaler {
+	if r == nil {
+		return p
+	}
+
+	switch p := p.(type) {
+	case trailerPacket:
+		return trailerPacket{r.translate(p.idmarshaler), p.trailer}
+	case asExtension:
+		return asExtension{r.translate(p.idmarshaler), p.name}
+	case *sshFxpReadPacket:
+		q := *p
+		q.Handle = r.handle(p.Handle)
+		return &q
+	case *sshFxpWritePacket:
+		q := *p
+		q.Handle = r.handle(p.Handle)
+		return &q
+	case *sshFxpFstatPacket:
+		q := *p
+		q.Handle = r.handle(p.Handle)
+		return &q
+	case *sshFxpFsetstatPacket:
+		q := *p
+		q.Handle = r.handle(p.Handle)
+		return &q
+	case *sshFxpClosePacket:
+		q := *p
+		q.Handle = r.handle(p.Handle)
+		return &q
+	case *sshFxpFsyncPacket:
+		q := *p
+		q.Handle = r.handle(p.Handle)
+		return &q
+	case *sshFxpCheckFilePacket:
+		q := *p
+		q.Handle = r.handle(p.Handle)
+		return &q
+	case *sshFxpBlockPacket:
+		q := *p
+		q.Handle = r.handle(p.Handle)
+		return &q
+	case *sshFxpUnblockPacket:
+		q := *p
+		q.Handle = r.handle(p.Handle)
+		return &q
+	case *sshFxpCopyDataPacket:
+		q := *p
+		q.ReadFromHandle = r.handle(p.ReadFromHandle)
+		q.WriteToHandle = r.handle(p.WriteToHandle)
+		return &q
+	}
+	return p
+}
+
+// idempotent reports whether sending p again does no harm,
+// if it may already have been carried out.
+func (r *reconnector) idempotent(p idmarshaler) bool {
+	switch p := p.(type) {
+	case trailerPacket:
+		return r.idempotent(p.idmarshaler)
+	case *sshFxpWritePacket:
+		// appending the data again would duplicate it
+		f, _ := r.file(p.Handle)
+		return f.pflags&sshFxfAppend == 0
+	case *sshFxpReadPacket,
+		*sshFxpFstatPacket,
+		*sshFxpFsetstatPacket,
+		*sshFxpClosePacket,
+		*sshFxpFsyncPacket,
+		*sshFxpStatPacket,
+		*sshFxpLstatPacket,
+		*sshFxpSetstatPacket,
+		*sshFxpRealpathPacket,
+		*sshFxpReadlinkPacket,
+		*sshFxpStatvfsPacket:
+		return true
+	}
+	return false
+}
+
+// stop prevents any further reconnect, as the Client is being closed.
+func (r *reconnector) stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopped)
+	})
+}
+
+// resume re-establishes the session after the connection was lost with cause.
+// It reports whether the loop can carry on receiving.
+// New requests are held back until resume returns.
+func (r *reconnector) resume(cause error) bool {
+	c := r.c
+	c.gate.Lock()
+	defer c.gate.Unlock()
+
+	select {
+	case <-c.closed:
+		// shut down by the keepalive
+		return false
+	default:
+	}
+
+	if r.observer != nil {
+		r.observer.Disconnected(cause)
+	}
+
+	var err error
+	backoff := r.policy.Backoff
+	for attempt := 0; attempt == 0 || attempt < r.policy.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-r.stopped:
+				timer.Stop()
+				return false
+			case <-timer.C:
+			}
+
+			backoff *= 2
+			if r.policy.MaxBackoff > 0 && backoff > r.policy.MaxBackoff {
+				backoff = r.policy.MaxBackoff
+			}
+		}
+
+		var rd io.Reader
+		var wr io.WriteCloser
+		rd, wr, err = r.dial()
+		if err != nil {
+			debug("reconnect attempt %d failed: %v", attempt+1, err)
+			continue
+		}
+
+		c.conn.Lock()
+		c.conn.Reader, c.conn.WriteCloser = rd, wr
+		c.conn.Unlock()
+
+		select {
+		case <-r.stopped:
+			// Close may have closed the connection before it was replaced
+			c.conn.Close()
+			return false
+		default:
+		}
+
+		if err = r.handshake(); err != nil {
+			debug("reconnect attempt %d failed: %v", attempt+1, err)
+			c.conn.Close()
+			continue
+		}
+
+		if r.observer != nil {
+			r.observer.Reconnected(nil)
+		}
+		r.replay()
+		return true
+	}
+
+	if r.observer != nil {
+		r.observer.Reconnected(err)
+	}
+	return false
+}
+
+// handshake starts the session on the new connection, and reopens the Files.
+func (r *reconnector) handshake() error {
+	c := r.c
+
+	// the new session starts out uncompressed
+	c.compression.set(nil)
+
+	if err := c.sendInit(); err != nil {
+		return err
+	}
+	typ, data, err := c.recvPacket(0)
+	if err != nil {
+		return err
+	}
+	if typ != sshFxpVersion {
+		return &unexpectedPacketErr{sshFxpVersion, typ}
+	}
+	version, _, err := unmarshalUint32Safe(data)
+	if err != nil {
+		return err
+	}
+	if version != c.version {
+		// the requests are already marshaled for the previous version
+		return &unexpectedVersionErr{c.version, version}
+	}
+
+	if err := c.negotiateCompression(r.roundTrip); err != nil {
+		return err
+	}
+
+	return r.reopen()
+}
+
+// roundTrip sends p and receives its response directly,
+// while the loop is not receiving.
+func (r *reconnector) roundTrip(p idmarshaler) (byte, []byte, error) {
+	if err := r.c.conn.sendPacket(p); err != nil {
+		return 0, nil, err
+	}
+
+	typ, data, err := r.c.recvPacket(0)
+	if err != nil {
+		return 0, nil, err
+	}
+	sid, _, err := unmarshalUint32Safe(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	if sid != p.id() {
+		return 0, nil, &unexpectedIDErr{p.id(), sid}
+	}
+	return typ, data, nil
+}
+
+// reopen opens all tracked Files on the new session.
+// Files which cannot be opened again are left with an invalid handle,
+// so their requests fail.
+func (r *reconnector) reopen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, f := range r.files {
+		id := r.c.nextID()
+		pkt := r.c.openPacket(id, f.path, f.pflags&^(sshFxfCreat|sshFxfTrunc|sshFxfExcl), 0, nil)
+		typ, data, err := r.roundTrip(r.c.paths.packet(pkt))
+		if err != nil {
+			return err
+		}
+
+		f.handle = ""
+		if typ == sshFxpHandle {
+			_, data = unmarshalUint32(data)
+			f.handle, _ = unmarshalString(data)
+		}
+	}
+
+	return nil
+}
+
+// replay sends the requests in flight again, which are idempotent,
+// and fails all others.
+func (r *reconnector) replay() {
+	c := r.c
+
+	var replays []idmarshaler
+	c.Lock()
+	for sid, ch := range c.inflight {
+		p, ok := c.pending[sid]
+		switch {
+		case !ok:
+			// given up on, nobody waits for the response
+			delete(c.inflight, sid)
+			c.release(sid)
+		case r.idempotent(p):
+			replays = append(replays, p)
+		default:
+			delete(c.inflight, sid)
+			delete(c.pending, sid)
+			c.release(sid)
+			ch <- result{err: ErrSSHFxConnectionLost}
+		}
+	}
+	c.Unlock()
+
+	sort.Slice(replays, func(i, j int) bool {
+		return replays[i].id() < replays[j].id()
+	})
+
+	// Send them once the loop receives again,
+	// so their responses cannot fill up the connection.
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		c.gate.RLock()
+		defer c.gate.RUnlock()
+
+		for _, p := range replays {
+			if err := c.conn.sendPacket(r.translate(p)); err != nil {
+				// the loop notices the lost connection,
+				// and replays whatever is still pending
+				c.conn.Close()
+				return
+			}
+		}
+	}()
+}
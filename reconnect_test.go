@@ -0,0 +1,203 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reconnectServer serves every connection dialed with a new server,
+// the current connection can be dropped to have the client reconnect.
+type reconnectServer struct {
+	serve func(rw io.ReadWriteCloser, dials int)
+
+	mu    sync.Mutex
+	dials int
+	drop  func()
+}
+
+func (s *reconnectServer) dial() (io.Reader, io.WriteCloser, error) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+
+	s.mu.Lock()
+	s.dials++
+	dials := s.dials
+	s.drop = func() {
+		sr.Close()
+		sw.Close()
+	}
+	s.mu.Unlock()
+
+	go func() {
+		defer sw.Close()
+		s.serve(struct {
+			io.Reader
+			io.WriteCloser
+		}{sr, sw}, dials)
+	}()
+	return cr, cw, nil
+}
+
+func (s *reconnectServer) dropConn() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drop()
+}
+
+func (s *reconnectServer) dialed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dials
+}
+
+func (s *reconnectServer) client(t *testing.T, policy ReconnectPolicy) *Client {
+	rd, wr, err := s.dial()
+	require.NoError(t, err)
+	client, err := NewClientPipe(rd, wr, WithAutoReconnect(s.dial, policy))
+	require.NoError(t, err)
+	return client
+}
+
+func TestReconnectResumesFiles(t *testing.T) {
+	s := &reconnectServer{serve: func(rw io.ReadWriteCloser, _ int) {
+		server, err := NewServer(rw, apis.NewAVFS())
+		if err == nil {
+			server.Serve()
+		}
+	}}
+	client := s.client(t, ReconnectPolicy{MaxAttempts: 3})
+	defer client.Close()
+
+	name := path.Join(t.TempDir(), "resumed")
+	w, err := client.Create(name)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	r, err := client.Open(name)
+	require.NoError(t, err)
+
+	s.dropConn()
+
+	// the file is not truncated again, and written on at its offset
+	_, err = w.Write([]byte(" world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+	require.NoError(t, r.Close())
+
+	assert.Equal(t, 2, s.dialed())
+	assert.Empty(t, client.reconnect.files)
+}
+
+// parkingHandler holds back reads and commands until released,
+// after announcing them on parked.
+type parkingHandler struct {
+	parked  chan<- string
+	release <-chan struct{}
+}
+
+func (h parkingHandler) park(method string) {
+	if h.parked != nil {
+		h.parked <- method
+	}
+	<-h.release
+}
+
+func (h parkingHandler) ReadAt(b []byte, off int64) (int, error) {
+	h.park("Read")
+	return copy(b, "hello"), nil
+}
+
+func (h parkingHandler) Fileread(*Request) (io.ReaderAt, error) {
+	return h, nil
+}
+
+func (h parkingHandler) Filecmd(r *Request) error {
+	h.park(r.Method)
+	return nil
+}
+
+func TestReconnectReplaysRequests(t *testing.T) {
+	parked := make(chan string, 2)
+	stalled := make(chan struct{})
+	defer close(stalled)
+	released := make(chan struct{})
+	close(released)
+
+	s := &reconnectServer{serve: func(rw io.ReadWriteCloser, dials int) {
+		h := parkingHandler{release: released}
+		if dials == 1 {
+			h = parkingHandler{parked: parked, release: stalled}
+		}
+		NewRequestServer(rw, Handlers{FileGet: h, FileCmd: h}).Serve()
+	}}
+	client := s.client(t, ReconnectPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+	defer client.Close()
+
+	f, err := client.Open("/file")
+	require.NoError(t, err)
+
+	read := make(chan error, 1)
+	go func() {
+		b := make([]byte, 5)
+		n, err := f.ReadAt(b, 0)
+		if err == nil && string(b[:n]) != "hello" {
+			err = errors.New("unexpected data " + string(b[:n]))
+		}
+		read <- err
+	}()
+	mkdir := make(chan error, 1)
+	go func() {
+		mkdir <- client.Mkdir("/dir")
+	}()
+
+	<-parked
+	<-parked
+	s.dropConn()
+
+	assert.NoError(t, <-read, "the read is replayed")
+	assert.True(t, errors.Is(<-mkdir, ErrSSHFxConnectionLost), "mkdir may have happened")
+
+	require.NoError(t, client.Mkdir("/dir"))
+	require.NoError(t, f.Close())
+	assert.Equal(t, 2, s.dialed())
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	s := &reconnectServer{serve: func(rw io.ReadWriteCloser, _ int) {
+		server, err := NewServer(rw, apis.NewAVFS())
+		if err == nil {
+			server.Serve()
+		}
+	}}
+	rd, wr, err := s.dial()
+	require.NoError(t, err)
+
+	var dials int
+	client, err := NewClientPipe(rd, wr, WithAutoReconnect(func() (io.Reader, io.WriteCloser, error) {
+		dials++
+		return nil, nil, errors.New("unreachable")
+	}, ReconnectPolicy{MaxAttempts: 3, Backoff: time.Millisecond}))
+	require.NoError(t, err)
+	defer client.Close()
+
+	s.dropConn()
+
+	assert.Error(t, client.Wait())
+	assert.Equal(t, 3, dials)
+	_, err = client.Stat(os.TempDir())
+	assert.True(t, errors.Is(err, ErrSSHFxConnectionLost), "got %v", err)
+}
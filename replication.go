@@ -0,0 +1,273 @@
+package sftp
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp/apis"
+)
+
+// ReplicationEvent describes a mutation a Server has successfully applied,
+// with enough information to apply it to a read-only mirror as well.
+type ReplicationEvent struct {
+	// Op is one of "Put", "Setstat", "Rename", "PosixRename", "Rmdir",
+	// "Mkdir", "Link", "Symlink" or "Remove", like Request.Method.
+	Op string
+
+	// Path is the local path the mutation was applied to.
+	// For Symlink this is the target of the link, like Request.Filepath.
+	Path string
+
+	// Target is the new path for Rename and PosixRename,
+	// and the path of the new link for Link and Symlink.
+	Target string
+
+	// ContentPath names a snapshot of the file contents for Put,
+	// taken when the handle was closed. It is removed once Replicate returns,
+	// so it has to be copied or linked elsewhere to be kept.
+	ContentPath string
+
+	// Info is the result of lstat on the changed path for Put, Setstat,
+	// Mkdir and Symlink, taken right after the mutation.
+	Info fs.FileInfo
+
+	// Time is when the mutation was applied.
+	Time time.Time
+}
+
+// ReplicationHook receives the mutations of a Server, see WithReplication.
+// Replicate is called from a single goroutine, in the order the mutations
+// completed, while the server goes on serving requests.
+type ReplicationHook interface {
+	Replicate(ev ReplicationEvent) error
+}
+
+// BackPressureReporter is an optional interface a ReplicationHook can
+// implement to learn that the replication queue is full. It is called with
+// the number of pending events before the server stops serving mutations
+// until there is room in the queue again.
+type BackPressureReporter interface {
+	BackPressure(pending int)
+}
+
+// ReplicationStats reports the progress of the ReplicationHook of a Server.
+type ReplicationStats struct {
+	Queued     uint64 // events queued so far
+	Replicated uint64 // events Replicate returned nil for
+	Failed     uint64 // events that could not be snapshotted or replicated
+	Stalls     uint64 // times a mutation had to wait for a full queue
+	Pending    int    // events waiting to be replicated right now
+}
+
+// WithReplication calls hook for every mutation the Server applied,
+// through a queue holding up to queueLen events. Once the queue is full
+// the server waits for it to drain before serving further requests,
+// rather than letting the mirror fall behind without bound.
+//
+// Serve only returns once every queued event has been replicated.
+func WithReplication(hook ReplicationHook, queueLen int) ServerOption {
+	return func(s *Server) error {
+		if queueLen < 0 {
+			return fmt.Errorf("sftp: negative replication queue length %d", queueLen)
+		}
+		s.replication = &replication{
+			hook:    hook,
+			queue:   make(chan ReplicationEvent, queueLen),
+			writing: make(map[string]string),
+		}
+		return nil
+	}
+}
+
+// replication queues the mutations of a Server for its ReplicationHook.
+type replication struct {
+	// first, to keep them 64-bit aligned for atomic access on 32-bit platforms
+	queued     uint64
+	replicated uint64
+	failed     uint64
+	stalls     uint64
+
+	hook  ReplicationHook
+	queue chan ReplicationEvent
+	done  chan struct{}
+
+	mu      sync.Mutex
+	writing map[string]string // handle to local path, for handles open for writing
+}
+
+// start runs the hook until stop is called.
+func (r *replication) start(svr *Server) {
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		for ev := range r.queue {
+			if err := r.hook.Replicate(ev); err != nil {
+				atomic.AddUint64(&r.failed, 1)
+				fmt.Fprintf(svr.debugStream, "sftp server replication of %s %q failed: %v\n", ev.Op, ev.Path, err)
+			} else {
+				atomic.AddUint64(&r.replicated, 1)
+			}
+			if ev.ContentPath != "" {
+				apis.Remove(svr.fs, ev.ContentPath)
+			}
+		}
+	}()
+}
+
+// stop waits for all queued events to be replicated.
+func (r *replication) stop() {
+	close(r.queue)
+	<-r.done
+}
+
+func (r *replication) stats() ReplicationStats {
+	return ReplicationStats{
+		Queued:     atomic.LoadUint64(&r.queued),
+		Replicated: atomic.LoadUint64(&r.replicated),
+		Failed:     atomic.LoadUint64(&r.failed),
+		Stalls:     atomic.LoadUint64(&r.stalls),
+		Pending:    len(r.queue),
+	}
+}
+
+func (r *replication) enqueue(ev ReplicationEvent) {
+	atomic.AddUint64(&r.queued, 1)
+	select {
+	case r.queue <- ev:
+		return
+	default:
+	}
+
+	atomic.AddUint64(&r.stalls, 1)
+	if bp, ok := r.hook.(BackPressureReporter); ok {
+		bp.BackPressure(len(r.queue))
+	}
+	r.queue <- ev
+}
+
+// replicate queues the mutation done by p, if rpkt reports its success.
+// It has to be called before the response is sent, so a client never sees
+// a mutation completing that the mirror is not going to learn about.
+func (svr *Server) replicate(p requestPacket, rpkt responsePacket) {
+	r := svr.replication
+
+	if open, ok := p.(*sshFxpOpenPacket); ok {
+		if h, ok := rpkt.(*sshFxpHandlePacket); ok && !open.readonly() {
+			r.mu.Lock()
+			r.writing[h.Handle] = toLocalPath(open.Path)
+			r.mu.Unlock()
+		}
+		return
+	}
+
+	if status, ok := rpkt.(*sshFxpStatusPacket); !ok || status.Code != sshFxOk {
+		if c, ok := p.(*sshFxpClosePacket); ok {
+			// the handle is gone, whether closing it failed or not
+			r.mu.Lock()
+			delete(r.writing, c.Handle)
+			r.mu.Unlock()
+		}
+		return
+	}
+
+	if e, ok := p.(*sshFxpExtendedPacket); ok {
+		p = e.SpecificPacket
+	}
+
+	ev := ReplicationEvent{Time: time.Now()}
+	switch p := p.(type) {
+	case *sshFxpClosePacket:
+		r.mu.Lock()
+		name, ok := r.writing[p.Handle]
+		delete(r.writing, p.Handle)
+		r.mu.Unlock()
+		if !ok {
+			return
+		}
+		ev.Op, ev.Path = "Put", name
+		content, err := svr.snapshot(name)
+		if err != nil {
+			atomic.AddUint64(&r.failed, 1)
+			fmt.Fprintf(svr.debugStream, "sftp server replication snapshot of %q failed: %v\n", name, err)
+			return
+		}
+		ev.ContentPath = content
+	case *sshFxpSetstatPacket:
+		ev.Op, ev.Path = "Setstat", toLocalPath(p.Path)
+	case *sshFxpFsetstatPacket:
+		f, ok := svr.getHandle(p.Handle)
+		if !ok {
+			return
+		}
+		ev.Op, ev.Path = "Setstat", f.Name()
+	case *sshFxpMkdirPacket:
+		ev.Op, ev.Path = "Mkdir", toLocalPath(p.Path)
+	case *sshFxpRmdirPacket:
+		ev.Op, ev.Path = "Rmdir", toLocalPath(p.Path)
+	case *sshFxpRemovePacket:
+		ev.Op, ev.Path = "Remove", toLocalPath(p.Filename)
+	case *sshFxpRenamePacket:
+		ev.Op, ev.Path, ev.Target = "Rename", toLocalPath(p.Oldpath), toLocalPath(p.Newpath)
+	case *sshFxpExtendedPacketPosixRename:
+		ev.Op, ev.Path, ev.Target = "PosixRename", toLocalPath(p.Oldpath), toLocalPath(p.Newpath)
+	case *sshFxpSymlinkPacket:
+		ev.Op, ev.Path, ev.Target = "Symlink", toLocalPath(p.Targetpath), toLocalPath(p.Linkpath)
+	case *sshFxpExtendedPacketHardlink:
+		ev.Op, ev.Path, ev.Target = "Link", toLocalPath(p.Oldpath), toLocalPath(p.Newpath)
+	default:
+		return
+	}
+
+	switch ev.Op {
+	case "Put", "Setstat", "Mkdir":
+		ev.Info, _ = apis.Lstat(svr.fs, ev.Path)
+	case "Symlink":
+		ev.Info, _ = apis.Lstat(svr.fs, ev.Target)
+	}
+
+	r.enqueue(ev)
+}
+
+var snapshotCount uint64
+
+// snapshot copies the contents of name to a new file in the temporary
+// directory of the Fs and returns its path.
+func (svr *Server) snapshot(name string) (string, error) {
+	src, err := svr.fs.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	tmp := path.Join(apis.TempDir(svr.fs), fmt.Sprintf("sftp-replica-%d-%d",
+		os.Getpid(), atomic.AddUint64(&snapshotCount, 1)))
+	dst, err := svr.fs.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(dst, src)
+	if err2 := dst.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		apis.Remove(svr.fs, tmp)
+		return "", err
+	}
+	return tmp, nil
+}
+
+// ReplicationStats returns the progress of the ReplicationHook,
+// the zero value if WithReplication was not used.
+func (svr *Server) ReplicationStats() ReplicationStats {
+	if svr.replication == nil {
+		return ReplicationStats{}
+	}
+	return svr.replication.stats()
+}
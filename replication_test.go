@@ -0,0 +1,145 @@
+package sftp
+
+import (
+	"io"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingHook keeps the events and the snapshotted contents.
+type recordingHook struct {
+	mu       sync.Mutex
+	events   []ReplicationEvent
+	contents map[string]string
+	release  chan struct{} // if set, Replicate waits for it
+	pressure []int
+}
+
+func (h *recordingHook) Replicate(ev ReplicationEvent) error {
+	if h.release != nil {
+		<-h.release
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.events = append(h.events, ev)
+	if ev.ContentPath != "" {
+		b, err := os.ReadFile(ev.ContentPath)
+		if err != nil {
+			return err
+		}
+		if h.contents == nil {
+			h.contents = make(map[string]string)
+		}
+		h.contents[ev.Path] = string(b)
+	}
+	return nil
+}
+
+func (h *recordingHook) BackPressure(pending int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pressure = append(h.pressure, pending)
+}
+
+func TestServerReplication(t *testing.T) {
+	skipIfWindows(t)
+	hook := &recordingHook{}
+	client, server := clientServerPair(t, WithReplication(hook, 16))
+
+	dir := t.TempDir()
+	name := path.Join(dir, "file")
+
+	require.NoError(t, client.Mkdir(path.Join(dir, "sub")))
+	f, err := client.Create(name)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("replicate me"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, client.Chmod(name, 0600))
+	require.NoError(t, client.Rename(name, path.Join(dir, "sub", "file")))
+	require.NoError(t, client.Remove(path.Join(dir, "sub", "file")))
+
+	// neither reads nor failed mutations are replicated
+	_, err = client.Stat(dir)
+	require.NoError(t, err)
+	assert.Error(t, client.Remove(name))
+
+	server.Close()
+	client.Close()
+	<-server.replication.done // the mirror caught up
+
+	var ops []string
+	for _, ev := range hook.events {
+		ops = append(ops, ev.Op)
+	}
+	assert.Equal(t, []string{"Mkdir", "Put", "Setstat", "Rename", "Remove"}, ops)
+	assert.Equal(t, "replicate me", hook.contents[name])
+	assert.Equal(t, path.Join(dir, "sub", "file"), hook.events[3].Target)
+	require.NotNil(t, hook.events[2].Info)
+	assert.Equal(t, os.FileMode(0600), hook.events[2].Info.Mode().Perm())
+
+	// the snapshot is gone after Replicate returned
+	_, err = os.Stat(hook.events[1].ContentPath)
+	assert.True(t, os.IsNotExist(err))
+
+	stats := server.ReplicationStats()
+	assert.Equal(t, ReplicationStats{Queued: 5, Replicated: 5}, stats)
+}
+
+func TestServerReplicationBackPressure(t *testing.T) {
+	skipIfWindows(t)
+	hook := &recordingHook{release: make(chan struct{})}
+	client, server := clientServerPair(t, WithReplication(hook, 1))
+
+	dir := t.TempDir()
+	mkdirs := make(chan error)
+	go func() {
+		for _, name := range []string{"a", "b", "c"} {
+			if err := client.Mkdir(path.Join(dir, name)); err != nil {
+				mkdirs <- err
+				return
+			}
+		}
+		mkdirs <- nil
+	}()
+
+	// at most one event is being replicated and one queued,
+	// so the third one has to wait
+	require.Eventually(t, func() bool {
+		hook.mu.Lock()
+		defer hook.mu.Unlock()
+		return len(hook.pressure) > 0
+	}, 10*time.Second, time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		hook.release <- struct{}{}
+	}
+	require.NoError(t, <-mkdirs)
+
+	server.Close()
+	client.Close()
+	<-server.replication.done // the mirror caught up
+
+	stats := server.ReplicationStats()
+	assert.Equal(t, uint64(3), stats.Replicated)
+	assert.NotZero(t, stats.Stalls)
+	assert.Len(t, hook.pressure, int(stats.Stalls))
+}
+
+func TestWithReplicationInvalidQueue(t *testing.T) {
+	_, err := NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{}, apis.NewAVFS(), WithReplication(&recordingHook{}, -1))
+	assert.Error(t, err)
+}
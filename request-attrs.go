@@ -2,9 +2,10 @@ package sftp
 
 // Methods on the Request object to make working with the Flags bitmasks and
 // Attr(ibutes) byte blob easier. Use Pflags() when working with an Open/Write
-// request and AttrFlags() and Attributes() when working with SetStat requests.
+// request and FileAttributes() when working with SetStat or Mkdir requests.
 import (
 	"io/fs"
+	"time"
 )
 
 // FileOpenFlags defines Open and Write Flags. Correlate directly with with fs.OpenFile flags
@@ -63,3 +64,75 @@ func (r *Request) Attributes() *FileStat {
 	fs, _ := unmarshalFileStat(r.Flags, r.Attrs)
 	return fs
 }
+
+// DecodeAttributes returns the file attributes of a Setstat or Mkdir request,
+// along with which of them the client set. Only the attributes flagged are
+// meaningful, the others are left zero. It fails if the client sent fewer
+// attributes than it flagged.
+func (r *Request) DecodeAttributes() (FileAttrFlags, *FileStat, error) {
+	fs, _, err := unmarshalFileStatSafe(r.Flags, r.Attrs)
+	if err != nil {
+		return FileAttrFlags{}, nil, err
+	}
+	return r.AttrFlags(), fs, nil
+}
+
+// FileAttributes are the parsed file attributes of a Setstat or Mkdir
+// request. Each accessor reports whether the client set the attribute.
+type FileAttributes struct {
+	flags FileAttrFlags
+	stat  FileStat
+}
+
+// FileAttributes parses the file attributes of a Setstat or Mkdir request.
+// It fails if the client sent fewer attributes than it flagged.
+func (r *Request) FileAttributes() (*FileAttributes, error) {
+	flags, stat, err := r.DecodeAttributes()
+	if err != nil {
+		return nil, err
+	}
+	return &FileAttributes{flags: flags, stat: *stat}, nil
+}
+
+// Flags returns which attributes the client set.
+func (a *FileAttributes) Flags() FileAttrFlags {
+	return a.flags
+}
+
+// Size returns the size to truncate or extend the file to.
+func (a *FileAttributes) Size() (int64, bool) {
+	if !a.flags.Size {
+		return 0, false
+	}
+	n, err := toInt64(a.stat.Size)
+	return n, err == nil
+}
+
+// Mode returns the permissions, including the setuid, setgid and sticky bits.
+func (a *FileAttributes) Mode() (fs.FileMode, bool) {
+	if !a.flags.Permissions {
+		return 0, false
+	}
+	return toFileMode(a.stat.Mode) &^ fs.ModeType, true
+}
+
+// Times returns the access and modification times.
+func (a *FileAttributes) Times() (atime, mtime time.Time, ok bool) {
+	if !a.flags.Acmodtime {
+		return time.Time{}, time.Time{}, false
+	}
+	return time.Unix(int64(a.stat.Atime), int64(a.stat.AtimeNsec)), time.Unix(int64(a.stat.Mtime), int64(a.stat.MtimeNsec)), true
+}
+
+// Owner returns the user and group ids.
+func (a *FileAttributes) Owner() (uid, gid int, ok bool) {
+	if !a.flags.UidGid {
+		return 0, 0, false
+	}
+	return int(a.stat.UID), int(a.stat.GID), true
+}
+
+// Extended returns the extended attributes, nil if the client set none.
+func (a *FileAttributes) Extended() []StatExtended {
+	return a.stat.Extended
+}
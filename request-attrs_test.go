@@ -1,10 +1,12 @@
 package sftp
 
 import (
+	"context"
 	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRequestPflags(t *testing.T) {
@@ -56,3 +58,34 @@ func TestRequestAttributesEmpty(t *testing.T) {
 	}, fs)
 	assert.Empty(t, b)
 }
+
+func TestRequestDecodeAttributes(t *testing.T) {
+	at := marshalUint32(nil, 0o700)
+	at = marshalUint32(at, 1)
+	at = marshalUint32(at, 2)
+	pkt := &sshFxpMkdirPacket{
+		ID:    1,
+		Path:  "/dir",
+		Flags: sshFileXferAttrPermissions | sshFileXferAttrACmodTime,
+		Attrs: at,
+	}
+	b, err := pkt.MarshalBinary()
+	require.NoError(t, err)
+	var got sshFxpMkdirPacket
+	require.NoError(t, got.UnmarshalBinary(b[4+1:]))
+
+	r := requestFromPacket(context.Background(), &got)
+	flags, attrs, err := r.DecodeAttributes()
+	require.NoError(t, err)
+	assert.Equal(t, FileAttrFlags{Permissions: true, Acmodtime: true}, flags)
+	assert.Equal(t, &FileStat{Mode: 0o700, Atime: 1, Mtime: 2}, attrs)
+
+	r.Attrs = r.Attrs[:8]
+	_, _, err = r.DecodeAttributes()
+	assert.Equal(t, errShortPacket, err)
+
+	r.Flags = sshFileXferAttrExtended
+	r.Attrs = marshalUint32(nil, 1<<30)
+	_, _, err = r.DecodeAttributes()
+	assert.Equal(t, errShortPacket, err, "more extensions than would fit")
+}
@@ -15,6 +15,10 @@ const (
 	ErrSSHFxNoConnection     = fxerr(sshFxNoConnection)
 	ErrSSHFxConnectionLost   = fxerr(sshFxConnectionLost)
 	ErrSSHFxOpUnsupported    = fxerr(sshFxOPUnsupported)
+
+	// byte range locking, see Locker
+	ErrSSHFxByteRangeLockConflict   = fxerr(sshFxByteRangeLockConflict)
+	ErrSSHFxNoMatchingByteRangeLock = fxerr(sshFxNoMatchingByteRangeLock)
 )
 
 // Deprecated error types, these are aliases for the new ones, please use the new ones directly
@@ -48,6 +52,10 @@ func (e fxerr) Error() string {
 		return "connection lost"
 	case ErrSSHFxOpUnsupported:
 		return "operation unsupported"
+	case ErrSSHFxByteRangeLockConflict:
+		return "byte range lock conflict"
+	case ErrSSHFxNoMatchingByteRangeLock:
+		return "no matching byte range lock"
 	default:
 		return "failure"
 	}
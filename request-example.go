@@ -63,6 +63,15 @@ func (fs *root) OpenFile(r *Request) (WriterAtReaderAt, error) {
 	return fs.openfile(r.Filepath, r.Flags)
 }
 
+// Lock and Unlock implement Locker for the open handle r.
+func (fs *root) Lock(r *Request, offset, length int64, exclusive bool) error {
+	return fs.locks.lock(r.Filepath, r, offset, length, exclusive)
+}
+
+func (fs *root) Unlock(r *Request, offset, length int64) error {
+	return fs.locks.unlock(r.Filepath, r, offset, length)
+}
+
 func (fs *root) putfile(pathname string, file *memFile) error {
 	pathname, err := fs.canonName(pathname)
 	if err != nil {
@@ -157,8 +166,12 @@ func (fs *root) Filecmd(r *Request) error {
 			return err
 		}
 
-		if r.AttrFlags().Size {
-			return file.Truncate(int64(r.Attributes().Size))
+		flags, attrs, err := r.DecodeAttributes()
+		if err != nil {
+			return err
+		}
+		if flags.Size {
+			return file.Truncate(int64(attrs.Size))
 		}
 
 		return nil
@@ -183,9 +196,6 @@ func (fs *root) Filecmd(r *Request) error {
 	case "Mkdir":
 		return fs.mkdir(r.Filepath)
 
-	case "Link":
-		return fs.link(r.Filepath, r.Target)
-
 	case "Symlink":
 		// NOTE: r.Filepath is the target, and r.Target is the linkpath.
 		return fs.symlink(r.Filepath, r.Target)
@@ -267,6 +277,18 @@ func (fs *root) PosixRename(r *Request) error {
 	return fs.rename(r.Filepath, r.Target)
 }
 
+func (fs *root) Link(r *Request) error {
+	if fs.mockErr != nil {
+		return fs.mockErr
+	}
+	_ = r.WithContext(r.Context()) // initialize context for deadlock testing
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.link(r.Filepath, r.Target)
+}
+
 func (fs *root) StatVFS(r *Request) (*StatVFS, error) {
 	if fs.mockErr != nil {
 		return nil, fs.mockErr
@@ -481,6 +503,8 @@ type root struct {
 
 	mu    sync.Mutex
 	files map[string]*memFile
+
+	locks rangeLocks
 }
 
 // Set a mocked error that the next handler call will return.
@@ -641,6 +665,11 @@ func (f *memFile) WriteAt(b []byte, off int64) (int, error) {
 	return copy(f.content[off:], b), nil
 }
 
+// Sync is a no-op, there is no stable storage to flush to.
+func (f *memFile) Sync() error {
+	return nil
+}
+
 func (f *memFile) Truncate(size int64) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
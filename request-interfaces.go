@@ -3,6 +3,7 @@ package sftp
 import (
 	"io"
 	"io/fs"
+	"time"
 )
 
 // WriterAtReaderAt defines the interface to return when a file is to
@@ -50,19 +51,59 @@ type OpenFileWriter interface {
 
 // FileCmder should return an error
 // Note in cases of an error, the error text will be sent to the client.
-// Called for Methods: Setstat, Rename, Rmdir, Mkdir, Link, Symlink, Remove
+// Called for Methods: Setstat, Rename, Rmdir, Mkdir, Symlink, Remove
 type FileCmder interface {
 	Filecmd(*Request) error
 }
 
 // PosixRenameFileCmder is a FileCmder that implements the PosixRename method.
-// If this interface is implemented PosixRename requests will call it
-// otherwise they will be handled in the same way as Rename
+// If this interface is implemented posix-rename@openssh.com requests will call it
+// otherwise they will be handled in the same way as Rename.
+// Unlike Rename, PosixRename is expected to atomically replace an existing
+// Request.Target, as rename(2) does.
 type PosixRenameFileCmder interface {
 	FileCmder
 	PosixRename(*Request) error
 }
 
+// Linker is a FileCmder that implements the Link method.
+// You need to implement this interface if you want to handle
+// hardlink@openssh.com requests, otherwise they are answered with op unsupported.
+// Request.Filepath is the existing file and Request.Target the new link.
+type Linker interface {
+	FileCmder
+	Link(*Request) error
+}
+
+// Truncater, Chmodder, Chtimeser and Chowner are FileCmders that set single
+// attributes of Request.Filepath. If a FileCmder implements the interfaces
+// for all the attributes a Setstat request sets, they are called in that
+// order, stopping at the first error, instead of Filecmd. Otherwise, and for
+// requests with extended attributes, Filecmd handles Setstat as before.
+type Truncater interface {
+	FileCmder
+	Truncate(r *Request, size int64) error
+}
+
+// Chmodder is a FileCmder that sets the permissions, see Truncater.
+type Chmodder interface {
+	FileCmder
+	Chmod(r *Request, mode fs.FileMode) error
+}
+
+// Chtimeser is a FileCmder that sets the access and modification times,
+// see Truncater.
+type Chtimeser interface {
+	FileCmder
+	Chtimes(r *Request, atime, mtime time.Time) error
+}
+
+// Chowner is a FileCmder that sets the user and group ids, see Truncater.
+type Chowner interface {
+	FileCmder
+	Chown(r *Request, uid, gid int) error
+}
+
 // StatVFSFileCmder is a FileCmder that implements the StatVFS method.
 // You need to implement this interface if you want to handle statvfs requests.
 // Please also be sure that the statvfs@openssh.com extension is enabled
@@ -86,6 +127,25 @@ type LstatFileLister interface {
 	Lstat(*Request) (ListerAt, error)
 }
 
+// FstatFileLister is a FileLister that implements the Fstat method.
+// If this interface is implemented Fstat requests will call it with the
+// Filepath and Flags of the open handle, see Request.Handle,
+// otherwise they will be handled in the same way as Stat.
+type FstatFileLister interface {
+	FileLister
+	Fstat(*Request) (ListerAt, error)
+}
+
+// ReadlinkFileLister is a FileLister that implements the Readlink method.
+// If this interface is implemented Readlink requests will call it with the
+// path of the link and answer with the target it returns, otherwise they
+// will be handled by Filelist, taking the Name of the only entry listed as
+// the target.
+type ReadlinkFileLister interface {
+	FileLister
+	Readlink(string) (string, error)
+}
+
 // RealPathFileLister is a FileLister that implements the Realpath method.
 // We use "/" as start directory for relative paths, implementing this
 // interface you can customize the start directory.
@@ -103,6 +163,14 @@ type NameLookupFileLister interface {
 	LookupGroupName(string) string
 }
 
+// DirStatsFileLister is a FileLister that implements the DirStats method.
+// If this interface is implemented dir-stats requests will call it
+// otherwise every directory below Request.Filepath is listed with Filelist.
+type DirStatsFileLister interface {
+	FileLister
+	DirStats(*Request) (*DirStats, error)
+}
+
 // ListerAt does for file lists what io.ReaderAt does for files.
 // ListAt should return the number of entries copied and an io.EOF
 // error if at end of list. This is testable by comparing how many you
@@ -113,6 +181,45 @@ type ListerAt interface {
 	ListAt([]fs.FileInfo, int64) (int, error)
 }
 
+// PagedListerAt is a ListerAt which lists a directory page by page, each
+// page continuing from a cursor of its own, like the continuation tokens of
+// object stores. The RequestServer answers the readdir requests of a List
+// with ListPage then, so that listings of millions of entries are streamed
+// rather than held in memory, and need no offsets the backend cannot seek to.
+// ListAt is still used for Stat and Readlink, with offset 0.
+//
+// ListPage copies the entries following cursor into ls, "" for the first
+// page, and returns how many it copied along with the cursor of the next
+// page. It returns io.EOF with the last page, or with no entries after it.
+// If the PagedListerAt is an io.Closer it is closed with the handle.
+type PagedListerAt interface {
+	ListerAt
+	ListPage(ls []fs.FileInfo, cursor string) (n int, next string, err error)
+}
+
+// FileSyncer is an optional interface that writerAt can implement
+// to handle fsync@openssh.com requests for its handle, like *os.File does.
+// If it is not implemented these requests are answered with op unsupported.
+type FileSyncer interface {
+	Sync() error
+}
+
+// Locker is an optional interface for the FilePut or FileGet handler,
+// to place advisory byte range locks for File.Lock and File.Unlock.
+// The lock is held by r, the Request of the open handle; exclusive locks
+// conflict with all locks of other Requests, shared locks with exclusive
+// ones only. A length of 0 reaches up to the end of the file.
+// Lock should return ErrSSHFxByteRangeLockConflict on conflicts.
+//
+// Unlock releases the lock on exactly this range, or returns
+// ErrSSHFxNoMatchingByteRangeLock. With an offset and length of 0 it releases
+// all locks of r, which is how the RequestServer releases them on close.
+// If it is not implemented these requests are answered with op unsupported.
+type Locker interface {
+	Lock(r *Request, offset, length int64, exclusive bool) error
+	Unlock(r *Request, offset, length int64) error
+}
+
 // TransferError is an optional interface that readerAt and writerAt
 // can implement to be notified about the error causing Serve() to exit
 // with the request still open
@@ -7,7 +7,9 @@ import (
 	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 )
 
 var maxTxPacket uint32 = 1 << 15
@@ -30,6 +32,18 @@ type RequestServer struct {
 	mu           sync.RWMutex
 	handleCount  int
 	openRequests map[string]*Request
+	features     *featureTracker
+	strictPaths  bool
+	limiter      RequestLimiter
+	logger       Logger
+	metrics      MetricsCollector
+	trace        *sessionTrace
+	audit        *auditLog
+	root         *rootDir
+	specialFiles SpecialFilePolicy
+	handles      *handleTracker
+
+	startDirectory string // of relative paths, "/" if not set
 }
 
 // A RequestServerOption is a function which applies configuration to a RequestServer.
@@ -47,6 +61,42 @@ func WithRSAllocator() RequestServerOption {
 	}
 }
 
+// WithRSStrictPaths rejects requests with a path climbing above the root
+// with "..", or containing a NUL byte, with permission denied before they
+// reach any handler. Realpath requests are exempt, as clients resolve ".."
+// with them to change directories.
+//
+// Request.Filepath and Request.Target are always clean absolute paths.
+func WithRSStrictPaths() RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.strictPaths = true
+	}
+}
+
+// WithRSStartDirectory sets the directory the sessions of the RequestServer
+// start in, like the home directory of the user: Realpath of "." resolves to
+// it rather than to "/", and the relative paths of requests are taken as
+// relative to it. RealPathFileListers get the paths of Realpath requests
+// made absolute the same way.
+//
+// The start directory is a path as the client sees it, below the root set
+// with WithRSRootDirectory if any.
+func WithRSStartDirectory(dir string) RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.startDirectory = cleanPath(dir)
+	}
+}
+
+// WithRSCompression offers the given compression algorithms, in order of
+// preference, to clients of this package. By default zstd is offered,
+// calling WithRSCompression without any algorithms turns compression off.
+// See Compressor.
+func WithRSCompression(compressors ...Compressor) RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.compression.offered = compressors
+	}
+}
+
 // NewRequestServer creates/allocates/returns new RequestServer.
 // Normally there will be one server per user-session.
 func NewRequestServer(rwc io.ReadWriteCloser, h Handlers, options ...RequestServerOption) *RequestServer {
@@ -54,7 +104,9 @@ func NewRequestServer(rwc io.ReadWriteCloser, h Handlers, options ...RequestServ
 		conn: conn{
 			Reader:      rwc,
 			WriteCloser: rwc,
+			compression: compression{offered: defaultCompressors()},
 		},
+		stats: newSessionStats(),
 	}
 	rs := &RequestServer{
 		Handlers: h,
@@ -63,6 +115,7 @@ func NewRequestServer(rwc io.ReadWriteCloser, h Handlers, options ...RequestServ
 		pktMgr:     newPktMgr(svrConn),
 
 		openRequests: make(map[string]*Request),
+		features:     newFeatureTracker(),
 	}
 
 	for _, o := range options {
@@ -71,6 +124,12 @@ func NewRequestServer(rwc io.ReadWriteCloser, h Handlers, options ...RequestServ
 	return rs
 }
 
+// FeatureUsage returns the packet types and extensions the client has
+// requested so far in this session.
+func (rs *RequestServer) FeatureUsage() FeatureUsage {
+	return rs.features.snapshot()
+}
+
 // New Open packet/Request
 func (rs *RequestServer) nextRequest(r *Request) string {
 	rs.mu.Lock()
@@ -80,6 +139,10 @@ func (rs *RequestServer) nextRequest(r *Request) string {
 
 	r.handle = strconv.Itoa(rs.handleCount)
 	rs.openRequests[r.handle] = r
+	rs.handles.touch(r.handle)
+	if rs.metrics != nil {
+		rs.metrics.AddOpenHandles(1)
+	}
 
 	return r.handle
 }
@@ -94,6 +157,9 @@ func (rs *RequestServer) getRequest(handle string) (*Request, bool) {
 	defer rs.mu.RUnlock()
 
 	r, ok := rs.openRequests[handle]
+	if ok {
+		rs.handles.touch(handle)
+	}
 	return r, ok
 }
 
@@ -104,6 +170,11 @@ func (rs *RequestServer) closeRequest(handle string) error {
 
 	if r, ok := rs.openRequests[handle]; ok {
 		delete(rs.openRequests, handle)
+		rs.handles.forget(handle)
+		if rs.metrics != nil {
+			rs.metrics.AddOpenHandles(-1)
+		}
+		rs.releaseLocks(r)
 		return r.close()
 	}
 
@@ -128,7 +199,8 @@ func (rs *RequestServer) serveLoop(pktChan chan<- orderedRequest) error {
 			return err
 		}
 
-		pkt, err = makePacket(rxPacket{fxp(pktType), pktBytes})
+		pkt, err = rs.protocol.makePacket(rxPacket{fxp(pktType), pktBytes})
+		rs.features.record(fxp(pktType), pkt)
 		if err != nil {
 			switch {
 			case errors.Is(err, errUnknownExtendedPacket):
@@ -155,6 +227,13 @@ func (rs *RequestServer) Serve() error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if rs.trace != nil {
+		rs.trace.begin("sftp.RequestServer")
+	}
+	if rs.handles != nil {
+		go rs.handles.reap(ctx.Done(), rs.reapRequest)
+	}
+
 	var wg sync.WaitGroup
 	runWorker := func(ch chan orderedRequest) {
 		wg.Add(1)
@@ -170,6 +249,7 @@ func (rs *RequestServer) Serve() error {
 	err := rs.serveLoop(pktChan)
 
 	wg.Wait() // wait for all workers to exit
+	rs.trace.end(sessionError(err))
 
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
@@ -183,8 +263,17 @@ func (rs *RequestServer) Serve() error {
 		req.transferError(err)
 
 		delete(rs.openRequests, handle)
+		if rs.metrics != nil {
+			rs.metrics.AddOpenHandles(-1)
+		}
+		rs.releaseLocks(req)
 		req.close()
 	}
+	rs.audit.abandon()
+
+	usage := rs.features.snapshot()
+	debug("sftp request server session features used: %v", usage)
+	reportFeatureUsage(rs.logger, rs.metrics, usage)
 
 	return err
 }
@@ -192,28 +281,69 @@ func (rs *RequestServer) Serve() error {
 func (rs *RequestServer) packetWorker(ctx context.Context, pktChan chan orderedRequest) error {
 	for pkt := range pktChan {
 		orderID := pkt.orderID()
+		var logged loggedRequest
+		if rs.observing() {
+			logged = newLoggedRequest(pkt.requestPacket)
+			logged.span = rs.trace.startSpan(logged.ev.Packet)
+		}
 		if epkt, ok := pkt.requestPacket.(*sshFxpExtendedPacket); ok {
 			if epkt.SpecificPacket != nil {
 				pkt.requestPacket = epkt.SpecificPacket
 			}
 		}
 
+		if err := rs.pktMgr.refuses(pkt.requestPacket); err != nil {
+			rs.ready(statusFromError(pkt.id(), err), orderID, logged)
+			continue
+		}
+
+		if rs.startDirectory != "" {
+			pkt.requestPacket = rs.absolutePaths(pkt.requestPacket)
+		}
+
+		if rs.strictPaths {
+			if err := checkStrictPaths(pkt.requestPacket); err != nil {
+				rs.ready(statusFromError(pkt.id(), err), orderID, logged)
+				continue
+			}
+		}
+
+		if rs.root != nil {
+			confined, err := rs.root.confine(pkt.requestPacket)
+			if err != nil {
+				rs.ready(statusFromError(pkt.id(), err), orderID, logged)
+				continue
+			}
+			pkt.requestPacket = confined
+		}
+
+		if rs.limiter != nil {
+			if err := rs.limit(pkt.requestPacket); err != nil {
+				rs.ready(statusFromError(pkt.id(), err), orderID, logged)
+				continue
+			}
+		}
+
 		var rpkt responsePacket
 		switch pkt := pkt.requestPacket.(type) {
 		case *sshFxInitPacket:
-			rpkt = &sshFxVersionPacket{Version: sftpProtocolVersion, Extensions: sftpExtensions}
+			rpkt = &sshFxVersionPacket{Version: rs.protocol.negotiated(), Extensions: rs.compression.extensions(rs.extensions())}
 		case *sshFxpClosePacket:
 			handle := pkt.getHandle()
 			rpkt = statusFromError(pkt.ID, rs.closeRequest(handle))
 		case *sshFxpRealpathPacket:
 			var realPath string
 			if realPather, ok := rs.Handlers.FileList.(RealPathFileLister); ok {
-				realPath = realPather.RealPath(pkt.getPath())
+				realPath = realPather.RealPath(rs.absolutePath(pkt.getPath()))
 			} else {
-				realPath = cleanPath(pkt.getPath())
+				realPath = cleanPath(rs.absolutePath(pkt.getPath()))
 			}
 			rpkt = cleanPacketPath(pkt, realPath)
 		case *sshFxpOpendirPacket:
+			if rs.handlesFull() {
+				rpkt = statusFromError(pkt.ID, errTooManyHandles)
+				break
+			}
 			request := requestFromPacket(ctx, pkt)
 			handle := rs.nextRequest(request)
 			rpkt = request.opendir(rs.Handlers, pkt)
@@ -222,12 +352,23 @@ func (rs *RequestServer) packetWorker(ctx context.Context, pktChan chan orderedR
 				rs.closeRequest(handle)
 			}
 		case *sshFxpOpenPacket:
+			if rs.handlesFull() {
+				rpkt = statusFromError(pkt.ID, errTooManyHandles)
+				break
+			}
 			request := requestFromPacket(ctx, pkt)
+			refused, streamed := rs.specialFile(request)
+			if refused {
+				rpkt = refuseSpecialFile(pkt.ID)
+				break
+			}
 			handle := rs.nextRequest(request)
 			rpkt = request.open(rs.Handlers, pkt)
 			if _, ok := rpkt.(*sshFxpHandlePacket); !ok {
 				// if we return an error we have to remove the handle from the active ones
 				rs.closeRequest(handle)
+			} else if streamed {
+				request.state.stream()
 			}
 		case *sshFxpFstatPacket:
 			handle := pkt.getHandle()
@@ -235,8 +376,10 @@ func (rs *RequestServer) packetWorker(ctx context.Context, pktChan chan orderedR
 			if !ok {
 				rpkt = statusFromError(pkt.ID, EBADF)
 			} else {
-				request = NewRequest("Stat", request.Filepath)
-				rpkt = request.call(rs.Handlers, pkt, rs.pktMgr.alloc, orderID)
+				fstat := NewRequest("Fstat", request.Filepath)
+				fstat.Flags = request.Flags
+				fstat.handle = handle
+				rpkt = fstat.call(rs.Handlers, pkt, rs.pktMgr.alloc, orderID)
 			}
 		case *sshFxpFsetstatPacket:
 			handle := pkt.getHandle()
@@ -247,13 +390,62 @@ func (rs *RequestServer) packetWorker(ctx context.Context, pktChan chan orderedR
 				request = NewRequest("Setstat", request.Filepath)
 				rpkt = request.call(rs.Handlers, pkt, rs.pktMgr.alloc, orderID)
 			}
-		case *sshFxpExtendedPacketPosixRename:
-			request := NewRequest("PosixRename", pkt.Oldpath)
-			request.Target = pkt.Newpath
-			rpkt = request.call(rs.Handlers, pkt, rs.pktMgr.alloc, orderID)
 		case *sshFxpExtendedPacketStatVFS:
 			request := NewRequest("StatVFS", pkt.Path)
 			rpkt = request.call(rs.Handlers, pkt, rs.pktMgr.alloc, orderID)
+		case *sshFxpExtendedPacketFsync:
+			request, ok := rs.getRequest(pkt.Handle)
+			if !ok {
+				rpkt = statusFromError(pkt.ID, EBADF)
+			} else {
+				rpkt = statusFromError(pkt.ID, request.sync())
+			}
+		case *sshFxpExtendedPacketCopyData:
+			rpkt = rs.copyData(pkt)
+		case *sshFxpExtendedPacketBlock:
+			rpkt = rs.lock(pkt)
+		case *sshFxpExtendedPacketUnblock:
+			rpkt = rs.unlock(pkt)
+		case *sshFxpExtendedPacketCheckFile:
+			rpkt = rs.checkFile(pkt)
+		case *sshFxpExtendedPacketDirStats:
+			rpkt = rs.dirStats(pkt)
+		case *sshFxpExtendedPacketExpandPath:
+			// there are no home directories, handlers only see virtual paths
+			rpkt = statusFromError(pkt.ID, ErrSSHFxOpUnsupported)
+		case *sshFxpExtendedPacketDiskUsage:
+			// the handlers cannot tell the allocated sizes, so clients walk the tree
+			rpkt = statusFromError(pkt.ID, ErrSSHFxOpUnsupported)
+		case *sshFxpExtendedPacketGlob:
+			// clients list the directories through the handlers themselves
+			rpkt = statusFromError(pkt.ID, ErrSSHFxOpUnsupported)
+		case *sshFxpExtendedPacketSpaceAvailable:
+			// clients fall back to statvfs, which the handlers may answer
+			rpkt = statusFromError(pkt.ID, ErrSSHFxOpUnsupported)
+		case *sshFxpExtendedPacketAllocate:
+			// clients fall back to growing the file with a setstat
+			rpkt = statusFromError(pkt.ID, ErrSSHFxOpUnsupported)
+		case *sshFxpExtendedPacketTraceContext:
+			rs.trace.adopt(pkt.Carrier)
+			rpkt = statusFromError(pkt.ID, nil)
+		case *sshFxpExtendedPacketAccess:
+			// the handlers cannot tell, so clients fall back to the permissions
+			rpkt = statusFromError(pkt.ID, ErrSSHFxOpUnsupported)
+		case *sshFxpExtendedPacketGetACL, *sshFxpExtendedPacketSetACL:
+			// the handlers have no access control lists
+			rpkt = statusFromError(pkt.id(), ErrSSHFxOpUnsupported)
+		case *sshFxpExtendedPacketUsersGroupsByID:
+			if lookup, ok := rs.Handlers.FileList.(NameLookupFileLister); ok {
+				rpkt = usersGroupsByID(pkt, lookup)
+			} else {
+				rpkt = statusFromError(pkt.ID, ErrSSHFxOpUnsupported)
+			}
+		case *sshFxpExtendedPacketLimits:
+			rpkt = &sshFxpLimitsReply{ID: pkt.ID, limits: serverLimits()}
+		case *sshFxpExtendedPacketCompression:
+			rpkt = statusFromError(pkt.ID, rs.compression.enable(pkt.Algorithm))
+		case *refusedRequest:
+			rpkt = statusFromError(pkt.ID, pkt.err)
 		case hasHandle:
 			handle := pkt.getHandle()
 			request, ok := rs.getRequest(handle)
@@ -270,12 +462,23 @@ func (rs *RequestServer) packetWorker(ctx context.Context, pktChan chan orderedR
 			rpkt = statusFromError(pkt.id(), ErrSSHFxOpUnsupported)
 		}
 
-		rs.pktMgr.readyPacket(
-			rs.pktMgr.newOrderedResponse(rpkt, orderID))
+		rs.ready(rpkt, orderID, logged)
 	}
 	return nil
 }
 
+// ready queues rpkt as the response to the request of orderID,
+// logging and measuring the request first if asked to.
+func (rs *RequestServer) ready(rpkt responsePacket, orderID uint32, logged loggedRequest) {
+	if rs.observing() {
+		ev := logged.served(rpkt)
+		observe(rs.logger, rs.metrics, logged.span, ev)
+		rs.audit.served(logged, ev, rpkt)
+	}
+	rs.pktMgr.readyPacket(
+		rs.pktMgr.newOrderedResponse(rpkt, orderID))
+}
+
 // clean and return name packet for file
 func cleanPacketPath(pkt *sshFxpRealpathPacket, realPath string) responsePacket {
 	return &sshFxpNamePacket{
@@ -295,6 +498,110 @@ func cleanPath(p string) string {
 	return cleanPathWithBase("/", p)
 }
 
+// extensions returns the extensions of sftpExtensions the Handlers can serve,
+// for the version reply.
+func (rs *RequestServer) extensions() []sshExtensionPair {
+	exts := make([]sshExtensionPair, 0, len(sftpExtensions))
+	for _, ext := range sftpExtensions {
+		if rs.serves(ext.Name) {
+			exts = append(exts, ext)
+		}
+	}
+	return exts
+}
+
+// serves reports whether the Handlers can serve the extension name. Those
+// working on a file system rather than on requests are never served.
+func (rs *RequestServer) serves(name string) bool {
+	switch name {
+	case expandPathExtension, diskUsageExtension, globExtension,
+		spaceAvailableExtension, allocateExtension, accessExtension,
+		getACLExtension, setACLExtension:
+		return false
+	case blockExtension, unblockExtension:
+		_, ok := rs.locker()
+		return ok
+	case usersGroupsByIDExtension:
+		_, ok := rs.Handlers.FileList.(NameLookupFileLister)
+		return ok
+	case "statvfs@openssh.com":
+		_, ok := rs.Handlers.FileCmd.(StatVFSFileCmder)
+		return ok
+	}
+	return true
+}
+
+// absolutePath returns p relative to the start directory, if it is relative
+// and WithRSStartDirectory is set. The ".." elements of p are kept, for
+// WithRSStrictPaths to check.
+func (rs *RequestServer) absolutePath(p string) string {
+	if rs.startDirectory == "" || path.IsAbs(filepath.ToSlash(p)) {
+		return p
+	}
+	return rs.startDirectory + "/" + p
+}
+
+// absolutePaths returns pkt with its relative paths made absolute,
+// see absolutePath.
+func (rs *RequestServer) absolutePaths(pkt requestPacket) requestPacket {
+	return mapPaths(pkt, func(name string, _ bool) string {
+		return rs.absolutePath(name)
+	})
+}
+
+// checkStrictPaths returns EPERM, if a path of pkt is not
+// acceptable for WithRSStrictPaths.
+func checkStrictPaths(pkt requestPacket) error {
+	var paths []string
+	switch p := pkt.(type) {
+	case *sshFxpRealpathPacket:
+		return nil
+	case *sshFxpRenamePacket:
+		paths = append(paths, p.Newpath)
+	case *sshFxpSymlinkPacket:
+		paths = append(paths, p.Linkpath)
+	case *sshFxpExtendedPacketPosixRename:
+		paths = append(paths, p.Newpath)
+	case *sshFxpExtendedPacketHardlink:
+		paths = append(paths, p.Newpath)
+	case *sshFxpExtendedPacketStatVFS:
+		paths = append(paths, p.Path)
+	case *sshFxpExtendedPacketCheckFile:
+		paths = append(paths, p.Path)
+	case *sshFxpExtendedPacketDirStats:
+		paths = append(paths, p.Path)
+	}
+	if p, ok := pkt.(hasPath); ok {
+		paths = append(paths, p.getPath())
+	}
+
+	for _, p := range paths {
+		if strings.IndexByte(p, 0) >= 0 || escapesRoot(p) {
+			return syscall.EPERM
+		}
+	}
+	return nil
+}
+
+// escapesRoot reports whether p, taken as relative to the root,
+// climbs above it with "..".
+func escapesRoot(p string) bool {
+	depth := 0
+	for _, elem := range strings.Split(filepath.ToSlash(p), "/") {
+		switch elem {
+		case "", ".":
+		case "..":
+			if depth == 0 {
+				return true
+			}
+			depth--
+		default:
+			depth++
+		}
+	}
+	return false
+}
+
 func cleanPathWithBase(base, p string) string {
 	p = filepath.ToSlash(filepath.Clean(p))
 	if !path.IsAbs(p) {
@@ -11,11 +11,12 @@ import (
 	"os"
 	"path"
 	"runtime"
+	"strconv"
 	"syscall"
 	"testing"
 	"time"
 
-	"github.com/pkg/sftp/internal/apis"
+	"github.com/pkg/sftp/apis"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -43,7 +44,12 @@ func (cs csPair) testHandler() *root {
 
 const sock = "/tmp/rstest.sock"
 
-func clientRequestServerPair(t *testing.T) *csPair {
+func clientRequestServerPair(t *testing.T, options ...RequestServerOption) *csPair {
+	return clientRequestServerPairWith(t, options)
+}
+
+// clientRequestServerPairWith is clientRequestServerPair configuring the Client as well.
+func clientRequestServerPairWith(t *testing.T, options []RequestServerOption, clientOptions ...ClientOption) *csPair {
 	fsApi := apis.NewAVFS()
 	skipIfWindows(t)
 	skipIfPlan9(t)
@@ -69,7 +75,6 @@ func clientRequestServerPair(t *testing.T) *csPair {
 		require.NoError(t, err)
 
 		handlers := InMemHandler()
-		var options []RequestServerOption
 		if *testAllocator {
 			options = append(options, WithRSAllocator())
 		}
@@ -87,7 +92,7 @@ func clientRequestServerPair(t *testing.T) *csPair {
 	c, err := net.Dial("unix", sock)
 	require.NoError(t, err)
 
-	client, err := NewClientPipe(c, c)
+	client, err := NewClientPipe(c, c, clientOptions...)
 	if err != nil {
 		t.Fatalf("unexpected error: %+v", err)
 	}
@@ -98,6 +103,28 @@ func clientRequestServerPair(t *testing.T) *csPair {
 	return pair
 }
 
+// clientRequestServerPipe connects a Client to a RequestServer of handlers
+// over pipes, rather than the socket of clientRequestServerPair.
+// The caller closes both.
+func clientRequestServerPipe(t testing.TB, handlers Handlers, options []RequestServerOption, clientOptions ...ClientOption) (*Client, *RequestServer) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	options = options[:len(options):len(options)]
+	if *testAllocator {
+		options = append(options, WithRSAllocator())
+	}
+	server := NewRequestServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw}, handlers, options...)
+	serve(server)
+	client, err := NewClientPipe(cr, cw, clientOptions...)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	return client, server
+}
+
 func checkRequestServerAllocator(t *testing.T, p *csPair) {
 	if p.svr.pktMgr.alloc == nil {
 		return
@@ -254,11 +281,11 @@ func TestRequestJustRead(t *testing.T) {
 }
 
 func TestRequestOpenFail(t *testing.T) {
-	p := clientRequestServerPair(t)
+	p := clientRequestServerPair(t, WithRSVerboseStatus())
 	defer p.Close()
 	rf, err := p.cli.Open("/foo")
-	assert.Exactly(t, &StatusError{Code: sshFxFailure,
-		msg: "file does not exist"}, err)
+	assert.Exactly(t, &fs.PathError{Op: "open", Path: "/foo", Err: &StatusError{Code: sshFxFailure,
+		msg: "Failure: file does not exist", lang: "en"}}, err)
 	assert.Nil(t, rf)
 	// if we return an error the sftp client will not close the handle
 	// ensure that we close it ourself
@@ -437,7 +464,29 @@ func TestRequestRenameFail(t *testing.T) {
 	_, err = putTestFile(p.cli, "/bar", "goodbye")
 	require.NoError(t, err)
 	err = p.cli.Rename("/foo", "/bar")
-	assert.IsType(t, &StatusError{}, err)
+	var status *StatusError
+	assert.True(t, errors.As(err, &status), "got %v", err)
+	checkRequestServerAllocator(t, p)
+}
+
+func TestRequestPosixRenameCleanTarget(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+	_, err := putTestFile(p.cli, "/foo", "hello")
+	require.NoError(t, err)
+	_, err = putTestFile(p.cli, "/bar", "goodbye")
+	require.NoError(t, err)
+
+	// the target is cleaned the same way as for Rename
+	err = p.cli.PosixRename("/foo", "dir/../bar")
+	require.NoError(t, err)
+
+	content, err := getTestFile(p.cli, "/bar")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), content)
+
+	_, err = getTestFile(p.cli, "/foo")
+	assert.Error(t, err)
 	checkRequestServerAllocator(t, p)
 }
 
@@ -727,7 +776,7 @@ func TestRequestReadlink(t *testing.T) {
 }
 
 func TestRequestReaddir(t *testing.T) {
-	p := clientRequestServerPair(t)
+	p := clientRequestServerPair(t, WithRSVerboseStatus())
 	MaxFilelist = 22 // make not divisible by our test amount (100)
 	defer p.Close()
 	for i := 0; i < 100; i++ {
@@ -738,11 +787,11 @@ func TestRequestReaddir(t *testing.T) {
 		}
 	}
 	_, err := p.cli.ReadDir("/foo_01")
-	assert.Equal(t, &StatusError{Code: sshFxFailure,
-		msg: " /foo_01: not a directory"}, err)
+	assert.Equal(t, &fs.PathError{Op: "readdir", Path: "/foo_01", Err: &StatusError{Code: sshFxFailure,
+		msg: "Failure:  /foo_01: not a directory", lang: "en"}}, err)
 	_, err = p.cli.ReadDir("/does_not_exist")
-	assert.Equal(t, &StatusError{Code: sshFxFailure,
-		msg: "file does not exist"}, err)
+	assert.Equal(t, &fs.PathError{Op: "readdir", Path: "/does_not_exist", Err: &StatusError{Code: sshFxFailure,
+		msg: "Failure: file does not exist", lang: "en"}}, err)
 	di, err := p.cli.ReadDir("/")
 	require.NoError(t, err)
 	require.Len(t, di, 100)
@@ -790,6 +839,67 @@ func TestRequestStatVFSError(t *testing.T) {
 	checkRequestServerAllocator(t, p)
 }
 
+func TestRequestServerExtensions(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+
+	for _, name := range []string{
+		"block@github.com/pkg/sftp",
+		"hardlink@openssh.com",
+		"posix-rename@openssh.com",
+		"statvfs@openssh.com",
+	} {
+		_, ok := p.cli.HasExtension(name)
+		assert.True(t, ok, "request server doesn't list %s", name)
+	}
+	for _, name := range []string{
+		"access@github.com/pkg/sftp",
+		"allocate@github.com/pkg/sftp",
+		"disk-usage@github.com/pkg/sftp",
+		"expand-path@openssh.com",
+		"getacl@github.com/pkg/sftp",
+		"glob@github.com/pkg/sftp",
+		"setacl@github.com/pkg/sftp",
+		"space-available",
+		"users-groups-by-id@openssh.com",
+	} {
+		_, ok := p.cli.HasExtension(name)
+		assert.False(t, ok, "request server lists %s", name)
+	}
+}
+
+func TestRequestServerExtensionsHandlers(t *testing.T) {
+	handlers := InMemHandler()
+	handlers.FileCmd = struct{ FileCmder }{handlers.FileCmd}
+	handlers.FileGet = struct{ FileReader }{handlers.FileGet}
+	handlers.FilePut = struct{ FileWriter }{handlers.FilePut}
+	rs := NewRequestServer(nil, handlers)
+
+	for _, ext := range rs.extensions() {
+		switch ext.Name {
+		case "statvfs@openssh.com", blockExtension, unblockExtension:
+			t.Errorf("request server lists %s without a handler for it", ext.Name)
+		}
+	}
+}
+
+func TestRequestFsync(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+
+	_, ok := p.cli.HasExtension("fsync@openssh.com")
+	require.True(t, ok, "request server doesn't list fsync extension")
+
+	f, err := p.cli.Create("/foo")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("flush me"))
+	require.NoError(t, err)
+	assert.NoError(t, f.Sync())
+	require.NoError(t, f.Close())
+
+	checkRequestServerAllocator(t, p)
+}
+
 func TestCleanDisconnect(t *testing.T) {
 	p := clientRequestServerPair(t)
 	defer p.Close()
@@ -837,6 +947,53 @@ func TestRealPath(t *testing.T) {
 	assert.Equal(t, path.Join(root.startDirectory, "relpath"), p)
 }
 
+func TestEscapesRoot(t *testing.T) {
+	for p, want := range map[string]bool{
+		"":             false,
+		"/":            false,
+		"/a/../b":      false,
+		"a/b/../..":    false,
+		"/..":          true,
+		"..":           true,
+		"/a/../../etc": true,
+		"./a/./../..":  true,
+		"a/..//../b":   true,
+	} {
+		assert.Equal(t, want, escapesRoot(p), p)
+	}
+}
+
+func TestRequestStrictPaths(t *testing.T) {
+	p := clientRequestServerPair(t, WithRSStrictPaths())
+	defer p.Close()
+
+	_, err := putTestFile(p.cli, "/foo", "hello")
+	require.NoError(t, err)
+
+	for _, op := range []func() error{
+		func() error { _, err := p.cli.Stat("/../foo"); return err },
+		func() error { _, err := p.cli.Open("../../foo"); return err },
+		func() error { return p.cli.Mkdir("/a/../../b") },
+		func() error { return p.cli.Rename("/foo", "/../bar") },
+		func() error { return p.cli.Symlink("/foo", "../bar") },
+		func() error { _, err := p.cli.Stat("/foo\x00"); return err },
+	} {
+		err := op()
+		assert.True(t, errors.Is(err, fs.ErrPermission), "%v", err)
+	}
+	_, err = p.cli.Stat("/bar")
+	assert.Error(t, err)
+
+	// ".." within the tree and realpath are fine
+	_, err = p.cli.Stat("/a/../foo")
+	assert.NoError(t, err)
+	wd, err := p.cli.RealPath("/..")
+	require.NoError(t, err)
+	assert.Equal(t, "/", wd)
+
+	checkRequestServerAllocator(t, p)
+}
+
 func TestCleanPath(t *testing.T) {
 	assert.Equal(t, "/", cleanPath("/"))
 	assert.Equal(t, "/", cleanPath("."))
@@ -862,3 +1019,185 @@ func TestCleanPath(t *testing.T) {
 		cleanPath(bslash+"a"+bslash+bslash+"b"+bslash+bslash+"c"+bslash))
 	assert.Equal(t, "/C:/a", cleanPath("C:"+bslash+"a"))
 }
+
+func TestRequestServerStartDirectory(t *testing.T) {
+	p := clientRequestServerPair(t, WithRSStartDirectory("/home/alice"), WithRSStrictPaths())
+	defer p.Close()
+
+	require.NoError(t, p.cli.MkdirAll("/home/alice"))
+	wd, err := p.cli.Getwd()
+	require.NoError(t, err)
+	assert.Equal(t, "/home/alice", wd)
+	rp, err := p.cli.RealPath("../bob")
+	require.NoError(t, err)
+	assert.Equal(t, "/home/bob", rp)
+
+	f, err := p.cli.Create("foo")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = p.cli.Stat("/home/alice/foo")
+	assert.NoError(t, err, "created in the start directory")
+	require.NoError(t, p.cli.Rename("foo", "../bar"))
+	_, err = p.cli.Stat("/home/bar")
+	assert.NoError(t, err)
+
+	_, err = p.cli.Stat("../../../etc")
+	assert.Error(t, err, "climbs above the root from the start directory")
+}
+
+// pagedLister lists count files, page by page.
+type pagedLister struct {
+	count  int
+	pages  int
+	closed bool
+}
+
+func (l *pagedLister) Filelist(r *Request) (ListerAt, error) {
+	if r.Method != "List" {
+		return listerat{&memFile{name: r.Filepath, isdir: true}}, nil
+	}
+	return l, nil
+}
+
+func (l *pagedLister) ListAt([]fs.FileInfo, int64) (int, error) {
+	return 0, errors.New("listed by offset")
+}
+
+func (l *pagedLister) ListPage(ls []fs.FileInfo, cursor string) (int, string, error) {
+	l.pages++
+	next := 0
+	if cursor != "" {
+		next, _ = strconv.Atoi(cursor)
+	}
+	n := 0
+	for ; n < len(ls) && next < l.count; n++ {
+		ls[n] = &memFile{name: fmt.Sprintf("/dir/f%04d", next)}
+		next++
+	}
+	if next == l.count {
+		return n, "", io.EOF
+	}
+	return n, strconv.Itoa(next), nil
+}
+
+func (l *pagedLister) Close() error {
+	l.closed = true
+	return nil
+}
+
+func TestRequestServerPagedListerAt(t *testing.T) {
+	h := &pagedLister{count: 250}
+	client, server := clientRequestServerPipe(t, Handlers{FileList: h}, nil)
+
+	infos, err := client.ReadDir("/dir")
+	require.NoError(t, err)
+	require.Len(t, infos, 250)
+	for i, fi := range infos {
+		assert.Equal(t, fmt.Sprintf("f%04d", i), fi.Name())
+	}
+	pages := (h.count + int(MaxFilelist) - 1) / int(MaxFilelist)
+	assert.Equal(t, pages, h.pages, "pages of MaxFilelist, the last one with io.EOF")
+	assert.True(t, h.closed)
+
+	server.Close()
+	client.Close()
+}
+
+// statRecorder is an in-memory FileLister telling Fstat and Readlink apart.
+type statRecorder struct {
+	*root
+	fstats []*Request
+}
+
+func (h *statRecorder) Fstat(r *Request) (ListerAt, error) {
+	h.fstats = append(h.fstats, r)
+	r.Method = "Stat"
+	return h.root.Filelist(r)
+}
+
+func (h *statRecorder) Readlink(name string) (string, error) {
+	return "target-of" + name, nil
+}
+
+func TestRequestServerFstatReadlink(t *testing.T) {
+	mem := InMemHandler()
+	h := &statRecorder{root: mem.FileList.(*root)}
+	mem.FileList = h
+
+	client, server := clientRequestServerPipe(t, mem, nil)
+
+	_, err := putTestFile(client, "/foo", "hello")
+	require.NoError(t, err)
+	f, err := client.Open("/foo")
+	require.NoError(t, err)
+	fi, err := f.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), fi.Size())
+	if assert.Len(t, h.fstats, 1) {
+		assert.Equal(t, "/foo", h.fstats[0].Filepath)
+		assert.NotEmpty(t, h.fstats[0].Handle())
+		assert.True(t, h.fstats[0].Pflags().Read)
+	}
+	require.NoError(t, f.Close())
+
+	_, err = client.Stat("/foo")
+	require.NoError(t, err)
+	assert.Len(t, h.fstats, 1, "a Stat is no Fstat")
+
+	target, err := client.ReadLink("/link")
+	require.NoError(t, err)
+	assert.Equal(t, "target-of/link", target)
+
+	server.Close()
+	client.Close()
+}
+
+type attrRecorder struct {
+	*root
+	calls []string
+}
+
+func (h *attrRecorder) Truncate(r *Request, size int64) error {
+	h.calls = append(h.calls, fmt.Sprintf("truncate %s %d", r.Filepath, size))
+	return nil
+}
+
+func (h *attrRecorder) Chmod(r *Request, mode fs.FileMode) error {
+	h.calls = append(h.calls, fmt.Sprintf("chmod %s %v", r.Filepath, mode))
+	return nil
+}
+
+func (h *attrRecorder) Chtimes(r *Request, atime, mtime time.Time) error {
+	h.calls = append(h.calls, fmt.Sprintf("chtimes %s %d %d", r.Filepath, atime.Unix(), mtime.Unix()))
+	return nil
+}
+
+func TestRequestServerSetstatInterfaces(t *testing.T) {
+	mem := InMemHandler()
+	h := &attrRecorder{root: mem.FileCmd.(*root)}
+	mem.FileCmd = h
+
+	client, server := clientRequestServerPipe(t, mem, nil)
+
+	_, err := putTestFile(client, "/foo", "hello")
+	require.NoError(t, err)
+	require.NoError(t, client.Chmod("/foo", 0o600|fs.ModeSetuid))
+	require.NoError(t, client.Chtimes("/foo", time.Unix(1, 0), time.Unix(2, 0)))
+	require.NoError(t, client.Truncate("/foo", 3))
+	f, err := client.OpenFile("/foo", os.O_WRONLY)
+	require.NoError(t, err)
+	require.NoError(t, f.Truncate(4))
+	require.NoError(t, f.Close())
+	assert.Equal(t, []string{
+		"chmod /foo urw-------",
+		"chtimes /foo 1 2",
+		"truncate /foo 3",
+		"truncate /foo 4",
+	}, h.calls)
+
+	require.NoError(t, client.Chown("/foo", 1, 2), "no Chowner, Filecmd handles it")
+	assert.Len(t, h.calls, 4)
+
+	server.Close()
+	client.Close()
+}
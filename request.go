@@ -23,6 +23,8 @@ type state struct {
 	writerAtReaderAt WriterAtReaderAt
 	listerAt         ListerAt
 	lsoffset         int64
+	lscursor         string // of the next page of a PagedListerAt
+	lsdone           bool   // the PagedListerAt returned its last page
 }
 
 // copy returns a shallow copy the state.
@@ -38,6 +40,8 @@ func (s *state) copy() state {
 		writerAtReaderAt: s.writerAtReaderAt,
 		listerAt:         s.listerAt,
 		lsoffset:         s.lsoffset,
+		lscursor:         s.lscursor,
+		lsdone:           s.lsdone,
 	}
 }
 
@@ -106,6 +110,23 @@ func (s *state) lsInc(offset int64) {
 	s.lsoffset += offset
 }
 
+// Returns the cursor of the next page, and whether there is none
+func (s *state) lsCursor() (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.lscursor, s.lsdone
+}
+
+// Sets the cursor of the next page
+func (s *state) lsSetCursor(cursor string, done bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lscursor = cursor
+	s.lsdone = done
+}
+
 // manage file read/write state
 func (s *state) setListerAt(la ListerAt) {
 	s.mu.Lock()
@@ -123,14 +144,17 @@ func (s *state) getListerAt() ListerAt {
 
 // Request contains the data and state for the incoming service request.
 type Request struct {
-	// Get, Put, Setstat, Stat, Rename, Remove
-	// Rmdir, Mkdir, List, Readlink, Link, Symlink
+	// Get, Put, Open, Setstat, Stat, Lstat, Fstat, Rename, PosixRename,
+	// Remove, Rmdir, Mkdir, List, Readlink, Link, Symlink, StatVFS
 	Method   string
 	Filepath string
 	Flags    uint32
-	Attrs    []byte // convert to sub-struct
-	Target   string // for renames and sym-links
-	handle   string
+	Attrs    []byte // see FileAttributes
+	// Target is the second path of two path requests, parsed from the
+	// packet: the new path of Rename, PosixRename and Link, and the path of
+	// the link to create for Symlink, whose Filepath is what it points to.
+	Target string
+	handle string
 
 	// reader/writer/readdir from handlers
 	state
@@ -179,6 +203,9 @@ func requestFromPacket(ctx context.Context, pkt hasPath) *Request {
 	case *sshFxpSetstatPacket:
 		request.Flags = p.Flags
 		request.Attrs = p.Attrs.([]byte)
+	case *sshFxpMkdirPacket:
+		request.Flags = p.Flags
+		request.Attrs = p.Attrs
 	case *sshFxpRenamePacket:
 		request.Target = cleanPath(p.Newpath)
 	case *sshFxpSymlinkPacket:
@@ -187,10 +214,18 @@ func requestFromPacket(ctx context.Context, pkt hasPath) *Request {
 		request.Target = cleanPath(p.Linkpath)
 	case *sshFxpExtendedPacketHardlink:
 		request.Target = cleanPath(p.Newpath)
+	case *sshFxpExtendedPacketPosixRename:
+		request.Target = cleanPath(p.Newpath)
 	}
 	return request
 }
 
+// Handle returns the handle of the file an Fstat request is about, and the
+// one a request opening a file or directory returns, "" for the others.
+func (r *Request) Handle() string {
+	return r.handle
+}
+
 // Context returns the request's context. To change the context,
 // use WithContext.
 //
@@ -218,6 +253,18 @@ func (r *Request) WithContext(ctx context.Context) *Request {
 	return r2
 }
 
+// sync flushes the writer of the handle, if it is a FileSyncer.
+func (r *Request) sync() error {
+	_, wr, rw := r.getAllReaderWriters()
+	if s, ok := rw.(FileSyncer); ok {
+		return s.Sync()
+	}
+	if s, ok := wr.(FileSyncer); ok {
+		return s.Sync()
+	}
+	return ErrSSHFxOpUnsupported
+}
+
 // Close reader/writer if possible
 func (r *Request) close() error {
 	defer func() {
@@ -255,6 +302,15 @@ func (r *Request) close() error {
 		}
 	}
 
+	if c, ok := r.getListerAt().(interface {
+		PagedListerAt
+		io.Closer
+	}); ok {
+		if err2 := c.Close(); err == nil {
+			err = err2
+		}
+	}
+
 	return err
 }
 
@@ -292,7 +348,7 @@ func (r *Request) call(handlers Handlers, pkt requestPacket, alloc *allocator, o
 		return filecmd(handlers.FileCmd, r, pkt)
 	case "List":
 		return filelist(handlers.FileList, r, pkt)
-	case "Stat", "Lstat", "Readlink":
+	case "Stat", "Lstat", "Fstat", "Readlink":
 		return filestat(handlers.FileList, r, pkt)
 	default:
 		return statusFromError(pkt.id(), fmt.Errorf("unexpected method: %s", r.Method))
@@ -373,11 +429,18 @@ func fileget(h FileReader, r *Request, pkt requestPacket, alloc *allocator, orde
 		return statusFromError(pkt.id(), errors.New("unexpected read packet"))
 	}
 
-	data, offset, _ := packetData(pkt, alloc, orderID)
+	data, offset, _, err := packetData(pkt, alloc, orderID)
+	if err != nil {
+		return statusFromError(pkt.id(), err)
+	}
 
 	n, err := rd.ReadAt(data, offset)
+	if n == 0 && err == nil {
+		// a ReaderAt may report the end of the file by reading nothing
+		err = io.EOF
+	}
 	// only return EOF error if no data left to read
-	if err != nil && (err != io.EOF || n == 0) {
+	if err != nil && (!errors.Is(err, io.EOF) || n == 0) {
 		return statusFromError(pkt.id(), err)
 	}
 
@@ -395,9 +458,12 @@ func fileput(h FileWriter, r *Request, pkt requestPacket, alloc *allocator, orde
 		return statusFromError(pkt.id(), errors.New("unexpected write packet"))
 	}
 
-	data, offset, _ := packetData(pkt, alloc, orderID)
+	data, offset, _, err := packetData(pkt, alloc, orderID)
+	if err != nil {
+		return statusFromError(pkt.id(), err)
+	}
 
-	_, err := wr.WriteAt(data, offset)
+	_, err = wr.WriteAt(data, offset)
 	return statusFromError(pkt.id(), err)
 }
 
@@ -408,13 +474,19 @@ func fileputget(h FileWriter, r *Request, pkt requestPacket, alloc *allocator, o
 		return statusFromError(pkt.id(), errors.New("unexpected write and read packet"))
 	}
 
-	switch p := pkt.(type) {
-	case *sshFxpReadPacket:
-		data, offset := p.getDataSlice(alloc, orderID), int64(p.Offset)
+	data, offset, _, err := packetData(pkt, alloc, orderID)
+	if err != nil {
+		return statusFromError(pkt.id(), err)
+	}
 
+	switch pkt.(type) {
+	case *sshFxpReadPacket:
 		n, err := rw.ReadAt(data, offset)
+		if n == 0 && err == nil {
+			err = io.EOF
+		}
 		// only return EOF error if no data left to read
-		if err != nil && (err != io.EOF || n == 0) {
+		if err != nil && (!errors.Is(err, io.EOF) || n == 0) {
 			return statusFromError(pkt.id(), err)
 		}
 
@@ -425,8 +497,6 @@ func fileputget(h FileWriter, r *Request, pkt requestPacket, alloc *allocator, o
 		}
 
 	case *sshFxpWritePacket:
-		data, offset := p.Data, int64(p.Offset)
-
 		_, err := rw.WriteAt(data, offset)
 		return statusFromError(pkt.id(), err)
 
@@ -436,12 +506,18 @@ func fileputget(h FileWriter, r *Request, pkt requestPacket, alloc *allocator, o
 }
 
 // file data for additional read/write packets
-func packetData(p requestPacket, alloc *allocator, orderID uint32) (data []byte, offset int64, length uint32) {
+func packetData(p requestPacket, alloc *allocator, orderID uint32) (data []byte, offset int64, length uint32, err error) {
 	switch p := p.(type) {
 	case *sshFxpReadPacket:
-		return p.getDataSlice(alloc, orderID), int64(p.Offset), p.Len
+		if offset, err = toInt64(p.Offset); err != nil {
+			return nil, 0, 0, err
+		}
+		return p.getDataSlice(alloc, orderID), offset, p.Len, nil
 	case *sshFxpWritePacket:
-		return p.Data, int64(p.Offset), p.Length
+		if offset, err = toInt64(p.Offset); err != nil {
+			return nil, 0, 0, err
+		}
+		return p.Data, offset, p.Length, nil
 	}
 	return
 }
@@ -466,6 +542,14 @@ func filecmd(h FileCmder, r *Request, pkt requestPacket) responsePacket {
 		err := h.Filecmd(r)
 		return statusFromError(pkt.id(), err)
 
+	case "Link":
+		if linker, ok := h.(Linker); ok {
+			err := linker.Link(r)
+			return statusFromError(pkt.id(), err)
+		}
+
+		return statusFromError(pkt.id(), ErrSSHFxOpUnsupported)
+
 	case "StatVFS":
 		if statVFSCmdr, ok := h.(StatVFSFileCmder); ok {
 			stat, err := statVFSCmdr.StatVFS(r)
@@ -477,12 +561,71 @@ func filecmd(h FileCmder, r *Request, pkt requestPacket) responsePacket {
 		}
 
 		return statusFromError(pkt.id(), ErrSSHFxOpUnsupported)
+
+	case "Setstat":
+		if attrs, ok := setstatAttributes(h, r); ok {
+			err := setstat(h, r, attrs)
+			return statusFromError(pkt.id(), err)
+		}
 	}
 
 	err := h.Filecmd(r)
 	return statusFromError(pkt.id(), err)
 }
 
+// setstatAttributes returns the attributes of the Setstat request r, if h
+// implements the interfaces setting each of them.
+func setstatAttributes(h FileCmder, r *Request) (*FileAttributes, bool) {
+	attrs, err := r.FileAttributes()
+	if err != nil || attrs.Extended() != nil {
+		return nil, false
+	}
+	flags := attrs.Flags()
+	if !flags.Size && !flags.Permissions && !flags.Acmodtime && !flags.UidGid {
+		return nil, false
+	}
+	if _, ok := h.(Truncater); flags.Size && !ok {
+		return nil, false
+	}
+	if _, ok := h.(Chmodder); flags.Permissions && !ok {
+		return nil, false
+	}
+	if _, ok := h.(Chtimeser); flags.Acmodtime && !ok {
+		return nil, false
+	}
+	if _, ok := h.(Chowner); flags.UidGid && !ok {
+		return nil, false
+	}
+	return attrs, true
+}
+
+// setstat sets attrs with the granular interfaces of h.
+func setstat(h FileCmder, r *Request, attrs *FileAttributes) error {
+	if attrs.Flags().Size {
+		size, ok := attrs.Size()
+		if !ok {
+			return errors.New("sftp: size out of range")
+		}
+		if err := h.(Truncater).Truncate(r, size); err != nil {
+			return err
+		}
+	}
+	if mode, ok := attrs.Mode(); ok {
+		if err := h.(Chmodder).Chmod(r, mode); err != nil {
+			return err
+		}
+	}
+	if atime, mtime, ok := attrs.Times(); ok {
+		if err := h.(Chtimeser).Chtimes(r, atime, mtime); err != nil {
+			return err
+		}
+	}
+	if uid, gid, ok := attrs.Owner(); ok {
+		return h.(Chowner).Chown(r, uid, gid)
+	}
+	return nil
+}
+
 // wrap FileLister handler
 func filelist(h FileLister, r *Request, pkt requestPacket) responsePacket {
 	lister := r.getListerAt()
@@ -490,16 +633,25 @@ func filelist(h FileLister, r *Request, pkt requestPacket) responsePacket {
 		return statusFromError(pkt.id(), errors.New("unexpected dir packet"))
 	}
 
-	offset := r.lsNext()
 	finfo := make([]fs.FileInfo, MaxFilelist)
-	n, err := lister.ListAt(finfo, offset)
-	r.lsInc(int64(n))
+	var n int
+	var err error
+	if pager, ok := lister.(PagedListerAt); ok {
+		n, err = r.listPage(pager, finfo)
+	} else {
+		n, err = lister.ListAt(finfo, r.lsNext())
+		r.lsInc(int64(n))
+	}
 	// ignore EOF as we only return it when there are no results
 	finfo = finfo[:n] // avoid need for nil tests below
 
 	switch r.Method {
 	case "List":
-		if err != nil && (err != io.EOF || n == 0) {
+		if n == 0 && err == nil {
+			// an empty listing is the end, or clients would ask forever
+			err = io.EOF
+		}
+		if err != nil && (!errors.Is(err, io.EOF) || n == 0) {
 			return statusFromError(pkt.id(), err)
 		}
 
@@ -528,11 +680,25 @@ func filelist(h FileLister, r *Request, pkt requestPacket) responsePacket {
 	}
 }
 
+// listPage copies the next page of pager into finfo.
+func (r *Request) listPage(pager PagedListerAt, finfo []fs.FileInfo) (int, error) {
+	cursor, done := r.lsCursor()
+	if done {
+		return 0, io.EOF
+	}
+	n, next, err := pager.ListPage(finfo, cursor)
+	if err == nil || errors.Is(err, io.EOF) {
+		r.lsSetCursor(next, err != nil)
+	}
+	return n, err
+}
+
 func filestat(h FileLister, r *Request, pkt requestPacket) responsePacket {
 	var lister ListerAt
 	var err error
 
-	if r.Method == "Lstat" {
+	switch r.Method {
+	case "Lstat":
 		if lstatFileLister, ok := h.(LstatFileLister); ok {
 			lister, err = lstatFileLister.Lstat(r)
 		} else {
@@ -540,7 +706,24 @@ func filestat(h FileLister, r *Request, pkt requestPacket) responsePacket {
 			r.Method = "Stat"
 			lister, err = h.Filelist(r)
 		}
-	} else {
+	case "Fstat":
+		if fstatFileLister, ok := h.(FstatFileLister); ok {
+			lister, err = fstatFileLister.Fstat(r)
+		} else {
+			// FstatFileLister not implemented handle this request as a Stat
+			r.Method = "Stat"
+			lister, err = h.Filelist(r)
+		}
+	case "Readlink":
+		if readlinkFileLister, ok := h.(ReadlinkFileLister); ok {
+			target, err := readlinkFileLister.Readlink(r.Filepath)
+			if err != nil {
+				return statusFromError(pkt.id(), err)
+			}
+			return readlinkResponse(pkt.id(), target)
+		}
+		lister, err = h.Filelist(r)
+	default:
 		lister, err = h.Filelist(r)
 	}
 	if err != nil {
@@ -551,7 +734,7 @@ func filestat(h FileLister, r *Request, pkt requestPacket) responsePacket {
 	finfo = finfo[:n] // avoid need for nil tests below
 
 	switch r.Method {
-	case "Stat", "Lstat":
+	case "Stat", "Lstat", "Fstat":
 		if err != nil && err != io.EOF {
 			return statusFromError(pkt.id(), err)
 		}
@@ -579,23 +762,28 @@ func filestat(h FileLister, r *Request, pkt requestPacket) responsePacket {
 			}
 			return statusFromError(pkt.id(), err)
 		}
-		filename := finfo[0].Name()
-		return &sshFxpNamePacket{
-			ID: pkt.id(),
-			NameAttrs: []*sshFxpNameAttr{
-				{
-					Name:     filename,
-					LongName: filename,
-					Attrs:    emptyFileStat,
-				},
-			},
-		}
+		return readlinkResponse(pkt.id(), finfo[0].Name())
 	default:
 		err = fmt.Errorf("unexpected method: %s", r.Method)
 		return statusFromError(pkt.id(), err)
 	}
 }
 
+// readlinkResponse returns the response to a Readlink request with id,
+// for a link to target.
+func readlinkResponse(id uint32, target string) responsePacket {
+	return &sshFxpNamePacket{
+		ID: id,
+		NameAttrs: []*sshFxpNameAttr{
+			{
+				Name:     target,
+				LongName: target,
+				Attrs:    emptyFileStat,
+			},
+		},
+	}
+}
+
 // init attributes of request object from packet data
 func requestMethod(p requestPacket) (method string) {
 	switch p.(type) {
@@ -611,8 +799,10 @@ func requestMethod(p requestPacket) (method string) {
 		method = "Symlink"
 	case *sshFxpRemovePacket:
 		method = "Remove"
-	case *sshFxpStatPacket, *sshFxpFstatPacket:
+	case *sshFxpStatPacket:
 		method = "Stat"
+	case *sshFxpFstatPacket:
+		method = "Fstat"
 	case *sshFxpLstatPacket:
 		method = "Lstat"
 	case *sshFxpRmdirPacket:
@@ -623,6 +813,8 @@ func requestMethod(p requestPacket) (method string) {
 		method = "Mkdir"
 	case *sshFxpExtendedPacketHardlink:
 		method = "Link"
+	case *sshFxpExtendedPacketPosixRename:
+		method = "PosixRename"
 	}
 	return method
 }
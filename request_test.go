@@ -7,7 +7,7 @@ import (
 	"io/fs"
 	"testing"
 
-	"github.com/pkg/sftp/internal/apis"
+	"github.com/pkg/sftp/apis"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -260,3 +260,75 @@ func TestOpendirHandleReuse(t *testing.T) {
 	rpkt = request.call(handlers, pkt, nil, 0)
 	assert.IsType(t, &sshFxpNamePacket{}, rpkt)
 }
+
+// offsetRecorder remembers the offset of the last ReadAt or WriteAt call.
+type offsetRecorder struct {
+	off int64
+}
+
+func (o *offsetRecorder) ReadAt(p []byte, off int64) (int, error) {
+	o.off = off
+	return len(p), nil
+}
+
+func (o *offsetRecorder) WriteAt(p []byte, off int64) (int, error) {
+	o.off = off
+	return len(p), nil
+}
+
+func TestRequestLargeOffsets(t *testing.T) {
+	handlers := newTestHandlers()
+	rec := &offsetRecorder{off: -1}
+
+	request := testRequest("Put")
+	request.state.writerAt = rec
+	for _, off := range []uint64{1<<31 + 1, 1<<32 + 1, 1<<40 + 1} {
+		pkt := &sshFxpWritePacket{ID: 1, Handle: "a", Offset: off, Length: 1,
+			Data: []byte("a")}
+		checkOkStatus(t, request.call(handlers, pkt, nil, 0))
+		assert.Equal(t, int64(off), rec.off)
+	}
+
+	request = testRequest("Get")
+	request.state.readerAt = rec
+	rpkt := request.call(handlers, &sshFxpReadPacket{ID: 2, Handle: "a",
+		Offset: 1<<32 + 7, Len: 4}, nil, 0)
+	assert.IsType(t, &sshFxpDataPacket{}, rpkt)
+	assert.Equal(t, int64(1<<32+7), rec.off)
+
+	// offsets not fitting into an int64 must not wrap around to negative ones
+	rec.off = -1
+	rpkt = request.call(handlers, &sshFxpReadPacket{ID: 3, Handle: "a",
+		Offset: 1 << 63, Len: 4}, nil, 0)
+	assert.IsType(t, &sshFxpStatusPacket{}, rpkt)
+	assert.Equal(t, int64(-1), rec.off)
+}
+
+type syncRecorder struct {
+	offsetRecorder
+	synced bool
+}
+
+func (s *syncRecorder) Sync() error {
+	s.synced = true
+	return nil
+}
+
+func TestRequestSync(t *testing.T) {
+	request := testRequest("Put")
+	request.state.writerAt = &offsetRecorder{}
+	assert.Equal(t, ErrSSHFxOpUnsupported, request.sync())
+
+	rec := &syncRecorder{}
+	request.state.writerAt = rec
+	assert.NoError(t, request.sync())
+	assert.True(t, rec.synced)
+}
+
+func TestRequestLinkUnsupported(t *testing.T) {
+	handlers := newTestHandlers()
+	request := testRequest("Link")
+	pkt := fakePacket{myid: 1}
+	rpkt := request.call(handlers, pkt, nil, 0)
+	assert.Equal(t, statusFromError(pkt.myid, ErrSSHFxOpUnsupported), rpkt)
+}
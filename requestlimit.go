@@ -0,0 +1,137 @@
+package sftp
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRequestLimited is the error for requests turned down by a RequestLimiter.
+// Clients see it as SSH_FX_FAILURE with its message.
+var ErrRequestLimited = errors.New("sftp: too many requests, try again later")
+
+// RequestLimiter decides whether a RequestServer serves a request, before the
+// request reaches any handler. It returns nil to serve the request, or the
+// error to answer it with, usually ErrRequestLimited.
+//
+// The Request has the Method and Filepath that the handlers would see, and
+// Target for renames and links. Reads, writes and listings of an open handle
+// carry the Method of the request which opened it: Get, Put, Open or List.
+// Init and close requests are never limited, so that clients can always
+// release their handles.
+//
+// A RequestLimiter is called concurrently.
+type RequestLimiter func(*Request) error
+
+// WithRequestLimiter has the RequestServer ask limit before serving requests,
+// to cap how many a client may send per method or path, say. See
+// MethodRateLimiter for a simple one.
+func WithRequestLimiter(limit RequestLimiter) RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.limiter = limit
+	}
+}
+
+// MethodRateLimiter returns a RequestLimiter, which allows as many requests
+// per second for each Method as given in rates, and up to that many at once.
+// Methods not in rates are not limited.
+func MethodRateLimiter(rates map[string]int) RequestLimiter {
+	buckets := make(map[string]*requestBucket, len(rates))
+	for method, rate := range rates {
+		if rate > 0 {
+			buckets[method] = &requestBucket{
+				rate:   float64(rate),
+				tokens: float64(rate),
+				last:   time.Now(),
+			}
+		}
+	}
+
+	return func(r *Request) error {
+		if b, ok := buckets[r.Method]; ok && !b.take() {
+			return ErrRequestLimited
+		}
+		return nil
+	}
+}
+
+// requestBucket is a token bucket holding up to a second worth of requests.
+type requestBucket struct {
+	rate float64 // requests per second
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// take reports whether a request may pass now, and counts it if so.
+func (b *requestBucket) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// limit asks the RequestLimiter about pkt.
+func (rs *RequestServer) limit(pkt requestPacket) error {
+	var request *Request
+	switch p := pkt.(type) {
+	case *sshFxInitPacket, *sshFxpClosePacket:
+		return nil
+	case *sshFxpRealpathPacket:
+		request = NewRequest("Realpath", p.getPath())
+	case *sshFxpOpenPacket:
+		request = NewRequest(openMethod(p.Pflags), p.getPath())
+	case *sshFxpOpendirPacket:
+		request = NewRequest("List", p.getPath())
+	case *sshFxpExtendedPacketStatVFS:
+		request = NewRequest("StatVFS", p.Path)
+	case *sshFxpExtendedPacketDirStats:
+		request = NewRequest("DirStats", p.Path)
+	case hasHandle:
+		opened, ok := rs.getRequest(p.getHandle())
+		if !ok {
+			// answered with EBADF anyway
+			return nil
+		}
+		method := requestMethod(p)
+		if method == "" {
+			method = opened.Method
+		}
+		request = NewRequest(method, opened.Filepath)
+	case hasPath:
+		request = NewRequest(requestMethod(p), p.getPath())
+		if target := requestTarget(p); target != "" {
+			request.Target = cleanPath(target)
+		}
+	default:
+		// extensions not reaching the handlers
+		return nil
+	}
+	return rs.limiter(request)
+}
+
+// openMethod returns the Method of the handler an open request with pflags
+// goes to, mostly; Request.open has the final say.
+func openMethod(pflags uint32) string {
+	flags := newFileOpenFlags(pflags)
+	switch {
+	case flags.Write, flags.Append, flags.Creat, flags.Trunc:
+		if flags.Read {
+			return "Open"
+		}
+		return "Put"
+	}
+	return "Get"
+}
@@ -0,0 +1,59 @@
+package sftp
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestLimiter(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	p := clientRequestServerPair(t, WithRequestLimiter(func(r *Request) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, r.Method+" "+r.Filepath)
+		if r.Filepath == "/forbidden" {
+			return ErrRequestLimited
+		}
+		return nil
+	}))
+	defer p.Close()
+
+	_, err := putTestFile(p.cli, "/foo", "hello")
+	require.NoError(t, err)
+	f, err := p.cli.Open("/foo")
+	require.NoError(t, err)
+	_, err = f.Read(make([]byte, 5))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = p.cli.Stat("/forbidden")
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, ErrSSHFxFailure), "got %v", err)
+		assert.Contains(t, err.Error(), "too many requests")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(t, seen, "Open /foo", "created read-write")
+	assert.Contains(t, seen, "Get /foo")
+	assert.Contains(t, seen, "Stat /forbidden")
+	checkRequestServerAllocator(t, p)
+}
+
+func TestMethodRateLimiter(t *testing.T) {
+	limit := MethodRateLimiter(map[string]int{"Stat": 2})
+
+	stat := NewRequest("Stat", "/")
+	assert.NoError(t, limit(stat))
+	assert.NoError(t, limit(stat))
+	assert.Equal(t, ErrRequestLimited, limit(stat))
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, limit(NewRequest("Get", "/")))
+	}
+}
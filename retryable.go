@@ -0,0 +1,63 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"net"
+)
+
+// IsRetryable reports whether an operation failing with err may succeed when
+// tried again later, possibly on a new session, as opposed to errors which
+// will not go away, like a missing file or a denied permission.
+//
+// Retryable are the errors of a lost or unresponsive connection, including
+// ErrSSHFxConnectionLost, ErrSSHFxNoConnection, ErrKeepaliveTimeout, network
+// errors and timeouts, byte range lock conflicts, and the failures the
+// servers of this package answer requests with, which were turned down by a
+// RequestLimiter, timed out in the backend, see WithBackendTimeout, or
+// arrived while the server shut down, see ErrShuttingDown.
+//
+// IsRetryable does not tell whether it is safe to repeat the operation:
+// a rename failing with a lost connection may have taken effect anyway.
+func IsRetryable(err error) bool {
+	if err == nil || err == io.EOF {
+		return false
+	}
+
+	var status *StatusError
+	if errors.As(err, &status) {
+		switch status.FxCode() {
+		case ErrSSHFxNoConnection, ErrSSHFxConnectionLost, ErrSSHFxByteRangeLockConflict:
+			return true
+		case ErrSSHFxFailure:
+			switch status.msg {
+			case ErrRequestLimited.Error(), errBackendTimeout.Error(), ErrShuttingDown.Error():
+				return true
+			}
+		}
+		return false
+	}
+
+	var code fxerr
+	if errors.As(err, &code) {
+		switch code {
+		case ErrSSHFxNoConnection, ErrSSHFxConnectionLost, ErrSSHFxByteRangeLockConflict:
+			return true
+		}
+		return false
+	}
+
+	switch {
+	case errors.Is(err, ErrKeepaliveTimeout),
+		errors.Is(err, ErrRequestLimited),
+		errors.Is(err, io.ErrClosedPipe):
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
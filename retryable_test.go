@@ -0,0 +1,79 @@
+package sftp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+	for _, err := range []error{
+		ErrSSHFxConnectionLost,
+		&StatusError{Code: sshFxNoConnection},
+		&StatusError{Code: sshFxByteRangeLockConflict},
+		&statusFromError(1, ErrRequestLimited).StatusError,
+		&statusFromError(1, errBackendTimeout).StatusError,
+		&statusFromError(1, ErrShuttingDown).StatusError,
+		fmt.Errorf("wrapped: %w", ErrKeepaliveTimeout),
+		io.ErrClosedPipe,
+		&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+		timeoutError{},
+		os.ErrDeadlineExceeded,
+	} {
+		assert.True(t, IsRetryable(err), "%v", err)
+	}
+
+	for _, err := range []error{
+		nil,
+		io.EOF,
+		io.ErrUnexpectedEOF,
+		os.ErrNotExist,
+		os.ErrPermission,
+		ErrSSHFxOpUnsupported,
+		&StatusError{Code: sshFxFailure, msg: "disk full"},
+		&StatusError{Code: sshFxBadMessage},
+		errShortPacket,
+	} {
+		assert.False(t, IsRetryable(err), "%v", err)
+	}
+}
+
+func TestConcurrentTransferResumes(t *testing.T) {
+	s := &poolServers{}
+	var sessions []*Client
+	for i := 0; i < 3; i++ {
+		c, err := s.newClient()
+		require.NoError(t, err)
+		defer c.Close()
+		sessions = append(sessions, c)
+	}
+	// lost its connection
+	sessions[1].Close()
+
+	content := make([]byte, 3*stripeBufferSize+1234)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	name := filepath.Join(t.TempDir(), "uploaded")
+	n, err := UploadConcurrent(sessions, name, bytes.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), n)
+
+	b, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, b))
+}
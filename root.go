@@ -0,0 +1,349 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/sftp/apis"
+)
+
+// maxRootLinks is how many symbolic links the path of a request may run
+// through in a root directory, like the limit of Linux.
+const maxRootLinks = 40
+
+var errTooManyLinks = errors.New("too many levels of symbolic links")
+
+// WithRootDirectory confines the Server to the directory root, like a
+// chroot: the paths of the client are taken as relative to root, absolute
+// ones included, and requests climbing above it with ".." are denied.
+//
+// Symbolic links are resolved by the Server itself, walking the path with
+// Lstat, and absolute link targets are taken as relative to root too, so
+// that no link leads out of it. Link targets are stored and read back as
+// the client sent them. Realpath answers with the paths the client sees,
+// and "~" expands to "/" unless set with WithHomeDirResolver.
+//
+// Files changed outside of the session while a request is served may still
+// let it escape, root should not be writable by anyone else.
+func WithRootDirectory(root string) ServerOption {
+	return func(s *Server) error {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return err
+		}
+		s.root = &rootDir{
+			path:     abs,
+			join:     filepath.Join,
+			readLink: s.readRootLink,
+		}
+		return nil
+	}
+}
+
+// WithRSRootDirectory confines the RequestServer to the directory root,
+// like WithRootDirectory does for a Server: Request.Filepath and
+// Request.Target are always below root, which is a path of the handlers.
+// Realpath requests are not rebased, and Symlink requests keep their
+// target as is.
+//
+// Symbolic links are resolved with the Lstat and Readlink methods of the
+// FileLister, which has to be a LstatFileLister for that. Without one the
+// paths are confined lexically only.
+func WithRSRootDirectory(root string) RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.root = &rootDir{
+			path:     cleanPath(root),
+			join:     path.Join,
+			readLink: rs.readRootLink,
+		}
+	}
+}
+
+// rootDir confines the paths of a session to a root directory.
+type rootDir struct {
+	path string
+	join func(elem ...string) string
+
+	// readLink returns the target of the symbolic link name,
+	// with isLink false if name is no link.
+	readLink func(name string) (target string, isLink bool, err error)
+}
+
+// resolve returns the path of name below the root, following the symbolic
+// links on the way, and the last element of name too if follow.
+func (r *rootDir) resolve(name string, follow bool) (string, error) {
+	if escapesRoot(name) {
+		return "", syscall.EPERM
+	}
+
+	pending := splitPath(name)
+	resolved := "/"
+	links := 0
+	for len(pending) > 0 {
+		elem := pending[0]
+		pending = pending[1:]
+
+		if elem == ".." {
+			// from a link target, which cannot climb above the root either
+			resolved = path.Dir(resolved)
+			continue
+		}
+		next := path.Join(resolved, elem)
+		if len(pending) == 0 && !follow {
+			resolved = next
+			break
+		}
+
+		target, isLink, err := r.readLink(r.join(r.path, next))
+		if err != nil || !isLink {
+			// missing files are no links, neither is anything below them
+			resolved = next
+			continue
+		}
+
+		if links++; links > maxRootLinks {
+			return "", &fs.PathError{Op: "resolve", Path: name, Err: errTooManyLinks}
+		}
+		if path.IsAbs(filepath.ToSlash(target)) {
+			resolved = "/"
+		}
+		pending = append(splitPath(target), pending...)
+	}
+	return r.join(r.path, resolved), nil
+}
+
+// splitPath returns the elements of the clean form of p.
+func splitPath(p string) []string {
+	var elems []string
+	for _, elem := range strings.Split(filepath.ToSlash(p), "/") {
+		if elem != "" && elem != "." {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
+
+// confine returns a copy of pkt with its paths resolved below the root.
+// Packets without paths are returned as they are.
+func (r *rootDir) confine(pkt requestPacket) (requestPacket, error) {
+	var err error
+	confined := mapPaths(pkt, func(name string, follow bool) string {
+		if err != nil {
+			return ""
+		}
+		var resolved string
+		resolved, err = r.resolve(name, follow)
+		return resolved
+	})
+	if err != nil {
+		return nil, err
+	}
+	return confined, nil
+}
+
+// mapPaths returns pkt with its paths passed through resolve, which is told
+// whether the last element of the path is followed if it is a symbolic link.
+// The target of symbolic links and Realpath requests are left alone, as is
+// pkt itself.
+func mapPaths(pkt requestPacket, resolve func(name string, follow bool) string) requestPacket {
+	switch p := pkt.(type) {
+	case *sshFxpExtendedPacket:
+		if p.SpecificPacket == nil {
+			return pkt
+		}
+		q := *p
+		q.SpecificPacket = mapPaths(p.SpecificPacket, resolve).(interface {
+			serverRespondablePacket
+			readonly() bool
+		})
+		return &q
+
+	case *sshFxpOpenPacket:
+		q := *p
+		q.Path = resolve(p.Path, true)
+		return &q
+	case *sshFxpOpendirPacket:
+		q := *p
+		q.Path = resolve(p.Path, true)
+		return &q
+	case *sshFxpStatPacket:
+		q := *p
+		q.Path = resolve(p.Path, true)
+		return &q
+	case *sshFxpSetstatPacket:
+		q := *p
+		q.Path = resolve(p.Path, true)
+		return &q
+	case *sshFxpLstatPacket:
+		q := *p
+		q.Path = resolve(p.Path, false)
+		return &q
+	case *sshFxpReadlinkPacket:
+		q := *p
+		q.Path = resolve(p.Path, false)
+		return &q
+	case *sshFxpMkdirPacket:
+		q := *p
+		q.Path = resolve(p.Path, false)
+		return &q
+	case *sshFxpRmdirPacket:
+		q := *p
+		q.Path = resolve(p.Path, false)
+		return &q
+	case *sshFxpRemovePacket:
+		q := *p
+		q.Filename = resolve(p.Filename, false)
+		return &q
+	case *sshFxpSymlinkPacket:
+		q := *p
+		q.Linkpath = resolve(p.Linkpath, false)
+		return &q
+	case *sshFxpRenamePacket:
+		q := *p
+		q.Oldpath = resolve(p.Oldpath, false)
+		q.Newpath = resolve(p.Newpath, false)
+		return &q
+	case *sshFxpExtendedPacketPosixRename:
+		q := *p
+		q.Oldpath = resolve(p.Oldpath, false)
+		q.Newpath = resolve(p.Newpath, false)
+		return &q
+	case *sshFxpExtendedPacketHardlink:
+		q := *p
+		q.Oldpath = resolve(p.Oldpath, false)
+		q.Newpath = resolve(p.Newpath, false)
+		return &q
+	case *sshFxpExtendedPacketStatVFS:
+		q := *p
+		q.Path = resolve(p.Path, true)
+		return &q
+	case *sshFxpExtendedPacketCheckFile:
+		q := *p
+		if p.Handle == "" {
+			q.Path = resolve(p.Path, true)
+		}
+		return &q
+	case *sshFxpExtendedPacketDirStats:
+		q := *p
+		q.Path = resolve(p.Path, true)
+		return &q
+	case *sshFxpExtendedPacketDiskUsage:
+		q := *p
+		q.Path = resolve(p.Path, true)
+		return &q
+	case *sshFxpExtendedPacketSpaceAvailable:
+		q := *p
+		q.Path = resolve(p.Path, true)
+		return &q
+	case *sshFxpExtendedPacketAccess:
+		q := *p
+		q.Path = resolve(p.Path, true)
+		return &q
+	case *sshFxpExtendedPacketGetACL:
+		q := *p
+		q.Path = resolve(p.Path, true)
+		return &q
+	case *sshFxpExtendedPacketSetACL:
+		q := *p
+		q.Path = resolve(p.Path, true)
+		return &q
+	}
+	return pkt
+}
+
+// confine returns the request p with its paths below the root directory,
+// or the response to send in its place.
+func (svr *Server) confine(p requestPacket) (requestPacket, responsePacket) {
+	switch p := p.(type) {
+	case *sshFxpRealpathPacket:
+		return nil, rootedPathResponse(p.ID, p.Path)
+	case *sshFxpExtendedPacket:
+		if e, ok := p.SpecificPacket.(*sshFxpExtendedPacketExpandPath); ok {
+			resolve := svr.homeDir
+			if resolve == nil {
+				resolve = func(string) (string, error) { return "/", nil }
+			}
+			expanded, err := expandHome(e.Path, resolve)
+			if err != nil {
+				return nil, statusFromError(e.ID, err)
+			}
+			return nil, rootedPathResponse(e.ID, expanded)
+		}
+	}
+
+	confined, err := svr.root.confine(p)
+	if err != nil {
+		return nil, statusFromError(p.id(), err)
+	}
+	return confined, nil
+}
+
+// rootedPathResponse answers with the clean absolute form of p, as the
+// client of a root directory sees it. Realpath of ".." in the root is the
+// root, as usual.
+func rootedPathResponse(id uint32, p string) responsePacket {
+	name := cleanPath(p)
+	return &sshFxpNamePacket{
+		ID: id,
+		NameAttrs: []*sshFxpNameAttr{
+			{
+				Name:     name,
+				LongName: name,
+				Attrs:    emptyFileStat,
+			},
+		},
+	}
+}
+
+// readRootLink reads the symbolic link name in the file system of the Server.
+func (svr *Server) readRootLink(name string) (string, bool, error) {
+	info, err := apis.Lstat(svr.fs, name)
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		return "", false, err
+	}
+	target, err := apis.Readlink(svr.fs, name)
+	return target, err == nil, err
+}
+
+// readRootLink reads the symbolic link name through the handlers of the
+// RequestServer.
+func (rs *RequestServer) readRootLink(name string) (string, bool, error) {
+	lister, ok := rs.Handlers.FileList.(LstatFileLister)
+	if !ok {
+		return "", false, nil
+	}
+	info, err := listOne(lister.Lstat(NewRequest("Lstat", name)))
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		return "", false, err
+	}
+	if readlinker, ok := lister.(ReadlinkFileLister); ok {
+		target, err := readlinker.Readlink(name)
+		return target, err == nil, err
+	}
+	link, err := listOne(lister.Filelist(NewRequest("Readlink", name)))
+	if err != nil {
+		return "", false, err
+	}
+	return link.Name(), true, nil
+}
+
+// listOne returns the first entry of lister.
+func listOne(lister ListerAt, err error) (fs.FileInfo, error) {
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fs.FileInfo, 1)
+	n, err := lister.ListAt(entries, 0)
+	if n == 0 {
+		if err == nil || err == io.EOF {
+			err = fs.ErrNotExist
+		}
+		return nil, err
+	}
+	return entries[0], nil
+}
@@ -0,0 +1,146 @@
+package sftp
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRootDirResolve(t *testing.T) {
+	links := map[string]string{
+		"/root/abs":    "/dir",
+		"/root/rel":    "../../..",
+		"/root/loop":   "loop",
+		"/root/dir/up": "../abs",
+	}
+	r := &rootDir{
+		path: "/root",
+		join: path.Join,
+		readLink: func(name string) (string, bool, error) {
+			target, ok := links[name]
+			return target, ok, nil
+		},
+	}
+
+	for _, tt := range []struct {
+		name   string
+		follow bool
+		want   string
+	}{
+		{"/", true, "/root"},
+		{"foo", true, "/root/foo"},
+		{"/foo/./bar/..", true, "/root/foo"},
+		{"/abs/foo", true, "/root/dir/foo"},
+		{"/abs", true, "/root/dir"},
+		{"/abs", false, "/root/abs"},
+		{"/rel/foo", true, "/root/foo"},
+		{"/dir/up/x", true, "/root/dir/x"},
+	} {
+		got, err := r.resolve(tt.name, tt.follow)
+		if assert.NoError(t, err, tt.name) {
+			assert.Equal(t, tt.want, got, tt.name)
+		}
+	}
+
+	_, err := r.resolve("/../etc/passwd", true)
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	_, err = r.resolve("foo/../../etc", true)
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	_, err = r.resolve("/loop", true)
+	assert.ErrorIs(t, err, errTooManyLinks)
+	_, err = r.resolve("/loop", false)
+	assert.NoError(t, err, "the link itself is fine")
+}
+
+func TestServerRootDirectory(t *testing.T) {
+	skipIfWindows(t)
+	skipIfPlan9(t)
+
+	root, outside := t.TempDir(), t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0600))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "out")))
+	require.NoError(t, os.Symlink("../../../../..", filepath.Join(root, "up")))
+
+	client, server := clientServerPair(t, WithRootDirectory(root))
+	defer client.Close()
+	defer server.Close()
+
+	f, err := client.Create("/foo")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	b, err := os.ReadFile(filepath.Join(root, "foo"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	wd, err := client.Getwd()
+	require.NoError(t, err)
+	assert.Equal(t, "/", wd)
+	resolved, err := client.RealPath("/foo/../..")
+	require.NoError(t, err)
+	assert.Equal(t, "/", resolved)
+
+	_, err = client.Stat("/../foo")
+	assert.ErrorIs(t, err, fs.ErrPermission)
+
+	// links lead to the root, however they are written
+	_, err = client.Stat("/out/secret")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = client.Open("/out/secret")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	fi, err := client.Stat("/up/foo")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), fi.Size())
+
+	// links made by the client are relative to the root too
+	require.NoError(t, client.Symlink("/foo", "/link"))
+	target, err := client.ReadLink("/link")
+	require.NoError(t, err)
+	assert.Equal(t, "/foo", target)
+	f, err = client.Open("/link")
+	require.NoError(t, err)
+	b, err = io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	require.NoError(t, f.Close())
+
+	fi, err = client.Lstat("/link")
+	require.NoError(t, err)
+	assert.True(t, fi.Mode()&fs.ModeSymlink != 0)
+	require.NoError(t, client.Remove("/link"))
+	_, err = os.Lstat(filepath.Join(root, "foo"))
+	assert.NoError(t, err, "removing the link keeps its target")
+}
+
+func TestRequestServerRootDirectory(t *testing.T) {
+	p := clientRequestServerPair(t, WithRSRootDirectory("/jail"))
+	defer p.Close()
+
+	handlers := p.svr.Handlers
+	require.NoError(t, handlers.FileCmd.Filecmd(NewRequest("Mkdir", "/jail")))
+	symlink := NewRequest("Symlink", "/")
+	symlink.Target = "/jail/up"
+	require.NoError(t, handlers.FileCmd.Filecmd(symlink))
+
+	_, err := putTestFile(p.cli, "/foo", "hello")
+	require.NoError(t, err)
+	_, err = listOne(handlers.FileList.Filelist(NewRequest("Stat", "/jail/foo")))
+	assert.NoError(t, err, "the file is in the root")
+
+	fi, err := p.cli.Stat("/up/foo")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), fi.Size())
+
+	_, err = p.cli.Stat("/../foo")
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	require.NoError(t, p.cli.Rename("/foo", "/bar"))
+	_, err = listOne(handlers.FileList.Filelist(NewRequest("Stat", "/jail/bar")))
+	assert.NoError(t, err)
+}
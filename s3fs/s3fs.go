@@ -0,0 +1,682 @@
+// Package s3fs serves the objects of an S3 compatible bucket through the
+// sftp.RequestServer, as a SFTP gateway to the bucket.
+//
+//	fsys := &s3fs.FS{Bucket: bucket, Prefix: "users/" + conn.User() + "/"}
+//	server := sftp.NewRequestServer(channel, fsys.Handlers())
+//
+// The FS talks to the object store through the Bucket interface, the handful
+// of S3 operations it needs, so that it works with any S3 client library:
+// an adapter over the AWS SDK, minio-go or the like takes a few lines for
+// each method.
+//
+// Object stores are no file systems, and the FS maps one onto the other as
+// far as it goes:
+//
+//   - A path is the key of an object, relative to Prefix. Directories are
+//     the common prefixes of the keys, up to a "/", and the empty marker
+//     objects ending in "/" that Mkdir puts.
+//   - Files are read with range GETs. They are written whole, and
+//     sequentially: opening a file for writing replaces the object once the
+//     file is closed, with a multipart upload of PartSize parts if it is
+//     larger than that. Writes ahead of the current offset are buffered, as
+//     clients send them concurrently, but appends and overwrites are not
+//     supported.
+//   - Renames copy and delete the objects, which is not atomic, and for
+//     directories takes a request for each object in them.
+//   - Setstat is accepted but ignored, as objects have no modes, owners or
+//     times to set, and links are not supported.
+package s3fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// ErrNotFound is what the errors of a Bucket wrap for keys which do not
+// exist, see errors.Is.
+var ErrNotFound = errors.New("s3fs: no such key")
+
+var (
+	errAppend     = errors.New("s3fs: objects cannot be appended to")
+	errOverwrite  = errors.New("s3fs: objects are written sequentially, they cannot be overwritten")
+	errHole       = errors.New("s3fs: writes left a hole in the object")
+	errNotEmpty   = errors.New("directory not empty")
+	errIsRoot     = errors.New("s3fs: the root directory cannot be changed")
+	errNotAllowed = errors.New("s3fs: not supported by object stores")
+)
+
+// MinPartSize is the smallest size S3 allows for the parts of a multipart
+// upload, but the last, and the default PartSize.
+const MinPartSize = 5 << 20
+
+// maxPendingParts limits how much of a file may be written ahead of its
+// offset, in parts.
+const maxPendingParts = 4
+
+// Object describes an object of a Bucket.
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// ListResult is a page of the objects under a prefix.
+type ListResult struct {
+	// Objects and CommonPrefixes are the keys and the common prefixes up
+	// to the delimiter, including it, in the page.
+	Objects        []Object
+	CommonPrefixes []string
+
+	// NextToken continues the listing, "" if the page was the last.
+	NextToken string
+}
+
+// Part is an uploaded part of a multipart upload.
+type Part struct {
+	Number int
+	ETag   string
+}
+
+// Bucket is the subset of the S3 API the FS needs. Errors for keys that do
+// not exist have to wrap ErrNotFound.
+type Bucket interface {
+	HeadObject(ctx context.Context, key string) (Object, error)
+	GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	PutObject(ctx context.Context, key string, body io.Reader, size int64) error
+	CopyObject(ctx context.Context, srcKey, dstKey string) error
+	DeleteObject(ctx context.Context, key string) error
+
+	// ListObjects lists the objects with prefix, and the common prefixes
+	// up to delimiter, if it is not "", starting at token, "" for the first
+	// page.
+	ListObjects(ctx context.Context, prefix, delimiter, token string) (*ListResult, error)
+
+	CreateMultipartUpload(ctx context.Context, key string) (uploadID string, err error)
+	UploadPart(ctx context.Context, key, uploadID string, number int, body io.Reader, size int64) (etag string, err error)
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) error
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+}
+
+// FS serves a Bucket to the sftp.RequestServer. Its fields must not be
+// changed once it serves.
+type FS struct {
+	Bucket Bucket
+
+	// Prefix is prepended to the paths to make the keys, it should be ""
+	// or end in "/".
+	Prefix string
+
+	// PartSize is the size of the parts of multipart uploads, MinPartSize
+	// if zero. Files up to it are uploaded with a single PutObject.
+	PartSize int64
+}
+
+// Handlers returns the sftp.Handlers serving fsys.
+func (fsys *FS) Handlers() sftp.Handlers {
+	return sftp.Handlers{
+		FileGet:  fsys,
+		FilePut:  fsys,
+		FileCmd:  fsys,
+		FileList: fsys,
+	}
+}
+
+func (fsys *FS) partSize() int64 {
+	if fsys.PartSize > 0 {
+		return fsys.PartSize
+	}
+	return MinPartSize
+}
+
+// key returns the key of the object at p, Prefix for the root directory.
+func (fsys *FS) key(p string) string {
+	return fsys.Prefix + strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// dirPrefix returns the prefix of the keys in the directory at p.
+func (fsys *FS) dirPrefix(p string) string {
+	k := fsys.key(p)
+	if k == "" || strings.HasSuffix(k, "/") {
+		return k
+	}
+	return k + "/"
+}
+
+func isRoot(p string) bool {
+	return path.Clean("/"+p) == "/"
+}
+
+func pathError(op, name string, err error) error {
+	if errors.Is(err, ErrNotFound) {
+		err = syscall.ENOENT
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// fileInfo describes an object, or a directory.
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+// stat returns the fs.FileInfo of p, the object at its key, or else the
+// directory of the keys with its prefix.
+func (fsys *FS) stat(ctx context.Context, p string) (fs.FileInfo, error) {
+	name := path.Base(path.Clean("/" + p))
+	if isRoot(p) {
+		return &fileInfo{name: name, dir: true}, nil
+	}
+
+	obj, err := fsys.Bucket.HeadObject(ctx, fsys.key(p))
+	if err == nil {
+		return &fileInfo{name: name, size: obj.Size, modTime: obj.LastModified}, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	empty, err := fsys.isEmpty(ctx, fsys.dirPrefix(p), false)
+	if err != nil {
+		return nil, err
+	}
+	if empty {
+		return nil, syscall.ENOENT
+	}
+	return &fileInfo{name: name, dir: true}, nil
+}
+
+// isEmpty reports whether there are no keys with prefix, not counting the
+// marker of the directory if exceptMarker is set.
+func (fsys *FS) isEmpty(ctx context.Context, prefix string, exceptMarker bool) (bool, error) {
+	token := ""
+	for {
+		res, err := fsys.Bucket.ListObjects(ctx, prefix, "/", token)
+		if err != nil {
+			return false, err
+		}
+		if len(res.CommonPrefixes) > 0 {
+			return false, nil
+		}
+		for _, obj := range res.Objects {
+			if !exceptMarker || obj.Key != prefix {
+				return false, nil
+			}
+		}
+		if res.NextToken == "" {
+			return true, nil
+		}
+		token = res.NextToken
+	}
+}
+
+// Fileread opens a file for reading with range GETs.
+func (fsys *FS) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	key := fsys.key(r.Filepath)
+	obj, err := fsys.Bucket.HeadObject(r.Context(), key)
+	if err != nil {
+		return nil, pathError("open", r.Filepath, err)
+	}
+	return &reader{ctx: r.Context(), bucket: fsys.Bucket, key: key, size: obj.Size}, nil
+}
+
+// reader reads an object.
+type reader struct {
+	ctx    context.Context
+	bucket Bucket
+	key    string
+	size   int64
+}
+
+func (rd *reader) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, syscall.EINVAL
+	}
+	if off >= rd.size {
+		return 0, io.EOF
+	}
+	length := int64(len(b))
+	if off+length > rd.size {
+		length = rd.size - off
+	}
+
+	body, err := rd.bucket.GetObjectRange(rd.ctx, rd.key, off, length)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	n, err := io.ReadFull(body, b[:length])
+	if err != nil {
+		return n, err
+	}
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Filewrite opens a file for writing, which replaces the object once the
+// file is closed.
+func (fsys *FS) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	flags := r.Pflags()
+	if flags.Append {
+		return nil, pathError("open", r.Filepath, errAppend)
+	}
+	if isRoot(r.Filepath) {
+		return nil, pathError("open", r.Filepath, syscall.EISDIR)
+	}
+
+	key := fsys.key(r.Filepath)
+	if !flags.Creat || flags.Excl {
+		_, err := fsys.Bucket.HeadObject(r.Context(), key)
+		switch {
+		case err == nil && flags.Excl:
+			return nil, pathError("open", r.Filepath, syscall.EEXIST)
+		case err == nil, flags.Creat && errors.Is(err, ErrNotFound):
+		default:
+			return nil, pathError("open", r.Filepath, err)
+		}
+	}
+
+	return &writer{
+		ctx:      r.Context(),
+		bucket:   fsys.Bucket,
+		key:      key,
+		partSize: fsys.partSize(),
+		pending:  make(map[int64][]byte),
+	}, nil
+}
+
+// writer uploads an object, in parts once it outgrows one.
+type writer struct {
+	ctx      context.Context
+	bucket   Bucket
+	key      string
+	partSize int64
+
+	mu       sync.Mutex
+	off      int64            // the end of the data written in order
+	buf      []byte           // the data not uploaded yet
+	pending  map[int64][]byte // the data written ahead of off, by offset
+	npending int64
+	uploadID string
+	parts    []Part
+	err      error // fails all writes once set
+	closed   bool
+}
+
+func (w *writer) WriteAt(b []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	switch {
+	case off < w.off:
+		return 0, w.fail(errOverwrite)
+
+	case off > w.off:
+		if w.npending+int64(len(b)) > maxPendingParts*w.partSize {
+			return 0, w.fail(errHole)
+		}
+		w.pending[off] = append([]byte(nil), b...)
+		w.npending += int64(len(b))
+		return len(b), nil
+	}
+
+	w.buf = append(w.buf, b...)
+	w.off += int64(len(b))
+	for {
+		data, ok := w.pending[w.off]
+		if !ok {
+			break
+		}
+		delete(w.pending, w.off)
+		w.npending -= int64(len(data))
+		w.buf = append(w.buf, data...)
+		w.off += int64(len(data))
+	}
+
+	for int64(len(w.buf)) >= w.partSize {
+		if err := w.uploadPart(w.buf[:w.partSize]); err != nil {
+			return 0, w.fail(err)
+		}
+		w.buf = append(w.buf[:0], w.buf[w.partSize:]...)
+	}
+	return len(b), nil
+}
+
+// uploadPart uploads data as the next part, starting the upload for the
+// first one.
+func (w *writer) uploadPart(data []byte) error {
+	if w.uploadID == "" {
+		id, err := w.bucket.CreateMultipartUpload(w.ctx, w.key)
+		if err != nil {
+			return err
+		}
+		w.uploadID = id
+	}
+
+	number := len(w.parts) + 1
+	etag, err := w.bucket.UploadPart(w.ctx, w.key, w.uploadID, number, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	w.parts = append(w.parts, Part{Number: number, ETag: etag})
+	return nil
+}
+
+// fail aborts the upload, so that the object is left as it was, and has all
+// further writes fail with err.
+func (w *writer) fail(err error) error {
+	if w.err == nil {
+		w.err = err
+	}
+	if w.uploadID != "" {
+		w.bucket.AbortMultipartUpload(w.ctx, w.key, w.uploadID)
+		w.uploadID = ""
+	}
+	w.buf, w.pending = nil, nil
+	return w.err
+}
+
+// TransferError aborts the upload, implementing sftp.TransferError.
+func (w *writer) TransferError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.fail(err)
+}
+
+// Close completes the upload, replacing the object.
+func (w *writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+
+	switch {
+	case w.err != nil:
+		return w.err
+	case len(w.pending) > 0:
+		return w.fail(errHole)
+	case w.uploadID == "":
+		if err := w.bucket.PutObject(w.ctx, w.key, bytes.NewReader(w.buf), int64(len(w.buf))); err != nil {
+			return w.fail(err)
+		}
+		return nil
+	}
+
+	if len(w.buf) > 0 {
+		if err := w.uploadPart(w.buf); err != nil {
+			return w.fail(err)
+		}
+	}
+	if err := w.bucket.CompleteMultipartUpload(w.ctx, w.key, w.uploadID, w.parts); err != nil {
+		return w.fail(err)
+	}
+	return nil
+}
+
+// Filecmd serves the commands, see sftp.FileCmder.
+func (fsys *FS) Filecmd(r *sftp.Request) error {
+	ctx := r.Context()
+
+	switch r.Method {
+	case "Setstat":
+		_, err := fsys.stat(ctx, r.Filepath)
+		if err != nil {
+			return pathError("setstat", r.Filepath, err)
+		}
+		return nil
+
+	case "Rename":
+		return fsys.rename(ctx, r.Filepath, r.Target, false)
+
+	case "Mkdir":
+		if isRoot(r.Filepath) {
+			return pathError("mkdir", r.Filepath, syscall.EEXIST)
+		}
+		if _, err := fsys.stat(ctx, r.Filepath); err == nil {
+			return pathError("mkdir", r.Filepath, syscall.EEXIST)
+		}
+		err := fsys.Bucket.PutObject(ctx, fsys.dirPrefix(r.Filepath), bytes.NewReader(nil), 0)
+		if err != nil {
+			return pathError("mkdir", r.Filepath, err)
+		}
+		return nil
+
+	case "Rmdir":
+		if isRoot(r.Filepath) {
+			return pathError("rmdir", r.Filepath, errIsRoot)
+		}
+		fi, err := fsys.stat(ctx, r.Filepath)
+		switch {
+		case err != nil:
+			return pathError("rmdir", r.Filepath, err)
+		case !fi.IsDir():
+			return pathError("rmdir", r.Filepath, syscall.ENOTDIR)
+		}
+		prefix := fsys.dirPrefix(r.Filepath)
+		empty, err := fsys.isEmpty(ctx, prefix, true)
+		switch {
+		case err != nil:
+			return pathError("rmdir", r.Filepath, err)
+		case !empty:
+			return pathError("rmdir", r.Filepath, errNotEmpty)
+		}
+		if err := fsys.Bucket.DeleteObject(ctx, prefix); err != nil && !errors.Is(err, ErrNotFound) {
+			return pathError("rmdir", r.Filepath, err)
+		}
+		return nil
+
+	case "Remove":
+		key := fsys.key(r.Filepath)
+		if _, err := fsys.Bucket.HeadObject(ctx, key); err != nil {
+			return pathError("remove", r.Filepath, err)
+		}
+		if err := fsys.Bucket.DeleteObject(ctx, key); err != nil {
+			return pathError("remove", r.Filepath, err)
+		}
+		return nil
+
+	case "Symlink":
+		return pathError("symlink", r.Target, errNotAllowed)
+	}
+
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// PosixRename renames like Rename, but replaces an existing target file,
+// implementing sftp.PosixRenameFileCmder.
+func (fsys *FS) PosixRename(r *sftp.Request) error {
+	return fsys.rename(r.Context(), r.Filepath, r.Target, true)
+}
+
+func (fsys *FS) rename(ctx context.Context, oldpath, newpath string, replace bool) error {
+	if isRoot(oldpath) || isRoot(newpath) {
+		return pathError("rename", oldpath, errIsRoot)
+	}
+
+	fi, err := fsys.stat(ctx, oldpath)
+	if err != nil {
+		return pathError("rename", oldpath, err)
+	}
+	target, err := fsys.stat(ctx, newpath)
+	switch {
+	case err == nil && (!replace || fi.IsDir() || target.IsDir()):
+		// SFTP-v2: it is an error if there already exists a file with the name specified by newpath.
+		return pathError("rename", newpath, syscall.EEXIST)
+	case err != nil && !errors.Is(err, syscall.ENOENT):
+		return pathError("rename", newpath, err)
+	}
+
+	if !fi.IsDir() {
+		if err := fsys.move(ctx, fsys.key(oldpath), fsys.key(newpath)); err != nil {
+			return pathError("rename", oldpath, err)
+		}
+		return nil
+	}
+
+	oldPrefix, newPrefix := fsys.dirPrefix(oldpath), fsys.dirPrefix(newpath)
+	if strings.HasPrefix(newPrefix, oldPrefix) {
+		return pathError("rename", newpath, syscall.EINVAL)
+	}
+	token := ""
+	for {
+		// the listing is not affected by the moves, as the keys are
+		// listed in order, and each page continues after the last key
+		res, err := fsys.Bucket.ListObjects(ctx, oldPrefix, "", token)
+		if err != nil {
+			return pathError("rename", oldpath, err)
+		}
+		for _, obj := range res.Objects {
+			if err := fsys.move(ctx, obj.Key, newPrefix+strings.TrimPrefix(obj.Key, oldPrefix)); err != nil {
+				return pathError("rename", oldpath, err)
+			}
+		}
+		if res.NextToken == "" {
+			return nil
+		}
+		token = res.NextToken
+	}
+}
+
+// move copies the object at src to dst, and deletes it.
+func (fsys *FS) move(ctx context.Context, src, dst string) error {
+	if err := fsys.Bucket.CopyObject(ctx, src, dst); err != nil {
+		return err
+	}
+	return fsys.Bucket.DeleteObject(ctx, src)
+}
+
+// Filelist serves List and Stat, see sftp.FileLister.
+func (fsys *FS) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		fi, err := fsys.stat(r.Context(), r.Filepath)
+		switch {
+		case err != nil:
+			return nil, pathError("readdir", r.Filepath, err)
+		case !fi.IsDir():
+			return nil, pathError("readdir", r.Filepath, syscall.ENOTDIR)
+		}
+		return &lister{ctx: r.Context(), bucket: fsys.Bucket, prefix: fsys.dirPrefix(r.Filepath)}, nil
+
+	case "Stat":
+		fi, err := fsys.stat(r.Context(), r.Filepath)
+		if err != nil {
+			return nil, pathError("stat", r.Filepath, err)
+		}
+		return listerAt{fi}, nil
+
+	case "Readlink":
+		return nil, pathError("readlink", r.Filepath, syscall.EINVAL)
+	}
+
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// listerAt lists a fixed set of fs.FileInfos.
+type listerAt []fs.FileInfo
+
+func (l listerAt) ListAt(ls []fs.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// lister lists a directory, fetching the pages of its listing as they are
+// asked for.
+type lister struct {
+	ctx    context.Context
+	bucket Bucket
+	prefix string
+
+	mu      sync.Mutex
+	entries []fs.FileInfo
+	token   string
+	done    bool
+}
+
+func (l *lister) ListAt(ls []fs.FileInfo, offset int64) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for !l.done && offset+int64(len(ls)) > int64(len(l.entries)) {
+		if err := l.fetch(); err != nil {
+			return 0, err
+		}
+	}
+
+	if offset >= int64(len(l.entries)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l.entries[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fetch appends the entries of the next page of the listing.
+func (l *lister) fetch() error {
+	res, err := l.bucket.ListObjects(l.ctx, l.prefix, "/", l.token)
+	if err != nil {
+		return err
+	}
+
+	for _, prefix := range res.CommonPrefixes {
+		name := strings.TrimSuffix(strings.TrimPrefix(prefix, l.prefix), "/")
+		l.entries = append(l.entries, &fileInfo{name: name, dir: true})
+	}
+	for _, obj := range res.Objects {
+		if obj.Key == l.prefix {
+			continue // the marker of the directory
+		}
+		l.entries = append(l.entries, &fileInfo{
+			name:    strings.TrimPrefix(obj.Key, l.prefix),
+			size:    obj.Size,
+			modTime: obj.LastModified,
+		})
+	}
+
+	l.token = res.NextToken
+	l.done = res.NextToken == ""
+	return nil
+}
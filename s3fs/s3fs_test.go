@@ -0,0 +1,309 @@
+package s3fs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pkg/sftp"
+)
+
+// memBucket is a Bucket in memory, listing pageSize keys and prefixes per
+// page.
+type memBucket struct {
+	pageSize int
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+	nextID  int
+	ranges  int // the range GETs
+	parts   int // the parts uploaded
+}
+
+func newMemBucket() *memBucket {
+	return &memBucket{
+		pageSize: 2,
+		objects:  make(map[string][]byte),
+		uploads:  make(map[string]map[int][]byte),
+	}
+}
+
+func (b *memBucket) HeadObject(ctx context.Context, key string) (Object, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data, ok := b.objects[key]
+	if !ok {
+		return Object{}, fmt.Errorf("head %s: %w", key, ErrNotFound)
+	}
+	return Object{Key: key, Size: int64(len(data)), LastModified: time.Unix(1, 0)}, nil
+}
+
+func (b *memBucket) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data, ok := b.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("get %s: %w", key, ErrNotFound)
+	}
+	b.ranges++
+	return ioutil.NopCloser(bytes.NewReader(data[offset : offset+length])), nil
+}
+
+func (b *memBucket) PutObject(ctx context.Context, key string, body io.Reader, size int64) error {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[key] = data
+	return nil
+}
+
+func (b *memBucket) CopyObject(ctx context.Context, srcKey, dstKey string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data, ok := b.objects[srcKey]
+	if !ok {
+		return fmt.Errorf("copy %s: %w", srcKey, ErrNotFound)
+	}
+	b.objects[dstKey] = data
+	return nil
+}
+
+func (b *memBucket) DeleteObject(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.objects, key)
+	return nil
+}
+
+func (b *memBucket) ListObjects(ctx context.Context, prefix, delimiter, token string) (*ListResult, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// the entries are the keys and the common prefixes, in order
+	isPrefix := make(map[string]bool)
+	var entries []string
+	for key := range b.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		entry, common := key, false
+		if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			entry, common = key[:len(prefix)+i+len(delimiter)], true
+		}
+		if _, ok := isPrefix[entry]; !ok {
+			entries = append(entries, entry)
+		}
+		isPrefix[entry] = common
+	}
+	sort.Strings(entries)
+
+	res := new(ListResult)
+	for _, entry := range entries {
+		if entry <= token {
+			continue
+		}
+		if len(res.Objects)+len(res.CommonPrefixes) == b.pageSize {
+			break
+		}
+		if isPrefix[entry] {
+			res.CommonPrefixes = append(res.CommonPrefixes, entry)
+		} else {
+			res.Objects = append(res.Objects, Object{Key: entry, Size: int64(len(b.objects[entry]))})
+		}
+		res.NextToken = entry
+	}
+	if len(res.Objects)+len(res.CommonPrefixes) < b.pageSize {
+		res.NextToken = ""
+	}
+	return res, nil
+}
+
+func (b *memBucket) CreateMultipartUpload(ctx context.Context, key string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	id := strconv.Itoa(b.nextID)
+	b.uploads[id] = make(map[int][]byte)
+	return id, nil
+}
+
+func (b *memBucket) UploadPart(ctx context.Context, key, uploadID string, number int, body io.Reader, size int64) (string, error) {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.uploads[uploadID][number] = data
+	b.parts++
+	return "etag-" + strconv.Itoa(number), nil
+}
+
+func (b *memBucket) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var data []byte
+	for _, part := range parts {
+		data = append(data, b.uploads[uploadID][part.Number]...)
+	}
+	b.objects[key] = data
+	delete(b.uploads, uploadID)
+	return nil
+}
+
+func (b *memBucket) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.uploads, uploadID)
+	return nil
+}
+
+// serve serves fsys to a client over pipes.
+func serve(t *testing.T, fsys *FS) *sftp.Client {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server := sftp.NewRequestServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw}, fsys.Handlers())
+	go server.Serve()
+	client, err := sftp.NewClientPipe(cr, cw)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return client
+}
+
+func TestReadWrite(t *testing.T) {
+	bucket := newMemBucket()
+	client := serve(t, &FS{Bucket: bucket, Prefix: "alice/", PartSize: 1 << 10})
+
+	small, err := client.Create("/small")
+	require.NoError(t, err)
+	_, err = small.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, small.Close())
+	assert.Equal(t, []byte("hello"), bucket.objects["alice/small"])
+	assert.Zero(t, bucket.parts, "a single PutObject")
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	large, err := client.Create("/large")
+	require.NoError(t, err)
+	_, err = large.ReadFrom(bytes.NewReader(content))
+	require.NoError(t, err)
+	require.NoError(t, large.Close())
+	assert.Equal(t, content, bucket.objects["alice/large"])
+	assert.Equal(t, 16, bucket.parts)
+	assert.Empty(t, bucket.uploads)
+
+	f, err := client.Open("/large")
+	require.NoError(t, err)
+	b := make([]byte, 4)
+	_, err = f.ReadAt(b, 20)
+	require.NoError(t, err)
+	assert.Equal(t, "4567", string(b))
+	assert.NotZero(t, bucket.ranges)
+	require.NoError(t, f.Close())
+
+	_, err = client.OpenFile("/small", os.O_WRONLY|os.O_APPEND)
+	assert.Error(t, err)
+	_, err = client.OpenFile("/small", os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	assert.Error(t, err)
+	_, err = client.Open("/missing")
+	assert.True(t, os.IsNotExist(err), "%v", err)
+}
+
+func TestWriteOrder(t *testing.T) {
+	bucket := newMemBucket()
+	fsys := &FS{Bucket: bucket, PartSize: 4}
+	w := &writer{ctx: context.Background(), bucket: bucket, key: "k", partSize: fsys.partSize(), pending: make(map[int64][]byte)}
+
+	_, err := w.WriteAt([]byte("efgh"), 4)
+	require.NoError(t, err)
+	_, err = w.WriteAt([]byte("ij"), 8)
+	require.NoError(t, err)
+	assert.Zero(t, bucket.parts, "waiting for the start")
+	_, err = w.WriteAt([]byte("abcd"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, bucket.parts)
+	require.NoError(t, w.Close())
+	assert.Equal(t, "abcdefghij", string(bucket.objects["k"]))
+
+	w = &writer{ctx: context.Background(), bucket: bucket, key: "hole", partSize: 4, pending: make(map[int64][]byte)}
+	_, err = w.WriteAt([]byte("late"), 8)
+	require.NoError(t, err)
+	assert.Equal(t, errHole, w.Close())
+	assert.NotContains(t, bucket.objects, "hole")
+}
+
+func TestDirectories(t *testing.T) {
+	bucket := newMemBucket()
+	client := serve(t, &FS{Bucket: bucket})
+
+	for _, key := range []string{"a/1", "a/2", "a/b/3", "c", "d/"} {
+		bucket.objects[key] = []byte(key)
+	}
+
+	names := func(dir string) []string {
+		fis, err := client.ReadDir(dir)
+		require.NoError(t, err)
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		sort.Strings(names)
+		return names
+	}
+	assert.Equal(t, []string{"a", "c", "d"}, names("/"))
+	assert.Equal(t, []string{"1", "2", "b"}, names("/a"))
+	assert.Empty(t, names("/d"))
+
+	fi, err := client.Stat("/a/b")
+	require.NoError(t, err)
+	assert.True(t, fi.IsDir())
+
+	require.NoError(t, client.Mkdir("/e"))
+	assert.Contains(t, bucket.objects, "e/")
+	assert.Error(t, client.Mkdir("/e"))
+	require.NoError(t, client.RemoveDirectory("/e"))
+	assert.NotContains(t, bucket.objects, "e/")
+	assert.Error(t, client.RemoveDirectory("/a"), "not empty")
+
+	require.NoError(t, client.Rename("/a", "/z"))
+	assert.Equal(t, []string{"1", "2", "b"}, names("/z"))
+	assert.NotContains(t, bucket.objects, "a/1")
+	assert.Error(t, client.Rename("/c", "/z/1"), "SFTP renames do not replace")
+	require.NoError(t, client.PosixRename("/c", "/z/1"))
+	assert.Equal(t, []byte("c"), bucket.objects["z/1"])
+
+	require.NoError(t, client.Remove("/z/1"))
+	assert.NotContains(t, bucket.objects, "z/1")
+	assert.Error(t, client.Symlink("/z/2", "/link"))
+}
@@ -0,0 +1,138 @@
+package sftp
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/pkg/sftp/apis"
+)
+
+// sshFxpFileDataPacket is a data packet, whose payload is sent from the file
+// straight to the socket with sendfile(2), rather than read into memory first.
+// It owns file, a duplicate of the handle's, as the handle may be closed
+// before the response is sent.
+type sshFxpFileDataPacket struct {
+	ID     uint32
+	Length uint32
+	file   *os.File
+	offset int64
+}
+
+func (p *sshFxpFileDataPacket) id() uint32 { return p.ID }
+
+func (p *sshFxpFileDataPacket) marshalPacket() ([]byte, []byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpData)
+	b = marshalUint32(b, p.ID)
+	b = marshalUint32(b, p.Length)
+
+	// the payload follows through sendPayload
+	return b, nil, nil
+}
+
+// MarshalBinary reads the payload into the packet,
+// for where it is not sent with sendPacket.
+func (p *sshFxpFileDataPacket) MarshalBinary() ([]byte, error) {
+	defer p.file.Close()
+
+	b := make([]byte, dataHeaderLen+int(p.Length))
+	if _, err := p.file.ReadAt(b[dataHeaderLen:], p.offset); err != nil {
+		return nil, err
+	}
+	// b[0:4] will be overwritten with the length in sendPacket
+	b[4] = sshFxpData
+	binary.BigEndian.PutUint32(b[5:9], p.ID)
+	binary.BigEndian.PutUint32(b[9:13], p.Length)
+	return b, nil
+}
+
+func (p *sshFxpFileDataPacket) payloadLength() int { return int(p.Length) }
+
+// sendPayload sends the payload after the header, all of it or the packet
+// is cut short, which leaves the peer unable to find the next one.
+func (p *sshFxpFileDataPacket) sendPayload(w io.Writer) error {
+	defer p.file.Close()
+
+	if c, ok := w.(*conn); ok {
+		w = c.WriteCloser
+	}
+
+	var n int
+	var err error
+	if sc, ok := w.(syscall.Conn); ok && canSendfile {
+		n, err = sendfile(sc, p.file, p.offset, int(p.Length))
+	} else {
+		var m int64
+		m, err = io.Copy(w, io.NewSectionReader(p.file, p.offset, int64(p.Length)))
+		n = int(m)
+	}
+	if err == nil && n < int(p.Length) {
+		// the file shrunk since its size was taken
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// filePayloader is a packet, whose payload of payloadLength bytes
+// sendPayload writes after the header.
+type filePayloader interface {
+	payloadLength() int
+	sendPayload(w io.Writer) error
+}
+
+// sendfileData returns the response to p sending the data with sendfile(2),
+// if the handle is an OS file and the server writes to a TCP connection.
+// It returns nil to read the data as usual, also at the end of the file.
+func (svr *Server) sendfileData(p *sshFxpReadPacket) responsePacket {
+	if !canSendfile || svr.compression.get() != nil {
+		return nil
+	}
+	if _, ok := svr.conn.WriteCloser.(*net.TCPConn); !ok {
+		return nil
+	}
+
+	f, ok := svr.getHandle(p.Handle)
+	if !ok {
+		return nil
+	}
+	osFile, ok := apis.OSFile(f)
+	if !ok {
+		return nil
+	}
+	offset, err := toInt64(p.Offset)
+	if err != nil {
+		return nil
+	}
+	fi, err := osFile.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil
+	}
+
+	length := int64(p.Len)
+	if length > int64(maxTxPacket) {
+		length = int64(maxTxPacket)
+	}
+	if remaining := fi.Size() - offset; remaining < length {
+		length = remaining
+	}
+	if length <= 0 {
+		return nil
+	}
+
+	dup, err := dupFile(osFile)
+	if err != nil {
+		return nil
+	}
+	return &sshFxpFileDataPacket{
+		ID:     p.ID,
+		Length: uint32(length),
+		file:   dup,
+		offset: offset,
+	}
+}
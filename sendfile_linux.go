@@ -0,0 +1,84 @@
+//go:build linux
+// +build linux
+
+package sftp
+
+import (
+	"os"
+	"syscall"
+)
+
+// canSendfile reports whether sendfile(2) is available.
+const canSendfile = true
+
+// dupFile returns a new *os.File for the file descriptor of f,
+// which stays open when f is closed.
+func dupFile(f *os.File) (*os.File, error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	fd := -1
+	var dupErr error
+	err = rc.Control(func(oldfd uintptr) {
+		fd, dupErr = syscall.Dup(int(oldfd))
+	})
+	if err == nil {
+		err = dupErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	syscall.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), f.Name()), nil
+}
+
+// sendfile sends n bytes of f starting at off to the socket dst with
+// sendfile(2), without going through user space or moving the offset of f.
+// It returns how many bytes it sent, which are fewer at the end of the file.
+func sendfile(dst syscall.Conn, f *os.File, off int64, n int) (int, error) {
+	out, err := dst.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	in, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var sent int
+	var sendErr, writeErr error
+	err = in.Control(func(infd uintptr) {
+		writeErr = out.Write(func(outfd uintptr) bool {
+			for sent < n {
+				m, err := syscall.Sendfile(int(outfd), int(infd), &off, n-sent)
+				if m > 0 {
+					sent += m
+				}
+				switch {
+				case err == syscall.EINTR:
+					continue
+				case err == syscall.EAGAIN:
+					// wait for the socket to become writable
+					return false
+				case err != nil:
+					sendErr = os.NewSyscallError("sendfile", err)
+					return true
+				case m == 0:
+					// the end of the file
+					return true
+				}
+			}
+			return true
+		})
+	})
+	if err == nil {
+		err = writeErr
+	}
+	if err == nil {
+		err = sendErr
+	}
+	return sent, err
+}
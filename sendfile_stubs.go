@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package sftp
+
+import (
+	"os"
+	"syscall"
+)
+
+// canSendfile reports whether sendfile(2) is available.
+const canSendfile = false
+
+func dupFile(f *os.File) (*os.File, error) {
+	return nil, ErrSSHFxOpUnsupported
+}
+
+func sendfile(dst syscall.Conn, f *os.File, off int64, n int) (int, error) {
+	return 0, ErrSSHFxOpUnsupported
+}
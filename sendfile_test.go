@@ -0,0 +1,97 @@
+package sftp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tcpServerPair connects a Client to a Server of the OS files over TCP.
+func tcpServerPair(t *testing.T) (*Client, *Server) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	servers := make(chan *Server, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(servers)
+			return
+		}
+		server, err := NewServer(conn, apis.NewOS())
+		if err != nil {
+			conn.Close()
+			close(servers)
+			return
+		}
+		servers <- server
+		server.Serve()
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	// compressed data cannot be sent with sendfile
+	client, err := NewClientPipe(conn, conn, UseCompression())
+	require.NoError(t, err)
+	server, ok := <-servers
+	require.True(t, ok)
+	return client, server
+}
+
+func TestServerSendfile(t *testing.T) {
+	client, server := tcpServerPair(t)
+	defer client.Close()
+
+	want := make([]byte, 1<<20+123)
+	rand.New(rand.NewSource(1)).Read(want)
+	name := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(name, want, 0o644))
+
+	f, err := client.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	if canSendfile {
+		handle := f.handle
+		pkt := server.sendfileData(&sshFxpReadPacket{ID: 1, Handle: handle, Offset: uint64(len(want) - 10), Len: 100})
+		if assert.IsType(t, &sshFxpFileDataPacket{}, pkt) {
+			assert.Equal(t, uint32(10), pkt.(*sshFxpFileDataPacket).Length, "clamped to the end of the file")
+			pkt.(*sshFxpFileDataPacket).file.Close()
+		}
+		assert.Nil(t, server.sendfileData(&sshFxpReadPacket{ID: 1, Handle: handle, Offset: uint64(len(want)), Len: 100}))
+	}
+
+	got, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(want, got))
+
+	b := make([]byte, 10)
+	n, err := f.ReadAt(b, int64(len(want))-5)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestFileDataPacketCutShort(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(name, []byte("hello"), 0o644))
+	file, err := os.Open(name)
+	require.NoError(t, err)
+
+	// the file shrunk since the response was made
+	var buf bytes.Buffer
+	err = sendPacket(&buf, &sshFxpFileDataPacket{ID: 1, Length: 10, file: file})
+	var cut *cutShortError
+	assert.True(t, errors.As(err, &cut))
+	assert.Equal(t, 4+1+4+4+5, buf.Len())
+}
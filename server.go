@@ -15,7 +15,7 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/pkg/sftp/internal/apis"
+	"github.com/pkg/sftp/apis"
 )
 
 const (
@@ -26,7 +26,8 @@ const (
 // Server is an SSH File Transfer Protocol (sftp) server.
 // This is intended to provide the sftp subsystem to an ssh server daemon.
 // This implementation currently supports most of sftp server protocol version 3,
-// as specified at http://tools.ietf.org/html/draft-ietf-secsh-filexfer-02
+// as specified at http://tools.ietf.org/html/draft-ietf-secsh-filexfer-02,
+// and speaks versions 4 to 6 with clients offering them, see WithProtocolVersion.
 type Server struct {
 	*serverConn
 	debugStream   io.Writer
@@ -36,8 +37,36 @@ type Server struct {
 	openFilesLock sync.RWMutex
 	handleCount   int
 	fs            apis.Fs
+	features      *featureTracker
+	replication   *replication
+	cache         *serverCache
+	timeouts      *backendTimeouts
+	homeDir       HomeDirResolver
+	idNames       IDNameResolver
+	mmapMinSize   int64
+	duLimit       int
+	logger        Logger
+	metrics       MetricsCollector
+	trace         *sessionTrace
+	audit         *auditLog
+	root          *rootDir
+	accessControl func(op Operation, path string, flags uint32) error
+	pathPolicy    []PathRule
+	quota         *quotaTracker
+	handles       *handleTracker
+	specialFiles  SpecialFilePolicy
+	done          chan struct{} // closed once Serve stops reading requests
 }
 
+// ServerFS is the file system a Server serves, see package apis for how to
+// implement it. It is an alias of apis.Fs, ServerFile of apis.File.
+type (
+	ServerFS   = apis.Fs
+	ServerFile = apis.File
+)
+
+// SetAPI replaces the file system the Server serves, before it starts
+// serving.
 func (svr *Server) SetAPI(fs apis.Fs) {
 	svr.fs = fs
 }
@@ -48,27 +77,63 @@ func (svr *Server) nextHandle(f apis.File) string {
 	svr.handleCount++
 	handle := strconv.Itoa(svr.handleCount)
 	svr.openFiles[handle] = f
+	svr.handles.touch(handle)
+	if svr.metrics != nil {
+		svr.metrics.AddOpenHandles(1)
+	}
 	return handle
 }
 
 func (svr *Server) closeHandle(handle string) error {
 	svr.openFilesLock.Lock()
-	defer svr.openFilesLock.Unlock()
-	if f, ok := svr.openFiles[handle]; ok {
-		delete(svr.openFiles, handle)
-		return f.Close()
-	}
+	f, ok := svr.openFiles[handle]
+	delete(svr.openFiles, handle)
+	svr.openFilesLock.Unlock()
 
-	return EBADF
+	if !ok {
+		return EBADF
+	}
+	svr.handles.forget(handle)
+	if svr.metrics != nil {
+		svr.metrics.AddOpenHandles(-1)
+	}
+	// close outside of the lock, a hung backend must not block other handles
+	serverLocks.unlock(f.Name(), f, 0, 0)
+	return f.Close()
 }
 
 func (svr *Server) getHandle(handle string) (apis.File, bool) {
 	svr.openFilesLock.RLock()
 	defer svr.openFilesLock.RUnlock()
 	f, ok := svr.openFiles[handle]
+	if ok {
+		svr.handles.touch(handle)
+	}
 	return f, ok
 }
 
+// canReadLinks reports whether the Fs supports reading symbolic links,
+// unless it declares otherwise through apis.LinkReader it does as an
+// apis.Readlinker.
+func (svr *Server) canReadLinks() bool {
+	if _, ok := svr.fs.(apis.Readlinker); !ok {
+		return false
+	}
+	if r, ok := svr.fs.(apis.LinkReader); ok {
+		return r.CanReadLinks()
+	}
+	return true
+}
+
+// canWriteLinks reports whether the Fs supports creating links,
+// unless it declares otherwise through apis.LinkWriter it does.
+func (svr *Server) canWriteLinks() bool {
+	if w, ok := svr.fs.(apis.LinkWriter); ok {
+		return w.CanWriteLinks()
+	}
+	return true
+}
+
 type serverRespondablePacket interface {
 	encoding.BinaryUnmarshaler
 	id() uint32
@@ -80,12 +145,19 @@ type serverRespondablePacket interface {
 // functions may be specified to further configure the Server.
 //
 // A subsequent call to Serve() is required to begin serving files over SFTP.
+//
+// If the streams are a *net.TCPConn, reads from OS files are sent with
+// sendfile(2) where available, without copying the data through user space,
+// unless the client negotiated compression. Written data still arrives inside
+// the request packets.
 func NewServer(rwc io.ReadWriteCloser, fs apis.Fs, options ...ServerOption) (*Server, error) {
 	svrConn := &serverConn{
 		conn: conn{
 			Reader:      rwc,
 			WriteCloser: rwc,
+			compression: compression{offered: defaultCompressors()},
 		},
+		stats: newSessionStats(),
 	}
 	s := &Server{
 		serverConn:  svrConn,
@@ -93,6 +165,8 @@ func NewServer(rwc io.ReadWriteCloser, fs apis.Fs, options ...ServerOption) (*Se
 		pktMgr:      newPktMgr(svrConn),
 		openFiles:   make(map[string]apis.File),
 		fs:          fs,
+		features:    newFeatureTracker(),
+		done:        make(chan struct{}),
 	}
 
 	for _, o := range options {
@@ -136,6 +210,34 @@ func WithAllocator() ServerOption {
 	}
 }
 
+// WithMaxWorkers serves up to n reads and writes at a time, instead of
+// SftpServerWorkerCount, and limits the requests waiting to be served or
+// answered to n more. Once a client has that many requests outstanding,
+// the server stops reading further ones until it sent responses, so that
+// a client sending requests faster than they are served cannot exhaust
+// the memory of the server.
+func WithMaxWorkers(n int) ServerOption {
+	return func(s *Server) error {
+		if n < 1 {
+			return fmt.Errorf("sftp: max workers %d is not positive", n)
+		}
+		s.pktMgr.workers = n
+		s.pktMgr.slots = make(chan struct{}, 2*n)
+		return nil
+	}
+}
+
+// WithCompression offers the given compression algorithms, in order of
+// preference, to clients of this package. By default zstd is offered,
+// calling WithCompression without any algorithms turns compression off.
+// See Compressor.
+func WithCompression(compressors ...Compressor) ServerOption {
+	return func(s *Server) error {
+		s.compression.offered = compressors
+		return nil
+	}
+}
+
 type rxPacket struct {
 	pktType  fxp
 	pktBytes []byte
@@ -144,6 +246,13 @@ type rxPacket struct {
 // Up to N parallel servers
 func (svr *Server) sftpServerWorker(pktChan chan orderedRequest) error {
 	for pkt := range pktChan {
+		if err := svr.pktMgr.refuses(pkt.requestPacket); err != nil {
+			svr.pktMgr.readyPacket(
+				svr.pktMgr.newOrderedResponse(statusFromError(pkt.id(), err), pkt.orderID()),
+			)
+			continue
+		}
+
 		// readonly checks
 		readonly := true
 		switch pkt := pkt.requestPacket.(type) {
@@ -172,30 +281,90 @@ func (svr *Server) sftpServerWorker(pktChan chan orderedRequest) error {
 }
 
 func handlePacket(s *Server, p orderedRequest) error {
+	var logged loggedRequest
+	if s.observing() {
+		logged = newLoggedRequest(p.requestPacket)
+		logged.span = s.trace.startSpan(logged.ev.Packet)
+	}
+
+	var rpkt responsePacket
+	var err error
+	if s.root != nil {
+		var confined requestPacket
+		if confined, rpkt = s.confine(p.requestPacket); confined != nil {
+			p.requestPacket = confined
+		}
+	}
+	if (s.accessControl != nil || s.pathPolicy != nil) && rpkt == nil {
+		rpkt = s.checkAccess(p.requestPacket)
+	}
+	var charge quotaCharge
+	if s.quota != nil && rpkt == nil {
+		var quotaErr error
+		if charge, quotaErr = s.quota.before(s, p.requestPacket); quotaErr != nil {
+			rpkt = statusFromError(p.id(), quotaErr)
+		}
+	}
+	switch {
+	case rpkt != nil:
+	case s.timeouts != nil:
+		rpkt, err = s.respondWithTimeout(p)
+	default:
+		rpkt, err = respondPacket(s, p)
+	}
+	if err != nil {
+		return err
+	}
+
+	if s.quota != nil {
+		s.quota.after(rpkt, charge)
+	}
+	if s.replication != nil {
+		s.replicate(p.requestPacket, rpkt)
+	}
+	if s.cache != nil {
+		s.invalidateCache(p.requestPacket, rpkt)
+	}
+	if s.observing() {
+		ev := logged.served(rpkt)
+		observe(s.logger, s.metrics, logged.span, ev)
+		s.audit.served(logged, ev, rpkt)
+	}
+
+	s.pktMgr.readyPacket(s.pktMgr.newOrderedResponse(rpkt, p.orderID()))
+	return nil
+}
+
+// respondPacket serves p, returning the response to send.
+func respondPacket(s *Server, p orderedRequest) (responsePacket, error) {
 	var rpkt responsePacket
 	orderID := p.orderID()
 	switch p := p.requestPacket.(type) {
 	case *sshFxInitPacket:
 		rpkt = &sshFxVersionPacket{
-			Version:    sftpProtocolVersion,
-			Extensions: sftpExtensions,
+			Version:    s.protocol.negotiated(),
+			Extensions: s.compression.extensions(sftpExtensions),
 		}
 	case *sshFxpStatPacket:
 		// stat the requested file
-		info, err := s.fs.Stat(toLocalPath(p.Path))
+		name := toLocalPath(p.Path)
+		info, err := s.stat(name)
 		rpkt = &sshFxpStatResponse{
-			ID:   p.ID,
-			info: info,
+			ID:       p.ID,
+			info:     info,
+			extended: s.xattrs(name, err),
 		}
 		if err != nil {
 			rpkt = statusFromError(p.ID, err)
 		}
 	case *sshFxpLstatPacket:
 		// stat the requested file
-		info, err := s.fs.Lstat(toLocalPath(p.Path))
+		name := toLocalPath(p.Path)
+		info, err := s.lstat(name)
 		rpkt = &sshFxpStatResponse{
-			ID:   p.ID,
-			info: info,
+			ID:       p.ID,
+			info:     info,
+			extended: s.xattrs(name, err),
 		}
 		if err != nil {
 			rpkt = statusFromError(p.ID, err)
@@ -207,8 +376,9 @@ func handlePacket(s *Server, p orderedRequest) error {
 		if ok {
 			info, err = f.Stat()
 			rpkt = &sshFxpStatResponse{
-				ID:   p.ID,
-				info: info,
+				ID:       p.ID,
+				info:     info,
+				extended: s.xattrs(f.Name(), err),
 			}
 		}
 		if err != nil {
@@ -216,40 +386,31 @@ func handlePacket(s *Server, p orderedRequest) error {
 		}
 	case *sshFxpMkdirPacket:
 		// TODO FIXME: ignore flags field
-		err := s.fs.Mkdir(toLocalPath(p.Path), 0755)
+		err := apis.Mkdir(s.fs, toLocalPath(p.Path), 0755)
 		rpkt = statusFromError(p.ID, err)
 	case *sshFxpRmdirPacket:
-		err := s.fs.Remove(toLocalPath(p.Path))
+		err := apis.Remove(s.fs, toLocalPath(p.Path))
 		rpkt = statusFromError(p.ID, err)
 	case *sshFxpRemovePacket:
-		err := s.fs.Remove(toLocalPath(p.Filename))
+		err := apis.Remove(s.fs, toLocalPath(p.Filename))
 		rpkt = statusFromError(p.ID, err)
 	case *sshFxpRenamePacket:
-		err := s.fs.Rename(toLocalPath(p.Oldpath), toLocalPath(p.Newpath))
+		err := apis.Rename(s.fs, toLocalPath(p.Oldpath), toLocalPath(p.Newpath))
 		rpkt = statusFromError(p.ID, err)
 	case *sshFxpSymlinkPacket:
-		err := s.fs.Symlink(toLocalPath(p.Targetpath), toLocalPath(p.Linkpath))
+		err := error(ErrSSHFxOpUnsupported)
+		if s.canWriteLinks() {
+			err = apis.Symlink(s.fs, toLocalPath(p.Targetpath), toLocalPath(p.Linkpath))
+		}
 		rpkt = statusFromError(p.ID, err)
 	case *sshFxpClosePacket:
 		rpkt = statusFromError(p.ID, s.closeHandle(p.Handle))
 	case *sshFxpReadlinkPacket:
-		f, err := s.fs.Readlink(toLocalPath(p.Path))
-		rpkt = &sshFxpNamePacket{
-			ID: p.ID,
-			NameAttrs: []*sshFxpNameAttr{
-				{
-					Name:     f,
-					LongName: f,
-					Attrs:    emptyFileStat,
-				},
-			},
-		}
-		if err != nil {
-			rpkt = statusFromError(p.ID, err)
+		if !s.canReadLinks() {
+			rpkt = statusFromError(p.ID, ErrSSHFxOpUnsupported)
+			break
 		}
-	case *sshFxpRealpathPacket:
-		f, err := filepath.Abs(toLocalPath(p.Path))
-		f = cleanPath(f)
+		f, err := apis.Readlink(s.fs, toLocalPath(p.Path))
 		rpkt = &sshFxpNamePacket{
 			ID: p.ID,
 			NameAttrs: []*sshFxpNameAttr{
@@ -263,10 +424,12 @@ func handlePacket(s *Server, p orderedRequest) error {
 		if err != nil {
 			rpkt = statusFromError(p.ID, err)
 		}
+	case *sshFxpRealpathPacket:
+		rpkt = realpathResponse(p.ID, p.Path)
 	case *sshFxpOpendirPacket:
 		p.Path = toLocalPath(p.Path)
 
-		if stat, err := s.fs.Stat(p.Path); err != nil {
+		if stat, err := s.stat(p.Path); err != nil {
 			rpkt = statusFromError(p.ID, err)
 		} else if !stat.IsDir() {
 			rpkt = statusFromError(p.ID, &fs.PathError{
@@ -277,22 +440,34 @@ func handlePacket(s *Server, p orderedRequest) error {
 				Path:   p.Path,
 				Pflags: sshFxfRead,
 			}).respond(s)
+			if h, ok := rpkt.(*sshFxpHandlePacket); ok {
+				s.cacheListing(h.Handle, p.Path)
+			}
 		}
 	case *sshFxpReadPacket:
+		if rpkt = s.sendfileData(p); rpkt != nil {
+			break
+		}
 		var err error = EBADF
 		f, ok := s.getHandle(p.Handle)
 		if ok {
-			err = nil
-			data := p.getDataSlice(s.pktMgr.alloc, orderID)
-			n, _err := f.ReadAt(data, int64(p.Offset))
-			if _err != nil && (_err != io.EOF || n == 0) {
-				err = _err
-			}
-			rpkt = &sshFxpDataPacket{
-				ID:     p.ID,
-				Length: uint32(n),
-				Data:   data[:n],
-				// do not use data[:n:n] here to clamp the capacity, we allocated extra capacity above to avoid reallocations
+			var offset int64
+			if offset, err = toInt64(p.Offset); err == nil {
+				data := p.getDataSlice(s.pktMgr.alloc, orderID)
+				n, _err := f.ReadAt(data, offset)
+				if n == 0 && _err == nil {
+					// a backend may report the end of the file by reading nothing
+					_err = io.EOF
+				}
+				if _err != nil && (!errors.Is(_err, io.EOF) || n == 0) {
+					err = _err
+				}
+				rpkt = &sshFxpDataPacket{
+					ID:     p.ID,
+					Length: uint32(n),
+					Data:   data[:n],
+					// do not use data[:n:n] here to clamp the capacity, we allocated extra capacity above to avoid reallocations
+				}
 			}
 		}
 		if err != nil {
@@ -303,7 +478,10 @@ func handlePacket(s *Server, p orderedRequest) error {
 		f, ok := s.getHandle(p.Handle)
 		var err error = EBADF
 		if ok {
-			_, err = f.WriteAt(p.Data, int64(p.Offset))
+			var offset int64
+			if offset, err = toInt64(p.Offset); err == nil {
+				_, err = f.WriteAt(p.Data, offset)
+			}
 		}
 		rpkt = statusFromError(p.ID, err)
 	case *sshFxpExtendedPacket:
@@ -314,12 +492,13 @@ func handlePacket(s *Server, p orderedRequest) error {
 		}
 	case serverRespondablePacket:
 		rpkt = p.respond(s)
+	case *refusedRequest:
+		rpkt = statusFromError(p.ID, p.err)
 	default:
-		return fmt.Errorf("unexpected packet type %T", p)
+		return nil, fmt.Errorf("unexpected packet type %T", p)
 	}
 
-	s.pktMgr.readyPacket(s.pktMgr.newOrderedResponse(rpkt, orderID))
-	return nil
+	return rpkt, nil
 }
 
 // Serve serves SFTP connections until the streams stop or the SFTP subsystem
@@ -330,6 +509,16 @@ func (svr *Server) Serve() error {
 			svr.pktMgr.alloc.Free()
 		}
 	}()
+	if svr.replication != nil {
+		svr.replication.start(svr)
+	}
+	if svr.trace != nil {
+		svr.trace.begin("sftp.Server")
+	}
+	if svr.handles != nil {
+		go svr.handles.reap(svr.done, svr.reapHandle)
+	}
+
 	var wg sync.WaitGroup
 	runWorker := func(ch chan orderedRequest) {
 		wg.Add(1)
@@ -347,13 +536,15 @@ func (svr *Server) Serve() error {
 	var pktType uint8
 	var pktBytes []byte
 	for {
+		svr.pktMgr.acquire()
 		pktType, pktBytes, err = svr.serverConn.recvPacket(svr.pktMgr.getNextOrderID())
 		if err != nil {
 			// we don't care about releasing allocated pages here, the server will quit and the allocator freed
 			break
 		}
 
-		pkt, err = makePacket(rxPacket{fxp(pktType), pktBytes})
+		pkt, err = svr.protocol.makePacket(rxPacket{fxp(pktType), pktBytes})
+		svr.features.record(fxp(pktType), pkt)
 		if err != nil {
 			switch {
 			case errors.Is(err, errUnknownExtendedPacket):
@@ -372,17 +563,44 @@ func (svr *Server) Serve() error {
 		pktChan <- svr.pktMgr.newOrderedRequest(pkt)
 	}
 
-	close(pktChan) // shuts down sftpServerWorkers
-	wg.Wait()      // wait for all workers to exit
+	close(svr.done) // cancels long running requests
+	close(pktChan)  // shuts down sftpServerWorkers
+	wg.Wait()       // wait for all workers to exit
 
-	// close any still-open files
-	for handle, file := range svr.openFiles {
+	if svr.replication != nil {
+		svr.replication.stop() // wait for the mirror to catch up
+	}
+
+	// close any still-open files, backend calls which timed out may still open more
+	svr.openFilesLock.Lock()
+	openFiles := svr.openFiles
+	svr.openFiles = make(map[string]apis.File)
+	svr.openFilesLock.Unlock()
+	if svr.metrics != nil {
+		svr.metrics.AddOpenHandles(-len(openFiles))
+	}
+	for handle, file := range openFiles {
 		fmt.Fprintf(svr.debugStream, "sftp server file with handle %q left open: %v\n", handle, file.Name())
+		serverLocks.unlock(file.Name(), file, 0, 0)
 		file.Close()
 	}
+	if svr.timeouts != nil {
+		svr.timeouts.closePoisoned()
+	}
+	svr.audit.abandon()
+	usage := svr.features.snapshot()
+	fmt.Fprintf(svr.debugStream, "sftp server session features used: %v\n", usage)
+	reportFeatureUsage(svr.logger, svr.metrics, usage)
+	svr.trace.end(sessionError(err))
 	return err // error from recvPacket
 }
 
+// FeatureUsage returns the packet types and extensions the client has
+// requested so far in this session.
+func (svr *Server) FeatureUsage() FeatureUsage {
+	return svr.features.snapshot()
+}
+
 type ider interface {
 	id() uint32
 }
@@ -391,8 +609,9 @@ type ider interface {
 func (p *sshFxInitPacket) id() uint32 { return 0 }
 
 type sshFxpStatResponse struct {
-	ID   uint32
-	info fs.FileInfo
+	ID       uint32
+	info     fs.FileInfo
+	extended []StatExtended // the xattrs of the file
 }
 
 func (p *sshFxpStatResponse) marshalPacket() ([]byte, []byte, error) {
@@ -402,8 +621,15 @@ func (p *sshFxpStatResponse) marshalPacket() ([]byte, []byte, error) {
 	b = append(b, sshFxpAttrs)
 	b = marshalUint32(b, p.ID)
 
-	var payload []byte
-	payload = marshalFileInfo(payload, p.info)
+	flags, fileStat := fileStatFromInfo(p.info)
+	fileStat.Extended = p.extended
+	if times, ok := timesExtended(fileStat); ok {
+		fileStat.Extended = append(fileStat.Extended[:len(fileStat.Extended):len(fileStat.Extended)], times)
+	}
+	if len(fileStat.Extended) > 0 {
+		flags |= sshFileXferAttrExtended
+	}
+	payload := marshalFileStat(nil, flags, fileStat)
 
 	return b, payload, nil
 }
@@ -453,12 +679,39 @@ func (p *sshFxpOpenPacket) respond(svr *Server) responsePacket {
 	if p.hasPflags(sshFxfExcl) {
 		osFlags |= syscall.O_EXCL
 	}
-	f, err := svr.fs.OpenFile(toLocalPath(p.Path), osFlags, 0644)
+	if !p.hasPflags(sshFxfCreat) {
+		if err := svr.missing(toLocalPath(p.Path)); err != nil {
+			return statusFromError(p.ID, err)
+		}
+	}
+	if svr.handlesFull() {
+		return statusFromError(p.ID, errTooManyHandles)
+	}
+	refused, streamed := svr.specialFile(toLocalPath(p.Path))
+	if refused {
+		return refuseSpecialFile(p.ID)
+	}
+
+	// like OpenSSH, only the permissions of the attributes are applied,
+	// and only to a file being created
+	perm := fs.FileMode(0644)
+	if p.Flags&sshFileXferAttrPermissions != 0 {
+		attrs, _, err := unmarshalFileStatSafe(p.Flags, p.Attrs)
+		if err != nil {
+			return statusFromError(p.ID, err)
+		}
+		perm = fs.FileMode(attrs.Mode) & fs.ModePerm
+	}
+
+	f, err := svr.fs.OpenFile(toLocalPath(p.Path), osFlags, perm)
 	if err != nil {
 		return statusFromError(p.ID, err)
 	}
+	if streamed {
+		f = &streamedFile{File: f}
+	}
 
-	handle := svr.nextHandle(f)
+	handle := svr.nextHandle(svr.maybeMmap(f, osFlags))
 	return &sshFxpHandlePacket{ID: p.ID, Handle: handle}
 }
 
@@ -469,7 +722,12 @@ func (p *sshFxpReaddirPacket) respond(svr *Server) responsePacket {
 	}
 
 	dirents, err := f.ReadDir(128)
-	if err != nil {
+	if err == nil && len(dirents) == 0 {
+		// an empty listing is the end, or clients would ask forever
+		err = io.EOF
+	}
+	// only return EOF error if no entries are left to list
+	if err != nil && (!errors.Is(err, io.EOF) || len(dirents) == 0) {
 		return statusFromError(p.ID, err)
 	}
 
@@ -502,13 +760,16 @@ func (p *sshFxpSetstatPacket) respond(svr *Server) responsePacket {
 	if (p.Flags & sshFileXferAttrSize) != 0 {
 		var size uint64
 		if size, b, err = unmarshalUint64Safe(b); err == nil {
-			err = svr.fs.Truncate(p.Path, int64(size))
+			var n int64
+			if n, err = toInt64(size); err == nil {
+				err = apis.Truncate(svr.fs, p.Path, n)
+			}
 		}
 	}
 	if (p.Flags & sshFileXferAttrPermissions) != 0 {
 		var mode uint32
 		if mode, b, err = unmarshalUint32Safe(b); err == nil {
-			err = svr.fs.Chmod(p.Path, fs.FileMode(mode))
+			err = apis.Chmod(svr.fs, p.Path, fs.FileMode(mode))
 		}
 	}
 	if (p.Flags & sshFileXferAttrACmodTime) != 0 {
@@ -517,20 +778,24 @@ func (p *sshFxpSetstatPacket) respond(svr *Server) responsePacket {
 		if atime, b, err = unmarshalUint32Safe(b); err != nil {
 		} else if mtime, b, err = unmarshalUint32Safe(b); err != nil {
 		} else {
-			atimeT := time.Unix(int64(atime), 0)
-			mtimeT := time.Unix(int64(mtime), 0)
-			err = svr.fs.Chtimes(p.Path, atimeT, mtimeT)
+			anano, mnano := setstatNsec(p.Flags, p.Attrs.([]byte))
+			atimeT := time.Unix(int64(atime), anano)
+			mtimeT := time.Unix(int64(mtime), mnano)
+			err = apis.Chtimes(svr.fs, p.Path, atimeT, mtimeT)
 		}
 	}
 	if (p.Flags & sshFileXferAttrUIDGID) != 0 {
 		var uid uint32
 		var gid uint32
 		if uid, b, err = unmarshalUint32Safe(b); err != nil {
-		} else if gid, _, err = unmarshalUint32Safe(b); err != nil {
+		} else if gid, b, err = unmarshalUint32Safe(b); err != nil {
 		} else {
-			err = svr.fs.Chown(p.Path, int(uid), int(gid))
+			err = apis.Chown(svr.fs, p.Path, int(uid), int(gid))
 		}
 	}
+	if err == nil && (p.Flags&sshFileXferAttrExtended) != 0 {
+		err = svr.setxattrs(p.Path, b)
+	}
 
 	return statusFromError(p.ID, err)
 }
@@ -549,7 +814,10 @@ func (p *sshFxpFsetstatPacket) respond(svr *Server) responsePacket {
 	if (p.Flags & sshFileXferAttrSize) != 0 {
 		var size uint64
 		if size, b, err = unmarshalUint64Safe(b); err == nil {
-			err = f.Truncate(int64(size))
+			var n int64
+			if n, err = toInt64(size); err == nil {
+				err = f.Truncate(n)
+			}
 		}
 	}
 	if (p.Flags & sshFileXferAttrPermissions) != 0 {
@@ -564,24 +832,47 @@ func (p *sshFxpFsetstatPacket) respond(svr *Server) responsePacket {
 		if atime, b, err = unmarshalUint32Safe(b); err != nil {
 		} else if mtime, b, err = unmarshalUint32Safe(b); err != nil {
 		} else {
-			atimeT := time.Unix(int64(atime), 0)
-			mtimeT := time.Unix(int64(mtime), 0)
-			err = svr.fs.Chtimes(f.Name(), atimeT, mtimeT)
+			anano, mnano := setstatNsec(p.Flags, p.Attrs.([]byte))
+			atimeT := time.Unix(int64(atime), anano)
+			mtimeT := time.Unix(int64(mtime), mnano)
+			err = apis.Chtimes(svr.fs, f.Name(), atimeT, mtimeT)
 		}
 	}
 	if (p.Flags & sshFileXferAttrUIDGID) != 0 {
 		var uid uint32
 		var gid uint32
 		if uid, b, err = unmarshalUint32Safe(b); err != nil {
-		} else if gid, _, err = unmarshalUint32Safe(b); err != nil {
+		} else if gid, b, err = unmarshalUint32Safe(b); err != nil {
 		} else {
-			err = f.Chown(int(uid), int(gid))
+			err = apis.FileChown(svr.fs, f, int(uid), int(gid))
 		}
 	}
+	if err == nil && (p.Flags&sshFileXferAttrExtended) != 0 {
+		err = svr.setxattrs(f.Name(), b)
+	}
 
 	return statusFromError(p.ID, err)
 }
 
+// realpathResponse answers with the absolute, clean form of p.
+func realpathResponse(id uint32, p string) responsePacket {
+	f, err := filepath.Abs(toLocalPath(p))
+	if err != nil {
+		return statusFromError(id, err)
+	}
+	f = cleanPath(f)
+	return &sshFxpNamePacket{
+		ID: id,
+		NameAttrs: []*sshFxpNameAttr{
+			{
+				Name:     f,
+				LongName: f,
+				Attrs:    emptyFileStat,
+			},
+		},
+	}
+}
+
 func statusFromError(id uint32, err error) *sshFxpStatusPacket {
 	ret := &sshFxpStatusPacket{
 		ID: id,
@@ -605,17 +896,22 @@ func statusFromError(id uint32, err error) *sshFxpStatusPacket {
 	debug("statusFromError: error is %T %#v", err, err)
 	ret.StatusError.Code = sshFxFailure
 	ret.StatusError.msg = err.Error()
+	ret.err = err
 
 	if code, ok := translateSyscallError(err); ok {
 		ret.StatusError.Code = code
 		return ret
 	}
+	if errors.Is(err, apis.ErrUnsupported) {
+		ret.StatusError.Code = sshFxOPUnsupported
+		return ret
+	}
 
 	switch e := err.(type) {
 	case fxerr:
 		ret.StatusError.Code = uint32(e)
 	default:
-		if e == io.EOF {
+		if errors.Is(e, io.EOF) {
 			ret.StatusError.Code = sshFxEOF
 		}
 	}
@@ -29,7 +29,7 @@ import (
 	"testing"
 	"time"
 
-	"github.com/pkg/sftp/internal/apis"
+	"github.com/pkg/sftp/apis"
 
 	"github.com/kr/fs"
 	"github.com/stretchr/testify/assert"
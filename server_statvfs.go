@@ -0,0 +1,62 @@
+package sftp
+
+import (
+	"github.com/pkg/sftp/apis"
+)
+
+func (p *sshFxpExtendedPacketStatVFS) respond(svr *Server) responsePacket {
+	retPkt, err := svr.statVFS(toLocalPath(p.Path))
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+	retPkt.ID = p.ID
+
+	return retPkt
+}
+
+// statVFS returns the statistics of the file system containing name, as far
+// as the quota of the session, if any, leaves them.
+func (svr *Server) statVFS(name string) (*StatVFS, error) {
+	statFs, ok := svr.fs.(apis.StatVFSer)
+	if !ok && svr.quota == nil {
+		return nil, ErrSSHFxOpUnsupported
+	}
+
+	stat := quotaStatVFS()
+	if ok {
+		var err error
+		stat, err = statVFSFromAPI(statFs.StatVFS(name))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if svr.quota != nil {
+		stat = svr.quota.statVFS(stat)
+	}
+	return stat, nil
+}
+
+// getStatVFSForPath returns the statistics of the host file system containing name.
+func getStatVFSForPath(name string) (*StatVFS, error) {
+	return statVFSFromAPI(apis.NewOS().StatVFS(name))
+}
+
+func statVFSFromAPI(stat *apis.StatVFS, err error) (*StatVFS, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatVFS{
+		Bsize:   stat.Bsize,
+		Frsize:  stat.Frsize,
+		Blocks:  stat.Blocks,
+		Bfree:   stat.Bfree,
+		Bavail:  stat.Bavail,
+		Files:   stat.Files,
+		Ffree:   stat.Ffree,
+		Favail:  stat.Favail,
+		Fsid:    stat.Fsid,
+		Flag:    stat.Flag,
+		Namemax: stat.Namemax,
+	}, nil
+}
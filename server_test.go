@@ -12,34 +12,71 @@ import (
 	"syscall"
 	"testing"
 
-	"github.com/pkg/sftp/internal/apis"
+	"github.com/pkg/sftp/apis"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func clientServerPair(t *testing.T) (*Client, *Server) {
+// clientServerPair connects a Client to a Server of the AVFS over pipes.
+// The caller closes both.
+func clientServerPair(t testing.TB, options ...ServerOption) (*Client, *Server) {
+	return clientServerPairWith(t, options)
+}
+
+// clientServerPairWith is clientServerPair configuring the Client as well.
+func clientServerPairWith(t testing.TB, serverOptions []ServerOption, clientOptions ...ClientOption) (*Client, *Server) {
+	return clientServerPairFS(t, apis.NewAVFS(), serverOptions, clientOptions...)
+}
+
+// clientServerPairFS is clientServerPairWith serving fsys rather than the AVFS.
+func clientServerPairFS(t testing.TB, fsys apis.Fs, serverOptions []ServerOption, clientOptions ...ClientOption) (*Client, *Server) {
 	cr, sw := io.Pipe()
 	sr, cw := io.Pipe()
-	var options []ServerOption
+	options := serverOptions[:len(serverOptions):len(serverOptions)]
 	if *testAllocator {
 		options = append(options, WithAllocator())
 	}
 	server, err := NewServer(struct {
 		io.Reader
 		io.WriteCloser
-	}{sr, sw}, apis.NewAVFS(), options...)
+	}{sr, sw}, fsys, options...)
 	if err != nil {
 		t.Fatal(err)
 	}
-	go server.Serve()
-	client, err := NewClientPipe(cr, cw)
+	serve(server)
+	client, err := NewClientPipe(cr, cw, clientOptions...)
 	if err != nil {
 		t.Fatalf("%+v\n", err)
 	}
 	return client, server
 }
 
+// served holds the results of Serve of the servers the pair helpers start.
+var served sync.Map
+
+// serve runs Serve of server in the background, closing server once it
+// returns, see waitServed.
+func serve(server interface {
+	Serve() error
+	Close() error
+}) {
+	done := make(chan error, 1)
+	served.Store(server, done)
+	go func() {
+		err := server.Serve()
+		server.Close()
+		done <- err
+	}()
+}
+
+// waitServed waits for Serve of server, started by one of the pair helpers,
+// to return, which it does once the client is closed.
+func waitServed(server interface{}) error {
+	done, _ := served.Load(server)
+	return <-done.(chan error)
+}
+
 type sshFxpTestBadExtendedPacket struct {
 	ID        uint32
 	Extension string
@@ -155,6 +192,7 @@ func TestStatusFromError(t *testing.T) {
 	}
 	for _, tc := range testCases {
 		tc.pkt.StatusError.msg = tc.err.Error()
+		tc.pkt.err = tc.err
 		assert.Equal(t, tc.pkt, statusFromError(tc.pkt.ID, tc.err))
 	}
 }
@@ -250,3 +288,163 @@ func TestServerWithBrokenClient(t *testing.T) {
 		srv.Close()
 	}
 }
+
+// Offsets and sizes beyond 2^31 and 2^32 have to survive the round trip
+// through the uint64 fields on the wire, sparse files keep this cheap.
+func TestServerLargeOffsets(t *testing.T) {
+	skipIfWindows(t)
+	skipIfPlan9(t)
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	name := path.Join(dir, "large")
+	f, err := client.Create(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	for _, off := range []int64{1<<31 - 2, 1<<32 - 2} {
+		n, err := f.WriteAt([]byte("abcd"), off)
+		require.NoError(t, err)
+		assert.Equal(t, 4, n)
+
+		b := make([]byte, 4)
+		n, err = f.ReadAt(b, off)
+		require.NoError(t, err)
+		assert.Equal(t, "abcd", string(b[:n]))
+	}
+
+	fi, err := client.Stat(name)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<32+2), fi.Size())
+
+	infos, err := client.ReadDir(dir)
+	require.NoError(t, err)
+	if assert.Len(t, infos, 1) {
+		assert.Equal(t, int64(1<<32+2), infos[0].Size())
+	}
+
+	require.NoError(t, client.Truncate(name, 5<<30))
+	fi, err = f.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, int64(5<<30), fi.Size())
+
+	require.NoError(t, f.Truncate(6<<30))
+	fi, err = client.Stat(name)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6<<30), fi.Size())
+
+	_, err = f.ReadAt(make([]byte, 4), -1)
+	assert.ErrorIs(t, err, fs.ErrInvalid)
+	_, err = f.WriteAt([]byte("abcd"), -1)
+	assert.ErrorIs(t, err, fs.ErrInvalid)
+	assert.ErrorIs(t, f.Truncate(-1), fs.ErrInvalid)
+	assert.ErrorIs(t, client.Truncate(name, -1), fs.ErrInvalid)
+
+	// offsets that do not fit into an int64 are rejected by the server
+	id := client.nextID()
+	typ, data, err := client.clientConn.sendPacket(nil, &sshFxpReadPacket{
+		ID:     id,
+		Handle: f.handle,
+		Offset: 1 << 63,
+		Len:    4,
+	})
+	require.NoError(t, err)
+	if assert.Equal(t, byte(sshFxpStatus), typ) {
+		assert.Error(t, unmarshalStatus(id, data))
+	}
+}
+
+func TestServerStatVFS(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("StatVFS is implemented on linux and darwin")
+	}
+
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	_, ok := client.HasExtension("statvfs@openssh.com")
+	require.True(t, ok, "server doesn't list statvfs extension")
+
+	vfs, err := client.StatVFS("/")
+	require.NoError(t, err)
+	expected, err := getStatVFSForPath("/")
+	require.NoError(t, err)
+	assert.Equal(t, expected.Blocks, vfs.Blocks)
+	assert.Equal(t, expected.Frsize, vfs.Frsize)
+	assert.Equal(t, expected.Namemax, vfs.Namemax)
+
+	_, err = client.StatVFS("/a/missing/path")
+	assert.Error(t, err)
+}
+
+func TestServerFsync(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	_, ok := client.HasExtension("fsync@openssh.com")
+	require.True(t, ok, "server doesn't list fsync extension")
+
+	f, err := client.Create(path.Join(t.TempDir(), "synced"))
+	require.NoError(t, err)
+	_, err = f.Write([]byte("flush me"))
+	require.NoError(t, err)
+	assert.NoError(t, f.Sync())
+	require.NoError(t, f.Close())
+}
+
+// coreFs hides all but the core methods of the wrapped Fs.
+type coreFs struct {
+	apis.Fs
+}
+
+func TestServerCoreFs(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	server.SetAPI(coreFs{apis.NewOS()})
+
+	dir := t.TempDir()
+	name := path.Join(dir, "file")
+	require.NoError(t, os.WriteFile(name, []byte("core"), 0o644))
+
+	fi, err := client.Lstat(name)
+	require.NoError(t, err, "Lstat falls back to Stat")
+	assert.Equal(t, int64(4), fi.Size())
+	fis, err := client.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, fis, 1)
+
+	for op, err := range map[string]error{
+		"mkdir":    client.Mkdir(path.Join(dir, "sub")),
+		"remove":   client.Remove(name),
+		"rename":   client.Rename(name, name+".new"),
+		"symlink":  client.Symlink(name, name+".link"),
+		"chmod":    client.Chmod(name, 0o600),
+		"truncate": client.Truncate(name, 0),
+	} {
+		assert.True(t, errors.Is(err, ErrOpUnsupported), "%s: got %v", op, err)
+	}
+	_, err = client.ReadLink(name)
+	assert.True(t, errors.Is(err, ErrOpUnsupported), "got %v", err)
+}
+
+// statVFSlessFs hides the StatVFS method of the wrapped Fs.
+type statVFSlessFs struct {
+	apis.FullFs
+}
+
+func TestServerStatVFSUnsupported(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	server.SetAPI(statVFSlessFs{apis.NewOS()})
+
+	_, err := client.StatVFS("/")
+	assert.True(t, errors.Is(err, ErrOpUnsupported), "got %v", err)
+}
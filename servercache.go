@@ -0,0 +1,400 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp/apis"
+)
+
+// ServerCache caches what Servers learn from a backend they share: the paths
+// which do not exist, and the listings of directories. It serves fleets of
+// clients polling the same paths, whose sessions would otherwise all ask the
+// backend over and over again. See WithServerCache.
+type ServerCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu       sync.Mutex
+	gen      uint64 // counts invalidations, to drop what was loaded meanwhile
+	missing  map[missingKey]cachedMissing
+	listings map[string]cachedListing
+}
+
+// missingKey is a path stat or, if lstat is set, lstat did not find.
+type missingKey struct {
+	name  string
+	lstat bool
+}
+
+type cachedMissing struct {
+	err     error
+	expires time.Time
+}
+
+type cachedListing struct {
+	entries []fs.DirEntry
+	expires time.Time
+}
+
+// NewServerCache returns a ServerCache keeping entries for ttl,
+// and up to maxEntries of them at a time.
+func NewServerCache(ttl time.Duration, maxEntries int) (*ServerCache, error) {
+	if ttl <= 0 {
+		return nil, errors.New("sftp: server cache needs a positive ttl")
+	}
+	if maxEntries < 1 {
+		return nil, errors.New("sftp: server cache needs room for at least one entry")
+	}
+	return &ServerCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		missing:    make(map[missingKey]cachedMissing),
+		listings:   make(map[string]cachedListing),
+	}, nil
+}
+
+// WithServerCache lets the Server answer stat, lstat and open requests for
+// paths which do not exist, and directory listings, from cache. Passing the
+// same cache to the Servers of many sessions shares it between them, so they
+// have to serve the same backend at the same paths.
+//
+// Mutations through any of these Servers drop the entries of the paths they
+// touch. Changes made otherwise, or through other paths like symbolic links,
+// show up once the entries expired.
+func WithServerCache(cache *ServerCache) ServerOption {
+	return func(s *Server) error {
+		if cache == nil {
+			return errors.New("sftp: WithServerCache needs a cache")
+		}
+		s.cache = &serverCache{
+			shared:  cache,
+			writing: make(map[string]string),
+		}
+		return nil
+	}
+}
+
+// serverCache is the ServerCache of a single Server.
+type serverCache struct {
+	shared *ServerCache
+
+	mu      sync.Mutex
+	writing map[string]string // handle to local path, for handles open for writing
+}
+
+// cacheKey returns the key of the local path name,
+// relative paths depend on the working directory and are not cached.
+func cacheKey(name string) (string, bool) {
+	if !filepath.IsAbs(name) {
+		return "", false
+	}
+	return path.Clean(filepath.ToSlash(name)), true
+}
+
+// within reports whether the key name is dir or below it.
+func within(name, dir string) bool {
+	return name == dir || strings.HasPrefix(name, strings.TrimSuffix(dir, "/")+"/")
+}
+
+func (c *ServerCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// room reports whether there is room for another entry,
+// dropping the expired ones if there is not.
+func (c *ServerCache) room(now time.Time) bool {
+	if len(c.missing)+len(c.listings) < c.maxEntries {
+		return true
+	}
+	for k, m := range c.missing {
+		if now.After(m.expires) {
+			delete(c.missing, k)
+		}
+	}
+	for k, l := range c.listings {
+		if now.After(l.expires) {
+			delete(c.listings, k)
+		}
+	}
+	return len(c.missing)+len(c.listings) < c.maxEntries
+}
+
+// lookupMissing returns the error stat or lstat returned for the key name,
+// if it is cached. What lstat does not find, stat does not find either.
+func (c *ServerCache) lookupMissing(name string, lstat bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for _, k := range []missingKey{{name, true}, {name, false}} {
+		if !lstat || k.lstat {
+			if m, ok := c.missing[k]; ok && now.Before(m.expires) {
+				return m.err
+			}
+		}
+	}
+	return nil
+}
+
+// storeMissing caches err of stat or lstat for the key name, unless
+// something was invalidated since gen.
+func (c *ServerCache) storeMissing(gen uint64, name string, lstat bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.gen != gen || !c.room(now) {
+		return
+	}
+	c.missing[missingKey{name, lstat}] = cachedMissing{err: err, expires: now.Add(c.ttl)}
+}
+
+// lookupListing returns the cached listing of the directory with the key name.
+func (c *ServerCache) lookupListing(name string) ([]fs.DirEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	l, ok := c.listings[name]
+	if !ok || time.Now().After(l.expires) {
+		return nil, false
+	}
+	return l.entries, true
+}
+
+// storeListing caches the listing of the directory with the key name,
+// unless something was invalidated since gen.
+func (c *ServerCache) storeListing(gen uint64, name string, entries []fs.DirEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.gen != gen || !c.room(now) {
+		return
+	}
+	c.listings[name] = cachedListing{entries: entries, expires: now.Add(c.ttl)}
+}
+
+// invalidate drops all entries about the local path name and below it,
+// and the listing of its directory.
+func (c *ServerCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+
+	key, ok := cacheKey(name)
+	if !ok {
+		// no telling which entries a relative path refers to
+		c.missing = make(map[missingKey]cachedMissing)
+		c.listings = make(map[string]cachedListing)
+		return
+	}
+
+	for k := range c.missing {
+		if within(k.name, key) {
+			delete(c.missing, k)
+		}
+	}
+	for k := range c.listings {
+		if within(k, key) {
+			delete(c.listings, k)
+		}
+	}
+	delete(c.listings, path.Dir(key))
+}
+
+// stat is fs.Stat through the cache of missing paths.
+func (svr *Server) stat(name string) (fs.FileInfo, error) {
+	return svr.cachedStat(name, false, svr.fs.Stat)
+}
+
+// lstat is fs.Lstat through the cache of missing paths.
+func (svr *Server) lstat(name string) (fs.FileInfo, error) {
+	return svr.cachedStat(name, true, func(name string) (fs.FileInfo, error) {
+		return apis.Lstat(svr.fs, name)
+	})
+}
+
+func (svr *Server) cachedStat(name string, lstat bool, stat func(string) (fs.FileInfo, error)) (fs.FileInfo, error) {
+	key, ok := cacheKey(name)
+	if svr.cache == nil || !ok {
+		return stat(name)
+	}
+	c := svr.cache.shared
+
+	if err := c.lookupMissing(key, lstat); err != nil {
+		return nil, err
+	}
+
+	gen := c.generation()
+	info, err := stat(name)
+	if os.IsNotExist(err) {
+		c.storeMissing(gen, key, lstat, err)
+	}
+	return info, err
+}
+
+// missing returns the cached error of stat for the local path name,
+// if it is known not to exist.
+func (svr *Server) missing(name string) error {
+	key, ok := cacheKey(name)
+	if svr.cache == nil || !ok {
+		return nil
+	}
+	return svr.cache.shared.lookupMissing(key, false)
+}
+
+// cacheListing has the directory open at handle list its entries
+// through the cache.
+func (svr *Server) cacheListing(handle, name string) {
+	key, ok := cacheKey(name)
+	if svr.cache == nil || !ok {
+		return
+	}
+
+	svr.openFilesLock.Lock()
+	defer svr.openFilesLock.Unlock()
+	if f, ok := svr.openFiles[handle]; ok {
+		svr.openFiles[handle] = &cachedDir{File: f, cache: svr.cache.shared, key: key}
+	}
+}
+
+// invalidateCache drops the cached entries of the paths p mutates.
+// It has to be called before the response is sent, so that a client
+// never reads what it just changed from the cache.
+func (svr *Server) invalidateCache(p requestPacket, rpkt responsePacket) {
+	c := svr.cache
+
+	if e, ok := p.(*sshFxpExtendedPacket); ok {
+		p = e.SpecificPacket
+	}
+
+	switch p := p.(type) {
+	case *sshFxpOpenPacket:
+		if p.readonly() {
+			return
+		}
+		name := toLocalPath(p.Path)
+		if h, ok := rpkt.(*sshFxpHandlePacket); ok {
+			c.mu.Lock()
+			c.writing[h.Handle] = name
+			c.mu.Unlock()
+		}
+		c.shared.invalidate(name)
+	case *sshFxpClosePacket:
+		// the size and times changed with the writes
+		c.mu.Lock()
+		name, ok := c.writing[p.Handle]
+		delete(c.writing, p.Handle)
+		c.mu.Unlock()
+		if ok {
+			c.shared.invalidate(name)
+		}
+	case *sshFxpSetstatPacket:
+		c.shared.invalidate(toLocalPath(p.Path))
+	case *sshFxpFsetstatPacket:
+		if f, ok := svr.getHandle(p.Handle); ok {
+			c.shared.invalidate(f.Name())
+		}
+	case *sshFxpExtendedPacketAllocate:
+		if f, ok := svr.getHandle(p.Handle); ok {
+			c.shared.invalidate(f.Name())
+		}
+	case *sshFxpMkdirPacket:
+		c.shared.invalidate(toLocalPath(p.Path))
+	case *sshFxpRmdirPacket:
+		c.shared.invalidate(toLocalPath(p.Path))
+	case *sshFxpRemovePacket:
+		c.shared.invalidate(toLocalPath(p.Filename))
+	case *sshFxpRenamePacket:
+		c.shared.invalidate(toLocalPath(p.Oldpath))
+		c.shared.invalidate(toLocalPath(p.Newpath))
+	case *sshFxpExtendedPacketPosixRename:
+		c.shared.invalidate(toLocalPath(p.Oldpath))
+		c.shared.invalidate(toLocalPath(p.Newpath))
+	case *sshFxpExtendedPacketHardlink:
+		c.shared.invalidate(toLocalPath(p.Newpath))
+	case *sshFxpSymlinkPacket:
+		c.shared.invalidate(toLocalPath(p.Linkpath))
+	}
+}
+
+// cachedDir is a directory listed through the ServerCache.
+type cachedDir struct {
+	apis.File
+	cache *ServerCache
+	key   string
+
+	entries []fs.DirEntry // not read yet
+	loaded  bool
+}
+
+func (d *cachedDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := d.load()
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.loaded = entries, true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// load returns the cached listing, or lists the directory and caches it.
+func (d *cachedDir) load() ([]fs.DirEntry, error) {
+	if entries, ok := d.cache.lookupListing(d.key); ok {
+		return entries, nil
+	}
+
+	gen := d.cache.generation()
+	dirents, err := d.File.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	// resolve the infos now, the server asks for them anyway
+	entries := make([]fs.DirEntry, 0, len(dirents))
+	for _, dirent := range dirents {
+		info, err := dirent.Info()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, infoEntry{info})
+	}
+
+	d.cache.storeListing(gen, d.key, entries)
+	return entries, nil
+}
+
+// infoEntry is a fs.DirEntry of a resolved fs.FileInfo.
+type infoEntry struct {
+	fs.FileInfo
+}
+
+func (e infoEntry) Type() fs.FileMode { return e.Mode().Type() }
+
+func (e infoEntry) Info() (fs.FileInfo, error) { return e.FileInfo, nil }
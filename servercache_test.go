@@ -0,0 +1,185 @@
+package sftp
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingFs counts the stats and directory listings reaching the backend.
+type countingFs struct {
+	apis.FullFs
+
+	mu     sync.Mutex
+	stats  int
+	lists  int
+	opened int
+}
+
+func (c *countingFs) count(n *int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*n++
+}
+
+func (c *countingFs) counts() (stats, lists, opened int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats, c.lists, c.opened
+}
+
+func (c *countingFs) Stat(name string) (fs.FileInfo, error) {
+	c.count(&c.stats)
+	return c.FullFs.Stat(name)
+}
+
+func (c *countingFs) Lstat(name string) (fs.FileInfo, error) {
+	c.count(&c.stats)
+	return c.FullFs.Lstat(name)
+}
+
+func (c *countingFs) OpenFile(name string, flag int, perm os.FileMode) (apis.File, error) {
+	c.count(&c.opened)
+	f, err := c.FullFs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return countingFile{f, c}, nil
+}
+
+type countingFile struct {
+	apis.File
+	fs *countingFs
+}
+
+func (f countingFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	f.fs.count(&f.fs.lists)
+	return f.File.ReadDir(n)
+}
+
+// cachedClient connects a Client to a new Server of backend sharing cache.
+func cachedClient(t *testing.T, backend apis.Fs, cache *ServerCache) *Client {
+	client, server := clientServerPairFS(t, backend, []ServerOption{WithServerCache(cache)})
+	t.Cleanup(func() { client.Close() })
+	t.Cleanup(func() { server.Close() })
+	return client
+}
+
+func TestServerCache(t *testing.T) {
+	backend := &countingFs{FullFs: apis.NewAVFS()}
+	cache, err := NewServerCache(time.Hour, 100)
+	require.NoError(t, err)
+	c1 := cachedClient(t, backend, cache)
+	defer c1.Close()
+	c2 := cachedClient(t, backend, cache)
+	defer c2.Close()
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "polled")
+
+	// negative lookups are shared between the sessions
+	_, err = c1.Stat(name)
+	assert.True(t, os.IsNotExist(err))
+	_, err = c2.Lstat(name)
+	assert.True(t, os.IsNotExist(err))
+	_, err = c2.Stat(name)
+	assert.True(t, os.IsNotExist(err), "lstat did not find it, so neither does stat")
+	_, err = c2.Open(name)
+	assert.True(t, os.IsNotExist(err))
+	stats, _, opened := backend.counts()
+	assert.Equal(t, 2, stats)
+	assert.Equal(t, 0, opened)
+
+	// so are directory listings
+	for _, c := range []*Client{c1, c2} {
+		infos, err := c.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, infos)
+	}
+	_, lists, _ := backend.counts()
+	assert.Equal(t, 1, lists)
+
+	// mutations drop them
+	f, err := c1.Create(name)
+	require.NoError(t, err)
+	_, err = c2.Stat(name)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	infos, err := c2.ReadDir(dir)
+	require.NoError(t, err)
+	if assert.Len(t, infos, 1) {
+		assert.Equal(t, "polled", infos[0].Name())
+		assert.Equal(t, int64(5), infos[0].Size(), "closing a written file drops the listing")
+	}
+
+	require.NoError(t, c2.Mkdir(filepath.Join(dir, "sub")))
+	infos, err = c1.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, infos, 2)
+	_, lists, _ = backend.counts()
+	assert.Equal(t, 3, lists)
+
+	require.NoError(t, c1.Remove(name))
+	_, err = c2.Stat(name)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestServerCacheRename(t *testing.T) {
+	backend := &countingFs{FullFs: apis.NewAVFS()}
+	cache, err := NewServerCache(time.Hour, 100)
+	require.NoError(t, err)
+	c1 := cachedClient(t, backend, cache)
+	defer c1.Close()
+	c2 := cachedClient(t, backend, cache)
+	defer c2.Close()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "sub"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "sub", "file"), nil, 0o644))
+
+	moved := filepath.Join(dir, "dst", "sub", "file")
+	_, err = c1.Stat(moved)
+	assert.True(t, os.IsNotExist(err))
+	_, err = c1.ReadDir(filepath.Join(src, "sub"))
+	require.NoError(t, err)
+
+	// renaming a directory brings the paths below it into existence
+	require.NoError(t, c2.Rename(src, filepath.Join(dir, "dst")))
+	_, err = c1.Stat(moved)
+	assert.NoError(t, err)
+	_, err = c1.ReadDir(filepath.Join(src, "sub"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestServerCacheFull(t *testing.T) {
+	cache, err := NewServerCache(time.Hour, 2)
+	require.NoError(t, err)
+
+	cache.storeMissing(0, "/a", false, os.ErrNotExist)
+	cache.storeMissing(0, "/b", true, os.ErrNotExist)
+	cache.storeMissing(0, "/c", false, os.ErrNotExist)
+	assert.Error(t, cache.lookupMissing("/a", false))
+	assert.Error(t, cache.lookupMissing("/b", false))
+	assert.NoError(t, cache.lookupMissing("/a", true))
+	assert.NoError(t, cache.lookupMissing("/c", false), "there is no room")
+
+	cache.invalidate("/")
+	assert.Empty(t, cache.missing)
+	cache.storeMissing(0, "/c", false, os.ErrNotExist)
+	assert.Empty(t, cache.missing, "loaded before the invalidation")
+
+	_, err = NewServerCache(0, 1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,438 @@
+package sftp
+
+// SFTP protocol versions 4 to 6 support for Server and RequestServer
+// see https://tools.ietf.org/html/draft-ietf-secsh-filexfer-13
+
+import (
+	"encoding"
+	"errors"
+	"io/fs"
+	"path"
+	"strconv"
+	"sync/atomic"
+)
+
+// serverProtocol is the protocol version a Server or RequestServer speaks
+// with a client: the latest version both speak, up to version 6.
+//
+// Both servers only serve requests of version 3, so requests of later
+// versions are translated into version 3 on their way in, see makePacket,
+// and the attributes in the responses back on their way out, see encode.
+// Requests version 3 has no equivalent for, like opening a file in text mode
+// or setting its access control list, are refused with SSH_FX_OP_UNSUPPORTED.
+type serverProtocol struct {
+	version uint32 // accessed atomically, 0 until the client sent SSH_FXP_INIT
+}
+
+// negotiated returns the version spoken with the client.
+func (sp *serverProtocol) negotiated() uint32 {
+	if version := atomic.LoadUint32(&sp.version); version != 0 {
+		return version
+	}
+	return sftpProtocolVersion
+}
+
+// negotiate settles the version with the one offered by the client,
+// once: a repeated SSH_FXP_INIT does not change it anymore.
+func (sp *serverProtocol) negotiate(offered uint32) uint32 {
+	version := offered
+	if version > maxProtocolVersion {
+		version = maxProtocolVersion
+	}
+	if version < sftpProtocolVersion {
+		version = sftpProtocolVersion
+	}
+	atomic.CompareAndSwapUint32(&sp.version, 0, version)
+	return sp.negotiated()
+}
+
+// makePacket is makePacket for requests of the negotiated version. A request
+// that cannot be translated into version 3 comes back as a refusedRequest.
+func (sp *serverProtocol) makePacket(p rxPacket) (requestPacket, error) {
+	if p.pktType == sshFxpInit {
+		pkt, err := makePacket(p)
+		if init, ok := pkt.(*sshFxInitPacket); ok && err == nil {
+			sp.negotiate(init.Version)
+		}
+		return pkt, err
+	}
+
+	version := sp.negotiated()
+	if version < 4 {
+		return makePacket(p)
+	}
+
+	p, err := downgradeRequest(version, p)
+	if err != nil {
+		var code fxerr
+		if !errors.As(err, &code) {
+			err = ErrSSHFxBadMessage
+		}
+		id, _, _ := unmarshalUint32Safe(p.pktBytes)
+		return &refusedRequest{ID: id, err: err}, nil
+	}
+	return makePacket(p)
+}
+
+// refusedRequest is a request the servers answer with err instead of serving it.
+type refusedRequest struct {
+	ID  uint32
+	err error
+}
+
+func (p *refusedRequest) id() uint32 { return p.ID }
+
+func (p *refusedRequest) UnmarshalBinary(b []byte) error { return nil }
+
+// packetOf returns the received packet equivalent to the request m.
+func packetOf(m encoding.BinaryMarshaler) rxPacket {
+	b, _ := m.MarshalBinary()
+	return rxPacket{pktType: fxp(b[4]), pktBytes: b[5:]}
+}
+
+// downgradeRequest translates the request p of version into one of version 3.
+func downgradeRequest(version uint32, p rxPacket) (rxPacket, error) {
+	id, b, err := unmarshalUint32Safe(p.pktBytes)
+	if err != nil {
+		return p, err
+	}
+
+	switch p.pktType {
+	case sshFxpOpen:
+		var name string
+		var pflags uint32
+		if name, b, err = unmarshalStringSafe(b); err != nil {
+			return p, err
+		}
+		if version >= 5 {
+			var access, flags uint32
+			if access, b, err = unmarshalUint32Safe(b); err != nil {
+				return p, err
+			}
+			if flags, b, err = unmarshalUint32Safe(b); err != nil {
+				return p, err
+			}
+			if pflags, err = fromOpenV5(access, flags); err != nil {
+				return p, err
+			}
+		} else {
+			if pflags, b, err = unmarshalUint32Safe(b); err != nil {
+				return p, err
+			}
+			if pflags&sshFxfText != 0 {
+				return p, ErrSSHFxOpUnsupported
+			}
+		}
+		attrs, err := downgradeAttrs(version, b)
+		if err != nil {
+			return p, err
+		}
+		data := marshalString(marshalUint32(nil, id), name)
+		data = marshalUint32(data, pflags)
+		return rxPacket{pktType: sshFxpOpen, pktBytes: append(data, attrs...)}, nil
+
+	case sshFxpMkdir, sshFxpSetstat, sshFxpFsetstat:
+		// the path or handle stays, the attributes following it change
+		rest := skipString(b)
+		if rest == nil {
+			return p, errShortPacket
+		}
+		attrs, err := downgradeAttrs(version, rest)
+		if err != nil {
+			return p, err
+		}
+		data := append([]byte(nil), p.pktBytes[:len(p.pktBytes)-len(rest)]...)
+		return rxPacket{pktType: p.pktType, pktBytes: append(data, attrs...)}, nil
+
+	case sshFxpRename:
+		var oldpath, newpath string
+		var flags uint32
+		if oldpath, b, err = unmarshalStringSafe(b); err != nil {
+			return p, err
+		}
+		if newpath, b, err = unmarshalStringSafe(b); err != nil {
+			return p, err
+		}
+		if version >= 5 {
+			if flags, _, err = unmarshalUint32Safe(b); err != nil {
+				return p, err
+			}
+		}
+		if flags&sshFxfRenameOverwrite != 0 {
+			return packetOf(&sshFxpPosixRenamePacket{ID: id, Oldpath: oldpath, Newpath: newpath}), nil
+		}
+		return packetOf(&sshFxpRenamePacket{ID: id, Oldpath: oldpath, Newpath: newpath}), nil
+
+	case sshFxpLink:
+		var newLinkPath, existingPath string
+		if newLinkPath, b, err = unmarshalStringSafe(b); err != nil {
+			return p, err
+		}
+		if existingPath, b, err = unmarshalStringSafe(b); err != nil {
+			return p, err
+		}
+		if len(b) < 1 {
+			return p, errShortPacket
+		}
+		if b[0] != 0 {
+			return packetOf(&sshFxpSymlinkPacket{ID: id, Targetpath: existingPath, Linkpath: newLinkPath}), nil
+		}
+		return packetOf(&sshFxpHardlinkPacket{ID: id, Oldpath: existingPath, Newpath: newLinkPath}), nil
+
+	case sshFxpBlock, sshFxpUnblock:
+		// the extensions carry the same fields, see asExtension
+		ext := blockExtension
+		if p.pktType == sshFxpUnblock {
+			ext = unblockExtension
+		}
+		data := marshalString(marshalUint32(nil, id), ext)
+		return rxPacket{pktType: sshFxpExtended, pktBytes: append(data, b...)}, nil
+
+	case sshFxpRealpath:
+		if version < 6 {
+			return p, nil
+		}
+		// version 6 follows the path with a control byte,
+		// and then the paths to compose it with
+		var name string
+		if name, b, err = unmarshalStringSafe(b); err != nil {
+			return p, err
+		}
+		if len(b) > 0 {
+			b = b[1:]
+		}
+		for len(b) > 0 {
+			var compose string
+			if compose, b, err = unmarshalStringSafe(b); err != nil {
+				return p, err
+			}
+			if path.IsAbs(compose) {
+				name = compose
+			} else {
+				name = path.Join(name, compose)
+			}
+		}
+		return packetOf(&sshFxpRealpathPacket{ID: id, Path: name}), nil
+	}
+
+	// the remaining requests are the same, or only add fields at
+	// their end, like the attributes wanted by SSH_FXP_STAT
+	return p, nil
+}
+
+// fromOpenV5 converts the desired access and flags of version 5 into
+// SSH_FXF_* open flags of version 3, see toOpenV5.
+func fromOpenV5(access, flags uint32) (uint32, error) {
+	var pflags uint32
+	if access&ace4ReadData != 0 {
+		pflags |= sshFxfRead
+	}
+	if access&(ace4WriteData|ace4AppendData) != 0 {
+		pflags |= sshFxfWrite
+	}
+	if pflags == 0 {
+		pflags = sshFxfRead
+	}
+
+	switch flags & sshFxfAccessDisposition {
+	case sshFxfCreateNew:
+		pflags |= sshFxfCreat | sshFxfExcl
+	case sshFxfCreateTruncate:
+		pflags |= sshFxfCreat | sshFxfTrunc
+	case sshFxfOpenExisting:
+	case sshFxfOpenOrCreate:
+		pflags |= sshFxfCreat
+	case sshFxfTruncateExisting:
+		pflags |= sshFxfTrunc
+	default:
+		return 0, ErrSSHFxOpUnsupported
+	}
+
+	if flags&(sshFxfAppendData|sshFxfAppendDataAtomic) != 0 {
+		pflags |= sshFxfAppend
+	}
+	if flags&^(sshFxfAccessDisposition|sshFxfAppendData|sshFxfAppendDataAtomic) != 0 {
+		// text mode, byte range locks, no following of symlinks, ...
+		return 0, ErrSSHFxOpUnsupported
+	}
+	return pflags, nil
+}
+
+// downgradeAttrs converts the attributes b of version into those of version 3.
+func downgradeAttrs(version uint32, b []byte) ([]byte, error) {
+	flags, _, err := unmarshalUint32Safe(b)
+	if err != nil {
+		return nil, err
+	}
+	if flags&(sshFileXferAttrACL|sshFileXferAttrBits) != 0 {
+		return nil, ErrSSHFxOpUnsupported
+	}
+	stat, _ := unmarshalAttrsV4(version, b)
+
+	var v3flags uint32
+	if flags&sshFileXferAttrSize != 0 {
+		v3flags |= sshFileXferAttrSize
+	}
+	if flags&sshFileXferAttrOwnerGroup != 0 {
+		uid, err := strconv.ParseUint(stat.Owner, 10, 32)
+		if err != nil {
+			return nil, fxerr(sshFxOwnerInvalid)
+		}
+		gid, err := strconv.ParseUint(stat.Group, 10, 32)
+		if err != nil {
+			return nil, fxerr(sshFxGroupInvalid)
+		}
+		stat.UID, stat.GID = uint32(uid), uint32(gid)
+		v3flags |= sshFileXferAttrUIDGID
+	}
+	if flags&sshFileXferAttrPermissions != 0 {
+		stat.Mode &^= S_IFMT
+		v3flags |= sshFileXferAttrPermissions
+	}
+
+	switch flags & (sshFileXferAttrAccessTime | sshFileXferAttrModifyTime) {
+	case 0:
+		stat.AtimeNsec, stat.MtimeNsec = 0, 0
+	case sshFileXferAttrAccessTime | sshFileXferAttrModifyTime:
+		v3flags |= sshFileXferAttrACmodTime
+	default:
+		// version 3 sets both times or neither
+		return nil, ErrSSHFxOpUnsupported
+	}
+	if times, ok := timesExtended(stat); ok {
+		stat.Extended = append(stat.Extended, times)
+	}
+	if len(stat.Extended) > 0 {
+		v3flags |= sshFileXferAttrExtended
+	}
+
+	return marshalFileStat(nil, v3flags, stat), nil
+}
+
+// encode translates the attributes in the response m into the negotiated version.
+func (sp *serverProtocol) encode(m encoding.BinaryMarshaler) encoding.BinaryMarshaler {
+	version := sp.negotiated()
+	if version < 4 {
+		return m
+	}
+
+	r, ordered := m.(orderedResponse)
+	p, _ := m.(responsePacket)
+	if ordered {
+		p = r.responsePacket
+	}
+	switch p.(type) {
+	case *sshFxpStatResponse, *sshFxpNamePacket:
+	default:
+		return m
+	}
+
+	upgraded := &upgradedResponse{responsePacket: p, version: version}
+	if ordered {
+		r.responsePacket = upgraded
+		return r
+	}
+	return upgraded
+}
+
+// upgradedResponse is a response carrying attributes of version 3,
+// which it encodes as those of version instead.
+type upgradedResponse struct {
+	responsePacket
+	version uint32
+}
+
+func (p *upgradedResponse) MarshalBinary() ([]byte, error) {
+	header, payload, err := marshalPacket(p.responsePacket)
+	if err != nil {
+		return nil, err
+	}
+	b := append(header, payload...)
+
+	switch b[4] {
+	case sshFxpAttrs:
+		attrs, _ := upgradeAttrs(p.version, b[4+1+4:])
+		return append(b[:4+1+4:4+1+4], attrs...), nil
+
+	case sshFxpName:
+		// uint32(count), followed by the name, long name and attributes of
+		// each entry: version 4 dropped the long name
+		count, rest, err := unmarshalUint32Safe(b[4+1+4:])
+		if err != nil {
+			return nil, err
+		}
+		out := marshalUint32(append([]byte(nil), b[:4+1+4]...), count)
+		for i := uint32(0); i < count; i++ {
+			var name string
+			if name, rest, err = unmarshalStringSafe(rest); err != nil {
+				return nil, err
+			}
+			if rest = skipString(rest); rest == nil {
+				return nil, errShortPacket
+			}
+			var attrs []byte
+			attrs, rest = upgradeAttrs(p.version, rest)
+			out = append(marshalString(out, name), attrs...)
+		}
+		return out, nil
+	}
+	return b, nil
+}
+
+// upgradeAttrs converts the attributes of version 3 at the start of b into
+// those of version, and returns them along with the rest of b.
+func upgradeAttrs(version uint32, b []byte) ([]byte, []byte) {
+	flags, _ := unmarshalUint32(b)
+	stat, rest := unmarshalAttrs(b)
+
+	typ := uint8(sshFileXferTypeUnknown)
+	if flags&sshFileXferAttrPermissions != 0 && stat.Mode&S_IFMT != 0 {
+		typ = sshFileXferTypeSpecial
+		mode := toFileMode(stat.Mode) & fs.ModeType
+		for t, m := range fileTypeModes {
+			if m == mode {
+				typ = t
+				break
+			}
+		}
+	}
+
+	var v4flags uint32
+	var attrs []byte
+	if flags&sshFileXferAttrSize != 0 {
+		v4flags |= sshFileXferAttrSize
+		attrs = marshalUint64(attrs, stat.Size)
+	}
+	if flags&sshFileXferAttrUIDGID != 0 {
+		v4flags |= sshFileXferAttrOwnerGroup
+		attrs = marshalString(attrs, strconv.FormatUint(uint64(stat.UID), 10))
+		attrs = marshalString(attrs, strconv.FormatUint(uint64(stat.GID), 10))
+	}
+	if flags&sshFileXferAttrPermissions != 0 {
+		v4flags |= sshFileXferAttrPermissions
+		attrs = marshalUint32(attrs, stat.Mode&^S_IFMT)
+	}
+	if flags&sshFileXferAttrACmodTime != 0 {
+		v4flags |= sshFileXferAttrAccessTime | sshFileXferAttrModifyTime | sshFileXferAttrSubsecondTimes
+		attrs = marshalUint64(attrs, uint64(stat.Atime))
+		attrs = marshalUint32(attrs, stat.AtimeNsec)
+		if stat.CreateTime != 0 {
+			v4flags |= sshFileXferAttrCreateTime
+			attrs = marshalUint64(attrs, uint64(stat.CreateTime))
+			attrs = marshalUint32(attrs, stat.CreateTimeNsec)
+		}
+		attrs = marshalUint64(attrs, uint64(stat.Mtime))
+		attrs = marshalUint32(attrs, stat.MtimeNsec)
+	}
+	if len(stat.Extended) > 0 {
+		v4flags |= sshFileXferAttrExtended
+		attrs = marshalUint32(attrs, uint32(len(stat.Extended)))
+		for _, ext := range stat.Extended {
+			attrs = marshalString(attrs, ext.ExtType)
+			attrs = marshalString(attrs, ext.ExtData)
+		}
+	}
+
+	out := append(marshalUint32(nil, v4flags), typ)
+	return append(out, attrs...), rest
+}
@@ -0,0 +1,206 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerProtocolVersions(t *testing.T) {
+	for _, version := range []uint32{4, 5, 6} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			client, server := clientServerPairWith(t, nil, WithProtocolVersion(version))
+			defer client.Close()
+			defer server.Close()
+
+			require.Equal(t, version, client.ProtocolVersion())
+
+			dir := t.TempDir()
+			file := path.Join(dir, "file")
+			f, err := client.Create(file)
+			require.NoError(t, err)
+			_, err = f.Write([]byte("hello"))
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			require.NoError(t, client.Chmod(file, 0600))
+			mtime := time.Unix(1600000000, 123456789)
+			require.NoError(t, client.Chtimes(file, mtime, mtime))
+
+			fi, err := client.Stat(file)
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), fi.Size())
+			assert.Equal(t, os.FileMode(0600), fi.Mode())
+			assert.True(t, mtime.Equal(fi.ModTime()), "%v", fi.ModTime())
+
+			require.NoError(t, client.Mkdir(path.Join(dir, "sub")))
+			entries, err := client.ReadDir(dir)
+			require.NoError(t, err)
+			modes := map[string]os.FileMode{}
+			for _, e := range entries {
+				modes[e.Name()] = e.Mode()
+			}
+			assert.Equal(t, map[string]os.FileMode{"file": 0600, "sub": os.ModeDir | 0755}, modes)
+
+			require.NoError(t, client.Symlink(file, path.Join(dir, "symlink")))
+			target, err := client.ReadLink(path.Join(dir, "symlink"))
+			require.NoError(t, err)
+			assert.Equal(t, file, target)
+
+			require.NoError(t, client.Link(file, path.Join(dir, "link")))
+			require.NoError(t, client.PosixRename(path.Join(dir, "link"), path.Join(dir, "symlink")))
+			fi, err = client.Lstat(path.Join(dir, "symlink"))
+			require.NoError(t, err)
+			assert.True(t, fi.Mode().IsRegular())
+
+			f, err = client.OpenFile(file, os.O_RDWR)
+			require.NoError(t, err)
+			assert.NoError(t, f.Lock(0, 5, true))
+			assert.NoError(t, f.Unlock(0, 5))
+			require.NoError(t, f.Close())
+
+			_, err = client.OpenFileFlags(file, os.O_RDONLY, OpenText)
+			assert.True(t, errors.Is(err, ErrSSHFxOpUnsupported), "%v", err)
+		})
+	}
+}
+
+func TestRequestServerProtocolVersions(t *testing.T) {
+	for _, version := range []uint32{4, 6} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			p := clientRequestServerPairWith(t, nil, WithProtocolVersion(version))
+			defer p.Close()
+
+			require.Equal(t, version, p.cli.ProtocolVersion())
+
+			f, err := p.cli.Create("/file")
+			require.NoError(t, err)
+			_, err = f.Write([]byte("hello"))
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			require.NoError(t, p.cli.Mkdir("/dir"))
+			require.NoError(t, p.cli.Rename("/file", "/dir/file"))
+			require.NoError(t, p.cli.Symlink("/dir/file", "/symlink"))
+
+			fi, err := p.cli.Stat("/symlink")
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), fi.Size())
+			assert.True(t, fi.Mode().IsRegular())
+
+			entries, err := p.cli.ReadDir("/dir")
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+			assert.Equal(t, "file", entries[0].Name())
+			assert.Equal(t, int64(5), entries[0].Size())
+
+			_, err = p.cli.OpenFileFlags("/dir/file", os.O_RDONLY, OpenText)
+			assert.True(t, errors.Is(err, ErrSSHFxOpUnsupported), "%v", err)
+		})
+	}
+}
+
+func TestServerProtocolNegotiation(t *testing.T) {
+	var sp serverProtocol
+	assert.Equal(t, uint32(3), sp.negotiated())
+	assert.Equal(t, uint32(6), sp.negotiate(7))
+	assert.Equal(t, uint32(6), sp.negotiate(4)) // settled by the first init
+
+	sp = serverProtocol{}
+	assert.Equal(t, uint32(3), sp.negotiate(2))
+}
+
+func TestServerProtocolRequests(t *testing.T) {
+	sp := serverProtocol{version: 6}
+
+	// hard link, and rename replacing the target
+	b := marshalString(marshalString(marshalUint32(nil, 1), "new"), "old")
+	pkt, err := sp.makePacket(rxPacket{sshFxpLink, append(b, 0)})
+	require.NoError(t, err)
+	assert.Equal(t, &sshFxpExtendedPacketHardlink{ID: 1, ExtendedRequest: "hardlink@openssh.com", Oldpath: "old", Newpath: "new"},
+		pkt.(*sshFxpExtendedPacket).SpecificPacket)
+
+	b = marshalString(marshalString(marshalUint32(nil, 2), "old"), "new")
+	pkt, err = sp.makePacket(rxPacket{sshFxpRename, marshalUint32(b, sshFxfRenameOverwrite)})
+	require.NoError(t, err)
+	assert.Equal(t, &sshFxpExtendedPacketPosixRename{ID: 2, ExtendedRequest: "posix-rename@openssh.com", Oldpath: "old", Newpath: "new"},
+		pkt.(*sshFxpExtendedPacket).SpecificPacket)
+
+	pkt, err = sp.makePacket(rxPacket{sshFxpRename, marshalUint32(b, 0)})
+	require.NoError(t, err)
+	assert.Equal(t, &sshFxpRenamePacket{ID: 2, Oldpath: "old", Newpath: "new"}, pkt)
+
+	// owners which are no numeric ids
+	b = marshalString(marshalUint32(nil, 3), "file")
+	b = marshalUint32(b, sshFileXferAttrOwnerGroup)
+	b = append(b, sshFileXferTypeUnknown)
+	b = marshalString(marshalString(b, "root"), "0")
+	pkt, err = sp.makePacket(rxPacket{sshFxpSetstat, b})
+	require.NoError(t, err)
+	assert.Equal(t, &refusedRequest{ID: 3, err: fxerr(sshFxOwnerInvalid)}, pkt)
+
+	// access control lists
+	b = marshalString(marshalUint32(nil, 4), "file")
+	b = marshalUint32(b, sshFileXferAttrACL)
+	b = append(b, sshFileXferTypeUnknown)
+	b = marshalString(b, "")
+	pkt, err = sp.makePacket(rxPacket{sshFxpSetstat, b})
+	require.NoError(t, err)
+	assert.Equal(t, &refusedRequest{ID: 4, err: ErrSSHFxOpUnsupported}, pkt)
+
+	// sub-second times go into the times extension
+	b = marshalString(marshalUint32(nil, 5), "file")
+	b = marshalUint32(b, sshFileXferAttrAccessTime|sshFileXferAttrModifyTime|sshFileXferAttrSubsecondTimes)
+	b = append(b, sshFileXferTypeUnknown)
+	b = marshalUint32(marshalUint64(b, 10), 1)
+	b = marshalUint32(marshalUint64(b, 20), 2)
+	pkt, err = sp.makePacket(rxPacket{sshFxpSetstat, b})
+	require.NoError(t, err)
+	setstat := pkt.(*sshFxpSetstatPacket)
+	assert.Equal(t, uint32(sshFileXferAttrACmodTime|sshFileXferAttrExtended), setstat.Flags)
+	atime, mtime := setstatNsec(setstat.Flags, setstat.Attrs.([]byte))
+	assert.Equal(t, []int64{1, 2}, []int64{atime, mtime})
+}
+
+func TestServerProtocolResponses(t *testing.T) {
+	sp := serverProtocol{version: 4}
+
+	stat := &FileStat{Size: 5, Mode: 0100644, UID: 1, GID: 2, Mtime: 20, MtimeNsec: 3}
+	b, err := sp.encode(&sshFxpStatResponse{ID: 1, info: fileInfoFromStat(stat, "file")}).MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, byte(sshFxpAttrs), b[4])
+	got, _ := unmarshalAttrsV4(4, b[4+1+4:])
+	assert.Equal(t, uint64(5), got.Size)
+	assert.Equal(t, uint32(0100644), got.Mode)
+	assert.Equal(t, "1", got.Owner)
+	assert.Equal(t, "2", got.Group)
+	assert.Equal(t, uint32(20), got.Mtime)
+	assert.Equal(t, uint32(3), got.MtimeNsec)
+
+	// the entries of a name lose their long names
+	p := &sshFxpNamePacket{ID: 2, NameAttrs: []*sshFxpNameAttr{{
+		Name:     "dir",
+		LongName: "drwxr-xr-x dir",
+		Attrs:    []interface{}{fileInfoFromStat(&FileStat{Mode: 040755}, "dir")},
+	}}}
+	b, err = sp.encode(p).MarshalBinary()
+	require.NoError(t, err)
+	count, b := unmarshalUint32(b[4+1+4:])
+	assert.Equal(t, uint32(1), count)
+	name, b := unmarshalString(b)
+	assert.Equal(t, "dir", name)
+	flags, b := unmarshalUint32(b)
+	assert.Equal(t, uint32(sshFileXferAttrSize|sshFileXferAttrOwnerGroup|sshFileXferAttrPermissions|
+		sshFileXferAttrAccessTime|sshFileXferAttrModifyTime|sshFileXferAttrSubsecondTimes), flags)
+	assert.Equal(t, byte(sshFileXferTypeDirectory), b[0])
+
+	// unchanged with version 3
+	sp = serverProtocol{version: 3}
+	assert.Equal(t, p, sp.encode(p))
+}
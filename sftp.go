@@ -3,7 +3,11 @@
 package sftp
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
 )
 
 const (
@@ -86,9 +90,27 @@ const (
 var (
 	// supportedSFTPExtensions defines the supported extensions
 	supportedSFTPExtensions = []sshExtensionPair{
+		{"access@github.com/pkg/sftp", "1"},
+		{"allocate@github.com/pkg/sftp", "1"},
+		{"block@github.com/pkg/sftp", "1"},
+		{"check-file", "1"},
+		{"copy-data", "1"},
+		{"dir-stats@github.com/pkg/sftp", "1"},
+		{"disk-usage@github.com/pkg/sftp", "1"},
+		{"expand-path@openssh.com", "1"},
+		{"fsync@openssh.com", "1"},
+		{"getacl@github.com/pkg/sftp", "1"},
+		{"glob@github.com/pkg/sftp", "1"},
 		{"hardlink@openssh.com", "1"},
+		{"limits@openssh.com", "1"},
 		{"posix-rename@openssh.com", "1"},
+		{"setacl@github.com/pkg/sftp", "1"},
+		{"space-available", "1"},
 		{"statvfs@openssh.com", "2"},
+		{"times@github.com/pkg/sftp", "1"},
+		{"trace-context@github.com/pkg/sftp", "1"},
+		{"unblock@github.com/pkg/sftp", "1"},
+		{"users-groups-by-id@openssh.com", "1"},
 	}
 	sftpExtensions = supportedSFTPExtensions
 )
@@ -147,6 +169,12 @@ func (f fxp) String() string {
 		return "SSH_FXP_NAME"
 	case sshFxpAttrs:
 		return "SSH_FXP_ATTRS"
+	case sshFxpLink:
+		return "SSH_FXP_LINK"
+	case sshFxpBlock:
+		return "SSH_FXP_BLOCK"
+	case sshFxpUnblock:
+		return "SSH_FXP_UNBLOCK"
 	case sshFxpExtended:
 		return "SSH_FXP_EXTENDED"
 	case sshFxpExtendedReply:
@@ -178,6 +206,10 @@ func (f fx) String() string {
 		return "SSH_FX_CONNECTION_LOST"
 	case sshFxOPUnsupported:
 		return "SSH_FX_OP_UNSUPPORTED"
+	case sshFxByteRangeLockConflict:
+		return "SSH_FX_BYTE_RANGE_LOCK_CONFLICT"
+	case sshFxNoMatchingByteRangeLock:
+		return "SSH_FX_NO_MATCHING_BYTE_RANGE_LOCK"
 	default:
 		return "unknown"
 	}
@@ -231,6 +263,64 @@ func (s *StatusError) FxCode() fxerr {
 	return fxerr(s.Code)
 }
 
+// Is reports whether target is the code of s, one of the exported codes
+// like ErrSSHFxFailure, so that errors.Is matches them.
+func (s *StatusError) Is(target error) bool {
+	code, ok := target.(fxerr)
+	return ok && uint32(code) == s.Code
+}
+
+// Unwrap returns the error the code of s stands for, like ErrNotExist for
+// SSH_FX_NO_SUCH_FILE, if there is one.
+func (s *StatusError) Unwrap() error {
+	switch s.Code {
+	case sshFxEOF:
+		return io.EOF
+	case sshFxNoSuchFile:
+		return ErrNotExist
+	case sshFxFileAlreadyExists:
+		return ErrExist
+	case sshFxPermissionDenied:
+		return ErrPermission
+	}
+	return nil
+}
+
+// The errors the methods of Client and File fail with can be matched against
+// these with errors.Is, whether the server answered with a status or the
+// Client found out by itself. The methods taking paths return them wrapped in
+// an *fs.PathError, or an *os.LinkError if they take two, like package os.
+var (
+	ErrNotExist      = iofs.ErrNotExist             // the file does not exist
+	ErrExist         = iofs.ErrExist                // the file exists already
+	ErrPermission    = iofs.ErrPermission           // permission denied
+	ErrHandleClosed  = iofs.ErrClosed               // the File was closed already
+	ErrOpUnsupported = error(ErrSSHFxOpUnsupported) // the server does not support the operation
+)
+
+// pathError attaches the operation op and the path name to *err, like the
+// errors of package os, unless it is io.EOF or carries a path already.
+func pathError(err *error, op, name string) {
+	if *err == nil || *err == io.EOF || carriesPath(*err) {
+		return
+	}
+	*err = &iofs.PathError{Op: op, Path: name, Err: *err}
+}
+
+// linkError is pathError for the operations taking two paths.
+func linkError(err *error, op, oldname, newname string) {
+	if *err == nil || *err == io.EOF || carriesPath(*err) {
+		return
+	}
+	*err = &os.LinkError{Op: op, Old: oldname, New: newname, Err: *err}
+}
+
+func carriesPath(err error) bool {
+	var pathErr *iofs.PathError
+	var linkErr *os.LinkError
+	return errors.As(err, &pathErr) || errors.As(err, &linkErr)
+}
+
 func getSupportedExtensionByName(extensionName string) (sshExtensionPair, error) {
 	for _, supportedExtension := range supportedSFTPExtensions {
 		if supportedExtension.Name == extensionName {
@@ -3,6 +3,8 @@ package sftp
 import (
 	"errors"
 	"io"
+	"io/fs"
+	"os"
 	"syscall"
 	"testing"
 
@@ -26,6 +28,35 @@ func TestErrFxCode(t *testing.T) {
 	}
 }
 
+func TestStatusErrorIs(t *testing.T) {
+	for _, tt := range []struct {
+		code uint32
+		is   []error
+	}{
+		{code: sshFxNoSuchFile, is: []error{ErrNotExist, os.ErrNotExist, ErrSSHFxNoSuchFile}},
+		{code: sshFxPermissionDenied, is: []error{ErrPermission, ErrSSHFxPermissionDenied}},
+		{code: sshFxOPUnsupported, is: []error{ErrOpUnsupported, ErrSSHFxOpUnsupported}},
+		{code: sshFxEOF, is: []error{io.EOF}},
+		{code: sshFxFailure, is: []error{ErrSSHFxFailure}},
+	} {
+		err := error(&StatusError{Code: tt.code})
+		for _, target := range tt.is {
+			assert.True(t, errors.Is(err, target), "%v is %v", err, target)
+		}
+		assert.False(t, errors.Is(err, ErrSSHFxBadMessage))
+	}
+
+	err := error(&StatusError{Code: sshFxNoSuchFile})
+	pathError(&err, "stat", "/a")
+	assert.EqualError(t, err, `stat /a: sftp: "" (SSH_FX_NO_SUCH_FILE)`)
+	pathError(&err, "open", "/b")
+	assert.Equal(t, "/a", err.(*fs.PathError).Path, "only wrapped once")
+
+	err = io.EOF
+	pathError(&err, "read", "/a")
+	assert.Equal(t, io.EOF, err)
+}
+
 func TestSupportedExtensions(t *testing.T) {
 	for _, supportedExtension := range supportedSFTPExtensions {
 		_, err := getSupportedExtensionByName(supportedExtension.Name)
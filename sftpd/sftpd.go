@@ -0,0 +1,395 @@
+// Package sftpd serves the sftp subsystem of ssh connections, running a
+// sftp.Server or sftp.RequestServer for every session which asks for it.
+//
+//	srv := &sftpd.Server{
+//		Config: config, // with the host keys and authentication
+//		FS: func(conn *ssh.ServerConn) (apis.Fs, error) {
+//			return apis.NewAVFS(), nil
+//		},
+//		ServerOptions: func(conn *ssh.ServerConn) []sftp.ServerOption {
+//			return []sftp.ServerOption{sftp.WithRootDirectory("/srv/" + conn.User())}
+//		},
+//	}
+//	log.Fatal(srv.ListenAndServe(":2022"))
+//
+// Sessions asking for anything else, like a shell or a command, are refused,
+// as are channels other than sessions and global requests.
+package sftpd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/pkg/sftp"
+	"github.com/pkg/sftp/apis"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe once the Server is
+// shut down or closed.
+var ErrServerClosed = errors.New("sftpd: Server closed")
+
+// Server accepts ssh connections and serves the sftp subsystem of their
+// sessions. Either FS or Handlers has to be set. The fields must not be
+// changed once the Server serves.
+type Server struct {
+	// Config authenticates the users and holds the host keys.
+	Config *ssh.ServerConfig
+
+	// FS returns the file system a sftp.Server serves the user of conn,
+	// or an error to refuse the session.
+	FS func(conn *ssh.ServerConn) (apis.Fs, error)
+
+	// Handlers returns the handlers a sftp.RequestServer serves the user of
+	// conn with, or an error to refuse the session. It is used instead of
+	// FS if set.
+	Handlers func(conn *ssh.ServerConn) (sftp.Handlers, error)
+
+	// ServerOptions and RequestServerOptions return the options of the
+	// sftp.Server or sftp.RequestServer of a session of conn, if set.
+	ServerOptions        func(conn *ssh.ServerConn) []sftp.ServerOption
+	RequestServerOptions func(conn *ssh.ServerConn) []sftp.RequestServerOption
+
+	// HandshakeTimeout limits how long the ssh handshake of a connection,
+	// including the authentication, may take. Zero means no limit.
+	HandshakeTimeout time.Duration
+
+	// ErrorLog receives the errors of connections and sessions, which do
+	// not stop the Server. The standard logger is used if nil.
+	ErrorLog *log.Logger
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[*ssh.ServerConn]struct{}
+	sessions  map[session]struct{}
+	wg        sync.WaitGroup // the goroutines of the connections
+}
+
+// session is a sftp.Server or sftp.RequestServer serving a session.
+type session interface {
+	Serve() error
+	Shutdown(ctx context.Context) error
+	Close() error
+}
+
+// ListenAndServe listens on the TCP address addr and serves the connections
+// to it, see Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts the connections of l, and serves each of them on a
+// goroutine of its own. It returns the error of Accept, or ErrServerClosed
+// once the Server is shut down or closed. l is closed when Serve returns.
+func (s *Server) Serve(l net.Listener) error {
+	defer l.Close()
+
+	if s.Config == nil {
+		return errors.New("sftpd: Server has no Config")
+	}
+	if s.FS == nil && s.Handlers == nil {
+		return errors.New("sftpd: Server has neither FS nor Handlers")
+	}
+	if !s.trackListener(l, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(l, false)
+
+	for {
+		nConn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		if !s.addConn() {
+			nConn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(nConn)
+		}()
+	}
+}
+
+// Shutdown shuts the Server down gracefully: it stops listening, shuts the
+// sessions down with sftp.Server.Shutdown or sftp.RequestServer.Shutdown,
+// and closes the connections. It waits for all of that until ctx is done,
+// and then returns the error of ctx, nil if it was not done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	sessions := s.close()
+
+	var wg sync.WaitGroup
+	for sess := range sessions {
+		wg.Add(1)
+		go func(sess session) {
+			defer wg.Done()
+			sess.Shutdown(ctx)
+		}(sess)
+	}
+	wg.Wait()
+	s.closeConns()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close closes the listeners and the connections of the Server at once,
+// dropping the requests in progress. See Shutdown to let them complete.
+func (s *Server) Close() error {
+	s.close()
+	s.closeConns()
+	return nil
+}
+
+// close marks the Server closed, closes its listeners and returns its
+// sessions.
+func (s *Server) close() map[session]struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	sessions := make(map[session]struct{}, len(s.sessions))
+	for sess := range s.sessions {
+		sessions[sess] = struct{}{}
+	}
+	return sessions
+}
+
+// closeConns closes the connections of the Server.
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// addConn adds a connection to the wait group of the Server, unless it is
+// closed, so that Shutdown does not miss any.
+func (s *Server) addConn() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// trackListener adds l to the listeners of the Server, or removes it.
+// It adds nothing and returns false if the Server is closed.
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !add {
+		delete(s.listeners, l)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+// trackConn adds conn to the connections of the Server, or removes it.
+// It adds nothing and returns false if the Server is closed.
+func (s *Server) trackConn(conn *ssh.ServerConn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !add {
+		delete(s.conns, conn)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[*ssh.ServerConn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+// trackSession adds sess to the sessions of the Server, or removes it.
+// It adds nothing and returns false if the Server is closed.
+func (s *Server) trackSession(sess session, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !add {
+		delete(s.sessions, sess)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	if s.sessions == nil {
+		s.sessions = make(map[session]struct{})
+	}
+	s.sessions[sess] = struct{}{}
+	return true
+}
+
+// serveConn performs the ssh handshake on nConn and serves its channels.
+func (s *Server) serveConn(nConn net.Conn) {
+	if s.HandshakeTimeout > 0 {
+		nConn.SetDeadline(time.Now().Add(s.HandshakeTimeout))
+	}
+	conn, chans, reqs, err := ssh.NewServerConn(nConn, s.Config)
+	if err != nil {
+		s.logf("sftpd: handshake with %v: %v", nConn.RemoteAddr(), err)
+		nConn.Close()
+		return
+	}
+	nConn.SetDeadline(time.Time{})
+
+	if !s.trackConn(conn, true) {
+		conn.Close()
+		return
+	}
+	defer s.trackConn(conn, false)
+	defer conn.Close()
+
+	go ssh.DiscardRequests(reqs)
+
+	var wg sync.WaitGroup
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			newCh.Reject(ssh.UnknownChannelType, "only sessions are served")
+			continue
+		}
+		wg.Add(1)
+		go func(newCh ssh.NewChannel) {
+			defer wg.Done()
+			s.serveChannel(conn, newCh)
+		}(newCh)
+	}
+	wg.Wait()
+}
+
+// serveChannel accepts the session newCh, and serves it once it asks for
+// the sftp subsystem.
+func (s *Server) serveChannel(conn *ssh.ServerConn, newCh ssh.NewChannel) {
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		s.logf("sftpd: accepting a session of %s: %v", conn.User(), err)
+		return
+	}
+	defer ch.Close()
+
+	subsystem := make(chan struct{}, 1)
+	go func() {
+		defer close(subsystem)
+		started := false
+		for req := range reqs {
+			ok := !started && req.Type == "subsystem" && isSFTP(req.Payload)
+			if req.WantReply {
+				req.Reply(ok, nil)
+			}
+			if ok {
+				started = true
+				subsystem <- struct{}{}
+			}
+		}
+	}()
+	if _, ok := <-subsystem; !ok {
+		return
+	}
+
+	sess, err := s.newSession(conn, ch)
+	if err != nil {
+		s.logf("sftpd: starting a session of %s: %v", conn.User(), err)
+		return
+	}
+	if !s.trackSession(sess, true) {
+		sess.Close()
+		return
+	}
+	defer s.trackSession(sess, false)
+
+	if err := sess.Serve(); err != nil && err != io.EOF {
+		s.logf("sftpd: session of %s: %v", conn.User(), err)
+	}
+	sess.Close()
+}
+
+// newSession returns the server of a session of conn on ch.
+func (s *Server) newSession(conn *ssh.ServerConn, ch ssh.Channel) (session, error) {
+	if s.Handlers != nil {
+		h, err := s.Handlers(conn)
+		if err != nil {
+			return nil, err
+		}
+		var opts []sftp.RequestServerOption
+		if s.RequestServerOptions != nil {
+			opts = s.RequestServerOptions(conn)
+		}
+		return sftp.NewRequestServer(ch, h, opts...), nil
+	}
+
+	fs, err := s.FS(conn)
+	if err != nil {
+		return nil, err
+	}
+	var opts []sftp.ServerOption
+	if s.ServerOptions != nil {
+		opts = s.ServerOptions(conn)
+	}
+	return sftp.NewServer(ch, fs, opts...)
+}
+
+// isSFTP reports whether payload is the one of a subsystem request for sftp.
+func isSFTP(payload []byte) bool {
+	var msg struct{ Name string }
+	return ssh.Unmarshal(payload, &msg) == nil && msg.Name == "sftp"
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Output(2, fmt.Sprintf(format, args...))
+	} else {
+		log.Output(2, fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,161 @@
+package sftpd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/pkg/sftp"
+	"github.com/pkg/sftp/apis"
+)
+
+// serve starts srv with a host key and a password for every user, and
+// returns its address and the error Serve returns.
+func serve(t *testing.T, srv *Server) (string, <-chan error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	srv.Config = &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != "secret" {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		},
+	}
+	srv.Config.AddHostKey(signer)
+	srv.ErrorLog = log.New(ioutil.Discard, "", 0)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(l)
+	}()
+	return l.Addr().String(), served
+}
+
+func dial(t *testing.T, addr, user string) *ssh.Client {
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.Password("secret")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.NoError(t, err)
+	return conn
+}
+
+func TestServerFS(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "alice"), 0o755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "alice", "hello"), []byte("hello, alice"), 0o644))
+
+	srv := &Server{
+		FS: func(conn *ssh.ServerConn) (apis.Fs, error) {
+			if conn.User() != "alice" {
+				return nil, errors.New("no sftp for you")
+			}
+			return apis.NewAVFS(), nil
+		},
+		ServerOptions: func(conn *ssh.ServerConn) []sftp.ServerOption {
+			return []sftp.ServerOption{sftp.WithRootDirectory(filepath.Join(dir, conn.User()))}
+		},
+	}
+	addr, served := serve(t, srv)
+	defer srv.Close()
+
+	conn := dial(t, addr, "alice")
+	defer conn.Close()
+	client, err := sftp.NewClient(conn)
+	require.NoError(t, err)
+	f, err := client.Open("/hello")
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, alice", string(b))
+	require.NoError(t, f.Close())
+
+	bob := dial(t, addr, "bob")
+	defer bob.Close()
+	_, err = sftp.NewClient(bob)
+	assert.Error(t, err, "refused")
+
+	session, err := conn.NewSession()
+	require.NoError(t, err)
+	assert.Error(t, session.Run("ls"), "only sftp is served")
+	session.Close()
+
+	require.NoError(t, client.Close())
+	require.NoError(t, srv.Close())
+	assert.Equal(t, ErrServerClosed, <-served)
+}
+
+func TestServerHandlers(t *testing.T) {
+	srv := &Server{
+		Handlers: func(conn *ssh.ServerConn) (sftp.Handlers, error) {
+			return sftp.InMemHandler(), nil
+		},
+	}
+	addr, _ := serve(t, srv)
+	defer srv.Close()
+
+	conn := dial(t, addr, "alice")
+	defer conn.Close()
+	client, err := sftp.NewClient(conn)
+	require.NoError(t, err)
+	defer client.Close()
+
+	f, err := client.Create("/foo")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	fi, err := client.Stat("/foo")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), fi.Size())
+}
+
+func TestServerShutdown(t *testing.T) {
+	srv := &Server{
+		FS: func(conn *ssh.ServerConn) (apis.Fs, error) {
+			return apis.NewAVFS(), nil
+		},
+	}
+	addr, served := serve(t, srv)
+
+	conn := dial(t, addr, "alice")
+	defer conn.Close()
+	client, err := sftp.NewClient(conn)
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Getwd()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, srv.Shutdown(ctx))
+	assert.Equal(t, ErrServerClosed, <-served)
+
+	_, err = client.Getwd()
+	assert.Error(t, err, "the session is closed")
+	_, err = net.DialTimeout("tcp", addr, time.Second)
+	assert.Error(t, err, "no longer listening")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	assert.Equal(t, ErrServerClosed, srv.Serve(l))
+}
@@ -0,0 +1,64 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrShuttingDown is the error servers of this package answer requests with,
+// which arrive while they shut down, see Server.Shutdown. Clients see it as
+// SSH_FX_FAILURE with its message, and IsRetryable reports true for it.
+var ErrShuttingDown = errors.New("sftp: server is shutting down")
+
+// shutdownPollInterval is how often Shutdown checks whether all requests
+// have been answered.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Shutdown shuts the Server down gracefully: requests arriving from now on
+// fail with ErrShuttingDown, except for closing handles, while the requests
+// received before are served and answered. Once they are, or ctx is done,
+// the connection is closed, so that Serve returns.
+//
+// Shutdown returns the error of ctx if it was done before all requests were
+// answered, and the error of closing the connection otherwise.
+func (svr *Server) Shutdown(ctx context.Context) error {
+	return svr.pktMgr.drain(ctx, svr.conn.Close)
+}
+
+// Shutdown shuts the RequestServer down gracefully, see Server.Shutdown.
+func (rs *RequestServer) Shutdown(ctx context.Context) error {
+	return rs.pktMgr.drain(ctx, rs.conn.Close)
+}
+
+// drain has the requests arriving from now on refused, waits for those
+// received to be answered, or ctx to be done, and then calls close.
+func (s *packetManager) drain(ctx context.Context, close func() error) error {
+	atomic.StoreInt32(&s.draining, 1)
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for atomic.LoadInt64(&s.unanswered) > 0 {
+		select {
+		case <-ctx.Done():
+			close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return close()
+}
+
+// refuses returns the error to answer p with, while the server shuts down.
+func (s *packetManager) refuses(p requestPacket) error {
+	if atomic.LoadInt32(&s.draining) == 0 {
+		return nil
+	}
+	switch p.(type) {
+	case *sshFxInitPacket, *sshFxpClosePacket:
+		return nil
+	}
+	return ErrShuttingDown
+}
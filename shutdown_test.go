@@ -0,0 +1,95 @@
+package sftp
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingCmder blocks the commands until release is closed.
+type blockingCmder struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (h blockingCmder) Filecmd(*Request) error {
+	h.entered <- struct{}{}
+	<-h.release
+	return nil
+}
+
+// waitFor waits until cond holds.
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; !cond(); i++ {
+		require.Less(t, i, 500, "timed out")
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRequestServerShutdown(t *testing.T) {
+	h := blockingCmder{entered: make(chan struct{}), release: make(chan struct{})}
+	client, server := clientRequestServerPipe(t, Handlers{FileCmd: h}, nil)
+	defer client.Close()
+
+	mkdir := make(chan error, 1)
+	go func() {
+		mkdir <- client.Mkdir("/dir")
+	}()
+	<-h.entered
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- server.Shutdown(context.Background())
+	}()
+	waitFor(t, func() bool { return atomic.LoadInt32(&server.pktMgr.draining) == 1 })
+
+	stat := make(chan error, 1)
+	go func() {
+		_, err := client.Stat("/dir")
+		stat <- err
+	}()
+	waitFor(t, func() bool { return atomic.LoadInt64(&server.pktMgr.unanswered) == 2 })
+
+	close(h.release)
+	assert.NoError(t, <-mkdir, "served")
+	err := <-stat
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), ErrShuttingDown.Error())
+		assert.True(t, IsRetryable(err))
+	}
+	assert.NoError(t, <-shutdown)
+	waitServed(server)
+}
+
+func TestRequestServerShutdownTimeout(t *testing.T) {
+	h := blockingCmder{entered: make(chan struct{}), release: make(chan struct{})}
+	client, server := clientRequestServerPipe(t, Handlers{FileCmd: h}, nil)
+	defer client.Close()
+
+	go client.Mkdir("/dir")
+	<-h.entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, server.Shutdown(ctx))
+
+	close(h.release)
+	waitServed(server)
+}
+
+func TestServerShutdown(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+
+	_, err := client.Getwd()
+	require.NoError(t, err)
+
+	require.NoError(t, server.Shutdown(context.Background()))
+	waitServed(server)
+	_, err = client.Getwd()
+	assert.Error(t, err, "the connection is closed")
+}
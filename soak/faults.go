@@ -0,0 +1,100 @@
+package soak
+
+import (
+	"io"
+
+	"github.com/pkg/sftp"
+)
+
+// faulty returns h, with the faults of the configuration injected.
+func (s *soak) faulty(h sftp.Handlers) sftp.Handlers {
+	if s.cfg.FaultRate <= 0 {
+		return h
+	}
+	f := &faultyHandlers{h: h, s: s}
+	return sftp.Handlers{FileGet: f, FilePut: f, FileCmd: f, FileList: f}
+}
+
+// fault reports whether to inject a fault, and counts it if so.
+func (s *soak) fault() bool {
+	if !s.chaos(s.cfg.FaultRate) {
+		return false
+	}
+	s.count(&s.report.Faults)
+	return true
+}
+
+// faultyHandlers fail the calls of the handlers h at random.
+type faultyHandlers struct {
+	h sftp.Handlers
+	s *soak
+}
+
+func (f *faultyHandlers) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	if f.s.fault() {
+		return nil, ErrInjected
+	}
+	rd, err := f.h.FileGet.Fileread(r)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyFile{rd: rd, s: f.s}, nil
+}
+
+func (f *faultyHandlers) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	if f.s.fault() {
+		return nil, ErrInjected
+	}
+	wr, err := f.h.FilePut.Filewrite(r)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyFile{wr: wr, s: f.s}, nil
+}
+
+func (f *faultyHandlers) Filecmd(r *sftp.Request) error {
+	if f.s.fault() {
+		return ErrInjected
+	}
+	return f.h.FileCmd.Filecmd(r)
+}
+
+func (f *faultyHandlers) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	if f.s.fault() {
+		return nil, ErrInjected
+	}
+	return f.h.FileList.Filelist(r)
+}
+
+// faultyFile fails the reads and writes of a file at random.
+type faultyFile struct {
+	rd io.ReaderAt
+	wr io.WriterAt
+	s  *soak
+}
+
+func (f *faultyFile) ReadAt(b []byte, off int64) (int, error) {
+	if f.s.fault() {
+		return 0, ErrInjected
+	}
+	return f.rd.ReadAt(b, off)
+}
+
+func (f *faultyFile) WriteAt(b []byte, off int64) (int, error) {
+	if f.s.fault() {
+		return 0, ErrInjected
+	}
+	return f.wr.WriteAt(b, off)
+}
+
+// Close closes the file of the handlers, if it is an io.Closer.
+func (f *faultyFile) Close() error {
+	var c interface{} = f.rd
+	if f.wr != nil {
+		c = f.wr
+	}
+	if c, ok := c.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,556 @@
+// Package soak runs soak tests of package sftp: mixes of uploads,
+// downloads, renames and deletes against a RequestServer, for a while,
+// with disconnects, latency spikes and backend faults injected, checking
+// that no operation reported as done left the files in any other state.
+//
+//	report, err := soak.Run(ctx, soak.Config{
+//		Duration:        time.Minute,
+//		DisconnectEvery: time.Second,
+//		FaultRate:       0.01,
+//	})
+//	if err == nil && !report.OK() {
+//		log.Fatal(report.Violations)
+//	}
+//
+// Errors of single operations are expected under chaos and only counted.
+// A violation is data which differs from what the client wrote, a file
+// which is there after its delete or rename succeeded, or one which is
+// missing without a failed operation to explain it.
+package soak
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// ErrInjected is the error of the backend faults injected by Run.
+var ErrInjected = errors.New("soak: injected backend fault")
+
+// Operation is an operation of the soak test.
+type Operation string
+
+// The operations of the soak test.
+const (
+	Upload   Operation = "upload"
+	Download Operation = "download"
+	Rename   Operation = "rename"
+	Delete   Operation = "delete"
+)
+
+// Mix weighs how often each operation is picked. Operations on files are
+// only picked once there are files, uploads otherwise.
+type Mix struct {
+	Upload, Download, Rename, Delete int
+}
+
+// DefaultMix picks downloads most often, then uploads.
+var DefaultMix = Mix{Upload: 4, Download: 6, Rename: 2, Delete: 2}
+
+// Config configures a soak test. The zero value runs a second of operations
+// without any chaos against an in-memory RequestServer.
+type Config struct {
+	// Duration is how long to start new operations, a second if 0.
+	Duration time.Duration
+
+	// Workers is how many operations are run at once, 4 if 0.
+	Workers int
+
+	// Mix weighs the operations, DefaultMix if zero.
+	Mix Mix
+
+	// MaxFileSize is the largest upload in bytes, 64 KiB if 0.
+	MaxFileSize int
+
+	// Seed seeds the choices of operations, files and their contents.
+	Seed int64
+
+	// Handlers serve the files, the in-memory handlers of sftp.InMemHandler
+	// if zero. They are shared by all connections, and have to start out
+	// with no directory /soak.
+	Handlers sftp.Handlers
+
+	// ServerOptions and ClientOptions configure the RequestServer of each
+	// connection and the Client.
+	ServerOptions []sftp.RequestServerOption
+	ClientOptions []sftp.ClientOption
+
+	// DisconnectEvery drops the connection at random times, every
+	// DisconnectEvery on average, 0 to never drop it. The client reconnects
+	// with sftp.WithAutoReconnect and Reconnect.
+	DisconnectEvery time.Duration
+	Reconnect       sftp.ReconnectPolicy
+
+	// LatencySpikeRate is the chance of a response to be held back for
+	// LatencySpike first.
+	LatencySpikeRate float64
+	LatencySpike     time.Duration
+
+	// FaultRate is the chance of a call of the handlers, or of a read or
+	// write of their files, to fail with ErrInjected.
+	FaultRate float64
+
+	// Grace is how long to wait for the operations in flight at the end,
+	// 30 seconds if 0. Operations still running after it are violations.
+	Grace time.Duration
+}
+
+// Report is the result of a soak test.
+type Report struct {
+	Operations map[Operation]int // done
+	Errors     map[Operation]int // failed, which chaos explains
+
+	Disconnects   int
+	LatencySpikes int
+	Faults        int
+
+	// Files is how many files were checked at the end.
+	Files int
+
+	Violations []Violation
+}
+
+// OK reports whether no invariant was violated.
+func (r *Report) OK() bool {
+	return len(r.Violations) == 0
+}
+
+// Violation is an invariant violated in a soak test.
+type Violation struct {
+	Op   Operation // empty for the checks at the end
+	Path string
+	Msg  string
+}
+
+func (v Violation) String() string {
+	if v.Op == "" {
+		return v.Path + ": " + v.Msg
+	}
+	return string(v.Op) + " " + v.Path + ": " + v.Msg
+}
+
+// Run runs the soak test of cfg, until its Duration has passed or ctx is
+// done. It returns an error only if the test could not run at all.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if cfg.Duration <= 0 {
+		cfg.Duration = time.Second
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.Mix == (Mix{}) {
+		cfg.Mix = DefaultMix
+	}
+	if cfg.MaxFileSize <= 0 {
+		cfg.MaxFileSize = 64 * 1024
+	}
+	if cfg.Grace <= 0 {
+		cfg.Grace = 30 * time.Second
+	}
+	handlers := cfg.Handlers
+	if handlers.FileGet == nil && handlers.FilePut == nil && handlers.FileCmd == nil && handlers.FileList == nil {
+		handlers = sftp.InMemHandler()
+	}
+
+	s := &soak{
+		cfg: cfg,
+		report: Report{
+			Operations: make(map[Operation]int),
+			Errors:     make(map[Operation]int),
+		},
+		files: make(map[string]*fileState),
+	}
+	s.handlers = s.faulty(handlers)
+
+	opts := cfg.ClientOptions
+	if cfg.DisconnectEvery > 0 {
+		opts = append(opts[:len(opts):len(opts)], sftp.WithAutoReconnect(s.dial, cfg.Reconnect))
+	}
+	rd, wr, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClientPipe(rd, wr, opts...)
+	if err != nil {
+		s.dropConn()
+		return nil, err
+	}
+	defer func() {
+		client.Close()
+		s.dropConn()
+	}()
+	s.client = client
+
+	if err := client.Mkdir("/soak"); err != nil {
+		return nil, fmt.Errorf("soak: mkdir /soak: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	if cfg.DisconnectEvery > 0 {
+		go s.disconnect(ctx, rand.New(rand.NewSource(cfg.Seed)))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			s.work(ctx, i, rand.New(rand.NewSource(cfg.Seed+int64(i)+1)))
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(cfg.Duration + cfg.Grace):
+		s.violate("", "", "operations still running "+cfg.Grace.String()+" after the end")
+		report := s.snapshot()
+		return &report, nil
+	}
+
+	// the checks at the end go without chaos
+	atomic.StoreInt32(&s.calm, 1)
+	s.check()
+
+	report := s.snapshot()
+	return &report, nil
+}
+
+// fileState is what the soak test knows about a file.
+type fileState struct {
+	content []byte
+	gone    bool // deleted or renamed
+	unsure  bool // an operation on it failed, it may be in any state
+}
+
+// soak is a running soak test.
+type soak struct {
+	cfg      Config
+	handlers sftp.Handlers
+	client   *sftp.Client
+	calm     int32 // atomic, 1 once chaos has ended
+
+	connMu sync.Mutex
+	drop   func()
+
+	mu     sync.Mutex // protects the fields below
+	report Report
+	files  map[string]*fileState
+	paths  []string // of files which are neither gone nor unsure
+	next   int
+}
+
+// dial connects a new RequestServer.
+func (s *soak) dial() (io.Reader, io.WriteCloser, error) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+
+	server := sftp.NewRequestServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, &spikyWriter{WriteCloser: sw, s: s}}, s.handlers, s.cfg.ServerOptions...)
+	go func() {
+		server.Serve()
+		server.Close()
+	}()
+
+	s.connMu.Lock()
+	s.drop = func() {
+		sr.Close()
+		sw.Close()
+	}
+	s.connMu.Unlock()
+	return cr, cw, nil
+}
+
+// disconnect drops the connection at random until ctx is done.
+func (s *soak) disconnect(ctx context.Context, rnd *rand.Rand) {
+	for {
+		wait := time.Duration(rnd.ExpFloat64() * float64(s.cfg.DisconnectEvery))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		s.dropConn()
+		s.count(&s.report.Disconnects)
+	}
+}
+
+// dropConn drops the current connection.
+func (s *soak) dropConn() {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	s.drop()
+}
+
+// chaos reports whether to inject a fault with the chance rate.
+func (s *soak) chaos(rate float64) bool {
+	return rate > 0 && atomic.LoadInt32(&s.calm) == 0 && rand.Float64() < rate
+}
+
+// count increments the counter n of the report.
+func (s *soak) count(n *int) {
+	s.mu.Lock()
+	*n++
+	s.mu.Unlock()
+}
+
+// work runs operations until ctx is done.
+func (s *soak) work(ctx context.Context, worker int, rnd *rand.Rand) {
+	for ctx.Err() == nil {
+		op := s.pick(rnd)
+		var err error
+		switch op {
+		case Upload:
+			err = s.upload(worker, rnd)
+		case Download:
+			err = s.download(rnd)
+		case Rename:
+			err = s.rename(worker, rnd)
+		case Delete:
+			err = s.remove(rnd)
+		}
+
+		s.mu.Lock()
+		if err != nil {
+			s.report.Errors[op]++
+		} else {
+			s.report.Operations[op]++
+		}
+		s.mu.Unlock()
+	}
+}
+
+// pick picks the next operation by the weights of the mix.
+func (s *soak) pick(rnd *rand.Rand) Operation {
+	mix := s.cfg.Mix
+	s.mu.Lock()
+	if len(s.paths) == 0 {
+		mix = Mix{Upload: 1}
+	}
+	s.mu.Unlock()
+
+	n := rnd.Intn(mix.Upload + mix.Download + mix.Rename + mix.Delete)
+	switch {
+	case n < mix.Upload:
+		return Upload
+	case n < mix.Upload+mix.Download:
+		return Download
+	case n < mix.Upload+mix.Download+mix.Rename:
+		return Rename
+	}
+	return Delete
+}
+
+// newPath returns a path no file of the soak test had yet.
+func (s *soak) newPath(worker int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	return path.Join("/soak", "w"+strconv.Itoa(worker)+"-"+strconv.Itoa(s.next))
+}
+
+// take removes a random file from the files to operate on, so that no
+// other worker operates on it until it is put back with release.
+func (s *soak) take(rnd *rand.Rand) (string, *fileState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.paths) == 0 {
+		return "", nil
+	}
+	i := rnd.Intn(len(s.paths))
+	name := s.paths[i]
+	s.paths[i] = s.paths[len(s.paths)-1]
+	s.paths = s.paths[:len(s.paths)-1]
+	return name, s.files[name]
+}
+
+// release records state as the state of the file at name, and makes it
+// available to the operations again if it is known.
+func (s *soak) release(name string, state *fileState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[name] = state
+	if !state.gone && !state.unsure {
+		s.paths = append(s.paths, name)
+	}
+}
+
+// violate records a violation.
+func (s *soak) violate(op Operation, name, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.report.Violations = append(s.report.Violations, Violation{Op: op, Path: name, Msg: msg})
+}
+
+func (s *soak) upload(worker int, rnd *rand.Rand) error {
+	name := s.newPath(worker)
+	content := make([]byte, 1+rnd.Intn(s.cfg.MaxFileSize))
+	rnd.Read(content)
+
+	err := s.write(name, content)
+	s.release(name, &fileState{content: content, unsure: err != nil})
+	return err
+}
+
+// write creates the file name with content.
+func (s *soak) write(name string, content []byte) error {
+	f, err := s.client.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *soak) download(rnd *rand.Rand) error {
+	name, state := s.take(rnd)
+	if state == nil {
+		return nil
+	}
+	defer s.release(name, state)
+
+	content, err := s.read(name)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(content, state.content) {
+		s.violate(Download, name, fmt.Sprintf("read %d bytes differing from the %d written", len(content), len(state.content)))
+	}
+	return nil
+}
+
+// read returns the content of the file name.
+func (s *soak) read(name string) ([]byte, error) {
+	f, err := s.client.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := io.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return content, f.Close()
+}
+
+func (s *soak) rename(worker int, rnd *rand.Rand) error {
+	name, state := s.take(rnd)
+	if state == nil {
+		return nil
+	}
+	target := s.newPath(worker)
+
+	err := s.client.Rename(name, target)
+	if err != nil {
+		// either name or target may be the file now
+		s.release(name, &fileState{content: state.content, unsure: true})
+		s.release(target, &fileState{content: state.content, unsure: true})
+		return err
+	}
+	s.release(name, &fileState{gone: true})
+	s.release(target, state)
+	return nil
+}
+
+func (s *soak) remove(rnd *rand.Rand) error {
+	name, state := s.take(rnd)
+	if state == nil {
+		return nil
+	}
+
+	err := s.client.Remove(name)
+	if err != nil {
+		s.release(name, &fileState{content: state.content, unsure: true})
+		return err
+	}
+	s.release(name, &fileState{gone: true})
+	return nil
+}
+
+// check checks the files at the end.
+func (s *soak) check() {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		s.mu.Lock()
+		state := s.files[name]
+		s.report.Files++
+		s.mu.Unlock()
+
+		content, err := s.read(name)
+		switch {
+		case state.gone:
+			if err == nil {
+				s.violate("", name, "still there after it was deleted or renamed")
+			}
+		case state.unsure:
+			// a failed upload may have written any of its chunks
+		case err != nil:
+			s.violate("", name, "cannot be read: "+err.Error())
+		case !bytes.Equal(content, state.content):
+			s.violate("", name, fmt.Sprintf("has %d bytes differing from the %d written", len(content), len(state.content)))
+		}
+	}
+}
+
+// snapshot returns a copy of the report.
+func (s *soak) snapshot() Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.report
+	r.Operations = make(map[Operation]int, len(s.report.Operations))
+	for op, n := range s.report.Operations {
+		r.Operations[op] = n
+	}
+	r.Errors = make(map[Operation]int, len(s.report.Errors))
+	for op, n := range s.report.Errors {
+		r.Errors[op] = n
+	}
+	r.Violations = append([]Violation(nil), s.report.Violations...)
+	return r
+}
+
+// spikyWriter holds back the responses of a server at random.
+type spikyWriter struct {
+	io.WriteCloser
+	s *soak
+}
+
+func (w *spikyWriter) Write(b []byte) (int, error) {
+	if w.s.chaos(w.s.cfg.LatencySpikeRate) {
+		w.s.count(&w.s.report.LatencySpikes)
+		time.Sleep(w.s.cfg.LatencySpike)
+	}
+	return w.WriteCloser.Write(b)
+}
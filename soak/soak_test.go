@@ -0,0 +1,54 @@
+package soak
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+func TestRun(t *testing.T) {
+	report, err := Run(context.Background(), Config{
+		Duration:    300 * time.Millisecond,
+		MaxFileSize: 8 * 1024,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range report.Violations {
+		t.Error(v)
+	}
+	if report.Operations[Upload] == 0 || report.Operations[Download] == 0 {
+		t.Errorf("operations = %v", report.Operations)
+	}
+	if len(report.Errors) != 0 {
+		t.Errorf("errors without chaos = %v", report.Errors)
+	}
+	if report.Files == 0 {
+		t.Error("no files checked")
+	}
+}
+
+func TestRunChaos(t *testing.T) {
+	report, err := Run(context.Background(), Config{
+		Duration:         500 * time.Millisecond,
+		MaxFileSize:      8 * 1024,
+		Seed:             1,
+		DisconnectEvery:  50 * time.Millisecond,
+		Reconnect:        sftp.ReconnectPolicy{MaxAttempts: 5, Backoff: time.Millisecond},
+		LatencySpikeRate: 0.01,
+		LatencySpike:     5 * time.Millisecond,
+		FaultRate:        0.02,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range report.Violations {
+		t.Error(v)
+	}
+	if report.Disconnects == 0 || report.Faults == 0 || report.LatencySpikes == 0 {
+		t.Errorf("no chaos: %d disconnects, %d faults, %d latency spikes", report.Disconnects, report.Faults, report.LatencySpikes)
+	}
+	t.Logf("operations %v, errors %v", report.Operations, report.Errors)
+}
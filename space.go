@@ -0,0 +1,200 @@
+package sftp
+
+import (
+	iofs "io/fs"
+
+	"github.com/pkg/sftp/apis"
+)
+
+// spaceAvailableExtension asks the server for the space left on the file
+// system of a path, as specified by draft-ietf-secsh-filexfer-13.
+const spaceAvailableExtension = "space-available"
+
+// allocateExtension asks the server to reserve the disk space of a byte
+// range of an open file.
+const allocateExtension = "allocate@github.com/pkg/sftp"
+
+// SpaceAvailable is the space on the file system of a path, in bytes.
+type SpaceAvailable struct {
+	BytesOnDevice              uint64 // size of the file system
+	UnusedBytesOnDevice        uint64 // free space on it
+	BytesAvailableToUser       uint64 // what the user may use of it, like with a quota
+	UnusedBytesAvailableToUser uint64 // what the user may still write
+	BytesPerAllocationUnit     uint32 // the unit space is allocated in, 0 if unknown
+}
+
+func spaceAvailableFromStatVFS(st *StatVFS) *SpaceAvailable {
+	unit := uint32(st.Frsize)
+	if uint64(unit) != st.Frsize {
+		unit = 0
+	}
+	return &SpaceAvailable{
+		BytesOnDevice:              st.TotalSpace(),
+		UnusedBytesOnDevice:        st.FreeSpace(),
+		BytesAvailableToUser:       st.TotalSpace(),
+		UnusedBytesAvailableToUser: st.Frsize * st.Bavail,
+		BytesPerAllocationUnit:     unit,
+	}
+}
+
+// SpaceAvailable returns the space on the file system containing path, so
+// that uploads can fail fast when it cannot take them.
+//
+// If the server supports the space-available extension, as the Server of
+// this package does, SpaceAvailable uses it. Otherwise it derives the space
+// from StatVFS, which needs the statvfs@openssh.com extension.
+func (c *Client) SpaceAvailable(path string) (_ *SpaceAvailable, err error) {
+	defer pathError(&err, "spaceavailable", path)
+
+	if _, ok := c.HasExtension(spaceAvailableExtension); ok {
+		space, err := c.spaceAvailable(path)
+		if status, ok := err.(*StatusError); !ok || status.FxCode() != ErrSSHFxOpUnsupported {
+			return space, err
+		}
+	}
+
+	st, err := c.StatVFS(path)
+	if err != nil {
+		return nil, err
+	}
+	return spaceAvailableFromStatVFS(st), nil
+}
+
+func (c *Client) spaceAvailable(path string) (*SpaceAvailable, error) {
+	id := c.nextID()
+	typ, data, err := c.sendPacket(nil, &sshFxpSpaceAvailablePacket{
+		ID:   id,
+		Path: path,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case sshFxpExtendedReply:
+		sid, data, err := unmarshalUint32Safe(data)
+		if err != nil {
+			return nil, err
+		}
+		if sid != id {
+			return nil, &unexpectedIDErr{id, sid}
+		}
+		return unmarshalSpaceAvailable(data)
+
+	case sshFxpStatus:
+		return nil, normaliseError(unmarshalStatus(id, data))
+
+	default:
+		return nil, unimplementedPacketErr(typ)
+	}
+}
+
+func unmarshalSpaceAvailable(b []byte) (*SpaceAvailable, error) {
+	var s SpaceAvailable
+	var err error
+	for _, v := range []*uint64{&s.BytesOnDevice, &s.UnusedBytesOnDevice, &s.BytesAvailableToUser, &s.UnusedBytesAvailableToUser} {
+		if *v, b, err = unmarshalUint64Safe(b); err != nil {
+			return nil, err
+		}
+	}
+	if s.BytesPerAllocationUnit, _, err = unmarshalUint32Safe(b); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+type sshFxpSpaceAvailableReply struct {
+	ID uint32
+	SpaceAvailable
+}
+
+func (p *sshFxpSpaceAvailableReply) id() uint32 { return p.ID }
+
+func (p *sshFxpSpaceAvailableReply) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4*8 + // 4*uint64
+		4 // uint32(bytes-per-allocation-unit)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtendedReply)
+	b = marshalUint32(b, p.ID)
+	b = marshalUint64(b, p.BytesOnDevice)
+	b = marshalUint64(b, p.UnusedBytesOnDevice)
+	b = marshalUint64(b, p.BytesAvailableToUser)
+	b = marshalUint64(b, p.UnusedBytesAvailableToUser)
+	b = marshalUint32(b, p.BytesPerAllocationUnit)
+
+	return b, nil
+}
+
+func (p *sshFxpExtendedPacketSpaceAvailable) respond(svr *Server) responsePacket {
+	st, err := svr.statVFS(toLocalPath(p.Path))
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+	return &sshFxpSpaceAvailableReply{ID: p.ID, SpaceAvailable: *spaceAvailableFromStatVFS(st)}
+}
+
+// Preallocate reserves the disk space of the first size bytes of the file,
+// growing it to size if it is shorter, so that writing them cannot run out
+// of space later: a volume too full for the upload fails it up front.
+//
+// If the server supports the allocate@github.com/pkg/sftp extension, as the
+// Server of this package does on Linux, the space is reserved. Otherwise
+// Preallocate only grows the file with Truncate, which may leave it sparse;
+// check SpaceAvailable beforehand to fail fast then.
+func (f *File) Preallocate(size int64) (err error) {
+	defer pathError(&err, "preallocate", f.path)
+	if err = f.checkClosed(); err != nil {
+		return err
+	}
+
+	if size < 0 {
+		return iofs.ErrInvalid
+	}
+	if _, ok := f.c.HasExtension(allocateExtension); ok {
+		err := f.allocate(0, size)
+		if status, ok := err.(*StatusError); !ok || status.FxCode() != ErrSSHFxOpUnsupported {
+			return err
+		}
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() >= size {
+		return nil
+	}
+	return f.Truncate(size)
+}
+
+func (f *File) allocate(offset, length int64) error {
+	id := f.c.nextID()
+	typ, data, err := f.c.sendPacket(nil, &sshFxpAllocatePacket{
+		ID:     id,
+		Handle: f.handle,
+		Offset: uint64(offset),
+		Length: uint64(length),
+	})
+
+	switch {
+	case err != nil:
+		return err
+	case typ == sshFxpStatus:
+		return normaliseError(unmarshalStatus(id, data))
+	default:
+		return &unexpectedPacketErr{want: sshFxpStatus, got: typ}
+	}
+}
+
+func (p *sshFxpExtendedPacketAllocate) respond(svr *Server) responsePacket {
+	f, ok := svr.getHandle(p.Handle)
+	if !ok {
+		return statusFromError(p.ID, EBADF)
+	}
+	if p.Length == 0 {
+		return statusFromError(p.ID, nil)
+	}
+	return statusFromError(p.ID, apis.Allocate(f, int64(p.Offset), int64(p.Length)))
+}
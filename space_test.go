@@ -0,0 +1,120 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientSpaceAvailable(t *testing.T) {
+	skipIfWindows(t)
+	skipIfPlan9(t)
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	space, err := client.SpaceAvailable(dir)
+	require.NoError(t, err)
+	st, err := client.StatVFS(dir)
+	require.NoError(t, err)
+	assert.Equal(t, st.TotalSpace(), space.BytesOnDevice)
+	assert.Equal(t, st.TotalSpace(), space.BytesAvailableToUser)
+	assert.Equal(t, uint64(st.Frsize), uint64(space.BytesPerAllocationUnit))
+	assert.LessOrEqual(t, space.UnusedBytesAvailableToUser, space.BytesAvailableToUser)
+
+	_, err = client.SpaceAvailable(filepath.Join(dir, "missing"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSpaceAvailableReply(t *testing.T) {
+	want := SpaceAvailable{
+		BytesOnDevice:              1 << 40,
+		UnusedBytesOnDevice:        1 << 30,
+		BytesAvailableToUser:       1 << 20,
+		UnusedBytesAvailableToUser: 1 << 10,
+		BytesPerAllocationUnit:     4096,
+	}
+	b, err := (&sshFxpSpaceAvailableReply{ID: 1, SpaceAvailable: want}).MarshalBinary()
+	require.NoError(t, err)
+	got, err := unmarshalSpaceAvailable(b[9:])
+	require.NoError(t, err)
+	assert.Equal(t, &want, got)
+
+	_, err = unmarshalSpaceAvailable(b[9 : len(b)-1])
+	assert.Equal(t, errShortPacket, err)
+}
+
+func TestServerSpaceAvailableQuota(t *testing.T) {
+	skipIfWindows(t)
+	skipIfPlan9(t)
+
+	client, server := clientServerPair(t, WithQuota(NewQuotaManager(1<<20, 0), "user"))
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	space, err := client.SpaceAvailable(dir)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, space.BytesAvailableToUser, uint64(1<<20))
+	assert.LessOrEqual(t, space.UnusedBytesAvailableToUser, uint64(1<<20))
+
+	// allocations are charged like the writes they make room for
+	f, err := client.Create(filepath.Join(dir, "big"))
+	require.NoError(t, err)
+	defer f.Close()
+	assert.Error(t, f.Preallocate(2<<20))
+	require.NoError(t, f.Preallocate(1<<19))
+	assert.Equal(t, int64(1<<19), quotaBytes(t, server))
+}
+
+// quotaBytes returns the bytes the session of server is charged.
+func quotaBytes(t *testing.T, server *Server) int64 {
+	t.Helper()
+	require.NotNil(t, server.quota)
+	return server.quota.m.Usage(server.quota.user).Bytes
+}
+
+func TestFilePreallocate(t *testing.T) {
+	skipIfWindows(t)
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	name := filepath.Join(t.TempDir(), "file")
+	f, err := client.Create(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, f.Preallocate(1<<16))
+	fi, err := os.Stat(name)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<16), fi.Size())
+
+	// never shrinks
+	require.NoError(t, f.Preallocate(10))
+	fi, err = os.Stat(name)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<16), fi.Size())
+
+	assert.Error(t, f.Preallocate(-1))
+}
+
+func TestRequestPreallocate(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+
+	f, err := p.cli.Create("/file")
+	require.NoError(t, err)
+
+	// the client grows the file itself
+	require.NoError(t, f.Preallocate(100))
+	require.NoError(t, f.Close())
+	fi, err := p.cli.Stat("/file")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), fi.Size())
+	checkRequestServerAllocator(t, p)
+}
@@ -0,0 +1,229 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"sync"
+
+	"github.com/pkg/sftp/apis"
+)
+
+// errSpecialFile is the error for opens of special files refused by
+// SpecialFilesRefused. Clients see it as SSH_FX_OP_UNSUPPORTED.
+var errSpecialFile = errors.New("sftp: not a regular file, special files are not served")
+
+// errStreamOffset is the error for reads and writes of a streamed special
+// file, which do not continue where the previous one ended.
+var errStreamOffset = errors.New("sftp: special file is streamed, read and write it sequentially")
+
+// SpecialFilePolicy is how a server serves files which are neither regular
+// files nor directories, like character devices and FIFOs.
+type SpecialFilePolicy int
+
+const (
+	// SpecialFilesAsFiles serves special files like regular files, reading
+	// and writing them at the offsets the client asks for, which most
+	// devices and all FIFOs fail or ignore. This is the default.
+	SpecialFilesAsFiles SpecialFilePolicy = iota
+
+	// SpecialFilesRefused refuses to open special files, with the status
+	// SSH_FX_OP_UNSUPPORTED. They can still be listed and stat'd.
+	SpecialFilesRefused
+
+	// SpecialFilesStreamed serves special files as streams: the offsets
+	// of reads and writes are no positions in the file, they only have to
+	// continue where the previous read or write ended, and requests out of
+	// this order fail. Clients have to keep a single read or write in
+	// flight, like File.WriteTo of this package does for files which are
+	// not regular. Nothing is assumed of the size the file reports.
+	SpecialFilesStreamed
+)
+
+// WithSpecialFiles sets how the Server serves special files, like character
+// devices and FIFOs, see SpecialFilePolicy. Opening a FIFO waits for its
+// other end in any case.
+func WithSpecialFiles(policy SpecialFilePolicy) ServerOption {
+	return func(s *Server) error {
+		s.specialFiles = policy
+		return nil
+	}
+}
+
+// WithRSSpecialFiles sets how the RequestServer serves special files, see
+// SpecialFilePolicy. Which files are special is told by the Stat requests of
+// the FileLister, which are made before the opens of a policy other than
+// SpecialFilesAsFiles. Streamed files are read and written through the
+// handlers at the offsets of the stream.
+func WithRSSpecialFiles(policy SpecialFilePolicy) RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.specialFiles = policy
+	}
+}
+
+// isSpecialFile reports whether mode is the one of a special file.
+func isSpecialFile(mode fs.FileMode) bool {
+	return mode&(fs.ModeDevice|fs.ModeCharDevice|fs.ModeNamedPipe|fs.ModeSocket|fs.ModeIrregular) != 0
+}
+
+// refuseSpecialFile returns the response to an open of a refused special file.
+func refuseSpecialFile(id uint32) responsePacket {
+	return &sshFxpStatusPacket{
+		ID: id,
+		StatusError: StatusError{
+			Code: sshFxOPUnsupported,
+			msg:  errSpecialFile.Error(),
+		},
+	}
+}
+
+// specialFile returns whether name is a special file to be refused, or to
+// be streamed, by the policy of the Server.
+func (svr *Server) specialFile(name string) (refused, streamed bool) {
+	if svr.specialFiles == SpecialFilesAsFiles {
+		return false, false
+	}
+	fi, err := svr.fs.Stat(name)
+	if err != nil || !isSpecialFile(fi.Mode()) {
+		return false, false
+	}
+	return svr.specialFiles == SpecialFilesRefused, svr.specialFiles == SpecialFilesStreamed
+}
+
+// specialFile returns whether the file of r is a special file to be refused,
+// or to be streamed, by the policy of the RequestServer.
+func (rs *RequestServer) specialFile(r *Request) (refused, streamed bool) {
+	if rs.specialFiles == SpecialFilesAsFiles || rs.Handlers.FileList == nil {
+		return false, false
+	}
+	fi, err := listOne(rs.Handlers.FileList.Filelist(NewRequest("Stat", r.Filepath)))
+	if err != nil || !isSpecialFile(fi.Mode()) {
+		return false, false
+	}
+	return rs.specialFiles == SpecialFilesRefused, rs.specialFiles == SpecialFilesStreamed
+}
+
+// streamPos is the position of the reads and writes of a streamed file.
+type streamPos struct {
+	mu      sync.Mutex
+	read    int64
+	written int64
+}
+
+// readAt reads into b with read, if off continues the stream.
+func (s *streamPos) readAt(b []byte, off int64, read func([]byte) (int, error)) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if off != s.read {
+		return 0, errStreamOffset
+	}
+	n, err := read(b)
+	s.read += int64(n)
+	return n, err
+}
+
+// writeAt writes b with write, if off continues the stream.
+func (s *streamPos) writeAt(b []byte, off int64, write func([]byte) (int, error)) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if off != s.written {
+		return 0, errStreamOffset
+	}
+	n, err := write(b)
+	s.written += int64(n)
+	return n, err
+}
+
+// streamedFile is a special file of a Server, read and written as a stream.
+type streamedFile struct {
+	apis.File
+	pos streamPos
+}
+
+func (f *streamedFile) ReadAt(b []byte, off int64) (int, error) {
+	return f.pos.readAt(b, off, f.File.Read)
+}
+
+func (f *streamedFile) WriteAt(b []byte, off int64) (int, error) {
+	return f.pos.writeAt(b, off, f.File.Write)
+}
+
+// streamedHandle is a special file of a RequestServer, read and written as a
+// stream through the reader and writer the handlers returned.
+type streamedHandle struct {
+	rd     io.ReaderAt
+	wr     io.WriterAt
+	shared bool // rd and wr are the same
+	pos    streamPos
+}
+
+func (h *streamedHandle) ReadAt(b []byte, off int64) (int, error) {
+	if h.rd == nil {
+		return 0, errors.New("unexpected read packet")
+	}
+	return h.pos.readAt(b, off, func(b []byte) (int, error) {
+		return h.rd.ReadAt(b, off)
+	})
+}
+
+func (h *streamedHandle) WriteAt(b []byte, off int64) (int, error) {
+	if h.wr == nil {
+		return 0, errors.New("unexpected write packet")
+	}
+	return h.pos.writeAt(b, off, func(b []byte) (int, error) {
+		return h.wr.WriteAt(b, off)
+	})
+}
+
+// Close closes the reader and the writer, if they are io.Closers.
+func (h *streamedHandle) Close() error {
+	var err error
+	for _, x := range h.parts() {
+		if c, ok := x.(io.Closer); ok {
+			if err2 := c.Close(); err == nil {
+				err = err2
+			}
+		}
+	}
+	return err
+}
+
+// TransferError passes err on to the reader and the writer, if they are
+// TransferErrors.
+func (h *streamedHandle) TransferError(err error) {
+	for _, x := range h.parts() {
+		if t, ok := x.(TransferError); ok {
+			t.TransferError(err)
+		}
+	}
+}
+
+// parts returns the reader and the writer, once if they are the same.
+func (h *streamedHandle) parts() []interface{} {
+	var parts []interface{}
+	if h.rd != nil {
+		parts = append(parts, h.rd)
+	}
+	if h.wr != nil && !h.shared {
+		parts = append(parts, h.wr)
+	}
+	return parts
+}
+
+// stream has the reads and writes of the open file go through a
+// streamedHandle.
+func (s *state) stream() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.writerAtReaderAt != nil:
+		s.writerAtReaderAt = &streamedHandle{rd: s.writerAtReaderAt, wr: s.writerAtReaderAt, shared: true}
+	case s.writerAt != nil:
+		s.writerAt = &streamedHandle{wr: s.writerAt}
+	case s.readerAt != nil:
+		s.readerAt = &streamedHandle{rd: s.readerAt}
+	}
+}
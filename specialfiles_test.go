@@ -0,0 +1,117 @@
+package sftp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerSpecialFilesRefused(t *testing.T) {
+	skipIfWindows(t)
+	skipIfPlan9(t)
+
+	client, server := clientServerPair(t, WithSpecialFiles(SpecialFilesRefused))
+	defer client.Close()
+	defer server.Close()
+
+	_, err := client.Open("/dev/zero")
+	var status *StatusError
+	require.True(t, errors.As(err, &status), "got %v", err)
+	assert.Equal(t, uint32(sshFxOPUnsupported), status.Code)
+	assert.Equal(t, errSpecialFile.Error(), status.msg)
+
+	fi, err := client.Stat("/dev/zero")
+	require.NoError(t, err)
+	assert.True(t, fi.Mode()&fs.ModeCharDevice != 0)
+}
+
+func TestServerSpecialFilesStreamed(t *testing.T) {
+	skipIfWindows(t)
+	skipIfPlan9(t)
+
+	client, server := clientServerPair(t, WithSpecialFiles(SpecialFilesStreamed))
+	defer client.Close()
+	defer server.Close()
+
+	f, err := client.Open("/dev/zero")
+	require.NoError(t, err)
+	b := make([]byte, 100)
+	for i := 0; i < 2; i++ {
+		n, err := f.Read(b)
+		require.NoError(t, err)
+		assert.Equal(t, 100, n)
+	}
+	_, err = f.ReadAt(b, 1000)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), errStreamOffset.Error())
+	}
+	require.NoError(t, f.Close())
+
+	f, err = client.OpenFile("/dev/null", os.O_WRONLY)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	assert.NoError(t, err)
+	_, err = f.WriteAt([]byte("hello"), 0)
+	assert.Error(t, err, "the stream is at 5")
+	require.NoError(t, f.Close())
+}
+
+// specialFileInfo is the FileInfo of a FIFO.
+type specialFileInfo struct{ name string }
+
+func (fi specialFileInfo) Name() string       { return fi.name }
+func (fi specialFileInfo) Size() int64        { return 0 }
+func (fi specialFileInfo) Mode() fs.FileMode  { return fs.ModeNamedPipe | 0o644 }
+func (fi specialFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi specialFileInfo) IsDir() bool        { return false }
+func (fi specialFileInfo) Sys() interface{}   { return nil }
+
+// fifoHandler serves a FIFO with content.
+type fifoHandler struct {
+	content []byte
+}
+
+func (h fifoHandler) Fileread(*Request) (io.ReaderAt, error) {
+	return bytes.NewReader(h.content), nil
+}
+
+func (h fifoHandler) Filelist(r *Request) (ListerAt, error) {
+	return listerat{specialFileInfo{name: "fifo"}}, nil
+}
+
+func TestRequestServerSpecialFiles(t *testing.T) {
+	h := fifoHandler{content: []byte("hello, world")}
+
+	for _, policy := range []SpecialFilePolicy{SpecialFilesAsFiles, SpecialFilesRefused, SpecialFilesStreamed} {
+		client, server := clientRequestServerPipe(t, Handlers{FileGet: h, FileList: h}, []RequestServerOption{WithRSSpecialFiles(policy)})
+
+		f, err := client.Open("/fifo")
+		if policy == SpecialFilesRefused {
+			assert.Error(t, err)
+		} else {
+			require.NoError(t, err)
+			b := make([]byte, 5)
+			_, err = f.ReadAt(b, 7)
+			if policy == SpecialFilesStreamed {
+				assert.Error(t, err, "out of order")
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "world", string(b))
+			}
+			_, err = io.ReadFull(f, b)
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(b))
+			require.NoError(t, f.Close())
+		}
+
+		server.Close()
+		client.Close()
+	}
+}
@@ -0,0 +1,344 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"path"
+	"sync"
+	"time"
+)
+
+// WithStatCache has the Client answer Stat and Lstat from cache, for ttl
+// after the server answered them, keeping up to size paths. This serves
+// sync tools, which stat the same paths over and over again.
+//
+// The requests of the Client which change files drop the entries of the
+// paths they touch, those below them and their parent directories: renames,
+// removals, setstats, links, and writes and truncates through Files opened
+// by the Client. Changes made otherwise, by other clients or through other
+// paths like symbolic links, show up once the entries expired. Relative
+// paths depend on the working directory of the server and are not cached,
+// and errors are not cached either, see WithNegativeStatCache for that.
+// StatNoCache and LstatNoCache bypass the cache.
+func WithStatCache(ttl time.Duration, size int) ClientOption {
+	return func(c *Client) error {
+		if ttl <= 0 {
+			return fmt.Errorf("sftp: stat cache ttl %v is not positive", ttl)
+		}
+		if size < 1 {
+			return fmt.Errorf("sftp: stat cache needs room for at least one path, not %d", size)
+		}
+		cache := c.statCache()
+		cache.ttl = ttl
+		cache.size = size
+		return nil
+	}
+}
+
+// WithNegativeStatCache has the Client answer Stat and Lstat of paths the
+// server reported not to exist from cache, for ttl after it did. This serves
+// sync algorithms, which probe many paths that do not exist before uploading.
+//
+// Keep ttl short: only the requests of the Client creating, renaming or
+// linking paths drop the entries of those, just like with WithStatCache.
+// Along with WithStatCache, both share its size; alone, up to 1024 paths
+// are kept. StatNoCache and LstatNoCache bypass the cache.
+func WithNegativeStatCache(ttl time.Duration) ClientOption {
+	return func(c *Client) error {
+		if ttl <= 0 {
+			return fmt.Errorf("sftp: negative stat cache ttl %v is not positive", ttl)
+		}
+		c.statCache().negTTL = ttl
+		return nil
+	}
+}
+
+// defaultStatCacheSize is how many paths the stat cache keeps,
+// unless WithStatCache says otherwise.
+const defaultStatCacheSize = 1024
+
+// statCache returns the stat cache of c, creating it if need be.
+func (c *Client) statCache() *statCache {
+	if c.attrCache == nil {
+		c.attrCache = &statCache{
+			size:    defaultStatCacheSize,
+			entries: make(map[statKey]cachedStat),
+			handles: make(map[string]string),
+			pending: make(map[uint32]invalidation),
+		}
+	}
+	return c.attrCache
+}
+
+// statCache holds the answers to Stat and Lstat,
+// see WithStatCache and WithNegativeStatCache.
+type statCache struct {
+	ttl    time.Duration // of the attributes, 0 if not cached
+	negTTL time.Duration // of the paths not existing, 0 if not cached
+	size   int
+
+	mu      sync.Mutex
+	gen     uint64 // counts invalidations, to drop what was asked meanwhile
+	entries map[statKey]cachedStat
+	handles map[string]string       // the paths of the Files open
+	pending map[uint32]invalidation // of the outstanding requests changing files
+}
+
+// statKey is a path stat or, if lstat is set, lstat was asked about.
+type statKey struct {
+	name  string
+	lstat bool
+}
+
+type cachedStat struct {
+	stat    FileStat
+	err     error // the path does not exist if set
+	expires time.Time
+}
+
+// invalidation is what a request changing files invalidates once more when
+// it is answered, as requests may be served concurrently with it.
+type invalidation struct {
+	files []string // whose attributes change
+	trees []string // which are created, removed or replaced, with all below
+	all   bool     // a handle of no known path
+	close string   // the handle it closes
+}
+
+// statCacheKey returns the key of the path name,
+// relative paths are not cached.
+func statCacheKey(name string) (string, bool) {
+	if !path.IsAbs(name) {
+		return "", false
+	}
+	return path.Clean(name), true
+}
+
+// generation returns the count of invalidations, to pass to store.
+func (c *statCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// lookup returns the cached answer to stat, or lstat, for name.
+func (c *statCache) lookup(name string, lstat bool) (cachedStat, bool) {
+	key, ok := statCacheKey(name)
+	if !ok {
+		return cachedStat{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[statKey{key, lstat}]
+	if !ok || time.Now().After(e.expires) {
+		return cachedStat{}, false
+	}
+	return e, true
+}
+
+// store caches the answer to stat, or lstat, for name, unless something was
+// invalidated since gen. Of the errors, only those of paths not existing are
+// cached, if asked to; answers not cached drop what was.
+func (c *statCache) store(gen uint64, name string, lstat bool, stat *FileStat, err error) {
+	key, ok := statCacheKey(name)
+	if !ok {
+		return
+	}
+	ttl := c.ttl
+	if err != nil {
+		ttl = 0
+		if errors.Is(err, iofs.ErrNotExist) {
+			ttl = c.negTTL
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ttl == 0 {
+		delete(c.entries, statKey{key, lstat})
+		return
+	}
+	if c.gen != gen {
+		return
+	}
+	now := time.Now()
+	if len(c.entries) >= c.size {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	if len(c.entries) >= c.size {
+		// make room for the most recent
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	e := cachedStat{err: err, expires: now.Add(ttl)}
+	if err == nil {
+		e.stat = *stat
+	}
+	c.entries[statKey{key, lstat}] = e
+}
+
+// opened remembers the path of the File open as handle.
+func (c *statCache) opened(handle, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handles[handle] = name
+}
+
+// sending invalidates the entries request p, about to be sent, changes.
+func (c *statCache) sending(p idmarshaler) {
+	var inv invalidation
+	if !c.changes(p, &inv) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if inv.close != "" {
+		if name, ok := c.handles[inv.close]; ok {
+			inv.files = append(inv.files, name)
+		}
+	}
+	c.invalidate(inv)
+	c.pending[p.id()] = inv
+}
+
+// answered invalidates the entries of the request sid once more, if it
+// changes files, now that it has been served.
+func (c *statCache) answered(sid uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	inv, ok := c.pending[sid]
+	if !ok {
+		return
+	}
+	delete(c.pending, sid)
+	c.invalidate(inv)
+	if inv.close != "" {
+		delete(c.handles, inv.close)
+	}
+}
+
+// changes collects in inv the paths request p changes,
+// reporting whether it changes any.
+func (c *statCache) changes(p idmarshaler, inv *invalidation) bool {
+	switch p := p.(type) {
+	case trailerPacket:
+		return c.changes(p.idmarshaler, inv)
+	case asExtension:
+		return c.changes(p.idmarshaler, inv)
+
+	case *sshFxpOpenPacket:
+		switch {
+		case p.Pflags&(sshFxfCreat|sshFxfTrunc) != 0:
+			inv.trees = []string{p.Path}
+		case p.Pflags&sshFxfWrite != 0:
+			inv.files = []string{p.Path}
+		default:
+			return false
+		}
+	case *sshFxpOpenV5Packet:
+		if p.DesiredAccess&(ace4WriteData|ace4AppendData) == 0 {
+			return false
+		}
+		inv.trees = []string{p.Path}
+	case *sshFxpSetstatPacket:
+		inv.files = []string{p.Path}
+	case *sshFxpSetACLPacket:
+		inv.files = []string{p.Path}
+	case *sshFxpRemovePacket:
+		inv.trees = []string{p.Filename}
+	case *sshFxpMkdirPacket:
+		inv.trees = []string{p.Path}
+	case *sshFxpRmdirPacket:
+		inv.trees = []string{p.Path}
+	case *sshFxpRenamePacket:
+		inv.trees = []string{p.Oldpath, p.Newpath}
+	case *sshFxpPosixRenamePacket:
+		inv.trees = []string{p.Oldpath, p.Newpath}
+	case *sshFxpSymlinkPacket:
+		inv.trees = []string{p.Linkpath}
+	case *sshFxpHardlinkPacket:
+		// the link count of the old path changes too
+		inv.files = []string{p.Oldpath}
+		inv.trees = []string{p.Newpath}
+	case *sshFxpLinkPacket:
+		inv.trees = []string{p.NewLinkPath}
+		if !p.Symlink {
+			inv.files = []string{p.ExistingPath}
+		}
+
+	case *sshFxpWritePacket:
+		c.changesHandle(p.Handle, inv)
+	case *sshFxpFsetstatPacket:
+		c.changesHandle(p.Handle, inv)
+	case *sshFxpAllocatePacket:
+		c.changesHandle(p.Handle, inv)
+	case *sshFxpCopyDataPacket:
+		c.changesHandle(p.WriteToHandle, inv)
+	case *sshFxpClosePacket:
+		// the size and times changed with the writes
+		inv.close = p.Handle
+
+	default:
+		return false
+	}
+	return true
+}
+
+// changesHandle adds the path of the File open as handle to inv.
+func (c *statCache) changesHandle(handle string, inv *invalidation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if name, ok := c.handles[handle]; ok {
+		inv.files = []string{name}
+	} else {
+		inv.all = true
+	}
+}
+
+// invalidate drops the entries of inv: those of its files, and those of its
+// trees, below them and of their parent directories. The caller holds c.mu.
+func (c *statCache) invalidate(inv invalidation) {
+	c.gen++
+
+	if inv.all {
+		c.entries = make(map[statKey]cachedStat)
+		return
+	}
+	for _, name := range inv.files {
+		key, ok := statCacheKey(name)
+		if !ok {
+			// no telling which entries a relative path refers to
+			c.entries = make(map[statKey]cachedStat)
+			return
+		}
+		delete(c.entries, statKey{key, false})
+		delete(c.entries, statKey{key, true})
+	}
+	for _, name := range inv.trees {
+		key, ok := statCacheKey(name)
+		if !ok {
+			c.entries = make(map[statKey]cachedStat)
+			return
+		}
+		dir := path.Dir(key)
+		for k := range c.entries {
+			if within(k.name, key) || k.name == dir {
+				delete(c.entries, k)
+			}
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package sftp
+
+import (
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// statCountingFs counts the stat and lstat requests reaching the file system.
+type statCountingFs struct {
+	apis.FullFs
+	calls int32
+}
+
+func (fs *statCountingFs) Stat(name string) (iofs.FileInfo, error) {
+	atomic.AddInt32(&fs.calls, 1)
+	return fs.FullFs.Stat(name)
+}
+
+func (fs *statCountingFs) Lstat(name string) (iofs.FileInfo, error) {
+	atomic.AddInt32(&fs.calls, 1)
+	return fs.FullFs.Lstat(name)
+}
+
+func (fs *statCountingFs) count() int32 { return atomic.LoadInt32(&fs.calls) }
+
+func TestClientStatCache(t *testing.T) {
+	skipIfWindows(t)
+	skipIfPlan9(t)
+
+	fs := &statCountingFs{FullFs: apis.NewAVFS()}
+	client, server := clientServerPairFS(t, fs, nil, WithStatCache(time.Hour, 100))
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	name := dir + "/file"
+	_, err := putTestFile(client, name, "hello")
+	require.NoError(t, err)
+
+	// stat asks the server once, whatever it is called with
+	stat := func() iofs.FileInfo {
+		t.Helper()
+		before := fs.count()
+		fi, err := client.Stat(name)
+		require.NoError(t, err)
+		_, err = client.Stat(dir + "/./file")
+		require.NoError(t, err)
+		assert.Equal(t, before+1, fs.count())
+		return fi
+	}
+	assert.Equal(t, int64(5), stat().Size())
+
+	// stat and lstat are cached apart
+	before := fs.count()
+	_, err = client.Lstat(name)
+	require.NoError(t, err)
+	assert.Equal(t, before+1, fs.count())
+
+	require.NoError(t, client.Chmod(name, 0o600))
+	assert.Equal(t, iofs.FileMode(0o600), stat().Mode().Perm())
+
+	f, err := client.OpenFile(name, os.O_WRONLY)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte(" world"), 5)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, int64(11), stat().Size())
+
+	// renames drop the entries of the parent directory too
+	_, err = client.Stat(dir)
+	require.NoError(t, err)
+	require.NoError(t, client.Rename(name, dir+"/other"))
+	_, err = client.Stat(name)
+	assert.Error(t, err)
+	before = fs.count()
+	_, err = client.Stat(dir)
+	require.NoError(t, err)
+	assert.Equal(t, before+1, fs.count())
+
+	// errors are not cached
+	before = fs.count()
+	for i := 0; i < 2; i++ {
+		_, err = client.Stat(name)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, before+2, fs.count())
+
+	require.NoError(t, client.Rename(dir+"/other", name))
+	stat()
+	require.NoError(t, client.Remove(name))
+	_, err = client.Stat(name)
+	assert.Error(t, err)
+
+	_, w := io.Pipe()
+	for _, opt := range []ClientOption{WithStatCache(0, 1), WithStatCache(time.Second, 0)} {
+		_, err = NewClientPipe(nil, w, opt)
+		assert.Error(t, err)
+	}
+}
+
+func TestStatCacheStore(t *testing.T) {
+	c := &statCache{
+		ttl:     time.Hour,
+		size:    10,
+		entries: make(map[statKey]cachedStat),
+		handles: make(map[string]string),
+		pending: make(map[uint32]invalidation),
+	}
+
+	// relative paths are not cached
+	c.store(c.generation(), "file", false, &FileStat{}, nil)
+	_, ok := c.lookup("file", false)
+	assert.False(t, ok)
+
+	// answers asked before an invalidation are not stored
+	gen := c.generation()
+	c.sending(&sshFxpRemovePacket{ID: 1, Filename: "/a"})
+	c.store(gen, "/b", false, &FileStat{}, nil)
+	_, ok = c.lookup("/b", false)
+	assert.False(t, ok)
+
+	// nor when it is answered
+	gen = c.generation()
+	c.answered(1)
+	c.store(gen, "/b", false, &FileStat{}, nil)
+	_, ok = c.lookup("/b", false)
+	assert.False(t, ok)
+	assert.Empty(t, c.pending)
+
+	c.store(c.generation(), "/b", false, &FileStat{Size: 1}, nil)
+	e, ok := c.lookup("/b", false)
+	require.True(t, ok)
+	assert.Equal(t, uint64(1), e.stat.Size)
+
+	// errors are not cached, unless asked to for paths not existing
+	c.store(c.generation(), "/c", false, nil, iofs.ErrPermission)
+	c.store(c.generation(), "/d", false, nil, iofs.ErrNotExist)
+	_, ok = c.lookup("/c", false)
+	assert.False(t, ok)
+	_, ok = c.lookup("/d", false)
+	assert.False(t, ok)
+	c.negTTL = time.Hour
+	c.store(c.generation(), "/d", false, nil, iofs.ErrNotExist)
+	e, ok = c.lookup("/d", false)
+	require.True(t, ok)
+	assert.Equal(t, iofs.ErrNotExist, e.err)
+
+	// writes through handles of unknown paths drop everything
+	c.sending(&sshFxpWritePacket{ID: 2, Handle: "unknown"})
+	_, ok = c.lookup("/b", false)
+	assert.False(t, ok)
+
+	// bounded, even while everything is fresh
+	for i := 0; i < 20; i++ {
+		c.store(c.generation(), fmt.Sprint("/", i), false, &FileStat{}, nil)
+	}
+	assert.LessOrEqual(t, len(c.entries), 10)
+}
+
+func TestClientNegativeStatCache(t *testing.T) {
+	skipIfWindows(t)
+	skipIfPlan9(t)
+
+	fs := &statCountingFs{FullFs: apis.NewAVFS()}
+	client, server := clientServerPairFS(t, fs, nil, WithNegativeStatCache(time.Hour))
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	name := dir + "/file"
+
+	before := fs.count()
+	for i := 0; i < 3; i++ {
+		_, err := client.Stat(name)
+		assert.True(t, os.IsNotExist(err))
+	}
+	assert.Equal(t, before+1, fs.count())
+
+	// the attributes of paths existing are not cached
+	before = fs.count()
+	for i := 0; i < 2; i++ {
+		_, err := client.Stat(dir)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, before+2, fs.count())
+
+	// creating the path drops its entry
+	_, err := putTestFile(client, name, "hello")
+	require.NoError(t, err)
+	_, err = client.Stat(name)
+	require.NoError(t, err)
+
+	// changes made otherwise show once bypassing the cache
+	_, err = client.Lstat(dir + "/other")
+	assert.True(t, os.IsNotExist(err))
+	require.NoError(t, os.WriteFile(dir+"/other", nil, 0o644))
+	_, err = client.Lstat(dir + "/other")
+	assert.True(t, os.IsNotExist(err))
+	_, err = client.LstatNoCache(dir + "/other")
+	require.NoError(t, err)
+	_, err = client.Lstat(dir + "/other")
+	require.NoError(t, err)
+
+	_, w := io.Pipe()
+	_, err = NewClientPipe(nil, w, WithNegativeStatCache(0))
+	assert.Error(t, err)
+}
@@ -0,0 +1,116 @@
+package sftp
+
+import (
+	"encoding"
+	"sync/atomic"
+	"time"
+)
+
+// SessionStats is a snapshot of the activity of a server session, see
+// Server.Stats and RequestServer.Stats.
+type SessionStats struct {
+	Started      time.Time     // when the server was created
+	Uptime       time.Duration // since Started
+	LastActivity time.Time     // when the last request arrived, Started if none did
+
+	OpenHandles int // open files and directories
+
+	// BytesIn and BytesOut are the file contents received from the client
+	// in writes, and sent to it in reads. Headers and other requests are
+	// not counted.
+	BytesIn  uint64
+	BytesOut uint64
+
+	// Requests is keyed by packet type name (e.g. "SSH_FXP_OPEN"), with
+	// the number of requests of each type, see FeatureUsage.
+	Requests map[string]uint64
+}
+
+// sessionStats counts the traffic of a server session.
+type sessionStats struct {
+	bytesIn      uint64 // accessed atomically
+	bytesOut     uint64 // accessed atomically
+	lastActivity int64  // in Unix nanoseconds, accessed atomically
+
+	started time.Time
+}
+
+func newSessionStats() *sessionStats {
+	now := time.Now()
+	return &sessionStats{
+		lastActivity: now.UnixNano(),
+		started:      now,
+	}
+}
+
+// received counts a packet of type typ and payload b from the client.
+func (s *sessionStats) received(typ uint8, b []byte) {
+	atomic.StoreInt64(&s.lastActivity, time.Now().UnixNano())
+	if typ == sshFxpWrite {
+		atomic.AddUint64(&s.bytesIn, uint64(writeLength(b)))
+	}
+}
+
+// writeLength returns the length of the data of the write packet b,
+// 0 if it is malformed.
+func writeLength(b []byte) uint32 {
+	_, b, err := unmarshalUint32Safe(b) // id
+	if err == nil {
+		_, b, err = unmarshalStringSafe(b) // handle
+	}
+	if err == nil {
+		_, b, err = unmarshalUint64Safe(b) // offset
+	}
+	if err != nil {
+		return 0
+	}
+	length, _, _ := unmarshalUint32Safe(b)
+	return length
+}
+
+// sent counts a packet m sent to the client.
+func (s *sessionStats) sent(m encoding.BinaryMarshaler) {
+	if r, ok := m.(orderedResponse); ok {
+		m = r.responsePacket
+	}
+
+	switch p := m.(type) {
+	case *sshFxpDataPacket:
+		atomic.AddUint64(&s.bytesOut, uint64(len(p.Data)))
+	case *sshFxpFileDataPacket:
+		atomic.AddUint64(&s.bytesOut, uint64(p.Length))
+	}
+}
+
+// snapshot returns the stats, with openHandles and the requests of features.
+func (s *sessionStats) snapshot(openHandles int, features *featureTracker) SessionStats {
+	return SessionStats{
+		Started:      s.started,
+		Uptime:       time.Since(s.started),
+		LastActivity: time.Unix(0, atomic.LoadInt64(&s.lastActivity)),
+		OpenHandles:  openHandles,
+		BytesIn:      atomic.LoadUint64(&s.bytesIn),
+		BytesOut:     atomic.LoadUint64(&s.bytesOut),
+		Requests:     features.snapshot().Packets,
+	}
+}
+
+// Stats returns a snapshot of the activity of the session, which may be
+// taken at any time, also while the Server serves requests.
+func (svr *Server) Stats() SessionStats {
+	svr.openFilesLock.RLock()
+	openHandles := len(svr.openFiles)
+	svr.openFilesLock.RUnlock()
+
+	return svr.stats.snapshot(openHandles, svr.features)
+}
+
+// Stats returns a snapshot of the activity of the session, which may be
+// taken at any time, also while the RequestServer serves requests.
+func (rs *RequestServer) Stats() SessionStats {
+	rs.mu.RLock()
+	openHandles := len(rs.openRequests)
+	rs.mu.RUnlock()
+
+	return rs.stats.snapshot(openHandles, rs.features)
+}
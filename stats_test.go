@@ -0,0 +1,60 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerStats(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	before := server.Stats()
+	assert.Zero(t, before.OpenHandles)
+	assert.Zero(t, before.BytesIn)
+
+	name := filepath.Join(t.TempDir(), "f")
+	f, err := client.OpenFile(name, os.O_RDWR|os.O_CREATE)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello, world"))
+	require.NoError(t, err)
+	b := make([]byte, 5)
+	_, err = f.ReadAt(b, 0)
+	require.NoError(t, err)
+
+	// the counters are updated before the responses are sent
+	st := server.Stats()
+	assert.Equal(t, 1, st.OpenHandles)
+	assert.Equal(t, uint64(12), st.BytesIn)
+	assert.Equal(t, uint64(5), st.BytesOut)
+	assert.Equal(t, uint64(1), st.Requests["SSH_FXP_OPEN"])
+	assert.Equal(t, uint64(1), st.Requests["SSH_FXP_WRITE"])
+	assert.Equal(t, before.Started, st.Started)
+	assert.False(t, st.LastActivity.Before(before.LastActivity))
+	assert.GreaterOrEqual(t, int64(st.Uptime), int64(before.Uptime))
+
+	require.NoError(t, f.Close())
+	assert.Zero(t, server.Stats().OpenHandles)
+}
+
+func TestRequestServerStats(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+
+	f, err := p.cli.Create("/foo")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, p.svr.Stats().OpenHandles)
+	require.NoError(t, f.Close())
+
+	st := p.svr.Stats()
+	assert.Zero(t, st.OpenHandles)
+	assert.Equal(t, uint64(5), st.BytesIn)
+	assert.Equal(t, uint64(1), st.Requests["SSH_FXP_CLOSE"])
+}
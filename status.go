@@ -0,0 +1,198 @@
+package sftp
+
+import (
+	"encoding"
+	"errors"
+)
+
+// statusMessages are the messages of the status codes, in English.
+var statusMessages = map[uint32]string{
+	sshFxOk:                      "Success",
+	sshFxEOF:                     "End of file",
+	sshFxNoSuchFile:              "No such file",
+	sshFxPermissionDenied:        "Permission denied",
+	sshFxFailure:                 "Failure",
+	sshFxBadMessage:              "Bad message",
+	sshFxNoConnection:            "No connection",
+	sshFxConnectionLost:          "Connection lost",
+	sshFxOPUnsupported:           "Operation unsupported",
+	sshFxInvalidHandle:           "Invalid handle",
+	sshFxNoSuchPath:              "No such path",
+	sshFxFileAlreadyExists:       "File already exists",
+	sshFxWriteProtect:            "Write protected",
+	sshFxNoMedia:                 "No media",
+	sshFxNoSpaceOnFilesystem:     "No space on file system",
+	sshFxQuotaExceeded:           "Quota exceeded",
+	sshFxUnknownPrincipal:        "Unknown principal",
+	sshFxLockConflict:            "Lock conflict",
+	sshFxDirNotEmpty:             "Directory not empty",
+	sshFxNotADirectory:           "Not a directory",
+	sshFxInvalidFilename:         "Invalid filename",
+	sshFxLinkLoop:                "Too many symbolic links",
+	sshFxCannotDelete:            "Cannot delete",
+	sshFxInvalidParameter:        "Invalid parameter",
+	sshFxFileIsADirectory:        "File is a directory",
+	sshFxByteRangeLockConflict:   "Byte range lock conflict",
+	sshFxByteRangeLockRefused:    "Byte range lock refused",
+	sshFxDeletePending:           "Delete pending",
+	sshFxFileCorrupt:             "File corrupt",
+	sshFxOwnerInvalid:            "Owner invalid",
+	sshFxGroupInvalid:            "Group invalid",
+	sshFxNoMatchingByteRangeLock: "No matching byte range lock",
+}
+
+// publicStatusMessages are the messages of the errors of this package,
+// which clients may tell apart by them, see IsRetryable, or which tell
+// them what to do differently.
+var publicStatusMessages = map[string]bool{
+	ErrRequestLimited.Error(): true,
+	errBackendTimeout.Error(): true,
+	errSpecialFile.Error():    true,
+	errStreamOffset.Error():   true,
+	ErrQuotaExceeded.Error():  true,
+	errTooManyHandles.Error(): true,
+	ErrShuttingDown.Error():   true,
+}
+
+// statusText fills in the message and language tag of status responses.
+//
+// The message is the one of the status code, in the language of the tag.
+// The error the backend failed with is added to it only if verbose, as it
+// may tell clients more about the server than they ought to know, like the
+// local paths of its files.
+type statusText struct {
+	lang      string            // "en" if empty
+	messages  map[uint32]string // in lang, the English ones if missing
+	verbose   bool
+	translate func(error) (uint32, string) // see WithErrorTranslator
+}
+
+// WithStatusLanguage has the Server tag the messages of its status responses
+// with the language tag, like "de" or "en-US", in place of "en". Messages
+// holds the messages of the status codes in that language, the English
+// ones stand in for missing codes.
+func WithStatusLanguage(tag string, messages map[uint32]string) ServerOption {
+	return func(s *Server) error {
+		s.status.lang = tag
+		s.status.messages = messages
+		return nil
+	}
+}
+
+// WithVerboseStatus has the Server add the errors its file system fails
+// with to the messages of its status responses, like
+// "No such file: open /srv/sftp/foo: no such file or directory",
+// which helps debugging clients only showing the message to their users.
+func WithVerboseStatus() ServerOption {
+	return func(s *Server) error {
+		s.status.verbose = true
+		return nil
+	}
+}
+
+// WithErrorTranslator has the Server ask translate for the status code and
+// message of the errors its file system fails with, before mapping them
+// itself. This lets file systems which are not backed by POSIX files, like
+// object stores, answer with precise codes for their own errors, like
+// SSH_FX_QUOTA_EXCEEDED or SSH_FX_FILE_ALREADY_EXISTS, in place of
+// SSH_FX_FAILURE.
+//
+// The message translate returns is sent as is, in place of the one of the
+// code; if empty, the message of the code is sent as usual. A code of 0,
+// SSH_FX_OK, has the Server map the error itself. Mind that clients of
+// version 3 of the protocol only know the codes up to SSH_FX_OP_UNSUPPORTED,
+// most treat the others as failures.
+func WithErrorTranslator(translate func(error) (uint32, string)) ServerOption {
+	return func(s *Server) error {
+		if translate == nil {
+			return errors.New("sftp: error translator is nil")
+		}
+		s.status.translate = translate
+		return nil
+	}
+}
+
+// WithRSStatusLanguage has the RequestServer tag the messages of its status
+// responses with the language tag, like WithStatusLanguage does for a Server.
+func WithRSStatusLanguage(tag string, messages map[uint32]string) RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.status.lang = tag
+		rs.status.messages = messages
+	}
+}
+
+// WithRSVerboseStatus has the RequestServer add the errors its handlers fail
+// with to the messages of its status responses, see WithVerboseStatus.
+func WithRSVerboseStatus() RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.status.verbose = true
+	}
+}
+
+// WithRSErrorTranslator has the RequestServer ask translate for the status
+// code and message of the errors its handlers fail with, see
+// WithErrorTranslator.
+func WithRSErrorTranslator(translate func(error) (uint32, string)) RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.status.translate = translate
+	}
+}
+
+// message returns the message of a status with code and the error message
+// detail, as set by statusFromError.
+func (t *statusText) message(code uint32, detail string) string {
+	if publicStatusMessages[detail] {
+		return detail
+	}
+
+	msg, ok := t.messages[code]
+	if !ok {
+		msg, ok = statusMessages[code]
+	}
+	if !ok {
+		msg = "Unknown status"
+	}
+
+	switch {
+	case !t.verbose, detail == "", code == sshFxOk, code == sshFxEOF:
+	case detail != fxerr(code).Error():
+		msg += ": " + detail
+	}
+	return msg
+}
+
+// apply returns m with the message and language tag filled in,
+// if it is a status response.
+func (t *statusText) apply(m encoding.BinaryMarshaler) encoding.BinaryMarshaler {
+	r, ordered := m.(orderedResponse)
+	p, ok := m.(*sshFxpStatusPacket)
+	if ordered {
+		p, ok = r.responsePacket.(*sshFxpStatusPacket)
+	}
+	if !ok {
+		return m
+	}
+
+	status := *p
+	status.msg = t.message(p.Code, p.msg)
+	if t.translate != nil && p.err != nil {
+		if code, msg := t.translate(p.err); code != sshFxOk {
+			status.Code = code
+			status.msg = msg
+			if msg == "" {
+				status.msg = t.message(code, p.msg)
+			}
+		}
+	}
+	status.err = nil
+	status.lang = t.lang
+	if status.lang == "" {
+		status.lang = "en"
+	}
+
+	if ordered {
+		r.responsePacket = &status
+		return r
+	}
+	return &status
+}
@@ -0,0 +1,172 @@
+package sftp
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusTextMessage(t *testing.T) {
+	var plain statusText
+	verbose := statusText{verbose: true}
+	german := statusText{lang: "de", messages: map[uint32]string{sshFxNoSuchFile: "Datei nicht gefunden"}}
+
+	for _, tt := range []struct {
+		text   statusText
+		code   uint32
+		detail string
+		want   string
+	}{
+		{plain, sshFxOk, "", "Success"},
+		{plain, sshFxEOF, "EOF", "End of file"},
+		{plain, sshFxNoSuchFile, "open /srv/foo: no such file or directory", "No such file"},
+		{plain, sshFxFailure, ErrRequestLimited.Error(), ErrRequestLimited.Error()},
+		{plain, 1000, "", "Unknown status"},
+		{verbose, sshFxNoSuchFile, "open /srv/foo: no such file or directory", "No such file: open /srv/foo: no such file or directory"},
+		{verbose, sshFxNoSuchFile, "no such file", "No such file"},
+		{verbose, sshFxEOF, "EOF", "End of file"},
+		{german, sshFxNoSuchFile, "", "Datei nicht gefunden"},
+		{german, sshFxFailure, "", "Failure"},
+	} {
+		assert.Equal(t, tt.want, tt.text.message(tt.code, tt.detail))
+	}
+}
+
+func TestStatusTextApply(t *testing.T) {
+	text := statusText{lang: "en-US"}
+	status := statusFromError(1, ErrSSHFxPermissionDenied)
+
+	m := text.apply(orderedResponse{responsePacket: status, orderid: 2})
+	if assert.IsType(t, orderedResponse{}, m) {
+		r := m.(orderedResponse)
+		assert.Equal(t, uint32(2), r.orderid)
+		assert.Equal(t, &sshFxpStatusPacket{
+			ID:          1,
+			StatusError: StatusError{Code: sshFxPermissionDenied, msg: "Permission denied", lang: "en-US"},
+		}, r.responsePacket)
+	}
+	assert.Equal(t, "permission denied", status.msg, "the response is copied")
+
+	data := &sshFxpDataPacket{ID: 1}
+	assert.Equal(t, data, text.apply(data))
+}
+
+func TestServerStatusText(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		options []ServerOption
+		lang    string
+		verbose bool
+	}{
+		{"default", nil, "en", false},
+		{"language", []ServerOption{WithStatusLanguage("en-GB", nil)}, "en-GB", false},
+		{"verbose", []ServerOption{WithVerboseStatus()}, "en", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := clientServerPair(t, tt.options...)
+			defer client.Close()
+			defer server.Close()
+
+			name := filepath.Join(t.TempDir(), "missing")
+			id := client.nextID()
+			typ, data, err := client.sendPacket(nil, &sshFxpLstatPacket{ID: id, Path: name})
+			require.NoError(t, err)
+			require.Equal(t, uint8(sshFxpStatus), typ)
+			var status *StatusError
+			require.True(t, errors.As(unmarshalStatus(id, data), &status))
+			assert.Equal(t, uint32(sshFxNoSuchFile), status.Code)
+			assert.Equal(t, tt.lang, status.lang)
+			if tt.verbose {
+				assert.Contains(t, status.msg, "No such file: ")
+				assert.Contains(t, status.msg, name)
+			} else {
+				assert.Equal(t, "No such file", status.msg)
+			}
+		})
+	}
+}
+
+func TestRequestServerStatusText(t *testing.T) {
+	limited := func(*Request) error { return ErrRequestLimited }
+	p := clientRequestServerPair(t, WithRequestLimiter(limited))
+	defer p.Close()
+
+	_, err := p.cli.Stat("/foo")
+	assert.True(t, IsRetryable(err), "the message of a limited request is kept")
+}
+
+func TestStatusTextTranslate(t *testing.T) {
+	errConflict := errors.New("version conflict")
+	text := statusText{translate: func(err error) (uint32, string) {
+		switch {
+		case errors.Is(err, errConflict):
+			return sshFxFileAlreadyExists, ""
+		case errors.Is(err, ErrQuotaExceeded):
+			return sshFxQuotaExceeded, "Bucket is full"
+		}
+		return sshFxOk, ""
+	}}
+
+	for _, tt := range []struct {
+		err  error
+		code uint32
+		msg  string
+	}{
+		{errConflict, sshFxFileAlreadyExists, "File already exists"},
+		{ErrQuotaExceeded, sshFxQuotaExceeded, "Bucket is full"},
+		{ErrSSHFxPermissionDenied, sshFxPermissionDenied, "Permission denied"},
+	} {
+		m := text.apply(statusFromError(1, tt.err))
+		if assert.IsType(t, &sshFxpStatusPacket{}, m, tt.err) {
+			status := m.(*sshFxpStatusPacket)
+			assert.Equal(t, tt.code, status.Code, tt.err)
+			assert.Equal(t, tt.msg, status.msg, tt.err)
+			assert.Nil(t, status.err)
+		}
+	}
+
+	// successes are left alone
+	m := text.apply(statusFromError(1, nil))
+	assert.Equal(t, uint32(sshFxOk), m.(*sshFxpStatusPacket).Code)
+}
+
+func TestServerErrorTranslator(t *testing.T) {
+	translate := func(err error) (uint32, string) {
+		if errors.Is(err, fs.ErrNotExist) {
+			return sshFxNoSuchPath, "Not in this bucket"
+		}
+		return sshFxOk, ""
+	}
+
+	client, server := clientServerPair(t, WithErrorTranslator(translate))
+	defer client.Close()
+	defer server.Close()
+
+	_, err := client.Stat(filepath.Join(t.TempDir(), "missing"))
+	var status *StatusError
+	require.True(t, errors.As(err, &status), "got %v", err)
+	assert.Equal(t, uint32(sshFxNoSuchPath), status.Code)
+	assert.Equal(t, "Not in this bucket", status.msg)
+
+	_, err = NewServer(nil, apis.NewAVFS(), WithErrorTranslator(nil))
+	assert.Error(t, err)
+}
+
+func TestRequestServerErrorTranslator(t *testing.T) {
+	p := clientRequestServerPair(t, WithRSErrorTranslator(func(err error) (uint32, string) {
+		return sshFxNoSuchPath, ""
+	}))
+	defer p.Close()
+
+	_, err := p.cli.Stat("/foo")
+	var status *StatusError
+	require.True(t, errors.As(err, &status), "got %v", err)
+	assert.Equal(t, uint32(sshFxNoSuchPath), status.Code)
+	assert.Equal(t, "No such path", status.msg)
+}
@@ -0,0 +1,80 @@
+package sftp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxStatVFSCacheEntries bounds the paths a statVFSCache remembers at once,
+// for clients asking about every file of a large tree.
+const maxStatVFSCacheEntries = 1024
+
+// WithStatVFSCache has the Client answer StatVFS for a path from cache, for
+// ttl after the server answered it, instead of asking the server again. This
+// serves monitoring agents calling StatVFS for every file they sync, while
+// the answer rarely changes. The free space it reports may be as old as
+// ttl. Errors are not cached.
+func WithStatVFSCache(ttl time.Duration) ClientOption {
+	return func(c *Client) error {
+		if ttl <= 0 {
+			return fmt.Errorf("sftp: statvfs cache ttl %v is not positive", ttl)
+		}
+		c.statVFSCache = &statVFSCache{
+			ttl:     ttl,
+			entries: make(map[string]cachedStatVFS),
+		}
+		return nil
+	}
+}
+
+// statVFSCache holds the answers to StatVFS, see WithStatVFSCache.
+type statVFSCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedStatVFS
+}
+
+type cachedStatVFS struct {
+	stat    StatVFS
+	expires time.Time
+}
+
+// get returns the cached statistics of path, or those statVFS returns,
+// caching them. Callers get copies they may change.
+func (c *statVFSCache) get(path string, statVFS func(string) (*StatVFS, error)) (*StatVFS, error) {
+	c.mu.Lock()
+	e, ok := c.entries[path]
+	c.mu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		stat := e.stat
+		return &stat, nil
+	}
+
+	stat, err := statVFS(path)
+	if err != nil {
+		return nil, err
+	}
+	c.store(path, *stat)
+	return stat, nil
+}
+
+func (c *statVFSCache) store(path string, stat StatVFS) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= maxStatVFSCacheEntries {
+		for p, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, p)
+			}
+		}
+		if len(c.entries) >= maxStatVFSCacheEntries {
+			// all fresh, start over rather than grow without bounds
+			c.entries = make(map[string]cachedStatVFS)
+		}
+	}
+	c.entries[path] = cachedStatVFS{stat: stat, expires: now.Add(c.ttl)}
+}
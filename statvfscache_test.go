@@ -0,0 +1,84 @@
+package sftp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// statVFSCountingFs counts the statvfs requests reaching the file system.
+type statVFSCountingFs struct {
+	apis.FullFs
+	calls int32
+}
+
+func (fs *statVFSCountingFs) StatVFS(name string) (*apis.StatVFS, error) {
+	atomic.AddInt32(&fs.calls, 1)
+	if name == "/missing" {
+		return nil, os.ErrNotExist
+	}
+	return &apis.StatVFS{Bsize: 4096, Frsize: 4096, Blocks: 100, Bfree: 50, Bavail: 40}, nil
+}
+
+func TestClientStatVFSCache(t *testing.T) {
+	fs := &statVFSCountingFs{FullFs: apis.NewAVFS()}
+	client, server := clientServerPairFS(t, fs, nil, WithStatVFSCache(time.Hour))
+	defer client.Close()
+	defer server.Close()
+
+	st, err := client.StatVFS("/a")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(50), st.Bfree)
+	st.Bfree = 0 // callers get copies
+
+	st, err = client.StatVFS("/a")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(50), st.Bfree)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fs.calls))
+
+	_, err = client.StatVFS("/b")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fs.calls))
+
+	// errors are not cached
+	for i := 0; i < 2; i++ {
+		_, err = client.StatVFS("/missing")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(&fs.calls))
+
+	_, w := io.Pipe()
+	_, err = NewClientPipe(nil, w, WithStatVFSCache(0))
+	assert.Error(t, err)
+}
+
+func TestStatVFSCacheExpiry(t *testing.T) {
+	c := &statVFSCache{ttl: time.Millisecond, entries: make(map[string]cachedStatVFS)}
+	var calls int
+	statVFS := func(string) (*StatVFS, error) {
+		calls++
+		return &StatVFS{}, nil
+	}
+
+	_, err := c.get("/", statVFS)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, err = c.get("/", statVFS)
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	// bounded, even while everything is fresh
+	c.ttl = time.Hour
+	for i := 0; i < maxStatVFSCacheEntries+10; i++ {
+		c.store(fmt.Sprint("/", i), StatVFS{})
+	}
+	assert.LessOrEqual(t, len(c.entries), maxStatVFSCacheEntries)
+}
@@ -0,0 +1,590 @@
+// Package sync mirrors a directory tree one way between an apis.Fs and the
+// server of a sftp.Client: it plans what to create, update and delete for
+// the destination to match the source, and runs the plan with several
+// transfers at once.
+//
+//	report, err := sync.Run(ctx, sync.Config{
+//		Local:      apis.NewOS(),
+//		LocalRoot:  "/var/www",
+//		Remote:     client,
+//		RemoteRoot: "/srv/www",
+//		Direction:  sync.Upload,
+//		Delete:     true,
+//	})
+//	if err == nil && !report.OK() {
+//		log.Fatal(report.Failed)
+//	}
+//
+// Files are compared by size and modification time, as far as the server
+// keeps it, or by their contents with Checksum. Directories and regular files are mirrored,
+// with their permissions and the modification times of the files; symbolic
+// links and other files are skipped and reported.
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	gosync "sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/pkg/sftp/apis"
+)
+
+// Direction is which way Run mirrors.
+type Direction int
+
+// The directions to mirror.
+const (
+	Upload   Direction = iota // the local tree to the server
+	Download                  // the tree on the server to the local one
+)
+
+// Action is what an Op does to the destination.
+type Action string
+
+// The actions of a plan.
+const (
+	Mkdir  Action = "mkdir"  // create a directory
+	Create Action = "create" // copy a file which is missing
+	Update Action = "update" // copy a file which differs
+	Delete Action = "delete" // remove a file or a directory tree
+)
+
+// Op is an operation of a plan, on the file Path of the destination,
+// relative to its root, with "." for the root.
+type Op struct {
+	Action Action
+	Path   string
+	Size   int64 // of the file to copy
+}
+
+func (op Op) String() string {
+	return string(op.Action) + " " + op.Path
+}
+
+// Config configures a mirror run.
+type Config struct {
+	// Local is the local file system and LocalRoot the directory in it.
+	Local     apis.Fs
+	LocalRoot string
+
+	// Remote is the client of the server and RemoteRoot the directory on it.
+	Remote     *sftp.Client
+	RemoteRoot string
+
+	// Direction is which way to mirror.
+	Direction Direction
+
+	// Delete removes the files of the destination which are missing from
+	// the source. Files of the destination which are in the way of those
+	// of the source, like a file where the source has a directory, are
+	// removed either way.
+	Delete bool
+
+	// Checksum compares files of the same size by their SHA-256 digests,
+	// rather than by their modification times. The server computes those
+	// of its files with the check-file extension, or the files are read if
+	// it does not support it.
+	Checksum bool
+
+	// ModTimes compares the modification times of the local and remote
+	// files. If it is the zero value, Run calibrates one for the server with
+	// Client.CalibrateModTimes in RemoteRoot, and falls back to the zero
+	// value if it cannot write there.
+	ModTimes sftp.ModTimeComparer
+
+	// DryRun only plans, and leaves the destination as it is.
+	DryRun bool
+
+	// Concurrency is how many files are copied at once, 4 if 0.
+	Concurrency int
+}
+
+// Report is the result of a mirror run.
+type Report struct {
+	DryRun bool
+
+	// Ops is the plan, in the order the operations were started.
+	Ops []Op
+
+	// Done counts the operations which succeeded, by action.
+	Done map[Action]int
+
+	// Failed are the operations which did not succeed.
+	Failed []Failure
+
+	// Unchanged is how many files the source and destination agreed on,
+	// and Skipped are the files of the source which are neither
+	// directories nor regular files.
+	Unchanged int
+	Skipped   []string
+
+	// Bytes is how much was copied.
+	Bytes int64
+}
+
+// OK reports whether every operation succeeded.
+func (r *Report) OK() bool {
+	return len(r.Failed) == 0
+}
+
+// Failure is an operation which failed.
+type Failure struct {
+	Op  Op
+	Err error
+}
+
+func (f Failure) String() string {
+	return f.Op.String() + ": " + f.Err.Error()
+}
+
+// Run mirrors the tree of the source of cfg onto its destination, or only
+// plans it with DryRun. The source root has to be a directory, the
+// destination root is created if it is missing.
+//
+// Run returns an error only if it could not plan, or ctx was done, the
+// errors of single operations are reported as Failed.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if cfg.Local == nil || cfg.Remote == nil {
+		return nil, errors.New("sync: Local and Remote are required")
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 4
+	}
+	if cfg.ModTimes == (sftp.ModTimeComparer{}) && !cfg.Checksum {
+		if m, err := cfg.Remote.CalibrateModTimes(cfg.RemoteRoot); err == nil {
+			cfg.ModTimes = m
+		}
+	}
+
+	local := &localTree{fsys: cfg.Local, root: cfg.LocalRoot}
+	remote := &remoteTree{c: cfg.Remote, root: cfg.RemoteRoot}
+	m := &mirror{
+		cfg:    cfg,
+		src:    tree(local),
+		dst:    tree(remote),
+		report: &Report{DryRun: cfg.DryRun, Done: make(map[Action]int)},
+	}
+	if cfg.Direction == Download {
+		m.src, m.dst = remote, local
+	}
+
+	if err := m.planRoot(ctx); err != nil {
+		return nil, err
+	}
+	if cfg.DryRun {
+		return m.report, nil
+	}
+	return m.report, m.run(ctx)
+}
+
+// tree is a side of a mirror, with the names relative to its root.
+type tree interface {
+	lstat(name string) (fs.FileInfo, error)
+	readDir(name string) ([]fs.FileInfo, error)
+	open(name string) (io.ReadCloser, error)
+	create(name string) (io.WriteCloser, error)
+	mkdir(name string, perm fs.FileMode) error
+	chmod(name string, perm fs.FileMode) error
+	chtimes(name string, mtime time.Time) error
+	removeAll(name string) error
+	checksum(name string) ([]byte, error)
+}
+
+// mirror is a mirror run.
+type mirror struct {
+	cfg      Config
+	src, dst tree
+	mu       gosync.Mutex // guards report while the copies run
+	report   *Report
+	perms    map[string]fs.FileMode // of the directories to create
+	infos    map[string]fs.FileInfo // of the files to copy
+}
+
+// planRoot plans the mirror of the roots.
+func (m *mirror) planRoot(ctx context.Context) error {
+	m.perms = make(map[string]fs.FileMode)
+	m.infos = make(map[string]fs.FileInfo)
+
+	src, err := m.src.lstat(".")
+	if err != nil {
+		return fmt.Errorf("sync: %w", err)
+	}
+	if !src.IsDir() {
+		return errors.New("sync: the source root is not a directory")
+	}
+
+	dst, err := m.dst.lstat(".")
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		m.add(Op{Action: Mkdir, Path: "."})
+		m.perms["."] = src.Mode().Perm()
+		return m.plan(ctx, ".", false)
+	case err != nil:
+		return fmt.Errorf("sync: %w", err)
+	case !dst.IsDir():
+		return errors.New("sync: the destination root is not a directory")
+	}
+	return m.plan(ctx, ".", true)
+}
+
+// plan plans the mirror of the directory dir of the source, which the
+// destination has too if exists.
+func (m *mirror) plan(ctx context.Context, dir string, exists bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	srcInfos, err := m.src.readDir(dir)
+	if err != nil {
+		return fmt.Errorf("sync: %w", err)
+	}
+	dstInfos := make(map[string]fs.FileInfo)
+	if exists {
+		infos, err := m.dst.readDir(dir)
+		if err != nil {
+			return fmt.Errorf("sync: %w", err)
+		}
+		for _, info := range infos {
+			dstInfos[info.Name()] = info
+		}
+	}
+
+	sort.Slice(srcInfos, func(i, j int) bool { return srcInfos[i].Name() < srcInfos[j].Name() })
+	for _, s := range srcInfos {
+		name := path.Join(dir, s.Name())
+		d, ok := dstInfos[s.Name()]
+		delete(dstInfos, s.Name())
+
+		switch {
+		case s.IsDir():
+			if ok && !d.IsDir() {
+				m.add(Op{Action: Delete, Path: name})
+				ok = false
+			}
+			if !ok {
+				m.add(Op{Action: Mkdir, Path: name})
+				m.perms[name] = s.Mode().Perm()
+			}
+			if err := m.plan(ctx, name, ok); err != nil {
+				return err
+			}
+
+		case s.Mode().IsRegular():
+			if ok && !d.Mode().IsRegular() {
+				m.add(Op{Action: Delete, Path: name})
+				ok = false
+			}
+			action := Create
+			if ok {
+				same, err := m.same(name, s, d)
+				if err != nil {
+					return fmt.Errorf("sync: %w", err)
+				}
+				if same {
+					m.report.Unchanged++
+					continue
+				}
+				action = Update
+			}
+			m.add(Op{Action: action, Path: name, Size: s.Size()})
+			m.infos[name] = s
+
+		default:
+			m.report.Skipped = append(m.report.Skipped, name)
+		}
+	}
+
+	if m.cfg.Delete {
+		var extra []string
+		for name := range dstInfos {
+			extra = append(extra, name)
+		}
+		sort.Strings(extra)
+		for _, name := range extra {
+			m.add(Op{Action: Delete, Path: path.Join(dir, name)})
+		}
+	}
+	return nil
+}
+
+// same reports whether the files name of the source and the destination,
+// with the attributes s and d, agree.
+func (m *mirror) same(name string, s, d fs.FileInfo) (bool, error) {
+	if s.Size() != d.Size() {
+		return false, nil
+	}
+	if !m.cfg.Checksum {
+		local, remote := s.ModTime(), d.ModTime()
+		if m.cfg.Direction == Download {
+			local, remote = remote, local
+		}
+		return m.cfg.ModTimes.Equal(local, remote), nil
+	}
+
+	a, err := m.src.checksum(name)
+	if err != nil {
+		return false, err
+	}
+	b, err := m.dst.checksum(name)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(a, b), nil
+}
+
+func (m *mirror) add(op Op) {
+	m.report.Ops = append(m.report.Ops, op)
+}
+
+// run runs the plan: the deletes and mkdirs one after the other, as they
+// depend on each other, and then the copies, several at once.
+func (m *mirror) run(ctx context.Context) error {
+	var copies []Op
+	for _, op := range m.report.Ops {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch op.Action {
+		case Delete:
+			m.done(op, m.dst.removeAll(op.Path), 0)
+		case Mkdir:
+			err := m.dst.mkdir(op.Path, m.perms[op.Path])
+			if err == nil {
+				// the umask may have taken permissions away
+				err = m.dst.chmod(op.Path, m.perms[op.Path])
+			}
+			m.done(op, err, 0)
+		default:
+			copies = append(copies, op)
+		}
+	}
+
+	ops := make(chan Op)
+	var wg gosync.WaitGroup
+	for i := 0; i < m.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for op := range ops {
+				n, err := m.copy(op.Path)
+				m.done(op, err, n)
+			}
+		}()
+	}
+
+	var err error
+	for _, op := range copies {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		ops <- op
+	}
+	close(ops)
+	wg.Wait()
+	return err
+}
+
+// done records the outcome of op, which copied n bytes.
+func (m *mirror) done(op Op, err error, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.report.Bytes += n
+	if err != nil {
+		m.report.Failed = append(m.report.Failed, Failure{Op: op, Err: err})
+		return
+	}
+	m.report.Done[op.Action]++
+}
+
+// copy copies the file name from the source to the destination, with its
+// permissions and modification time.
+func (m *mirror) copy(name string) (int64, error) {
+	info := m.infos[name]
+
+	r, err := m.src.open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	w, err := m.dst.create(name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		w.Close()
+		return n, err
+	}
+	if err := w.Close(); err != nil {
+		return n, err
+	}
+
+	if err := m.dst.chmod(name, info.Mode().Perm()); err != nil {
+		return n, err
+	}
+	return n, m.dst.chtimes(name, info.ModTime())
+}
+
+// localTree is the tree below root in fsys.
+type localTree struct {
+	fsys apis.Fs
+	root string
+}
+
+func (t *localTree) path(name string) string {
+	return path.Join(t.root, name)
+}
+
+func (t *localTree) lstat(name string) (fs.FileInfo, error) {
+	return apis.Lstat(t.fsys, t.path(name))
+}
+
+func (t *localTree) readDir(name string) ([]fs.FileInfo, error) {
+	entries, err := t.fsys.ReadDir(t.path(name))
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// removed since listing it
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (t *localTree) open(name string) (io.ReadCloser, error) {
+	return t.fsys.Open(t.path(name))
+}
+
+func (t *localTree) create(name string) (io.WriteCloser, error) {
+	return t.fsys.OpenFile(t.path(name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+}
+
+func (t *localTree) mkdir(name string, perm fs.FileMode) error {
+	return apis.Mkdir(t.fsys, t.path(name), perm)
+}
+
+func (t *localTree) chmod(name string, perm fs.FileMode) error {
+	return apis.Chmod(t.fsys, t.path(name), perm)
+}
+
+func (t *localTree) chtimes(name string, mtime time.Time) error {
+	return apis.Chtimes(t.fsys, t.path(name), mtime, mtime)
+}
+
+func (t *localTree) removeAll(name string) error {
+	return apis.RemoveAll(t.fsys, t.path(name))
+}
+
+func (t *localTree) checksum(name string) ([]byte, error) {
+	f, err := t.fsys.Open(t.path(name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return digest(f)
+}
+
+// remoteTree is the tree below root on the server of c.
+type remoteTree struct {
+	c    *sftp.Client
+	root string
+}
+
+func (t *remoteTree) path(name string) string {
+	return path.Join(t.root, name)
+}
+
+func (t *remoteTree) lstat(name string) (fs.FileInfo, error) {
+	return t.c.Lstat(t.path(name))
+}
+
+func (t *remoteTree) readDir(name string) ([]fs.FileInfo, error) {
+	return t.c.ReadDir(t.path(name))
+}
+
+func (t *remoteTree) open(name string) (io.ReadCloser, error) {
+	return t.c.Open(t.path(name))
+}
+
+func (t *remoteTree) create(name string) (io.WriteCloser, error) {
+	return t.c.OpenFile(t.path(name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+}
+
+func (t *remoteTree) mkdir(name string, perm fs.FileMode) error {
+	return t.c.Mkdir(t.path(name))
+}
+
+func (t *remoteTree) chmod(name string, perm fs.FileMode) error {
+	return t.c.Chmod(t.path(name), perm)
+}
+
+func (t *remoteTree) chtimes(name string, mtime time.Time) error {
+	return t.c.Chtimes(t.path(name), mtime, mtime)
+}
+
+// removeAll removes name, and everything below it if it is a directory,
+// as the client has no RemoveAll.
+func (t *remoteTree) removeAll(name string) error {
+	info, err := t.lstat(name)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return t.c.Remove(t.path(name))
+	}
+	infos, err := t.readDir(name)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if err := t.removeAll(path.Join(name, info.Name())); err != nil {
+			return err
+		}
+	}
+	return t.c.RemoveDirectory(t.path(name))
+}
+
+func (t *remoteTree) checksum(name string) ([]byte, error) {
+	res, err := t.c.CheckFile(t.path(name), []string{"sha256"}, 0, 0, 0)
+	if err == nil && res.Algorithm == "sha256" && len(res.Hashes) == 1 {
+		return res.Hashes[0], nil
+	}
+	if err != nil && !errors.Is(err, sftp.ErrOpUnsupported) {
+		return nil, err
+	}
+
+	// the server cannot hash the file, so read it
+	f, err := t.c.Open(t.path(name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return digest(f)
+}
+
+// digest returns the SHA-256 digest of what r reads.
+func digest(r io.Reader) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
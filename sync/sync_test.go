@@ -0,0 +1,189 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pkg/sftp"
+	"github.com/pkg/sftp/apis"
+)
+
+// client returns a client of a Server serving the host file system.
+func client(t *testing.T) *sftp.Client {
+	sc, cc := net.Pipe()
+	server, err := sftp.NewServer(sc, apis.NewOS())
+	require.NoError(t, err)
+	go server.Serve()
+	c, err := sftp.NewClientPipe(cc, cc)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		server.Close()
+		c.Close()
+	})
+	return c
+}
+
+func writeFile(t *testing.T, name, content string, mtime time.Time) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+	require.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+	require.NoError(t, os.Chtimes(name, mtime, mtime))
+}
+
+func readFile(t *testing.T, name string) string {
+	b, err := os.ReadFile(name)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestRunUpload(t *testing.T) {
+	local, remote := t.TempDir(), filepath.Join(t.TempDir(), "mirror")
+	old, mtime := time.Unix(1400000000, 0), time.Unix(1500000000, 0)
+	writeFile(t, filepath.Join(local, "a"), "new", mtime)
+	writeFile(t, filepath.Join(local, "dir", "b"), "bbb", mtime)
+	writeFile(t, filepath.Join(local, "same"), "same", mtime)
+	require.NoError(t, os.Symlink("a", filepath.Join(local, "link")))
+
+	cfg := Config{
+		Local:      apis.NewOS(),
+		LocalRoot:  local,
+		Remote:     client(t),
+		RemoteRoot: remote,
+		Delete:     true,
+	}
+	report, err := Run(context.Background(), cfg)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Failed)
+	assert.Equal(t, []Op{
+		{Action: Mkdir, Path: "."},
+		{Action: Create, Path: "a", Size: 3},
+		{Action: Mkdir, Path: "dir"},
+		{Action: Create, Path: "dir/b", Size: 3},
+		{Action: Create, Path: "same", Size: 4},
+	}, report.Ops)
+	assert.Equal(t, []string{"link"}, report.Skipped)
+	assert.Equal(t, int64(10), report.Bytes)
+	assert.Equal(t, "bbb", readFile(t, filepath.Join(remote, "dir", "b")))
+	fi, err := os.Stat(filepath.Join(remote, "a"))
+	require.NoError(t, err)
+	assert.True(t, mtime.Equal(fi.ModTime()), "got %v", fi.ModTime())
+
+	// change the local tree and the remote one
+	writeFile(t, filepath.Join(local, "a"), "newer", mtime)
+	writeFile(t, filepath.Join(remote, "extra", "c"), "c", old)
+	require.NoError(t, os.Remove(filepath.Join(remote, "dir", "b")))
+	require.NoError(t, os.Mkdir(filepath.Join(remote, "dir", "b"), 0o755))
+
+	cfg.DryRun = true
+	report, err = Run(context.Background(), cfg)
+	require.NoError(t, err)
+	want := []Op{
+		{Action: Update, Path: "a", Size: 5},
+		{Action: Delete, Path: "dir/b"},
+		{Action: Create, Path: "dir/b", Size: 3},
+		{Action: Delete, Path: "extra"},
+	}
+	assert.Equal(t, want, report.Ops)
+	assert.Equal(t, 1, report.Unchanged)
+	_, err = os.Stat(filepath.Join(remote, "extra"))
+	assert.NoError(t, err, "a dry run changes nothing")
+
+	cfg.DryRun = false
+	report, err = Run(context.Background(), cfg)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Failed)
+	assert.Equal(t, want, report.Ops)
+	assert.Equal(t, map[Action]int{Update: 1, Create: 1, Delete: 2}, report.Done)
+	assert.Equal(t, "newer", readFile(t, filepath.Join(remote, "a")))
+	assert.Equal(t, "bbb", readFile(t, filepath.Join(remote, "dir", "b")))
+	_, err = os.Stat(filepath.Join(remote, "extra"))
+	assert.True(t, os.IsNotExist(err))
+
+	report, err = Run(context.Background(), cfg)
+	require.NoError(t, err)
+	assert.Empty(t, report.Ops, "mirrored")
+}
+
+func TestRunDownloadChecksum(t *testing.T) {
+	local, remote := t.TempDir(), t.TempDir()
+	mtime := time.Unix(1500000000, 0)
+	writeFile(t, filepath.Join(remote, "changed"), "abc", mtime)
+	writeFile(t, filepath.Join(remote, "touched"), "xyz", mtime)
+	writeFile(t, filepath.Join(local, "changed"), "abd", mtime)
+	writeFile(t, filepath.Join(local, "touched"), "xyz", time.Unix(1600000000, 0))
+	writeFile(t, filepath.Join(local, "kept"), "kept", mtime)
+
+	report, err := Run(context.Background(), Config{
+		Local:      apis.NewOS(),
+		LocalRoot:  local,
+		Remote:     client(t),
+		RemoteRoot: remote,
+		Direction:  Download,
+		Checksum:   true,
+	})
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Failed)
+	assert.Equal(t, []Op{{Action: Update, Path: "changed", Size: 3}}, report.Ops)
+	assert.Equal(t, 1, report.Unchanged)
+	assert.Equal(t, "abc", readFile(t, filepath.Join(local, "changed")))
+	assert.Equal(t, "kept", readFile(t, filepath.Join(local, "kept")), "nothing is deleted without Delete")
+}
+
+func TestRunModTimes(t *testing.T) {
+	local, remote := t.TempDir(), t.TempDir()
+	mtime := time.Unix(1500000000, 0)
+	writeFile(t, filepath.Join(local, "shifted"), "abc", mtime)
+	writeFile(t, filepath.Join(remote, "shifted"), "abc", mtime.Add(-time.Hour))
+	writeFile(t, filepath.Join(local, "changed"), "abc", mtime.Add(time.Minute))
+	writeFile(t, filepath.Join(remote, "changed"), "abc", mtime.Add(-time.Hour))
+
+	// a server reporting its times an hour behind
+	report, err := Run(context.Background(), Config{
+		Local:      apis.NewOS(),
+		LocalRoot:  local,
+		Remote:     client(t),
+		RemoteRoot: remote,
+		DryRun:     true,
+		ModTimes:   sftp.ModTimeComparer{Offset: time.Hour},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Op{{Action: Update, Path: "changed", Size: 3}}, report.Ops)
+	assert.Equal(t, 1, report.Unchanged)
+
+	// calibrated, the server reports the true times
+	report, err = Run(context.Background(), Config{
+		Local:      apis.NewOS(),
+		LocalRoot:  local,
+		Remote:     client(t),
+		RemoteRoot: remote,
+		DryRun:     true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Op{
+		{Action: Update, Path: "changed", Size: 3},
+		{Action: Update, Path: "shifted", Size: 3},
+	}, report.Ops)
+}
+
+func TestRunErrors(t *testing.T) {
+	c := client(t)
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "file"), "", time.Now())
+
+	_, err := Run(context.Background(), Config{Local: apis.NewOS(), LocalRoot: filepath.Join(dir, "file"), Remote: c, RemoteRoot: dir})
+	assert.Error(t, err, "the source is no directory")
+	_, err = Run(context.Background(), Config{Local: apis.NewOS(), LocalRoot: dir, Remote: c, RemoteRoot: filepath.Join(dir, "file")})
+	assert.Error(t, err, "the destination is no directory")
+	_, err = Run(context.Background(), Config{Local: apis.NewOS(), LocalRoot: filepath.Join(dir, "missing"), Remote: c, RemoteRoot: dir})
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	_, err = Run(context.Background(), Config{Remote: c})
+	assert.Error(t, err)
+}
@@ -0,0 +1,332 @@
+package sftp
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// tarOptions holds the options of Client.TarTo.
+type tarOptions struct {
+	filter         func(name string, info iofs.FileInfo) bool
+	followSymlinks bool
+}
+
+// A TarOption configures Client.TarTo.
+type TarOption func(*tarOptions)
+
+// TarFilter makes TarTo archive only the files for which keep returns true,
+// given their remote name and attributes. The tree below a directory which
+// is not kept is not archived either.
+func TarFilter(keep func(name string, info iofs.FileInfo) bool) TarOption {
+	return func(o *tarOptions) {
+		o.filter = keep
+	}
+}
+
+// TarFollowSymlinks makes TarTo archive what symbolic links point to rather
+// than the links, see WalkFollowSymlinks.
+func TarFollowSymlinks() TarOption {
+	return func(o *tarOptions) {
+		o.followSymlinks = true
+	}
+}
+
+// errFileGrew stops the copy of a file which grew after TarTo wrote its header.
+var errFileGrew = errors.New("file grew while archiving")
+
+// sizedWriter writes the first n bytes written to it to w, and fails with
+// errFileGrew on more.
+type sizedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (sw *sizedWriter) Write(b []byte) (int, error) {
+	if int64(len(b)) > sw.n {
+		n, err := sw.w.Write(b[:sw.n])
+		sw.n -= int64(n)
+		if err == nil {
+			err = errFileGrew
+		}
+		return n, err
+	}
+	n, err := sw.w.Write(b)
+	sw.n -= int64(n)
+	return n, err
+}
+
+// TarTo writes the file or directory tree at root to w as a tar archive,
+// streaming every file with File.WriteTo, so no local copy of the tree is
+// needed. The names in the archive are relative to root, or the base name
+// of root if it is not a directory. The directories, regular files and
+// symbolic links of the tree are archived with their permissions, times and
+// numeric owners; other files, like sockets, are skipped.
+//
+// A file which changes size while it is archived keeps the size it had when
+// the directory was listed, cut off or padded with zeros as tar(1) does.
+func (c *Client) TarTo(w io.Writer, root string, opts ...TarOption) (err error) {
+	defer pathError(&err, "tar", root)
+
+	var o tarOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var walkOpts []WalkDirOption
+	if o.followSymlinks {
+		walkOpts = append(walkOpts, WalkFollowSymlinks())
+	}
+
+	tw := tar.NewWriter(w)
+	err = c.WalkDir(root, func(name string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if o.filter != nil && !o.filter(name, info) {
+			if d.IsDir() {
+				return iofs.SkipDir
+			}
+			return nil
+		}
+
+		rel := path.Base(name)
+		if d.IsDir() || name != root {
+			rel = strings.TrimPrefix(strings.TrimPrefix(name, root), "/")
+		}
+		if rel == "" {
+			// the root directory itself
+			return nil
+		}
+		return c.tarFile(tw, name, rel, info)
+	}, walkOpts...)
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// tarFile writes the remote file name, with the attributes info, to tw
+// under the name rel.
+func (c *Client) tarFile(tw *tar.Writer, name, rel string, info iofs.FileInfo) error {
+	var link string
+	switch mode := info.Mode(); {
+	case mode.IsDir(), mode.IsRegular():
+	case mode&iofs.ModeSymlink != 0:
+		target, err := c.ReadLink(name)
+		if err != nil {
+			return err
+		}
+		link = target
+	default:
+		return nil
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = rel
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	if stat, ok := info.Sys().(*FileStat); ok {
+		hdr.Uid, hdr.Gid = int(stat.UID), int(stat.GID)
+		hdr.Uname, hdr.Gname = stat.Owner, stat.Group
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := c.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sw := &sizedWriter{w: tw, n: hdr.Size}
+	if _, err := f.WriteTo(sw); err != nil && !errors.Is(err, errFileGrew) {
+		return err
+	}
+	if sw.n > 0 {
+		// the file shrank
+		_, err := io.CopyN(tw, zeroReader{}, sw.n)
+		return err
+	}
+	return nil
+}
+
+// zeroReader reads zeros forever.
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+// UntarFrom extracts the tar archive read from r below the remote directory
+// root, creating it if need be, and streaming every file with File.ReadFrom,
+// so no local copy of the archive is needed. Directories, regular files,
+// symbolic links and hard links are extracted, with the permissions and
+// modification times of the archive; other entries are skipped. Existing
+// files are overwritten.
+//
+// Entries whose names lead out of root are refused, and so are symbolic
+// links pointing out of it. Like tar(1), UntarFrom creates the links after
+// all other entries, so nothing of the archive is extracted through them.
+func (c *Client) UntarFrom(r io.Reader, root string) (err error) {
+	defer pathError(&err, "untar", root)
+
+	if err := c.MkdirAll(root); err != nil {
+		return err
+	}
+
+	// the directories get their attributes last, since extracting into
+	// them changes their times and they may not be writable
+	var dirs []*tar.Header
+	// the links are created last, named relative to root
+	var links []*tar.Header
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		rel, err := untarName(hdr.Name)
+		if err != nil {
+			return err
+		}
+		name := path.Join(root, rel)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := c.MkdirAll(name); err != nil {
+				return err
+			}
+			hdr.Name = name
+			dirs = append(dirs, hdr)
+
+		case tar.TypeReg, tar.TypeRegA:
+			if err := c.MkdirAll(path.Dir(name)); err != nil {
+				return err
+			}
+			if err := c.untarFile(tr, name, hdr); err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink, tar.TypeLink:
+			if hdr.Typeflag == tar.TypeSymlink && !untarLinkInside(rel, hdr.Linkname) {
+				return fmt.Errorf("sftp: tar entry %q links out of the directory", hdr.Name)
+			}
+			hdr.Name = rel
+			links = append(links, hdr)
+		}
+	}
+
+	for _, hdr := range links {
+		if err := c.untarLink(root, hdr); err != nil {
+			return err
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := c.preserveRemote(dirs[i].Name, dirs[i].FileInfo(), PreserveMode|PreserveTimes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// untarFile writes the current entry of tr, with the header hdr, to the
+// remote file name.
+func (c *Client) untarFile(tr *tar.Reader, name string, hdr *tar.Header) error {
+	f, err := c.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	// a LimitedReader tells ReadFrom how much to expect
+	if _, err := f.ReadFrom(io.LimitReader(tr, hdr.Size)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return c.preserveRemote(name, hdr.FileInfo(), PreserveMode|PreserveTimes)
+}
+
+// untarLink creates the link entry hdr, named relative to root, refusing
+// to create it through a symbolic link created before.
+func (c *Client) untarLink(root string, hdr *tar.Header) error {
+	dir := root
+	for _, elem := range strings.Split(path.Dir(hdr.Name), "/") {
+		if elem == "." {
+			break
+		}
+		dir = path.Join(dir, elem)
+		fi, err := c.Lstat(dir)
+		if errors.Is(err, iofs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&iofs.ModeSymlink != 0 {
+			return fmt.Errorf("sftp: tar entry %q leads through a symbolic link", hdr.Name)
+		}
+	}
+
+	name := path.Join(root, hdr.Name)
+	if err := c.MkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	// replace what is there, like tar(1) does
+	_ = c.Remove(name)
+
+	if hdr.Typeflag == tar.TypeSymlink {
+		return c.Symlink(hdr.Linkname, name)
+	}
+	target, err := untarName(hdr.Linkname)
+	if err != nil {
+		return err
+	}
+	return c.Link(path.Join(root, target), name)
+}
+
+// untarLinkInside reports whether the symbolic link entry rel pointing to
+// target stays within the directory it is extracted to.
+func untarLinkInside(rel, target string) bool {
+	if path.IsAbs(target) {
+		return false
+	}
+	_, err := untarName(path.Join(path.Dir(rel), target))
+	return err == nil
+}
+
+// untarName returns the name of a tar entry relative to the directory it
+// is extracted to, refusing names which lead out of it.
+func untarName(name string) (string, error) {
+	rel := path.Clean(strings.TrimLeft(name, "/"))
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("sftp: tar entry %q leads out of the directory", name)
+	}
+	return rel, nil
+}
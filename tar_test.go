@@ -0,0 +1,166 @@
+package sftp
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientTarUntar(t *testing.T) {
+	skipIfWindows(t)
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	src := t.TempDir()
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	mtime := time.Unix(1500000000, 0)
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "sub", "skipped"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "big"), content, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "sub", "small"), []byte("foo"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "sub", "skipped", "file"), nil, 0o644))
+	require.NoError(t, os.Symlink("sub/small", filepath.Join(src, "link")))
+	require.NoError(t, os.Chtimes(filepath.Join(src, "big"), mtime, mtime))
+	require.NoError(t, os.Chmod(filepath.Join(src, "sub"), 0o750))
+
+	var buf bytes.Buffer
+	require.NoError(t, client.TarTo(&buf, src, TarFilter(func(name string, info os.FileInfo) bool {
+		return info.Name() != "skipped"
+	})))
+
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+		if hdr.Name == "link" {
+			assert.Equal(t, "sub/small", hdr.Linkname)
+		}
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"big", "link", "sub/", "sub/small"}, names)
+
+	dst := filepath.Join(t.TempDir(), "restored")
+	require.NoError(t, client.UntarFrom(bytes.NewReader(buf.Bytes()), dst))
+
+	b, err := os.ReadFile(filepath.Join(dst, "big"))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, b))
+	fi, err := os.Stat(filepath.Join(dst, "big"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+	assert.True(t, mtime.Equal(fi.ModTime()), "got %v", fi.ModTime())
+
+	fi, err = os.Stat(filepath.Join(dst, "sub"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o750), fi.Mode().Perm())
+	target, err := os.Readlink(filepath.Join(dst, "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "sub/small", target)
+	_, err = os.Stat(filepath.Join(dst, "sub", "skipped"))
+	assert.True(t, os.IsNotExist(err))
+
+	// a single file is archived under its base name
+	buf.Reset()
+	require.NoError(t, client.TarTo(&buf, filepath.Join(src, "sub", "small")))
+	hdr, err := tar.NewReader(&buf).Next()
+	require.NoError(t, err)
+	assert.Equal(t, "small", hdr.Name)
+	assert.Equal(t, int64(3), hdr.Size)
+}
+
+func TestClientUntarEscape(t *testing.T) {
+	skipIfWindows(t)
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0o644}))
+	require.NoError(t, tw.Close())
+
+	dir := t.TempDir()
+	err := client.UntarFrom(&buf, filepath.Join(dir, "root"))
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(dir, "evil"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestClientUntarSymlinkEscape(t *testing.T) {
+	skipIfWindows(t)
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	outside := t.TempDir()
+	for _, target := range []string{outside, "../..", "sub/../../x"} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: target}))
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "a/passwd", Typeflag: tar.TypeReg, Mode: 0o644}))
+		require.NoError(t, tw.Close())
+
+		err := client.UntarFrom(&buf, filepath.Join(t.TempDir(), "root"))
+		assert.Error(t, err, target)
+		_, err = os.Stat(filepath.Join(outside, "passwd"))
+		assert.True(t, os.IsNotExist(err), target)
+	}
+}
+
+func TestClientUntarLinksLast(t *testing.T) {
+	skipIfWindows(t)
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	// a link to a directory within root is fine, but later entries are
+	// not extracted through it, and neither are later links
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0o755}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "dir"}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "a/file", Typeflag: tar.TypeReg, Mode: 0o644}))
+	require.NoError(t, tw.Close())
+
+	root := filepath.Join(t.TempDir(), "root")
+	assert.Error(t, client.UntarFrom(&buf, root), "a is a directory by the time the link is created")
+	_, err := os.Stat(filepath.Join(root, "dir", "file"))
+	assert.True(t, os.IsNotExist(err))
+
+	buf.Reset()
+	tw = tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0o755}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "dir"}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "a/l", Typeflag: tar.TypeSymlink, Linkname: "../x"}))
+	require.NoError(t, tw.Close())
+
+	root = filepath.Join(t.TempDir(), "root")
+	assert.Error(t, client.UntarFrom(&buf, root))
+	_, err = os.Lstat(filepath.Join(root, "dir", "l"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSizedWriter(t *testing.T) {
+	var buf bytes.Buffer
+	sw := &sizedWriter{w: &buf, n: 5}
+	n, err := sw.Write([]byte("abc"))
+	assert.Equal(t, 3, n)
+	assert.NoError(t, err)
+	n, err = sw.Write([]byte("defg"))
+	assert.Equal(t, 2, n)
+	assert.Equal(t, errFileGrew, err)
+	assert.Equal(t, "abcde", buf.String())
+}
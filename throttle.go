@@ -0,0 +1,155 @@
+package sftp
+
+import (
+	"encoding"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// tokenBucket paces a stream of bytes to a rate. Bytes may be taken beyond
+// the tokens available, the takers then wait until the debt is paid off, so
+// that packets larger than the burst pass too.
+type tokenBucket struct {
+	rate  float64 // bytes per second
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	// a tenth of a second worth of bytes evens out the pauses
+	burst := float64(rate) / 10
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// wait takes n bytes from the bucket, and blocks until they are paid for.
+// A nil bucket does not limit anything.
+func (b *tokenBucket) wait(n int) {
+	if b == nil || n <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// throttle limits the payload bytes of a connection, see WithBandwidthLimit.
+type throttle struct {
+	read  *tokenBucket // the payload of SSH_FXP_DATA packets
+	write *tokenBucket // the payload of SSH_FXP_WRITE packets
+}
+
+func newThrottle(read, write int64) (*throttle, error) {
+	if read < 0 || write < 0 {
+		return nil, fmt.Errorf("sftp: bandwidth limit %d/%d is negative", read, write)
+	}
+	if read == 0 && write == 0 {
+		return nil, nil
+	}
+	return &throttle{
+		read:  newTokenBucket(read),
+		write: newTokenBucket(write),
+	}, nil
+}
+
+// send waits until the payload of m may be sent.
+func (t *throttle) send(m encoding.BinaryMarshaler) {
+	if t == nil {
+		return
+	}
+
+	if r, ok := m.(orderedResponse); ok {
+		m = r.responsePacket
+	}
+
+	switch p := m.(type) {
+	case *sshFxpWritePacket:
+		t.write.wait(len(p.Data))
+	case *sshFxpDataPacket:
+		t.read.wait(len(p.Data))
+	case *sshFxpFileDataPacket:
+		t.read.wait(int(p.Length))
+	}
+}
+
+// recv waits until the payload of the received packet b of type typ
+// may be passed on.
+func (t *throttle) recv(typ uint8, b []byte) {
+	if t == nil {
+		return
+	}
+
+	// the payload comes last, its length is good enough to pace it
+	switch typ {
+	case sshFxpWrite:
+		t.write.wait(len(b))
+	case sshFxpData:
+		t.read.wait(len(b))
+	}
+}
+
+// WithBandwidthLimit caps the file contents a Server sends to and receives
+// from its client to read and write bytes per second respectively, so that
+// operators can limit the bandwidth of every session without shaping the
+// traffic outside. Zero does not limit the direction.
+//
+// Only the payload of reads and writes counts towards the limits, headers
+// and other requests are not paced.
+func WithBandwidthLimit(read, write int64) ServerOption {
+	return func(s *Server) error {
+		t, err := newThrottle(read, write)
+		if err != nil {
+			return err
+		}
+		s.throttle = t
+		return nil
+	}
+}
+
+// WithRSBandwidthLimit caps the file contents a RequestServer sends and
+// receives, like WithBandwidthLimit does for a Server. Negative limits are
+// ignored.
+func WithRSBandwidthLimit(read, write int64) RequestServerOption {
+	return func(rs *RequestServer) {
+		if t, err := newThrottle(read, write); err == nil {
+			rs.throttle = t
+		}
+	}
+}
+
+// UseBandwidthLimit caps the file contents the Client reads and writes to
+// read and write bytes per second respectively. Zero does not limit the
+// direction.
+func UseBandwidthLimit(read, write int64) ClientOption {
+	return func(c *Client) error {
+		t, err := newThrottle(read, write)
+		if err != nil {
+			return err
+		}
+		c.throttle = t
+		return nil
+	}
+}
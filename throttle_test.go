@@ -0,0 +1,70 @@
+package sftp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	assert.Nil(t, newTokenBucket(0))
+	(*tokenBucket)(nil).wait(1 << 20) // does not limit
+
+	b := newTokenBucket(100 << 10)
+	start := time.Now()
+	b.wait(10 << 10) // the burst
+	assert.Less(t, int64(time.Since(start)), int64(50*time.Millisecond))
+	b.wait(20 << 10)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(150*time.Millisecond))
+
+	_, err := newThrottle(-1, 0)
+	assert.Error(t, err)
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	skipIfWindows(t)
+	const rate = 200 << 10
+
+	// the limit applies to the wire, compression would shrink the payload
+	client, server := clientServerPairWith(t, []ServerOption{WithBandwidthLimit(0, rate)}, UseBandwidthLimit(rate, 0), UseCompression())
+	defer client.Close()
+	defer server.Close()
+
+	want := bytes.Repeat([]byte("x"), 100<<10)
+	name := filepath.Join(t.TempDir(), "paced")
+
+	// the server paces the writes
+	start := time.Now()
+	f, err := client.Create(name)
+	require.NoError(t, err)
+	_, err = f.Write(want)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(300*time.Millisecond))
+
+	// the client paces the reads
+	start = time.Now()
+	f, err = client.Open(name)
+	require.NoError(t, err)
+	got, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(300*time.Millisecond))
+	assert.Equal(t, want, got)
+
+	// other requests are not held up
+	start = time.Now()
+	_, err = client.Stat(name)
+	require.NoError(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(100*time.Millisecond))
+
+	b, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, want, b)
+}
@@ -0,0 +1,104 @@
+package sftp
+
+import (
+	"time"
+)
+
+// timesExtension carries the sub-second parts of the times and the creation
+// time of a file as an extended attribute, which version 3 has no room for:
+//
+//	uint32   atime-nseconds
+//	uint32   mtime-nseconds
+//	uint64   createtime      0 if unknown
+//	uint32   createtime-nseconds
+//
+// Servers supporting it add it to the attributes of stat replies, and
+// clients to those of setstat requests changing the times.
+const timesExtension = "times@github.com/pkg/sftp"
+
+// timesExtended returns the extended attribute of the times extension for
+// stat, false if it would add nothing to the whole seconds of version 3.
+func timesExtended(stat *FileStat) (StatExtended, bool) {
+	if stat.AtimeNsec == 0 && stat.MtimeNsec == 0 && stat.CreateTime == 0 {
+		return StatExtended{}, false
+	}
+	b := make([]byte, 0, 4+4+8+4)
+	b = marshalUint32(b, stat.AtimeNsec)
+	b = marshalUint32(b, stat.MtimeNsec)
+	b = marshalUint64(b, uint64(stat.CreateTime))
+	b = marshalUint32(b, stat.CreateTimeNsec)
+	return StatExtended{ExtType: timesExtension, ExtData: string(b)}, true
+}
+
+// unmarshalTimes moves the times extension out of the extended attributes
+// of stat into its fields.
+func unmarshalTimes(stat *FileStat) {
+	for i, ext := range stat.Extended {
+		if ext.ExtType != timesExtension {
+			continue
+		}
+
+		b := []byte(ext.ExtData)
+		atime, b, err1 := unmarshalUint32Safe(b)
+		mtime, b, err2 := unmarshalUint32Safe(b)
+		ctime, b, err3 := unmarshalUint64Safe(b)
+		ctimeNsec, _, err4 := unmarshalUint32Safe(b)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+			return
+		}
+		stat.AtimeNsec, stat.MtimeNsec = atime, mtime
+		if ctime != 0 {
+			stat.CreateTime, stat.CreateTimeNsec = uint32(ctime), ctimeNsec
+		}
+
+		stat.Extended = append(stat.Extended[:i:i], stat.Extended[i+1:]...)
+		if len(stat.Extended) == 0 {
+			stat.Extended = nil
+		}
+		return
+	}
+}
+
+// acModTimes are the attributes set by Chtimes.
+type acModTimes struct {
+	Atime time.Time
+	Mtime time.Time
+}
+
+// acModTimesV3 converts the times set by Chtimes into version 3 attributes,
+// which keep the sub-second parts only if the server supports the times
+// extension.
+func (c *Client) acModTimesV3(a acModTimes) (uint32, interface{}) {
+	times := struct {
+		Atime uint32
+		Mtime uint32
+	}{uint32(a.Atime.Unix()), uint32(a.Mtime.Unix())}
+
+	ext, ok := timesExtended(&FileStat{
+		AtimeNsec: uint32(a.Atime.Nanosecond()),
+		MtimeNsec: uint32(a.Mtime.Nanosecond()),
+	})
+	if !ok {
+		return sshFileXferAttrACmodTime, times
+	}
+	if _, ok := c.HasExtension(timesExtension); !ok {
+		return sshFileXferAttrACmodTime, times
+	}
+
+	return sshFileXferAttrACmodTime | sshFileXferAttrExtended, struct {
+		Atime    uint32
+		Mtime    uint32
+		Count    uint32
+		Extended StatExtended
+	}{times.Atime, times.Mtime, 1, ext}
+}
+
+// setstatNsec returns the sub-second parts of the times set by the version 3
+// attributes b with flags, which are zero unless sent with the times extension.
+func setstatNsec(flags uint32, b []byte) (atime, mtime int64) {
+	if flags&sshFileXferAttrExtended == 0 {
+		return 0, 0
+	}
+	stat, _, _ := unmarshalFileStatSafe(flags, b)
+	return int64(stat.AtimeNsec), int64(stat.MtimeNsec)
+}
@@ -0,0 +1,97 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientChtimesNsec(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	_, ok := client.HasExtension(timesExtension)
+	require.True(t, ok, "server doesn't list times extension")
+
+	name := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(name, nil, 0o644))
+
+	atime := time.Unix(1000000000, 123456789)
+	mtime := time.Unix(1000000001, 987654321)
+	require.NoError(t, client.Chtimes(name, atime, mtime))
+
+	local, err := os.Stat(name)
+	require.NoError(t, err)
+	if local.ModTime().Nanosecond() == 0 {
+		t.Skip("the file system keeps whole seconds")
+	}
+	assert.True(t, mtime.Equal(local.ModTime()), "got %v", local.ModTime())
+
+	fi, err := client.StatNoCache(name)
+	require.NoError(t, err)
+	assert.True(t, mtime.Equal(fi.ModTime()), "got %v", fi.ModTime())
+	stat := fi.Sys().(*FileStat)
+	assert.Equal(t, uint32(987654321), stat.MtimeNsec)
+	assert.Nil(t, stat.Extended, "the times are no extended attribute")
+}
+
+func TestClientChtimesV3(t *testing.T) {
+	client, requests := fakeServer(t, 3, func(typ byte, id uint32) rawPacket {
+		return statusOK(id)
+	})
+	defer client.Close()
+
+	// without the extension the times are truncated
+	require.NoError(t, client.Chtimes("/file", time.Unix(1, 5), time.Unix(2, 6)))
+	req := <-requests
+	assert.Equal(t, byte(sshFxpSetstat), req.typ)
+	_, data := unmarshalUint32(req.data)
+	_, data = unmarshalString(data)
+	want := marshalUint32(nil, sshFileXferAttrACmodTime)
+	want = marshalUint32(marshalUint32(want, 1), 2)
+	assert.Equal(t, want, data)
+}
+
+func TestClientChtimesV6(t *testing.T) {
+	client, requests := fakeServer(t, 6, func(typ byte, id uint32) rawPacket {
+		return statusOK(id)
+	})
+	defer client.Close()
+
+	require.NoError(t, client.Chtimes("/file", time.Unix(1, 5), time.Unix(2, 6)))
+	req := <-requests
+	_, data := unmarshalUint32(req.data)
+	_, data = unmarshalString(data)
+	want := marshalUint32(nil, sshFileXferAttrAccessTime|sshFileXferAttrModifyTime|sshFileXferAttrSubsecondTimes)
+	want = append(want, sshFileXferTypeUnknown)
+	want = marshalUint32(marshalUint64(want, 1), 5)
+	want = marshalUint32(marshalUint64(want, 2), 6)
+	assert.Equal(t, want, data)
+}
+
+func TestUnmarshalTimes(t *testing.T) {
+	stat := &FileStat{AtimeNsec: 1, MtimeNsec: 2, CreateTime: 3, CreateTimeNsec: 4}
+	times, ok := timesExtended(stat)
+	require.True(t, ok)
+	_, ok = timesExtended(&FileStat{Atime: 1, Mtime: 2})
+	assert.False(t, ok, "whole seconds need no extension")
+
+	b := marshalUint32(nil, sshFileXferAttrExtended)
+	b = marshalUint32(b, 2)
+	b = marshalString(marshalString(b, "foo"), "bar")
+	b = marshalString(marshalString(b, times.ExtType), times.ExtData)
+
+	got, _ := unmarshalAttrs(b)
+	assert.Equal(t, &FileStat{
+		Extended:       []StatExtended{{"foo", "bar"}},
+		AtimeNsec:      1,
+		MtimeNsec:      2,
+		CreateTime:     3,
+		CreateTimeNsec: 4,
+	}, got)
+}
@@ -0,0 +1,259 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+const traceContextExtension = "trace-context@github.com/pkg/sftp"
+
+// Tracer is the integration point for distributed tracing, see the module
+// github.com/pkg/sftp/otel for OpenTelemetry. A Server or RequestServer
+// starts a span for every request it serves, a Client for the operations
+// Open, OpenFile, Create, ReadDir, Stat, Lstat, Rename, PosixRename, Remove,
+// RemoveDirectory and Mkdir, and for File.ReadAt, WriteAt, ReadFrom, WriteTo
+// and Close. These are children of a span for the whole session.
+//
+// The spans are named after the packet type of the request, like
+// "SSH_FXP_OPEN", or the operation, like "Client.Rename" or "File.ReadAt".
+// The session spans are named "sftp.Client", "sftp.Server" and
+// "sftp.RequestServer".
+type Tracer interface {
+	// Start starts a span named name, as a child of the span in ctx,
+	// and returns it with a context carrying it.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// End ends the span, with the details of the request or operation in ev.
+	// The event of a session has only its Latency and Err set.
+	End(ev LogEvent)
+}
+
+// TracePropagator is implemented by Tracers, which carry the trace of a
+// Client session over to the servers of this package, so that the spans of
+// the requests they serve join the trace of the client.
+type TracePropagator interface {
+	// Inject returns the trace context of the span in ctx as a string,
+	// like a W3C traceparent header.
+	Inject(ctx context.Context) string
+
+	// Extract returns ctx carrying the trace context of carrier,
+	// a string returned by Inject.
+	Extract(ctx context.Context, carrier string) context.Context
+}
+
+// UseTracer has the Client trace its session with tracer,
+// in a span which is a child of the span in ctx.
+func UseTracer(ctx context.Context, tracer Tracer) ClientOption {
+	return func(c *Client) error {
+		c.trace = &sessionTrace{tracer: tracer, ctx: ctx}
+		return nil
+	}
+}
+
+// WithTracer has the Server trace its session with tracer,
+// in a span which is a child of the span in ctx.
+func WithTracer(ctx context.Context, tracer Tracer) ServerOption {
+	return func(s *Server) error {
+		s.trace = &sessionTrace{tracer: tracer, ctx: ctx}
+		return nil
+	}
+}
+
+// WithRSTracer has the RequestServer trace its session with tracer,
+// in a span which is a child of the span in ctx.
+func WithRSTracer(ctx context.Context, tracer Tracer) RequestServerOption {
+	return func(rs *RequestServer) {
+		rs.trace = &sessionTrace{tracer: tracer, ctx: ctx}
+	}
+}
+
+// sessionTrace is the trace of a session.
+type sessionTrace struct {
+	tracer Tracer
+
+	mu    sync.RWMutex
+	ctx   context.Context // of the session span, once started
+	span  Span
+	start time.Time
+	ended bool
+}
+
+// begin starts the span of the session.
+func (t *sessionTrace) begin(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.ctx == nil {
+		t.ctx = context.Background()
+	}
+	t.ctx, t.span = t.tracer.Start(t.ctx, name)
+	t.start = time.Now()
+}
+
+// end ends the span of the session, once. A nil sessionTrace does nothing.
+func (t *sessionTrace) end(err error) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.span == nil || t.ended {
+		return
+	}
+	t.ended = true
+	t.span.End(LogEvent{Latency: time.Since(t.start), Err: err})
+}
+
+// sessionError returns the error a session of a server ended with,
+// nil if the client hung up.
+func sessionError(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// startSpan starts the span name in the session.
+// A nil sessionTrace returns a nil Span.
+func (t *sessionTrace) startSpan(name string) Span {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.RLock()
+	ctx := t.ctx
+	t.mu.RUnlock()
+
+	_, span := t.tracer.Start(ctx, name)
+	return span
+}
+
+// adopt continues the trace of a client, injected into carrier,
+// for the spans started afterwards.
+func (t *sessionTrace) adopt(carrier string) {
+	if t == nil {
+		return
+	}
+	propagator, ok := t.tracer.(TracePropagator)
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ctx = propagator.Extract(t.ctx, carrier)
+}
+
+// propagateTrace carries the trace of the Client session over to the server,
+// if both ends support it. The session goes on untraced by the server
+// if this fails.
+func (c *Client) propagateTrace() {
+	propagator, ok := c.trace.tracer.(TracePropagator)
+	if !ok {
+		return
+	}
+	if _, ok := c.HasExtension(traceContextExtension); !ok {
+		return
+	}
+
+	c.trace.mu.RLock()
+	carrier := propagator.Inject(c.trace.ctx)
+	c.trace.mu.RUnlock()
+
+	c.sendPacket(nil, &sshFxpTraceContextPacket{
+		ID:      c.nextID(),
+		Carrier: carrier,
+	})
+}
+
+// clientOp is the span of a Client operation.
+type clientOp struct {
+	span  Span
+	ev    LogEvent
+	start time.Time
+}
+
+// startOp starts the span of the operation name on path or handle.
+// It returns nil if the Client does not trace its session.
+func (c *clientConn) startOp(name, path, handle string) *clientOp {
+	span := c.trace.startSpan(name)
+	if span == nil {
+		return nil
+	}
+	return &clientOp{
+		span:  span,
+		ev:    LogEvent{Path: path, Handle: handle},
+		start: time.Now(),
+	}
+}
+
+// end ends the span of op, which transferred n bytes and failed with err.
+// A nil clientOp does nothing.
+func (op *clientOp) end(n int64, err error) {
+	if op == nil {
+		return
+	}
+	op.ev.Latency = time.Since(op.start)
+	op.ev.Bytes = n
+	op.ev.Err = err
+	op.span.End(op.ev)
+}
+
+// done ends the span of op with the error *err, to be deferred.
+func (op *clientOp) done(err *error) {
+	op.end(0, *err)
+}
+
+type sshFxpTraceContextPacket struct {
+	ID      uint32
+	Carrier string
+}
+
+func (p *sshFxpTraceContextPacket) id() uint32 { return p.ID }
+
+func (p *sshFxpTraceContextPacket) MarshalBinary() ([]byte, error) {
+	l := 4 + 1 + 4 + // uint32(length) + byte(type) + uint32(id)
+		4 + len(traceContextExtension) +
+		4 + len(p.Carrier)
+
+	b := make([]byte, 4, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, traceContextExtension)
+	b = marshalString(b, p.Carrier)
+
+	return b, nil
+}
+
+type sshFxpExtendedPacketTraceContext struct {
+	ID              uint32
+	ExtendedRequest string
+	Carrier         string
+}
+
+func (p *sshFxpExtendedPacketTraceContext) id() uint32     { return p.ID }
+func (p *sshFxpExtendedPacketTraceContext) readonly() bool { return true }
+func (p *sshFxpExtendedPacketTraceContext) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Carrier, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *sshFxpExtendedPacketTraceContext) respond(svr *Server) responsePacket {
+	// servers without a Tracer take the trace context, and ignore it
+	svr.trace.adopt(p.Carrier)
+	return statusFromError(p.ID, nil)
+}
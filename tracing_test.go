@@ -0,0 +1,142 @@
+package sftp
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type traceKey struct{}
+
+// fakeTracer records the spans it ended, with the trace they belong to.
+// Spans without a trace in their context start the trace of the tracer.
+type fakeTracer struct {
+	trace string
+
+	mu    sync.Mutex
+	spans []fakeSpan
+}
+
+type fakeSpan struct {
+	tracer *fakeTracer
+	name   string
+	trace  string
+	ev     LogEvent
+}
+
+func (t *fakeTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	trace, _ := ctx.Value(traceKey{}).(string)
+	if trace == "" {
+		trace = t.trace
+		ctx = context.WithValue(ctx, traceKey{}, trace)
+	}
+	return ctx, &fakeSpan{tracer: t, name: name, trace: trace}
+}
+
+func (t *fakeTracer) Inject(ctx context.Context) string {
+	trace, _ := ctx.Value(traceKey{}).(string)
+	return trace
+}
+
+func (t *fakeTracer) Extract(ctx context.Context, carrier string) context.Context {
+	return context.WithValue(ctx, traceKey{}, carrier)
+}
+
+func (s *fakeSpan) End(ev LogEvent) {
+	s.ev = ev
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.spans = append(s.tracer.spans, *s)
+}
+
+// ended returns the spans named name, which have ended.
+func (t *fakeTracer) ended(name string) []fakeSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var spans []fakeSpan
+	for _, s := range t.spans {
+		if s.name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func TestTracer(t *testing.T) {
+	ct := &fakeTracer{trace: "client"}
+	st := &fakeTracer{trace: "server"}
+
+	client, server := clientServerPairWith(t, []ServerOption{WithTracer(context.Background(), st)}, UseTracer(context.Background(), ct))
+
+	name := filepath.Join(t.TempDir(), "foo")
+	f, err := client.Create(name)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("hello"), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = client.Stat(name + ".missing")
+	require.Error(t, err)
+
+	client.Close()
+	waitServed(server)
+
+	if spans := ct.ended("Client.Open"); assert.Len(t, spans, 1) {
+		assert.Equal(t, "client", spans[0].trace)
+		assert.Equal(t, name, spans[0].ev.Path)
+		assert.NoError(t, spans[0].ev.Err)
+	}
+	if spans := ct.ended("File.WriteAt"); assert.Len(t, spans, 1) {
+		assert.Equal(t, int64(5), spans[0].ev.Bytes)
+		assert.NotEmpty(t, spans[0].ev.Handle)
+	}
+	assert.Len(t, ct.ended("File.Close"), 1)
+	if spans := ct.ended("Client.Stat"); assert.Len(t, spans, 1) {
+		assert.Error(t, spans[0].ev.Err)
+	}
+	assert.Len(t, ct.ended("sftp.Client"), 1)
+
+	// the server joins the trace of the client, once told about it
+	for _, typ := range []string{"SSH_FXP_OPEN", "SSH_FXP_WRITE", "SSH_FXP_CLOSE", "SSH_FXP_STAT"} {
+		if spans := st.ended(typ); assert.Len(t, spans, 1, typ) {
+			assert.Equal(t, "client", spans[0].trace, typ)
+		}
+	}
+	if spans := st.ended("SSH_FXP_WRITE"); assert.Len(t, spans, 1) {
+		assert.Equal(t, int64(5), spans[0].ev.Bytes)
+	}
+	if spans := st.ended("sftp.Server"); assert.Len(t, spans, 1) {
+		assert.Equal(t, "server", spans[0].trace)
+		assert.NoError(t, spans[0].ev.Err, "the client hung up")
+	}
+}
+
+func TestRequestServerTracer(t *testing.T) {
+	st := &fakeTracer{trace: "server"}
+	p := clientRequestServerPair(t, WithRSTracer(context.Background(), st))
+	defer p.Close()
+
+	require.NoError(t, p.cli.Mkdir("/foo"))
+	_, err := p.cli.Stat("/foo")
+	require.NoError(t, err)
+
+	assert.Len(t, st.ended("SSH_FXP_MKDIR"), 1)
+	if spans := st.ended("SSH_FXP_STAT"); assert.Len(t, spans, 1) {
+		assert.Equal(t, "/foo", spans[0].ev.Path)
+		assert.Equal(t, "server", spans[0].trace, "the client does not trace")
+	}
+}
+
+func TestNilTrace(t *testing.T) {
+	var trace *sessionTrace
+	assert.Nil(t, trace.startSpan("foo"))
+	trace.adopt("client")
+	trace.end(nil)
+
+	var op *clientOp
+	op.end(0, nil)
+}
@@ -0,0 +1,206 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"sync"
+)
+
+// errNoSessions is returned by the concurrent transfers without any session.
+var errNoSessions = errors.New("sftp: concurrent transfer needs at least one session")
+
+// stripeBufferSize is how much a striped transfer reads and writes at once,
+// enough for File.ReadAt and File.WriteAt to keep many requests in flight.
+const stripeBufferSize = 1 << 20
+
+// DownloadConcurrent copies the remote file at path to w, returning the bytes
+// copied. The file is split into one range per session, which are read over
+// all sessions at the same time and written to w at their offsets, so w has to
+// support concurrent WriteAt calls on distinct ranges, as os.File does.
+//
+// The sessions have to be connected to the same server, separate sessions of
+// one SSH connection for example. Unlike the concurrent reads of a single File,
+// this spreads the transfer over the flow control windows of several channels.
+//
+// If a session fails with an error for which IsRetryable holds, like when it
+// lost its connection, the rest of its range is transferred over the sessions
+// which completed theirs.
+//
+// WithPreserve applies to w if it is an *os.File, other writers are left as
+// they are.
+func DownloadConcurrent(sessions []*Client, path string, w io.WriterAt, opts ...TransferOption) (int64, error) {
+	if len(sessions) == 0 {
+		return 0, errNoSessions
+	}
+	o := newTransferOptions(opts)
+
+	fi, err := sessions[0].Stat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := downloadConcurrent(sessions, path, fi.Size(), w)
+	if f, ok := w.(*os.File); ok && err == nil && o.preserve != 0 {
+		err = preserveLocal(f, fi, o.preserve)
+	}
+	return n, err
+}
+
+// downloadConcurrent downloads size bytes of the remote file at path over sessions.
+func downloadConcurrent(sessions []*Client, path string, size int64, w io.WriterAt) (int64, error) {
+	return stripe(sessions, size, func(c *Client, off, n int64) (int64, error) {
+		f, err := c.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+
+		b := make([]byte, stripeBufferSize)
+		var copied int64
+		for copied < n {
+			chunk := b
+			if n-copied < int64(len(chunk)) {
+				chunk = chunk[:n-copied]
+			}
+			m, err := f.ReadAt(chunk, off+copied)
+			if m > 0 {
+				if _, err := w.WriteAt(chunk[:m], off+copied); err != nil {
+					return copied, err
+				}
+				copied += int64(m)
+			}
+			if err == io.EOF {
+				// the file shrank since the Stat
+				return copied, nil
+			}
+			if err != nil {
+				return copied, err
+			}
+		}
+		return copied, nil
+	})
+}
+
+// UploadConcurrent copies size bytes of r to the remote file at path, which
+// is created or truncated first, returning the bytes copied. Like
+// DownloadConcurrent, it writes one range of the file over every session at
+// the same time, reading them from r at their offsets.
+//
+// If UploadConcurrent fails, parts of the remote file may not have been
+// written yet, even before the bytes copied.
+//
+// WithPreserve takes the attributes from the Stat method of r, like that of
+// an *os.File, and does nothing for readers without one.
+func UploadConcurrent(sessions []*Client, path string, r io.ReaderAt, size int64, opts ...TransferOption) (int64, error) {
+	if len(sessions) == 0 {
+		return 0, errNoSessions
+	}
+	o := newTransferOptions(opts)
+
+	var fi iofs.FileInfo
+	if s, ok := r.(interface{ Stat() (iofs.FileInfo, error) }); ok && o.preserve != 0 {
+		var err error
+		if fi, err = s.Stat(); err != nil {
+			return 0, err
+		}
+	}
+
+	f, err := sessions[0].OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+
+	n, err := stripe(sessions, size, func(c *Client, off, n int64) (int64, error) {
+		f, err := c.OpenFile(path, os.O_WRONLY)
+		if err != nil {
+			return 0, err
+		}
+
+		b := make([]byte, stripeBufferSize)
+		var copied int64
+		for copied < n {
+			chunk := b
+			if n-copied < int64(len(chunk)) {
+				chunk = chunk[:n-copied]
+			}
+			m, err := r.ReadAt(chunk, off+copied)
+			if int64(m) < int64(len(chunk)) && (err == nil || err == io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			if m > 0 {
+				if _, err := f.WriteAt(chunk[:m], off+copied); err != nil {
+					f.Close()
+					return copied, err
+				}
+				copied += int64(m)
+			}
+			if err != nil {
+				f.Close()
+				return copied, err
+			}
+		}
+		return copied, f.Close()
+	})
+	if err == nil && fi != nil {
+		err = sessions[0].preserveRemote(path, fi, o.preserve)
+	}
+	return n, err
+}
+
+// stripe runs transfer for each session, on an equal share of size bytes.
+// The rest of a share failing with a retryable error is resumed on the
+// sessions which completed theirs, one after the other.
+// It returns the bytes transferred and the first error.
+func stripe(sessions []*Client, size int64, transfer func(c *Client, off, n int64) (int64, error)) (int64, error) {
+	share := size / int64(len(sessions))
+
+	var wg sync.WaitGroup
+	offs := make([]int64, len(sessions))
+	lens := make([]int64, len(sessions))
+	counts := make([]int64, len(sessions))
+	errs := make([]error, len(sessions))
+	for i, c := range sessions {
+		offs[i] = int64(i) * share
+		lens[i] = share
+		if i == len(sessions)-1 {
+			lens[i] = size - offs[i]
+		}
+
+		wg.Add(1)
+		go func(i int, c *Client) {
+			defer wg.Done()
+			counts[i], errs[i] = transfer(c, offs[i], lens[i])
+		}(i, c)
+	}
+	wg.Wait()
+
+	for i := range sessions {
+		for j, c := range sessions {
+			if !IsRetryable(errs[i]) {
+				break
+			}
+			if errs[j] != nil || c.isClosed() {
+				continue
+			}
+			n, err := transfer(c, offs[i]+counts[i], lens[i]-counts[i])
+			counts[i] += n
+			errs[i] = err
+		}
+	}
+
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+	for _, err := range errs {
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
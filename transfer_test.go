@@ -0,0 +1,116 @@
+package sftp
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentTransfer(t *testing.T) {
+	s := &poolServers{}
+	var sessions []*Client
+	for i := 0; i < 3; i++ {
+		c, err := s.newClient()
+		require.NoError(t, err)
+		defer c.Close()
+		sessions = append(sessions, c)
+	}
+
+	content := make([]byte, 2*stripeBufferSize+4321)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "uploaded")
+	require.NoError(t, os.WriteFile(name, bytes.Repeat([]byte("x"), 4*stripeBufferSize), 0o644))
+	n, err := UploadConcurrent(sessions, name, bytes.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), n)
+
+	b, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, b), "the file is truncated and written")
+
+	f, err := os.Create(filepath.Join(dir, "downloaded"))
+	require.NoError(t, err)
+	defer f.Close()
+	n, err = DownloadConcurrent(sessions, name, f)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), n)
+	b, err = os.ReadFile(f.Name())
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, b))
+
+	_, err = UploadConcurrent(sessions, name, bytes.NewReader(content[:10]), 100)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	_, err = DownloadConcurrent(sessions, filepath.Join(dir, "missing"), f)
+	assert.True(t, os.IsNotExist(err))
+	_, err = DownloadConcurrent(nil, name, f)
+	assert.Equal(t, errNoSessions, err)
+}
+
+func TestConcurrentTransferPreserve(t *testing.T) {
+	s := &poolServers{}
+	c, err := s.newClient()
+	require.NoError(t, err)
+	defer c.Close()
+	sessions := []*Client{c}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	require.NoError(t, os.WriteFile(src, []byte("content"), 0o640))
+	mtime := time.Unix(1500000000, 500)
+	require.NoError(t, os.Chtimes(src, mtime, mtime))
+
+	r, err := os.Open(src)
+	require.NoError(t, err)
+	defer r.Close()
+	uploaded := filepath.Join(dir, "uploaded")
+	_, err = UploadConcurrent(sessions, uploaded, r, 7, WithPreserve(PreserveMode|PreserveTimes))
+	require.NoError(t, err)
+	fi, err := os.Stat(uploaded)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), fi.Mode().Perm())
+	assert.True(t, fi.ModTime().Equal(mtime), "got %v", fi.ModTime())
+
+	f, err := os.Create(filepath.Join(dir, "downloaded"))
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = DownloadConcurrent(sessions, src, f, WithPreserve(PreserveMode|PreserveTimes|PreserveOwner))
+	require.NoError(t, err)
+	fi, err = f.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), fi.Mode().Perm())
+	assert.True(t, fi.ModTime().Equal(mtime), "got %v", fi.ModTime())
+}
+
+func TestPreservedAttrs(t *testing.T) {
+	fi := fileInfoFromStat(&FileStat{
+		Mode:  0o4755,
+		Mtime: 2,
+		Atime: 1,
+		UID:   10,
+		GID:   20,
+	}, "file")
+
+	c := &Client{version: 3}
+	flags, b := c.preservedAttrs(fi, PreserveMode|PreserveTimes|PreserveOwner)
+	assert.Equal(t, uint32(sshFileXferAttrUIDGID|sshFileXferAttrPermissions|sshFileXferAttrACmodTime), flags)
+	want := marshalUint32(marshalUint32(nil, 10), 20)
+	want = marshalUint32(want, 0o4755)
+	want = marshalUint32(marshalUint32(want, 1), 2)
+	assert.Equal(t, want, b)
+
+	c = &Client{version: 6}
+	flags, b = c.preservedAttrs(fi, PreserveTimes)
+	assert.Equal(t, uint32(sshFileXferAttrAccessTime|sshFileXferAttrModifyTime|sshFileXferAttrSubsecondTimes), flags)
+	want = marshalUint32(marshalUint64(nil, 1), 0)
+	want = marshalUint32(marshalUint64(want, 2), 0)
+	assert.Equal(t, want, b)
+}
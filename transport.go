@@ -0,0 +1,93 @@
+package sftp
+
+import (
+	"io"
+)
+
+// Transport carries the sessions of a Client over any kind of stream,
+// like a serial line, a QUIC stream or an SSM session, see NewClientTransport.
+type Transport interface {
+	// Open returns the pipes of a new session of the sftp subsystem.
+	// It is called to start the Client, and again for every reconnect.
+	Open() (io.Reader, io.WriteCloser, error)
+}
+
+// Open calls dial, so that a DialFunc is a Transport.
+func (dial DialFunc) Open() (io.Reader, io.WriteCloser, error) {
+	return dial()
+}
+
+// ReconnectSignaler is an optional interface a Transport can implement,
+// if it learns that the session is gone before the Client does, like when
+// the carrier migrated to another path.
+type ReconnectSignaler interface {
+	// Reconnect returns a channel, which receives whenever the Client is to
+	// give up on the current session and reconnect. The Client closes the
+	// WriteCloser of the session then, which has to make its Reader fail,
+	// as it does for the pipes of a session of ssh.
+	Reconnect() <-chan struct{}
+}
+
+// ReconnectObserver is an optional interface a Transport can implement
+// to learn when the Client lost its session and how reconnecting went.
+type ReconnectObserver interface {
+	// Disconnected is called with the error ending the session,
+	// before the Client reconnects.
+	Disconnected(err error)
+
+	// Reconnected is called once the Client resumed, after the open Files
+	// were reopened and before the requests in flight are sent again. If all
+	// attempts failed, it is called with the error of the last one, and the
+	// Client shuts down.
+	Reconnected(err error)
+}
+
+// NewClientTransport creates a new SFTP client on the sessions of t. It opens
+// the first one right away, and further ones to reconnect according to policy,
+// as WithAutoReconnect does.
+//
+// Passing WithAutoReconnect in opts replaces the Transport for reconnecting.
+func NewClientTransport(t Transport, policy ReconnectPolicy, opts ...ClientOption) (*Client, error) {
+	rd, wr, err := t.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append([]ClientOption{withTransport(t, policy)}, opts...)
+	return NewClientPipe(rd, wr, opts...)
+}
+
+// withTransport reconnects through t, see NewClientTransport.
+func withTransport(t Transport, policy ReconnectPolicy) ClientOption {
+	return func(c *Client) error {
+		if err := WithAutoReconnect(t.Open, policy)(c); err != nil {
+			return err
+		}
+		if s, ok := t.(ReconnectSignaler); ok {
+			c.reconnect.signal = s.Reconnect()
+		}
+		if o, ok := t.(ReconnectObserver); ok {
+			c.reconnect.observer = o
+		}
+		return nil
+	}
+}
+
+// watch drops the current session whenever the Transport signals to reconnect,
+// until the Client is closed.
+func (r *reconnector) watch() {
+	defer r.c.wg.Done()
+
+	for {
+		select {
+		case <-r.stopped:
+			return
+		case _, ok := <-r.signal:
+			if !ok {
+				return
+			}
+			// the loop notices, and reconnects
+			r.c.conn.Close()
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signalingTransport opens the sessions of a reconnectServer,
+// signals reconnects on demand and records what it is told.
+type signalingTransport struct {
+	*reconnectServer
+	signal chan struct{}
+	fail   bool // fail to open sessions
+
+	mu     sync.Mutex
+	events []string
+}
+
+func (t *signalingTransport) Open() (io.Reader, io.WriteCloser, error) {
+	if t.fail {
+		return nil, nil, errors.New("unreachable")
+	}
+	return t.dial()
+}
+
+func (t *signalingTransport) Reconnect() <-chan struct{} { return t.signal }
+
+func (t *signalingTransport) record(event string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		event += " with error"
+	}
+	t.events = append(t.events, event)
+}
+
+func (t *signalingTransport) Disconnected(err error) { t.record("disconnected", err) }
+func (t *signalingTransport) Reconnected(err error)  { t.record("reconnected", err) }
+
+func (t *signalingTransport) recorded() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.events...)
+}
+
+func TestClientTransport(t *testing.T) {
+	transport := &signalingTransport{
+		reconnectServer: &reconnectServer{serve: func(rw io.ReadWriteCloser, _ int) {
+			server, err := NewServer(rw, apis.NewAVFS())
+			if err == nil {
+				server.Serve()
+			}
+		}},
+		signal: make(chan struct{}),
+	}
+	client, err := NewClientTransport(transport, ReconnectPolicy{MaxAttempts: 3})
+	require.NoError(t, err)
+	defer client.Close()
+
+	name := path.Join(t.TempDir(), "moved")
+	f, err := client.Create(name)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	// the carrier moved, the session carries on over a new one
+	transport.signal <- struct{}{}
+	assert.Eventually(t, func() bool {
+		return len(transport.recorded()) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = f.Write([]byte(" world"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	b, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+	assert.Equal(t, 2, transport.dialed())
+	assert.Equal(t, []string{"disconnected with error", "reconnected"}, transport.recorded())
+}
+
+func TestClientTransportGivesUp(t *testing.T) {
+	transport := &signalingTransport{
+		reconnectServer: &reconnectServer{serve: func(rw io.ReadWriteCloser, _ int) {
+			server, err := NewServer(rw, apis.NewAVFS())
+			if err == nil {
+				server.Serve()
+			}
+		}},
+	}
+	client, err := NewClientTransport(transport, ReconnectPolicy{MaxAttempts: 2, Backoff: time.Millisecond})
+	require.NoError(t, err)
+	defer client.Close()
+
+	transport.fail = true
+	transport.dropConn()
+
+	assert.Error(t, client.Wait())
+	assert.Equal(t, []string{"disconnected with error", "reconnected with error"}, transport.recorded())
+
+	_, err = NewClientTransport(transport, ReconnectPolicy{})
+	assert.Error(t, err)
+}
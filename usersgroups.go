@@ -0,0 +1,147 @@
+package sftp
+
+import (
+	"strconv"
+)
+
+// usersGroupsByIDExtension maps numeric user and group IDs to names.
+const usersGroupsByIDExtension = "users-groups-by-id@openssh.com"
+
+// IDNameResolver resolves numeric user and group IDs, given in decimal,
+// to names for users-groups-by-id@openssh.com requests. Unknown IDs are
+// returned as they are, or as an empty string.
+type IDNameResolver interface {
+	LookupUserName(uid string) string
+	LookupGroupName(gid string) string
+}
+
+// WithIDNameResolver sets how a Server resolves user and group IDs to names
+// for users-groups-by-id@openssh.com requests.
+// By default the names are looked up with os/user.
+//
+// A RequestServer answers these requests if its FileList handler
+// is a NameLookupFileLister.
+func WithIDNameResolver(resolver IDNameResolver) ServerOption {
+	return func(s *Server) error {
+		s.idNames = resolver
+		return nil
+	}
+}
+
+func unmarshalIDList(s string) ([]uint32, error) {
+	if len(s)%4 != 0 {
+		return nil, errShortPacket
+	}
+
+	b := []byte(s)
+	ids := make([]uint32, 0, len(b)/4)
+	for len(b) > 0 {
+		var id uint32
+		id, b = unmarshalUint32(b)
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+type sshFxpUsersGroupsReply struct {
+	ID     uint32
+	Users  []string
+	Groups []string
+}
+
+func (p *sshFxpUsersGroupsReply) id() uint32 { return p.ID }
+
+func (p *sshFxpUsersGroupsReply) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 4, 4+1+4+4+4)
+	b = append(b, sshFxpExtendedReply)
+	b = marshalUint32(b, p.ID)
+	for _, names := range [][]string{p.Users, p.Groups} {
+		var list []byte
+		for _, name := range names {
+			list = marshalString(list, name)
+		}
+		b = marshalString(b, string(list))
+	}
+	return b, nil
+}
+
+// unmarshalNameList decodes the names packed into one string of the reply.
+func unmarshalNameList(b []byte, n int) ([]string, []byte, error) {
+	list, rest, err := unmarshalStringSafe(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lb := []byte(list)
+	names := make([]string, n)
+	for i := range names {
+		if names[i], lb, err = unmarshalStringSafe(lb); err != nil {
+			return nil, nil, err
+		}
+	}
+	return names, rest, nil
+}
+
+// usersGroupsByID answers with the names for the requested IDs,
+// unknown ones are left empty.
+func usersGroupsByID(p *sshFxpExtendedPacketUsersGroupsByID, resolver IDNameResolver) responsePacket {
+	lookup := func(ids []uint32, fn func(string) string) []string {
+		names := make([]string, len(ids))
+		for i, id := range ids {
+			s := strconv.FormatUint(uint64(id), 10)
+			if name := fn(s); name != s {
+				names[i] = name
+			}
+		}
+		return names
+	}
+
+	return &sshFxpUsersGroupsReply{
+		ID:     p.ID,
+		Users:  lookup(p.UIDs, resolver.LookupUserName),
+		Groups: lookup(p.GIDs, resolver.LookupGroupName),
+	}
+}
+
+func (p *sshFxpExtendedPacketUsersGroupsByID) respond(svr *Server) responsePacket {
+	resolver := svr.idNames
+	if resolver == nil {
+		resolver = osIDLookup{}
+	}
+	return usersGroupsByID(p, resolver)
+}
+
+// UsersGroupsByID resolves user and group IDs, e.g. from FileStat,
+// to names on the server in a single request. The returned names are in the
+// order of the IDs, with an empty name for every ID the server does not know.
+// The server has to support the users-groups-by-id@openssh.com extension.
+func (c *Client) UsersGroupsByID(uids, gids []uint32) (users, groups []string, err error) {
+	id := c.nextID()
+	typ, data, err := c.sendPacket(nil, &sshFxpUsersGroupsByIDPacket{
+		ID:   id,
+		UIDs: uids,
+		GIDs: gids,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch typ {
+	case sshFxpExtendedReply:
+		sid, data := unmarshalUint32(data)
+		if sid != id {
+			return nil, nil, &unexpectedIDErr{id, sid}
+		}
+		if users, data, err = unmarshalNameList(data, len(uids)); err != nil {
+			return nil, nil, err
+		}
+		if groups, _, err = unmarshalNameList(data, len(gids)); err != nil {
+			return nil, nil, err
+		}
+		return users, groups, nil
+	case sshFxpStatus:
+		return nil, nil, normaliseError(unmarshalStatus(id, data))
+	default:
+		return nil, nil, unimplementedPacketErr(typ)
+	}
+}
@@ -0,0 +1,90 @@
+package sftp
+
+import (
+	"errors"
+	"os/user"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mapIDNames knows uid/gid 1000 only.
+type mapIDNames struct{}
+
+func (mapIDNames) LookupUserName(uid string) string {
+	if uid == "1000" {
+		return "alice"
+	}
+	return uid
+}
+
+func (mapIDNames) LookupGroupName(gid string) string {
+	if gid == "1000" {
+		return "staff"
+	}
+	return ""
+}
+
+func TestUsersGroupsByIDPacket(t *testing.T) {
+	b, err := (&sshFxpUsersGroupsByIDPacket{ID: 7, UIDs: []uint32{0, 1000}, GIDs: []uint32{42}}).MarshalBinary()
+	require.NoError(t, err)
+
+	var p sshFxpExtendedPacket
+	require.NoError(t, p.UnmarshalBinary(b[5:]))
+	pkt, ok := p.SpecificPacket.(*sshFxpExtendedPacketUsersGroupsByID)
+	require.True(t, ok)
+	assert.Equal(t, uint32(7), pkt.ID)
+	assert.Equal(t, []uint32{0, 1000}, pkt.UIDs)
+	assert.Equal(t, []uint32{42}, pkt.GIDs)
+
+	_, err = unmarshalIDList("abc")
+	assert.Error(t, err)
+}
+
+func TestServerUsersGroupsByID(t *testing.T) {
+	client, server := clientServerPair(t, WithIDNameResolver(mapIDNames{}))
+	defer client.Close()
+	defer server.Close()
+
+	_, ok := client.HasExtension(usersGroupsByIDExtension)
+	assert.True(t, ok)
+
+	users, groups, err := client.UsersGroupsByID([]uint32{1000, 1001}, []uint32{1001, 1000})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", ""}, users)
+	assert.Equal(t, []string{"", "staff"}, groups)
+
+	users, groups, err = client.UsersGroupsByID(nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, users)
+	assert.Empty(t, groups)
+}
+
+func TestServerUsersGroupsByIDOSUser(t *testing.T) {
+	skipIfWindows(t)
+	u, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	require.NoError(t, err)
+
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	users, _, err := client.UsersGroupsByID([]uint32{uint32(uid)}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{u.Username}, users)
+}
+
+func TestRequestUsersGroupsByIDUnsupported(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+
+	_, _, err := p.cli.UsersGroupsByID([]uint32{0}, nil)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrSSHFxOpUnsupported), "got %v", err)
+}
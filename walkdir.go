@@ -0,0 +1,192 @@
+package sftp
+
+import (
+	iofs "io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// maxWalkLinks bounds the symbolic links WalkDir follows on the way down to
+// a directory, for the cycles it cannot tell from the link targets.
+const maxWalkLinks = 40
+
+// walkDir holds the options of Client.WalkDir.
+type walkDir struct {
+	followSymlinks bool
+	concurrency    int
+}
+
+// A WalkDirOption configures Client.WalkDir.
+type WalkDirOption func(*walkDir)
+
+// WalkFollowSymlinks makes WalkDir follow symbolic links: they are visited
+// with the entries of what they point to, and descended into if that is a
+// directory. A link to a directory above it is visited but not descended
+// into, and neither is a directory reached through more than 40 links.
+// Links which cannot be followed are visited as links.
+func WalkFollowSymlinks() WalkDirOption {
+	return func(w *walkDir) {
+		w.followSymlinks = true
+	}
+}
+
+// WalkStatConcurrency sets how many symbolic links of a directory WalkDir
+// stats at once with WalkFollowSymlinks, 8 by default.
+func WalkStatConcurrency(n int) WalkDirOption {
+	return func(w *walkDir) {
+		if n > 0 {
+			w.concurrency = n
+		}
+	}
+}
+
+// WalkDir walks the file tree rooted at root, calling fn for each file or
+// directory in the tree, including root, with the semantics of fs.WalkDir:
+// returning fs.SkipDir from fn skips the directory, or the rest of the
+// directory of a file.
+//
+// Unlike fs.WalkDir, WalkDir does not sort the entries of a directory. It
+// visits the files as the listing of the directory arrives from the server,
+// page by page, and the subdirectories after the listing is complete, so
+// that only one directory is open on the server at a time.
+func (c *Client) WalkDir(root string, fn iofs.WalkDirFunc, opts ...WalkDirOption) error {
+	w := walkDir{concurrency: 8}
+	for _, opt := range opts {
+		opt(&w)
+	}
+
+	info, err := c.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		real := path.Clean(root)
+		if w.followSymlinks {
+			// for absolute paths to compare the link targets with
+			if p, err := c.RealPath(root); err == nil {
+				real = p
+			}
+		}
+		err = c.walk(&w, root, real, infoEntry{info}, fn, 0)
+	}
+	if err == iofs.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// walk visits name, and everything below it if it is a directory. real is
+// name with the links followed on the way resolved, links counts them.
+func (c *Client) walk(w *walkDir, name, real string, d iofs.DirEntry, fn iofs.WalkDirFunc, links int) error {
+	if err := fn(name, d, nil); err != nil || !d.IsDir() {
+		if err == iofs.SkipDir && d.IsDir() {
+			return nil
+		}
+		return err
+	}
+
+	type subdir struct {
+		name, real string
+		d          iofs.DirEntry
+		links      int
+	}
+	var subdirs []subdir
+
+	err := c.readDir(name, func(page []iofs.FileInfo) error {
+		if w.followSymlinks {
+			page = c.followLinks(name, page, w.concurrency)
+		}
+		for _, info := range page {
+			entry := infoEntry{info}
+			child := path.Join(name, info.Name())
+			if !info.IsDir() {
+				if err := fn(child, entry, nil); err != nil {
+					return err
+				}
+				continue
+			}
+
+			sub := subdir{name: child, real: path.Join(real, info.Name()), d: entry, links: links}
+			if _, followed := info.(followedLink); followed {
+				target, err := c.ReadLink(child)
+				if err != nil {
+					// visit it, but do not descend into it
+					if err := fn(child, entry, nil); err != nil && err != iofs.SkipDir {
+						return err
+					}
+					continue
+				}
+				if !path.IsAbs(target) {
+					target = path.Join(real, target)
+				}
+				sub.real, sub.links = path.Clean(target), links+1
+				if real == sub.real || strings.HasPrefix(real, strings.TrimSuffix(sub.real, "/")+"/") {
+					// a link to a directory above it
+					if err := fn(child, entry, nil); err != nil && err != iofs.SkipDir {
+						return err
+					}
+					continue
+				}
+				if sub.links > maxWalkLinks {
+					if err := fn(child, entry, &iofs.PathError{Op: "walk", Path: child, Err: errTooManyLinks}); err != nil && err != iofs.SkipDir {
+						return err
+					}
+					continue
+				}
+			}
+			subdirs = append(subdirs, sub)
+		}
+		return nil
+	})
+	if err != nil {
+		if err == iofs.SkipDir {
+			// skip the rest of the directory
+			return nil
+		}
+		if err = fn(name, d, err); err != nil {
+			if err == iofs.SkipDir {
+				return nil
+			}
+			return err
+		}
+	}
+
+	for _, sub := range subdirs {
+		if err := c.walk(w, sub.name, sub.real, sub.d, fn, sub.links); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// followedLink is the fs.FileInfo of what a symbolic link points to, under
+// the name of the link.
+type followedLink struct {
+	iofs.FileInfo
+}
+
+// followLinks replaces the symbolic links among the entries of the
+// directory dir with what they point to, stat'ing up to n at once. Links
+// which cannot be stat'ed are kept.
+func (c *Client) followLinks(dir string, infos []iofs.FileInfo, n int) []iofs.FileInfo {
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for i, info := range infos {
+		if info.Mode()&iofs.ModeSymlink == 0 {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, name string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if fi, err := c.Stat(path.Join(dir, name)); err == nil {
+				infos[i] = followedLink{fi}
+			}
+		}(i, info.Name())
+	}
+	wg.Wait()
+	return infos
+}
@@ -0,0 +1,99 @@
+package sftp
+
+import (
+	iofs "io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientWalkDir(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	root := t.TempDir()
+	for _, dir := range []string{"a/b", "c", "skip"} {
+		require.NoError(t, os.MkdirAll(path.Join(root, dir), 0o755))
+	}
+	for _, file := range []string{"a/1", "a/b/2", "c/3", "skip/4", "5"} {
+		require.NoError(t, os.WriteFile(path.Join(root, file), nil, 0o644))
+	}
+	require.NoError(t, os.Symlink("../c", path.Join(root, "a/toc")))
+	require.NoError(t, os.Symlink("..", path.Join(root, "a/b/up")))
+	require.NoError(t, os.Symlink("missing", path.Join(root, "dangling")))
+
+	walk := func(opts ...WalkDirOption) []string {
+		var visited []string
+		err := client.WalkDir(root, func(name string, d iofs.DirEntry, err error) error {
+			require.NoError(t, err)
+			rel := strings.TrimPrefix(strings.TrimPrefix(name, root), "/")
+			if d.IsDir() {
+				rel += "/"
+			} else if d.Type()&iofs.ModeSymlink != 0 {
+				rel += "@"
+			}
+			visited = append(visited, rel)
+			if rel == "skip/" {
+				return iofs.SkipDir
+			}
+			return nil
+		}, opts...)
+		require.NoError(t, err)
+		sort.Strings(visited)
+		return visited
+	}
+
+	assert.Equal(t, []string{
+		"/", "5", "a/", "a/1", "a/b/", "a/b/2", "a/b/up@", "a/toc@", "c/", "c/3", "dangling@", "skip/",
+	}, walk())
+	assert.Equal(t, []string{
+		"/", "5", "a/", "a/1", "a/b/", "a/b/2", "a/b/up/", "a/toc/", "a/toc/3", "c/", "c/3", "dangling@", "skip/",
+	}, walk(WalkFollowSymlinks(), WalkStatConcurrency(2)), "the up link is not descended into")
+}
+
+func TestClientWalkDirSkip(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(root, "dir/sub"), 0o755))
+	for _, file := range []string{"dir/1", "dir/2", "dir/sub/3"} {
+		require.NoError(t, os.WriteFile(path.Join(root, file), nil, 0o644))
+	}
+
+	// SkipDir from a file skips the rest of its directory, subdirectories
+	// included
+	var visited []string
+	err := client.WalkDir(path.Join(root, "dir"), func(name string, d iofs.DirEntry, err error) error {
+		require.NoError(t, err)
+		visited = append(visited, path.Base(name))
+		if !d.IsDir() {
+			return iofs.SkipDir
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Len(t, visited, 2)
+	assert.Equal(t, "dir", visited[0])
+
+	errStop := iofs.ErrClosed
+	err = client.WalkDir(root, func(name string, d iofs.DirEntry, err error) error {
+		return errStop
+	})
+	assert.Equal(t, errStop, err)
+
+	missing := path.Join(root, "missing")
+	err = client.WalkDir(missing, func(name string, d iofs.DirEntry, err error) error {
+		assert.Equal(t, missing, name)
+		assert.Nil(t, d)
+		return err
+	})
+	assert.True(t, os.IsNotExist(err), "%v", err)
+}
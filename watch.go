@@ -0,0 +1,338 @@
+package sftp
+
+import (
+	"errors"
+	iofs "io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WatchOp is the kind of change of a WatchEvent.
+type WatchOp uint8
+
+// The changes a Watcher reports.
+const (
+	WatchCreate WatchOp = iota + 1 // the file appeared
+	WatchModify                    // the size, modification time or mode changed
+	WatchDelete                    // the file disappeared
+	WatchRename                    // the file disappeared from OldPath and appeared at Path
+)
+
+func (op WatchOp) String() string {
+	switch op {
+	case WatchCreate:
+		return "create"
+	case WatchModify:
+		return "modify"
+	case WatchDelete:
+		return "delete"
+	case WatchRename:
+		return "rename"
+	}
+	return "unknown"
+}
+
+// WatchEvent is a change a Watcher found.
+type WatchEvent struct {
+	Op      WatchOp
+	Path    string
+	OldPath string        // for WatchRename
+	Info    iofs.FileInfo // the attributes after the change, before it for WatchDelete
+}
+
+func (ev WatchEvent) String() string {
+	if ev.Op == WatchRename {
+		return ev.Op.String() + " " + ev.OldPath + " -> " + ev.Path
+	}
+	return ev.Op.String() + " " + ev.Path
+}
+
+// watchOptions holds the options of Client.Watch.
+type watchOptions struct {
+	recursive bool
+}
+
+// A WatchOption configures Client.Watch.
+type WatchOption func(*watchOptions)
+
+// WatchRecursive makes Watch watch the whole tree below the directory,
+// rather than only its entries.
+func WatchRecursive() WatchOption {
+	return func(o *watchOptions) {
+		o.recursive = true
+	}
+}
+
+// A Watcher polls a file or directory for changes, see Client.Watch.
+type Watcher struct {
+	// Events receives the changes found, and is closed once the Watcher
+	// is closed, or the client is.
+	Events <-chan WatchEvent
+
+	// Errors receives the errors of polls, which are skipped and retried
+	// at the next one. An error is dropped while the one before is unread.
+	Errors <-chan error
+
+	c         *Client
+	root      string
+	recursive bool
+	events    chan WatchEvent
+	errs      chan error
+	stop      chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// Watch polls path every interval for changes, and sends them to the Events
+// of the returned Watcher, since the protocol has no notifications of its
+// own. If path is a directory, its entries are watched too, and the whole
+// tree below it with WatchRecursive. The path need not exist, its creation
+// is reported like any other.
+//
+// Every poll lists the directories watched and diffs the attributes the
+// listings carry against those of the poll before, so that it takes one
+// request per directory and none per file. A file which disappeared and one
+// which appeared with the same size, modification time and mode in the same
+// poll are reported as a rename, and a renamed directory is reported alone,
+// without its entries. Changes undone between two polls are missed.
+func (c *Client) Watch(path string, interval time.Duration, opts ...WatchOption) (_ *Watcher, err error) {
+	defer pathError(&err, "watch", path)
+
+	if interval <= 0 {
+		return nil, errors.New("sftp: watch interval must be positive")
+	}
+	var o watchOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	w := &Watcher{
+		c:         c,
+		root:      path,
+		recursive: o.recursive,
+		events:    make(chan WatchEvent),
+		errs:      make(chan error, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	w.Events, w.Errors = w.events, w.errs
+
+	snap, err := w.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	go w.poll(snap, interval)
+	return w, nil
+}
+
+// Close stops the Watcher and closes its channels. It is safe to call more
+// than once.
+func (w *Watcher) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+	return nil
+}
+
+// poll diffs a snapshot against the one before every interval.
+func (w *Watcher) poll(snap map[string]iofs.FileInfo, interval time.Duration) {
+	defer close(w.done)
+	defer close(w.errs)
+	defer close(w.events)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.c.closed:
+			return
+		case <-ticker.C:
+		}
+
+		next, err := w.snapshot()
+		if err != nil {
+			select {
+			case w.errs <- err:
+			default:
+			}
+			continue
+		}
+		for _, ev := range diffSnapshots(snap, next) {
+			select {
+			case w.events <- ev:
+			case <-w.stop:
+				return
+			case <-w.c.closed:
+				return
+			}
+		}
+		snap = next
+	}
+}
+
+// snapshot returns the attributes of the files watched by their paths,
+// none if the root does not exist.
+func (w *Watcher) snapshot() (map[string]iofs.FileInfo, error) {
+	snap := make(map[string]iofs.FileInfo)
+	info, err := w.c.Lstat(w.root)
+	if errors.Is(err, iofs.ErrNotExist) {
+		return snap, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snap[w.root] = info
+	if !info.IsDir() {
+		return snap, nil
+	}
+
+	dirs := []string{w.root}
+	for len(dirs) > 0 {
+		dir := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+
+		infos, err := w.c.ReadDir(dir)
+		if errors.Is(err, iofs.ErrNotExist) && dir != w.root {
+			// removed since listing its parent
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			name := path.Join(dir, info.Name())
+			snap[name] = info
+			if w.recursive && info.IsDir() {
+				dirs = append(dirs, name)
+			}
+		}
+	}
+	return snap, nil
+}
+
+// diffSnapshots returns the changes from the snapshot old to next: the
+// renames, then the deletes, the entries of a directory before it, then
+// the creates and the modifications, a directory before its entries.
+func diffSnapshots(old, next map[string]iofs.FileInfo) []WatchEvent {
+	var created, deleted, modified []string
+	for name, info := range next {
+		before, ok := old[name]
+		switch {
+		case !ok:
+			created = append(created, name)
+		case watchModified(before, info):
+			modified = append(modified, name)
+		}
+	}
+	for name := range old {
+		if _, ok := next[name]; !ok {
+			deleted = append(deleted, name)
+		}
+	}
+	sort.Strings(created)
+	sort.Strings(modified)
+	sort.Sort(sort.Reverse(sort.StringSlice(deleted)))
+
+	var events []WatchEvent
+	renamed := make(map[string]bool) // the old and new paths of renames
+	var renamedDirs [][2]string
+	// the directories first, so that their entries can be left out
+	for _, dirs := range []bool{true, false} {
+		for _, from := range deleted {
+			if old[from].IsDir() != dirs || renamed[from] || underRenamed(from, renamedDirs, 0) {
+				continue
+			}
+			to, ok := renameTarget(from, deleted, created, old, next, renamed)
+			if !ok {
+				continue
+			}
+			renamed[from], renamed[to] = true, true
+			if dirs {
+				renamedDirs = append(renamedDirs, [2]string{from, to})
+			}
+			events = append(events, WatchEvent{Op: WatchRename, Path: to, OldPath: from, Info: next[to]})
+		}
+	}
+
+	for _, name := range deleted {
+		if !renamed[name] && !underRenamed(name, renamedDirs, 0) {
+			events = append(events, WatchEvent{Op: WatchDelete, Path: name, Info: old[name]})
+		}
+	}
+	for _, name := range created {
+		if !renamed[name] && !underRenamed(name, renamedDirs, 1) {
+			events = append(events, WatchEvent{Op: WatchCreate, Path: name, Info: next[name]})
+		}
+	}
+	for _, name := range modified {
+		events = append(events, WatchEvent{Op: WatchModify, Path: name, Info: next[name]})
+	}
+	return events
+}
+
+// watchModified reports whether a file changed from before to info. The
+// times and sizes of directories change with their entries, which are
+// reported themselves.
+func watchModified(before, info iofs.FileInfo) bool {
+	if before.Mode() != info.Mode() {
+		return true
+	}
+	if info.IsDir() {
+		return false
+	}
+	return before.Size() != info.Size() || !before.ModTime().Equal(info.ModTime())
+}
+
+// renameTarget returns the only created file which looks like the deleted
+// file from, if from is the only deleted file which looks like it, and
+// neither is part of a rename already.
+func renameTarget(from string, deleted, created []string, old, next map[string]iofs.FileInfo, renamed map[string]bool) (string, bool) {
+	var target string
+	for _, name := range created {
+		if renamed[name] || !looksRenamed(old[from], next[name]) {
+			continue
+		}
+		if target != "" {
+			// ambiguous
+			return "", false
+		}
+		target = name
+	}
+	if target == "" {
+		return "", false
+	}
+
+	for _, name := range deleted {
+		if name != from && !renamed[name] && looksRenamed(old[name], next[target]) {
+			// ambiguous
+			return "", false
+		}
+	}
+	return target, true
+}
+
+// looksRenamed reports whether the file with the attributes info could be
+// the one with before renamed, since renames keep the attributes.
+func looksRenamed(before, info iofs.FileInfo) bool {
+	if before.Mode() != info.Mode() || !before.ModTime().Equal(info.ModTime()) {
+		return false
+	}
+	return info.IsDir() || before.Size() == info.Size()
+}
+
+// underRenamed reports whether name is below one of the renamed directories,
+// by their old paths if side is 0, their new ones if it is 1.
+func underRenamed(name string, renamedDirs [][2]string, side int) bool {
+	for _, dirs := range renamedDirs {
+		if strings.HasPrefix(name, strings.TrimSuffix(dirs[side], "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
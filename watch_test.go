@@ -0,0 +1,132 @@
+package sftp
+
+import (
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func watchInfo(name string, mode uint32, size uint64, mtime uint32) iofs.FileInfo {
+	return fileInfoFromStat(&FileStat{Mode: mode, Size: size, Mtime: mtime}, name)
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	const file, dir = 0o100644, 0o40755
+	old := map[string]iofs.FileInfo{
+		"/w":            watchInfo("w", dir, 0, 1),
+		"/w/gone":       watchInfo("gone", file, 1, 1),
+		"/w/moved":      watchInfo("moved", file, 2, 2),
+		"/w/same":       watchInfo("same", file, 3, 3),
+		"/w/grown":      watchInfo("grown", file, 4, 4),
+		"/w/olddir":     watchInfo("olddir", dir, 0, 5),
+		"/w/olddir/a":   watchInfo("a", file, 6, 6),
+		"/w/twin1":      watchInfo("twin1", file, 7, 7),
+		"/w/twin2":      watchInfo("twin2", file, 7, 7),
+		"/w/changedmod": watchInfo("changedmod", file, 8, 8),
+	}
+	next := map[string]iofs.FileInfo{
+		"/w":            watchInfo("w", dir, 0, 9),
+		"/w/new":        watchInfo("new", file, 1, 9),
+		"/w/renamed":    watchInfo("renamed", file, 2, 2),
+		"/w/same":       watchInfo("same", file, 3, 3),
+		"/w/grown":      watchInfo("grown", file, 5, 9),
+		"/w/newdir":     watchInfo("newdir", dir, 0, 5),
+		"/w/newdir/a":   watchInfo("a", file, 6, 6),
+		"/w/twin3":      watchInfo("twin3", file, 7, 7),
+		"/w/changedmod": watchInfo("changedmod", 0o100600, 8, 8),
+	}
+
+	var got []string
+	for _, ev := range diffSnapshots(old, next) {
+		got = append(got, ev.String())
+	}
+	assert.Equal(t, []string{
+		"rename /w/olddir -> /w/newdir",
+		"rename /w/moved -> /w/renamed",
+		"delete /w/twin2", // which of the twins moved is ambiguous
+		"delete /w/twin1",
+		"delete /w/gone",
+		"create /w/new",
+		"create /w/twin3",
+		"modify /w/changedmod",
+		"modify /w/grown",
+	}, got)
+}
+
+func nextEvent(t *testing.T, w *Watcher) WatchEvent {
+	t.Helper()
+	select {
+	case ev := <-w.Events:
+		return ev
+	case err := <-w.Errors:
+		t.Fatalf("watch error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return WatchEvent{}
+}
+
+func TestClientWatch(t *testing.T) {
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := filepath.Join(t.TempDir(), "watched")
+	w, err := client.Watch(dir, 10*time.Millisecond, WatchRecursive())
+	require.NoError(t, err)
+	defer w.Close()
+
+	require.NoError(t, os.Mkdir(dir, 0o755))
+	ev := nextEvent(t, w)
+	assert.Equal(t, WatchEvent{Op: WatchCreate, Path: dir}, WatchEvent{Op: ev.Op, Path: ev.Path})
+	assert.True(t, ev.Info.IsDir())
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0o755))
+	ev = nextEvent(t, w)
+	assert.Equal(t, "create "+filepath.Join(dir, "sub"), ev.String())
+
+	name := filepath.Join(dir, "sub", "file")
+	require.NoError(t, os.WriteFile(name, []byte("x"), 0o644))
+	assert.Equal(t, "create "+name, nextEvent(t, w).String())
+
+	require.NoError(t, os.WriteFile(name, []byte("xyz"), 0o644))
+	ev = nextEvent(t, w)
+	assert.Equal(t, "modify "+name, ev.String())
+	assert.Equal(t, int64(3), ev.Info.Size())
+
+	renamed := filepath.Join(dir, "renamed")
+	require.NoError(t, os.Rename(name, renamed))
+	assert.Equal(t, "rename "+name+" -> "+renamed, nextEvent(t, w).String())
+
+	require.NoError(t, os.Remove(renamed))
+	ev = nextEvent(t, w)
+	assert.Equal(t, "delete "+renamed, ev.String())
+	assert.Equal(t, int64(3), ev.Info.Size(), "the attributes before")
+
+	require.NoError(t, w.Close())
+	_, ok := <-w.Events
+	assert.False(t, ok, "closed")
+	require.NoError(t, w.Close())
+}
+
+func TestClientWatchClientClosed(t *testing.T) {
+	client, server := clientServerPair(t)
+
+	w, err := client.Watch(t.TempDir(), time.Millisecond)
+	require.NoError(t, err)
+	server.Close()
+	client.Close()
+	select {
+	case <-w.Events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watcher goes on")
+	}
+
+	_, err = client.Watch("/", 0)
+	assert.Error(t, err)
+}
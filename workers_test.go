@@ -0,0 +1,54 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerMaxWorkers(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "slow")
+	require.NoError(t, os.WriteFile(name, make([]byte, 10*1024), 0o644))
+
+	backend := &hangingFs{FullFs: apis.NewAVFS(), name: name, hang: make(chan struct{})}
+	client, server := clientServerPairFS(t, backend, []ServerOption{WithMaxWorkers(2)})
+	defer client.Close()
+	defer server.Close()
+
+	f, err := client.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	reads := func() uint64 {
+		return server.FeatureUsage().Packets["SSH_FXP_READ"]
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(off int64) {
+			defer wg.Done()
+			_, err := f.ReadAt(make([]byte, 1024), off)
+			assert.NoError(t, err)
+		}(int64(i) * 1024)
+	}
+
+	// two are served, two wait, the rest are not read off the connection
+	assert.Eventually(t, func() bool { return reads() == 4 }, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, uint64(4), reads())
+
+	close(backend.hang)
+	wg.Wait()
+	assert.Equal(t, uint64(10), reads())
+
+	_, err = NewServer(nil, backend, WithMaxWorkers(0))
+	assert.Error(t, err)
+}
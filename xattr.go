@@ -0,0 +1,105 @@
+package sftp
+
+import (
+	"strings"
+
+	"github.com/pkg/sftp/apis"
+)
+
+// xattrSuffix ends the type of the extended attributes which carry
+// the extended file attributes (xattrs) of a file, see XattrExtended.
+const xattrSuffix = "@xattr.github.com/pkg/sftp"
+
+// XattrExtended returns the extended attribute carrying the extended file
+// attribute name with value, for Client.SetExtended. The Server of this
+// package sets those, and reports them on Stat, Lstat and File.Stat, when
+// its file system implements apis.Xattrer, as the host's does on Linux.
+func XattrExtended(name string, value []byte) StatExtended {
+	return StatExtended{
+		ExtType: name + xattrSuffix,
+		ExtData: string(value),
+	}
+}
+
+// Xattrs returns the extended file attributes among the extended
+// attributes of fs, see XattrExtended, nil if there are none.
+func (fs *FileStat) Xattrs() map[string][]byte {
+	var xattrs map[string][]byte
+	for _, ext := range fs.Extended {
+		if name := strings.TrimSuffix(ext.ExtType, xattrSuffix); name != ext.ExtType {
+			if xattrs == nil {
+				xattrs = make(map[string][]byte)
+			}
+			xattrs[name] = []byte(ext.ExtData)
+		}
+	}
+	return xattrs
+}
+
+// extendedAttrs are the attributes set by SetExtended.
+type extendedAttrs struct {
+	Count    uint32
+	Extended []StatExtended
+}
+
+// SetExtended sends the extended attributes ext, the type and data pairs
+// which the protocol allows besides the standard attributes, in a setstat
+// request for the named file. What they mean is up to the server; those made
+// with XattrExtended set extended file attributes.
+func (c *Client) SetExtended(path string, ext []StatExtended) (err error) {
+	defer pathError(&err, "setextended", path)
+
+	return c.setstat(path, sshFileXferAttrExtended, extendedAttrs{uint32(len(ext)), ext})
+}
+
+// SetExtended sends the extended attributes ext for the current file.
+//
+// See Client.SetExtended for details.
+func (f *File) SetExtended(ext []StatExtended) (err error) {
+	defer pathError(&err, "setextended", f.path)
+	if err = f.checkClosed(); err != nil {
+		return err
+	}
+
+	return f.c.setfstat(f.handle, sshFileXferAttrExtended, extendedAttrs{uint32(len(ext)), ext})
+}
+
+// xattrs returns the extended file attributes of name as extended
+// attributes, if it could be stat'ed without err. The file system may not
+// have any, which is no reason to fail the stat, so neither are its errors.
+func (svr *Server) xattrs(name string, err error) []StatExtended {
+	x, ok := svr.fs.(apis.Xattrer)
+	if err != nil || !ok {
+		return nil
+	}
+	attrs, err := x.Listxattr(name)
+	if err != nil {
+		return nil
+	}
+
+	var ext []StatExtended
+	for _, attr := range attrs {
+		value, err := x.Getxattr(name, attr)
+		if err != nil {
+			// removed since listing it
+			continue
+		}
+		ext = append(ext, XattrExtended(attr, value))
+	}
+	return ext
+}
+
+// setxattrs sets the extended file attributes among the extended
+// attributes b of a setstat request, ignoring the others.
+func (svr *Server) setxattrs(name string, b []byte) error {
+	attrs, _, err := unmarshalFileStatSafe(sshFileXferAttrExtended, b)
+	if err != nil {
+		return err
+	}
+	for attr, value := range attrs.Xattrs() {
+		if err := apis.Setxattr(svr.fs, name, attr, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package sftp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/pkg/sftp/apis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// xattrFs keeps the extended attributes of the files in memory.
+type xattrFs struct {
+	apis.FullFs
+	mu     sync.Mutex
+	xattrs map[string]map[string][]byte
+}
+
+func (fsys *xattrFs) Listxattr(name string) ([]string, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	var attrs []string
+	for attr := range fsys.xattrs[name] {
+		attrs = append(attrs, attr)
+	}
+	return attrs, nil
+}
+
+func (fsys *xattrFs) Getxattr(name, attr string) ([]byte, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	value, ok := fsys.xattrs[name][attr]
+	if !ok {
+		return nil, errors.New("no such attribute")
+	}
+	return value, nil
+}
+
+func (fsys *xattrFs) Setxattr(name, attr string, data []byte) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	if fsys.xattrs[name] == nil {
+		fsys.xattrs[name] = make(map[string][]byte)
+	}
+	fsys.xattrs[name][attr] = data
+	return nil
+}
+
+func statXattrs(t *testing.T, fi os.FileInfo) map[string][]byte {
+	t.Helper()
+	stat, ok := fi.Sys().(*FileStat)
+	require.True(t, ok)
+	return stat.Xattrs()
+}
+
+func TestClientSetExtended(t *testing.T) {
+	fsys := &xattrFs{FullFs: apis.NewAVFS(), xattrs: map[string]map[string][]byte{}}
+	client := fsClient(t, fsys)
+
+	name := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(name, nil, 0o644))
+
+	fi, err := client.Stat(name)
+	require.NoError(t, err)
+	assert.Nil(t, statXattrs(t, fi))
+
+	// other extended attributes are ignored
+	require.NoError(t, client.SetExtended(name, []StatExtended{
+		XattrExtended("mime_type", []byte("text/plain")),
+		{ExtType: "other@example.com", ExtData: "x"},
+	}))
+	assert.Equal(t, map[string]map[string][]byte{name: {"mime_type": []byte("text/plain")}}, fsys.xattrs)
+
+	f, err := client.OpenFile(name, os.O_RDWR)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, f.SetExtended([]StatExtended{XattrExtended("origin", []byte{0, 1})}))
+
+	want := map[string][]byte{"mime_type": []byte("text/plain"), "origin": {0, 1}}
+	fi, err = client.StatNoCache(name)
+	require.NoError(t, err)
+	assert.Equal(t, want, statXattrs(t, fi))
+	fi, err = f.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, want, statXattrs(t, fi))
+}
+
+func TestClientSetExtendedUnsupported(t *testing.T) {
+	client := fsClient(t, apis.NewAVFS())
+
+	name := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(name, nil, 0o644))
+
+	err := client.SetExtended(name, []StatExtended{XattrExtended("a", nil)})
+	assert.True(t, errors.Is(err, ErrOpUnsupported), "got %v", err)
+	assert.NoError(t, client.SetExtended(name, nil))
+}
+
+func TestServerXattrsOS(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(name, nil, 0o644))
+	if err := apis.NewOS().Setxattr(name, "probe", nil); err != nil {
+		t.Skipf("no user xattrs here: %v", err)
+	}
+
+	client := fsClient(t, apis.NewOS())
+	require.NoError(t, client.SetExtended(name, []StatExtended{XattrExtended("comment", []byte("hi"))}))
+
+	value, err := apis.NewOS().Getxattr(name, "comment")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hi"), value)
+
+	fi, err := client.StatNoCache(name)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"comment": []byte("hi"), "probe": {}}, statXattrs(t, fi))
+}
@@ -0,0 +1,105 @@
+package sftp
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"path"
+	"strings"
+)
+
+// ZipTo writes the remote files or directory trees roots to w as a zip
+// archive, as a web application offering to download a folder would. The
+// archive is built as it is written: every file is compressed while it is
+// streamed from the server with File.WriteTo, so the memory used does not
+// grow with the size of the files, and w needs no seeking.
+//
+// Every root is archived under its base name, which must differ between the
+// roots. Symbolic links are followed as with WalkFollowSymlinks, and links
+// which cannot be followed are skipped, like sockets and other files which
+// are neither directories nor regular files.
+func (c *Client) ZipTo(w io.Writer, roots ...string) (err error) {
+	seen := make(map[string]string)
+	for _, root := range roots {
+		base := zipBase(root)
+		if other, ok := seen[base]; ok {
+			return fmt.Errorf("sftp: %s and %s would both be zipped as %q", other, root, base)
+		}
+		seen[base] = root
+	}
+
+	zw := zip.NewWriter(w)
+	for _, root := range roots {
+		if err := c.zipTree(zw, root); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// zipBase returns the name root is archived under by ZipTo, empty for the
+// root directory of the server.
+func zipBase(root string) string {
+	base := path.Base(root)
+	if base == "/" || base == "." {
+		return ""
+	}
+	return base
+}
+
+// zipTree writes the file or directory tree at root to zw.
+func (c *Client) zipTree(zw *zip.Writer, root string) (err error) {
+	defer pathError(&err, "zip", root)
+
+	base := zipBase(root)
+	return c.WalkDir(root, func(name string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(path.Clean(name), path.Clean(root)), "/")
+		rel = strings.TrimPrefix(path.Join(base, rel), "/")
+		if rel == "" || rel == "." {
+			// the root directory of the server itself
+			return nil
+		}
+		return c.zipFile(zw, name, rel, info)
+	}, WalkFollowSymlinks())
+}
+
+// zipFile writes the remote file name, with the attributes info, to zw
+// under the name rel.
+func (c *Client) zipFile(zw *zip.Writer, name, rel string, info iofs.FileInfo) error {
+	fh, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	fh.Name = rel
+	if info.IsDir() {
+		fh.Name += "/"
+		_, err := zw.CreateHeader(fh)
+		return err
+	}
+	fh.Method = zip.Deflate
+
+	entry, err := zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	f, err := c.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteTo(entry)
+	return err
+}
@@ -0,0 +1,65 @@
+package sftp
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientZipTo(t *testing.T) {
+	skipIfWindows(t)
+	client, server := clientServerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	mtime := time.Unix(1500000000, 0)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "folder", "sub"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "folder", "big"), content, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "folder", "sub", "small"), []byte("foo"), 0o644))
+	require.NoError(t, os.Symlink("sub/small", filepath.Join(dir, "folder", "link")))
+	require.NoError(t, os.Symlink("missing", filepath.Join(dir, "folder", "dangling")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "single"), []byte("bar"), 0o644))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "folder", "big"), mtime, mtime))
+
+	var buf bytes.Buffer
+	require.NoError(t, client.ZipTo(&buf, filepath.Join(dir, "folder"), filepath.Join(dir, "single")))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	files := make(map[string]*zip.File)
+	var names []string
+	for _, f := range zr.File {
+		files[f.Name] = f
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"folder/", "folder/big", "folder/link", "folder/sub/", "folder/sub/small", "single"}, names)
+
+	read := func(name string) []byte {
+		rc, err := files[name].Open()
+		require.NoError(t, err)
+		defer rc.Close()
+		b, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		return b
+	}
+	assert.True(t, bytes.Equal(content, read("folder/big")))
+	assert.True(t, mtime.Equal(files["folder/big"].Modified), "got %v", files["folder/big"].Modified)
+	assert.Equal(t, []byte("foo"), read("folder/link"))
+	assert.Equal(t, []byte("bar"), read("single"))
+
+	err = client.ZipTo(io.Discard, filepath.Join(dir, "single"), filepath.Join(dir, "folder", "..", "single"))
+	assert.Error(t, err, "the same base name twice")
+	err = client.ZipTo(io.Discard, filepath.Join(dir, "missing"))
+	assert.True(t, os.IsNotExist(err))
+}